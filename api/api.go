@@ -0,0 +1,235 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package api contains a JSON REST API for reading and updating the code reviews in a repo.
+//
+// All of the endpoints are served under the "/api/v1/" prefix:
+//
+//	GET  /api/v1/reviews                   lists the open, published reviews (add "?all=1" for every review, including drafts)
+//	GET  /api/v1/reviews/<hash>            returns the details of a single review
+//	GET  /api/v1/reviews/<hash>/comments   returns the comment threads of a review
+//	POST /api/v1/reviews/<hash>/comments   adds a comment to a review
+//	GET  /api/v1/reviews/<hash>/ci         returns the CI reports for the head of a review
+//	POST /api/v1/reviews/<hash>/accept     accepts a review
+//	POST /api/v1/reviews/<hash>/reject     rejects a review
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Version is the version of the API, which is included in the path of every endpoint.
+const Version = "v1"
+
+// PathPrefix is the path under which all of the API endpoints are served.
+const PathPrefix = "/api/" + Version + "/"
+
+const reviewsPath = PathPrefix + "reviews"
+
+// Server serves the REST API for a single repo.
+type Server struct {
+	repo repository.Repo
+	// writeMutex serializes all of the requests that write to the repo's notes.
+	writeMutex sync.Mutex
+}
+
+// CommentRequest is the body of a request to add a comment to a review.
+type CommentRequest struct {
+	Description string            `json:"description"`
+	Parent      string            `json:"parent,omitempty"`
+	Location    *comment.Location `json:"location,omitempty"`
+	Resolved    *bool             `json:"resolved,omitempty"`
+}
+
+// CommentResponse is the body of the response to a request that added a comment.
+type CommentResponse struct {
+	Hash    string          `json:"hash"`
+	Comment comment.Comment `json:"comment"`
+}
+
+// errorResponse is the body of every response to a failed request.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// New returns a new Server for the given repo.
+func New(repo repository.Repo) *Server {
+	return &Server{repo: repo}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	bytes, err := json.Marshal(body)
+	if err != nil {
+		status = http.StatusInternalServerError
+		bytes, _ = json.Marshal(errorResponse{Error: err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+	w.Write([]byte("\n"))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	if path == reviewsPath {
+		if req.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("Only GET is supported for the list of reviews."))
+			return
+		}
+		s.listReviews(w, req)
+		return
+	}
+	if !strings.HasPrefix(path, reviewsPath+"/") {
+		writeError(w, http.StatusNotFound, errors.New("Unknown API endpoint."))
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, reviewsPath+"/"), "/", 2)
+	r, err := review.Get(s.repo, parts[0])
+	if err != nil {
+		status := http.StatusInternalServerError
+		if review.IsNotFound(err) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	if r == nil {
+		writeError(w, http.StatusNotFound, review.ErrReviewNotFound)
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, r)
+	case action == "comments" && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, r.Comments)
+	case action == "comments" && req.Method == http.MethodPost:
+		s.postComment(w, req, r)
+	case action == "ci" && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, r.Reports)
+	case action == "accept" && req.Method == http.MethodPost:
+		s.postVote(w, req, r, true)
+	case action == "reject" && req.Method == http.MethodPost:
+		s.postVote(w, req, r, false)
+	case action == "" || action == "comments" || action == "ci" || action == "accept" || action == "reject":
+		writeError(w, http.StatusMethodNotAllowed, errors.New("The method is not supported for this endpoint."))
+	default:
+		writeError(w, http.StatusNotFound, errors.New("Unknown API endpoint."))
+	}
+}
+
+// listReviews responds with the summaries of the open, published reviews, or of all reviews if "all" is set.
+func (s *Server) listReviews(w http.ResponseWriter, req *http.Request) {
+	var reviews []review.Summary
+	if req.URL.Query().Get("all") != "" {
+		reviews = review.ListAll(s.repo)
+	} else {
+		reviews = review.FilterDrafts(review.ListOpen(s.repo), "")
+	}
+	if reviews == nil {
+		reviews = []review.Summary{}
+	}
+	writeJSON(w, http.StatusOK, reviews)
+}
+
+// addComment fills in the author, timestamp, and default location of the given comment, and adds it to the review.
+func (s *Server) addComment(w http.ResponseWriter, r *review.Review, c comment.Comment) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	userEmail, err := s.repo.GetUserEmail()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	c.Author = userEmail
+	if c.Location == nil || c.Location.Commit == "" {
+		headCommit, err := r.GetHeadCommit()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if c.Location == nil {
+			c.Location = &comment.Location{}
+		}
+		c.Location.Commit = headCommit
+	}
+	if err := r.AddComment(c); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	hash, err := c.Hash()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, CommentResponse{Hash: hash, Comment: c})
+}
+
+// postComment adds the comment described in the request body to the review.
+func (s *Server) postComment(w http.ResponseWriter, req *http.Request, r *review.Review) {
+	var body CommentRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Description == "" {
+		writeError(w, http.StatusBadRequest, errors.New("The comment description is required."))
+		return
+	}
+	if body.Parent != "" && r.FindComment(body.Parent) == nil {
+		writeError(w, http.StatusBadRequest, errors.New("There is no matching parent comment."))
+		return
+	}
+	c := comment.New("", body.Description)
+	c.Parent = body.Parent
+	c.Location = body.Location
+	c.Resolved = body.Resolved
+	s.addComment(w, r, c)
+}
+
+// postVote accepts or rejects the review, with an optional message taken from the request body.
+func (s *Server) postVote(w http.ResponseWriter, req *http.Request, r *review.Review, accepted bool) {
+	var body CommentRequest
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if r.IsAbandoned() {
+		writeError(w, http.StatusConflict, errors.New("The review was abandoned."))
+		return
+	}
+	c := comment.New("", body.Description)
+	c.Resolved = &accepted
+	s.addComment(w, r, c)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(t *testing.T, s *Server, method, path, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestListReviews(t *testing.T) {
+	s := New(repository.NewMockRepoForTest())
+	w := serve(t, s, "GET", "/api/v1/reviews", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body.String())
+	}
+	var reviews []review.Summary
+	if err := json.Unmarshal(w.Body.Bytes(), &reviews); err != nil {
+		t.Fatal(err)
+	}
+	if len(reviews) == 0 || reviews[0].Revision != repository.TestCommitG {
+		t.Fatalf("Unexpected reviews: %v", reviews)
+	}
+}
+
+func TestGetReview(t *testing.T) {
+	s := New(repository.NewMockRepoForTest())
+	w := serve(t, s, "GET", "/api/v1/reviews/"+repository.TestCommitG, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body.String())
+	}
+	var r review.Review
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Request.Description != "Final description of G" {
+		t.Fatalf("Unexpected review: %v", r)
+	}
+	if w := serve(t, s, "GET", "/api/v1/reviews/"+repository.TestCommitA, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("Unexpected status code for a missing review: %d", w.Code)
+	}
+	if w := serve(t, s, "DELETE", "/api/v1/reviews/"+repository.TestCommitG, ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Unexpected status code for an unsupported method: %d", w.Code)
+	}
+}
+
+func TestPostCommentAndAccept(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	s := New(repo)
+	w := serve(t, s, "POST", "/api/v1/reviews/"+repository.TestCommitB+"/comments", `{"description": "Looks reasonable"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body.String())
+	}
+	var response CommentResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Comment.Author != "user@example.com" || response.Comment.Location == nil {
+		t.Fatalf("Unexpected comment: %v", response.Comment)
+	}
+
+	if w := serve(t, s, "POST", "/api/v1/reviews/"+repository.TestCommitB+"/comments", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Unexpected status code for an empty comment: %d", w.Code)
+	}
+
+	if w := serve(t, s, "POST", "/api/v1/reviews/"+repository.TestCommitB+"/accept", ""); w.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body.String())
+	}
+	r, err := review.Get(repo, repository.TestCommitB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, thread := range r.Comments {
+		if thread.Comment.Resolved != nil && *thread.Comment.Resolved {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("The acceptance was not recorded: %v", r.Comments)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/promet/git-appraise/commands/input"
+	"github.com/promet/git-appraise/commands/output"
 	"github.com/promet/git-appraise/repository"
 	"github.com/promet/git-appraise/review"
 	"github.com/promet/git-appraise/review/comment"
@@ -46,16 +47,16 @@ func abandonReview(repo repository.Repo, args []string) error {
 	}
 
 	if len(args) == 1 {
-		r, err = review.Get(repo, args[0])
+		r, err = getReview(repo, args[0])
 	} else {
 		r, err = review.GetCurrent(repo)
 	}
 
 	if err != nil {
-		return fmt.Errorf("Failed to load the review: %v\n", err)
+		return fmt.Errorf("Failed to load the review: %w\n", err)
 	}
 	if r == nil {
-		return errors.New("There is no matching review.")
+		return review.ErrReviewNotFound
 	}
 
 	if *abandonMessageFile != "" && *abandonMessage == "" {
@@ -101,7 +102,13 @@ func abandonReview(repo repository.Repo, args []string) error {
 		return err
 	}
 
-	return repo.AppendNote(request.Ref, r.Revision, note)
+	if err := repo.AppendNote(request.Ref, r.Revision, note); err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("abandon", r.Request)
+	}
+	return nil
 }
 
 // abandonCmd defines the "abandon" subcommand.
@@ -24,6 +24,7 @@ import (
 	"github.com/promet/git-appraise/repository"
 	"github.com/promet/git-appraise/review"
 	"github.com/promet/git-appraise/review/comment"
+	"strings"
 )
 
 var acceptFlagSet = flag.NewFlagSet("accept", flag.ExitOnError)
@@ -31,6 +32,8 @@ var acceptFlagSet = flag.NewFlagSet("accept", flag.ExitOnError)
 var (
 	acceptMessageFile = acceptFlagSet.String("F", "", "Take the comment from the given file. Use - to read the message from the standard input")
 	acceptMessage     = acceptFlagSet.String("m", "", "Message to attach to the review")
+	acceptSign        = acceptFlagSet.Bool("sign", false, "Sign the approval using the GPG key configured as user.signingkey")
+	acceptLevel       = acceptFlagSet.String("level", comment.LevelApproved, "Condition level of the approval; one of approved, approved-with-nits, or needs-changes")
 )
 
 // acceptReview adds an LGTM comment to the current code review.
@@ -43,18 +46,21 @@ func acceptReview(repo repository.Repo, args []string) error {
 	if len(args) > 1 {
 		return errors.New("Only accepting a single review is supported.")
 	}
+	if !comment.IsValidLevel(*acceptLevel) {
+		return fmt.Errorf("Unknown approval level %q; expected one of: %s.", *acceptLevel, strings.Join(comment.Levels, ", "))
+	}
 
 	if len(args) == 1 {
-		r, err = review.Get(repo, args[0])
+		r, err = getReview(repo, args[0])
 	} else {
 		r, err = review.GetCurrent(repo)
 	}
 
 	if err != nil {
-		return fmt.Errorf("Failed to load the review: %v\n", err)
+		return fmt.Errorf("Failed to load the review: %w\n", err)
 	}
 	if r == nil {
-		return errors.New("There is no matching review.")
+		return review.ErrReviewNotFound
 	}
 
 	acceptedCommit, err := r.GetHeadCommit()
@@ -64,8 +70,8 @@ func acceptReview(repo repository.Repo, args []string) error {
 	location := comment.Location{
 		Commit: acceptedCommit,
 	}
-	resolved := true
-	userEmail, err := repo.GetUserEmail()
+	resolved := comment.ResolvedForLevel(*acceptLevel)
+	userEmail, err := commentAuthor(repo, r, *acceptSign)
 	if err != nil {
 		return err
 	}
@@ -80,7 +86,15 @@ func acceptReview(repo repository.Repo, args []string) error {
 	c := comment.New(userEmail, *acceptMessage)
 	c.Location = &location
 	c.Resolved = &resolved
-	return r.AddComment(c)
+	if *acceptLevel != comment.LevelApproved {
+		c.Level = *acceptLevel
+	}
+	if *acceptSign {
+		if err := signMetadata(repo, &c); err != nil {
+			return err
+		}
+	}
+	return addComment("accept", r, c)
 }
 
 // acceptCmd defines the "accept" subcommand.
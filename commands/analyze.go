@@ -0,0 +1,235 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/analyzer"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/policy"
+	"github.com/promet/git-appraise/review/robot"
+	"io/ioutil"
+	"strings"
+)
+
+var analyzeFlagSet = flag.NewFlagSet("analyze", flag.ExitOnError)
+
+var (
+	analyzeConfig   = analyzeFlagSet.String("config", "", "Local file to read the analyzers from, instead of "+analyzer.ConfigFile+" in the target ref")
+	analyzeOnly     = analyzeFlagSet.String("only", "", "Comma-separated list of the names of the analyzers to run; defaults to all of them")
+	analyzeAllFiles = analyzeFlagSet.Bool("all-files", false, "Keep findings about files that are not changed by the review")
+	analyzeComment  = analyzeFlagSet.Bool("comment", true, "Post a comment summarizing the results of the analyzers")
+)
+
+// analyzeResult is the JSON output for a single analyzer run by the "analyze" subcommand.
+type analyzeResult struct {
+	Analyzer string `json:"analyzer"`
+	Findings int    `json:"findings"`
+	Errors   int    `json:"errors,omitempty"`
+	Skipped  bool   `json:"skipped,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// String returns a one-line summary of the result.
+func (result analyzeResult) String() string {
+	switch {
+	case result.Error != "":
+		return fmt.Sprintf("%s: failed: %s", result.Analyzer, result.Error)
+	case result.Skipped:
+		return fmt.Sprintf("%s: skipped, as none of the changed files apply", result.Analyzer)
+	case result.Findings == 0:
+		return fmt.Sprintf("%s: no findings", result.Analyzer)
+	}
+	return fmt.Sprintf("%s: %d finding(s), %d error(s)", result.Analyzer, result.Findings, result.Errors)
+}
+
+// loadAnalyzers reads the analyzers to run against the given review.
+func loadAnalyzers(repo repository.Repo, r *review.Review) ([]analyzer.Analyzer, error) {
+	var config *analyzer.Config
+	if *analyzeConfig != "" {
+		contents, err := ioutil.ReadFile(*analyzeConfig)
+		if err != nil {
+			return nil, err
+		}
+		if config, err = analyzer.Parse(contents); err != nil {
+			return nil, fmt.Errorf("Failed to parse %q: %v", *analyzeConfig, err)
+		}
+	} else {
+		target, err := repo.ResolveRefCommit(r.Request.TargetRef)
+		if err != nil {
+			return nil, err
+		}
+		if config, err = analyzer.Load(repo, target); err != nil {
+			return nil, err
+		}
+	}
+	if config == nil || len(config.Analyzers) == 0 {
+		return nil, fmt.Errorf("There are no analyzers configured in %s.", analyzer.ConfigFile)
+	}
+	only := splitValues(*analyzeOnly)
+	var analyzers []analyzer.Analyzer
+	for _, a := range config.Analyzers {
+		if len(only) == 0 || containsValue(only, a.Name) {
+			analyzers = append(analyzers, a)
+		}
+	}
+	if len(analyzers) == 0 {
+		return nil, errors.New("None of the configured analyzers match the --only flag.")
+	}
+	return analyzers, nil
+}
+
+// runAnalyzer runs a single analyzer against the review, and stages its findings as robot comments in the given writer.
+func runAnalyzer(repo repository.Repo, writer *repository.NoteWriter, r *review.Review, a analyzer.Analyzer, head string, changed []string) analyzeResult {
+	result := analyzeResult{Analyzer: a.Name}
+	if len(a.Files) > 0 {
+		result.Skipped = true
+		for _, file := range changed {
+			result.Skipped = result.Skipped && !a.Matches(file)
+		}
+		if result.Skipped {
+			return result
+		}
+	}
+	findings, err := a.Run(repo.GetPath(), changed)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if !*analyzeAllFiles {
+		findings = analyzer.FilterFindings(findings, changed)
+	}
+	note, err := robot.NewRun(a.Name, head, findings).Write()
+	if err == nil {
+		err = writer.Append(r.Revision, note)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Findings = len(findings)
+	for _, finding := range findings {
+		if finding.Severity == robot.SeverityError {
+			result.Errors++
+		}
+	}
+	return result
+}
+
+// analyzeReview runs the configured analyzers against the current review.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func analyzeReview(repo repository.Repo, args []string) error {
+	analyzeFlagSet.Parse(args)
+	args = analyzeFlagSet.Args()
+
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return errors.New("Only analyzing a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+	if !r.IsOpen() {
+		return errors.New("Only open reviews can be analyzed.")
+	}
+
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	checkedOut, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		return err
+	}
+	if checkedOut != head {
+		return fmt.Errorf("The head of the review (%.12s) must be checked out to analyze it.", head)
+	}
+	analyzers, err := loadAnalyzers(repo, r)
+	if err != nil {
+		return err
+	}
+	changed, err := policy.ChangedPaths(r)
+	if err != nil {
+		return err
+	}
+
+	var results []analyzeResult
+	var summary []string
+	failed := 0
+	// The findings of all of the analyzers are written with a single update of the robot comments.
+	writer := repository.NewNoteWriter(repo, robot.Ref, 0)
+	for _, a := range analyzers {
+		result := runAnalyzer(repo, writer, r, a, head, changed)
+		if result.Error != "" {
+			failed++
+		}
+		results = append(results, result)
+		summary = append(summary, "  "+result.String())
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("Failed to record the findings of the analyzers: %v", err)
+	}
+	if *analyzeComment && failed < len(results) {
+		userEmail, err := repo.GetUserEmail()
+		if err != nil {
+			return err
+		}
+		c := comment.New(userEmail, fmt.Sprintf("Analyzers run against %.12s:\n%s", head, strings.Join(summary, "\n")))
+		c.Location = &comment.Location{Commit: head}
+		if err := r.AddComment(c); err != nil {
+			return err
+		}
+	}
+	if JSONOutput {
+		if err := output.PrintJSONResult("analyze", results); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println(strings.Join(summary, "\n"))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of the analyzers failed.", failed)
+	}
+	return nil
+}
+
+// analyzeCmd defines the "analyze" subcommand.
+var analyzeCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s analyze [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		analyzeFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return analyzeReview(repo, args)
+	},
+	NeedsWorktree: true,
+}
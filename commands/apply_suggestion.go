@@ -0,0 +1,130 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"strings"
+)
+
+var applySuggestionFlagSet = flag.NewFlagSet("apply-suggestion", flag.ExitOnError)
+
+var (
+	applySuggestionMessage = applySuggestionFlagSet.String("m", "", "Message for the commit that applies the suggestion; defaults to one generated from the comment")
+)
+
+// buildSuggestionCommitMessage generates the message for a commit that applies a suggested change.
+func buildSuggestionCommitMessage(author, description, commentHash string) string {
+	message := fmt.Sprintf("Apply the change suggested by %s", author)
+	if description = strings.TrimSpace(description); description != "" {
+		message = message + "\n\n" + description
+	}
+	return message + "\n\nSuggested-in: " + commentHash
+}
+
+// applySuggestion creates a commit on the review ref that applies the change suggested in a comment.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func applySuggestion(repo repository.Repo, args []string) error {
+	applySuggestionFlagSet.Parse(args)
+	args = applySuggestionFlagSet.Args()
+
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("Applying a suggestion requires a comment hash, and optionally a review hash.")
+	}
+	commentHash := args[0]
+
+	var r *review.Review
+	var err error
+	if len(args) == 2 {
+		r, err = getReview(repo, args[1])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+	if !r.IsOpen() {
+		return errors.New("Suggestions can only be applied to open reviews.")
+	}
+
+	identity, err := ageIdentityFile(repo)
+	if err != nil {
+		return err
+	}
+	r.DecryptComments(identity)
+	var author, description, suggestion string
+	if c := r.FindComment(commentHash); c != nil {
+		author, description, suggestion = c.Author, c.Description, c.Suggestion
+	} else if rc := r.FindRobotComment(commentHash); rc != nil {
+		author, description, suggestion = rc.Analyzer, rc.Description, rc.Fix
+		commentHash = rc.Hash
+	} else {
+		return errors.New("There is no matching comment.")
+	}
+	if suggestion == "" {
+		return errors.New("The comment does not include a suggested change.")
+	}
+
+	hasUncommitted, err := repo.HasUncommittedChanges()
+	if err != nil {
+		return err
+	}
+	if hasUncommitted {
+		return errors.New("You have uncommitted or untracked files. Commit or stash them before applying a suggestion.")
+	}
+
+	if err := repo.SwitchToRef(r.Request.ReviewRef); err != nil {
+		return err
+	}
+	if err := repo.ApplyPatch(suggestion); err != nil {
+		return err
+	}
+	message := *applySuggestionMessage
+	if message == "" {
+		message = buildSuggestionCommitMessage(author, description, commentHash)
+	}
+	commit, err := repo.Commit(message)
+	if err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("apply-suggestion", headResult{Review: r.Revision, Head: commit})
+	}
+	fmt.Printf("Applied the suggested change in commit %.12s\n", commit)
+	return nil
+}
+
+// applySuggestionCmd defines the "apply-suggestion" subcommand.
+var applySuggestionCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s apply-suggestion [<option>...] <comment-hash> [<review-hash>]\n\nOptions:\n", arg0)
+		applySuggestionFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return applySuggestion(repo, args)
+	},
+	NeedsWorktree: true,
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/archive"
+	"time"
+)
+
+var archiveFlagSet = flag.NewFlagSet("archive", flag.ExitOnError)
+
+var (
+	archiveBefore = archiveFlagSet.String("before", "", "Archive the closed reviews last updated before the given date (YYYY-MM-DD or RFC 3339), or duration ago (e.g. 36h or 90d).")
+	archiveDryRun = archiveFlagSet.Bool("dry-run", false, "List the reviews that would be archived, without archiving them.")
+)
+
+// selectReviewsToArchive splits the given reviews into those that are closed and
+// were last updated before the given time, and the set of the revisions, aliases,
+// and patchset commits of the rest.
+func selectReviewsToArchive(reviews []review.Summary, before time.Time) ([]review.Summary, map[string]bool) {
+	var archived []review.Summary
+	kept := make(map[string]bool)
+	for i := range reviews {
+		r := &reviews[i]
+		if !r.IsOpen() && lastUpdated(r) < before.Unix() {
+			archived = append(archived, *r)
+			continue
+		}
+		kept[r.Revision] = true
+		kept[r.Request.Alias] = true
+		for _, patchset := range r.Request.Patchsets {
+			kept[patchset.Commit] = true
+		}
+	}
+	return archived, kept
+}
+
+// archiveReviews moves the notes of old, closed reviews into the archive refs.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func archiveReviews(repo repository.Repo, args []string) error {
+	archiveFlagSet.Parse(args)
+	if archiveFlagSet.NArg() > 0 {
+		return errors.New("The archive command does not take any arguments.")
+	}
+	if *archiveBefore == "" {
+		return errors.New("The --before flag is required.")
+	}
+	before, err := parseSince(*archiveBefore, time.Now())
+	if err != nil {
+		return err
+	}
+
+	archived, kept := selectReviewsToArchive(review.ListAll(repo), before)
+	if !*archiveDryRun {
+		details, err := review.DetailsAll(repo, archived)
+		if err != nil {
+			return err
+		}
+		for _, r := range details {
+			// Leave the notes of commits that other reviews still rely upon in place.
+			var objects []string
+			for _, object := range archive.Objects(r) {
+				if !kept[object] {
+					objects = append(objects, object)
+				}
+			}
+			if err := archive.Archive(repo, objects); err != nil {
+				return fmt.Errorf("Failed to archive the review %q: %v", r.Revision, err)
+			}
+		}
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("archive", archived)
+	}
+	if *archiveDryRun {
+		fmt.Printf("Would archive %d reviews:\n", len(archived))
+	} else {
+		fmt.Printf("Archived %d reviews:\n", len(archived))
+	}
+	output.PrintStack(archived, nil)
+	return nil
+}
+
+// archiveCmd defines the "archive" subcommand.
+var archiveCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s archive -before <date> [<option>...]\n\nOptions:\n", arg0)
+		archiveFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return archiveReviews(repo, args)
+	},
+}
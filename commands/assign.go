@@ -0,0 +1,132 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/assign"
+	"github.com/promet/git-appraise/review/away"
+	"github.com/promet/git-appraise/review/request"
+	"strconv"
+	"time"
+)
+
+var assignFlagSet = flag.NewFlagSet("assign", flag.ExitOnError)
+
+var (
+	assignReviewers = assignFlagSet.String("r", "", "Comma-separated list of reviewers to assign, instead of picking them from the configured pool")
+	assignStrategy  = assignFlagSet.String("strategy", "", "Strategy used to pick reviewers from the pool (round-robin or load); defaults to the appraise.assign setting")
+	assignCount     = assignFlagSet.Int("n", 1, "Number of reviewers to pick from the pool")
+)
+
+// pickReviewers picks reviewers from the repository's pool, using the given
+// strategy (or, if that is empty, the one configured for the repository).
+//
+// The requester, the existing reviewers, and anyone who is away are never picked.
+func pickReviewers(repo repository.Repo, strategyName, requester string, existing []string, count int) ([]string, error) {
+	pool, err := repo.GetReviewerPool()
+	if err != nil {
+		return nil, err
+	}
+	if len(pool) == 0 {
+		return nil, errors.New("There are no reviewers configured to pick from. Add them to the appraise.reviewers setting.")
+	}
+	if strategyName == "" {
+		strategyName, err = repo.GetAssignStrategy()
+		if err != nil {
+			return nil, err
+		}
+	}
+	strategy, err := assign.GetStrategy(strategyName)
+	if err != nil {
+		return nil, err
+	}
+	exclude := append([]string{requester}, existing...)
+	exclude = append(exclude, awayUsers(away.Load(repo), time.Now())...)
+	reviewers := strategy.Assign(pool, review.ListAll(repo), exclude, count)
+	if len(reviewers) == 0 {
+		return nil, errors.New("There are no reviewers left in the pool to assign.")
+	}
+	return reviewers, nil
+}
+
+// assignReview adds reviewers to a review, and then prints the review's reviewers.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func assignReview(repo repository.Repo, args []string) error {
+	assignFlagSet.Parse(args)
+	args = assignFlagSet.Args()
+
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return errors.New("Only assigning a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+
+	added := rerouteReviewers(repo, splitLabels(*assignReviewers))
+	if len(added) == 0 {
+		added, err = pickReviewers(repo, *assignStrategy, r.Request.Requester, r.Request.Reviewers, *assignCount)
+		if err != nil {
+			return err
+		}
+	}
+	r.Request.Reviewers = updateLabels(r.Request.Reviewers, added, nil)
+	// The new request must sort after the current one, so it gets a fresh timestamp.
+	r.Request.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	r.Request.Signature = ""
+	note, err := r.Request.Write()
+	if err != nil {
+		return err
+	}
+	if err := repo.AppendNote(request.Ref, r.Revision, note); err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("assign", r.Request.Reviewers)
+	}
+	for _, reviewer := range r.Request.Reviewers {
+		fmt.Println(reviewer)
+	}
+	return nil
+}
+
+// assignCmd defines the "assign" subcommand.
+var assignCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s assign [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		assignFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return assignReview(repo, args)
+	},
+}
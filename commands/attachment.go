@@ -0,0 +1,168 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"io/ioutil"
+	"os"
+)
+
+var attachmentListFlagSet = flag.NewFlagSet("attachment list", flag.ExitOnError)
+
+var attachmentGetFlagSet = flag.NewFlagSet("attachment get", flag.ExitOnError)
+
+var (
+	attachmentGetOutput = attachmentGetFlagSet.String("o", "", "File to write the attachment to; defaults to standard output")
+)
+
+// attachmentResult is the JSON output of the "attachment list" subcommand.
+type attachmentResult struct {
+	Comment    string             `json:"comment"`
+	Attachment comment.Attachment `json:"attachment"`
+}
+
+// collectAttachments returns the attachments of every comment in the given threads.
+func collectAttachments(threads []review.CommentThread, results []attachmentResult) []attachmentResult {
+	for _, thread := range threads {
+		for _, attachment := range thread.Comment.Attachments {
+			results = append(results, attachmentResult{Comment: thread.Hash, Attachment: attachment})
+		}
+		results = collectAttachments(thread.Children, results)
+	}
+	return results
+}
+
+// readAttachments stores each of the given files in the repo as an attachment.
+func readAttachments(repo repository.Repo, files []string) ([]comment.Attachment, error) {
+	var attachments []comment.Attachment
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		attachment, err := comment.StoreAttachment(repo, file, contents)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// attachmentList lists the files attached to the comments on a review.
+func attachmentList(repo repository.Repo, args []string) error {
+	attachmentListFlagSet.Parse(args)
+	args = attachmentListFlagSet.Args()
+
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return errors.New("Only listing the attachments of a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+
+	results := collectAttachments(r.Comments, nil)
+	if JSONOutput {
+		if results == nil {
+			results = []attachmentResult{}
+		}
+		return output.PrintJSONResult("attachment", results)
+	}
+	for _, result := range results {
+		fmt.Printf("%.12s %s (%s, %d bytes) on comment %.12s\n", result.Attachment.Hash, result.Attachment.Name,
+			result.Attachment.ContentType, result.Attachment.Size, result.Comment)
+	}
+	return nil
+}
+
+// attachmentGet writes the contents of an attachment.
+func attachmentGet(repo repository.Repo, args []string) error {
+	attachmentGetFlagSet.Parse(args)
+	args = attachmentGetFlagSet.Args()
+	if len(args) != 1 {
+		return errors.New("Getting an attachment requires the hash of the attachment.")
+	}
+	contents, err := repo.ReadBlob(args[0])
+	if err != nil {
+		return err
+	}
+	if *attachmentGetOutput != "" {
+		return ioutil.WriteFile(*attachmentGetOutput, contents, 0644)
+	}
+	_, err = os.Stdout.Write(contents)
+	return err
+}
+
+// attachmentSubcommands defines the operations supported by the "attachment" subcommand.
+var attachmentSubcommands = map[string]mirrorSystem{
+	"get": {
+		Usage: "get [-o <file>] <attachment-hash>",
+		Flags: attachmentGetFlagSet,
+		Run:   attachmentGet,
+	},
+	"list": {
+		Usage: "list [<review-hash>]",
+		Flags: attachmentListFlagSet,
+		Run:   attachmentList,
+	},
+}
+
+// attachmentReviews dispatches to the attachment operation named by the first argument.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func attachmentReviews(repo repository.Repo, args []string) error {
+	if len(args) < 1 {
+		return errors.New("The attachment command requires either \"get\" or \"list\".")
+	}
+	subcommand, ok := attachmentSubcommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown attachment operation %q.", args[0])
+	}
+	return subcommand.Run(repo, args[1:])
+}
+
+// attachmentCmd defines the "attachment" subcommand.
+var attachmentCmd = &Command{
+	Usage: func(arg0 string) {
+		for _, name := range []string{"get", "list"} {
+			subcommand := attachmentSubcommands[name]
+			fmt.Printf("Usage: %s attachment %s\n\nOptions:\n", arg0, subcommand.Usage)
+			subcommand.Flags.PrintDefaults()
+			fmt.Println()
+		}
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return attachmentReviews(repo, args)
+	},
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/away"
+	"github.com/promet/git-appraise/review/schema"
+	"os"
+	"sort"
+	"time"
+)
+
+var awayFlagSet = flag.NewFlagSet("away", flag.ExitOnError)
+
+var (
+	awayUntil    = awayFlagSet.String("until", "", "When you are back: a date (YYYY-MM-DD or RFC 3339), or a duration from now (e.g. 36h or 7d); by default, you are away until you pass -back")
+	awayDelegate = awayFlagSet.String("delegate", "", "Who to reroute your review requests to while you are away, and who may approve the changes that you own in your place")
+	awayMessage  = awayFlagSet.String("m", "", "Message explaining your absence")
+	awayBack     = awayFlagSet.Bool("back", false, "Mark yourself as back")
+	awayJSON     = awayFlagSet.Bool("json", false, "Format the output as JSON")
+)
+
+// parseUntil parses the value of the "until" flag, relative to the given time.
+//
+// A date without a time means the start of that day, in the local time zone.
+func parseUntil(until string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", until, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, until); err == nil {
+		return t, nil
+	}
+	if d, err := parseDuration(until); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid time %q; expected a date (YYYY-MM-DD or RFC 3339) or a duration (e.g. 36h or 7d).", until)
+}
+
+// describeAway describes the status of someone who is away.
+func describeAway(status away.Status) string {
+	description := status.User + " is away"
+	if status.Until != "" {
+		if until, err := schema.ParseTimestamp(status.Until); err == nil {
+			description += " until " + until.Local().Format(time.UnixDate)
+		}
+	}
+	if status.Delegate != "" {
+		description += ", delegating to " + status.Delegate
+	}
+	if status.Message != "" {
+		description += ": " + status.Message
+	}
+	return description
+}
+
+// rerouteReviewers replaces each of the given reviewers who is away with their delegate, and reports each one that it replaces.
+//
+// Reviewers who are away without a delegate are kept, with a warning, as there is nobody to review in their place.
+func rerouteReviewers(repo repository.Repo, reviewers []string) []string {
+	statuses := away.Load(repo)
+	now := time.Now()
+	for _, reviewer := range reviewers {
+		status := statuses.Away(reviewer, now)
+		if status == nil {
+			continue
+		}
+		if delegate := statuses.Delegate(reviewer, now); delegate != "" {
+			fmt.Fprintf(os.Stderr, "%s; the review is requested from %s instead.\n", describeAway(*status), delegate)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: %s.\n", describeAway(*status))
+		}
+	}
+	return statuses.Reroute(reviewers, now)
+}
+
+// awayUsers returns the users who are away at the given time.
+func awayUsers(statuses away.Statuses, now time.Time) []string {
+	var users []string
+	for _, status := range statuses {
+		if status.IsAway(now) {
+			users = append(users, status.User)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// listAway prints the statuses of everyone who is away.
+func listAway(statuses away.Statuses, now time.Time) error {
+	result := []away.Status{}
+	for _, user := range awayUsers(statuses, now) {
+		result = append(result, *statuses.Away(user, now))
+	}
+	if JSONOutput || *awayJSON {
+		return output.PrintJSONResult("away", result)
+	}
+	if len(result) == 0 {
+		fmt.Println("Nobody is away.")
+	}
+	for _, status := range result {
+		fmt.Println(describeAway(status))
+	}
+	return nil
+}
+
+// setAway records that the user is away, or lists everyone who is away if no status is given.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func setAway(repo repository.Repo, args []string) error {
+	awayFlagSet.Parse(args)
+	if len(awayFlagSet.Args()) > 0 {
+		return errors.New("The away command does not take any arguments.")
+	}
+	now := time.Now()
+	if !*awayBack && *awayUntil == "" && *awayDelegate == "" && *awayMessage == "" {
+		return listAway(away.Load(repo), now)
+	}
+	if *awayBack && (*awayUntil != "" || *awayDelegate != "" || *awayMessage != "") {
+		return errors.New("The -back flag cannot be combined with the other flags.")
+	}
+
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	status := away.NewBack(userEmail)
+	if !*awayBack {
+		var until time.Time
+		if *awayUntil != "" {
+			if until, err = parseUntil(*awayUntil, now); err != nil {
+				return err
+			}
+			if !until.After(now) {
+				return fmt.Errorf("The time %q has already passed.", *awayUntil)
+			}
+		}
+		if containsValue([]string{userEmail}, *awayDelegate) {
+			return errors.New("You cannot delegate to yourself.")
+		}
+		status = away.New(userEmail, until, *awayDelegate, *awayMessage)
+	}
+	note, err := status.Write()
+	if err != nil {
+		return err
+	}
+	if err := repo.AppendNote(away.Ref, away.Subject, note); err != nil {
+		return err
+	}
+	if JSONOutput || *awayJSON {
+		return output.PrintJSONResult("away", status)
+	}
+	if status.Back {
+		fmt.Println("Welcome back.")
+	} else {
+		fmt.Println(describeAway(status) + ".")
+	}
+	return nil
+}
+
+// awayCmd defines the "away" subcommand.
+var awayCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s away [<option>...]\n\nLists everyone who is away, if no options are given.\n\nOptions:\n", arg0)
+		awayFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return setAway(repo, args)
+	},
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/input"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+)
+
+var batchFlagSet = flag.NewFlagSet("batch", flag.ExitOnError)
+
+var (
+	batchQuery       = batchFlagSet.String("query", "", "Search query selecting the reviews to act upon, in addition to any given review hashes")
+	batchMessageFile = batchFlagSet.String("F", "", "Take the comment from the given file. Use - to read the message from the standard input")
+	batchMessage     = batchFlagSet.String("m", "", "Message to attach to each review")
+	batchSign        = batchFlagSet.Bool("sign", false, "Sign each comment using the GPG key configured as user.signingkey")
+)
+
+// batchActions maps each supported action to the past tense used to summarize it.
+var batchActions = map[string]string{
+	"accept":  "Accepted",
+	"comment": "Commented on",
+	"abandon": "Abandoned",
+}
+
+// batchNote is a single note to be written as part of a batch operation.
+type batchNote struct {
+	Ref      string
+	Revision string
+	Note     repository.Note
+}
+
+// batchResult is the JSON output of the "batch" subcommand.
+type batchResult struct {
+	Action  string   `json:"action"`
+	Reviews []string `json:"reviews"`
+}
+
+// selectBatchReviews returns the reviews named by the given hashes or matched by the given query, without duplicates.
+func selectBatchReviews(repo repository.Repo, hashes []string, query string) ([]review.Summary, error) {
+	var reviews []review.Summary
+	for _, hash := range hashes {
+		revision, err := resolveReview(repo, hash)
+		if err != nil {
+			return nil, err
+		}
+		r, err := review.GetSummary(repo, revision)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load the review %q: %w", hash, err)
+		}
+		if r == nil {
+			return nil, fmt.Errorf("There is no review for %q.", hash)
+		}
+		reviews = append(reviews, *r)
+	}
+	if query != "" {
+		matches, err := findReviews(repo, query, false)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, matches...)
+	}
+	seen := make(map[string]bool)
+	var unique []review.Summary
+	for _, r := range reviews {
+		if !seen[r.Revision] {
+			seen[r.Revision] = true
+			unique = append(unique, r)
+		}
+	}
+	return unique, nil
+}
+
+// buildBatchNotes returns the notes that perform the given action on each of the given reviews.
+//
+// Every review is checked before any notes are built, so that a batch is
+// rejected as a whole if the action cannot be performed on any one review.
+func buildBatchNotes(repo repository.Repo, action, author, message string, reviews []review.Summary) ([]batchNote, error) {
+	for _, r := range reviews {
+		if !r.IsOpen() {
+			return nil, fmt.Errorf("The review %.12s is not open, so it cannot be included in the batch.", r.Revision)
+		}
+	}
+	details, err := review.DetailsAll(repo, reviews)
+	if err != nil {
+		return nil, err
+	}
+	var notes []batchNote
+	for _, r := range details {
+		headCommit, err := r.GetHeadCommit()
+		if err != nil {
+			return nil, err
+		}
+		c := comment.New(author, message)
+		c.Location = &comment.Location{Commit: headCommit}
+		switch action {
+		case "accept":
+			resolved := true
+			c.Resolved = &resolved
+		case "abandon":
+			resolved := false
+			c.Resolved = &resolved
+		}
+		if *batchSign {
+			if err := signMetadata(repo, &c); err != nil {
+				return nil, err
+			}
+		}
+		note, err := c.Write()
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, batchNote{Ref: comment.Ref, Revision: r.Revision, Note: note})
+		if action == "abandon" {
+			// Empty target ref indicates that request was abandoned
+			abandoned := r.Request
+			abandoned.TargetRef = ""
+			note, err := abandoned.Write()
+			if err != nil {
+				return nil, err
+			}
+			notes = append(notes, batchNote{Ref: request.Ref, Revision: r.Revision, Note: note})
+		}
+	}
+	return notes, nil
+}
+
+// writeBatchNotes writes all of the given notes, or none of them.
+//
+// The notes for each notes ref are written with a single commit of that ref.
+// If writing any of them fails, then every notes ref that was written to is
+// restored to the commit it pointed to before the batch started.
+func writeBatchNotes(repo repository.Repo, notes []batchNote) error {
+	var refs []string
+	notesByRef := make(map[string]map[string][]repository.Note)
+	previous := make(map[string]string)
+	for _, n := range notes {
+		if _, ok := notesByRef[n.Ref]; !ok {
+			refs = append(refs, n.Ref)
+			notesByRef[n.Ref] = make(map[string][]repository.Note)
+			// A ref that does not exist yet is restored by deleting it.
+			previous[n.Ref], _ = repo.GetCommitHash(n.Ref)
+		}
+		notesByRef[n.Ref][n.Revision] = append(notesByRef[n.Ref][n.Revision], n.Note)
+	}
+	for _, ref := range refs {
+		if err := repo.AppendNotes(ref, notesByRef[ref]); err != nil {
+			for restored, commit := range previous {
+				if restoreErr := repo.SetRef(restored, commit); restoreErr != nil {
+					return fmt.Errorf("Failed to write the notes to %q (%v), and then failed to restore %q: %v", ref, err, restored, restoreErr)
+				}
+			}
+			return fmt.Errorf("Failed to write the notes to %q, so no reviews were changed: %v", ref, err)
+		}
+	}
+	return nil
+}
+
+// batchReviews performs a single action on multiple reviews at once.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func batchReviews(repo repository.Repo, args []string) error {
+	batchFlagSet.Parse(args)
+	args = batchFlagSet.Args()
+	if len(args) < 1 {
+		return errors.New("The batch command requires an action: accept, comment, or abandon.")
+	}
+	action := args[0]
+	summary, ok := batchActions[action]
+	if !ok {
+		return fmt.Errorf("Unknown batch action %q.", action)
+	}
+	if len(args) == 1 && *batchQuery == "" {
+		return errors.New("The batch command requires either review hashes or a query.")
+	}
+
+	reviews, err := selectBatchReviews(repo, args[1:], *batchQuery)
+	if err != nil {
+		return err
+	}
+	if len(reviews) == 0 {
+		return errors.New("There are no matching reviews.")
+	}
+
+	if *batchMessageFile != "" && *batchMessage == "" {
+		*batchMessage, err = input.FromFile(*batchMessageFile)
+		if err != nil {
+			return err
+		}
+	}
+	if action == "comment" && *batchMessage == "" {
+		return errors.New("Commenting on a batch of reviews requires a message.")
+	}
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	notes, err := buildBatchNotes(repo, action, userEmail, *batchMessage, reviews)
+	if err != nil {
+		return err
+	}
+	if err := writeBatchNotes(repo, notes); err != nil {
+		return err
+	}
+
+	if JSONOutput {
+		result := batchResult{Action: action, Reviews: []string{}}
+		for _, r := range reviews {
+			result.Reviews = append(result.Reviews, r.Revision)
+		}
+		return output.PrintJSONResult("batch", result)
+	}
+	fmt.Printf("%s %d reviews:\n", summary, len(reviews))
+	for _, r := range reviews {
+		updated, err := review.GetSummary(repo, r.Revision)
+		if err != nil {
+			return err
+		}
+		output.PrintSummary(updated)
+	}
+	return nil
+}
+
+// batchCmd defines the "batch" subcommand.
+var batchCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s batch [<option>...] (accept|comment|abandon) [<review-hash>...]\n\nOptions:\n", arg0)
+		batchFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return batchReviews(repo, args)
+	},
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"testing"
+)
+
+func TestBatchAbandon(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	reviews, err := selectBatchReviews(repo, []string{repository.TestCommitG, repository.TestCommitG}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reviews) != 1 {
+		t.Fatalf("Unexpected reviews: %v", reviews)
+	}
+	notes, err := buildBatchNotes(repo, "abandon", "user@example.com", "Superseded", reviews)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].Ref != comment.Ref || notes[1].Ref != request.Ref {
+		t.Fatalf("Unexpected notes: %v", notes)
+	}
+	if err := writeBatchNotes(repo, notes); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.GetSummary(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.IsAbandoned() {
+		t.Fatal("The review was not abandoned")
+	}
+
+	// The abandoned review is no longer open, so the whole batch must be rejected.
+	reviews, err = selectBatchReviews(repo, []string{repository.TestCommitG}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buildBatchNotes(repo, "accept", "user@example.com", "", reviews); err == nil {
+		t.Fatal("Unexpectedly allowed a batch including a closed review")
+	}
+	if _, err := selectBatchReviews(repo, []string{repository.TestCommitA}, ""); err == nil {
+		t.Fatal("Unexpectedly selected a commit without a review")
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/policy"
+)
+
+var carryForwardFlagSet = flag.NewFlagSet("carry-forward", flag.ExitOnError)
+
+var (
+	carryForwardApprovals = carryForwardFlagSet.String("approvals", "", "Comma-separated hashes of the stale approvals to carry forward; defaults to all of them")
+)
+
+// carryForwardResult is the JSON output of the "carry-forward" subcommand.
+type carryForwardResult struct {
+	Review    string   `json:"review"`
+	Head      string   `json:"head"`
+	Approvals []string `json:"approvals"`
+}
+
+// carryForward records that the stale approvals of a review count for its current head.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func carryForward(repo repository.Repo, args []string) error {
+	carryForwardFlagSet.Parse(args)
+	args = carryForwardFlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("Only carrying forward the approvals of a single review is supported.")
+	}
+
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	carried, err := policy.CarryForward(r, userEmail, splitValues(*carryForwardApprovals))
+	if err != nil {
+		return err
+	}
+	if JSONOutput {
+		head, err := r.GetHeadCommit()
+		if err != nil {
+			return err
+		}
+		if carried == nil {
+			carried = []string{}
+		}
+		return output.PrintJSONResult("carry-forward", carryForwardResult{Review: r.Revision, Head: head, Approvals: carried})
+	}
+	if len(carried) == 0 {
+		fmt.Println("There are no stale approvals to carry forward.")
+		return nil
+	}
+	fmt.Printf("Carried forward %d approval(s) of review %.12s\n", len(carried), r.Revision)
+	return nil
+}
+
+// carryForwardCmd defines the "carry-forward" subcommand.
+var carryForwardCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s carry-forward [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		carryForwardFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return carryForward(repo, args)
+	},
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/checklist"
+	"strings"
+)
+
+var checklistFlagSet = flag.NewFlagSet("checklist", flag.ExitOnError)
+
+var (
+	checklistCheck   = checklistFlagSet.String("check", "", "Comma-separated list of the checklist items to check off")
+	checklistUncheck = checklistFlagSet.String("uncheck", "", "Comma-separated list of the checklist items to uncheck")
+	checklistJSON    = checklistFlagSet.Bool("json", false, "Format the output as JSON")
+)
+
+// checklistItemStatus is the JSON output for a single item of a review's checklist.
+type checklistItemStatus struct {
+	checklist.Item
+	Checked bool `json:"checked"`
+	// CheckedBy is the author of the latest check of the item, if it is checked off.
+	CheckedBy string `json:"checkedBy,omitempty"`
+}
+
+// checklistStatus returns the state of each item of the given checklist.
+func checklistStatus(c *checklist.Checklist, checks []checklist.Check) []checklistItemStatus {
+	latest := checklist.Latest(checks)
+	var statuses []checklistItemStatus
+	for _, item := range c.Items {
+		status := checklistItemStatus{Item: item}
+		if check, ok := latest[strings.ToLower(item.Name)]; ok && !check.Unchecked {
+			status.Checked = true
+			status.CheckedBy = check.Author
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// printChecklist prints each item of a review's checklist, along with whether it has been checked off.
+func printChecklist(statuses []checklistItemStatus) error {
+	if JSONOutput || *checklistJSON {
+		return output.PrintJSONResult("checklist", statuses)
+	}
+	for _, status := range statuses {
+		marker := " "
+		if status.Checked {
+			marker = "x"
+		}
+		line := fmt.Sprintf("[%s] %s", marker, status.Name)
+		if status.Required {
+			line += " (required)"
+		}
+		if status.Checked {
+			line += fmt.Sprintf(", checked off by %s", status.CheckedBy)
+		} else if status.Hint != "" {
+			line += fmt.Sprintf(": %s", status.Hint)
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// findChecklistItems returns the names, as given in the checklist, of the items named on the command line.
+func findChecklistItems(c *checklist.Checklist, names []string) ([]string, error) {
+	var items []string
+	for _, name := range names {
+		item := c.Find(name)
+		if item == nil {
+			return nil, fmt.Errorf("The checklist has no item %q.", name)
+		}
+		items = append(items, item.Name)
+	}
+	return items, nil
+}
+
+// checkItems checks items off a review's checklist, or unchecks them, and prints the updated checklist.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func checkItems(repo repository.Repo, args []string) error {
+	checklistFlagSet.Parse(args)
+	args = checklistFlagSet.Args()
+
+	if len(args) > 1 {
+		return errors.New("Only the checklist of a single review is supported.")
+	}
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+
+	c, err := checklist.Load(repo, r.Request.TargetRef)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return fmt.Errorf("The target ref of the review has no checklist; one can be added in %q.", checklist.File)
+	}
+	checked, err := findChecklistItems(c, splitValues(*checklistCheck))
+	if err != nil {
+		return err
+	}
+	unchecked, err := findChecklistItems(c, splitValues(*checklistUncheck))
+	if err != nil {
+		return err
+	}
+	for _, item := range checked {
+		if containsValue(unchecked, item) {
+			return fmt.Errorf("The item %q cannot be both checked off and unchecked.", item)
+		}
+	}
+
+	if len(checked) > 0 || len(unchecked) > 0 {
+		author, err := commentAuthor(repo, r, false)
+		if err != nil {
+			return err
+		}
+		head, err := r.GetHeadCommit()
+		if err != nil {
+			return err
+		}
+		var notes []repository.Note
+		for _, item := range checked {
+			note, err := checklist.New(author, item, head, false).Write()
+			if err != nil {
+				return err
+			}
+			notes = append(notes, note)
+		}
+		for _, item := range unchecked {
+			note, err := checklist.New(author, item, head, true).Write()
+			if err != nil {
+				return err
+			}
+			notes = append(notes, note)
+		}
+		if err := r.Repo.AppendNotes(checklist.Ref, map[string][]repository.Note{r.Revision: notes}); err != nil {
+			return err
+		}
+	}
+	return printChecklist(checklistStatus(c, checklist.ParseAllValid(r.Repo.GetNotes(checklist.Ref, r.Revision))))
+}
+
+// checklistCmd defines the "checklist" subcommand.
+var checklistCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s checklist [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		checklistFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return checkItems(repo, args)
+	},
+}
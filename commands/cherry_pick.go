@@ -0,0 +1,212 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/request"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cherryPickFlagSet = flag.NewFlagSet("cherry-pick", flag.ExitOnError)
+
+var (
+	cherryPickOnto   = cherryPickFlagSet.String("onto", "", "Target ref to backport the review to, such as release/1.2")
+	cherryPickBranch = cherryPickFlagSet.String("branch", "", "Name of the branch to create for the backport; defaults to backport/<target>/<review>")
+)
+
+// cherryPickResult is the JSON output of the "cherry-pick" subcommand.
+type cherryPickResult struct {
+	Revision   string          `json:"revision"`
+	BackportOf string          `json:"backportOf"`
+	Request    request.Request `json:"request"`
+}
+
+// backportCommits returns the commits of the given review to cherry-pick, oldest first.
+//
+// For a submitted review, those are the commits of its latest patchset, since
+// the base recorded in its request predates any rebase before it was submitted.
+func backportCommits(r *review.Review) ([]string, error) {
+	if n := len(r.Request.Patchsets); r.Submitted && n > 0 && r.Request.Patchsets[n-1].Base != "" {
+		latest := r.Request.Patchsets[n-1]
+		return r.Repo.ListCommitsBetween(latest.Base, latest.Commit)
+	}
+	return r.ListCommits()
+}
+
+// backportReview cherry-picks the commits of the given review onto a new branch
+// created from the given target ref, and requests a review of that branch.
+//
+// The new review copies the description, reviewers, labels, and issues of the
+// original, and the two reviews are linked to each other. The new branch is
+// left checked out. This returns the revision of the new review.
+func backportReview(repo repository.Repo, r *review.Review, onto, branch, requester string) (string, request.Request, error) {
+	commits, err := backportCommits(r)
+	if err != nil {
+		return "", request.Request{}, err
+	}
+	if len(commits) == 0 {
+		return "", request.Request{}, errors.New("There are no commits in the review to cherry-pick.")
+	}
+	base, err := repo.GetCommitHash(onto)
+	if err != nil {
+		return "", request.Request{}, err
+	}
+	original, err := repo.GetHeadRef()
+	if err != nil {
+		if original, err = repo.GetCommitHash("HEAD"); err != nil {
+			return "", request.Request{}, err
+		}
+	}
+	// Pick the commits onto a detached head, so that nothing is left to clean up if that fails.
+	if err := repo.SwitchToRef(base); err != nil {
+		return "", request.Request{}, err
+	}
+	if err := repo.CherryPick(commits...); err != nil {
+		repo.AbortCherryPick()
+		repo.SwitchToRef(original)
+		return "", request.Request{}, fmt.Errorf("The review does not cherry-pick cleanly onto %s, so it must be backported by hand: %v", onto, err)
+	}
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		return "", request.Request{}, err
+	}
+	if err := repo.SetRef(branch, head); err != nil {
+		return "", request.Request{}, err
+	}
+	if err := repo.SwitchToRef(branch); err != nil {
+		return "", request.Request{}, err
+	}
+	picked, err := repo.ListCommitsBetween(base, head)
+	if err != nil {
+		return "", request.Request{}, err
+	}
+	if len(picked) == 0 {
+		return "", request.Request{}, fmt.Errorf("The review's changes are already in %s.", onto)
+	}
+	revision := picked[0]
+
+	backport := request.New(requester, r.Request.Reviewers, branch, onto, r.Request.Description)
+	backport.BaseCommit = base
+	backport.Patchsets = []request.Patchset{{Timestamp: backport.Timestamp, Commit: head, Base: base}}
+	backport.Labels = r.Request.Labels
+	backport.Issues = r.Request.Issues
+	backport.Blind = r.Request.Blind
+	backport.BackportOf = r.Revision
+	note, err := backport.Write()
+	if err != nil {
+		return "", request.Request{}, err
+	}
+	if err := repo.AppendNote(request.Ref, revision, note); err != nil {
+		return "", request.Request{}, err
+	}
+
+	r.Request.Backports = append(r.Request.Backports, revision)
+	r.Request.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	r.Request.Signature = ""
+	note, err = r.Request.Write()
+	if err != nil {
+		return "", request.Request{}, err
+	}
+	if err := repo.AppendNote(request.Ref, r.Revision, note); err != nil {
+		return "", request.Request{}, err
+	}
+	return revision, backport, nil
+}
+
+// cherryPick creates a review that backports an existing review to another target ref.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func cherryPick(repo repository.Repo, args []string) error {
+	cherryPickFlagSet.Parse(args)
+	args = cherryPickFlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("Only cherry-picking a single review is supported.")
+	}
+	if *cherryPickOnto == "" {
+		return errors.New("The --onto flag is required.")
+	}
+
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+
+	onto := qualifyRef(*cherryPickOnto)
+	if err := repo.VerifyGitRef(onto); err != nil {
+		return fmt.Errorf("The target ref %q does not exist.", onto)
+	}
+	if onto == r.Request.TargetRef {
+		return fmt.Errorf("The review already targets %s.", onto)
+	}
+	branch := *cherryPickBranch
+	if branch == "" {
+		branch = fmt.Sprintf("backport/%s/%.12s", strings.TrimPrefix(onto, "refs/heads/"), r.Revision)
+	}
+	branch = qualifyRef(branch)
+	if err := repo.VerifyGitRef(branch); err == nil {
+		return fmt.Errorf("The branch %q already exists.", branch)
+	}
+	hasUncommitted, err := repo.HasUncommittedChanges()
+	if err != nil {
+		return err
+	}
+	if hasUncommitted {
+		return errors.New("You have uncommitted or untracked files, which must be committed or stashed before cherry-picking.")
+	}
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	revision, backport, err := backportReview(repo, r, onto, branch, userEmail)
+	if err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("cherry-pick", cherryPickResult{Revision: revision, BackportOf: r.Revision, Request: backport})
+	}
+	fmt.Printf("Requested the backport %.12s of review %.12s onto %s, on the branch %s\n", revision, r.Revision, onto, branch)
+	return nil
+}
+
+// cherryPickCmd defines the "cherry-pick" subcommand.
+var cherryPickCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s cherry-pick --onto=<target-ref> [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		cherryPickFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return cherryPick(repo, args)
+	},
+	NeedsWorktree: true,
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"testing"
+)
+
+func TestBackportReview(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	if err := repo.SetRef("refs/heads/release", repository.TestCommitB); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revision, backport, err := backportReview(repo, r, "refs/heads/release", "refs/heads/backport", "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backport.BackportOf != repository.TestCommitG || backport.TargetRef != "refs/heads/release" || backport.Description != r.Request.Description {
+		t.Fatalf("Unexpected backport request: %+v", backport)
+	}
+	head, err := repo.GetHeadRef()
+	if err != nil || head != "refs/heads/backport" {
+		t.Fatalf("The backport branch was not checked out: %q, %v", head, err)
+	}
+
+	created, err := review.Get(repo, revision)
+	if err != nil || created == nil {
+		t.Fatalf("The backport review was not requested: %v", err)
+	}
+	if created.Request.BackportOf != repository.TestCommitG {
+		t.Fatalf("The backport review is not linked to the original: %+v", created.Request)
+	}
+	original, err := review.Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(original.Request.Backports) != 1 || original.Request.Backports[0] != revision {
+		t.Fatalf("The original review is not linked to the backport: %v", original.Request.Backports)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/input"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/ci"
+	"mime"
+	"os"
+	"path"
+	"strings"
+)
+
+// ciResult is the JSON output of the "ci" subcommand.
+type ciResult struct {
+	Commit string    `json:"commit"`
+	Report ci.Report `json:"report"`
+}
+
+var ciFlagSet = flag.NewFlagSet("ci", flag.ExitOnError)
+
+var (
+	ciStatus    = ciFlagSet.String("status", "", "Status of the build; one of success, failure, pending, or running")
+	ciURL       = ciFlagSet.String("url", "", "URL of the build results")
+	ciAgent     = ciFlagSet.String("agent", "", "Name of the CI agent that performed the build")
+	ciLogFile   = ciFlagSet.String("log", "", "Take an excerpt of the build log from the given file. Use - to read the log from the standard input")
+	ciArtifacts = ciFlagSet.String("artifacts", "", "Comma-separated list of build artifacts, each of the form <name>=<url>")
+	ciCoverage  = ciFlagSet.String("coverage", "", "Take the code coverage from the given Go coverage profile or LCOV file. Use - to read it from the standard input")
+	ciTests     = ciFlagSet.String("tests", "", "Take the test results from the given JUnit XML report or go test -json output. Use - to read them from the standard input")
+	ciTrigger   = ciFlagSet.String("trigger", "", "Hash of the rerun request (see rerun-ci) that triggered the build")
+	ciFrom      = ciFlagSet.String("from", "", "CI system to read the build's commit, agent, and URL from; used by \"ci publish\". One of github-actions")
+	ciSign      = ciFlagSet.Bool("sign", false, "Sign the report using the GPG key configured as user.signingkey")
+)
+
+// parseArtifact parses a single build artifact of the form "<name>=<url>".
+//
+// The content type of the artifact is guessed from the extension of the URL path.
+func parseArtifact(artifactString string) (ci.Artifact, error) {
+	parts := strings.SplitN(artifactString, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ci.Artifact{}, fmt.Errorf("Malformed artifact %q; artifacts must be of the form <name>=<url>", artifactString)
+	}
+	url := parts[1]
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	return ci.Artifact{
+		Name:        parts[0],
+		URL:         parts[1],
+		ContentType: mime.TypeByExtension(path.Ext(url)),
+	}, nil
+}
+
+// Build the CI report based solely on the parsed flag values.
+func buildReportFromFlags() (ci.Report, error) {
+	report := ci.New(*ciAgent, *ciStatus, *ciURL)
+	report.Trigger = *ciTrigger
+	if *ciLogFile != "" {
+		log, err := input.FromFile(*ciLogFile)
+		if err != nil {
+			return ci.Report{}, err
+		}
+		report.AppendLog(log)
+	}
+	if *ciCoverage != "" {
+		contents, err := input.FromFile(*ciCoverage)
+		if err != nil {
+			return ci.Report{}, err
+		}
+		if report.Coverage, err = ci.ParseCoverage(contents); err != nil {
+			return ci.Report{}, err
+		}
+	}
+	if *ciTests != "" {
+		contents, err := input.FromFile(*ciTests)
+		if err != nil {
+			return ci.Report{}, err
+		}
+		if report.Tests, err = ci.ParseTestResults(contents); err != nil {
+			return ci.Report{}, err
+		}
+	}
+	if len(*ciArtifacts) > 0 {
+		for _, artifactString := range strings.Split(*ciArtifacts, ",") {
+			artifact, err := parseArtifact(strings.TrimSpace(artifactString))
+			if err != nil {
+				return ci.Report{}, err
+			}
+			report.AddArtifact(artifact.Name, artifact.URL, artifact.ContentType)
+		}
+	}
+	if err := report.Validate(); err != nil {
+		return ci.Report{}, err
+	}
+	return report, nil
+}
+
+// postCIReport annotates a commit with the status of a build.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func postCIReport(repo repository.Repo, args []string) error {
+	ciFlagSet.Parse(args)
+	args = ciFlagSet.Args()
+
+	if len(args) > 0 && args[0] == "publish" {
+		return publishCIReport(repo, args[1:])
+	}
+	if len(args) > 1 {
+		return errors.New("Only reporting on a single commit is supported.")
+	}
+	revision := "HEAD"
+	if len(args) == 1 {
+		revision = args[0]
+	}
+	commit, err := repo.GetCommitHash(revision)
+	if err != nil {
+		return fmt.Errorf("Could not find a commit named %q: %v", revision, err)
+	}
+	return writeCIReport(repo, commit)
+}
+
+// writeCIReport annotates the given commit with a CI report built from the parsed flag values.
+func writeCIReport(repo repository.Repo, commit string) error {
+	report, err := buildReportFromFlags()
+	if err != nil {
+		return err
+	}
+	if *ciSign {
+		if err := signMetadata(repo, &report); err != nil {
+			return err
+		}
+	}
+	note, err := report.Write()
+	if err != nil {
+		return err
+	}
+	if err := repo.AppendNote(ci.Ref, commit, note); err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("ci", ciResult{Commit: commit, Report: report})
+	}
+	return nil
+}
+
+// warnSkippedCIReports prints a warning for each of the given CI reports that is skipped because of its invalid timestamp.
+func warnSkippedCIReports(reports []ci.Report) {
+	for _, warning := range ci.SkippedReportWarnings(reports) {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+}
+
+// ciCmd defines the "ci" subcommand.
+var ciCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s ci -agent <agent> [<option>...] [<commit>]\n", arg0)
+		fmt.Printf("       %s ci publish -from <ci-system> [<option>...]\n\nOptions:\n", arg0)
+		ciFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return postCIReport(repo, args)
+	},
+}
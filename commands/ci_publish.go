@@ -0,0 +1,135 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/ci"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ciEnvironment describes the build that is running, as read from the environment of a CI system.
+type ciEnvironment struct {
+	Commit string
+	Agent  string
+	URL    string
+}
+
+// ciSources maps the name of each supported CI system to the function that
+// reads the description of the current build from its environment.
+var ciSources = map[string]func(getenv func(string) string) (*ciEnvironment, error){
+	"github-actions": gitHubActionsEnvironment,
+}
+
+// gitHubActionsEvent is the subset of a GitHub Actions event payload that names the commit being built.
+type gitHubActionsEvent struct {
+	PullRequest *struct {
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	WorkflowRun *struct {
+		HeadSHA string `json:"head_sha"`
+	} `json:"workflow_run"`
+}
+
+// gitHubActionsEnvironment reads the description of the current build from the
+// default environment variables and event payload of GitHub Actions.
+//
+// For pull request events, GITHUB_SHA names a merge commit that GitHub created
+// for the build, so the head of the pull request is used instead.
+func gitHubActionsEnvironment(getenv func(string) string) (*ciEnvironment, error) {
+	if getenv("GITHUB_ACTIONS") != "true" {
+		return nil, errors.New("Not running in GitHub Actions; GITHUB_ACTIONS is not set.")
+	}
+	env := &ciEnvironment{
+		Commit: getenv("GITHUB_SHA"),
+		Agent:  "github-actions",
+	}
+	if workflow := getenv("GITHUB_WORKFLOW"); workflow != "" {
+		env.Agent += "/" + workflow
+		if job := getenv("GITHUB_JOB"); job != "" {
+			env.Agent += "/" + job
+		}
+	}
+	if server, repo, run := getenv("GITHUB_SERVER_URL"), getenv("GITHUB_REPOSITORY"), getenv("GITHUB_RUN_ID"); server != "" && repo != "" && run != "" {
+		env.URL = fmt.Sprintf("%s/%s/actions/runs/%s", strings.TrimSuffix(server, "/"), repo, run)
+	}
+	if eventPath := getenv("GITHUB_EVENT_PATH"); eventPath != "" {
+		contents, err := ioutil.ReadFile(eventPath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the GitHub Actions event payload: %v", err)
+		}
+		var event gitHubActionsEvent
+		if err := json.Unmarshal(contents, &event); err != nil {
+			return nil, fmt.Errorf("Malformed GitHub Actions event payload: %v", err)
+		}
+		if event.PullRequest != nil && event.PullRequest.Head.SHA != "" {
+			env.Commit = event.PullRequest.Head.SHA
+		} else if event.WorkflowRun != nil && event.WorkflowRun.HeadSHA != "" {
+			env.Commit = event.WorkflowRun.HeadSHA
+		}
+	}
+	if env.Commit == "" {
+		return nil, errors.New("Could not determine the commit being built; GITHUB_SHA is not set.")
+	}
+	return env, nil
+}
+
+// publishCIReport annotates the commit being built by a CI system with the
+// status of the build, taking the commit, agent, and URL from the environment.
+//
+// The "args" parameter is all of the command line arguments that followed "ci publish".
+func publishCIReport(repo repository.Repo, args []string) error {
+	ciFlagSet.Parse(args)
+	if len(ciFlagSet.Args()) > 0 {
+		return errors.New("The publish command does not take a commit; it is read from the environment.")
+	}
+	source, ok := ciSources[*ciFrom]
+	if !ok {
+		var names []string
+		for name := range ciSources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("Unknown CI system %q; --from must be one of: %s", *ciFrom, strings.Join(names, ", "))
+	}
+	env, err := source(os.Getenv)
+	if err != nil {
+		return err
+	}
+	if *ciAgent == "" {
+		*ciAgent = env.Agent
+	}
+	if *ciURL == "" {
+		*ciURL = env.URL
+	}
+	switch *ciStatus {
+	case "":
+		*ciStatus = ci.StatusRunning
+	case "cancelled":
+		// This is one of the values of GitHub's "job.status".
+		*ciStatus = ci.StatusFailure
+	}
+	return writeCIReport(repo, env.Commit)
+}
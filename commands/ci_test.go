@@ -0,0 +1,85 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildReportFromFlags(t *testing.T) {
+	args := []string{"-agent", "jenkins", "-status", "failure", "-url", "https://ci.example.com/job/1/",
+		"-artifacts", "log=https://ci.example.com/job/1/log.txt, results=https://ci.example.com/job/1/results.json"}
+	ciFlagSet.Parse(args)
+	report, err := buildReportFromFlags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Agent != "jenkins" || report.Status != "failure" || report.URL != "https://ci.example.com/job/1/" {
+		t.Fatalf("Unexpected CI report: %v", report)
+	}
+	if len(report.Artifacts) != 2 || report.Artifacts[1].Name != "results" || report.Artifacts[1].ContentType != "application/json" {
+		t.Fatalf("Unexpected artifacts: %v", report.Artifacts)
+	}
+
+	ciFlagSet.Parse([]string{"-agent", "jenkins", "-status", "broken", "-artifacts", ""})
+	if _, err := buildReportFromFlags(); err == nil {
+		t.Fatal("Failed to reject an unknown status")
+	}
+}
+
+func TestGitHubActionsEnvironment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ci-publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	eventPath := filepath.Join(dir, "event.json")
+	if err := ioutil.WriteFile(eventPath, []byte(`{"pull_request": {"head": {"sha": "abc123"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{
+		"GITHUB_ACTIONS":    "true",
+		"GITHUB_SHA":        "merge456",
+		"GITHUB_WORKFLOW":   "CI",
+		"GITHUB_JOB":        "test",
+		"GITHUB_SERVER_URL": "https://github.com",
+		"GITHUB_REPOSITORY": "owner/repo",
+		"GITHUB_RUN_ID":     "42",
+		"GITHUB_EVENT_PATH": eventPath,
+	}
+	getenv := func(key string) string { return vars[key] }
+	env, err := gitHubActionsEnvironment(getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ciEnvironment{Commit: "abc123", Agent: "github-actions/CI/test", URL: "https://github.com/owner/repo/actions/runs/42"}
+	if *env != expected {
+		t.Errorf("Unexpected environment: %+v", *env)
+	}
+
+	delete(vars, "GITHUB_EVENT_PATH")
+	if env, err := gitHubActionsEnvironment(getenv); err != nil || env.Commit != "merge456" {
+		t.Errorf("Unexpected environment for a push event: %+v, %v", env, err)
+	}
+	delete(vars, "GITHUB_ACTIONS")
+	if _, err := gitHubActionsEnvironment(getenv); err == nil {
+		t.Error("Failed to reject an environment outside of GitHub Actions")
+	}
+}
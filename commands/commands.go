@@ -18,38 +18,279 @@ limitations under the License.
 package commands
 
 import (
+	"errors"
+	"fmt"
 	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/namespace"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 const notesRefPattern = "refs/notes/pullrequests/*"
 const archiveRefPattern = "refs/pullrequests/archives/*"
 const commentFilename = "APPRAISE_COMMENT_EDITMSG"
 
+// shortIDFile is the path, relative to the git directory, of the index of short review IDs.
+var shortIDFile = filepath.Join("appraise", "short-ids.gob")
+
+// JSONOutput specifies that commands should report their results in the
+// versioned JSON format, rather than as human-readable text.
+var JSONOutput bool
+
+// NotesNamespace is the namespace of the notes refs that the reviews are kept
+// under, which takes the place of the "appraise.notesNamespace" setting if it is not empty.
+var NotesNamespace string
+
+// ErrReported is returned by a command that failed after it had already
+// reported the details of that failure in its output.
+var ErrReported = errors.New("The command failed.")
+
+// The exit codes of the tool, which let scripts tell apart the kinds of failures.
+const (
+	// ExitFailure is the exit code for the failures that do not have a more specific one.
+	ExitFailure = 1
+	// ExitUsage is the exit code for an invalid command line, such as an unknown command.
+	ExitUsage = 2
+	// ExitReviewNotFound is the exit code for naming a review that does not exist.
+	ExitReviewNotFound = 3
+	// ExitNotesRefMissing is the exit code for an operation that needs notes refs which do not exist.
+	ExitNotesRefMissing = 4
+	// ExitSchemaVersion is the exit code for reading data in a format version that is not supported.
+	ExitSchemaVersion = 5
+)
+
+// ExitCode returns the exit code for a command that failed with the given error.
+func ExitCode(err error) int {
+	switch {
+	case errors.Is(err, review.ErrReviewNotFound):
+		return ExitReviewNotFound
+	case errors.Is(err, repository.ErrNotesRefMissing):
+		return ExitNotesRefMissing
+	case errors.Is(err, repository.ErrSchemaVersion):
+		return ExitSchemaVersion
+	}
+	return ExitFailure
+}
+
 // Command represents the definition of a single command.
 type Command struct {
 	Usage     func(string)
 	RunMethod func(repository.Repo, []string) error
+	// NoSync indicates that the command should not automatically synchronize
+	// the review notes with a remote, even if "appraise.autoSync" is set.
+	NoSync bool
+	// NoRepo indicates that the command can also run outside of a git repo,
+	// in which case it is passed a nil repo.
+	NoRepo bool
+	// NeedsWorktree indicates that the command checks out or modifies files,
+	// and so cannot run in a bare repo.
+	NeedsWorktree bool
 }
 
 // Run executes a command, given its arguments.
 //
 // The args parameter is all of the command line args that followed the
 // subcommand.
+//
+// If the repo is configured to automatically synchronize with a remote, then
+// the review notes are pulled from that remote before the command runs, and
+// pushed back to it afterward if the command changed any refs. If it is only
+// configured to automatically push, then the notes are not pulled first.
 func (cmd *Command) Run(repo repository.Repo, args []string) error {
-	return cmd.RunMethod(repo, args)
+	if repo == nil {
+		if !cmd.NoRepo {
+			return errors.New("The command must be run from within a git repo.")
+		}
+		return cmd.RunMethod(repo, args)
+	}
+	if err := applyOutputConfig(repo); err != nil {
+		return err
+	}
+	repo, err := applyNotesNamespace(repo)
+	if err != nil {
+		return err
+	}
+	if cmd.NeedsWorktree {
+		bare, err := repo.IsBare()
+		if err != nil {
+			return err
+		}
+		if bare {
+			return errors.New("The command needs a worktree, so it cannot be run in a bare repo.")
+		}
+	}
+	if cmd.NoSync {
+		return cmd.RunMethod(repo, args)
+	}
+	return runAutoSynced(repo, func() error {
+		return cmd.RunMethod(repo, args)
+	})
+}
+
+// runAutoSynced runs the given function, synchronizing the review notes around
+// it as configured by "appraise.autoSync" and "appraise.autoPush".
+//
+// Commands that keep running, such as daemons, are marked NoSync and call this
+// on every pass instead, so that they do not work on the notes as they were
+// when they started.
+func runAutoSynced(repo repository.Repo, run func() error) error {
+	remote, err := repo.GetAutoSyncRemote()
+	if err != nil {
+		return err
+	}
+	pull := remote != ""
+	if !pull {
+		if remote, err = repo.GetAutoPushRemote(); err != nil {
+			return err
+		}
+	}
+	if remote == "" {
+		return run()
+	}
+	return runSynced(repo, remote, pull, run)
+}
+
+// runSynced runs the given function before pushing the review notes, and optionally after pulling them.
+//
+// Failing to reach the remote does not prevent the function from running, so
+// that reviews can still be worked on offline; it is reported as a warning.
+func runSynced(repo repository.Repo, remote string, pull bool, run func() error) error {
+	if pull {
+		if err := repo.PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to pull the reviews from %q: %v\n", remote, err)
+		}
+	}
+	before, err := repo.GetRepoStateHash()
+	if err != nil {
+		return err
+	}
+	if err := run(); err != nil {
+		return err
+	}
+	after, err := repo.GetRepoStateHash()
+	if err != nil || after == before {
+		return err
+	}
+	if err := pushNotes(repo, remote); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to push the reviews to %q: %v\n", remote, err)
+	}
+	return nil
+}
+
+// gitDirPath returns the absolute path of the given file within the repo's git directory.
+//
+// This is where the local state of the tool is kept, so that it also works in bare repos.
+func gitDirPath(repo repository.Repo, path string) (string, error) {
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gitDir, path), nil
+}
+
+// shortIDPath returns the path, relative to the git directory, of the index of short review IDs.
+//
+// The reviews of each namespace of notes refs are numbered separately.
+func shortIDPath() string {
+	if notesPrefix == namespace.DefaultPrefix {
+		return shortIDFile
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(notesPrefix, "refs/notes/"), "/")
+	return filepath.Join("appraise", "short-ids", filepath.FromSlash(name)+".gob")
+}
+
+// loadShortIDs reads the index of short review IDs, assigning IDs to any reviews that do not have one yet.
+func loadShortIDs(repo repository.Repo) (*review.ShortIDs, error) {
+	path, err := gitDirPath(repo, shortIDPath())
+	if err != nil {
+		return nil, err
+	}
+	return review.LoadShortIDs(repo, path)
+}
+
+// resolveReview returns the revision of the review named on the command line.
+//
+// The name may be a short ID, as printed by "list", a unique prefix of the
+// review's revision, the branch under review, or any other name of its commit.
+func resolveReview(repo repository.Repo, name string) (string, error) {
+	var shortIDs *review.ShortIDs
+	if review.ParseShortID(name) != 0 {
+		var err error
+		if shortIDs, err = loadShortIDs(repo); err != nil {
+			return "", err
+		}
+	}
+	return review.Resolve(repo, name, shortIDs)
+}
+
+// getReview returns the review named on the command line; see resolveReview.
+func getReview(repo repository.Repo, name string) (*review.Review, error) {
+	revision, err := resolveReview(repo, name)
+	if err != nil {
+		return nil, err
+	}
+	return review.Get(repo, revision)
 }
 
 // CommandMap defines all of the available (sub)commands.
 var CommandMap = map[string]*Command{
-	"abandon": abandonCmd,
-	"accept":  acceptCmd,
-	"comment": commentCmd,
-	"list":    listCmd,
-	"pull":    pullCmd,
-	"push":    pushCmd,
-	"rebase":  rebaseCmd,
-	"reject":  rejectCmd,
-	"request": requestCmd,
-	"show":    showCmd,
-	"submit":  submitCmd,
+	"abandon":           abandonCmd,
+	"accept":            acceptCmd,
+	"analyze":           analyzeCmd,
+	"apply-suggestion":  applySuggestionCmd,
+	"archive":           archiveCmd,
+	"assign":            assignCmd,
+	"attachment":        attachmentCmd,
+	"away":              awayCmd,
+	"batch":             batchCmd,
+	"carry-forward":     carryForwardCmd,
+	"checklist":         checklistCmd,
+	"cherry-pick":       cherryPickCmd,
+	"ci":                ciCmd,
+	"comment":           commentCmd,
+	"completion":        completionCmd,
+	"config":            configCmd,
+	"diff":              diffCmd,
+	"email":             emailCmd,
+	"export":            exportCmd,
+	"fsck":              fsckCmd,
+	"hook":              hookCmd,
+	"import":            importCmd,
+	"issues":            issuesCmd,
+	"label":             labelCmd,
+	"list":              listCmd,
+	"log":               logCmd,
+	"lsp":               lspCmd,
+	"migrate":           migrateCmd,
+	"mirror":            mirrorCmd,
+	"notify":            notifyCmd,
+	"nudge":             nudgeCmd,
+	"publish":           publishCmd,
+	"pull":              pullCmd,
+	"push":              pushCmd,
+	"queue":             queueCmd,
+	"react":             reactCmd,
+	"rebase":            rebaseCmd,
+	"redact":            redactCmd,
+	"reject":            rejectCmd,
+	"remap":             remapCmd,
+	"request":           requestCmd,
+	"rerequest":         rerequestCmd,
+	"rerun-ci":          rerunCICmd,
+	"reveal":            revealCmd,
+	"robot":             robotCmd,
+	"search":            searchCmd,
+	"serve":             serveCmd,
+	"show":              showCmd,
+	"stats":             statsCmd,
+	"status":            statusCmd,
+	"submit":            submitCmd,
+	"suggest-reviewers": suggestReviewersCmd,
+	"transfer":          transferCmd,
+	"verify":            verifyCmd,
+	"viewed":            viewedCmd,
+	"watch":             watchCmd,
+	"web":               webCmd,
 }
@@ -0,0 +1,171 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		code int
+	}{
+		{errors.New("Something went wrong."), ExitFailure},
+		{ErrReported, ExitFailure},
+		{review.ErrReviewNotFound, ExitReviewNotFound},
+		{fmt.Errorf("Failed to load the review: %w", &review.NotFoundError{Name: "missing"}), ExitReviewNotFound},
+		{fmt.Errorf("Failed to push: %w", &repository.NotesRefMissingError{Pattern: "refs/notes/devtools/*"}), ExitNotesRefMissing},
+		{&repository.SchemaVersionError{Kind: "bundle", Version: 2}, ExitSchemaVersion},
+	} {
+		if code := ExitCode(test.err); code != test.code {
+			t.Errorf("Unexpected exit code for %v: got %d, want %d", test.err, code, test.code)
+		}
+	}
+}
+
+// printedJSONError returns the JSON envelope that is printed for the given error.
+func printedJSONError(t *testing.T, command string, err error) map[string]interface{} {
+	r, w, pipeErr := os.Pipe()
+	if pipeErr != nil {
+		t.Fatal(pipeErr)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	printErr := output.PrintJSONError(command, err, ExitCode(err))
+	os.Stdout = stdout
+	w.Close()
+	if printErr != nil {
+		t.Fatal(printErr)
+	}
+	out, readErr := ioutil.ReadAll(r)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	var envelope map[string]interface{}
+	if jsonErr := json.Unmarshal(out, &envelope); jsonErr != nil {
+		t.Fatalf("Failed to parse the JSON error %q: %v", out, jsonErr)
+	}
+	return envelope
+}
+
+func TestJSONErrorExitCodes(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		code int
+	}{
+		{errors.New("Something went wrong."), ExitFailure},
+		{review.ErrReviewNotFound, ExitReviewNotFound},
+		{fmt.Errorf("Failed to load the review: %w", &review.NotFoundError{Name: "missing"}), ExitReviewNotFound},
+		{fmt.Errorf("Failed to push: %w", &repository.NotesRefMissingError{Pattern: "refs/notes/devtools/*"}), ExitNotesRefMissing},
+		{&repository.SchemaVersionError{Kind: "bundle", Version: 2}, ExitSchemaVersion},
+	} {
+		envelope := printedJSONError(t, "show", test.err)
+		if len(envelope) != 5 {
+			t.Errorf("Unexpected fields in the JSON error for %v: %v", test.err, envelope)
+		}
+		if v, ok := envelope["v"].(float64); !ok || int(v) != output.JSONFormatVersion {
+			t.Errorf("Unexpected version in the JSON error for %v: %v", test.err, envelope["v"])
+		}
+		if command := envelope["command"]; command != "show" {
+			t.Errorf("Unexpected command in the JSON error for %v: %v", test.err, command)
+		}
+		if result, ok := envelope["result"]; !ok || result != nil {
+			t.Errorf("Unexpected result in the JSON error for %v: %v", test.err, result)
+		}
+		if message := envelope["error"]; message != test.err.Error() {
+			t.Errorf("Unexpected message in the JSON error for %v: %v", test.err, message)
+		}
+		if code, ok := envelope["exitCode"].(float64); !ok || int(code) != test.code {
+			t.Errorf("Unexpected exit code in the JSON error for %v: got %v, want %d", test.err, envelope["exitCode"], test.code)
+		}
+	}
+}
+
+// syncingRepo is a mock repo that is configured to synchronize with a remote, and counts the synchronizations.
+type syncingRepo struct {
+	repository.Repo
+	syncRemote, pushRemote string
+	pulls, pushes          int
+}
+
+func (repo *syncingRepo) GetAutoSyncRemote() (string, error) { return repo.syncRemote, nil }
+
+func (repo *syncingRepo) GetAutoPushRemote() (string, error) { return repo.pushRemote, nil }
+
+func (repo *syncingRepo) PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	repo.pulls++
+	return nil
+}
+
+func (repo *syncingRepo) PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	repo.pushes++
+	return nil
+}
+
+func TestRunAutoSync(t *testing.T) {
+	writeNote := func(repo repository.Repo, args []string) error {
+		return repo.AppendNote("refs/notes/test", repository.TestCommitA, repository.Note("note"))
+	}
+	readOnly := func(repo repository.Repo, args []string) error { return nil }
+	for _, test := range []struct {
+		name                   string
+		cmd                    *Command
+		syncRemote, pushRemote string
+		pulls, pushes          int
+	}{
+		{"sync and write", &Command{RunMethod: writeNote}, "origin", "", 1, 1},
+		{"sync without writing", &Command{RunMethod: readOnly}, "origin", "", 1, 0},
+		{"push and write", &Command{RunMethod: writeNote}, "", "origin", 0, 1},
+		{"no remote", &Command{RunMethod: writeNote}, "", "", 0, 0},
+		{"no sync", &Command{RunMethod: writeNote, NoSync: true}, "origin", "", 0, 0},
+	} {
+		repo := &syncingRepo{Repo: repository.NewMockRepoForTest(), syncRemote: test.syncRemote, pushRemote: test.pushRemote}
+		if err := test.cmd.Run(repo, nil); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if repo.pulls != test.pulls || repo.pushes != test.pushes {
+			t.Errorf("%s: unexpected synchronization: %d pull(s) and %d push(es)", test.name, repo.pulls, repo.pushes)
+		}
+	}
+}
+
+func TestDaemonsSyncOnEveryPass(t *testing.T) {
+	for name, cmd := range map[string]*Command{"notify": notifyCmd, "nudge": nudgeCmd, "queue": queueCmd, "watch": watchCmd} {
+		if !cmd.NoSync {
+			t.Errorf("The %s command only synchronizes the notes once, when it starts", name)
+		}
+	}
+	repo := &syncingRepo{Repo: repository.NewMockRepoForTest(), syncRemote: "origin"}
+	if err := repo.SetConfig("appraise.slaResolution", false, "24h"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nudgeCmd.Run(repo, []string{"-dry-run=false", "-watch=false"}); err != nil {
+		t.Fatal(err)
+	}
+	if repo.pulls != 1 || repo.pushes != 1 {
+		t.Errorf("Unexpected synchronization of a nudge: %d pull(s) and %d push(es)", repo.pulls, repo.pushes)
+	}
+}
@@ -21,9 +21,12 @@ import (
 	"flag"
 	"fmt"
 	"github.com/promet/git-appraise/commands/input"
+	"github.com/promet/git-appraise/commands/output"
 	"github.com/promet/git-appraise/repository"
 	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/blind"
 	"github.com/promet/git-appraise/review/comment"
+	"path/filepath"
 	"strings"
 )
 
@@ -37,8 +40,41 @@ var (
 	commentLine        = commentFlagSet.Uint("l", 0, "Line being commented upon; requires that the -f flag also be set")
 	commentLgtm        = commentFlagSet.Bool("lgtm", false, "'Looks Good To Me'. Set this to express your approval. This cannot be combined with nmw")
 	commentNmw         = commentFlagSet.Bool("nmw", false, "'Needs More Work'. Set this to express your disapproval. This cannot be combined with lgtm")
+	commentSign        = commentFlagSet.Bool("sign", false, "Sign the comment using the GPG key configured as user.signingkey")
+	commentSuggestion  = commentFlagSet.String("suggestion", "", "Take a suggested change, as a patch in the unified diff format, from the given file. Use - to read the patch from the standard input")
+	commentAttach      = commentFlagSet.String("attach", "", "Comma-separated list of small files, such as screenshots, to attach to the comment")
+	commentEdit        = commentFlagSet.String("edit", "", "Hash of one of your earlier comments to replace the message (and suggested change) of")
+	commentDelete      = commentFlagSet.String("delete", "", "Hash of one of your earlier comments to retract")
+	commentPrivate     = commentFlagSet.Bool("private", false, "Encrypt the message (and suggested change) to the GPG keys of the requester, the reviewers, and yourself, so that only they can read it")
+	commentEncryptTo   = commentFlagSet.String("encrypt-to", "", "Comma-separated list of GPG users or age keys to encrypt the message (and suggested change) to, so that only they can read it")
 )
 
+// commentResult is the JSON output of the commands that add a comment to a review.
+type commentResult struct {
+	Review  string          `json:"review"`
+	Hash    string          `json:"hash"`
+	Comment comment.Comment `json:"comment"`
+}
+
+// addComment adds the given comment to the review, and reports it if JSON output was requested.
+func addComment(command string, r *review.Review, c comment.Comment) error {
+	if err := r.AddComment(c); err != nil {
+		return err
+	}
+	if !JSONOutput {
+		return nil
+	}
+	hash, err := c.Hash()
+	if err != nil {
+		return err
+	}
+	return output.PrintJSONResult(command, commentResult{
+		Review:  r.Revision,
+		Hash:    hash,
+		Comment: c,
+	})
+}
+
 // commentHashExists checks if the given comment hash exists in the given comment threads.
 func commentHashExists(hashToFind string, threads []review.CommentThread) bool {
 	for _, thread := range threads {
@@ -65,6 +101,104 @@ func checkCommentLocation(repo repository.Repo, commit, file string, line uint)
 	return nil
 }
 
+// ageIdentityFile returns the path of the user's age identity, which decrypts the private comments encrypted to their age key.
+func ageIdentityFile(repo repository.Repo) (string, error) {
+	return getConfigValue(repo, "ageIdentity")
+}
+
+// privateRecipients returns the recipients that a new comment should be encrypted to, or nil if it is not private.
+//
+// Replies to a private comment are encrypted to the same recipients, unless others are given.
+// The recipients are listed in the clear, so a pseudonymous commenter is left out of them.
+func privateRecipients(r *review.Review, userEmail string, parent *comment.Comment) []string {
+	if *commentEncryptTo != "" {
+		return splitValues(*commentEncryptTo)
+	}
+	if *commentPrivate {
+		var recipients []string
+		for _, recipient := range append([]string{r.Request.Requester, userEmail}, r.Request.Reviewers...) {
+			if recipient != "" && !blind.IsPseudonym(recipient) && !containsValue(recipients, recipient) {
+				recipients = append(recipients, recipient)
+			}
+		}
+		return recipients
+	}
+	if parent != nil && parent.Encrypted != nil {
+		return parent.Encrypted.Recipients
+	}
+	return nil
+}
+
+// reviseComment edits or deletes one of the user's earlier comments on the given review.
+func reviseComment(repo repository.Repo, r *review.Review) error {
+	if *commentEdit != "" && *commentDelete != "" {
+		return errors.New("You cannot combine the flags -edit and -delete.")
+	}
+	if *commentParent != "" || *commentFile != "" || *commentLgtm || *commentNmw {
+		return errors.New("Editing or deleting a comment cannot change its parent, location, or status.")
+	}
+	original := *commentEdit + *commentDelete
+	thread := r.FindThread(original)
+	if thread == nil {
+		return errors.New("There is no matching comment.")
+	}
+	userEmail, err := commentAuthor(repo, r, *commentSign)
+	if err != nil {
+		return err
+	}
+	if thread.Comment.Author != userEmail {
+		return errors.New("You can only edit or delete your own comments.")
+	}
+
+	if thread.Comment.Encrypted != nil {
+		// Decrypt the current version, so that its suggested change can be carried over.
+		identity, err := ageIdentityFile(repo)
+		if err != nil {
+			return err
+		}
+		r.DecryptComments(identity)
+	}
+
+	revision := comment.NewRevision(userEmail, original, "")
+	if *commentDelete != "" {
+		revision.Deleted = true
+	} else {
+		// Unless a new one is given, the suggested change is carried over from the current version.
+		revision.Suggestion = thread.Latest().Suggestion
+		if *commentSuggestion != "" {
+			revision.Suggestion, err = input.FromFile(*commentSuggestion)
+			if err != nil {
+				return err
+			}
+		}
+		if *commentMessageFile != "" && *commentMessage == "" {
+			*commentMessage, err = input.FromFile(*commentMessageFile)
+			if err != nil {
+				return err
+			}
+		}
+		if *commentMessageFile == "" && *commentMessage == "" {
+			*commentMessage, err = input.LaunchEditor(repo, commentFilename)
+			if err != nil {
+				return err
+			}
+		}
+		revision.Description = *commentMessage
+		// Revisions of a private comment stay private to the same recipients, unless others are given.
+		if recipients := privateRecipients(r, userEmail, &thread.Comment); recipients != nil {
+			if err := revision.Encrypt(recipients); err != nil {
+				return err
+			}
+		}
+	}
+	if *commentSign {
+		if err := signMetadata(repo, &revision); err != nil {
+			return err
+		}
+	}
+	return addComment("comment", r, revision)
+}
+
 // commentOnReview adds a comment to the current code review.
 func commentOnReview(repo repository.Repo, args []string) error {
 	commentFlagSet.Parse(args)
@@ -77,16 +211,20 @@ func commentOnReview(repo repository.Repo, args []string) error {
 	}
 
 	if len(args) == 1 {
-		r, err = review.Get(repo, args[0])
+		r, err = getReview(repo, args[0])
 	} else {
 		r, err = review.GetCurrent(repo)
 	}
 
 	if err != nil {
-		return fmt.Errorf("Failed to load the review: %v\n", err)
+		return fmt.Errorf("Failed to load the review: %w\n", err)
 	}
 	if r == nil {
-		return errors.New("There is no matching review.")
+		return review.ErrReviewNotFound
+	}
+
+	if *commentEdit != "" || *commentDelete != "" {
+		return reviseComment(repo, r)
 	}
 
 	if *commentLgtm && *commentNmw {
@@ -99,6 +237,25 @@ func commentOnReview(repo repository.Repo, args []string) error {
 		return errors.New("There is no matching parent comment.")
 	}
 
+	var suggestion string
+	if *commentSuggestion != "" {
+		if *commentSuggestion == "-" && *commentMessageFile == "-" {
+			return errors.New("Only one of the comment message and the suggested change can be read from the standard input.")
+		}
+		suggestion, err = input.FromFile(*commentSuggestion)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(suggestion, "@@") {
+			return errors.New("The suggested change must be a patch in the unified diff format.")
+		}
+	}
+
+	attachments, err := readAttachments(repo, splitValues(*commentAttach))
+	if err != nil {
+		return err
+	}
+
 	if *commentMessageFile != "" && *commentMessage == "" {
 		*commentMessage, err = input.FromFile(*commentMessageFile)
 		if err != nil {
@@ -120,10 +277,12 @@ func commentOnReview(repo repository.Repo, args []string) error {
 		Commit: commentedUponCommit,
 	}
 	if *commentFile != "" {
-		if err := checkCommentLocation(r.Repo, commentedUponCommit, *commentFile, *commentLine); err != nil {
+		// Paths typed on Windows use backslashes, but git always uses forward slashes.
+		path := filepath.ToSlash(*commentFile)
+		if err := checkCommentLocation(r.Repo, commentedUponCommit, path, *commentLine); err != nil {
 			return fmt.Errorf("Unable to comment on the given location: %v", err)
 		}
-		location.Path = *commentFile
+		location.Path = path
 		if *commentLine != 0 {
 			location.Range = &comment.Range{
 				StartLine: uint32(*commentLine),
@@ -131,18 +290,30 @@ func commentOnReview(repo repository.Repo, args []string) error {
 		}
 	}
 
-	userEmail, err := repo.GetUserEmail()
+	userEmail, err := commentAuthor(repo, r, *commentSign)
 	if err != nil {
 		return err
 	}
 	c := comment.New(userEmail, *commentMessage)
 	c.Location = &location
 	c.Parent = *commentParent
+	c.Suggestion = suggestion
+	c.Attachments = attachments
 	if *commentLgtm || *commentNmw {
 		resolved := *commentLgtm
 		c.Resolved = &resolved
 	}
-	return r.AddComment(c)
+	if recipients := privateRecipients(r, userEmail, r.FindComment(*commentParent)); recipients != nil {
+		if err := c.Encrypt(recipients); err != nil {
+			return err
+		}
+	}
+	if *commentSign {
+		if err := signMetadata(repo, &c); err != nil {
+			return err
+		}
+	}
+	return addComment("comment", r, c)
 }
 
 // commentCmd defines the "comment" subcommand.
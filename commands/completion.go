@@ -0,0 +1,458 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"sort"
+	"strings"
+)
+
+// The completion scripts do not complete anything themselves; they pass the
+// words typed so far to "git appraise completion -complete", and offer the
+// candidates that it prints, one per line, each followed by a tab and a
+// description.
+
+const bashCompletionScript = `# bash completion for git-appraise; generated by "git appraise completion bash".
+#
+# Once loaded, this completes both "git appraise" and "git-appraise".
+
+_git_appraise ()
+{
+	local i start=1
+	if [ "${COMP_WORDS[0]##*/}" != git-appraise ]; then
+		for ((i = 1; i < COMP_CWORD; i++)); do
+			if [ "${COMP_WORDS[i]}" = appraise ]; then
+				start=$((i + 1))
+				break
+			fi
+		done
+	fi
+	local IFS=$'\n'
+	COMPREPLY=($(git appraise completion -complete -- "${COMP_WORDS[@]:start:COMP_CWORD-start+1}" 2>/dev/null | cut -f1))
+}
+
+complete -o default -F _git_appraise git-appraise
+`
+
+const zshCompletionScript = `#compdef git-appraise
+# zsh completion for git-appraise; generated by "git appraise completion zsh".
+#
+# Once loaded, this completes both "git appraise" and "git-appraise".
+
+_git-appraise () {
+	local -a completions
+	local candidate
+	for candidate in "${(@f)$(git appraise completion -complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}"; do
+		[[ -n $candidate ]] || continue
+		completions+=("${${candidate%%$'\t'*}//:/\\:}:${candidate#*$'\t'}")
+	done
+	if (( ${#completions} )); then
+		_describe 'git-appraise' completions
+	else
+		_files
+	fi
+}
+
+if [[ $funcstack[1] == _git-appraise ]]; then
+	_git-appraise "$@"
+else
+	compdef _git-appraise git-appraise
+fi
+`
+
+const fishCompletionScript = `# fish completion for git-appraise; generated by "git appraise completion fish".
+#
+# Once loaded, this completes both "git appraise" and "git-appraise".
+
+function __git_appraise_complete
+	set -l tokens (commandline -opc)
+	set -l current (commandline -ct)
+	while set -q tokens[1]
+		set -l token $tokens[1]
+		set -e tokens[1]
+		if test "$token" = appraise; or test (basename -- "$token") = git-appraise
+			break
+		end
+	end
+	git appraise completion -complete -- $tokens "$current" 2>/dev/null
+end
+
+complete -c git-appraise -f -a '(__git_appraise_complete)'
+complete -c git -n '__fish_seen_subcommand_from appraise' -f -a '(__git_appraise_complete)'
+`
+
+// completionScripts maps the name of each supported shell to its completion script.
+var completionScripts = map[string]string{
+	"bash": bashCompletionScript,
+	"fish": fishCompletionScript,
+	"zsh":  zshCompletionScript,
+}
+
+var completionFlagSet = flag.NewFlagSet("completion", flag.ExitOnError)
+
+var (
+	completionComplete = completionFlagSet.Bool("complete", false, "Instead of printing a script, print the candidates for completing the last of the given words; this is used by the scripts.")
+)
+
+// completionFlagSets maps each command that takes options to its flags.
+//
+// The commands with their own subcommands are listed in completionSubcommands instead.
+var completionFlagSets = map[string]*flag.FlagSet{
+	"abandon":           abandonFlagSet,
+	"accept":            acceptFlagSet,
+	"analyze":           analyzeFlagSet,
+	"apply-suggestion":  applySuggestionFlagSet,
+	"archive":           archiveFlagSet,
+	"assign":            assignFlagSet,
+	"away":              awayFlagSet,
+	"batch":             batchFlagSet,
+	"carry-forward":     carryForwardFlagSet,
+	"checklist":         checklistFlagSet,
+	"cherry-pick":       cherryPickFlagSet,
+	"ci":                ciFlagSet,
+	"comment":           commentFlagSet,
+	"completion":        completionFlagSet,
+	"config":            configFlagSet,
+	"diff":              diffFlagSet,
+	"export":            exportFlagSet,
+	"fsck":              fsckFlagSet,
+	"import":            importFlagSet,
+	"issues":            issuesFlagSet,
+	"label":             labelFlagSet,
+	"list":              listFlagSet,
+	"log":               logFlagSet,
+	"lsp":               lspFlagSet,
+	"migrate":           migrateFlagSet,
+	"notify":            notifyFlagSet,
+	"nudge":             nudgeFlagSet,
+	"publish":           publishFlagSet,
+	"queue":             queueFlagSet,
+	"react":             reactFlagSet,
+	"rebase":            rebaseFlagSet,
+	"redact":            redactFlagSet,
+	"reject":            rejectFlagSet,
+	"remap":             remapFlagSet,
+	"request":           requestFlagSet,
+	"rerequest":         rerequestFlagSet,
+	"rerun-ci":          rerunCIFlagSet,
+	"reveal":            revealFlagSet,
+	"search":            searchFlagSet,
+	"serve":             serveFlagSet,
+	"show":              showFlagSet,
+	"stats":             statsFlagSet,
+	"status":            statusFlagSet,
+	"submit":            submitFlagSet,
+	"suggest-reviewers": suggestReviewersFlagSet,
+	"transfer":          transferFlagSet,
+	"verify":            verifyFlagSet,
+	"viewed":            viewedFlagSet,
+	"watch":             watchFlagSet,
+	"web":               webFlagSet,
+}
+
+// completionSubcommands maps each command that has its own subcommands to them.
+var completionSubcommands = map[string]map[string]mirrorSystem{
+	"attachment": attachmentSubcommands,
+	"email":      emailSubcommands,
+	"hook":       hookSubcommands,
+	"mirror":     mirrorSystems,
+	"robot":      robotSubcommands,
+}
+
+// nonReviewArgCommands lists the commands whose arguments are not review hashes.
+var nonReviewArgCommands = map[string]bool{
+	"archive":    true,
+	"away":       true,
+	"completion": true,
+	"config":     true,
+	"export":     true,
+	"fsck":       true,
+	"import":     true,
+	"list":       true,
+	"lsp":        true,
+	"migrate":    true,
+	"notify":     true,
+	"nudge":      true,
+	"pull":       true,
+	"push":       true,
+	"queue":      true,
+	"remap":      true,
+	"search":     true,
+	"serve":      true,
+	"stats":      true,
+	"watch":      true,
+	"web":        true,
+}
+
+// reviewerFlags lists the flags whose values are comma-separated lists of reviewers.
+var reviewerFlags = map[string]bool{
+	"author":   true,
+	"r":        true,
+	"reviewer": true,
+	"to":       true,
+}
+
+// reviewFlags lists the flags whose values are review hashes.
+var reviewFlags = map[string]bool{
+	"depends-on": true,
+}
+
+// completionCandidate is a single possible completion of a word, along with a description of it.
+type completionCandidate struct {
+	Value       string
+	Description string
+}
+
+// filterCandidates returns the given candidates that start with the given prefix, sorted by value.
+func filterCandidates(candidates []completionCandidate, prefix string) []completionCandidate {
+	var result []completionCandidate
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate.Value, prefix) && !seen[candidate.Value] {
+			seen[candidate.Value] = true
+			result = append(result, candidate)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Value < result[j].Value })
+	return result
+}
+
+// commandCandidates returns the names of all of the commands.
+func commandCandidates() []completionCandidate {
+	candidates := []completionCandidate{{Value: "help", Description: "Show the usage of a command"}}
+	for name := range CommandMap {
+		candidates = append(candidates, completionCandidate{Value: name})
+	}
+	return candidates
+}
+
+// isBoolFlag reports whether the given flag is a boolean, which does not take a value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface {
+		IsBoolFlag() bool
+	})
+	return ok && b.IsBoolFlag()
+}
+
+// flagCandidates returns the options in the given flag set.
+func flagCandidates(flags *flag.FlagSet) []completionCandidate {
+	var candidates []completionCandidate
+	if flags == nil {
+		return candidates
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		candidates = append(candidates, completionCandidate{Value: "-" + f.Name, Description: f.Usage})
+	})
+	return candidates
+}
+
+// firstLine returns the first line of the given text.
+func firstLine(text string) string {
+	return strings.SplitN(strings.TrimSpace(text), "\n", 2)[0]
+}
+
+// reviewCandidates returns the hashes of the reviews starting with the given prefix.
+//
+// If the prefix is empty, then only the open reviews are returned, as those
+// are the ones that are usually wanted.
+func reviewCandidates(repo repository.Repo, prefix string) []completionCandidate {
+	if repo == nil {
+		return nil
+	}
+	var reviews []review.Summary
+	if prefix == "" {
+		reviews = review.ListOpen(repo)
+	} else {
+		reviews = review.ListAll(repo)
+	}
+	var candidates []completionCandidate
+	for _, r := range reviews {
+		candidates = append(candidates, completionCandidate{
+			Value:       r.Revision,
+			Description: firstLine(r.Request.Description),
+		})
+	}
+	return filterCandidates(candidates, prefix)
+}
+
+// reviewerCandidates returns everyone who has requested, been assigned, or commented on a review, plus the configured reviewers.
+//
+// The word being completed may be a comma-separated list, in which case only
+// its last element is completed.
+func reviewerCandidates(repo repository.Repo, word string) []completionCandidate {
+	if repo == nil {
+		return nil
+	}
+	var reviewers []string
+	if email, err := repo.GetUserEmail(); err == nil {
+		reviewers = append(reviewers, email)
+	}
+	for _, setting := range []string{"reviewers", "defaultReviewers"} {
+		if values, err := getConfigList(repo, setting); err == nil {
+			reviewers = append(reviewers, values...)
+		}
+	}
+	for _, r := range review.ListAll(repo) {
+		reviewers = append(reviewers, r.Request.Requester)
+		reviewers = append(reviewers, r.Request.Reviewers...)
+		for _, thread := range r.Comments {
+			reviewers = appendCommentAuthors(reviewers, thread)
+		}
+	}
+	listPrefix := ""
+	if i := strings.LastIndex(word, ","); i >= 0 {
+		listPrefix = word[:i+1]
+	}
+	var candidates []completionCandidate
+	for _, reviewer := range reviewers {
+		if reviewer != "" {
+			candidates = append(candidates, completionCandidate{Value: listPrefix + reviewer})
+		}
+	}
+	return filterCandidates(candidates, word)
+}
+
+// appendCommentAuthors appends the authors of the given thread and of its replies.
+func appendCommentAuthors(authors []string, thread review.CommentThread) []string {
+	authors = append(authors, thread.Comment.Author)
+	for _, child := range thread.Children {
+		authors = appendCommentAuthors(authors, child)
+	}
+	return authors
+}
+
+// completeWords returns the candidates for completing the last of the given command line arguments.
+//
+// The repo may be nil, in which case only the commands and options are completed.
+func completeWords(repo repository.Repo, words []string) []completionCandidate {
+	for len(words) > 1 && (words[0] == "-json" || words[0] == "--json") {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	if len(words) == 1 {
+		return filterCandidates(commandCandidates(), current)
+	}
+	command := words[0]
+	if command == "help" {
+		if len(words) == 2 {
+			return filterCandidates(commandCandidates(), current)
+		}
+		return nil
+	}
+	args := words[1 : len(words)-1]
+	flags := completionFlagSets[command]
+	reviewArgs := !nonReviewArgCommands[command]
+	if subcommands, ok := completionSubcommands[command]; ok {
+		if len(args) == 0 {
+			var candidates []completionCandidate
+			for name, subcommand := range subcommands {
+				candidates = append(candidates, completionCandidate{Value: name, Description: subcommand.Usage})
+			}
+			return filterCandidates(candidates, current)
+		}
+		subcommand, ok := subcommands[args[0]]
+		if !ok {
+			return nil
+		}
+		args = args[1:]
+		flags = subcommand.Flags
+		reviewArgs = strings.Contains(subcommand.Usage, "<review-hash>")
+	}
+
+	if len(args) > 0 && flags != nil {
+		if f := flags.Lookup(strings.TrimLeft(args[len(args)-1], "-")); f != nil && strings.HasPrefix(args[len(args)-1], "-") && !isBoolFlag(f) {
+			switch {
+			case reviewerFlags[f.Name]:
+				return reviewerCandidates(repo, current)
+			case reviewFlags[f.Name]:
+				return reviewCandidates(repo, current)
+			}
+			return nil
+		}
+	}
+	if strings.HasPrefix(current, "-") {
+		return filterCandidates(flagCandidates(flags), current)
+	}
+	switch command {
+	case "completion":
+		var candidates []completionCandidate
+		for shell := range completionScripts {
+			candidates = append(candidates, completionCandidate{Value: shell})
+		}
+		return filterCandidates(candidates, current)
+	case "config":
+		var candidates []completionCandidate
+		for _, setting := range configSettings {
+			candidates = append(candidates, completionCandidate{Value: setting.Name, Description: setting.Description})
+		}
+		return filterCandidates(candidates, current)
+	}
+	if reviewArgs {
+		return reviewCandidates(repo, current)
+	}
+	return nil
+}
+
+// completion prints the completion script for a shell, or the candidates for completing a command line.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func completion(repo repository.Repo, args []string) error {
+	completionFlagSet.Parse(args)
+	args = completionFlagSet.Args()
+	if *completionComplete {
+		for _, candidate := range completeWords(repo, args) {
+			fmt.Printf("%s\t%s\n", candidate.Value, firstLine(candidate.Description))
+		}
+		return nil
+	}
+	if len(args) != 1 {
+		return errors.New("The completion command requires the name of a shell: \"bash\", \"zsh\", or \"fish\".")
+	}
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		return fmt.Errorf("Unsupported shell %q.", args[0])
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// completionCmd defines the "completion" subcommand.
+//
+// Its RunMethod is set in init, since completing the names of the commands
+// refers back to the CommandMap.
+var completionCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s completion (bash|zsh|fish)\n\n", arg0)
+		fmt.Printf("Prints a script that completes the commands, options, review hashes, and reviewers.\n")
+		fmt.Printf("For example, add the following to your ~/.bashrc:\n\n")
+		fmt.Printf("    source <(%s completion bash)\n", arg0)
+	},
+	NoSync: true,
+	NoRepo: true,
+}
+
+func init() {
+	completionCmd.RunMethod = completion
+}
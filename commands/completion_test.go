@@ -0,0 +1,55 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"testing"
+)
+
+func candidateValues(candidates []completionCandidate) []string {
+	var values []string
+	for _, candidate := range candidates {
+		values = append(values, candidate.Value)
+	}
+	return values
+}
+
+func TestCompleteWords(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	if values := candidateValues(completeWords(repo, []string{"-json", "su"})); len(values) != 2 || values[0] != "submit" || values[1] != "suggest-reviewers" {
+		t.Fatalf("Unexpected command completions: %v", values)
+	}
+	if values := candidateValues(completeWords(repo, []string{"request", "-depends"})); len(values) != 1 || values[0] != "-depends-on" {
+		t.Fatalf("Unexpected flag completions: %v", values)
+	}
+	if values := candidateValues(completeWords(repo, []string{"request", "-r", "alice@example.com,oj"})); len(values) != 1 || values[0] != "alice@example.com,ojarjur" {
+		t.Fatalf("Unexpected reviewer completions: %v", values)
+	}
+	if values := candidateValues(completeWords(repo, []string{"archive", "-before", ""})); len(values) != 0 {
+		t.Fatalf("Unexpectedly completed the value of a date flag: %v", values)
+	}
+	if values := candidateValues(completeWords(repo, []string{"show", "-json", ""})); len(values) == 0 {
+		t.Fatal("Failed to complete any review hashes")
+	}
+	if values := candidateValues(completeWords(repo, []string{"hook", "un"})); len(values) != 1 || values[0] != "uninstall" {
+		t.Fatalf("Unexpected subcommand completions: %v", values)
+	}
+	if values := candidateValues(completeWords(nil, []string{"completion", "z"})); len(values) != 1 || values[0] != "zsh" {
+		t.Fatalf("Unexpected shell completions: %v", values)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/namespace"
+	"strconv"
+	"strings"
+)
+
+const configPrefix = "appraise."
+
+var configFlagSet = flag.NewFlagSet("config", flag.ExitOnError)
+
+var (
+	configGlobal = configFlagSet.Bool("global", false, "Change the user's global git config, rather than the repo's, so that the setting applies to every repo.")
+	configUnset  = configFlagSet.Bool("unset", false, "Remove every value of the setting.")
+)
+
+// configSetting describes one of the git config settings that control the tool.
+type configSetting struct {
+	Name        string
+	Description string
+	MultiValued bool
+}
+
+// configSettings lists every setting, without its "appraise." prefix, in alphabetical order.
+var configSettings = []configSetting{
+	{Name: "ageIdentity", Description: "Path of the age identity file that decrypts the private comments encrypted to your age key"},
+	{Name: "approvalLevels", Description: "Condition levels of the approvals that count toward submitting a review (approved, approved-with-nits)", MultiValued: true},
+	{Name: "assign", Description: "Strategy used to pick reviewers from the pool (round-robin or load)"},
+	{Name: "autoPush", Description: "Remote (or \"true\" for origin) that notes are pushed to after every command that changes them"},
+	{Name: "autoRequest", Description: "Patterns of the branch names for which a review is requested when they are first pushed", MultiValued: true},
+	{Name: "autoRequestTarget", Description: "Target ref of automatically requested reviews"},
+	{Name: "autoSync", Description: "Remote (or \"true\" for origin) that notes are pulled from and pushed to around every command"},
+	{Name: "blindAdmins", Description: "GPG users or age keys of the administrators who can reveal the reviewers of blind reviews", MultiValued: true},
+	{Name: "blindSecret", Description: "Secret from which your pseudonyms on blind reviews are derived; generated the first time that you comment on one"},
+	{Name: "coverageThreshold", Description: "Minimum code coverage percentage required to submit a review"},
+	{Name: "defaultReviewers", Description: "Reviewers of new review requests that do not name any", MultiValued: true},
+	{Name: "defaultTarget", Description: "Target ref of new review requests that do not name one"},
+	{Name: "diffIgnoreMoved", Description: "Whether colored diffs dim the lines of code that were only moved, so that the changes stand out (true or false; defaults to false)"},
+	{Name: "diffIgnoreWhitespace", Description: "Whitespace changes that diffs leave out (all, change for changes in the amount of whitespace, or none; defaults to none)"},
+	{Name: "diffSyntax", Description: "Whether colored diffs highlight the code on each line by the language of its file (true or false; defaults to true)"},
+	{Name: "diffWords", Description: "Whether colored diffs highlight the words that changed within each changed line (true or false; defaults to true)"},
+	{Name: "issueTracker", Description: "Issue trackers that the issue IDs of reviews link to", MultiValued: true},
+	{Name: "notesNamespace", Description: "Notes refs (such as refs/notes/devtools/teamA) under which this project's reviews are kept, separately from those of the other projects in a monorepo"},
+	{Name: "output", Description: "Default output format (text or json)"},
+	{Name: "pager", Description: "Pager for diffs, instead of the one configured for git; \"cat\" disables paging"},
+	{Name: "pusherVariable", Description: "Environment variable that identifies the pusher to the update hook of a central server; approvals may then only be pushed by their authors"},
+	{Name: "redactors", Description: "Users who may redact the comments of others, and not only their own", MultiValued: true},
+	{Name: "reviewers", Description: "Pool of reviewers that may be automatically assigned", MultiValued: true},
+	{Name: "slaFirstResponse", Description: "How soon after they are requested reviews should be responded to by a reviewer (e.g. 24h or 2d), as checked by \"nudge\" and \"stats\""},
+	{Name: "slaResolution", Description: "How soon after they are requested reviews should be submitted (e.g. 5d), as checked by \"nudge\" and \"stats\""},
+	{Name: "staleApprovals", Description: "Whether approvals stop counting once a new revision of the review is pushed, unless carried forward (true or false)"},
+	{Name: "submit", Description: "Default submit strategy (merge, rebase, squash, or fast-forward)"},
+	{Name: "workspace", Description: "Paths of the repos whose reviews \"list -recursive\" lists, relative to this one", MultiValued: true},
+}
+
+// findConfigSetting returns the setting with the given name, which may include the "appraise." prefix.
+func findConfigSetting(name string) (*configSetting, error) {
+	name = strings.TrimPrefix(name, configPrefix)
+	for i := range configSettings {
+		if strings.EqualFold(configSettings[i].Name, name) {
+			return &configSettings[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown setting %q. Run \"git appraise config\" to list the settings.", name)
+}
+
+// getConfigValue returns the single value of the given setting, or an empty string if it is not set.
+func getConfigValue(repo repository.Repo, name string) (string, error) {
+	values, err := repo.GetConfig(configPrefix + name)
+	if err != nil || len(values) == 0 {
+		return "", err
+	}
+	return values[len(values)-1], nil
+}
+
+// getConfigBool returns the value of the given boolean setting, or the given default if it is not set.
+func getConfigBool(repo repository.Repo, name string, defaultValue bool) (bool, error) {
+	value, err := getConfigValue(repo, name)
+	if err != nil || value == "" {
+		return defaultValue, err
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid value %q for %s%s; expected true or false.", value, configPrefix, name)
+	}
+	return enabled, nil
+}
+
+// getConfigList returns the values of the given multi-valued setting, each of which may hold a comma-separated list.
+func getConfigList(repo repository.Repo, name string) ([]string, error) {
+	values, err := repo.GetConfig(configPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, value := range values {
+		result = append(result, splitValues(value)...)
+	}
+	return result, nil
+}
+
+// isFlagSet reports whether the named flag was passed on the command line.
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// applyRequestConfig fills in the target and reviewers of a new review request from the configured defaults.
+func applyRequestConfig(repo repository.Repo) error {
+	if !isFlagSet(requestFlagSet, "target") {
+		target, err := getConfigValue(repo, "defaultTarget")
+		if err != nil {
+			return err
+		}
+		if target != "" {
+			*requestTarget = qualifyRef(target)
+		}
+	}
+	if !isFlagSet(requestFlagSet, "r") {
+		reviewers, err := getConfigList(repo, "defaultReviewers")
+		if err != nil {
+			return err
+		}
+		*requestReviewers = strings.Join(reviewers, ",")
+	}
+	return nil
+}
+
+// applyOutputConfig switches to JSON output if that is configured as the default.
+func applyOutputConfig(repo repository.Repo) error {
+	if JSONOutput {
+		return nil
+	}
+	format, err := getConfigValue(repo, "output")
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(format) {
+	case "", "text":
+	case "json":
+		JSONOutput = true
+	default:
+		return fmt.Errorf("Invalid value %q for appraise.output; expected text or json.", format)
+	}
+	return nil
+}
+
+// notesPrefix is the prefix of the notes refs that the reviews are kept under.
+var notesPrefix = namespace.DefaultPrefix
+
+// applyNotesNamespace returns the view of the repo in which the review notes are
+// kept under the notes refs of the namespace selected by the "-namespace" flag, or
+// else by the "appraise.notesNamespace" setting.
+func applyNotesNamespace(repo repository.Repo) (repository.Repo, error) {
+	name := NotesNamespace
+	if name == "" {
+		var err error
+		if name, err = getConfigValue(repo, "notesNamespace"); err != nil || name == "" {
+			return repo, err
+		}
+	}
+	prefix, err := namespace.ParsePrefix(name)
+	if err != nil {
+		return nil, err
+	}
+	notesPrefix = prefix
+	return namespace.NewRepo(repo, prefix), nil
+}
+
+// notesRef returns the name of the ref that the given notes ref is kept in, within the selected namespace.
+func notesRef(ref string) string {
+	return namespace.Ref(notesPrefix, ref)
+}
+
+// configResult is the JSON output of the "config" subcommand.
+type configResult struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Values      []string `json:"values"`
+}
+
+// printConfig prints the values of the given settings.
+func printConfig(repo repository.Repo, settings []configSetting) error {
+	var results []configResult
+	for _, setting := range settings {
+		values, err := repo.GetConfig(configPrefix + setting.Name)
+		if err != nil {
+			return err
+		}
+		results = append(results, configResult{
+			Name:        configPrefix + setting.Name,
+			Description: setting.Description,
+			Values:      values,
+		})
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("config", results)
+	}
+	if len(results) == 1 {
+		for _, value := range results[0].Values {
+			fmt.Println(value)
+		}
+		return nil
+	}
+	for _, result := range results {
+		if len(result.Values) == 0 {
+			fmt.Printf("%s (not set)\n    %s\n", result.Name, result.Description)
+		} else {
+			fmt.Printf("%s = %s\n    %s\n", result.Name, strings.Join(result.Values, ", "), result.Description)
+		}
+	}
+	return nil
+}
+
+// configure shows or changes the settings that control the tool.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func configure(repo repository.Repo, args []string) error {
+	configFlagSet.Parse(args)
+	args = configFlagSet.Args()
+	if len(args) == 0 {
+		if *configUnset {
+			return errors.New("The --unset flag requires the name of a setting.")
+		}
+		return printConfig(repo, configSettings)
+	}
+	setting, err := findConfigSetting(args[0])
+	if err != nil {
+		return err
+	}
+	values := args[1:]
+	if *configUnset {
+		if len(values) > 0 {
+			return errors.New("The --unset flag does not take any values.")
+		}
+		return repo.SetConfig(configPrefix+setting.Name, *configGlobal)
+	}
+	if len(values) == 0 {
+		return printConfig(repo, []configSetting{*setting})
+	}
+	if len(values) > 1 && !setting.MultiValued {
+		return fmt.Errorf("The setting %q only takes a single value.", configPrefix+setting.Name)
+	}
+	return repo.SetConfig(configPrefix+setting.Name, *configGlobal, values...)
+}
+
+// configCmd defines the "config" subcommand.
+var configCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s config [<setting>]\n", arg0)
+		fmt.Printf("       %s config [-global] <setting> <value>...\n", arg0)
+		fmt.Printf("       %s config [-global] -unset <setting>\n\nOptions:\n", arg0)
+		configFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return configure(repo, args)
+	},
+	NoSync: true,
+}
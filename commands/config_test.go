@@ -0,0 +1,61 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"reflect"
+	"testing"
+)
+
+func TestConfigure(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	if err := configure(repo, []string{"appraise.defaultReviewers", "alice@example.com,bob@example.com", "carol@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	reviewers, err := getConfigList(repo, "defaultReviewers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reviewers, []string{"alice@example.com", "bob@example.com", "carol@example.com"}) {
+		t.Fatalf("Unexpected default reviewers: %v", reviewers)
+	}
+	if err := configure(repo, []string{"defaulttarget", "release"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := configure(repo, []string{"defaultTarget", "release", "master"}); err == nil {
+		t.Fatal("Unexpectedly set multiple values of a single-valued setting")
+	}
+	if err := configure(repo, []string{"noSuchSetting", "value"}); err == nil {
+		t.Fatal("Unexpectedly set an unknown setting")
+	}
+
+	requestFlagSet.Parse(nil)
+	if err := applyRequestConfig(repo); err != nil {
+		t.Fatal(err)
+	}
+	if *requestTarget != "refs/heads/release" {
+		t.Fatalf("The default target was not applied: %q", *requestTarget)
+	}
+
+	if err := configure(repo, []string{"-unset", "defaultReviewers"}); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := repo.GetConfig("appraise.defaultReviewers"); len(values) != 0 {
+		t.Fatalf("The setting was not unset: %v", values)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"os"
+	"strings"
+)
+
+var diffFlagSet = flag.NewFlagSet("diff", flag.ExitOnError)
+
+var (
+	diffSince       = diffFlagSet.Int("since", 0, "Number of the patchset to compare against; defaults to the latest patchset that you had seen when you last commented")
+	diffList        = diffFlagSet.Bool("list", false, "List the patchsets of the review instead of showing a diff")
+	diffOptions     = diffFlagSet.String("diff-opts", "", "Options to pass to the diff tool")
+	diffWhitespace  = diffFlagSet.String("ignore-whitespace", "", "Whitespace changes to leave out of the diff: \"all\", \"change\" for changes in the amount of whitespace, or \"none\"; defaults to the \"appraise.diffIgnoreWhitespace\" setting")
+	diffIgnoreMoved = diffFlagSet.Bool("ignore-moved", false, "Dim the lines of code that were only moved, so that the changes stand out; defaults to the \"appraise.diffIgnoreMoved\" setting")
+	diffColor       = diffFlagSet.String("color", "auto", "Whether to color the diff: \"always\", \"never\", or \"auto\" to only color output to a terminal")
+	diffPager       = diffFlagSet.Bool("pager", true, "Page the diff using the pager configured for git, when writing to a terminal")
+	diffSideBySide  = diffFlagSet.Bool("side-by-side", false, "Show the diff in two columns, with the patchset on the left and the current head on the right")
+)
+
+// diffResult is the JSON output of the "diff" subcommand.
+type diffResult struct {
+	Review    string `json:"review"`
+	Since     int    `json:"since"`
+	Commit    string `json:"commit"`
+	Interdiff bool   `json:"interdiff"`
+	Diff      string `json:"diff"`
+}
+
+// diffReview prints the changes made to a review since one of its earlier patchsets.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func diffReview(repo repository.Repo, args []string) error {
+	diffFlagSet.Parse(args)
+	args = diffFlagSet.Args()
+
+	if len(args) > 1 {
+		return errors.New("Only diffing a single review is supported.")
+	}
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+	if *diffList {
+		if JSONOutput {
+			return output.PrintJSONResult("diff", r.Request.Patchsets)
+		}
+		output.PrintPatchsets(r.Summary)
+		return nil
+	}
+
+	since := *diffSince
+	if since == 0 {
+		userEmail, err := repo.GetUserEmail()
+		if err != nil {
+			return err
+		}
+		if since = r.LastReviewedPatchset(userEmail); since == 0 {
+			return errors.New("You have not commented on the review yet; use --since to pick a patchset to compare against.")
+		}
+	}
+	patchset, err := r.GetPatchset(since)
+	if err != nil {
+		return err
+	}
+	whitespaceArgs, err := whitespaceDiffArgs(repo, *diffWhitespace)
+	if err != nil {
+		return err
+	}
+	diffArgs := append([]string{"--no-color"}, whitespaceArgs...)
+	if *diffOptions != "" {
+		diffArgs = append(diffArgs, strings.Split(*diffOptions, ",")...)
+	}
+	diff, interdiff, err := r.GetPatchsetDiff(*patchset, diffArgs...)
+	if err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("diff", diffResult{
+			Review:    r.Revision,
+			Since:     since,
+			Commit:    patchset.Commit,
+			Interdiff: interdiff,
+			Diff:      diff,
+		})
+	}
+	if diff == "" {
+		fmt.Printf("There are no changes since patchset %d.\n", since)
+		return nil
+	}
+
+	threads, err := r.RemapComments()
+	if err != nil {
+		return err
+	}
+	remapped := *r
+	remapped.Comments = threads
+	style, err := diffStyle(repo, *diffColor, diffFlagSet, *diffIgnoreMoved)
+	if err != nil {
+		return err
+	}
+	var width int
+	if *diffSideBySide {
+		width = terminalWidth()
+	}
+	printDiff := func() error {
+		fmt.Printf("Changes since patchset %d (%.12s):\n", since, patchset.Commit)
+		if !interdiff {
+			fmt.Println("The patchset could not be reapplied onto the current base, so this includes changes from the target ref.")
+		}
+		fmt.Println()
+		if *diffSideBySide {
+			return output.PrintSideBySideDiff(&remapped, diff, patchset.Commit, style, width)
+		}
+		return output.PrintInlineDiff(&remapped, diff, style)
+	}
+	if *diffPager && isTerminal(os.Stdout) {
+		return runWithPager(repo, printDiff)
+	}
+	return printDiff()
+}
+
+// diffCmd defines the "diff" subcommand.
+var diffCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s diff [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		diffFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return diffReview(repo, args)
+	},
+}
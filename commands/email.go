@@ -0,0 +1,144 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/mirror/email"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"io"
+	"os"
+)
+
+var emailExportFlagSet = flag.NewFlagSet("email export", flag.ExitOnError)
+
+var (
+	emailExportOutput = emailExportFlagSet.String("o", "", "File to write the mbox to; defaults to standard output")
+)
+
+var emailImportFlagSet = flag.NewFlagSet("email import", flag.ExitOnError)
+
+// emailExport writes a review as an mbox-formatted patch series, suitable for sending with git send-email.
+func emailExport(repo repository.Repo, args []string) error {
+	emailExportFlagSet.Parse(args)
+	args = emailExportFlagSet.Args()
+
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return errors.New("Only exporting a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return errors.New("There is no current review.")
+	}
+
+	var w io.Writer = os.Stdout
+	if *emailExportOutput != "" {
+		f, err := os.Create(*emailExportOutput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return email.Export(w, r)
+}
+
+// emailImport adds the replies in an mbox file as comments on the reviews they reply to.
+func emailImport(repo repository.Repo, args []string) error {
+	emailImportFlagSet.Parse(args)
+	args = emailImportFlagSet.Args()
+	if len(args) != 1 {
+		return errors.New("Importing email requires exactly one mbox file.")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	results, err := email.Import(repo, f)
+	if err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("email", results)
+	}
+	for _, result := range results {
+		summary := fmt.Sprintf("review %.12s: imported %d comments", result.Review, result.Imported)
+		if result.Error != "" {
+			summary += ": " + result.Error
+		}
+		fmt.Println(summary)
+	}
+	return nil
+}
+
+// emailSubcommands defines the operations supported by the "email" subcommand.
+var emailSubcommands = map[string]mirrorSystem{
+	"export": {
+		Usage: "export [-o <file>] [<review-hash>]",
+		Flags: emailExportFlagSet,
+		Run:   emailExport,
+	},
+	"import": {
+		Usage: "import <mbox-file>",
+		Flags: emailImportFlagSet,
+		Run:   emailImport,
+	},
+}
+
+// emailReviews dispatches to the email operation named by the first argument.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func emailReviews(repo repository.Repo, args []string) error {
+	if len(args) < 1 {
+		return errors.New("The email command requires either \"export\" or \"import\".")
+	}
+	subcommand, ok := emailSubcommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown email operation %q.", args[0])
+	}
+	return subcommand.Run(repo, args[1:])
+}
+
+// emailCmd defines the "email" subcommand.
+var emailCmd = &Command{
+	Usage: func(arg0 string) {
+		for _, name := range []string{"export", "import"} {
+			subcommand := emailSubcommands[name]
+			fmt.Printf("Usage: %s email %s\n\nOptions:\n", arg0, subcommand.Usage)
+			subcommand.Flags.PrintDefaults()
+			fmt.Println()
+		}
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return emailReviews(repo, args)
+	},
+}
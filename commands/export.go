@@ -0,0 +1,142 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/bundle"
+	"github.com/promet/git-appraise/web"
+	"io/ioutil"
+)
+
+var exportFlagSet = flag.NewFlagSet("export", flag.ExitOnError)
+
+var (
+	exportFormat     = exportFlagSet.String("format", "html", "Format of the export; \"html\" renders a static website, and \"json\" writes a bundle that can be imported into another repo")
+	exportOut        = exportFlagSet.String("out", "", "Directory (for html) or file (for json) into which the reviews are exported; json bundles default to the standard output")
+	exportSideBySide = exportFlagSet.Bool("side-by-side", false, "Show the diff of each review in two columns, rather than as a unified diff; only for the html format")
+)
+
+// exportResult is the JSON output of the "export" subcommand when the reviews are written to a file or directory.
+type exportResult struct {
+	Format string `json:"format"`
+	Out    string `json:"out"`
+	// Reviews is the number of reviews in an exported bundle.
+	Reviews int `json:"reviews,omitempty"`
+}
+
+// exportBundle writes the notes of the given reviews, or of every review if none are given, as a JSON bundle.
+func exportBundle(repo repository.Repo, revisions []string) error {
+	var reviews []*review.Review
+	if len(revisions) == 0 {
+		var err error
+		if reviews, err = review.DetailsAll(repo, review.ListAll(repo)); err != nil {
+			return err
+		}
+	}
+	for _, revision := range revisions {
+		// The notes are keyed by full commit hashes, which abbreviated ones may not identify in another repo.
+		resolved, err := resolveReview(repo, revision)
+		if err != nil {
+			return err
+		}
+		hash, err := repo.GetCommitHash(resolved)
+		if err != nil {
+			return fmt.Errorf("Could not find a commit named %q", revision)
+		}
+		r, err := review.Get(repo, hash)
+		if err != nil {
+			return fmt.Errorf("Failed to load the review: %w\n", err)
+		}
+		if r == nil {
+			return fmt.Errorf("There is no review for %q.", revision)
+		}
+		reviews = append(reviews, r)
+	}
+	b, err := bundle.New(reviews)
+	if err != nil {
+		return err
+	}
+	data, err := b.Write()
+	if err != nil {
+		return err
+	}
+	if *exportOut == "" {
+		if JSONOutput {
+			return output.PrintJSONResult("export", json.RawMessage(data))
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	if err := ioutil.WriteFile(*exportOut, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("export", exportResult{Format: "json", Out: *exportOut, Reviews: len(b.Reviews)})
+	}
+	fmt.Printf("Exported %d reviews to %s\n", len(b.Reviews), *exportOut)
+	return nil
+}
+
+// exportReviews exports the reviews in the repo.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func exportReviews(repo repository.Repo, args []string) error {
+	exportFlagSet.Parse(args)
+	args = exportFlagSet.Args()
+	switch *exportFormat {
+	case "html":
+		if len(args) > 0 {
+			return errors.New("The html format always exports every review, so it does not take any arguments.")
+		}
+		if *exportOut == "" {
+			return errors.New("The --out flag is required for the html format.")
+		}
+		if err := web.Export(repo, *exportOut, *exportSideBySide); err != nil {
+			return err
+		}
+		if JSONOutput {
+			return output.PrintJSONResult("export", exportResult{Format: "html", Out: *exportOut})
+		}
+		fmt.Printf("Exported the reviews to %s\n", *exportOut)
+		return nil
+	case "json":
+		if *exportSideBySide {
+			return errors.New("The --side-by-side flag can only be used with the html format.")
+		}
+		return exportBundle(repo, args)
+	}
+	return fmt.Errorf("Unsupported export format %q.", *exportFormat)
+}
+
+// exportCmd defines the "export" subcommand.
+var exportCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s export [-format html] -out <dir>\n", arg0)
+		fmt.Printf("       %s export -format json [-out <file>] [<review-hash>...]\n\nOptions:\n", arg0)
+		exportFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return exportReviews(repo, args)
+	},
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/fsck"
+)
+
+var fsckFlagSet = flag.NewFlagSet("fsck", flag.ExitOnError)
+
+var (
+	fsckFix = fsckFlagSet.Bool("fix", false, "Repair the problems that are found, by removing the notes of missing objects and the offending lines of the others.")
+)
+
+// fsckResult is the JSON output of the "fsck" subcommand.
+type fsckResult struct {
+	Problems []fsck.Problem `json:"problems"`
+	Fixed    bool           `json:"fixed"`
+}
+
+// checkNotes checks the review notes for orphaned, malformed, duplicate, and dangling metadata.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func checkNotes(repo repository.Repo, args []string) error {
+	fsckFlagSet.Parse(args)
+	if fsckFlagSet.NArg() > 0 {
+		return errors.New("The fsck command does not take any arguments.")
+	}
+	problems, err := fsck.Check(repo)
+	if err != nil {
+		return err
+	}
+	fixed := *fsckFix && len(problems) > 0
+	if fixed {
+		if err := fsck.Fix(repo, problems); err != nil {
+			return fmt.Errorf("Failed to fix the review notes: %v", err)
+		}
+	}
+	if JSONOutput {
+		if err := output.PrintJSONResult("fsck", fsckResult{Problems: problems, Fixed: fixed}); err != nil {
+			return err
+		}
+		if len(problems) > 0 && !fixed {
+			return ErrReported
+		}
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if fixed {
+		fmt.Printf("Fixed %d problems.\n", len(problems))
+	} else if len(problems) > 0 {
+		return fmt.Errorf("Found %d problems; run with -fix to repair them.", len(problems))
+	}
+	return nil
+}
+
+// fsckCmd defines the "fsck" subcommand.
+var fsckCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s fsck [<option>...]\n\nOptions:\n", arg0)
+		fsckFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return checkNotes(repo, args)
+	},
+}
@@ -0,0 +1,488 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/approval"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/policy"
+	"github.com/promet/git-appraise/review/schema"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hookMarker identifies the hook scripts written by "hook install", so that they can be safely replaced or removed.
+const hookMarker = "# Installed by git-appraise; see \"git appraise hook\"."
+
+// prePushHook is the contents of the pre-push hook script.
+const prePushHook = "#!/bin/sh\n" + hookMarker + "\nexec git appraise hook run pre-push \"$@\"\n"
+
+// updateHook is the contents of the update hook script, which a central server runs for every pushed ref.
+const updateHook = "#!/bin/sh\n" + hookMarker + "\nexec git appraise hook run update \"$@\"\n"
+
+// hookScripts maps the name of every hook that can be installed to the contents of its script.
+var hookScripts = map[string]string{
+	"pre-push": prePushHook,
+	"update":   updateHook,
+}
+
+// nullCommit is the hash that git passes to the pre-push and update hooks for a ref that does not exist.
+const nullCommit = "0000000000000000000000000000000000000000"
+
+var hookInstallFlagSet = flag.NewFlagSet("hook install", flag.ExitOnError)
+
+var hookUninstallFlagSet = flag.NewFlagSet("hook uninstall", flag.ExitOnError)
+
+var hookRunFlagSet = flag.NewFlagSet("hook run", flag.ExitOnError)
+
+var (
+	hookInstallForce = hookInstallFlagSet.Bool("force", false, "Replace an existing hook that was not installed by git-appraise")
+)
+
+// hookPath returns the path of the named hook script in the given repo.
+func hookPath(repo repository.Repo, name string) (string, error) {
+	return gitDirPath(repo, filepath.Join("hooks", name))
+}
+
+// hookName returns the name of the hook named by the given arguments, which defaults to the pre-push hook.
+func hookName(args []string) (string, error) {
+	if len(args) > 1 {
+		return "", errors.New("Only one hook may be specified.")
+	}
+	if len(args) == 0 {
+		return "pre-push", nil
+	}
+	if _, ok := hookScripts[args[0]]; !ok {
+		return "", fmt.Errorf("Unknown hook %q.", args[0])
+	}
+	return args[0], nil
+}
+
+// hookInstall writes the named hook script, or the pre-push one if none is named.
+func hookInstall(repo repository.Repo, args []string) error {
+	hookInstallFlagSet.Parse(args)
+	name, err := hookName(hookInstallFlagSet.Args())
+	if err != nil {
+		return err
+	}
+	hook, err := hookPath(repo, name)
+	if err != nil {
+		return err
+	}
+	if existing, err := ioutil.ReadFile(hook); err == nil && !strings.Contains(string(existing), hookMarker) && !*hookInstallForce {
+		return fmt.Errorf("A %s hook already exists at %q. Use --force to replace it.", name, hook)
+	}
+	if err := os.MkdirAll(filepath.Dir(hook), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(hook, []byte(hookScripts[name]), 0755); err != nil {
+		return err
+	}
+	fmt.Printf("Installed the %s hook at %q\n", name, hook)
+	return nil
+}
+
+// hookUninstall removes the named hook script, or the pre-push one if none is named, if it was written by hookInstall.
+func hookUninstall(repo repository.Repo, args []string) error {
+	hookUninstallFlagSet.Parse(args)
+	name, err := hookName(hookUninstallFlagSet.Args())
+	if err != nil {
+		return err
+	}
+	hook, err := hookPath(repo, name)
+	if err != nil {
+		return err
+	}
+	existing, err := ioutil.ReadFile(hook)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(existing), hookMarker) {
+		return fmt.Errorf("The %s hook at %q was not installed by git-appraise.", name, hook)
+	}
+	return os.Remove(hook)
+}
+
+// pushedRef is a single ref update that git passes to the pre-push hook.
+type pushedRef struct {
+	LocalRef     string
+	LocalCommit  string
+	RemoteRef    string
+	RemoteCommit string
+}
+
+// parsePushedRefs parses the ref updates that git writes to the standard input of the pre-push hook.
+func parsePushedRefs(in io.Reader) ([]pushedRef, error) {
+	var refs []pushedRef
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("Malformed pre-push hook input %q", scanner.Text())
+		}
+		refs = append(refs, pushedRef{fields[0], fields[1], fields[2], fields[3]})
+	}
+	return refs, scanner.Err()
+}
+
+// matchesAnyPattern returns whether or not the branch name matches any of the given patterns.
+func matchesAnyPattern(branch string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, branch); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// autoRequest requests a review of a newly pushed branch, unless it already has an open review.
+func autoRequest(repo repository.Repo, ref pushedRef, openReviews []review.Summary) error {
+	for _, summary := range openReviews {
+		if summary.Request.ReviewRef == ref.LocalRef {
+			return nil
+		}
+	}
+	message, err := repo.GetCommitMessage(ref.LocalCommit)
+	if err != nil {
+		return err
+	}
+	args := []string{"-source", ref.LocalRef, "-allow-uncommitted", "-m", message}
+	target, err := repo.GetAutoRequestTarget()
+	if err != nil {
+		return err
+	}
+	if target != "" {
+		args = append(args, "-target", target)
+	}
+	return requestReview(repo, args)
+}
+
+// hookRunPrePush implements the pre-push hook.
+//
+// For every branch being pushed for the first time whose name matches one of
+// the patterns in "appraise.autoRequest", a review is requested. The review
+// notes are then pushed to the same remote. Since there is no corresponding
+// post-push hook, this happens before the branches themselves are pushed.
+//
+// Failures are reported, but do not prevent the push from proceeding.
+func hookRunPrePush(repo repository.Repo, remote string, in io.Reader) error {
+	refs, err := parsePushedRefs(in)
+	if err != nil {
+		return err
+	}
+	patterns, err := repo.GetAutoRequestPatterns()
+	if err != nil {
+		return err
+	}
+	pushesBranch := false
+	openReviews := review.ListOpen(repo)
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.LocalRef, "refs/heads/") || ref.LocalCommit == nullCommit {
+			continue
+		}
+		pushesBranch = true
+		branch := strings.TrimPrefix(ref.LocalRef, "refs/heads/")
+		if ref.RemoteCommit != nullCommit || !matchesAnyPattern(branch, patterns) {
+			continue
+		}
+		if err := autoRequest(repo, ref, openReviews); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to request a review of %q: %v\n", branch, err)
+		}
+	}
+	// Pushing the notes runs this hook again, so they are only pushed alongside
+	// branches, to avoid endlessly pushing them from within their own push.
+	if !pushesBranch {
+		return nil
+	}
+	if err := push(repo, []string{remote}); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to push the review notes to %q: %v\n", remote, err)
+	}
+	return nil
+}
+
+// violationCheck returns descriptions of the notes among the given ones, keyed by the
+// revisions of their reviews, that the approval policy does not permit.
+type violationCheck func(repo repository.Repo, added map[string][]repository.Note, pusher string) ([]string, error)
+
+// sortedRevisions returns the revisions that the given notes annotate, in order.
+func sortedRevisions(added map[string][]repository.Note) []string {
+	var revisions []string
+	for revision := range added {
+		revisions = append(revisions, revision)
+	}
+	sort.Strings(revisions)
+	return revisions
+}
+
+// loadHookReview returns the review with the given revision, and the identities read from its target ref,
+// or a nil review if the repo does not know about it.
+func loadHookReview(repo repository.Repo, revision string) (*review.Review, *identity.Map, error) {
+	r, err := review.Get(repo, revision)
+	if err != nil && !errors.Is(err, review.ErrReviewNotFound) {
+		return nil, nil, err
+	}
+	if r == nil {
+		return nil, nil, nil
+	}
+	target, err := repo.ResolveRefCommit(r.Request.TargetRef)
+	if err != nil {
+		return nil, nil, err
+	}
+	identities, err := identity.Load(repo, target)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, identities, nil
+}
+
+// approvalViolations returns descriptions of the approvals among the given comments, keyed by the
+// revisions of their reviews, that the approval policy does not permit.
+//
+// Approvals may only be given by an owner of one of the paths that their review
+// changes; see policy.MayApprove. The policy is read from the target ref of each
+// review, as it is on the server. If a pusher is given, then approvals may also
+// only be given by the pusher themselves. Approvals of reviews that the repo does
+// not know about cannot be checked, and so are not permitted either. Neither are
+// redactions that carry a resolution, which is never taken from a redaction.
+func approvalViolations(repo repository.Repo, added map[string][]repository.Note, pusher string) ([]string, error) {
+	var violations []string
+	for _, revision := range sortedRevisions(added) {
+		var approvals []comment.Comment
+		for _, c := range comment.ParseAllValid(added[revision]) {
+			if redaction := c.Redaction; redaction != nil && redaction.Comment != nil && redaction.Comment.Resolved != nil {
+				violations = append(violations, fmt.Sprintf("The redaction by %s of a comment on the review %.12s carries a resolution.", c.Author, revision))
+			}
+			if c.Resolved != nil && *c.Resolved {
+				approvals = append(approvals, c)
+			}
+		}
+		if len(approvals) == 0 {
+			continue
+		}
+		sort.SliceStable(approvals, func(i, j int) bool {
+			return schema.CompareTimestamps(approvals[i].Timestamp, approvals[j].Timestamp) < 0
+		})
+		r, identities, err := loadHookReview(repo, revision)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			violations = append(violations, fmt.Sprintf("The review %.12s does not exist yet; push its request before approving it.", revision))
+			continue
+		}
+		target, err := repo.ResolveRefCommit(r.Request.TargetRef)
+		if err != nil {
+			return nil, err
+		}
+		p, err := policy.Load(repo, target)
+		if err != nil {
+			return nil, err
+		}
+		var paths []string
+		if p != nil {
+			if paths, err = policy.ChangedPaths(r); err != nil {
+				return nil, err
+			}
+		}
+		for _, c := range approvals {
+			if pusher != "" && !identities.Same(pusher, c.Author) {
+				violations = append(violations, fmt.Sprintf("%s cannot approve the review %.12s on behalf of %s.", pusher, revision, c.Author))
+			} else if p != nil && !p.MayApprove(c.Author, paths) {
+				violations = append(violations, fmt.Sprintf("%s does not own any of the paths changed by the review %.12s, as listed in %s.", c.Author, revision, p.File))
+			}
+		}
+	}
+	return violations, nil
+}
+
+// markViolations returns descriptions of the approval marks among the given notes, keyed by
+// the revisions of their reviews, that the approval policy does not permit.
+//
+// If a pusher is given, then marks may only be made by the pusher themselves.
+// Approvals may only be carried forward by their own authors. Marks of reviews
+// that the repo does not know about are not permitted.
+func markViolations(repo repository.Repo, added map[string][]repository.Note, pusher string) ([]string, error) {
+	var violations []string
+	for _, revision := range sortedRevisions(added) {
+		marks := approval.ParseAllValid(added[revision])
+		if len(marks) == 0 {
+			continue
+		}
+		sort.SliceStable(marks, func(i, j int) bool {
+			return schema.CompareTimestamps(marks[i].Timestamp, marks[j].Timestamp) < 0
+		})
+		r, identities, err := loadHookReview(repo, revision)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			violations = append(violations, fmt.Sprintf("The review %.12s does not exist yet; push its request before marking its approvals.", revision))
+			continue
+		}
+		for _, mark := range marks {
+			if pusher != "" && !identities.Same(pusher, mark.Author) {
+				violations = append(violations, fmt.Sprintf("%s cannot mark the approval %.12s of the review %.12s as %s on behalf of %s.", pusher, mark.Approval, revision, mark.Status, mark.Author))
+			} else if thread := r.FindThread(mark.Approval); thread != nil && mark.Status == approval.StatusCarriedForward && !identities.Same(thread.Comment.Author, mark.Author) {
+				violations = append(violations, fmt.Sprintf("%s cannot carry forward the approval %.12s of the review %.12s, which was given by %s.", mark.Author, mark.Approval, revision, thread.Comment.Author))
+			}
+		}
+	}
+	return violations, nil
+}
+
+// hookChecks maps each of the notes refs that the approval policy depends on, without the
+// notes prefix, to the check of the notes added to it.
+var hookChecks = map[string]violationCheck{
+	comment.Ref:  approvalViolations,
+	approval.Ref: markViolations,
+}
+
+// hookRunUpdate implements the update hook, which a central server runs before updating each pushed ref.
+//
+// Updates of the notes refs that the approval policy depends on (the discussion
+// notes, and the marks of stale and carried-forward approvals) which add notes
+// that the policy does not permit are rejected; see hookChecks. The pusher is
+// read from the environment variable named by "appraise.pusherVariable", if it
+// is set, as set by the server's authentication.
+func hookRunUpdate(repo repository.Repo, ref, oldCommit, newCommit string) error {
+	var check violationCheck
+	for checkedRef, refCheck := range hookChecks {
+		if ref == notesRef(checkedRef) {
+			check = refCheck
+		}
+	}
+	if check == nil || newCommit == nullCommit {
+		return nil
+	}
+	if oldCommit == nullCommit {
+		oldCommit = ""
+	}
+	added, err := repo.GetAddedNotes(oldCommit, newCommit)
+	if err != nil {
+		return err
+	}
+	pusherVariable, err := getConfigValue(repo, "pusherVariable")
+	if err != nil {
+		return err
+	}
+	var pusher string
+	if pusherVariable != "" {
+		if pusher = os.Getenv(pusherVariable); pusher == "" {
+			return fmt.Errorf("The pusher could not be identified, as %s is not set.", pusherVariable)
+		}
+	}
+	violations, err := check(repo, added, pusher)
+	if err != nil {
+		return err
+	}
+	for _, violation := range violations {
+		fmt.Fprintln(os.Stderr, violation)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("Rejected the update of %q, as it adds %d notes that are not permitted.", ref, len(violations))
+	}
+	return nil
+}
+
+// hookRun runs the named hook, as invoked by the script written by hookInstall.
+func hookRun(repo repository.Repo, args []string) error {
+	hookRunFlagSet.Parse(args)
+	args = hookRunFlagSet.Args()
+	if len(args) < 1 {
+		return errors.New("The name of the hook to run must be specified.")
+	}
+	switch args[0] {
+	case "pre-push":
+		if len(args) < 2 {
+			return errors.New("The pre-push hook requires the name of the remote.")
+		}
+		return hookRunPrePush(repo, args[1], os.Stdin)
+	case "update":
+		if len(args) != 4 {
+			return errors.New("The update hook requires the name of the ref, and its old and new commits.")
+		}
+		return hookRunUpdate(repo, args[1], args[2], args[3])
+	}
+	return fmt.Errorf("Unknown hook %q.", args[0])
+}
+
+// hookSubcommands defines all of the operations on the git hooks.
+var hookSubcommands = map[string]mirrorSystem{
+	"install": {
+		Usage: "install [-force] [pre-push|update]",
+		Flags: hookInstallFlagSet,
+		Run:   hookInstall,
+	},
+	"uninstall": {
+		Usage: "uninstall [pre-push|update]",
+		Flags: hookUninstallFlagSet,
+		Run:   hookUninstall,
+	},
+	"run": {
+		Usage: "run (pre-push <remote> [<url>] | update <ref> <old> <new>)",
+		Flags: hookRunFlagSet,
+		Run:   hookRun,
+	},
+}
+
+// hookReviews dispatches to the hook operation named by the first argument.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func hookReviews(repo repository.Repo, args []string) error {
+	if len(args) < 1 {
+		return errors.New("The hook command requires one of \"install\", \"uninstall\", or \"run\".")
+	}
+	subcommand, ok := hookSubcommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown hook operation %q.", args[0])
+	}
+	return subcommand.Run(repo, args[1:])
+}
+
+// hookCmd defines the "hook" subcommand.
+var hookCmd = &Command{
+	Usage: func(arg0 string) {
+		for _, name := range []string{"install", "uninstall", "run"} {
+			subcommand := hookSubcommands[name]
+			fmt.Printf("Usage: %s hook %s\n\nOptions:\n", arg0, subcommand.Usage)
+			subcommand.Flags.PrintDefaults()
+			fmt.Println()
+		}
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return hookReviews(repo, args)
+	},
+	NoSync: true,
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/approval"
+	"github.com/promet/git-appraise/review/comment"
+	"strings"
+	"testing"
+)
+
+func TestParsePushedRefs(t *testing.T) {
+	input := "refs/heads/feature/x abc refs/heads/feature/x " + nullCommit + "\n\nrefs/heads/master def refs/heads/master 123\n"
+	refs, err := parsePushedRefs(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].LocalRef != "refs/heads/feature/x" || refs[0].RemoteCommit != nullCommit || refs[1].LocalCommit != "def" {
+		t.Fatalf("Unexpected pushed refs: %+v", refs)
+	}
+	if _, err := parsePushedRefs(strings.NewReader("refs/heads/master def\n")); err == nil {
+		t.Fatal("Failed to reject malformed hook input")
+	}
+}
+
+func TestMatchesAnyPattern(t *testing.T) {
+	patterns := []string{"feature/*", "fix-*"}
+	for branch, expected := range map[string]bool{
+		"feature/login":   true,
+		"fix-crash":       true,
+		"feature/a/b":     false,
+		"master":          false,
+		"feature-nothing": false,
+	} {
+		if matched := matchesAnyPattern(branch, patterns); matched != expected {
+			t.Errorf("Unexpected match result for %q: %v", branch, matched)
+		}
+	}
+}
+
+func TestApprovalViolations(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	approved := true
+	approval := comment.New("ojarjur", "LGTM")
+	approval.Resolved = &approved
+	note, err := approval.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fyi, err := comment.New("bob@example.com", "FYI").Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := map[string][]repository.Note{
+		repository.TestCommitB: {note, fyi},
+		repository.TestCommitA: {fyi},
+	}
+	if violations, err := approvalViolations(repo, added, ""); err != nil || len(violations) != 0 {
+		t.Fatalf("Unexpected violations without a policy: %v, %v", violations, err)
+	}
+	if violations, err := approvalViolations(repo, added, "bob@example.com"); err != nil || len(violations) != 1 || !strings.Contains(violations[0], "on behalf of ojarjur") {
+		t.Fatalf("Unexpected violations for another pusher: %v, %v", violations, err)
+	}
+	added = map[string][]repository.Note{repository.TestCommitA: {note}}
+	if violations, err := approvalViolations(repo, added, ""); err != nil || len(violations) != 1 || !strings.Contains(violations[0], "does not exist") {
+		t.Fatalf("Unexpected violations for an unknown review: %v, %v", violations, err)
+	}
+}
+
+func TestApprovalViolationsOrder(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	approved := true
+	var notes []repository.Note
+	for author, timestamp := range map[string]string{
+		"later@example.com":   "1700000000",
+		"earlier@example.com": "2016-01-02T15:04:05Z",
+	} {
+		c := comment.New(author, "LGTM")
+		c.Timestamp = timestamp
+		c.Resolved = &approved
+		note, err := c.Write()
+		if err != nil {
+			t.Fatal(err)
+		}
+		notes = append(notes, note)
+	}
+	added := map[string][]repository.Note{repository.TestCommitB: notes}
+	violations, err := approvalViolations(repo, added, "bob@example.com")
+	if err != nil || len(violations) != 2 {
+		t.Fatalf("Unexpected violations: %v, %v", violations, err)
+	}
+	if !strings.Contains(violations[0], "earlier@example.com") || !strings.Contains(violations[1], "later@example.com") {
+		t.Errorf("The violations are not in the order of the approvals: %v", violations)
+	}
+}
+
+func TestRedactionResolutionViolations(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	resolved := true
+	redaction := comment.NewRedaction("mallory@example.com", "0123456789abcdef0123456789abcdef01234567", comment.New("boss@example.com", "LGTM"), "")
+	redaction.Redaction.Comment.Resolved = &resolved
+	note, err := redaction.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := map[string][]repository.Note{repository.TestCommitB: {note}}
+	if violations, err := approvalViolations(repo, added, ""); err != nil || len(violations) != 1 || !strings.Contains(violations[0], "carries a resolution") {
+		t.Fatalf("Unexpected violations for a redaction with a resolution: %v, %v", violations, err)
+	}
+}
+
+func TestMarkViolations(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	note, err := approval.New("ojarjur", "0123456789abcdef0123456789abcdef01234567", repository.TestCommitC, approval.StatusCarriedForward).Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := map[string][]repository.Note{repository.TestCommitB: {note}}
+	if violations, err := markViolations(repo, added, "ojarjur"); err != nil || len(violations) != 0 {
+		t.Fatalf("Unexpected violations for a mark by the pusher: %v, %v", violations, err)
+	}
+	if violations, err := markViolations(repo, added, "bob@example.com"); err != nil || len(violations) != 1 || !strings.Contains(violations[0], "on behalf of ojarjur") {
+		t.Fatalf("Unexpected violations for another pusher: %v, %v", violations, err)
+	}
+	added = map[string][]repository.Note{repository.TestCommitA: {note}}
+	if violations, err := markViolations(repo, added, ""); err != nil || len(violations) != 1 || !strings.Contains(violations[0], "does not exist") {
+		t.Fatalf("Unexpected violations for an unknown review: %v, %v", violations, err)
+	}
+}
+
+func TestHookChecksEveryPolicyRef(t *testing.T) {
+	for _, ref := range []string{comment.Ref, approval.Ref} {
+		if hookChecks[ref] == nil {
+			t.Errorf("The update hook does not check the notes pushed to %q", ref)
+		}
+	}
+}
+
+func TestCarryForwardMarkViolations(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	approved := true
+	c := comment.New("bob@example.com", "LGTM")
+	c.Resolved = &approved
+	note, err := c.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(comment.Ref, repository.TestCommitB, note); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := c.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for author, expected := range map[string]int{"bob@example.com": 0, "ojarjur": 1} {
+		mark, err := approval.New(author, hash, repository.TestCommitC, approval.StatusCarriedForward).Write()
+		if err != nil {
+			t.Fatal(err)
+		}
+		added := map[string][]repository.Note{repository.TestCommitB: {mark}}
+		if violations, err := markViolations(repo, added, ""); err != nil || len(violations) != expected {
+			t.Errorf("Unexpected violations for carrying forward an approval by %s: %v, %v", author, violations, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/input"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/bundle"
+)
+
+var importFlagSet = flag.NewFlagSet("import", flag.ExitOnError)
+
+// importReviews imports the reviews from a JSON bundle written by "export -format json".
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func importReviews(repo repository.Repo, args []string) error {
+	importFlagSet.Parse(args)
+	args = importFlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("Only importing a single bundle is supported.")
+	}
+	filename := "-"
+	if len(args) == 1 {
+		filename = args[0]
+	}
+	data, err := input.FromFile(filename)
+	if err != nil {
+		return err
+	}
+	b, err := bundle.Parse([]byte(data))
+	if err != nil {
+		return err
+	}
+	if err := b.Import(repo); err != nil {
+		return err
+	}
+	revisions := []string{}
+	for _, r := range b.Reviews {
+		revisions = append(revisions, r.Revision)
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("import", revisions)
+	}
+	fmt.Printf("Imported %d reviews.\n", len(revisions))
+	return nil
+}
+
+// importCmd defines the "import" subcommand.
+var importCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s import [<bundle-file>]\n\nReads the bundle from the standard input if no file is given.\n", arg0)
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return importReviews(repo, args)
+	},
+}
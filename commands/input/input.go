@@ -24,13 +24,15 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 )
 
 // LaunchEditor launches the default editor configured for the given repo. This
 // method blocks until the editor command has returned.
 //
 // The specified filename should be a temporary file and provided as a relative path
-// from the repo (e.g. "FILENAME" will be converted to ".git/FILENAME"). This file
+// from the repo's git directory (e.g. "FILENAME" will be converted to ".git/FILENAME"). This file
 // will be deleted after the editor is closed and its contents have been read.
 //
 // This method returns the text that was read from the temporary file, or
@@ -41,7 +43,11 @@ func LaunchEditor(repo repository.Repo, fileName string) (string, error) {
 		return "", fmt.Errorf("Unable to detect default git editor: %v\n", err)
 	}
 
-	path := fmt.Sprintf("%s/.git/%s", repo.GetPath(), fileName)
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(gitDir, fileName)
 
 	cmd, err := startInlineCommand(editor, path)
 	if err != nil {
@@ -49,7 +55,7 @@ func LaunchEditor(repo repository.Repo, fileName string) (string, error) {
 		// the editor string is not a path to an executable, but rather
 		// a shell command (e.g. "emacsclient --tty"). As such, we'll try
 		// to run the command through bash, and if that fails, try with sh
-		args := []string{"-c", fmt.Sprintf("%s %q", editor, path)}
+		args := []string{"-c", editor + " " + shellQuote(filepath.ToSlash(path))}
 		cmd, err = startInlineCommand("bash", args...)
 		if err != nil {
 			cmd, err = startInlineCommand("sh", args...)
@@ -69,7 +75,32 @@ func LaunchEditor(repo repository.Repo, fileName string) (string, error) {
 		return "", fmt.Errorf("Error reading edited file: %v\n", err)
 	}
 	os.Remove(path)
-	return string(output), err
+	// Editors on Windows may save the file with CRLF line endings, which should not end up in the notes.
+	return strings.Replace(string(output), "\r\n", "\n", -1), err
+}
+
+// shellQuote quotes the given argument for a POSIX shell, so that it is passed on unchanged.
+//
+// Unlike Go's quoting, this leaves backslashes (as in Windows paths) and
+// dollar signs alone.
+func shellQuote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// LaunchEditorWithText launches the default editor configured for the given
+// repo, on a temporary file that initially holds the given text.
+//
+// The fileName parameter is interpreted in the same way as for LaunchEditor.
+func LaunchEditorWithText(repo repository.Repo, fileName, text string) (string, error) {
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(gitDir, fileName)
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		return "", fmt.Errorf("Error writing the file to edit: %v\n", err)
+	}
+	return LaunchEditor(repo, fileName)
 }
 
 // FromFile loads and returns the contents of a given file. If - is passed
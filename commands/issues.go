@@ -0,0 +1,106 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/issue"
+	"github.com/promet/git-appraise/review/request"
+	"strconv"
+	"time"
+)
+
+var issuesFlagSet = flag.NewFlagSet("issues", flag.ExitOnError)
+
+var (
+	issuesAdd    = issuesFlagSet.String("add", "", "Comma-separated list of issues to link to the review")
+	issuesRemove = issuesFlagSet.String("remove", "", "Comma-separated list of issues to unlink from the review")
+)
+
+// issuesReview prints the issues linked to a review, or links and unlinks issues if requested.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func issuesReview(repo repository.Repo, args []string) error {
+	issuesFlagSet.Parse(args)
+	args = issuesFlagSet.Args()
+
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return errors.New("Only linking issues to a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+
+	if *issuesAdd != "" || *issuesRemove != "" {
+		r.Request.Issues = updateLabels(r.Request.Issues, splitLabels(*issuesAdd), splitLabels(*issuesRemove))
+		// The new request must sort after the current one, so it gets a fresh timestamp.
+		r.Request.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+		r.Request.Signature = ""
+		note, err := r.Request.Write()
+		if err != nil {
+			return err
+		}
+		if err := repo.AppendNote(request.Ref, r.Revision, note); err != nil {
+			return err
+		}
+	}
+	trackers, err := issue.LoadTrackers(repo)
+	if err != nil {
+		return err
+	}
+	issues := issue.Expand(trackers, r.Request.Issues)
+	if JSONOutput {
+		if issues == nil {
+			issues = []issue.Issue{}
+		}
+		return output.PrintJSONResult("issues", issues)
+	}
+	for _, i := range issues {
+		if i.URL != "" {
+			fmt.Printf("%s %s\n", i.ID, i.URL)
+		} else {
+			fmt.Println(i.ID)
+		}
+	}
+	return nil
+}
+
+// issuesCmd defines the "issues" subcommand.
+var issuesCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s issues [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		issuesFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return issuesReview(repo, args)
+	},
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/request"
+	"strconv"
+	"time"
+)
+
+var labelFlagSet = flag.NewFlagSet("label", flag.ExitOnError)
+
+var (
+	labelAdd    = labelFlagSet.String("add", "", "Comma-separated list of labels to add to the review")
+	labelRemove = labelFlagSet.String("remove", "", "Comma-separated list of labels to remove from the review")
+)
+
+// updateLabels returns the given labels, with the added ones appended and the removed ones dropped.
+func updateLabels(labels, added, removed []string) []string {
+	removedSet := make(map[string]bool)
+	for _, label := range removed {
+		removedSet[label] = true
+	}
+	seen := make(map[string]bool)
+	var result []string
+	for _, label := range append(labels, added...) {
+		if !removedSet[label] && !seen[label] {
+			seen[label] = true
+			result = append(result, label)
+		}
+	}
+	return result
+}
+
+// labelReview prints the labels of a review, or adds and removes labels if requested.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func labelReview(repo repository.Repo, args []string) error {
+	labelFlagSet.Parse(args)
+	args = labelFlagSet.Args()
+
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return errors.New("Only labeling a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+
+	if *labelAdd != "" || *labelRemove != "" {
+		r.Request.Labels = updateLabels(r.Request.Labels, splitLabels(*labelAdd), splitLabels(*labelRemove))
+		// The new request must sort after the current one, so it gets a fresh timestamp.
+		r.Request.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+		r.Request.Signature = ""
+		note, err := r.Request.Write()
+		if err != nil {
+			return err
+		}
+		if err := repo.AppendNote(request.Ref, r.Revision, note); err != nil {
+			return err
+		}
+	}
+	if JSONOutput {
+		labels := r.Request.Labels
+		if labels == nil {
+			labels = []string{}
+		}
+		return output.PrintJSONResult("label", labels)
+	}
+	for _, label := range r.Request.Labels {
+		fmt.Println(label)
+	}
+	return nil
+}
+
+// labelCmd defines the "label" subcommand.
+var labelCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s label [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		labelFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return labelReview(repo, args)
+	},
+}
@@ -0,0 +1,32 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpdateLabels(t *testing.T) {
+	labels := updateLabels([]string{"backend", "urgent"}, splitLabels("release-blocker, backend,"), splitLabels("urgent"))
+	if !reflect.DeepEqual(labels, []string{"backend", "release-blocker"}) {
+		t.Fatalf("Unexpected labels: %v", labels)
+	}
+	if labels := updateLabels([]string{"urgent"}, nil, []string{"urgent"}); len(labels) != 0 {
+		t.Fatalf("Failed to remove the only label: %v", labels)
+	}
+}
@@ -18,47 +18,452 @@ package commands
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/promet/git-appraise/commands/output"
 	"github.com/promet/git-appraise/repository"
 	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/archive"
+	"github.com/promet/git-appraise/review/away"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/schema"
+	"github.com/promet/git-appraise/search"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 )
 
 var listFlagSet = flag.NewFlagSet("list", flag.ExitOnError)
 
 var (
-	listAll        = listFlagSet.Bool("a", false, "List all reviews (not just the open ones).")
+	listAll        = listFlagSet.Bool("a", false, "List all reviews (not just the open ones), including the drafts of other users.")
 	listJSONOutput = listFlagSet.Bool("json", false, "Format the output as JSON")
+	listLabel      = listFlagSet.String("label", "", "Comma-separated list of labels; only list the reviews tagged with all of them.")
+	listAuthor     = listFlagSet.String("author", "", "Comma-separated list of requesters; only list the reviews requested by one of them.")
+	listReviewer   = listFlagSet.String("reviewer", "", "Comma-separated list of reviewers; only list the reviews assigned to, or commented on by, one of them.")
+	listStatus     = listFlagSet.String("status", "", "Comma-separated list of statuses (draft, pending, accepted, rejected, submitted, or abandoned); only list the reviews with one of them. This includes closed reviews.")
+	listRequested  = listFlagSet.String("since", "", "Only list the reviews requested since the given date (YYYY-MM-DD or RFC 3339), or duration ago (e.g. 36h or 7d).")
+	listSince      = listFlagSet.String("updated-since", "", "Only list the reviews updated since the given date (YYYY-MM-DD or RFC 3339), or duration ago (e.g. 36h or 7d).")
+	listTargetRef  = listFlagSet.String("target-ref", "", "Comma-separated list of refs; only list the reviews targeting one of them.")
+	listAny        = listFlagSet.Bool("any", false, "List the reviews matching any of the given filters, rather than all of them.")
+	listArchived   = listFlagSet.Bool("archived", false, "List the reviews that have been archived, rather than the ones that have not.")
+	listLimit      = listFlagSet.Int("limit", 0, "List at most the given number of reviews, printing each one as soon as it is loaded.")
+	listSkip       = listFlagSet.Int("skip", 0, "Skip the given number of the newest matching reviews, such as those listed by an earlier page.")
+	listRecursive  = listFlagSet.Bool("recursive", false, "List the reviews in every repo of the workspace, as configured by appraise.workspace, or else in the sibling repos of this one.")
+	listPlain      = listFlagSet.Bool("plain", false, "Print the descriptions as they were written, rather than rendering their Markdown.")
+	listFormat     = listFlagSet.String("format", "", "Print each review using the given Go template, or one of the built-in ones (oneline, short, or markdown), instead of the summary; prefix a path with @ to read the template from a file.")
 )
 
-// listReviews lists all extant reviews.
-// TODO(ojarjur): Add more flags for filtering the output (e.g. filtering by reviewer or status).
-func listReviews(repo repository.Repo, args []string) error {
-	listFlagSet.Parse(args)
-	var reviews []review.Summary
-	if *listAll {
-		reviews = review.ListAll(repo)
-		if !*listJSONOutput {
-			fmt.Printf("Loaded %d reviews:\n", len(reviews))
+// reviewFilter reports whether or not a review should be listed.
+type reviewFilter func(r *review.Summary) bool
+
+// splitValues splits a comma-separated list of flag values, dropping any empty entries.
+func splitValues(values string) []string {
+	return splitLabels(values)
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
 		}
-	} else {
-		reviews = review.ListOpen(repo)
-		if !*listJSONOutput {
-			fmt.Printf("Loaded %d open reviews:\n", len(reviews))
+	}
+	return false
+}
+
+// qualifyRef returns the fully qualified name of the given ref, treating unqualified names as branches.
+func qualifyRef(ref string) string {
+	if strings.HasPrefix(ref, "refs/") {
+		return ref
+	}
+	return "refs/heads/" + ref
+}
+
+// parseSince parses the value of the "since" or "updated-since" flag, relative to the given time.
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if strings.HasSuffix(since, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(since, "d")); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if duration, err := time.ParseDuration(since); err == nil {
+		return now.Add(-duration), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, since); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid time %q; expected a date (YYYY-MM-DD or RFC 3339) or a duration (e.g. 36h or 7d).", since)
+}
+
+// lastUpdated returns the timestamp of the latest request or comment in the review.
+func lastUpdated(r *review.Summary) int64 {
+	var latest int64
+	update := func(timestamp string) {
+		if t, err := schema.ParseTimestamp(timestamp); err == nil && t.Unix() > latest {
+			latest = t.Unix()
+		}
+	}
+	for _, req := range r.AllRequests {
+		update(req.Timestamp)
+	}
+	update(r.Request.Timestamp)
+	var visit func(threads []review.CommentThread)
+	visit = func(threads []review.CommentThread) {
+		for _, thread := range threads {
+			update(thread.Comment.Timestamp)
+			visit(thread.Children)
+		}
+	}
+	visit(r.Comments)
+	return latest
+}
+
+// containsIdentity reports whether any of the given identities belongs to the same person as the given one.
+func containsIdentity(identities *identity.Map, values []string, value string) bool {
+	for _, v := range values {
+		if identities.Same(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isReviewer reports whether the given user was asked to review, or has commented on, the review.
+func isReviewer(r *review.Summary, user string, identities *identity.Map) bool {
+	if containsIdentity(identities, r.Request.Reviewers, user) {
+		return true
+	}
+	var visit func(threads []review.CommentThread) bool
+	visit = func(threads []review.CommentThread) bool {
+		for _, thread := range threads {
+			if identities.Same(thread.Comment.Author, user) || visit(thread.Children) {
+				return true
+			}
+		}
+		return false
+	}
+	return visit(r.Comments)
+}
+
+// buildListFilters returns the filters selected by the flags passed to the "list" subcommand.
+//
+// Requesters and reviewers match the flags if they have any of the identities
+// of the given people. Reviewers who are away also match the flags if any of
+// the given people stands in for them.
+func buildListFilters(now time.Time, identities *identity.Map, statuses away.Statuses) ([]reviewFilter, error) {
+	var filters []reviewFilter
+	if *listLabel != "" {
+		labels := splitLabels(*listLabel)
+		filters = append(filters, func(r *review.Summary) bool {
+			return len(review.FilterByLabels([]review.Summary{*r}, labels)) == 1
+		})
+	}
+	if *listAuthor != "" {
+		authors := splitValues(*listAuthor)
+		filters = append(filters, func(r *review.Summary) bool {
+			return containsIdentity(identities, authors, r.Request.Requester)
+		})
+	}
+	if *listReviewer != "" {
+		reviewers := splitValues(*listReviewer)
+		for _, reviewer := range reviewers {
+			reviewers = append(reviewers, statuses.Delegators(reviewer, now)...)
 		}
+		filters = append(filters, func(r *review.Summary) bool {
+			for _, reviewer := range reviewers {
+				if isReviewer(r, reviewer, identities) {
+					return true
+				}
+			}
+			return false
+		})
 	}
-	if *listJSONOutput {
-		b, err := json.MarshalIndent(reviews, "", "  ")
+	if *listStatus != "" {
+		statuses := splitValues(*listStatus)
+		for _, status := range statuses {
+			if !containsValue([]string{"draft", "pending", "accepted", "rejected", "submitted", "abandoned"}, status) {
+				return nil, fmt.Errorf("Unknown review status %q.", status)
+			}
+		}
+		filters = append(filters, func(r *review.Summary) bool {
+			return containsValue(statuses, search.Status(r))
+		})
+	}
+	if *listSince != "" {
+		since, err := parseSince(*listSince, now)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, func(r *review.Summary) bool {
+			return lastUpdated(r) >= since.Unix()
+		})
+	}
+	if *listTargetRef != "" {
+		var refs []string
+		for _, ref := range splitValues(*listTargetRef) {
+			refs = append(refs, qualifyRef(ref))
+		}
+		filters = append(filters, func(r *review.Summary) bool {
+			return containsValue(refs, qualifyRef(r.Request.TargetRef))
+		})
+	}
+	return filters, nil
+}
+
+// applyListFilters returns the reviews that match all (or, if matchAny is set, any) of the given filters.
+func applyListFilters(reviews []review.Summary, filters []reviewFilter, matchAny bool) []review.Summary {
+	if len(filters) == 0 {
+		return reviews
+	}
+	var filtered []review.Summary
+	for i := range reviews {
+		matches := !matchAny
+		for _, filter := range filters {
+			if matchAny {
+				matches = matches || filter(&reviews[i])
+			} else {
+				matches = matches && filter(&reviews[i])
+			}
+		}
+		if matches {
+			filtered = append(filtered, reviews[i])
+		}
+	}
+	return filtered
+}
+
+// visitReviews calls visit with each of the reviews in the given repo that
+// were selected by the flags passed to the "list" subcommand, newest first,
+// until either visit returns false or the page selected by the "limit" flag is full.
+func visitReviews(repo repository.Repo, now time.Time, visit func(r review.Summary) bool) error {
+	if *listLimit < 0 || *listSkip < 0 {
+		return errors.New("The -limit and -skip flags must not be negative.")
+	}
+	identities, err := identity.Load(repo, "HEAD")
+	if err != nil {
+		return err
+	}
+	filters, err := buildListFilters(now, identities, away.Load(repo))
+	if err != nil {
+		return err
+	}
+	var requestedSince int64
+	if *listRequested != "" {
+		since, err := parseSince(*listRequested, now)
 		if err != nil {
 			return err
 		}
-		fmt.Println(string(b))
+		requestedSince = since.Unix()
+	}
+	source := repo
+	// Every archived review is closed, so there is no need to filter the open ones.
+	onlyOpen := !*listArchived && !*listAll
+	userEmail := ""
+	if *listArchived {
+		source = archive.NewRepo(repo)
+	} else if onlyOpen {
+		if userEmail, err = repo.GetUserEmail(); err != nil {
+			return err
+		}
+	}
+	skipped, visited := 0, 0
+	return review.StreamContext(repo.Context(), source, requestedSince, func(r review.Summary) bool {
+		if onlyOpen {
+			// Filtering by status may select closed reviews, so only skip those if it is not given.
+			if (*listStatus == "" && !r.IsOpen()) || len(review.FilterDrafts([]review.Summary{r}, userEmail)) == 0 {
+				return true
+			}
+		}
+		if len(applyListFilters([]review.Summary{r}, filters, *listAny)) == 0 {
+			return true
+		}
+		if skipped < *listSkip {
+			skipped++
+			return true
+		}
+		if JSONOutput || *listJSONOutput {
+			r.CheckMergeable()
+		}
+		visited++
+		return visit(r) && (*listLimit == 0 || visited < *listLimit)
+	})
+}
+
+// selectReviews returns the reviews in the given repo that were selected by the flags passed to the "list" subcommand.
+func selectReviews(repo repository.Repo, now time.Time) ([]review.Summary, error) {
+	var reviews []review.Summary
+	err := visitReviews(repo, now, func(r review.Summary) bool {
+		reviews = append(reviews, r)
+		return true
+	})
+	return reviews, err
+}
+
+// listHeading returns the heading of the listed reviews, given how many were loaded.
+func listHeading(count int) string {
+	if *listArchived {
+		return fmt.Sprintf("Loaded %d archived reviews", count)
+	} else if *listAll || *listStatus != "" {
+		return fmt.Sprintf("Loaded %d reviews", count)
+	}
+	return fmt.Sprintf("Loaded %d open reviews", count)
+}
+
+// printListJSON prints the result of the "list" subcommand in the format selected by its flags.
+func printListJSON(result interface{}) error {
+	if JSONOutput {
+		return output.PrintJSONResult("list", result)
+	}
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// printFormatted prints the summary of each of the given reviews using the given template, without any heading.
+func printFormatted(format *template.Template, reviews []review.Summary) error {
+	for i := range reviews {
+		if err := output.PrintFormatted(os.Stdout, format, &review.Review{Summary: &reviews[i]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workspaceReviews lists the reviews in a single repo of the workspace.
+type workspaceReviews struct {
+	Repository string           `json:"repository"`
+	Reviews    []review.Summary `json:"reviews"`
+}
+
+// listWorkspaceReviews lists the reviews in every repo of the workspace, grouped by repo.
+func listWorkspaceReviews(repo repository.Repo, format *template.Template) error {
+	repos, err := openWorkspace(repo)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var results []workspaceReviews
+	total := 0
+	for _, r := range repos {
+		reviews, err := selectReviews(r.Repo, now)
+		if err != nil {
+			return fmt.Errorf("Failed to list the reviews in %q: %v", r.Name, err)
+		}
+		results = append(results, workspaceReviews{Repository: r.Name, Reviews: reviews})
+		total += len(reviews)
+	}
+	if JSONOutput || *listJSONOutput {
+		return printListJSON(results)
+	}
+	if format != nil {
+		for _, result := range results {
+			if err := printFormatted(format, result.Reviews); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
-	for _, r := range reviews {
-		output.PrintSummary(&r)
+	fmt.Printf("%s in %d repositories:\n", listHeading(total), len(results))
+	for i, result := range results {
+		if len(result.Reviews) == 0 {
+			continue
+		}
+		shortIDs, err := loadShortIDs(repos[i].Repo)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s:\n", result.Repository)
+		output.PrintStack(result.Reviews, shortIDs)
+	}
+	return nil
+}
+
+// streamReviews prints each of the reviews selected by the flags passed to the "list" subcommand as soon as it is loaded.
+//
+// Since the reviews that a review depends upon may be on another page, the
+// reviews are not grouped under them as they are when listing every review.
+//
+// If a template is given, then each review is printed using it, and the heading is left out.
+func streamReviews(repo repository.Repo, format *template.Template) error {
+	shortIDs, err := loadShortIDs(repo)
+	if err != nil {
+		return err
+	}
+	count := 0
+	var formatErr error
+	if err := visitReviews(repo, time.Now(), func(r review.Summary) bool {
+		if format != nil {
+			formatErr = printFormatted(format, []review.Summary{r})
+			return formatErr == nil
+		}
+		output.PrintStack([]review.Summary{r}, shortIDs)
+		count++
+		return true
+	}); err != nil {
+		return err
+	}
+	if format != nil {
+		return formatErr
+	}
+	if *listSkip > 0 {
+		fmt.Printf("%s, after skipping %d.\n", listHeading(count), *listSkip)
+	} else {
+		fmt.Printf("%s.\n", listHeading(count))
+	}
+	if *listLimit > 0 && count == *listLimit {
+		fmt.Printf("Pass -skip %d to list the next page.\n", *listSkip+count)
+	}
+	return nil
+}
+
+// listReviews lists all extant reviews.
+func listReviews(repo repository.Repo, args []string) error {
+	listFlagSet.Parse(args)
+	var format *template.Template
+	if *listFormat != "" {
+		if JSONOutput || *listJSONOutput {
+			return errors.New("The -format flag cannot be used with the -json flag.")
+		}
+		var err error
+		if format, err = output.ParseFormat(*listFormat); err != nil {
+			return err
+		}
+	}
+	color, _ := useColor("auto")
+	output.Markdown = color && !*listPlain
+	if *listRecursive {
+		return listWorkspaceReviews(repo, format)
+	}
+	if repo == nil {
+		return errors.New("The command must be run from within a git repo.")
+	}
+	if (*listLimit != 0 || *listSkip != 0) && !JSONOutput && !*listJSONOutput {
+		return streamReviews(repo, format)
+	}
+	reviews, err := selectReviews(repo, time.Now())
+	if err != nil {
+		return err
+	}
+	if JSONOutput || *listJSONOutput {
+		return printListJSON(reviews)
+	}
+	if format != nil {
+		return printFormatted(format, reviews)
+	}
+	fmt.Printf("%s:\n", listHeading(len(reviews)))
+	shortIDs, err := loadShortIDs(repo)
+	if err != nil {
+		return err
 	}
+	output.PrintStack(reviews, shortIDs)
 	return nil
 }
 
@@ -71,4 +476,6 @@ var listCmd = &Command{
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return listReviews(repo, args)
 	},
+	// Listing the reviews of a whole workspace does not need a repo.
+	NoRepo: true,
 }
@@ -0,0 +1,107 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/request"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2016, 3, 10, 12, 0, 0, 0, time.UTC)
+	for since, expected := range map[string]time.Time{
+		"7d":                   time.Date(2016, 3, 3, 12, 0, 0, 0, time.UTC),
+		"36h":                  time.Date(2016, 3, 9, 0, 0, 0, 0, time.UTC),
+		"2016-01-02":           time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC),
+		"2016-01-02T15:04:05Z": time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC),
+	} {
+		parsed, err := parseSince(since, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !parsed.Equal(expected) {
+			t.Fatalf("Unexpected time for %q: %v", since, parsed)
+		}
+	}
+	if _, err := parseSince("last week", now); err == nil {
+		t.Fatal("Failed to reject an invalid time")
+	}
+}
+
+func TestApplyListFilters(t *testing.T) {
+	reviews := []review.Summary{
+		{Revision: "a", Request: request.Request{Requester: "alice", TargetRef: "refs/heads/master"}},
+		{Revision: "b", Request: request.Request{Requester: "bob", TargetRef: "refs/heads/master"}},
+		{Revision: "c", Request: request.Request{Requester: "bob", TargetRef: "refs/heads/release"}},
+	}
+	byBob := func(r *review.Summary) bool { return r.Request.Requester == "bob" }
+	onMaster := func(r *review.Summary) bool { return r.Request.TargetRef == "refs/heads/master" }
+	revisions := func(reviews []review.Summary) string {
+		var result string
+		for _, r := range reviews {
+			result += r.Revision
+		}
+		return result
+	}
+	if result := revisions(applyListFilters(reviews, nil, false)); result != "abc" {
+		t.Fatalf("Unexpected unfiltered reviews: %q", result)
+	}
+	if result := revisions(applyListFilters(reviews, []reviewFilter{byBob, onMaster}, false)); result != "b" {
+		t.Fatalf("Unexpected reviews matching all filters: %q", result)
+	}
+	if result := revisions(applyListFilters(reviews, []reviewFilter{byBob, onMaster}, true)); result != "abc" {
+		t.Fatalf("Unexpected reviews matching any filter: %q", result)
+	}
+}
+
+func TestVisitReviewsPages(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	*listAll = true
+	defer func() {
+		*listAll, *listLimit, *listSkip, *listRequested = false, 0, 0, ""
+	}()
+	page := func(limit, skip int, since string) []string {
+		*listLimit, *listSkip, *listRequested = limit, skip, since
+		var revisions []string
+		if err := visitReviews(repo, time.Now(), func(r review.Summary) bool {
+			revisions = append(revisions, r.Revision)
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return revisions
+	}
+	if all := page(0, 0, ""); len(all) != 3 || all[0] != repository.TestCommitG {
+		t.Fatalf("Unexpected reviews: %v", all)
+	}
+	if first := page(2, 0, ""); len(first) != 2 || first[0] != repository.TestCommitG {
+		t.Fatalf("Unexpected first page: %v", first)
+	}
+	if second := page(2, 2, ""); len(second) != 1 || second[0] != repository.TestCommitB {
+		t.Fatalf("Unexpected second page: %v", second)
+	}
+	if since := page(0, 0, "1970-01-01T00:00:02Z"); len(since) != 2 || since[1] != repository.TestCommitD {
+		t.Fatalf("Unexpected reviews requested since time 2: %v", since)
+	}
+	*listSkip = -1
+	if err := visitReviews(repo, time.Now(), func(review.Summary) bool { return true }); err == nil {
+		t.Fatal("Failed to reject a negative -skip flag")
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/audit"
+)
+
+var logFlagSet = flag.NewFlagSet("log", flag.ExitOnError)
+
+var (
+	logJSONOutput = logFlagSet.Bool("json", false, "Format the output as JSON")
+)
+
+// logResult is the JSON output of the "log" subcommand.
+type logResult struct {
+	Review string        `json:"review"`
+	Events []audit.Entry `json:"events"`
+}
+
+// logReview prints the timeline of the events in the life of a review.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func logReview(repo repository.Repo, args []string) error {
+	logFlagSet.Parse(args)
+	args = logFlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("Only logging a single review is supported.")
+	}
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+	entries, err := audit.Timeline(r)
+	if err != nil {
+		return err
+	}
+	result := logResult{Review: r.Revision, Events: entries}
+	if result.Events == nil {
+		result.Events = []audit.Entry{}
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("log", result)
+	}
+	if *logJSONOutput {
+		b, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	output.PrintTimeline(entries)
+	return nil
+}
+
+// logCmd defines the "log" subcommand.
+var logCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s log [-json] [<review-hash>]\n\nOptions:\n", arg0)
+		logFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return logReview(repo, args)
+	},
+}
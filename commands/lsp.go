@@ -0,0 +1,56 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/rpc"
+	"os"
+)
+
+var lspFlagSet = flag.NewFlagSet("lsp", flag.ExitOnError)
+
+// serveLSP serves the reviews in the repo to an editor, over JSON-RPC on stdin and stdout.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func serveLSP(repo repository.Repo, args []string) error {
+	lspFlagSet.Parse(args)
+	if len(lspFlagSet.Args()) > 0 {
+		return errors.New("The lsp command does not take any arguments.")
+	}
+	shortIDs, err := loadShortIDs(repo)
+	if err != nil {
+		return err
+	}
+	return rpc.New(repo, shortIDs).Serve(os.Stdin, os.Stdout)
+}
+
+// lspCmd defines the "lsp" subcommand.
+var lspCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s lsp\n\n", arg0)
+		fmt.Printf("Serves the reviews in the repo to an editor plugin, using JSON-RPC 2.0 messages\n")
+		fmt.Printf("on stdin and stdout, framed with Content-Length headers as in the Language Server Protocol.\n")
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return serveLSP(repo, args)
+	},
+	NoSync: true,
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/analyses"
+	"github.com/promet/git-appraise/review/approval"
+	"github.com/promet/git-appraise/review/away"
+	"github.com/promet/git-appraise/review/blind"
+	"github.com/promet/git-appraise/review/checklist"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/event"
+	"github.com/promet/git-appraise/review/reaction"
+	"github.com/promet/git-appraise/review/request"
+	"github.com/promet/git-appraise/review/robot"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
+)
+
+var migrateFlagSet = flag.NewFlagSet("migrate", flag.ExitOnError)
+
+var (
+	migrateDryRun = migrateFlagSet.Bool("dry-run", false, "Only report how many notes would be upgraded, without rewriting them")
+)
+
+// migratedRefs maps each notes ref that the migrate command rewrites to the latest version of its format.
+//
+// Comments are left out, as they are named by the hash of their contents, so
+// rewriting them would break the replies and reactions that refer to them.
+// They are only ever upgraded as they are read.
+var migratedRefs = map[string]int{
+	analyses.Ref:  analyses.FormatVersion,
+	approval.Ref:  approval.FormatVersion,
+	away.Ref:      away.FormatVersion,
+	blind.Ref:     blind.FormatVersion,
+	checklist.Ref: checklist.FormatVersion,
+	ci.Ref:        ci.FormatVersion,
+	ci.RerunRef:   ci.FormatVersion,
+	event.Ref:     event.FormatVersion,
+	reaction.Ref:  reaction.FormatVersion,
+	request.Ref:   request.FormatVersion,
+	robot.Ref:     robot.FormatVersion,
+}
+
+// migrateRefResult summarizes the migration of the notes under a single ref.
+type migrateRefResult struct {
+	Ref string `json:"ref"`
+	// Upgraded is the number of notes that were, or in a dry run would be, upgraded.
+	Upgraded int `json:"upgraded"`
+	// Unsupported is the number of notes that could not be upgraded, such as those written by a newer version of the tool.
+	Unsupported int `json:"unsupported,omitempty"`
+}
+
+// upgradeNotes upgrades each of the given notes under the given ref to the given version of its format.
+//
+// It returns the upgraded notes, along with the number of notes that were
+// upgraded and the number that could not be, such as those written by a newer
+// version of the tool. Lines that are not valid notes are kept as they are.
+func upgradeNotes(registry *schema.Registry, ref string, version int, notes []repository.Note) ([]repository.Note, int, int) {
+	var upgradedNotes []repository.Note
+	upgradedCount, unsupported := 0, 0
+	for _, note := range notes {
+		upgraded, err := registry.Upgrade(ref, note, version)
+		if errors.Is(err, repository.ErrSchemaVersion) {
+			unsupported++
+			upgraded = note
+		} else if err != nil || string(upgraded) == string(note) {
+			upgraded = note
+		} else {
+			upgradedCount++
+		}
+		upgradedNotes = append(upgradedNotes, upgraded)
+	}
+	return upgradedNotes, upgradedCount, unsupported
+}
+
+// migrateNotes upgrades every note under the given refs to the latest version of its format, using the given registry.
+func migrateNotes(repo repository.Repo, registry *schema.Registry, refs map[string]int, dryRun bool) ([]migrateRefResult, error) {
+	var sortedRefs []string
+	for ref := range refs {
+		sortedRefs = append(sortedRefs, ref)
+	}
+	sort.Strings(sortedRefs)
+	var results []migrateRefResult
+	for _, ref := range sortedRefs {
+		notesMap, err := repo.GetAllNotes(ref)
+		if err != nil {
+			return nil, err
+		}
+		result := migrateRefResult{Ref: ref}
+		for revision, notes := range notesMap {
+			_, upgraded, unsupported := upgradeNotes(registry, ref, refs[ref], notes)
+			result.Upgraded += upgraded
+			result.Unsupported += unsupported
+			if upgraded > 0 && !dryRun {
+				// The notes are upgraded again as they are written, in case they were changed in the meantime.
+				err := repo.SetNotes(ref, revision, func(notes []repository.Note) []repository.Note {
+					upgradedNotes, _, _ := upgradeNotes(registry, ref, refs[ref], notes)
+					return upgradedNotes
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// migrate rewrites the notes written in older versions of their formats.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func migrate(repo repository.Repo, args []string) error {
+	migrateFlagSet.Parse(args)
+	if len(migrateFlagSet.Args()) > 0 {
+		return errors.New("The migrate command does not take any arguments.")
+	}
+	results, err := migrateNotes(repo, schema.Default, migratedRefs, *migrateDryRun)
+	if err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("migrate", results)
+	}
+	verb := "Upgraded"
+	if *migrateDryRun {
+		verb = "Would upgrade"
+	}
+	total := 0
+	for _, result := range results {
+		total += result.Upgraded
+		if result.Upgraded > 0 {
+			fmt.Printf("%s %d notes under %s.\n", verb, result.Upgraded, notesRef(result.Ref))
+		}
+		if result.Unsupported > 0 {
+			fmt.Printf("Skipped %d notes under %s that are in a format version this tool does not support.\n", result.Unsupported, notesRef(result.Ref))
+		}
+	}
+	if total == 0 {
+		fmt.Println("All notes are in the latest format.")
+	}
+	return nil
+}
+
+// migrateCmd defines the "migrate" subcommand.
+var migrateCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s migrate [<option>...]\n\n", arg0)
+		fmt.Printf("Rewrites the review notes that were written in older versions of their\n")
+		fmt.Printf("formats in the latest version. Old notes are also read correctly without\n")
+		fmt.Printf("this; migrating them only saves upgrading them each time they are read.\n\nOptions:\n")
+		migrateFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return migrate(repo, args)
+	},
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/request"
+	"github.com/promet/git-appraise/review/schema"
+	"strings"
+	"testing"
+)
+
+func TestMigrateNotes(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	if err := repo.AppendNote(request.Ref, repository.TestCommitB, repository.Note(`{"v": 5}`)); err != nil {
+		t.Fatal(err)
+	}
+	registry := &schema.Registry{}
+	registry.Register(schema.Migration{Ref: request.Ref, From: 0, Upgrade: func(fields map[string]json.RawMessage) error {
+		return nil
+	}})
+	refs := map[string]int{request.Ref: 1}
+
+	results, err := migrateNotes(repo, registry, refs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Upgraded == 0 || results[0].Unsupported != 1 {
+		t.Fatalf("Unexpected results of a dry run: %+v", results)
+	}
+	if notes := repo.GetNotes(request.Ref, repository.TestCommitG); strings.Contains(string(notes[0]), `"v":1`) {
+		t.Fatalf("A dry run rewrote the notes: %s", notes)
+	}
+
+	if _, err := migrateNotes(repo, registry, refs, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, note := range repo.GetNotes(request.Ref, repository.TestCommitG) {
+		if strings.TrimSpace(string(note)) != "" && !strings.Contains(string(note), `"v":1`) {
+			t.Fatalf("A note was not upgraded: %s", note)
+		}
+	}
+	results, err = migrateNotes(repo, registry, refs, false)
+	if err != nil || len(results) != 1 || results[0].Upgraded != 0 {
+		t.Fatalf("Unexpected results of migrating again: %+v, %v", results, err)
+	}
+}
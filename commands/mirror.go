@@ -0,0 +1,286 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/mirror"
+	"github.com/promet/git-appraise/mirror/gerrit"
+	"github.com/promet/git-appraise/mirror/github"
+	"github.com/promet/git-appraise/mirror/gitlab"
+	"github.com/promet/git-appraise/mirror/phabricator"
+	"github.com/promet/git-appraise/repository"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+var mirrorGerritFlagSet = flag.NewFlagSet("mirror gerrit", flag.ExitOnError)
+
+var (
+	mirrorGerritURL      = mirrorGerritFlagSet.String("url", "", "Base URL of the Gerrit server")
+	mirrorGerritUser     = mirrorGerritFlagSet.String("user", "", "Gerrit username; if omitted, only the anonymous API is used")
+	mirrorGerritPassword = mirrorGerritFlagSet.String("password", "", "Gerrit HTTP password; defaults to the value of the GERRIT_PASSWORD environment variable")
+	mirrorGerritExport   = mirrorGerritFlagSet.Bool("export", false, "Also post local comments on the mirrored reviews back to the changes")
+)
+
+var mirrorGitLabFlagSet = flag.NewFlagSet("mirror gitlab", flag.ExitOnError)
+
+var (
+	mirrorGitLabToken  = mirrorGitLabFlagSet.String("token", "", "GitLab API token; defaults to the value of the GITLAB_TOKEN environment variable")
+	mirrorGitLabURL    = mirrorGitLabFlagSet.String("api-url", gitlab.DefaultBaseURL, "Base URL of the GitLab API, for use with self-managed instances")
+	mirrorGitLabExport = mirrorGitLabFlagSet.Bool("export", false, "Also post local comments and approvals on the mirrored reviews back to the merge requests")
+)
+
+var mirrorGitHubFlagSet = flag.NewFlagSet("mirror github", flag.ExitOnError)
+
+var (
+	mirrorGitHubToken  = mirrorGitHubFlagSet.String("token", "", "GitHub API token; defaults to the value of the GITHUB_TOKEN environment variable")
+	mirrorGitHubURL    = mirrorGitHubFlagSet.String("api-url", github.DefaultBaseURL, "Base URL of the GitHub API, for use with GitHub Enterprise")
+	mirrorGitHubExport = mirrorGitHubFlagSet.Bool("export", false, "Also post local comments on the mirrored reviews back to the pull requests")
+)
+
+var mirrorPhabricatorFlagSet = flag.NewFlagSet("mirror phabricator", flag.ExitOnError)
+
+var (
+	mirrorPhabricatorURL         = mirrorPhabricatorFlagSet.String("url", "", "Base URL of the Phabricator server")
+	mirrorPhabricatorToken       = mirrorPhabricatorFlagSet.String("token", "", "Conduit API token; defaults to the value of the PHABRICATOR_TOKEN environment variable")
+	mirrorPhabricatorDump        = mirrorPhabricatorFlagSet.String("dump", "", "Import the revisions from a dump previously saved with -save-dump, instead of from the server")
+	mirrorPhabricatorSaveDump    = mirrorPhabricatorFlagSet.String("save-dump", "", "Also save everything fetched from the server to the given file")
+	mirrorPhabricatorEmailDomain = mirrorPhabricatorFlagSet.String("email-domain", "", "Domain used to turn Phabricator usernames into email addresses")
+	mirrorPhabricatorTarget      = mirrorPhabricatorFlagSet.String("target", "refs/heads/master", "Target ref of the revisions whose diffs do not record the branch they were made onto")
+)
+
+// mirrorSystem defines how to mirror reviews to and from one other code review system.
+type mirrorSystem struct {
+	Usage string
+	Flags *flag.FlagSet
+	Run   func(repo repository.Repo, args []string) error
+}
+
+// mirrorSystems defines all of the systems that reviews can be mirrored with.
+var mirrorSystems = map[string]mirrorSystem{
+	"gerrit": {
+		Usage: "gerrit -url <url> [<option>...] <project>",
+		Flags: mirrorGerritFlagSet,
+		Run:   mirrorGerrit,
+	},
+	"github": {
+		Usage: "github [<option>...] <owner>/<repo>",
+		Flags: mirrorGitHubFlagSet,
+		Run:   mirrorGitHub,
+	},
+	"gitlab": {
+		Usage: "gitlab [<option>...] <namespace>/<project>",
+		Flags: mirrorGitLabFlagSet,
+		Run:   mirrorGitLab,
+	},
+	"phabricator": {
+		Usage: "phabricator [<option>...] (-url <url> <repository> | -dump <file>)",
+		Flags: mirrorPhabricatorFlagSet,
+		Run:   mirrorPhabricator,
+	},
+}
+
+// mirrorGitHub imports the pull requests of a GitHub repository, and optionally exports local comments to them.
+func mirrorGitHub(repo repository.Repo, args []string) error {
+	mirrorGitHubFlagSet.Parse(args)
+	args = mirrorGitHubFlagSet.Args()
+	if len(args) != 1 {
+		return errors.New("Mirroring GitHub requires exactly one repository, in the form <owner>/<repo>.")
+	}
+	parts := strings.Split(args[0], "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("Invalid GitHub repository %q; expected the form <owner>/<repo>.", args[0])
+	}
+	token := *mirrorGitHubToken
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+	if *mirrorGitHubExport && token == "" {
+		return errors.New("Exporting comments to GitHub requires an API token.")
+	}
+	client := github.NewClient(token)
+	client.BaseURL = strings.TrimSuffix(*mirrorGitHubURL, "/")
+
+	results, err := github.Sync(repo, client, parts[0], parts[1], *mirrorGitHubExport)
+	if err != nil {
+		return err
+	}
+	return printMirrorResults("pull request", results, *mirrorGitHubExport)
+}
+
+// mirrorGerrit imports the changes of a Gerrit project, and optionally exports local comments to them.
+func mirrorGerrit(repo repository.Repo, args []string) error {
+	mirrorGerritFlagSet.Parse(args)
+	args = mirrorGerritFlagSet.Args()
+	if len(args) != 1 {
+		return errors.New("Mirroring Gerrit requires exactly one project.")
+	}
+	if *mirrorGerritURL == "" {
+		return errors.New("Mirroring Gerrit requires the URL of the server.")
+	}
+	password := *mirrorGerritPassword
+	if password == "" {
+		password = os.Getenv("GERRIT_PASSWORD")
+	}
+	if *mirrorGerritExport && *mirrorGerritUser == "" {
+		return errors.New("Exporting comments to Gerrit requires a username and HTTP password.")
+	}
+	client := gerrit.NewClient(*mirrorGerritURL, *mirrorGerritUser, password)
+
+	results, err := gerrit.Sync(repo, client, args[0], *mirrorGerritExport)
+	if err != nil {
+		return err
+	}
+	return printMirrorResults("change", results, *mirrorGerritExport)
+}
+
+// mirrorGitLab imports the merge requests of a GitLab project, and optionally exports local comments and approvals to them.
+func mirrorGitLab(repo repository.Repo, args []string) error {
+	mirrorGitLabFlagSet.Parse(args)
+	args = mirrorGitLabFlagSet.Args()
+	if len(args) != 1 {
+		return errors.New("Mirroring GitLab requires exactly one project, in the form <namespace>/<project>.")
+	}
+	token := *mirrorGitLabToken
+	if token == "" {
+		token = os.Getenv("GITLAB_TOKEN")
+	}
+	if *mirrorGitLabExport && token == "" {
+		return errors.New("Exporting comments to GitLab requires an API token.")
+	}
+	client := gitlab.NewClient(token)
+	client.BaseURL = strings.TrimSuffix(*mirrorGitLabURL, "/")
+
+	results, err := gitlab.Sync(repo, client, args[0], *mirrorGitLabExport)
+	if err != nil {
+		return err
+	}
+	return printMirrorResults("merge request", results, *mirrorGitLabExport)
+}
+
+// mirrorPhabricator imports the Differential revisions of a Phabricator repository, either from the server or from a saved dump.
+func mirrorPhabricator(repo repository.Repo, args []string) error {
+	mirrorPhabricatorFlagSet.Parse(args)
+	args = mirrorPhabricatorFlagSet.Args()
+	var dump *phabricator.Dump
+	if *mirrorPhabricatorDump != "" {
+		if len(args) != 0 {
+			return errors.New("Importing a Phabricator dump does not take a repository.")
+		}
+		contents, err := ioutil.ReadFile(*mirrorPhabricatorDump)
+		if err != nil {
+			return err
+		}
+		dump, err = phabricator.ParseDump(contents)
+		if err != nil {
+			return err
+		}
+	} else {
+		if len(args) != 1 {
+			return errors.New("Mirroring Phabricator requires exactly one repository, given by its callsign, short name, or PHID.")
+		}
+		if *mirrorPhabricatorURL == "" {
+			return errors.New("Mirroring Phabricator requires the URL of the server.")
+		}
+		token := *mirrorPhabricatorToken
+		if token == "" {
+			token = os.Getenv("PHABRICATOR_TOKEN")
+		}
+		if token == "" {
+			return errors.New("Mirroring Phabricator requires a Conduit API token.")
+		}
+		var err error
+		dump, err = phabricator.NewClient(*mirrorPhabricatorURL, token).Fetch(args[0])
+		if err != nil {
+			return err
+		}
+		if *mirrorPhabricatorSaveDump != "" {
+			contents, err := json.MarshalIndent(dump, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(*mirrorPhabricatorSaveDump, contents, 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	options := phabricator.Options{
+		EmailDomain: *mirrorPhabricatorEmailDomain,
+		TargetRef:   *mirrorPhabricatorTarget,
+	}
+	return printMirrorResults("revision", phabricator.Import(repo, dump, options), false)
+}
+
+// printMirrorResults prints a summary of each review that was mirrored.
+func printMirrorResults(kind string, results []mirror.SyncResult, exported bool) error {
+	if JSONOutput {
+		return output.PrintJSONResult("mirror", results)
+	}
+	for _, result := range results {
+		summary := fmt.Sprintf("%s %d (%.12s): imported %d notes", kind, result.Number, result.Revision, result.Imported)
+		if exported {
+			summary += fmt.Sprintf(", exported %d comments", result.Exported)
+		}
+		if result.Error != "" {
+			summary += ": " + result.Error
+		}
+		fmt.Println(summary)
+	}
+	return nil
+}
+
+// mirrorReviews dispatches to the mirror for the system named by the first argument.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func mirrorReviews(repo repository.Repo, args []string) error {
+	if len(args) < 1 {
+		return errors.New("Mirroring requires the name of the system to mirror with.")
+	}
+	system, ok := mirrorSystems[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown system %q to mirror with.", args[0])
+	}
+	return system.Run(repo, args[1:])
+}
+
+// mirrorCmd defines the "mirror" subcommand.
+var mirrorCmd = &Command{
+	Usage: func(arg0 string) {
+		var names []string
+		for name := range mirrorSystems {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			system := mirrorSystems[name]
+			fmt.Printf("Usage: %s mirror %s\n\nOptions:\n", arg0, system.Usage)
+			system.Flags.PrintDefaults()
+			fmt.Println()
+		}
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return mirrorReviews(repo, args)
+	},
+}
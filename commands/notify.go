@@ -0,0 +1,176 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/notify"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// notifyStateFile is the path, relative to the git directory, of the record of already dispatched events.
+var notifyStateFile = filepath.Join("appraise", "notify.gob")
+
+var notifyFlagSet = flag.NewFlagSet("notify", flag.ExitOnError)
+
+var (
+	notifyWatch    = notifyFlagSet.Bool("watch", false, "Keep running, and check for new events periodically")
+	notifyInterval = notifyFlagSet.Duration("interval", 30*time.Second, "How often to check for new events when watching")
+	notifyAll      = notifyFlagSet.Bool("all", false, "On the first run, dispatch every existing event instead of only recording them")
+	notifyStdout   = notifyFlagSet.Bool("stdout", false, "Print each event to the standard output; this is the default if no other sink is given")
+	notifyWebhooks = notifyFlagSet.String("webhook", "", "Comma-separated list of URLs to post each event to, as JSON")
+	notifySMTP     = notifyFlagSet.String("smtp", "", "Address (host:port) of an SMTP server to email each event through")
+	notifySMTPUser = notifyFlagSet.String("smtp-user", "", "User to authenticate to the SMTP server as; the password is read from $GIT_APPRAISE_SMTP_PASSWORD")
+	notifySMTPFrom = notifyFlagSet.String("smtp-from", "", "Sender of the notification emails; defaults to the configured user email")
+	notifySMTPTo   = notifyFlagSet.String("smtp-to", "", "Comma-separated list of recipients for every email; defaults to the requester and reviewers of each review")
+	notifySlack    = notifyFlagSet.String("slack", "", "URL of a Slack (or Mattermost) incoming webhook to post each event to")
+	notifyRoutes   = notifyFlagSet.String("slack-routes", "", "Comma-separated list of routes of the form <channel>[=target:<ref>|=label:<label>]; each event is posted to every matching channel")
+	notifyWebURL   = notifyFlagSet.String("web-url", "", "Base URL of the web dashboard, used to link to reviews")
+)
+
+// buildNotifySinks returns the sinks selected by the flags passed to the "notify" subcommand.
+func buildNotifySinks(repo repository.Repo) ([]notify.Sink, error) {
+	var sinks []notify.Sink
+	for _, url := range splitValues(*notifyWebhooks) {
+		sinks = append(sinks, notify.WebhookSink{URL: url})
+	}
+	if *notifySMTP != "" {
+		from := *notifySMTPFrom
+		if from == "" {
+			userEmail, err := repo.GetUserEmail()
+			if err != nil {
+				return nil, err
+			}
+			from = userEmail
+		}
+		var auth smtp.Auth
+		if *notifySMTPUser != "" {
+			host, _, err := net.SplitHostPort(*notifySMTP)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid SMTP server address %q: %v", *notifySMTP, err)
+			}
+			auth = smtp.PlainAuth("", *notifySMTPUser, os.Getenv("GIT_APPRAISE_SMTP_PASSWORD"), host)
+		}
+		sinks = append(sinks, notify.SMTPSink{
+			Addr: *notifySMTP,
+			Auth: auth,
+			From: from,
+			To:   splitValues(*notifySMTPTo),
+		})
+	}
+	if *notifySlack != "" {
+		sink := notify.SlackSink{URL: *notifySlack, WebURL: *notifyWebURL}
+		for _, value := range splitValues(*notifyRoutes) {
+			route, err := notify.ParseRoute(value)
+			if err != nil {
+				return nil, err
+			}
+			sink.Routes = append(sink.Routes, route)
+		}
+		sinks = append(sinks, sink)
+	}
+	if *notifyStdout || len(sinks) == 0 {
+		sinks = append(sinks, notify.WriterSink{Writer: os.Stdout})
+	}
+	return sinks, nil
+}
+
+// dispatchNewEvents sends every event that has not previously been dispatched to the given sinks.
+func dispatchNewEvents(repo repository.Repo, sinks []notify.Sink) error {
+	stateFile, err := gitDirPath(repo, notifyStateFile)
+	if err != nil {
+		return err
+	}
+	state, err := notify.LoadState(stateFile)
+	firstRun := err != nil
+	if firstRun {
+		state = notify.NewState()
+	}
+	events := state.Unseen(notify.Collect(review.ListOpen(repo)))
+	if !firstRun || *notifyAll {
+		if err := notify.Dispatch(events, sinks); err != nil {
+			// Still record the events, so that one broken sink does not cause repeated notifications.
+			state.Save(stateFile)
+			return err
+		}
+	}
+	return state.Save(stateFile)
+}
+
+// notifyReviews dispatches notifications about changes to reviews.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func notifyReviews(repo repository.Repo, args []string) error {
+	notifyFlagSet.Parse(args)
+	if len(notifyFlagSet.Args()) > 0 {
+		return errors.New("The notify command does not take any arguments.")
+	}
+	sinks, err := buildNotifySinks(repo)
+	if err != nil {
+		return err
+	}
+	if !*notifyWatch {
+		return runAutoSynced(repo, func() error {
+			return dispatchNewEvents(repo, sinks)
+		})
+	}
+	var lastStateHash string
+	for {
+		err := runAutoSynced(repo, func() error {
+			stateHash, err := repo.GetRepoStateHash()
+			if err != nil || stateHash == lastStateHash {
+				return err
+			}
+			if err := dispatchNewEvents(repo, sinks); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			lastStateHash = stateHash
+			return nil
+		})
+		if repo.Context().Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-time.After(*notifyInterval):
+		case <-repo.Context().Done():
+			return nil
+		}
+	}
+}
+
+// notifyCmd defines the "notify" subcommand.
+var notifyCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s notify [<option>...]\n\nOptions:\n", arg0)
+		notifyFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return notifyReviews(repo, args)
+	},
+	NoSync: true,
+}
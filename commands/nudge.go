@@ -0,0 +1,218 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/event"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/stats"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var nudgeFlagSet = flag.NewFlagSet("nudge", flag.ExitOnError)
+
+var (
+	nudgeDryRun   = nudgeFlagSet.Bool("dry-run", false, "Only list the overdue reviews, without nudging anyone about them")
+	nudgeWatch    = nudgeFlagSet.Bool("watch", false, "Keep running, and check for overdue reviews periodically")
+	nudgeInterval = nudgeFlagSet.Duration("interval", 10*time.Minute, "How often to check for overdue reviews when watching")
+)
+
+// nudgeResult is the JSON output for a single nudge by the "nudge" subcommand.
+type nudgeResult struct {
+	Review string       `json:"review"`
+	Breach stats.Breach `json:"breach"`
+	// Nudged are the people who were (or in a dry run would be) nudged about the review.
+	Nudged []string `json:"nudged"`
+}
+
+// parseDuration parses a duration, which may also be given in days (e.g. 2d).
+func parseDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+	return time.ParseDuration(value)
+}
+
+// loadSLA reads the SLA that reviews are held to from the "appraise.sla*" settings.
+func loadSLA(repo repository.Repo) (stats.SLA, error) {
+	var sla stats.SLA
+	for name, limit := range map[string]*time.Duration{
+		"slaFirstResponse": &sla.FirstResponse,
+		"slaResolution":    &sla.Resolution,
+	} {
+		value, err := getConfigValue(repo, name)
+		if err != nil {
+			return sla, err
+		}
+		if value == "" {
+			continue
+		}
+		if *limit, err = parseDuration(value); err != nil || *limit <= 0 {
+			return sla, fmt.Errorf("Invalid appraise.%s %q; expected a duration such as 24h or 2d.", name, value)
+		}
+	}
+	return sla, nil
+}
+
+// nudgedUsers returns who to remind of a review that is overdue for the given objective.
+//
+// The reviewers are responsible for responding to a review, while both they
+// and the requester are responsible for getting it submitted.
+func nudgedUsers(r *review.Summary, objective string) []string {
+	users := append([]string(nil), r.Request.Reviewers...)
+	if objective == stats.ObjectiveResolution || len(users) == 0 {
+		users = append([]string{r.Request.Requester}, users...)
+	}
+	return users
+}
+
+// nudgeMessage describes how far past the SLA a review is.
+func nudgeMessage(breach stats.Breach, limit time.Duration) string {
+	overdue := time.Duration(breach.Overdue) * time.Second
+	return fmt.Sprintf("The review is %s past its %s %s SLA.", overdue, limit, strings.Replace(breach.Objective, "-", " ", -1))
+}
+
+// nudgeOverdueReviews nudges the people responsible for each open review that is overdue for an objective of the SLA.
+//
+// Each review is only nudged once about each objective, so that running this
+// repeatedly (e.g. as a daemon) does not nag anyone.
+func nudgeOverdueReviews(repo repository.Repo, sla stats.SLA, now time.Time) ([]nudgeResult, error) {
+	identities, err := identity.Load(repo, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	author, err := repo.GetUserEmail()
+	if err != nil {
+		return nil, err
+	}
+	var results []nudgeResult
+	for _, summary := range review.ListOpen(repo) {
+		overdue := sla.Overdue(&summary, identities, now)
+		if len(overdue) == 0 {
+			continue
+		}
+		r, err := summary.Details()
+		if err != nil {
+			return nil, err
+		}
+		for _, breach := range overdue {
+			if event.Nudged(r.Events, breach.Objective) {
+				continue
+			}
+			result := nudgeResult{Review: r.Revision, Breach: breach, Nudged: nudgedUsers(&summary, breach.Objective)}
+			results = append(results, result)
+			if *nudgeDryRun {
+				continue
+			}
+			limit := sla.FirstResponse
+			if breach.Objective == stats.ObjectiveResolution {
+				limit = sla.Resolution
+			}
+			head, err := r.GetHeadCommit()
+			if err != nil {
+				return nil, err
+			}
+			note, err := event.NewNudge(author, result.Nudged, head, breach.Objective, nudgeMessage(breach, limit)).Write()
+			if err != nil {
+				return nil, err
+			}
+			if err := repo.AppendNote(event.Ref, r.Revision, note); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// printNudges reports the nudges made by a single check for overdue reviews.
+func printNudges(results []nudgeResult) error {
+	if JSONOutput {
+		return output.PrintJSONResult("nudge", results)
+	}
+	verb := "Nudged"
+	if *nudgeDryRun {
+		verb = "Would nudge"
+	}
+	for _, result := range results {
+		overdue := time.Duration(result.Breach.Overdue) * time.Second
+		fmt.Printf("%s %s about review %.12s, which is %s overdue for its %s.\n", verb, strings.Join(result.Nudged, ", "), result.Review, overdue, result.Breach.Objective)
+	}
+	return nil
+}
+
+// nudgeReviews reminds people of the reviews that are overdue for the objectives of the SLA.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func nudgeReviews(repo repository.Repo, args []string) error {
+	nudgeFlagSet.Parse(args)
+	if len(nudgeFlagSet.Args()) > 0 {
+		return errors.New("The nudge command does not take any arguments.")
+	}
+	sla, err := loadSLA(repo)
+	if err != nil {
+		return err
+	}
+	if sla.IsZero() {
+		return errors.New("There is no SLA to nudge about; set appraise.slaFirstResponse or appraise.slaResolution to a duration such as 24h.")
+	}
+	for {
+		err := runAutoSynced(repo, func() error {
+			results, err := nudgeOverdueReviews(repo, sla, time.Now())
+			if err != nil {
+				return err
+			}
+			return printNudges(results)
+		})
+		if err != nil {
+			if !*nudgeWatch {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if !*nudgeWatch {
+			return nil
+		}
+		select {
+		case <-time.After(*nudgeInterval):
+		case <-repo.Context().Done():
+			return nil
+		}
+	}
+}
+
+// nudgeCmd defines the "nudge" subcommand.
+var nudgeCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s nudge [<option>...]\n\nOptions:\n", arg0)
+		nudgeFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return nudgeReviews(repo, args)
+	},
+	NoSync: true,
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/stats"
+	"testing"
+	"time"
+)
+
+func TestNudgeOverdueReviews(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	sla := stats.SLA{Resolution: 24 * time.Hour}
+	results, err := nudgeOverdueReviews(repo, sla, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 {
+		t.Fatal("Failed to nudge about any of the overdue reviews")
+	}
+	for _, result := range results {
+		if result.Breach.Objective != stats.ObjectiveResolution || result.Breach.Met || len(result.Nudged) == 0 {
+			t.Errorf("Unexpected nudge: %+v", result)
+		}
+	}
+	again, err := nudgeOverdueReviews(repo, sla, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 0 {
+		t.Fatalf("Nudged about the same reviews again: %+v", again)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	if d, err := parseDuration("2d"); err != nil || d != 48*time.Hour {
+		t.Fatalf("Unexpected duration of 2d: %v, %v", d, err)
+	}
+	if d, err := parseDuration("90m"); err != nil || d != 90*time.Minute {
+		t.Fatalf("Unexpected duration of 90m: %v, %v", d, err)
+	}
+	if _, err := parseDuration("soon"); err == nil {
+		t.Fatal("Accepted an invalid duration")
+	}
+}
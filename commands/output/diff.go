@@ -0,0 +1,522 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"fmt"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/schema"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ANSI escape sequences used when printing a diff in color.
+const (
+	colorReset   = "\x1b[m"
+	colorBold    = "\x1b[1m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorCyan    = "\x1b[36m"
+	colorReverse = "\x1b[7m"
+	diffMetaLine = "diff --git "
+)
+
+// maxWordDiffTokens is the most words that a changed line may have for the words that changed within it to be highlighted.
+const maxWordDiffTokens = 500
+
+// DiffStyle selects how a diff is printed.
+type DiffStyle struct {
+	// Color colors each line of a diff by its type.
+	Color bool
+	// Syntax highlights the code in the lines of a diff by the language of their file.
+	Syntax bool
+	// Words highlights the words that changed within each pair of a removed and an added line.
+	Words bool
+	// Moved dims the removed and added lines that only moved code, rather than changing it.
+	Moved bool
+}
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// lineAnchor identifies a line in the new version of a file.
+type lineAnchor struct {
+	Path string
+	Line uint32
+}
+
+// anchorThreads groups the given comment threads by the file line that they comment on.
+//
+// Threads on a whole file are keyed by a line number of 0, and threads that
+// are not anchored to any file, or whose anchor is outdated, are returned
+// separately.
+func anchorThreads(threads []review.CommentThread) (map[lineAnchor][]review.CommentThread, []review.CommentThread) {
+	anchored := make(map[lineAnchor][]review.CommentThread)
+	var unanchored []review.CommentThread
+	for _, thread := range threads {
+		location := thread.Comment.Location
+		if location == nil || location.Path == "" || thread.Outdated {
+			unanchored = append(unanchored, thread)
+			continue
+		}
+		anchor := lineAnchor{Path: location.Path}
+		if location.Range != nil {
+			anchor.Line = location.Range.StartLine
+		}
+		anchored[anchor] = append(anchored[anchor], thread)
+	}
+	return anchored, unanchored
+}
+
+// colorizeDiffLine returns the given line of a unified diff wrapped in the color matching its type.
+func colorizeDiffLine(line string) string {
+	switch {
+	case strings.HasPrefix(line, diffMetaLine), strings.HasPrefix(line, "index "),
+		strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "):
+		return colorBold + line + colorReset
+	case strings.HasPrefix(line, "@@"):
+		return colorCyan + line + colorReset
+	case strings.HasPrefix(line, "+"):
+		return colorGreen + line + colorReset
+	case strings.HasPrefix(line, "-"):
+		return colorRed + line + colorReset
+	}
+	return line
+}
+
+// hunkLineColor returns the color of the given line of a hunk, or an empty string if it is not colored.
+func hunkLineColor(line string) string {
+	switch {
+	case strings.HasPrefix(line, "+"):
+		return colorGreen
+	case strings.HasPrefix(line, "-"):
+		return colorRed
+	}
+	return ""
+}
+
+// highlightDiffLine returns the given line of a hunk with its code highlighted in the given language.
+//
+// The highlighted code keeps the color of the line's type wherever it is not highlighted.
+func highlightDiffLine(line, language, color string) string {
+	if line == "" {
+		return line
+	}
+	code := strings.Replace(highlightCode(language, line[1:]), colorReset, colorReset+color, -1)
+	return color + line[:1] + code + colorReset
+}
+
+// wordPattern splits lines into the words that are compared to find the changes within them.
+var wordPattern = regexp.MustCompile(`\w+|\s+|[^\w\s]`)
+
+// diffWords returns which of the words of the old and new versions of a line were changed.
+//
+// The words that are not changed are the longest common subsequence of the
+// two. If the lines have no words in common other than whitespace, then they
+// are too different for highlighting the changes to help, so nil is returned.
+func diffWords(oldWords, newWords []string) (oldChanged, newChanged []bool) {
+	// lengths[i][j] is the length of the longest common subsequence of oldWords[i:] and newWords[j:].
+	lengths := make([][]int, len(oldWords)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(newWords)+1)
+	}
+	for i := len(oldWords) - 1; i >= 0; i-- {
+		for j := len(newWords) - 1; j >= 0; j-- {
+			if oldWords[i] == newWords[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	oldChanged, newChanged = make([]bool, len(oldWords)), make([]bool, len(newWords))
+	common := false
+	i, j := 0, 0
+	for i < len(oldWords) && j < len(newWords) {
+		switch {
+		case oldWords[i] == newWords[j]:
+			common = common || strings.TrimSpace(oldWords[i]) != ""
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			oldChanged[i] = true
+			i++
+		default:
+			newChanged[j] = true
+			j++
+		}
+	}
+	for ; i < len(oldWords); i++ {
+		oldChanged[i] = true
+	}
+	for ; j < len(newWords); j++ {
+		newChanged[j] = true
+	}
+	if !common {
+		return nil, nil
+	}
+	return oldChanged, newChanged
+}
+
+// highlightWords returns the given line of a hunk with the changed words highlighted within the color of its type.
+func highlightWords(line string, words []string, changed []bool, color string) string {
+	var highlighted strings.Builder
+	highlighted.WriteString(color + line[:1])
+	for i, word := range words {
+		if changed[i] {
+			highlighted.WriteString(colorReverse + word + colorReset + color)
+		} else {
+			highlighted.WriteString(word)
+		}
+	}
+	highlighted.WriteString(colorReset)
+	return highlighted.String()
+}
+
+// highlightChanges pairs up the given removed and added lines of a hunk, in
+// order, and highlights the words that changed between each pair.
+//
+// The lines that could not be paired, or that are too different for the
+// changes to be highlighted, are left as they were.
+func highlightChanges(removed, added []string) {
+	for i := 0; i < len(removed) && i < len(added); i++ {
+		oldWords := wordPattern.FindAllString(removed[i][1:], -1)
+		newWords := wordPattern.FindAllString(added[i][1:], -1)
+		if len(oldWords) > maxWordDiffTokens || len(newWords) > maxWordDiffTokens {
+			continue
+		}
+		oldChanged, newChanged := diffWords(oldWords, newWords)
+		if oldChanged == nil {
+			continue
+		}
+		removed[i] = highlightWords(removed[i], oldWords, oldChanged, colorRed)
+		added[i] = highlightWords(added[i], newWords, newChanged, colorGreen)
+	}
+}
+
+// minMovedBlockLetters is the fewest letters and digits that a block of lines needs to be considered moved.
+const minMovedBlockLetters = 20
+
+// normalizeSpace returns the given code with its indentation removed and each run of whitespace within it collapsed.
+func normalizeSpace(code string) string {
+	return strings.Join(strings.Fields(code), " ")
+}
+
+// countAlphanumeric returns the number of letters and digits in the given string.
+func countAlphanumeric(s string) int {
+	count := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			count++
+		}
+	}
+	return count
+}
+
+// movedLines returns the indices of the given lines of a unified diff that only moved code.
+//
+// A removed line was moved if the same line, ignoring changes to its
+// whitespace, was added anywhere in the diff, and vice versa. As with git's
+// "--color-moved=blocks", only the runs of such lines with at least 20 letters
+// and digits between them count as moved, so that lines such as closing braces
+// do not.
+func movedLines(lines []string) map[int]bool {
+	removed, added := make(map[string]bool), make(map[string]bool)
+	// changed holds the indices of the removed and added lines of the hunks, with their normalized code.
+	changed := make(map[int]string)
+	inHunk := false
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, diffMetaLine):
+			inHunk = false
+		case hunkHeaderPattern.MatchString(line):
+			inHunk = true
+		case inHunk && strings.HasPrefix(line, "-"):
+			changed[i] = normalizeSpace(line[1:])
+			removed[changed[i]] = true
+		case inHunk && strings.HasPrefix(line, "+"):
+			changed[i] = normalizeSpace(line[1:])
+			added[changed[i]] = true
+		}
+	}
+	moved := make(map[int]bool)
+	var block []int
+	letters := 0
+	flushBlock := func() {
+		if letters >= minMovedBlockLetters {
+			for _, index := range block {
+				moved[index] = true
+			}
+		}
+		block, letters = nil, 0
+	}
+	for i, line := range lines {
+		code, ok := changed[i]
+		if !ok || code == "" || (line[0] == '-' && !added[code]) || (line[0] == '+' && !removed[code]) {
+			flushBlock()
+			continue
+		}
+		if len(block) > 0 && lines[block[len(block)-1]][0] != line[0] {
+			flushBlock()
+		}
+		block = append(block, i)
+		letters += countAlphanumeric(code)
+	}
+	flushBlock()
+	return moved
+}
+
+// colorizeDiff returns the lines of the given unified diff, colored in the given style.
+func colorizeDiff(lines []string, style DiffStyle) []string {
+	colored := make([]string, len(lines))
+	copy(colored, lines)
+	if !style.Color {
+		return colored
+	}
+	var moved map[int]bool
+	if style.Moved {
+		moved = movedLines(lines)
+	}
+	language := ""
+	inHunk := false
+	// removed and added are the indices of the current run of removed lines, and of the added lines that follow it.
+	var removed, added []int
+	flushChanges := func() {
+		if style.Words && len(removed) > 0 && len(added) > 0 {
+			oldLines, newLines := make([]string, len(removed)), make([]string, len(added))
+			for i, index := range removed {
+				oldLines[i] = lines[index]
+			}
+			for i, index := range added {
+				newLines[i] = lines[index]
+			}
+			highlightChanges(oldLines, newLines)
+			for i, index := range removed {
+				if oldLines[i] != lines[index] {
+					colored[index] = oldLines[i]
+				}
+			}
+			for i, index := range added {
+				if newLines[i] != lines[index] {
+					colored[index] = newLines[i]
+				}
+			}
+		}
+		removed, added = nil, nil
+	}
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, diffMetaLine):
+			flushChanges()
+			inHunk = false
+			language = ""
+		case !inHunk && strings.HasPrefix(line, "+++ "):
+			language = fileLanguage(strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/"))
+		case hunkHeaderPattern.MatchString(line):
+			flushChanges()
+			inHunk = true
+		case inHunk && moved[i]:
+			flushChanges()
+		case inHunk && strings.HasPrefix(line, "-"):
+			if len(added) > 0 {
+				flushChanges()
+			}
+			removed = append(removed, i)
+		case inHunk && strings.HasPrefix(line, "+"):
+			added = append(added, i)
+		default:
+			flushChanges()
+		}
+		color := hunkLineColor(line)
+		switch {
+		case !inHunk || strings.HasPrefix(line, "@@"):
+			colored[i] = colorizeDiffLine(line)
+		case moved[i]:
+			colored[i] = colorDim + color + line + colorReset
+		case style.Syntax && language != "" && !strings.HasPrefix(line, "\\"):
+			colored[i] = highlightDiffLine(line, language, color)
+		case color != "":
+			colored[i] = color + line + colorReset
+		}
+	}
+	flushChanges()
+	return colored
+}
+
+// printInlineThreads prints the given comment threads in between the lines of a diff, indented by the given prefix.
+func printInlineThreads(r *review.Review, threads []review.CommentThread, color bool, indent string) error {
+	for _, thread := range threads {
+		if color {
+			fmt.Print(colorYellow)
+		}
+		fmt.Printf(threadStateTemplate, indent, thread.Hash, getThreadStateString(thread))
+		if location := thread.Comment.Location; thread.Outdated && location != nil {
+			var line uint32
+			if location.Range != nil {
+				line = location.Range.StartLine
+			}
+			fmt.Printf("%s  originally on %s:%d at %.12s\n", indent, location.Path, line, location.Commit)
+		}
+		if err := showSubThread(r, thread, indent); err != nil {
+			return err
+		}
+		if color {
+			fmt.Print(colorReset)
+		}
+	}
+	return nil
+}
+
+// PrintInlineDiff prints the given diff of the review, with each comment
+// thread interleaved after the line that it comments on.
+//
+// Comments are matched against the lines of the new version of each file.
+// Threads that do not match any line in the diff are printed after it.
+func PrintInlineDiff(r *review.Review, diff string, style DiffStyle) error {
+	anchored, unanchored := anchorThreads(r.Comments)
+	printed := make(map[lineAnchor]bool)
+	printAnchor := func(anchor lineAnchor) error {
+		if printed[anchor] {
+			return nil
+		}
+		printed[anchor] = true
+		return printInlineThreads(r, anchored[anchor], style.Color, "    ")
+	}
+
+	var path string
+	var newLine uint32
+	inHunk := false
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	colored := colorizeDiff(lines, style)
+	for i, line := range lines {
+		fmt.Println(colored[i])
+		switch {
+		case strings.HasPrefix(line, diffMetaLine):
+			inHunk = false
+		case !inHunk && strings.HasPrefix(line, "+++ "):
+			path = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			if err := printAnchor(lineAnchor{Path: path}); err != nil {
+				return err
+			}
+		case hunkHeaderPattern.MatchString(line):
+			start, _ := strconv.ParseUint(hunkHeaderPattern.FindStringSubmatch(line)[1], 10, 32)
+			newLine = uint32(start)
+			inHunk = true
+		case inHunk && (strings.HasPrefix(line, "+") || strings.HasPrefix(line, " ")):
+			if err := printAnchor(lineAnchor{Path: path, Line: newLine}); err != nil {
+				return err
+			}
+			newLine++
+		}
+	}
+
+	for anchor, threads := range anchored {
+		if !printed[anchor] {
+			unanchored = append(unanchored, threads...)
+		}
+	}
+	if len(unanchored) > 0 {
+		fmt.Printf("\nComments not attached to any line in the diff:\n")
+		sort.SliceStable(unanchored, func(i, j int) bool {
+			return schema.CompareTimestamps(unanchored[i].Comment.Timestamp, unanchored[j].Comment.Timestamp) < 0
+		})
+		return printInlineThreads(r, unanchored, style.Color, "    ")
+	}
+	return nil
+}
+
+// formatBinaryVersion describes a version of a binary file, given its size and, for images, its dimensions.
+func formatBinaryVersion(size, width, height int) string {
+	if width > 0 || height > 0 {
+		return fmt.Sprintf("%d bytes, %dx%d", size, width, height)
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+// describeBinaryChange returns a one-line description of the change to a binary file.
+func describeBinaryChange(change review.BinaryDiff) string {
+	kind := "Binary file"
+	if change.ContentType != "" {
+		kind = fmt.Sprintf("Binary file (%s)", strings.SplitN(change.ContentType, ";", 2)[0])
+	}
+	oldVersion := formatBinaryVersion(change.OldSize, change.OldWidth, change.OldHeight)
+	newVersion := formatBinaryVersion(change.NewSize, change.NewWidth, change.NewHeight)
+	switch {
+	case change.OldBlob == "":
+		return fmt.Sprintf("%s %s added: %s", kind, change.Path, newVersion)
+	case change.NewBlob == "":
+		return fmt.Sprintf("%s %s removed: %s", kind, change.Path, oldVersion)
+	}
+	return fmt.Sprintf("%s %s changed: %s -> %s (%+d bytes)", kind, change.Path, oldVersion, newVersion, change.NewSize-change.OldSize)
+}
+
+// PrintBinaryDiffs prints a description of each of the changes that a review makes to binary files.
+func PrintBinaryDiffs(diffs []review.BinaryDiff, style DiffStyle) {
+	if len(diffs) == 0 {
+		return
+	}
+	fmt.Println()
+	for _, change := range diffs {
+		description := describeBinaryChange(change)
+		if style.Color {
+			description = colorBold + description + colorReset
+		}
+		fmt.Println(description)
+	}
+}
+
+// describeSubmoduleChange returns a one-line description of the change to the commit of a submodule.
+func describeSubmoduleChange(change review.SubmoduleDiff) string {
+	switch {
+	case change.OldCommit == "":
+		return fmt.Sprintf("Submodule %s added at %.12s", change.Path, change.NewCommit)
+	case change.NewCommit == "":
+		return fmt.Sprintf("Submodule %s removed from %.12s", change.Path, change.OldCommit)
+	}
+	return fmt.Sprintf("Submodule %s %.12s..%.12s", change.Path, change.OldCommit, change.NewCommit)
+}
+
+// PrintSubmoduleDiffs prints the changes that a review makes to the commits
+// of its submodules, each followed by the diff of its commit range within the
+// submodule.
+func PrintSubmoduleDiffs(diffs []review.SubmoduleDiff, style DiffStyle) {
+	for _, change := range diffs {
+		header := describeSubmoduleChange(change)
+		if style.Color {
+			header = colorBold + header + colorReset
+		}
+		fmt.Printf("\n%s\n", header)
+		if change.Review != "" {
+			fmt.Printf("  review: %.12s\n", change.Review)
+		}
+		if !change.CheckedOut {
+			fmt.Printf("  not checked out; run \"git submodule update --init %s\" to show its diff\n", change.Path)
+			continue
+		}
+		if change.Diff == "" {
+			continue
+		}
+		for _, line := range colorizeDiff(strings.Split(strings.TrimSuffix(change.Diff, "\n"), "\n"), style) {
+			fmt.Println(line)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"testing"
+)
+
+func TestAnchorThreads(t *testing.T) {
+	lineComment := comment.New("user@example.com", "On a line")
+	lineComment.Location = &comment.Location{Commit: "A", Path: "main.go", Range: &comment.Range{StartLine: 3}}
+	fileComment := comment.New("user@example.com", "On a file")
+	fileComment.Location = &comment.Location{Commit: "A", Path: "main.go"}
+	reviewComment := comment.New("user@example.com", "On the review")
+	threads := []review.CommentThread{
+		{Hash: "line", Comment: lineComment},
+		{Hash: "file", Comment: fileComment},
+		{Hash: "review", Comment: reviewComment},
+	}
+	anchored, unanchored := anchorThreads(threads)
+	if lineThreads := anchored[lineAnchor{Path: "main.go", Line: 3}]; len(lineThreads) != 1 || lineThreads[0].Hash != "line" {
+		t.Fatalf("Unexpected threads anchored at the line: %v", lineThreads)
+	}
+	if fileThreads := anchored[lineAnchor{Path: "main.go"}]; len(fileThreads) != 1 || fileThreads[0].Hash != "file" {
+		t.Fatalf("Unexpected threads anchored at the file: %v", fileThreads)
+	}
+	if len(unanchored) != 1 || unanchored[0].Hash != "review" {
+		t.Fatalf("Unexpected unanchored threads: %v", unanchored)
+	}
+}
+
+func TestColorizeDiff(t *testing.T) {
+	lines := []string{
+		"diff --git a/notes.txt b/notes.txt",
+		"--- a/notes.txt",
+		"+++ b/notes.txt",
+		"@@ -1,3 +1,3 @@",
+		" unchanged",
+		"-the quick fox",
+		"+the quick cat",
+		"+nothing in common",
+	}
+	colored := colorizeDiff(lines, DiffStyle{Color: true, Syntax: true, Words: true})
+	expected := []string{
+		colorBold + lines[0] + colorReset,
+		colorBold + lines[1] + colorReset,
+		colorBold + lines[2] + colorReset,
+		colorCyan + lines[3] + colorReset,
+		lines[4],
+		colorRed + "-the quick " + colorReverse + "fox" + colorReset + colorRed + colorReset,
+		colorGreen + "+the quick " + colorReverse + "cat" + colorReset + colorGreen + colorReset,
+		colorGreen + lines[7] + colorReset,
+	}
+	for i := range expected {
+		if colored[i] != expected[i] {
+			t.Errorf("Unexpected coloring of %q: %q", lines[i], colored[i])
+		}
+	}
+	if plain := colorizeDiff(lines, DiffStyle{}); plain[6] != lines[6] {
+		t.Errorf("Unexpected coloring without color: %q", plain[6])
+	}
+	if oldChanged, _ := diffWords([]string{"a", " ", "b"}, []string{"c", " ", "d"}); oldChanged != nil {
+		t.Errorf("Unexpected highlighting of lines with nothing in common: %v", oldChanged)
+	}
+}
+
+func TestColorizeDiffSyntax(t *testing.T) {
+	lines := []string{
+		"+++ b/main.go",
+		"@@ -1 +1 @@",
+		"+return nil",
+	}
+	colored := colorizeDiff(lines, DiffStyle{Color: true, Syntax: true})
+	expected := colorGreen + "+" + colorBlue + colorBold + "return" + colorReset + colorGreen + " " +
+		colorBlue + colorBold + "nil" + colorReset + colorGreen + colorReset
+	if colored[2] != expected {
+		t.Errorf("Unexpected highlighting: %q", colored[2])
+	}
+}
+
+func TestMovedLines(t *testing.T) {
+	lines := []string{
+		"diff --git a/old.go b/old.go",
+		"--- a/old.go",
+		"+++ b/old.go",
+		"@@ -1,4 +1,1 @@",
+		"-func parseConfig(path string) error {",
+		"-	return readConfigFile(path)",
+		"-}",
+		" // The end.",
+		"diff --git a/new.go b/new.go",
+		"--- a/new.go",
+		"+++ b/new.go",
+		"@@ -1,1 +1,6 @@",
+		"+func parseConfig(path string) error {",
+		"+		return readConfigFile(path)",
+		"+}",
+		"+// Helpers.",
+		"+}",
+	}
+	moved := movedLines(lines)
+	for _, i := range []int{4, 5, 6, 12, 13, 14} {
+		if !moved[i] {
+			t.Errorf("Expected %q to be moved", lines[i])
+		}
+	}
+	if moved[15] || moved[16] {
+		t.Errorf("Unexpected moving of new code or of a lone closing brace")
+	}
+	colored := colorizeDiff(lines, DiffStyle{Color: true, Moved: true})
+	if expected := colorDim + colorGreen + lines[13] + colorReset; colored[13] != expected {
+		t.Errorf("Unexpected coloring of a moved line: %q", colored[13])
+	}
+	if expected := colorGreen + lines[16] + colorReset; colored[16] != expected {
+		t.Errorf("Unexpected coloring of an added line: %q", colored[16])
+	}
+}
+
+func TestDescribeBinaryChange(t *testing.T) {
+	change := review.BinaryDiff{
+		BinaryChange: repository.BinaryChange{Path: "logo.png", OldBlob: "a", NewBlob: "b"},
+		ContentType:  "image/png",
+		OldSize:      100,
+		NewSize:      80,
+		OldWidth:     16,
+		OldHeight:    16,
+		NewWidth:     32,
+		NewHeight:    32,
+	}
+	expected := "Binary file (image/png) logo.png changed: 100 bytes, 16x16 -> 80 bytes, 32x32 (-20 bytes)"
+	if description := describeBinaryChange(change); description != expected {
+		t.Errorf("Unexpected description of a changed image: %q", description)
+	}
+	change = review.BinaryDiff{BinaryChange: repository.BinaryChange{Path: "data.bin", OldBlob: "a"}, OldSize: 256}
+	if description := describeBinaryChange(change); description != "Binary file data.bin removed: 256 bytes" {
+		t.Errorf("Unexpected description of a removed file: %q", description)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"fmt"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/schema"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Formats are the built-in templates for printing reviews, by name.
+var Formats = map[string]string{
+	"oneline":  `{{short .Revision}} [{{status .}}] {{subject .Request.Description}}`,
+	"short":    "review {{.Revision}}\nStatus:    {{status .}}\nRequester: {{.Request.Requester}}\nReviewers: {{join .Request.Reviewers \", \"}}\n\n    {{subject .Request.Description}}\n",
+	"markdown": `- {{subject .Request.Description}} ({{short .Revision}}{{with .Request.Requester}}, by {{.}}{{end}}){{with .Request.Labels}} [{{join . ", "}}]{{end}}`,
+}
+
+// formatFuncs are the functions that the templates for printing reviews may call.
+var formatFuncs = template.FuncMap{
+	"status": func(r *review.Review) string {
+		return getStatusString(r.Summary)
+	},
+	"short": func(hash string) string {
+		if len(hash) > 12 {
+			return hash[:12]
+		}
+		return hash
+	},
+	"subject": func(description string) string {
+		return strings.TrimSpace(strings.SplitN(description, "\n", 2)[0])
+	},
+	"body": func(description string) string {
+		parts := strings.SplitN(description, "\n", 2)
+		if len(parts) < 2 {
+			return ""
+		}
+		return strings.TrimSpace(parts[1])
+	},
+	"date": func(timestamp string) string {
+		t, err := schema.ParseTimestamp(timestamp)
+		if err != nil {
+			return timestamp
+		}
+		return t.Local().Format("2006-01-02")
+	},
+	"time": reformatTimestamp,
+	"join": strings.Join,
+	"indent": func(prefix, text string) string {
+		return prefix + strings.Replace(text, "\n", "\n"+prefix, -1)
+	},
+}
+
+// FormatNames returns the names of the built-in templates, sorted.
+func FormatNames() []string {
+	var names []string
+	for name := range Formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseFormat parses the template for printing reviews that was passed to the "format" flag.
+//
+// The format is either the name of one of the built-in templates, "@" followed
+// by the path of a file that contains a template, or the text of a template.
+// Each review is printed on its own line, so a newline is added to the end of
+// the template if it does not already have one.
+func ParseFormat(format string) (*template.Template, error) {
+	text, ok := Formats[format]
+	if !ok && strings.HasPrefix(format, "@") {
+		contents, err := ioutil.ReadFile(strings.TrimPrefix(format, "@"))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the template: %v", err)
+		}
+		text = string(contents)
+	} else if !ok {
+		text = format
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	t, err := template.New("format").Funcs(formatFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid format %q, which is neither a template nor one of %s: %v", format, strings.Join(FormatNames(), ", "), err)
+	}
+	return t, nil
+}
+
+// PrintFormatted writes the given review to w using the given template.
+//
+// To print just the summary of a review, wrap it in a review without any reports, events, or robot comments.
+func PrintFormatted(w io.Writer, t *template.Template, r *review.Review) error {
+	return t.Execute(w, r)
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"bytes"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/request"
+	"testing"
+)
+
+func TestPrintFormatted(t *testing.T) {
+	r := &review.Review{Summary: &review.Summary{
+		Revision: "0123456789abcdef0123",
+		Request: request.Request{
+			Requester:   "user@example.com",
+			Reviewers:   []string{"a@example.com", "b@example.com"},
+			Description: "Fix the parser\n\nIt used to crash on empty input.",
+			Labels:      []string{"bug"},
+		},
+	}}
+	for format, expected := range map[string]string{
+		"oneline":  "0123456789ab [pending] Fix the parser\n",
+		"markdown": "- Fix the parser (0123456789ab, by user@example.com) [bug]\n",
+		"short": "review 0123456789abcdef0123\nStatus:    pending\nRequester: user@example.com\n" +
+			"Reviewers: a@example.com, b@example.com\n\n    Fix the parser\n",
+		`{{.Request.Requester}}: {{body .Request.Description}}`: "user@example.com: It used to crash on empty input.\n",
+	} {
+		tmpl, err := ParseFormat(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := PrintFormatted(&out, tmpl, r); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != expected {
+			t.Errorf("Unexpected output for the format %q: %q", format, out.String())
+		}
+	}
+	if _, err := ParseFormat("{{.Revision"); err == nil {
+		t.Error("Failed to reject an invalid template")
+	}
+}
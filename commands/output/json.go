@@ -0,0 +1,66 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JSONFormatVersion defines the version of the JSON output format.
+//
+// This must be incremented whenever a change is made to the output that
+// could break an existing consumer, such as removing or renaming a field.
+const JSONFormatVersion = 1
+
+// jsonResult is the envelope in which every command result is written in JSON mode.
+type jsonResult struct {
+	Version int         `json:"v"`
+	Command string      `json:"command"`
+	Result  interface{} `json:"result"`
+	Error   string      `json:"error,omitempty"`
+	// ExitCode is the exit code that the tool exits with after the error.
+	ExitCode int `json:"exitCode,omitempty"`
+}
+
+func printJSONResult(result jsonResult) error {
+	bytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(bytes))
+	return nil
+}
+
+// PrintJSONResult prints the result of the given command in the versioned JSON format.
+func PrintJSONResult(command string, result interface{}) error {
+	return printJSONResult(jsonResult{
+		Version: JSONFormatVersion,
+		Command: command,
+		Result:  result,
+	})
+}
+
+// PrintJSONError prints the error returned by the given command, and the exit code that it causes, in the versioned JSON format.
+func PrintJSONError(command string, err error, exitCode int) error {
+	return printJSONResult(jsonResult{
+		Version:  JSONFormatVersion,
+		Command:  command,
+		Error:    err.Error(),
+		ExitCode: exitCode,
+	})
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// captureStdout returns everything that the given function prints to stdout.
+func captureStdout(t *testing.T, print func() error) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	printErr := print()
+	os.Stdout = stdout
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printErr != nil {
+		t.Fatal(printErr)
+	}
+	return string(out)
+}
+
+func TestPrintJSONResult(t *testing.T) {
+	out := captureStdout(t, func() error {
+		return PrintJSONResult("show", map[string]string{"revision": "abcd"})
+	})
+	expected := `{
+  "v": 1,
+  "command": "show",
+  "result": {
+    "revision": "abcd"
+  }
+}
+`
+	if out != expected {
+		t.Errorf("Unexpected JSON result: got %q, want %q", out, expected)
+	}
+}
+
+func TestPrintJSONError(t *testing.T) {
+	out := captureStdout(t, func() error {
+		return PrintJSONError("show", errors.New("There is no matching review."), 3)
+	})
+	expected := `{
+  "v": 1,
+  "command": "show",
+  "result": null,
+  "error": "There is no matching review.",
+  "exitCode": 3
+}
+`
+	if out != expected {
+		t.Errorf("Unexpected JSON error: got %q, want %q", out, expected)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Markdown specifies that review descriptions and comments are rendered from
+// Markdown for a terminal, using ANSI escape sequences, rather than being
+// printed as they were written.
+var Markdown bool
+
+// ANSI escape sequences used when rendering Markdown, on top of the ones used for diffs.
+const (
+	colorDim       = "\x1b[2m"
+	colorItalic    = "\x1b[3m"
+	colorUnderline = "\x1b[4m"
+	colorBlue      = "\x1b[34m"
+	colorMagenta   = "\x1b[35m"
+)
+
+var (
+	fencePattern      = regexp.MustCompile("^\\s*(```|~~~)\\s*([\\w+#-]*)")
+	headingPattern    = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*\s*$`)
+	bulletPattern     = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	quotePattern      = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	rulePattern       = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+	inlineCodePattern = regexp.MustCompile("`[^`]+`")
+	boldPattern       = regexp.MustCompile(`\*\*([^*]+)\*\*|\b__([^_]+)__\b`)
+	italicPattern     = regexp.MustCompile(`\*([^*\s][^*]*)\*|\b_([^_\s][^_]*)_\b`)
+	linkPattern       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+)
+
+// renderDescription returns the given description or comment, rendered from Markdown if that is enabled.
+func renderDescription(text string) string {
+	if !Markdown {
+		return text
+	}
+	return RenderMarkdown(text)
+}
+
+// RenderMarkdown renders the given Markdown text for a terminal.
+//
+// Only the common subset of Markdown is supported: headings, bold and italic
+// text, inline code, links, lists, block quotes, rules, and fenced code
+// blocks, whose contents are highlighted if they name a known language.
+// Anything else is left as it was written.
+func RenderMarkdown(text string) string {
+	var rendered []string
+	fence, language := "", ""
+	for _, line := range strings.Split(text, "\n") {
+		if match := fencePattern.FindStringSubmatch(line); match != nil && (fence == "" || match[1] == fence) {
+			if fence == "" {
+				fence, language = match[1], strings.ToLower(match[2])
+			} else {
+				fence, language = "", ""
+			}
+			continue
+		}
+		if fence != "" {
+			rendered = append(rendered, "    "+highlightCode(language, line))
+			continue
+		}
+		rendered = append(rendered, renderLine(line))
+	}
+	return strings.Join(rendered, "\n")
+}
+
+// renderLine renders a single line of Markdown that is not within a code block.
+func renderLine(line string) string {
+	if match := headingPattern.FindStringSubmatch(line); match != nil {
+		return colorBold + colorUnderline + match[1] + colorReset
+	}
+	if rulePattern.MatchString(line) {
+		return colorDim + strings.Repeat("─", 40) + colorReset
+	}
+	if match := bulletPattern.FindStringSubmatch(line); match != nil {
+		return match[1] + "• " + renderInline(match[2])
+	}
+	if match := quotePattern.FindStringSubmatch(line); match != nil {
+		return colorDim + "│ " + colorReset + renderInline(match[1])
+	}
+	return renderInline(line)
+}
+
+// renderInline renders the emphasis, code, and links within a line of Markdown.
+//
+// The contents of inline code are left alone, so that they are shown as written.
+func renderInline(line string) string {
+	var rendered strings.Builder
+	last := 0
+	for _, span := range inlineCodePattern.FindAllStringIndex(line, -1) {
+		rendered.WriteString(renderEmphasis(line[last:span[0]]))
+		rendered.WriteString(colorCyan + line[span[0]+1:span[1]-1] + colorReset)
+		last = span[1]
+	}
+	rendered.WriteString(renderEmphasis(line[last:]))
+	return rendered.String()
+}
+
+// renderEmphasis renders the bold and italic text and the links within some Markdown text.
+func renderEmphasis(text string) string {
+	text = linkPattern.ReplaceAllString(text, colorUnderline+"$1"+colorReset+" ($2)")
+	text = boldPattern.ReplaceAllString(text, colorBold+"$1$2"+colorReset)
+	return italicPattern.ReplaceAllString(text, colorItalic+"$1$2"+colorReset)
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	for text, expected := range map[string]string{
+		"plain snake_case text":        "plain snake_case text",
+		"a **bold** and *italic* word": "a " + colorBold + "bold" + colorReset + " and " + colorItalic + "italic" + colorReset + " word",
+		"- `a *b*` item":               "• " + colorCyan + "a *b*" + colorReset + " item",
+		"## Heading ##":                colorBold + colorUnderline + "Heading" + colorReset,
+		"> quoted":                     colorDim + "│ " + colorReset + "quoted",
+		"see [docs](http://x.io)":      "see " + colorUnderline + "docs" + colorReset + " (http://x.io)",
+		"```go\nreturn 1 // done\n```": "    " + colorBlue + colorBold + "return" + colorReset + " " + colorMagenta + "1" + colorReset + " " + colorDim + "// done" + colorReset,
+		"```\n**not bold**\n```":       "    " + colorDim + "**not bold**" + colorReset,
+	} {
+		if rendered := RenderMarkdown(text); rendered != expected {
+			t.Errorf("Unexpected rendering of %q: %q", text, rendered)
+		}
+	}
+}
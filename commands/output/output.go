@@ -20,14 +20,22 @@ package output
 import (
 	"fmt"
 	"github.com/promet/git-appraise/review"
-	"strconv"
+	"github.com/promet/git-appraise/review/audit"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/event"
+	"github.com/promet/git-appraise/review/issue"
+	"github.com/promet/git-appraise/review/reaction"
+	"github.com/promet/git-appraise/review/robot"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
 	"strings"
 	"time"
 )
 
 const (
 	// Template for printing the summary of a code review.
-	reviewSummaryTemplate = `[%s] %.12s
+	reviewSummaryTemplate = `[%s] %s%.12s
   %s
 `
 	// Template for printing the summary of a code review.
@@ -46,7 +54,16 @@ time:   %s
 status: %s
 %s`
 	// Template for displaying the summary of the comment threads for a review
-	commentSummaryTemplate = `  comments (%d threads):
+	commentSummaryTemplate = `  comments (%d threads, %d open):
+`
+	// Template for displaying the summary of the robot comments for a review
+	robotSummaryTemplate = `  robot comments (%d findings, %d open):
+`
+	// Template for displaying the state of a comment thread
+	threadStateTemplate = `%sthread %.12s: %s
+`
+	// Template for displaying a single build artifact
+	artifactTemplate = `      %s (%s): %s
 `
 	// Number of lines of context to print for inline comments
 	contextLineCount = 5
@@ -55,6 +72,9 @@ status: %s
 // getStatusString returns a human friendly string encapsulating both the review's
 // resolved status, and its submitted status.
 func getStatusString(r *review.Summary) string {
+	if r.IsDraft() {
+		return "draft"
+	}
 	if r.Resolved == nil && r.Submitted {
 		return "tbr"
 	}
@@ -76,31 +96,100 @@ func getStatusString(r *review.Summary) string {
 	return "rejected"
 }
 
+// formatSummary returns a single-line summary of a review, followed by its description.
+//
+// The summary includes the short ID of the review, unless that is zero.
+func formatSummary(r *review.Summary, shortID int) string {
+	statusString := getStatusString(r)
+	indentedDescription := strings.Replace(renderDescription(r.Request.Description), "\n", "\n  ", -1)
+	id := ""
+	if shortID != 0 {
+		id = fmt.Sprintf("#%d ", shortID)
+	}
+	summary := fmt.Sprintf(reviewSummaryTemplate, statusString, id, r.Revision, indentedDescription)
+	if len(r.Request.Labels) > 0 {
+		summary = strings.Replace(summary, "\n", fmt.Sprintf(" (%s)\n", strings.Join(r.Request.Labels, ", ")), 1)
+	}
+	return summary
+}
+
 // PrintSummary prints a single-line summary of a review.
 func PrintSummary(r *review.Summary) {
-	statusString := getStatusString(r)
-	indentedDescription := strings.Replace(r.Request.Description, "\n", "\n  ", -1)
-	fmt.Printf(reviewSummaryTemplate, statusString, r.Revision, indentedDescription)
+	fmt.Print(formatSummary(r, 0))
 }
 
-// reformatTimestamp takes a timestamp string of the form "0123456789" and changes it
-// to the form "Mon Jan _2 13:04:05 UTC 2006".
+// PrintStack prints a summary of each of the given reviews, with every review
+// that depends upon another one indented underneath the review it depends upon.
+//
+// The short ID of each review is included if shortIDs is not nil.
+func PrintStack(reviews []review.Summary, shortIDs *review.ShortIDs) {
+	listed := make(map[string]bool)
+	for _, r := range reviews {
+		listed[r.Revision] = true
+	}
+	dependents := make(map[string][]review.Summary)
+	var roots []review.Summary
+	for _, r := range reviews {
+		if dependsOn := r.Request.DependsOn; dependsOn != "" && listed[dependsOn] && dependsOn != r.Revision {
+			dependents[dependsOn] = append(dependents[dependsOn], r)
+		} else {
+			roots = append(roots, r)
+		}
+	}
+	var printLevel func(level []review.Summary, indent string)
+	printLevel = func(level []review.Summary, indent string) {
+		for _, r := range level {
+			summary := formatSummary(&r, shortIDs.ID(r.Revision))
+			fmt.Print(indent + strings.Replace(strings.TrimSuffix(summary, "\n"), "\n", "\n"+indent, -1) + "\n")
+			printLevel(dependents[r.Revision], indent+"    ")
+		}
+	}
+	printLevel(roots, "")
+}
+
+// reformatTimestamp takes a timestamp string of the form "0123456789" or
+// "2006-01-02T15:04:05+07:00" and changes it to the form
+// "Mon Jan _2 13:04:05 UTC 2006", in the local time zone.
 //
 // Timestamps that are not in the format we expect are left alone.
 func reformatTimestamp(timestamp string) string {
-	parsedTimestamp, err := strconv.ParseInt(timestamp, 10, 64)
+	t, err := schema.ParseTimestamp(timestamp)
 	if err != nil {
 		// The timestamp is an unexpected format, so leave it alone
 		return timestamp
 	}
-	t := time.Unix(parsedTimestamp, 0)
-	return t.Format(time.UnixDate)
+	return t.Local().Format(time.UnixDate)
+}
+
+// getThreadStateString returns a human friendly string describing whether or not
+// the discussion in a comment thread is still open.
+func getThreadStateString(thread review.CommentThread) string {
+	state := getThreadResolvedString(thread)
+	if thread.Outdated {
+		state += " (outdated)"
+	}
+	return state
+}
+
+// getThreadResolvedString returns a human friendly string describing whether a comment thread has been resolved.
+func getThreadResolvedString(thread review.CommentThread) string {
+	if thread.IsOpen() {
+		return "open"
+	}
+	if thread.Resolved == nil {
+		return "fyi"
+	}
+	if thread.ResolvedBy == "" {
+		return "resolved"
+	}
+	return fmt.Sprintf("resolved by %s at %s", thread.ResolvedBy, reformatTimestamp(thread.ResolvedAt))
 }
 
 // showThread prints the detailed output for an entire comment thread.
 func showThread(r *review.Review, thread review.CommentThread) error {
 	comment := thread.Comment
 	indent := "    "
+	fmt.Printf(threadStateTemplate, indent, thread.Hash, getThreadStateString(thread))
 	if comment.Location != nil && comment.Location.Path != "" && comment.Location.Range != nil && comment.Location.Range.StartLine > 0 {
 		contents, err := r.Repo.Show(comment.Location.Commit, comment.Location.Path)
 		if err != nil {
@@ -120,6 +209,30 @@ func showThread(r *review.Review, thread review.CommentThread) error {
 	return showSubThread(r, thread, indent)
 }
 
+// formatHistory returns a summary of the earlier versions of a revised comment.
+func formatHistory(thread review.CommentThread) string {
+	versions := append([]comment.Comment{thread.Comment}, thread.Revisions[:len(thread.Revisions)-1]...)
+	lastEdit := thread.Revisions[len(thread.Revisions)-1]
+	history := fmt.Sprintf("edited: %s\nhistory:", reformatTimestamp(lastEdit.Timestamp))
+	for _, version := range versions {
+		description := strings.Replace(strings.TrimSpace(version.Description), "\n", "\n  | ", -1)
+		if description == "" && version.Encrypted != nil {
+			description = "[encrypted]"
+		}
+		history += fmt.Sprintf("\n  %s:\n  | %s", reformatTimestamp(version.Timestamp), description)
+	}
+	return history
+}
+
+// formatReactions returns a single-line summary of the reactions to a comment.
+func formatReactions(counts []reaction.Count) string {
+	var formatted []string
+	for _, count := range counts {
+		formatted = append(formatted, fmt.Sprintf("%s %d (%s)", count.Reaction, len(count.Authors), strings.Join(count.Authors, ", ")))
+	}
+	return strings.Join(formatted, ", ")
+}
+
 // showSubThread prints the given comment (sub)thread, indented by the given prefix string.
 func showSubThread(r *review.Review, thread review.CommentThread, indent string) error {
 	statusString := "fyi"
@@ -129,15 +242,52 @@ func showSubThread(r *review.Review, thread review.CommentThread, indent string)
 		} else {
 			statusString = "needs work"
 		}
+		switch thread.Comment.Level {
+		case comment.LevelApprovedWithNits:
+			statusString = "lgtm with nits"
+		case comment.LevelNeedsChanges:
+			statusString = "needs changes"
+		}
 	}
-	comment := thread.Comment
-	threadHash, err := comment.Hash()
-	if err != nil {
-		return err
+	comment := thread.Latest()
+	threadHash := thread.Hash
+	if threadHash == "" {
+		var err error
+		if threadHash, err = comment.Hash(); err != nil {
+			return err
+		}
 	}
 
 	timestamp := reformatTimestamp(comment.Timestamp)
-	commentSummary := fmt.Sprintf(indent+commentTemplate, threadHash, comment.Author, timestamp, statusString, comment.Description)
+	description := renderDescription(comment.Description)
+	if comment.Deleted {
+		description = "[deleted]"
+	}
+	if thread.Redacted != nil {
+		description = fmt.Sprintf("[redacted by %s at %s]", thread.Redacted.Author, reformatTimestamp(thread.Redacted.Timestamp))
+		if reason := thread.Redacted.Redaction.Reason; reason != "" {
+			description = description + "\nreason: " + reason
+		}
+	}
+	if comment.Suggestion != "" {
+		description = description + "\nsuggested change:\n" + strings.TrimSuffix(comment.Suggestion, "\n")
+	}
+	if comment.Encrypted != nil && !comment.Deleted {
+		if description == "" {
+			description = "[encrypted]"
+		}
+		description = description + "\nprivate to: " + strings.Join(comment.Encrypted.Recipients, ", ")
+	}
+	for _, attachment := range comment.Attachments {
+		description = description + fmt.Sprintf("\nattachment: %s (%s, %d bytes) %.12s", attachment.Name, attachment.ContentType, attachment.Size, attachment.Hash)
+	}
+	if thread.IsEdited() {
+		description = description + "\n" + formatHistory(thread)
+	}
+	if len(thread.Reactions) > 0 {
+		description = description + "\nreactions: " + formatReactions(thread.Reactions)
+	}
+	commentSummary := fmt.Sprintf(indent+commentTemplate, threadHash, comment.Author, timestamp, statusString, description)
 	indent = indent + "  "
 	indentedSummary := strings.Replace(commentSummary, "\n", "\n"+indent, -1)
 	fmt.Println(indentedSummary)
@@ -150,14 +300,201 @@ func showSubThread(r *review.Review, thread review.CommentThread, indent string)
 	return nil
 }
 
+// printEvents prints the transfers, re-requests, and nudges of the review, oldest first.
+func printEvents(r *review.Review) {
+	for _, e := range r.Events {
+		switch e.Type {
+		case event.TypeTransfer:
+			fmt.Printf("  transferred from %s to %s by %s at %s\n", e.From, e.To, e.Author, reformatTimestamp(e.Timestamp))
+		case event.TypeRerequest:
+			fmt.Printf("  requested again from %s by %s at %s\n", strings.Join(e.Reviewers, ", "), e.Author, reformatTimestamp(e.Timestamp))
+		case event.TypeNudge:
+			fmt.Printf("  overdue for its %s; %s nudged by %s at %s\n", e.SLA, strings.Join(e.Reviewers, ", "), e.Author, reformatTimestamp(e.Timestamp))
+		}
+		if message := strings.TrimSpace(e.Message); message != "" {
+			fmt.Printf("    %s\n", strings.Replace(message, "\n", "\n    ", -1))
+		}
+	}
+}
+
+// printMergeable prints whether the review merges cleanly into its target ref, if that has been checked.
+func printMergeable(r *review.Summary) {
+	if r.Mergeable == nil {
+		return
+	}
+	if *r.Mergeable {
+		fmt.Println("  merge: clean")
+		return
+	}
+	fmt.Printf("  merge: conflicts with the target ref in: %s\n", strings.Join(r.Conflicts, ", "))
+}
+
+// printIssues prints the issues linked to the review, along with their URLs in the configured issue trackers.
+func printIssues(r *review.Review) {
+	if len(r.Request.Issues) == 0 {
+		return
+	}
+	// A malformed tracker configuration only means that the issues are printed without their URLs.
+	trackers, _ := issue.LoadTrackers(r.Repo)
+	var issues []string
+	for _, i := range issue.Expand(trackers, r.Request.Issues) {
+		if i.URL != "" && i.URL != i.ID {
+			issues = append(issues, fmt.Sprintf("%s (%s)", i.ID, i.URL))
+		} else {
+			issues = append(issues, i.ID)
+		}
+	}
+	fmt.Printf("  issues: %s\n", strings.Join(issues, ", "))
+}
+
+// printBuildDetails prints the log excerpts and artifacts from the latest CI report of each agent.
+func printBuildDetails(r *review.Review) {
+	latestReports, err := ci.GetLatestCIReportsByAgent(r.Reports)
+	if err != nil {
+		return
+	}
+	var agents []string
+	for agent := range latestReports {
+		agents = append(agents, agent)
+	}
+	sort.Strings(agents)
+	for _, agent := range agents {
+		report := latestReports[agent]
+		if report.Log == "" && len(report.Artifacts) == 0 && report.Tests == nil {
+			continue
+		}
+		agentName := agent
+		if agentName == "" {
+			agentName = "unknown agent"
+		}
+		fmt.Printf("  build details (%s):\n", agentName)
+		if report.Tests != nil {
+			printTestResults(*report.Tests)
+		}
+		if report.Log != "" {
+			indent := "    |"
+			fmt.Println(indent + strings.Replace(strings.TrimSuffix(report.Log, "\n"), "\n", "\n"+indent, -1))
+		}
+		if len(report.Artifacts) > 0 {
+			fmt.Println("    artifacts:")
+			for _, artifact := range report.Artifacts {
+				contentType := artifact.ContentType
+				if contentType == "" {
+					contentType = "unknown type"
+				}
+				fmt.Printf(artifactTemplate, artifact.Name, contentType, artifact.URL)
+			}
+		}
+	}
+}
+
+// printTestResults prints the summary of a build's test results, followed by each failing test.
+func printTestResults(results ci.TestResults) {
+	fmt.Printf("    tests: %d total, %d passed, %d failed, %d skipped (%.1fs)\n",
+		results.Total, results.Passed, results.Failed, results.Skipped, results.Duration)
+	for _, failure := range results.Failures {
+		fmt.Printf("      FAIL %s (%.2fs)\n", failure.Name, failure.Duration)
+		if failure.Message != "" {
+			indent := "        "
+			fmt.Println(indent + strings.Replace(failure.Message, "\n", "\n"+indent, -1))
+		}
+	}
+	if omitted := results.Failed - len(results.Failures); omitted > 0 {
+		fmt.Printf("      ... and %d more\n", omitted)
+	}
+}
+
+// printPendingReruns prints the requests to re-run the review's CI build that have not yet been responded to.
+func printPendingReruns(r *review.Review) {
+	reruns, err := r.GetPendingReruns()
+	if err != nil {
+		return
+	}
+	for _, rerun := range reruns {
+		agentName := rerun.Agent
+		if agentName == "" {
+			agentName = "all agents"
+		}
+		fmt.Printf("  rerun requested (%s) by %s at %s\n", agentName, rerun.Requester, reformatTimestamp(rerun.Timestamp))
+	}
+}
+
+// printCoverage prints the code coverage reported for the review, and how it
+// differs from the coverage reported for the target ref.
+func printCoverage(r *review.Review) {
+	head, base, err := r.GetCoverage()
+	if err != nil || head == nil {
+		return
+	}
+	if base == nil {
+		fmt.Printf("  coverage: %.1f%%\n", head.Percent)
+		return
+	}
+	fmt.Printf("  coverage: %.1f%% (%+.1f%% vs target)\n", head.Percent, head.Percent-base.Percent)
+	for _, delta := range ci.CompareFiles(base, head) {
+		if delta.New {
+			fmt.Printf("    %s: %.1f%% (new)\n", delta.Path, delta.Head)
+		} else {
+			fmt.Printf("    %s: %.1f%% (%+.1f%%)\n", delta.Path, delta.Head, delta.Delta())
+		}
+	}
+}
+
 // printAnalyses prints the static analysis results for the latest commit in the review.
 func printAnalyses(r *review.Review) {
 	fmt.Println("  analyses: ", r.GetAnalysesMessage())
 }
 
+// PrintRobotComment prints a single robot comment.
+func PrintRobotComment(c robot.Comment, indent string) {
+	name := c.Analyzer
+	if c.Category != "" {
+		name += "/" + c.Category
+	}
+	var location string
+	if c.Location != nil && c.Location.Path != "" {
+		location = " " + c.Location.Path
+		if c.Location.Range != nil && c.Location.Range.StartLine > 0 {
+			location += fmt.Sprintf(":%d", c.Location.Range.StartLine)
+		}
+	}
+	status := "open"
+	if c.Resolved {
+		status = fmt.Sprintf("resolved in %.12s", c.ResolvedIn)
+	}
+	fmt.Printf("%s%.12s [%s] %s%s: %s\n", indent, c.Hash, c.Severity, name, location, status)
+	fmt.Printf("%s  %s\n", indent, strings.Replace(strings.TrimSpace(c.Description), "\n", "\n"+indent+"  ", -1))
+	if c.Fix != "" {
+		fmt.Printf("%s  (a fix is available; see apply-suggestion)\n", indent)
+	}
+}
+
+// printRobotComments prints the open robot comments for the review, and the number that have been resolved.
+func printRobotComments(r *review.Review) {
+	if len(r.RobotComments) == 0 {
+		return
+	}
+	var open []robot.Comment
+	for _, c := range r.RobotComments {
+		if !c.Resolved {
+			open = append(open, c)
+		}
+	}
+	fmt.Printf(robotSummaryTemplate, len(r.RobotComments), len(open))
+	for _, c := range open {
+		PrintRobotComment(c, "    ")
+	}
+}
+
 // printComments prints all of the comments for the review, with snippets of the preceding source code.
 func printComments(r *review.Review) error {
-	fmt.Printf(commentSummaryTemplate, len(r.Comments))
+	openThreads := 0
+	for _, thread := range r.Comments {
+		if thread.IsOpen() {
+			openThreads++
+		}
+	}
+	fmt.Printf(commentSummaryTemplate, len(r.Comments), openThreads)
 	for _, thread := range r.Comments {
 		err := showThread(r, thread)
 		if err != nil {
@@ -173,13 +510,76 @@ func PrintDetails(r *review.Review) error {
 	fmt.Printf(reviewDetailsTemplate, r.Request.ReviewRef, r.Request.TargetRef,
 		strings.Join(r.Request.Reviewers, ", "),
 		r.Request.Requester, r.GetBuildStatusMessage())
+	if r.Request.DependsOn != "" {
+		fmt.Printf("  depends on: %.12s\n", r.Request.DependsOn)
+	}
+	if r.Request.BackportOf != "" {
+		fmt.Printf("  backport of: %.12s\n", r.Request.BackportOf)
+	}
+	if len(r.Request.Backports) > 0 {
+		var backports []string
+		for _, backport := range r.Request.Backports {
+			backports = append(backports, fmt.Sprintf("%.12s", backport))
+		}
+		fmt.Printf("  backports: %s\n", strings.Join(backports, ", "))
+	}
+	printMergeable(r.Summary)
+	printIssues(r)
+	if n := len(r.Request.Patchsets); n > 0 {
+		fmt.Printf("  patchsets: %d (latest %.12s)\n", n, r.Request.Patchsets[n-1].Commit)
+	}
+	printEvents(r)
+	printBuildDetails(r)
+	printPendingReruns(r)
+	printCoverage(r)
 	printAnalyses(r)
+	printRobotComments(r)
 	if err := printComments(r); err != nil {
 		return err
 	}
 	return nil
 }
 
+// PrintPatchsets prints each of the patchsets recorded for a review, oldest first.
+func PrintPatchsets(r *review.Summary) {
+	for i, patchset := range r.Request.Patchsets {
+		fmt.Printf("patchset %d: %.12s (base %.12s) at %s\n", i+1, patchset.Commit, patchset.Base,
+			reformatTimestamp(patchset.Timestamp))
+	}
+}
+
+// PrintTimeline prints each of the events in the timeline of a review, oldest first.
+func PrintTimeline(entries []audit.Entry) {
+	for _, entry := range entries {
+		line := fmt.Sprintf("%s %s", reformatTimestamp(entry.Timestamp), entry.Type)
+		if entry.Actor != "" {
+			line += " by " + entry.Actor
+		}
+		if len(entry.Users) > 0 {
+			line += " for " + strings.Join(entry.Users, ", ")
+		}
+		if entry.Commit != "" {
+			line += fmt.Sprintf(" at %.12s", entry.Commit)
+		}
+		if entry.Path != "" {
+			line += " on " + entry.Path
+		}
+		if entry.Hash != "" {
+			line += fmt.Sprintf(" (%.12s)", entry.Hash)
+		}
+		fmt.Println(line)
+		if description := strings.TrimSpace(entry.Description); description != "" {
+			fmt.Printf("  %s\n", strings.SplitN(description, "\n", 2)[0])
+		}
+	}
+}
+
+// PrintComments prints a single-line summary of a review, followed by all of its comment threads.
+func PrintComments(r *review.Review) error {
+	PrintSummary(r.Summary)
+	return printComments(r)
+}
+
 // PrintJSON pretty prints the given review in JSON format.
 func PrintJSON(r *review.Review) error {
 	json, err := r.GetJSON()
@@ -0,0 +1,158 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"fmt"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/schema"
+	"github.com/promet/git-appraise/review/sidebyside"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// columnSeparator separates the two columns of a side-by-side diff.
+const columnSeparator = " │ "
+
+// lineNumberWidth is the width of the line number and the type marker that start each column of a side-by-side diff.
+const lineNumberWidth = 5
+
+// fitColumn expands the tabs in the given text, and truncates it to fit within the given width.
+func fitColumn(text string, width int) string {
+	text = strings.Replace(text, "\t", "    ", -1)
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= width {
+		return text
+	}
+	return string([]rune(text)[:width-1]) + "…"
+}
+
+// colorizeColumn colors the text of one column of a side-by-side diff in the given style.
+//
+// If the words of the text that changed are given, then they are highlighted
+// instead of the syntax of the code.
+func colorizeColumn(text string, changed bool, words []string, changedWords []bool, language, marker string, style DiffStyle) string {
+	if !style.Color {
+		return marker + text
+	}
+	color := ""
+	if changed {
+		color = colorGreen
+		if marker == "-" {
+			color = colorRed
+		}
+	}
+	if changedWords != nil {
+		return highlightWords(marker+text, words, changedWords, color)
+	}
+	if style.Syntax && language != "" {
+		return highlightDiffLine(marker+text, language, color)
+	}
+	if color == "" {
+		return marker + text
+	}
+	return color + marker + text + colorReset
+}
+
+// formatSideBySideRow formats a row of a side-by-side diff whose columns each have the given width.
+func formatSideBySideRow(row sidebyside.Row, width int, style DiffStyle) string {
+	if row.Header != "" {
+		if style.Color {
+			return colorizeDiffLine(row.Header)
+		}
+		return row.Header
+	}
+	path := row.NewPath
+	if path == "" {
+		path = row.OldPath
+	}
+	language := fileLanguage(path)
+	textWidth := width - lineNumberWidth
+	oldText, newText := fitColumn(row.Old.Text, textWidth), fitColumn(row.New.Text, textWidth)
+	var oldWords, newWords []string
+	var oldChanged, newChanged []bool
+	if style.Color && style.Words && row.Old.Changed && row.New.Changed {
+		oldWords, newWords = wordPattern.FindAllString(oldText, -1), wordPattern.FindAllString(newText, -1)
+		if len(oldWords) <= maxWordDiffTokens && len(newWords) <= maxWordDiffTokens {
+			oldChanged, newChanged = diffWords(oldWords, newWords)
+		}
+	}
+	column := func(line sidebyside.Line, text, marker string, words []string, changedWords []bool) string {
+		if line.Number == 0 {
+			return strings.Repeat(" ", width)
+		}
+		if !line.Changed {
+			marker = " "
+		}
+		padding := strings.Repeat(" ", textWidth-utf8.RuneCountInString(text))
+		return fmt.Sprintf("%4d", line.Number) + colorizeColumn(text, line.Changed, words, changedWords, language, marker, style) + padding
+	}
+	left := column(row.Old, oldText, "-", oldWords, oldChanged)
+	right := column(row.New, newText, "+", newWords, newChanged)
+	return strings.TrimRight(left+columnSeparator+right, " ")
+}
+
+// PrintSideBySideDiff prints the given diff of the review in two columns that
+// together fit within the given width, with the old version of each file on
+// the left and the new version on the right.
+//
+// Each comment thread is printed after the line that it comments on, in the
+// column of that line; see sidebyside.ThreadAnchor for how the threads are
+// anchored, given the commit on the old side of the diff. Threads that do not
+// match any line in the diff are printed after it.
+func PrintSideBySideDiff(r *review.Review, diff, base string, style DiffStyle, width int) error {
+	columnWidth := (width - utf8.RuneCountInString(columnSeparator)) / 2
+	if columnWidth < lineNumberWidth+10 {
+		columnWidth = lineNumberWidth + 10
+	}
+	rightIndent := strings.Repeat(" ", columnWidth+utf8.RuneCountInString(columnSeparator))
+	anchored, unanchored := sidebyside.AnchorThreads(r.Comments, base)
+	printed := make(map[sidebyside.Anchor]bool)
+	for _, row := range sidebyside.Split(diff) {
+		fmt.Println(formatSideBySideRow(row, columnWidth, style))
+		for _, anchor := range row.Anchors() {
+			if printed[anchor] {
+				continue
+			}
+			printed[anchor] = true
+			indent := "    "
+			if anchor.Side == sidebyside.New {
+				indent = rightIndent
+			}
+			if err := printInlineThreads(r, anchored[anchor], style.Color, indent); err != nil {
+				return err
+			}
+		}
+	}
+
+	for anchor, threads := range anchored {
+		if !printed[anchor] {
+			unanchored = append(unanchored, threads...)
+		}
+	}
+	if len(unanchored) > 0 {
+		fmt.Printf("\nComments not attached to any line in the diff:\n")
+		sort.SliceStable(unanchored, func(i, j int) bool {
+			return schema.CompareTimestamps(unanchored[i].Comment.Timestamp, unanchored[j].Comment.Timestamp) < 0
+		})
+		return printInlineThreads(r, unanchored, style.Color, "    ")
+	}
+	return nil
+}
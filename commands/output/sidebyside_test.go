@@ -0,0 +1,40 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"github.com/promet/git-appraise/review/sidebyside"
+	"testing"
+)
+
+func TestFormatSideBySideRow(t *testing.T) {
+	row := sidebyside.Row{
+		OldPath: "notes.txt",
+		NewPath: "notes.txt",
+		Old:     sidebyside.Line{Number: 7, Text: "a\tlong line of text", Changed: true},
+		New:     sidebyside.Line{Number: 8, Text: "short", Changed: true},
+	}
+	expected := "   7-a    long l… │    8+short"
+	if formatted := formatSideBySideRow(row, 17, DiffStyle{}); formatted != expected {
+		t.Errorf("Unexpected row: %q", formatted)
+	}
+	row.New = sidebyside.Line{}
+	expected = "   7-a    long l… │"
+	if formatted := formatSideBySideRow(row, 17, DiffStyle{}); formatted != expected {
+		t.Errorf("Unexpected row without a new line: %q", formatted)
+	}
+}
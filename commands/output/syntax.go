@@ -0,0 +1,139 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// codeSyntax describes how to highlight the code of a language.
+type codeSyntax struct {
+	// comment is the prefix of a comment that runs to the end of the line.
+	comment  string
+	keywords map[string]bool
+}
+
+// words returns the set of the given space-separated words.
+func words(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(list) {
+		set[word] = true
+	}
+	return set
+}
+
+var (
+	goSyntax = codeSyntax{"//", words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false")}
+	cSyntax  = codeSyntax{"//", words("auto break case char class const continue default do double else enum extern final float for goto if int long new private protected public return short static struct switch this throw try catch typedef union unsigned void volatile while null true false")}
+	jsSyntax = codeSyntax{"//", words("async await break case catch class const continue default delete do else export extends finally for from function if import in instanceof interface let new of return switch this throw try type typeof var void while yield null undefined true false")}
+	pySyntax = codeSyntax{"#", words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False")}
+	shSyntax = codeSyntax{"#", words("case do done elif else esac export fi for function if in local return then until while")}
+	rsSyntax = codeSyntax{"//", words("as async await break const continue crate else enum extern fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait type unsafe use where while true false")}
+)
+
+// codeSyntaxes maps the names of the languages of fenced code blocks to their syntax.
+var codeSyntaxes = map[string]codeSyntax{
+	"go":         goSyntax,
+	"golang":     goSyntax,
+	"c":          cSyntax,
+	"c++":        cSyntax,
+	"cpp":        cSyntax,
+	"java":       cSyntax,
+	"js":         jsSyntax,
+	"javascript": jsSyntax,
+	"ts":         jsSyntax,
+	"typescript": jsSyntax,
+	"py":         pySyntax,
+	"python":     pySyntax,
+	"sh":         shSyntax,
+	"bash":       shSyntax,
+	"shell":      shSyntax,
+	"rs":         rsSyntax,
+	"rust":       rsSyntax,
+}
+
+// codeTokenPattern matches the strings, words, and numbers within a line of code.
+var codeTokenPattern = regexp.MustCompile(`"(?:[^"\\]|\\.)*"?|'(?:[^'\\]|\\.)*'?|[A-Za-z_]\w*|\d[\w.]*`)
+
+// highlightCode highlights the keywords, strings, numbers, and comments in a line of code in the given language.
+//
+// Lines of code in an unknown language are only dimmed, as their syntax is not known.
+func highlightCode(language, line string) string {
+	syntax, ok := codeSyntaxes[language]
+	if !ok {
+		return colorDim + line + colorReset
+	}
+	var highlighted strings.Builder
+	last := 0
+	for _, span := range codeTokenPattern.FindAllStringIndex(line, -1) {
+		if span[0] < last {
+			continue
+		}
+		between := line[last:span[0]]
+		if i := strings.Index(between, syntax.comment); i >= 0 {
+			highlighted.WriteString(between[:i])
+			last += i
+			break
+		}
+		highlighted.WriteString(between)
+		token := line[span[0]:span[1]]
+		switch c := token[0]; {
+		case c == '"' || c == '\'':
+			token = colorGreen + token + colorReset
+		case c >= '0' && c <= '9':
+			token = colorMagenta + token + colorReset
+		case syntax.keywords[token]:
+			token = colorBlue + colorBold + token + colorReset
+		}
+		highlighted.WriteString(token)
+		last = span[1]
+	}
+	rest := line[last:]
+	if i := strings.Index(rest, syntax.comment); i >= 0 {
+		highlighted.WriteString(rest[:i])
+		rest = colorDim + rest[i:] + colorReset
+	}
+	highlighted.WriteString(rest)
+	return highlighted.String()
+}
+
+// fileLanguages maps the extensions of source files to the names of their languages.
+var fileLanguages = map[string]string{
+	".go":   "go",
+	".c":    "c",
+	".h":    "c",
+	".cc":   "cpp",
+	".cpp":  "cpp",
+	".hpp":  "cpp",
+	".java": "java",
+	".js":   "js",
+	".jsx":  "js",
+	".mjs":  "js",
+	".ts":   "ts",
+	".tsx":  "ts",
+	".py":   "py",
+	".sh":   "sh",
+	".bash": "sh",
+	".rs":   "rs",
+}
+
+// fileLanguage returns the name of the language of the file at the given path, or an empty string if it is not known.
+func fileLanguage(path string) string {
+	return fileLanguages[strings.ToLower(filepath.Ext(path))]
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"testing"
+)
+
+func TestHighlightCode(t *testing.T) {
+	highlighted := highlightCode("python", `print("# not a comment")  # a comment`)
+	expected := "print(" + colorGreen + `"# not a comment"` + colorReset + ")  " + colorDim + "# a comment" + colorReset
+	if highlighted != expected {
+		t.Errorf("Unexpected highlighting: %q", highlighted)
+	}
+}
+
+func TestFileLanguage(t *testing.T) {
+	if language := fileLanguage("commands/output/Diff.GO"); language != "go" {
+		t.Errorf("Unexpected language of a Go file: %q", language)
+	}
+	if language := fileLanguage("README.md"); language != "" {
+		t.Errorf("Unexpected language of a Markdown file: %q", language)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/request"
+	"strconv"
+	"time"
+)
+
+var publishFlagSet = flag.NewFlagSet("publish", flag.ExitOnError)
+
+var (
+	publishSign = publishFlagSet.Bool("sign", false, "Sign the published request using the GPG key configured as user.signingkey")
+)
+
+// publishReview marks a draft review as ready to be looked at by reviewers.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func publishReview(repo repository.Repo, args []string) error {
+	publishFlagSet.Parse(args)
+	args = publishFlagSet.Args()
+
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return errors.New("Only publishing a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+	if !r.IsDraft() {
+		return errors.New("The review is not a draft.")
+	}
+
+	// The new request must sort after the draft one, so it gets a fresh timestamp.
+	r.Request.Draft = false
+	r.Request.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	r.Request.Signature = ""
+	if *publishSign {
+		if err := signMetadata(repo, &r.Request); err != nil {
+			return err
+		}
+	}
+	note, err := r.Request.Write()
+	if err != nil {
+		return err
+	}
+	if err := repo.AppendNote(request.Ref, r.Revision, note); err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("publish", r.Request)
+	}
+	return nil
+}
+
+// publishCmd defines the "publish" subcommand.
+var publishCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s publish [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		publishFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return publishReview(repo, args)
+	},
+}
@@ -19,6 +19,7 @@ package commands
 import (
 	"errors"
 	"fmt"
+	"github.com/promet/git-appraise/commands/output"
 	"github.com/promet/git-appraise/repository"
 )
 
@@ -36,6 +37,9 @@ func pull(repo repository.Repo, args []string) error {
 	if err := repo.PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern); err != nil {
 		return err
 	}
+	if JSONOutput {
+		return output.PrintJSONResult("pull", remoteResult{Remote: remote})
+	}
 	return nil
 }
 
@@ -46,4 +50,5 @@ var pullCmd = &Command{
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return pull(repo, args)
 	},
+	NoSync: true,
 }
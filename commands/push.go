@@ -19,10 +19,77 @@ package commands
 import (
 	"errors"
 	"fmt"
+	"github.com/promet/git-appraise/commands/output"
 	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/policy"
 )
 
 // push pushes the local git-notes used for reviews to a remote repo.
+// remoteResult is the JSON output of the commands that synchronize with a remote repo.
+type remoteResult struct {
+	Remote string `json:"remote"`
+}
+
+// recordPatchsets records a new patchset for each of the user's open reviews whose head has changed.
+func recordPatchsets(repo repository.Repo) error {
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	for _, summary := range review.ListOpen(repo) {
+		if summary.Request.Requester != userEmail || summary.Request.ReviewRef == "" {
+			continue
+		}
+		r, err := summary.Details()
+		if err != nil {
+			return err
+		}
+		patchset, err := r.RecordPatchset()
+		if err != nil {
+			return err
+		}
+		if patchset == nil {
+			continue
+		}
+		if !JSONOutput {
+			fmt.Printf("Recorded patchset %d of review %.12s\n", len(r.Request.Patchsets), r.Revision)
+		}
+		if err := markStaleApprovals(r, userEmail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markStaleApprovals records that the approvals of earlier revisions of the review are stale, if that is enabled.
+func markStaleApprovals(r *review.Review, userEmail string) error {
+	marked, err := policy.MarkStale(r, userEmail)
+	if err != nil {
+		return err
+	}
+	if len(marked) > 0 && !JSONOutput {
+		fmt.Printf("Marked %d approval(s) of review %.12s as stale; they must be given again, or carried forward\n", len(marked), r.Revision)
+	}
+	return nil
+}
+
+// pushNotes pushes the review notes and archives to the remote.
+//
+// If the push is rejected because someone else has pushed to the remote since
+// it was last pulled from, then the remote notes are merged into the local
+// ones, and the push is retried.
+func pushNotes(repo repository.Repo, remote string) error {
+	err := repo.PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern)
+	if err == nil {
+		return nil
+	}
+	if pullErr := repo.PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern); pullErr != nil {
+		return err
+	}
+	return repo.PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern)
+}
+
 func push(repo repository.Repo, args []string) error {
 	if len(args) > 1 {
 		return errors.New("Only pushing to one remote at a time is supported.")
@@ -33,9 +100,15 @@ func push(repo repository.Repo, args []string) error {
 		remote = args[0]
 	}
 
-	if err := repo.PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern); err != nil {
+	if err := recordPatchsets(repo); err != nil {
 		return err
 	}
+	if err := pushNotes(repo, remote); err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("push", remoteResult{Remote: remote})
+	}
 	return nil
 }
 
@@ -46,4 +119,5 @@ var pushCmd = &Command{
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return push(repo, args)
 	},
+	NoSync: true,
 }
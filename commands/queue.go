@@ -0,0 +1,408 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/schema"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var queueFlagSet = flag.NewFlagSet("queue", flag.ExitOnError)
+
+var (
+	queueInterval  = queueFlagSet.Duration("interval", time.Minute, "How often to check for reviews that are ready to land")
+	queueRemote    = queueFlagSet.String("remote", "", "Remote to pull the reviews from, and push the rebased reviews and landed target refs to; defaults to the one in appraise.autoSync")
+	queueOnce      = queueFlagSet.Bool("once", false, "Process the queue a single time, rather than running until interrupted")
+	queueRequireCI = queueFlagSet.Bool("require-ci", true, "Only land reviews whose head has a successful build from at least one CI agent")
+	queueArchive   = queueFlagSet.Bool("archive", true, "Prevent the original commits of rebased and squashed reviews from being garbage collected")
+)
+
+// queueEvent is the JSON output of the "queue" subcommand for each review that it rebases, lands, or is blocked from landing.
+type queueEvent struct {
+	Review string `json:"review"`
+	Target string `json:"target"`
+	// Action is one of "rebased", "landed", or "blocked".
+	Action string `json:"action"`
+	// Commit is the new head of a rebased review.
+	Commit string `json:"commit,omitempty"`
+	// Reason is why a blocked review cannot land.
+	Reason string `json:"reason,omitempty"`
+}
+
+// reportQueueEvent prints what the queue did with a review.
+func reportQueueEvent(event queueEvent) error {
+	if JSONOutput {
+		return output.PrintJSONResult("queue", event)
+	}
+	switch event.Action {
+	case "rebased":
+		fmt.Printf("Rebased review %.12s onto %s as %.12s\n", event.Review, event.Target, event.Commit)
+	case "landed":
+		fmt.Printf("Landed review %.12s on %s\n", event.Review, event.Target)
+	default:
+		fmt.Printf("Could not land review %.12s on %s: %s\n", event.Review, event.Target, event.Reason)
+	}
+	return nil
+}
+
+// queueConflictMessage is the comment left on a review that the queue could not rebase, given its target ref.
+const queueConflictMessage = "The submit queue could not rebase this review onto %s without conflicts; it must be rebased by hand before it can land."
+
+// queuedAt returns when the given review was first requested, which is its place in the queue.
+func queuedAt(r review.Summary) string {
+	timestamp := r.Request.Timestamp
+	for _, req := range r.AllRequests {
+		if schema.CompareTimestamps(req.Timestamp, timestamp) < 0 {
+			timestamp = req.Timestamp
+		}
+	}
+	return timestamp
+}
+
+// sortQueue sorts the given reviews into the order in which they should land, with the earliest requested first.
+func sortQueue(reviews []review.Summary) {
+	sort.SliceStable(reviews, func(i, j int) bool {
+		return schema.CompareTimestamps(queuedAt(reviews[i]), queuedAt(reviews[j])) < 0
+	})
+}
+
+// isCIGreen reports whether the latest build from every CI agent that has reported on the review succeeded.
+//
+// If requireCI is set, then at least one agent must have reported.
+func isCIGreen(reports []ci.Report, requireCI bool) (bool, error) {
+	latestReports, err := ci.GetLatestCIReportsByAgent(reports)
+	if err != nil {
+		return false, err
+	}
+	for _, report := range latestReports {
+		if report.Status != ci.StatusSuccess {
+			return false, nil
+		}
+	}
+	return len(latestReports) > 0 || !requireCI, nil
+}
+
+// hasQueueConflict reports whether the queue has already failed to rebase the given head of the review.
+func hasQueueConflict(r *review.Review, head string) bool {
+	message := fmt.Sprintf(queueConflictMessage, r.Request.TargetRef)
+	var visit func(threads []review.CommentThread) bool
+	visit = func(threads []review.CommentThread) bool {
+		for _, thread := range threads {
+			location := thread.Comment.Location
+			if location != nil && location.Commit == head && thread.Comment.Description == message {
+				return true
+			}
+			if visit(thread.Children) {
+				return true
+			}
+		}
+		return false
+	}
+	return visit(r.Comments)
+}
+
+// processQueue moves each of the queued reviews that is next in line for its target ref one step closer to landing.
+//
+// A review is queued once nothing but being out of date with its target ref
+// keeps it from being submitted. Only the earliest queued review for each
+// target is processed, so that the reviews land one at a time, in order, and
+// each one is validated against everything that landed before it. A review
+// that the queue failed to rebase stays out of the queue until its head changes.
+func processQueue(repo repository.Repo, remote, user string) error {
+	reviews := review.ListOpen(repo)
+	sortQueue(reviews)
+	served := make(map[string]bool)
+	for _, summary := range reviews {
+		target := summary.Request.TargetRef
+		if summary.IsDraft() || served[target] {
+			continue
+		}
+		r, err := summary.Details()
+		if err != nil {
+			return err
+		}
+		head, err := r.GetHeadCommit()
+		if err != nil {
+			return err
+		}
+		if hasQueueConflict(r, head) {
+			continue
+		}
+		blockers, err := reviewBlockers(repo, r, false)
+		if err != nil {
+			return err
+		}
+		if len(blockers) > 0 {
+			continue
+		}
+		served[target] = true
+		if err := advanceQueuedReview(repo, r, remote, user); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to land review %.12s: %v\n", r.Revision, err)
+		}
+	}
+	return nil
+}
+
+// advanceQueuedReview rebases the given review onto its target ref if it is out of date, and lands it once its CI build is green.
+func advanceQueuedReview(repo repository.Repo, r *review.Review, remote, user string) error {
+	target := r.Request.TargetRef
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	upToDate, err := repo.IsAncestor(target, head)
+	if err != nil {
+		return err
+	}
+	if !upToDate {
+		rebased, err := rebaseQueuedReview(repo, r, remote, user)
+		if err != nil || !rebased {
+			return err
+		}
+		// Reload the review, so that the CI reports are the ones for its new head.
+		if r, err = review.Get(repo, r.Revision); err != nil {
+			return err
+		}
+		// The approvals may have gone stale with the rebase, unless they were carried forward.
+		blockers, err := reviewBlockers(repo, r, false)
+		if err != nil {
+			return err
+		}
+		if len(blockers) > 0 {
+			return reportQueueEvent(queueEvent{Review: r.Revision, Target: target, Action: "blocked", Reason: strings.Join(blockers, "; ")})
+		}
+	}
+	green, err := isCIGreen(r.Reports, *queueRequireCI)
+	if err != nil || !green {
+		return err
+	}
+	return landQueuedReview(repo, r, remote)
+}
+
+// rebaseQueuedReview rebases the given review onto its target ref, and reports whether that succeeded.
+//
+// If the rebase has conflicts, then it is abandoned, and a comment is left
+// on the review asking for it to be rebased by hand. If approvals go stale,
+// then the review must be approved again before it can land, since only the
+// authors of the approvals may carry them forward.
+func rebaseQueuedReview(repo repository.Repo, r *review.Review, remote, user string) (bool, error) {
+	target := r.Request.TargetRef
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return false, err
+	}
+	if err := r.Rebase(*queueArchive); err != nil {
+		repo.AbortRebase()
+		c := comment.New(user, fmt.Sprintf(queueConflictMessage, target))
+		c.Location = &comment.Location{Commit: head}
+		if err := r.AddComment(c); err != nil {
+			return false, err
+		}
+		if err := reportQueueEvent(queueEvent{Review: r.Revision, Target: target, Action: "blocked", Reason: "the review could not be rebased without conflicts, and must be rebased by hand"}); err != nil {
+			return false, err
+		}
+		return false, pushQueueNotes(repo, remote)
+	}
+	newHead, err := r.GetHeadCommit()
+	if err != nil {
+		return false, err
+	}
+	if err := reportQueueEvent(queueEvent{Review: r.Revision, Target: target, Action: "rebased", Commit: newHead}); err != nil {
+		return false, err
+	}
+	if remote != "" {
+		// The lease keeps the push from discarding any commits that the author pushed since the review was fetched.
+		if err := repo.PushRefWithLease(remote, r.Request.ReviewRef, head); err != nil {
+			return false, err
+		}
+	}
+	return true, pushQueueNotes(repo, remote)
+}
+
+// landQueuedReview submits the given review, which must be a fast-forward of its target ref, using the configured submit strategy.
+func landQueuedReview(repo repository.Repo, r *review.Review, remote string) error {
+	target := r.Request.TargetRef
+	source, err := r.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	strategy, err := repo.GetSubmitStrategy()
+	if err != nil {
+		return err
+	}
+	if strategy == "squash" {
+		err = r.Squash(buildSquashMessage(r), *queueArchive)
+	} else if err = repo.SwitchToRef(target); err == nil {
+		if strategy == "merge" {
+			submitMessage := fmt.Sprintf("Submitting review %.12s", r.Revision)
+			err = repo.MergeRef(source, false, submitMessage, r.Request.Description)
+		} else {
+			err = repo.MergeRef(source, true)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := reportQueueEvent(queueEvent{Review: r.Revision, Target: target, Action: "landed"}); err != nil {
+		return err
+	}
+	if remote != "" {
+		if err := repo.PushRefs(remote, false, target); err != nil {
+			return err
+		}
+	}
+	return pushQueueNotes(repo, remote)
+}
+
+// fetchQueueRefs fetches the target and review refs of the open reviews from the given remote.
+//
+// This is done before each pass over the queue, so that the reviews are
+// validated, rebased, and landed against what is on the remote, rather than
+// against stale local refs. Since the pushes are made from the local refs, the
+// local refs are replaced by the remote ones, which also recovers from a push
+// of a target ref that was rejected because the remote had moved on. Nothing
+// may be checked out while they are replaced, so HEAD is detached until they
+// have been, and then the original HEAD is checked out again.
+func fetchQueueRefs(repo repository.Repo, remote string) error {
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		return err
+	}
+	original, err := repo.GetHeadRef()
+	if err != nil {
+		original = head
+	}
+	if err := repo.SwitchToRef(head); err != nil {
+		return err
+	}
+	fetched := make(map[string]bool)
+	for _, summary := range review.ListOpen(repo) {
+		for _, ref := range []string{summary.Request.TargetRef, summary.Request.ReviewRef} {
+			if ref == "" || fetched[ref] {
+				continue
+			}
+			fetched[ref] = true
+			// A review ref that was never pushed only exists locally, so it is left as it is.
+			if err := repo.FetchRefs(remote, ref); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to fetch %s from %q: %v\n", ref, remote, err)
+			}
+		}
+	}
+	return repo.SwitchToRef(original)
+}
+
+// pushQueueNotes pushes the review notes to the given remote, if there is one.
+func pushQueueNotes(repo repository.Repo, remote string) error {
+	if remote == "" {
+		return nil
+	}
+	return pushNotes(repo, remote)
+}
+
+// runQueue keeps running, and lands the reviews that are approved and have passed CI, one at a time and in the order they were requested.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func runQueue(repo repository.Repo, args []string) error {
+	queueFlagSet.Parse(args)
+	if len(queueFlagSet.Args()) > 0 {
+		return errors.New("The queue command does not take any arguments.")
+	}
+	if *queueInterval <= 0 {
+		return errors.New("The queue interval must be positive.")
+	}
+	if uncommitted, err := repo.HasUncommittedChanges(); err != nil {
+		return err
+	} else if uncommitted {
+		return errors.New("The queue must be run in a clone without uncommitted changes.")
+	}
+	user, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	remote := *queueRemote
+	if remote == "" {
+		if remote, err = repo.GetAutoSyncRemote(); err != nil {
+			return err
+		}
+	}
+	original, err := repo.GetHeadRef()
+	if err != nil {
+		if original, err = repo.GetCommitHash("HEAD"); err != nil {
+			return err
+		}
+	}
+	// The queue runs unattended, so the rebases and merges must not open an editor.
+	os.Setenv("GIT_SEQUENCE_EDITOR", "true")
+	os.Setenv("GIT_EDITOR", "true")
+
+	if !*queueOnce && !JSONOutput {
+		fmt.Printf("Landing the approved reviews as they pass CI; press Ctrl-C to stop.\n")
+	}
+	for {
+		if remote != "" {
+			if err := repo.PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to pull the reviews from %q: %v\n", remote, err)
+			}
+		}
+		var err error
+		if remote != "" {
+			err = fetchQueueRefs(repo, remote)
+		}
+		if err == nil {
+			err = processQueue(repo, remote, user)
+		}
+		if switchErr := repo.SwitchToRef(original); err == nil {
+			err = switchErr
+		}
+		if repo.Context().Err() != nil {
+			return nil
+		}
+		if err != nil || *queueOnce {
+			return err
+		}
+		select {
+		case <-time.After(*queueInterval):
+		case <-repo.Context().Done():
+			// Interrupting the queue is the usual way to stop it.
+			return nil
+		}
+	}
+}
+
+// queueCmd defines the "queue" subcommand.
+var queueCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s queue [<option>...]\n\nOptions:\n", arg0)
+		queueFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return runQueue(repo, args)
+	},
+	NoSync:        true,
+	NeedsWorktree: true,
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/request"
+	"testing"
+)
+
+func TestSortQueue(t *testing.T) {
+	// The second review was requested first, but updated (e.g. rebased) since then.
+	reviews := []review.Summary{
+		{Revision: "first", Request: request.Request{Timestamp: "0000000002"}},
+		{Revision: "second", Request: request.Request{Timestamp: "0000000005"}, AllRequests: []request.Request{
+			{Timestamp: "0000000001"},
+			{Timestamp: "0000000005"},
+		}},
+	}
+	sortQueue(reviews)
+	if reviews[0].Revision != "second" || reviews[1].Revision != "first" {
+		t.Fatalf("The reviews were not sorted by when they were first requested: %v", reviews)
+	}
+}
+
+func TestIsCIGreen(t *testing.T) {
+	success := ci.Report{Timestamp: "0000000001", Status: ci.StatusSuccess, Agent: "build"}
+	pending := ci.Report{Timestamp: "0000000002", Status: ci.StatusPending, Agent: "lint"}
+	if green, err := isCIGreen(nil, true); err != nil || green {
+		t.Fatalf("A review without any CI reports was green (%v)", err)
+	}
+	if green, err := isCIGreen(nil, false); err != nil || !green {
+		t.Fatalf("A review without any CI reports was not green when CI is not required (%v)", err)
+	}
+	if green, err := isCIGreen([]ci.Report{success}, true); err != nil || !green {
+		t.Fatalf("A review with a successful build was not green (%v)", err)
+	}
+	if green, err := isCIGreen([]ci.Report{success, pending}, false); err != nil || green {
+		t.Fatalf("A review with a pending build was green (%v)", err)
+	}
+}
+
+// pushRecordingRepo is a mock repo that records the refs pushed to remotes.
+type pushRecordingRepo struct {
+	repository.Repo
+	forced []string
+	leases map[string]string
+}
+
+func (repo *pushRecordingRepo) PushRefs(remote string, force bool, refs ...string) error {
+	if force {
+		repo.forced = append(repo.forced, refs...)
+	}
+	return nil
+}
+
+func (repo *pushRecordingRepo) PushRefWithLease(remote, ref, expected string) error {
+	repo.leases[ref] = expected
+	return nil
+}
+
+func TestRebaseQueuedReviewPushesWithLease(t *testing.T) {
+	repo := &pushRecordingRepo{Repo: repository.NewMockRepoForTest(), leases: make(map[string]string)}
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebased, err := rebaseQueuedReview(repo, r, "origin", "queue@example.com"); err != nil || !rebased {
+		t.Fatalf("Failed to rebase the review: %v, %v", rebased, err)
+	}
+	if len(repo.forced) > 0 {
+		t.Errorf("Force pushed the refs %q", repo.forced)
+	}
+	if expected, ok := repo.leases[r.Request.ReviewRef]; !ok || expected != head {
+		t.Errorf("Did not push the review ref with a lease on its old head %q: %q", head, repo.leases)
+	}
+}
+
+func TestFetchQueueRefsRestoresHead(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	original, err := repo.GetHeadRef()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fetchQueueRefs(repo, "origin"); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := repo.GetHeadRef(); err != nil || head != original {
+		t.Errorf("The HEAD %q was not restored: %q, %v", original, head, err)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/reaction"
+	"sort"
+	"strings"
+)
+
+var reactFlagSet = flag.NewFlagSet("react", flag.ExitOnError)
+
+var (
+	reactRemove = reactFlagSet.Bool("remove", false, "Withdraw a previous reaction instead of adding one")
+)
+
+// reactToComment adds (or removes) a reaction to a review comment.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func reactToComment(repo repository.Repo, args []string) error {
+	reactFlagSet.Parse(args)
+	args = reactFlagSet.Args()
+
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("Reacting to a comment requires a comment hash and a reaction, and optionally a review hash.")
+	}
+	commentHash := args[0]
+
+	var r *review.Review
+	var err error
+	if len(args) == 3 {
+		r, err = getReview(repo, args[2])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+	if r.FindComment(commentHash) == nil {
+		return errors.New("There is no matching comment.")
+	}
+
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	re := reaction.New(userEmail, commentHash, args[1])
+	re.Removed = *reactRemove
+	note, err := re.Write()
+	if err != nil {
+		return err
+	}
+	if err := repo.AppendNote(reaction.Ref, r.Revision, note); err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("react", re)
+	}
+	return nil
+}
+
+// reactCmd defines the "react" subcommand.
+var reactCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s react [<option>...] <comment-hash> <reaction> [<review-hash>]\n\nOptions:\n", arg0)
+		reactFlagSet.PrintDefaults()
+		var aliases []string
+		for alias := range reaction.Aliases {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+		fmt.Printf("\nThe reaction may be any emoji, or one of: %s\n", strings.Join(aliases, ", "))
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return reactToComment(repo, args)
+	},
+}
@@ -21,6 +21,7 @@ import (
 	"flag"
 	"fmt"
 
+	"github.com/promet/git-appraise/commands/output"
 	"github.com/promet/git-appraise/repository"
 	"github.com/promet/git-appraise/review"
 )
@@ -42,15 +43,15 @@ func validateRebaseRequest(repo repository.Repo, args []string) (*review.Review,
 		return nil, errors.New("Only rebasing a single review is supported.")
 	}
 	if len(args) == 1 {
-		r, err = review.Get(repo, args[0])
+		r, err = getReview(repo, args[0])
 	} else {
 		r, err = review.GetCurrent(repo)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("Failed to load the review: %v\n", err)
+		return nil, fmt.Errorf("Failed to load the review: %w\n", err)
 	}
 	if r == nil {
-		return nil, errors.New("There is no matching review.")
+		return nil, review.ErrReviewNotFound
 	}
 
 	if r.Submitted {
@@ -69,6 +70,12 @@ func validateRebaseRequest(repo repository.Repo, args []string) (*review.Review,
 	return r, nil
 }
 
+// headResult is the JSON output of the commands that update the head commit of a review.
+type headResult struct {
+	Review string `json:"review"`
+	Head   string `json:"head"`
+}
+
 // Rebase the current code review.
 //
 // The "args" parameter contains all of the command line arguments that followed the subcommand.
@@ -80,7 +87,24 @@ func rebaseReview(repo repository.Repo, args []string) error {
 	if err != nil {
 		return err
 	}
-	return r.Rebase(*rebaseArchive)
+	if err := r.Rebase(*rebaseArchive); err != nil {
+		return err
+	}
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	if err := markStaleApprovals(r, userEmail); err != nil {
+		return err
+	}
+	if JSONOutput {
+		head, err := r.GetHeadCommit()
+		if err != nil {
+			return err
+		}
+		return output.PrintJSONResult("rebase", headResult{Review: r.Revision, Head: head})
+	}
+	return nil
 }
 
 // rebaseCmd defines the "rebase" subcommand.
@@ -92,4 +116,5 @@ var rebaseCmd = &Command{
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return rebaseReview(repo, args)
 	},
+	NeedsWorktree: true,
 }
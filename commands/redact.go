@@ -0,0 +1,104 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+)
+
+var redactFlagSet = flag.NewFlagSet("redact", flag.ExitOnError)
+
+var (
+	redactReason = redactFlagSet.String("m", "", "Reason for redacting the comment, which is recorded in its place")
+	redactPush   = redactFlagSet.String("push", "", "Force-push the rewritten comments to the given remote")
+)
+
+// redactResult is the JSON output of the "redact" subcommand.
+type redactResult struct {
+	Review  string `json:"review"`
+	Comment string `json:"comment"`
+	Pushed  string `json:"pushed,omitempty"`
+}
+
+// redactComment removes a comment, such as one that leaked a credential, from the history of the review notes.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func redactComment(repo repository.Repo, args []string) error {
+	redactFlagSet.Parse(args)
+	args = redactFlagSet.Args()
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("The redact command takes a comment hash, and optionally a review.")
+	}
+	hash := args[0]
+
+	var r *review.Review
+	var err error
+	if len(args) == 2 {
+		r, err = getReview(repo, args[1])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	if err := r.Redact(hash, userEmail, *redactReason); err != nil {
+		return err
+	}
+	if *redactPush != "" {
+		if err := repo.PushRefs(*redactPush, true, comment.Ref); err != nil {
+			return fmt.Errorf("Failed to push the redacted comments to %q: %v", *redactPush, err)
+		}
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("redact", redactResult{Review: r.Revision, Comment: hash, Pushed: *redactPush})
+	}
+	fmt.Printf("Redacted the comment %.12s.\n", hash)
+	remote := *redactPush
+	if remote == "" {
+		remote = "<remote>"
+		fmt.Printf("Force-push the rewritten comments to every remote that has them:\n  git push --force %s %s\n", remote, notesRef(comment.Ref))
+	}
+	fmt.Printf("Every other clone has to discard its copy of the comments, and fetch the rewritten ones:\n  git fetch %s +%s:%s\n", remote, notesRef(comment.Ref), notesRef(comment.Ref))
+	fmt.Printf("The earlier versions are kept locally until they are pruned:\n  git reflog expire --expire=now --all && git gc --prune=now\n")
+	return nil
+}
+
+// redactCmd defines the "redact" subcommand.
+var redactCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s redact [<option>...] <comment-hash> [<review-hash>]\n\nOptions:\n", arg0)
+		redactFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return redactComment(repo, args)
+	},
+	// The rewritten comments cannot be merged with the remote's, so they are only ever force-pushed.
+	NoSync: true,
+}
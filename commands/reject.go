@@ -32,6 +32,7 @@ var rejectFlagSet = flag.NewFlagSet("reject", flag.ExitOnError)
 var (
 	rejectMessageFile = rejectFlagSet.String("F", "", "Take the comment from the given file. Use - to read the message from the standard input")
 	rejectMessage     = rejectFlagSet.String("m", "", "Message to attach to the review")
+	rejectSign        = rejectFlagSet.Bool("sign", false, "Sign the rejection using the GPG key configured as user.signingkey")
 )
 
 // rejectReview adds an NMW comment to the current code review.
@@ -46,16 +47,16 @@ func rejectReview(repo repository.Repo, args []string) error {
 	}
 
 	if len(args) == 1 {
-		r, err = review.Get(repo, args[0])
+		r, err = getReview(repo, args[0])
 	} else {
 		r, err = review.GetCurrent(repo)
 	}
 
 	if err != nil {
-		return fmt.Errorf("Failed to load the review: %v\n", err)
+		return fmt.Errorf("Failed to load the review: %w\n", err)
 	}
 	if r == nil {
-		return errors.New("There is no matching review.")
+		return review.ErrReviewNotFound
 	}
 
 	if r.Request.TargetRef == "" {
@@ -83,14 +84,19 @@ func rejectReview(repo repository.Repo, args []string) error {
 		Commit: rejectedCommit,
 	}
 	resolved := false
-	userEmail, err := repo.GetUserEmail()
+	userEmail, err := commentAuthor(repo, r, *rejectSign)
 	if err != nil {
 		return err
 	}
 	c := comment.New(userEmail, *rejectMessage)
 	c.Location = &location
 	c.Resolved = &resolved
-	return r.AddComment(c)
+	if *rejectSign {
+		if err := signMetadata(repo, &c); err != nil {
+			return err
+		}
+	}
+	return addComment("reject", r, c)
 }
 
 // rejectCmd defines the "reject" subcommand.
@@ -0,0 +1,277 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/archive"
+	"github.com/promet/git-appraise/review/viewed"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var remapFlagSet = flag.NewFlagSet("remap", flag.ExitOnError)
+
+var (
+	remapMap    = remapFlagSet.String("map", "", "Read the rewritten commits from the given file (or \"-\" for stdin), with one \"<old> <new>\" pair per line, as written by git's post-rewrite hook or by git filter-repo. By default, the rewritten commits of open reviews are found by their patch IDs.")
+	remapDryRun = remapFlagSet.Bool("dry-run", false, "List the reviews that would be remapped, without moving any notes.")
+)
+
+// remapResult is the result of the "remap" subcommand.
+type remapResult struct {
+	// Reviews maps the old revision of each remapped review to its new one.
+	Reviews map[string]string `json:"reviews"`
+	// Remapped lists the commits whose notes were moved.
+	Remapped []string `json:"remapped,omitempty"`
+}
+
+// parseRewriteMap parses a list of rewritten commits, with one "<old> <new>" pair per line.
+//
+// Any header line, and commits that were dropped by the rewrite (mapped to the
+// all-zeros hash), are skipped. Chains of rewrites, such as from amending a
+// commit more than once, are followed to the final commit.
+func parseRewriteMap(reader io.Reader) (map[string]string, error) {
+	mapping := make(map[string]string)
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || (lineNumber == 1 && len(fields) >= 2 && fields[0] == "old" && fields[1] == "new") {
+			continue
+		}
+		if len(fields) < 2 || !repository.IsCommitHash(fields[0]) || !repository.IsCommitHash(fields[1]) {
+			return nil, fmt.Errorf("Malformed line %d of the rewrite map: %q.", lineNumber, scanner.Text())
+		}
+		if strings.Trim(fields[1], "0") == "" {
+			continue
+		}
+		mapping[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for old, rewritten := range mapping {
+		for seen := 0; seen < len(mapping); seen++ {
+			next, ok := mapping[rewritten]
+			if !ok || next == rewritten {
+				break
+			}
+			rewritten = next
+		}
+		mapping[old] = rewritten
+	}
+	return mapping, nil
+}
+
+// readRewriteMap reads the rewritten commits from the given file, or from stdin if it is "-".
+func readRewriteMap(file string) (map[string]string, error) {
+	if file == "-" {
+		return parseRewriteMap(os.Stdin)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseRewriteMap(f)
+}
+
+// matchPatchIDs maps each old commit to the new commit with the same patch ID.
+//
+// Patch IDs that are shared by more than one old or new commit are ambiguous, and so are skipped.
+func matchPatchIDs(oldIDs, newIDs map[string]string) map[string]string {
+	byID := func(ids map[string]string) map[string]string {
+		commits := make(map[string]string)
+		for commit, id := range ids {
+			if _, ok := commits[id]; ok {
+				commits[id] = ""
+				continue
+			}
+			commits[id] = commit
+		}
+		return commits
+	}
+	oldCommits := byID(oldIDs)
+	newCommits := byID(newIDs)
+	mapping := make(map[string]string)
+	for id, oldCommit := range oldCommits {
+		if newCommit := newCommits[id]; oldCommit != "" && newCommit != "" && newCommit != oldCommit {
+			mapping[oldCommit] = newCommit
+		}
+	}
+	return mapping
+}
+
+// findRewrittenCommits finds the commits of open reviews that were rewritten, by matching
+// the patch IDs of the commits last published for review with those now on the review ref.
+func findRewrittenCommits(repo repository.Repo) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, r := range review.ListOpen(repo) {
+		if r.Request.ReviewRef == "" || repository.IsCommitHash(r.Request.ReviewRef) {
+			continue
+		}
+		newHead, err := repo.ResolveRefCommit(r.Request.ReviewRef)
+		if err != nil {
+			// The review ref has been deleted, so there is nothing to remap the review onto.
+			continue
+		}
+		if rewritten, err := repo.IsAncestor(r.Revision, newHead); err != nil || rewritten {
+			continue
+		}
+		oldHead, oldBase := r.Revision, r.Request.BaseCommit
+		if n := len(r.Request.Patchsets); n > 0 {
+			oldHead = r.Request.Patchsets[n-1].Commit
+			if r.Request.Patchsets[n-1].Base != "" {
+				oldBase = r.Request.Patchsets[n-1].Base
+			}
+		}
+		target, err := repo.ResolveRefCommit(r.Request.TargetRef)
+		if err != nil {
+			return nil, err
+		}
+		if oldBase == "" {
+			if oldBase, err = repo.MergeBase(target, oldHead); err != nil {
+				return nil, err
+			}
+		}
+		newBase, err := repo.MergeBase(target, newHead)
+		if err != nil {
+			return nil, err
+		}
+		oldCommits, err := repo.ListCommitsBetween(oldBase, oldHead)
+		if err != nil {
+			return nil, err
+		}
+		newCommits, err := repo.ListCommitsBetween(newBase, newHead)
+		if err != nil {
+			return nil, err
+		}
+		oldIDs, err := repo.GetPatchIDs(oldCommits)
+		if err != nil {
+			return nil, err
+		}
+		newIDs, err := repo.GetPatchIDs(newCommits)
+		if err != nil {
+			return nil, err
+		}
+		for oldCommit, newCommit := range matchPatchIDs(oldIDs, newIDs) {
+			mapping[oldCommit] = newCommit
+		}
+	}
+	return mapping, nil
+}
+
+// remapNotesRefs lists the notes refs whose notes are moved to the rewritten commits.
+func remapNotesRefs() []string {
+	var refs []string
+	for _, ref := range archive.NotesRefs {
+		refs = append(refs, ref, archive.Ref(ref))
+	}
+	return append(refs, viewed.Ref)
+}
+
+// remapReviews moves the review notes of rewritten commits onto the commits that replaced them.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func remapReviews(repo repository.Repo, args []string) error {
+	remapFlagSet.Parse(args)
+	if remapFlagSet.NArg() > 0 {
+		return errors.New("The remap command does not take any arguments.")
+	}
+	var mapping map[string]string
+	var err error
+	if *remapMap != "" {
+		mapping, err = readRewriteMap(*remapMap)
+	} else {
+		mapping, err = findRewrittenCommits(repo)
+	}
+	if err != nil {
+		return err
+	}
+
+	result := remapResult{Reviews: make(map[string]string)}
+	for _, r := range review.ListAll(repo) {
+		if rewritten, ok := mapping[r.Revision]; ok {
+			result.Reviews[r.Revision] = rewritten
+		}
+	}
+	if !*remapDryRun && len(mapping) > 0 {
+		// Load the short IDs before moving the notes, so that the rewritten reviews keep theirs.
+		shortIDs, err := loadShortIDs(repo)
+		if err != nil {
+			return err
+		}
+		remapped := make(map[string]bool)
+		for _, ref := range remapNotesRefs() {
+			objects, err := repo.RemapNotes(ref, mapping)
+			if err != nil {
+				return err
+			}
+			for _, object := range objects {
+				remapped[object] = true
+			}
+		}
+		for object := range remapped {
+			result.Remapped = append(result.Remapped, object)
+		}
+		sort.Strings(result.Remapped)
+		path, err := gitDirPath(repo, shortIDPath())
+		if err != nil {
+			return err
+		}
+		if err := shortIDs.Remap(result.Reviews, path); err != nil {
+			return err
+		}
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("remap", result)
+	}
+	var revisions []string
+	for revision := range result.Reviews {
+		revisions = append(revisions, revision)
+	}
+	sort.Strings(revisions)
+	for _, revision := range revisions {
+		if *remapDryRun {
+			fmt.Printf("Would remap the review %.12s to %.12s\n", revision, result.Reviews[revision])
+		} else {
+			fmt.Printf("Remapped the review %.12s to %.12s\n", revision, result.Reviews[revision])
+		}
+	}
+	if !*remapDryRun {
+		fmt.Printf("Remapped the notes of %d commits.\n", len(result.Remapped))
+	}
+	return nil
+}
+
+// remapCmd defines the "remap" subcommand.
+var remapCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s remap [<option>...]\n\nOptions:\n", arg0)
+		remapFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return remapReviews(repo, args)
+	},
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRewriteMap(t *testing.T) {
+	a := strings.Repeat("a", 40)
+	b := strings.Repeat("b", 40)
+	c := strings.Repeat("c", 40)
+	d := strings.Repeat("d", 40)
+	zero := strings.Repeat("0", 40)
+	input := "old new\n" + a + " " + b + "\n\n" + b + " " + c + " extra\n" + d + " " + zero + "\n"
+	mapping, err := parseRewriteMap(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{a: c, b: c}
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("Unexpected rewrite map: %v", mapping)
+	}
+	if _, err := parseRewriteMap(strings.NewReader(a + " main\n")); err == nil {
+		t.Error("Failed to reject a malformed rewrite map")
+	}
+}
+
+func TestMatchPatchIDs(t *testing.T) {
+	oldIDs := map[string]string{"A": "1", "B": "2", "C": "3", "D": "3"}
+	newIDs := map[string]string{"E": "1", "F": "2", "G": "3", "B": "4"}
+	mapping := matchPatchIDs(oldIDs, newIDs)
+	expected := map[string]string{"A": "E", "B": "F"}
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("Unexpected matches: %v", mapping)
+	}
+}
@@ -21,8 +21,11 @@ import (
 	"flag"
 	"fmt"
 	"github.com/promet/git-appraise/commands/input"
+	"github.com/promet/git-appraise/commands/output"
 	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
 	"github.com/promet/git-appraise/review/request"
+	"github.com/promet/git-appraise/review/template"
 	"strings"
 )
 
@@ -34,16 +37,29 @@ Review Ref: %s
 Message: "%s"
 `
 
+// requestResult is the JSON output of the "request" subcommand.
+type requestResult struct {
+	Revision string          `json:"revision"`
+	Request  request.Request `json:"request"`
+}
+
 var requestFlagSet = flag.NewFlagSet("request", flag.ExitOnError)
 
 var (
 	requestMessageFile      = requestFlagSet.String("F", "", "Take the comment from the given file. Use - to read the message from the standard input")
 	requestMessage          = requestFlagSet.String("m", "", "Message to attach to the review")
 	requestReviewers        = requestFlagSet.String("r", "", "Comma-separated list of reviewers")
-	requestSource           = requestFlagSet.String("source", "HEAD", "Revision to review")
-	requestTarget           = requestFlagSet.String("target", "refs/heads/develop", "Revision against which to review")
+	requestSource           = requestFlagSet.String("source", "HEAD", "Revision to review; a branch, or any commit, such as a detached HEAD")
+	requestTarget           = requestFlagSet.String("target", "refs/heads/develop", "Revision against which to review; a branch, a tag, or any commit")
 	requestQuiet            = requestFlagSet.Bool("quiet", false, "Suppress review summary output")
 	requestAllowUncommitted = requestFlagSet.Bool("allow-uncommitted", false, "Allow uncommitted local changes.")
+	requestSign             = requestFlagSet.Bool("sign", false, "Sign the request using the GPG key configured as user.signingkey")
+	requestDependsOn        = requestFlagSet.String("depends-on", "", "Hash of another review that must be submitted before this one")
+	requestDraft            = requestFlagSet.Bool("draft", false, "Mark the review as a work in progress, hidden from other users until it is published")
+	requestLabels           = requestFlagSet.String("labels", "", "Comma-separated list of labels to tag the review with")
+	requestIssues           = requestFlagSet.String("issues", "", "Comma-separated list of the issues that the review addresses (e.g. PROJ-123,#45)")
+	requestAutoAssign       = requestFlagSet.Bool("auto-assign", false, "Assign a reviewer picked from the pool configured in appraise.reviewers")
+	requestBlind            = requestFlagSet.Bool("blind", false, "Make the review blind, so that its commenters are known by pseudonyms that only the administrators configured in appraise.blindAdmins can reveal")
 )
 
 // Build the template review request based solely on the parsed flag values.
@@ -65,6 +81,48 @@ func buildRequestFromFlags(requester string) (request.Request, error) {
 	return request.New(requester, reviewers, *requestSource, *requestTarget, *requestMessage), nil
 }
 
+// splitLabels splits a comma-separated list of labels, dropping any empty entries.
+func splitLabels(labels string) []string {
+	var result []string
+	for _, label := range strings.Split(labels, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			result = append(result, label)
+		}
+	}
+	return result
+}
+
+// resolveRequestRef returns the name under which the given source or target of a review request is recorded.
+//
+// Refs are recorded as they are, and the short names of branches and tags as
+// their full refs. Any other revision, such as a commit hash or a detached
+// HEAD, is recorded as the full hash of its commit, which does not depend on
+// any ref continuing to exist.
+func resolveRequestRef(repo repository.Repo, name string) (string, error) {
+	verifyErr := repo.VerifyGitRef(name)
+	if verifyErr == nil {
+		return name, nil
+	}
+	if strings.HasPrefix(name, "refs/") {
+		return "", verifyErr
+	}
+	for _, ref := range []string{qualifyRef(name), "refs/tags/" + name} {
+		if repo.VerifyGitRef(ref) == nil {
+			return ref, nil
+		}
+	}
+	commit, err := repo.GetCommitHash(name)
+	if err != nil {
+		return "", fmt.Errorf("Unknown revision %q; expected a ref, a tag, or a commit.", name)
+	}
+	return commit, nil
+}
+
+// isFixedTarget reports whether the given target of a review is a tag or a commit, which submitting the review cannot update.
+func isFixedTarget(target string) bool {
+	return repository.IsCommitHash(target) || strings.HasPrefix(target, "refs/tags/")
+}
+
 // Get the commit at which the review request should be anchored.
 func getReviewCommit(repo repository.Repo, r request.Request, args []string) (string, string, error) {
 	if len(args) > 1 {
@@ -92,12 +150,46 @@ func getReviewCommit(repo repository.Repo, r request.Request, args []string) (st
 	return reviewCommits[0], base, nil
 }
 
+// getDependency returns the revision of the review that a new review will depend upon.
+//
+// The dependency must be an existing review, and must not itself (directly or
+// indirectly) depend upon the new review.
+func getDependency(repo repository.Repo, reviewCommit, dependsOn string) (string, error) {
+	resolved, err := resolveReview(repo, dependsOn)
+	if err != nil {
+		return "", err
+	}
+	dependency, err := repo.GetCommitHash(resolved)
+	if err != nil {
+		return "", fmt.Errorf("Could not find the review %q to depend upon: %w", dependsOn, err)
+	}
+	visited := map[string]bool{reviewCommit: true}
+	for revision := dependency; revision != ""; {
+		if visited[revision] {
+			return "", errors.New("A review cannot depend upon itself, either directly or indirectly.")
+		}
+		visited[revision] = true
+		parent, err := review.GetSummary(repo, revision)
+		if err != nil {
+			return "", err
+		}
+		if parent == nil {
+			return "", fmt.Errorf("There is no review for the commit %.12s.", revision)
+		}
+		revision = parent.Request.DependsOn
+	}
+	return dependency, nil
+}
+
 // Create a new code review request.
 //
 // The "args" parameter is all of the command line arguments that followed the subcommand.
 func requestReview(repo repository.Repo, args []string) error {
 	requestFlagSet.Parse(args)
 	args = requestFlagSet.Args()
+	if err := applyRequestConfig(repo); err != nil {
+		return err
+	}
 
 	if !*requestAllowUncommitted {
 		// Requesting a code review with uncommited local changes is usually a mistake, so
@@ -121,15 +213,19 @@ func requestReview(repo repository.Repo, args []string) error {
 	}
 	if r.ReviewRef == "HEAD" {
 		headRef, err := repo.GetHeadRef()
+		if err != nil {
+			// HEAD is detached, so the commit that it points to is reviewed instead.
+			headRef, err = repo.GetCommitHash("HEAD")
+		}
 		if err != nil {
 			return err
 		}
 		r.ReviewRef = headRef
 	}
-	if err := repo.VerifyGitRef(r.TargetRef); err != nil {
+	if r.TargetRef, err = resolveRequestRef(repo, r.TargetRef); err != nil {
 		return err
 	}
-	if err := repo.VerifyGitRef(r.ReviewRef); err != nil {
+	if r.ReviewRef, err = resolveRequestRef(repo, r.ReviewRef); err != nil {
 		return err
 	}
 
@@ -138,19 +234,77 @@ func requestReview(repo repository.Repo, args []string) error {
 		return err
 	}
 	r.BaseCommit = baseCommit
+	head, err := repo.GetCommitHash(r.ReviewRef)
+	if err != nil {
+		return err
+	}
+	r.Patchsets = []request.Patchset{{Timestamp: r.Timestamp, Commit: head, Base: baseCommit}}
+	// Keep the head reachable, so that the review still works if its branch is deleted.
+	if err := review.KeepCommit(repo, head); err != nil {
+		return err
+	}
+	r.Draft = *requestDraft
+	r.Labels = splitLabels(*requestLabels)
+	r.Issues = splitLabels(*requestIssues)
+	if *requestAutoAssign {
+		assigned, err := pickReviewers(repo, "", r.Requester, r.Reviewers, 1)
+		if err != nil {
+			return err
+		}
+		r.Reviewers = append(r.Reviewers, assigned...)
+	}
+	r.Reviewers = rerouteReviewers(repo, r.Reviewers)
+	if *requestBlind {
+		if r.Blind, err = getConfigList(repo, "blindAdmins"); err != nil {
+			return err
+		}
+		if len(r.Blind) == 0 {
+			return errors.New("A blind review needs administrators to reveal its reviewers; set appraise.blindAdmins to their GPG users or age keys.")
+		}
+	}
+	if *requestDependsOn != "" {
+		r.DependsOn, err = getDependency(repo, reviewCommit, *requestDependsOn)
+		if err != nil {
+			return err
+		}
+	}
+	t, err := template.Load(repo, r.TargetRef)
+	if err != nil {
+		return err
+	}
 	if r.Description == "" {
 		description, err := repo.GetCommitMessage(reviewCommit)
 		if err != nil {
 			return err
 		}
 		r.Description = description
+		if t != nil && len(t.Fields) > 0 && *requestMessageFile == "" && !JSONOutput {
+			edited, err := input.LaunchEditorWithText(repo, commentFilename, t.Inject(description))
+			if err != nil {
+				return err
+			}
+			r.Description = template.StripComments(edited)
+		}
+	}
+	if t != nil && !r.Draft {
+		if missing := t.Missing(r.Description); len(missing) > 0 {
+			return fmt.Errorf("The review description is missing the required field(s): %s. See %q.", strings.Join(missing, ", "), template.File)
+		}
 	}
 
+	if *requestSign {
+		if err := signMetadata(repo, &r); err != nil {
+			return err
+		}
+	}
 	note, err := r.Write()
 	if err != nil {
 		return err
 	}
 	repo.AppendNote(request.Ref, reviewCommit, note)
+	if JSONOutput {
+		return output.PrintJSONResult("request", requestResult{Revision: reviewCommit, Request: r})
+	}
 	if !*requestQuiet {
 		fmt.Printf(requestSummaryTemplate, reviewCommit, r.TargetRef, r.ReviewRef, r.Description)
 	}
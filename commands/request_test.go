@@ -17,6 +17,7 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/promet/git-appraise/repository"
 	"testing"
 )
 
@@ -34,3 +35,43 @@ func TestBuildRequestFromFlags(t *testing.T) {
 		t.Fatalf("Unexpected reviewers list: '%v'", r.Reviewers)
 	}
 }
+
+func TestGetDependency(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	dependency, err := getDependency(repo, repository.TestCommitG, repository.TestCommitD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dependency != repository.TestCommitD {
+		t.Fatalf("Unexpected dependency: %q", dependency)
+	}
+	if _, err := getDependency(repo, repository.TestCommitG, repository.TestCommitG); err == nil {
+		t.Fatal("Unexpectedly allowed a review to depend upon itself")
+	}
+	if _, err := getDependency(repo, repository.TestCommitG, repository.TestCommitA); err == nil {
+		t.Fatal("Unexpectedly allowed a dependency on a commit without a review")
+	}
+}
+
+func TestResolveRequestRef(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	for name, expected := range map[string]string{
+		"master":                 repository.TestTargetRef,
+		repository.TestTargetRef: repository.TestTargetRef,
+		repository.TestCommitA:   repository.TestCommitA,
+	} {
+		resolved, err := resolveRequestRef(repo, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved != expected {
+			t.Errorf("Unexpected resolution of %q: %q", name, resolved)
+		}
+	}
+	if _, err := resolveRequestRef(repo, "refs/heads/missing"); err == nil {
+		t.Fatal("Unexpectedly resolved a missing ref")
+	}
+	if !isFixedTarget("refs/tags/v1.0") || !isFixedTarget("578e52199ece9b2898a7ad99c1f0977071af5f44") || isFixedTarget(repository.TestTargetRef) {
+		t.Fatal("Failed to tell apart the fixed and branch targets")
+	}
+}
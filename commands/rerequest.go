@@ -0,0 +1,114 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/event"
+	"github.com/promet/git-appraise/review/request"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var rerequestFlagSet = flag.NewFlagSet("rerequest", flag.ExitOnError)
+
+var (
+	rerequestReviewers = rerequestFlagSet.String("r", "", "Comma-separated list of the reviewers to request the review from again")
+	rerequestMessage   = rerequestFlagSet.String("m", "", "Message describing what changed since they last looked")
+)
+
+// rerequestReview asks some of the reviewers to look at a review again, such as after it was updated.
+//
+// Any of them who are not already reviewers are added as reviewers.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func rerequestReview(repo repository.Repo, args []string) error {
+	rerequestFlagSet.Parse(args)
+	args = rerequestFlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("Only re-requesting a single review is supported.")
+	}
+	reviewers := splitValues(*rerequestReviewers)
+	if len(reviewers) == 0 {
+		return errors.New("The -r flag is required.")
+	}
+	reviewers = rerouteReviewers(repo, reviewers)
+
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+	if !r.IsOpen() {
+		return errors.New("Only open reviews can be requested again.")
+	}
+
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	if updated := updateLabels(r.Request.Reviewers, reviewers, nil); len(updated) != len(r.Request.Reviewers) {
+		r.Request.Reviewers = updated
+		r.Request.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+		r.Request.Signature = ""
+		note, err := r.Request.Write()
+		if err != nil {
+			return err
+		}
+		if err := repo.AppendNote(request.Ref, r.Revision, note); err != nil {
+			return err
+		}
+	}
+	e := event.NewRerequest(userEmail, reviewers, head, *rerequestMessage)
+	if err := r.AddEvent(e); err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("rerequest", eventResult{Review: r.Revision, Event: e})
+	}
+	fmt.Printf("Requested review %.12s again from: %s\n", r.Revision, strings.Join(reviewers, ", "))
+	return nil
+}
+
+// rerequestCmd defines the "rerequest" subcommand.
+var rerequestCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s rerequest -r <reviewers> [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		rerequestFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return rerequestReview(repo, args)
+	},
+}
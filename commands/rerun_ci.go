@@ -0,0 +1,138 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
+)
+
+var rerunCIFlagSet = flag.NewFlagSet("rerun-ci", flag.ExitOnError)
+
+var (
+	rerunCIAgent   = rerunCIFlagSet.String("agent", "", "CI agent that should re-run the build; defaults to every agent")
+	rerunCIMessage = rerunCIFlagSet.String("m", "", "Reason for re-running the build")
+	rerunCIPending = rerunCIFlagSet.Bool("pending", false, "List the rerun requests that have not yet been responded to, instead of posting one")
+)
+
+// rerunCIResult is the JSON output for a single rerun request.
+type rerunCIResult struct {
+	Commit string   `json:"commit"`
+	Hash   string   `json:"hash"`
+	Rerun  ci.Rerun `json:"rerun"`
+}
+
+// listPendingReruns prints the rerun requests, across every commit, that the given agent has not yet responded to.
+func listPendingReruns(repo repository.Repo, agent string) error {
+	var results []rerunCIResult
+	for _, commit := range repo.ListNotedRevisions(ci.RerunRef) {
+		reruns := ci.ParseAllValidReruns(repo.GetNotes(ci.RerunRef, commit))
+		reports := ci.ParseAllValid(repo.GetNotes(ci.Ref, commit))
+		pending, err := ci.PendingReruns(reruns, reports, agent)
+		if err != nil {
+			return err
+		}
+		for _, rerun := range pending {
+			hash, err := rerun.Hash()
+			if err != nil {
+				return err
+			}
+			results = append(results, rerunCIResult{Commit: commit, Hash: hash, Rerun: rerun})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return schema.CompareTimestamps(results[i].Rerun.Timestamp, results[j].Rerun.Timestamp) < 0
+	})
+	if JSONOutput {
+		return output.PrintJSONResult("rerun-ci", results)
+	}
+	for _, result := range results {
+		agentName := result.Rerun.Agent
+		if agentName == "" {
+			agentName = "all agents"
+		}
+		fmt.Printf("%s %s (%s) requested by %s", result.Hash, result.Commit, agentName, result.Rerun.Requester)
+		if result.Rerun.Reason != "" {
+			fmt.Printf(": %s", result.Rerun.Reason)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// rerunCI requests that CI agents build and test the head of a review again.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func rerunCI(repo repository.Repo, args []string) error {
+	rerunCIFlagSet.Parse(args)
+	args = rerunCIFlagSet.Args()
+
+	if *rerunCIPending {
+		if len(args) > 0 {
+			return errors.New("Listing pending rerun requests does not take a review.")
+		}
+		return listPendingReruns(repo, *rerunCIAgent)
+	}
+
+	r, err := loadRobotReview(repo, args)
+	if err != nil {
+		return err
+	}
+	commit, err := r.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	requester, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	rerun := ci.NewRerun(requester, *rerunCIAgent, *rerunCIMessage)
+	note, err := rerun.Write()
+	if err != nil {
+		return err
+	}
+	if err := repo.AppendNote(ci.RerunRef, commit, note); err != nil {
+		return err
+	}
+	hash, err := rerun.Hash()
+	if err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("rerun-ci", rerunCIResult{Commit: commit, Hash: hash, Rerun: rerun})
+	}
+	fmt.Printf("Requested a rerun of the CI build for %.12s (rerun %.12s)\n", commit, hash)
+	return nil
+}
+
+// rerunCICmd defines the "rerun-ci" subcommand.
+var rerunCICmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s rerun-ci [-agent <agent>] [-m <message>] [<review-hash>]\n", arg0)
+		fmt.Printf("       %s rerun-ci -pending [-agent <agent>]\n\nOptions:\n", arg0)
+		rerunCIFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return rerunCI(repo, args)
+	},
+}
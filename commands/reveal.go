@@ -0,0 +1,175 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/blind"
+)
+
+var revealFlagSet = flag.NewFlagSet("reveal", flag.ExitOnError)
+
+var (
+	revealJSONOutput = revealFlagSet.Bool("json", false, "Format the output as JSON")
+)
+
+// revealedPseudonym is the JSON output for a single pseudonym revealed by the "reveal" subcommand.
+type revealedPseudonym struct {
+	Pseudonym string `json:"pseudonym"`
+	Identity  string `json:"identity"`
+}
+
+// blindSecret returns the user's secret from which their pseudonyms on blind reviews are derived.
+//
+// The secret is generated the first time that it is needed, and kept in the
+// user's global git config, so that they have the same pseudonym on a review
+// no matter which clone of the repo they comment from.
+func blindSecret(repo repository.Repo) ([]byte, error) {
+	secret, err := getConfigValue(repo, "blindSecret")
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		bytes := make([]byte, 32)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(bytes)
+		if err := repo.SetConfig(configPrefix+"blindSecret", true, secret); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(secret), nil
+}
+
+// commentAuthor returns the author that the user's comments on the given review are attributed to.
+//
+// That is the user's email, unless the review is blind and the user did not
+// request it, in which case it is their pseudonym on the review. The first
+// time that the user comments under a pseudonym, their identity is recorded,
+// encrypted to the administrators of the review. The review's repo is then
+// replaced by one that attributes the commits of the notes refs to the
+// pseudonym as well, so that they do not give the user away either.
+func commentAuthor(repo repository.Repo, r *review.Review, sign bool) (string, error) {
+	userEmail, err := repo.GetUserEmail()
+	if err != nil || len(r.Request.Blind) == 0 || userEmail == r.Request.Requester {
+		return userEmail, err
+	}
+	if sign {
+		return "", errors.New("Signing a comment on a blind review would reveal who wrote it.")
+	}
+	secret, err := blindSecret(repo)
+	if err != nil {
+		return "", err
+	}
+	pseudonym := blind.NewPseudonym(secret, r.Revision)
+	r.Repo = r.Repo.WithAuthor(pseudonym, pseudonym+"@"+blind.Domain)
+	if blind.Contains(blind.ParseAllValid(r.Repo.GetNotes(blind.Ref, r.Revision)), pseudonym) {
+		return pseudonym, nil
+	}
+	p, err := blind.New(pseudonym, userEmail, r.Request.Blind)
+	if err != nil {
+		return "", err
+	}
+	note, err := p.Write()
+	if err != nil {
+		return "", err
+	}
+	if err := r.Repo.AppendNote(blind.Ref, r.Revision, note); err != nil {
+		return "", err
+	}
+	return pseudonym, nil
+}
+
+// revealReviewers prints who is behind each of the pseudonyms on a blind review.
+//
+// Only the administrators of the review can decrypt the identities behind them.
+func revealReviewers(repo repository.Repo, args []string) error {
+	revealFlagSet.Parse(args)
+	args = revealFlagSet.Args()
+
+	if len(args) > 1 {
+		return errors.New("Only revealing the reviewers of a single review is supported.")
+	}
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+	if len(r.Request.Blind) == 0 {
+		return errors.New("The review is not blind.")
+	}
+
+	identity, err := ageIdentityFile(repo)
+	if err != nil {
+		return err
+	}
+	var revealed []revealedPseudonym
+	for _, p := range blind.ParseAllValid(repo.GetNotes(blind.Ref, r.Revision)) {
+		if containsPseudonym(revealed, p.Pseudonym) {
+			continue
+		}
+		email, err := p.Reveal(identity)
+		if err != nil {
+			return fmt.Errorf("Failed to reveal %s: %v", p.Pseudonym, err)
+		}
+		revealed = append(revealed, revealedPseudonym{Pseudonym: p.Pseudonym, Identity: email})
+	}
+	if JSONOutput || *revealJSONOutput {
+		return output.PrintJSONResult("reveal", revealed)
+	}
+	for _, p := range revealed {
+		fmt.Printf("%s\t%s\n", p.Pseudonym, p.Identity)
+	}
+	return nil
+}
+
+// containsPseudonym reports whether the given pseudonym has already been revealed.
+func containsPseudonym(revealed []revealedPseudonym, pseudonym string) bool {
+	for _, p := range revealed {
+		if p.Pseudonym == pseudonym {
+			return true
+		}
+	}
+	return false
+}
+
+// revealCmd defines the "reveal" subcommand.
+var revealCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s reveal [-json] [<review-hash>]\n\nOptions:\n", arg0)
+		revealFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return revealReviewers(repo, args)
+	},
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/input"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/robot"
+)
+
+var robotPostFlagSet = flag.NewFlagSet("robot post", flag.ExitOnError)
+
+var robotListFlagSet = flag.NewFlagSet("robot list", flag.ExitOnError)
+
+var (
+	robotPostAnalyzer = robotPostFlagSet.String("analyzer", "", "Name of the analyzer that produced the findings")
+	robotPostCommit   = robotPostFlagSet.String("commit", "", "Commit that was analyzed; defaults to the head of the review")
+	robotPostFile     = robotPostFlagSet.String("F", "-", "File containing the findings, either as a JSON list or as a SARIF log. Use - to read from the standard input")
+	robotListAll      = robotListFlagSet.Bool("all", false, "Also list the robot comments that have been resolved")
+)
+
+// loadRobotReview loads the review named by the given arguments, or the current review if there are none.
+func loadRobotReview(repo repository.Repo, args []string) (*review.Review, error) {
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return nil, errors.New("Only a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return nil, review.ErrReviewNotFound
+	}
+	return r, nil
+}
+
+// robotPost records the findings of a single run of an analyzer against a review.
+func robotPost(repo repository.Repo, args []string) error {
+	robotPostFlagSet.Parse(args)
+	args = robotPostFlagSet.Args()
+	if *robotPostAnalyzer == "" {
+		return errors.New("The name of the analyzer must be specified with --analyzer.")
+	}
+	r, err := loadRobotReview(repo, args)
+	if err != nil {
+		return err
+	}
+	commit := *robotPostCommit
+	if commit == "" {
+		commit, err = r.GetHeadCommit()
+	} else {
+		commit, err = repo.ResolveRefCommit(commit)
+	}
+	if err != nil {
+		return err
+	}
+	contents, err := input.FromFile(*robotPostFile)
+	if err != nil {
+		return err
+	}
+	findings, err := robot.ParseFindings([]byte(contents))
+	if err != nil {
+		return err
+	}
+
+	run := robot.NewRun(*robotPostAnalyzer, commit, findings)
+	note, err := run.Write()
+	if err != nil {
+		return err
+	}
+	if err := repo.AppendNote(robot.Ref, r.Revision, note); err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("robot", run)
+	}
+	return nil
+}
+
+// robotList lists the robot comments on a review.
+func robotList(repo repository.Repo, args []string) error {
+	robotListFlagSet.Parse(args)
+	args = robotListFlagSet.Args()
+	r, err := loadRobotReview(repo, args)
+	if err != nil {
+		return err
+	}
+	comments := []robot.Comment{}
+	for _, c := range r.RobotComments {
+		if *robotListAll || !c.Resolved {
+			comments = append(comments, c)
+		}
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("robot", comments)
+	}
+	for _, c := range comments {
+		output.PrintRobotComment(c, "")
+	}
+	return nil
+}
+
+// robotSubcommands defines the operations supported by the "robot" subcommand.
+var robotSubcommands = map[string]mirrorSystem{
+	"list": {
+		Usage: "list [-all] [<review-hash>]",
+		Flags: robotListFlagSet,
+		Run:   robotList,
+	},
+	"post": {
+		Usage: "post -analyzer <name> [-commit <commit>] [-F <file>] [<review-hash>]",
+		Flags: robotPostFlagSet,
+		Run:   robotPost,
+	},
+}
+
+// robotReviews dispatches to the robot comment operation named by the first argument.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func robotReviews(repo repository.Repo, args []string) error {
+	if len(args) < 1 {
+		return errors.New("The robot command requires either \"list\" or \"post\".")
+	}
+	subcommand, ok := robotSubcommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown robot operation %q.", args[0])
+	}
+	return subcommand.Run(repo, args[1:])
+}
+
+// robotCmd defines the "robot" subcommand.
+var robotCmd = &Command{
+	Usage: func(arg0 string) {
+		for _, name := range []string{"list", "post"} {
+			subcommand := robotSubcommands[name]
+			fmt.Printf("Usage: %s robot %s\n\nOptions:\n", arg0, subcommand.Usage)
+			subcommand.Flags.PrintDefaults()
+			fmt.Println()
+		}
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return robotReviews(repo, args)
+	},
+}
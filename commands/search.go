@@ -0,0 +1,143 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/policy"
+	"github.com/promet/git-appraise/search"
+	"path/filepath"
+	"strings"
+)
+
+// searchIndexFile is the path, relative to the git directory, of the persistent search index.
+var searchIndexFile = filepath.Join("appraise", "search.gob")
+
+var searchFlagSet = flag.NewFlagSet("search", flag.ExitOnError)
+
+var (
+	searchUseIndex = searchFlagSet.Bool("index", false, "Use, and keep up to date, a persistent search index stored in the git directory under "+searchIndexFile)
+)
+
+// loadSearchIndex returns an index of every review in the repo.
+//
+// If requested, a previously saved index is used as long as the repo has not changed since it was saved.
+func loadSearchIndex(repo repository.Repo, usePersisted bool) (*search.Index, error) {
+	stateHash, err := repo.GetRepoStateHash()
+	if err != nil {
+		return nil, err
+	}
+	if usePersisted {
+		path, err := gitDirPath(repo, searchIndexFile)
+		if err != nil {
+			return nil, err
+		}
+		index, err := search.Load(path)
+		if err == nil && index.StateHash == stateHash {
+			return index, nil
+		}
+	}
+	return search.Build(stateHash, review.ListAll(repo)), nil
+}
+
+// findReviews returns the reviews matching the given search query.
+//
+// If requested, the persistent search index is used and kept up to date.
+func findReviews(repo repository.Repo, q string, usePersisted bool) ([]review.Summary, error) {
+	query, err := search.ParseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	index, err := loadSearchIndex(repo, usePersisted)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := index.Search(query, func(revision string) ([]string, error) {
+		r, err := review.Get(repo, revision)
+		if err != nil || r == nil {
+			return nil, err
+		}
+		return policy.ChangedPaths(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if usePersisted {
+		path, err := gitDirPath(repo, searchIndexFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := index.Save(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var reviews []review.Summary
+	for _, revision := range revisions {
+		r, err := review.GetSummary(repo, revision)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			reviews = append(reviews, *r)
+		}
+	}
+	return reviews, nil
+}
+
+// searchReviews lists the reviews matching a search query.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func searchReviews(repo repository.Repo, args []string) error {
+	searchFlagSet.Parse(args)
+	args = searchFlagSet.Args()
+	if len(args) == 0 {
+		return errors.New("Searching requires a query.")
+	}
+	reviews, err := findReviews(repo, strings.Join(args, " "), *searchUseIndex)
+	if err != nil {
+		return err
+	}
+	if JSONOutput {
+		if reviews == nil {
+			reviews = []review.Summary{}
+		}
+		return output.PrintJSONResult("search", reviews)
+	}
+	fmt.Printf("Found %d matching reviews:\n", len(reviews))
+	for _, r := range reviews {
+		output.PrintSummary(&r)
+	}
+	return nil
+}
+
+// searchCmd defines the "search" subcommand.
+var searchCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s search [<option>...] <query>...\n\n", arg0)
+		fmt.Printf("Query terms of the form author:, reviewer:, status:, label:, and path: match those fields.\n\nOptions:\n")
+		searchFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return searchReviews(repo, args)
+	},
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/api"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/web"
+	"github.com/promet/git-appraise/webhook"
+	"net"
+	"net/http"
+)
+
+var serveFlagSet = flag.NewFlagSet("serve", flag.ExitOnError)
+
+var (
+	serveAddr    = serveFlagSet.String("addr", "localhost:8080", "Address on which to serve")
+	serveWithAPI = serveFlagSet.Bool("api", false, "Serve the REST API under "+api.PathPrefix)
+	serveWithWeb = serveFlagSet.Bool("web", false, "Serve the read-only web dashboard")
+
+	serveWithWebhooks  = serveFlagSet.Bool("webhooks", false, "Receive CI webhooks from Jenkins, Buildkite, and GitHub Actions under "+webhook.PathPrefix)
+	serveWebhookRemote = serveFlagSet.String("webhook-remote", "origin", "Remote to push the CI reports received by webhooks to; empty to not push them")
+	serveWebhookSecret = serveFlagSet.String("webhook-secret", "", "Shared secret that webhooks must be authenticated with; required unless -addr is a loopback address")
+)
+
+// buildServeHandler returns the HTTP handler for the parts of the server that were requested.
+func buildServeHandler(repo repository.Repo, serveAPI, serveWeb, serveWebhooks bool) (http.Handler, error) {
+	if !serveAPI && !serveWeb && !serveWebhooks {
+		return nil, errors.New("At least one of -api, -web, or -webhooks must be specified.")
+	}
+	mux := http.NewServeMux()
+	if serveAPI {
+		mux.Handle(api.PathPrefix, api.New(repo))
+	}
+	if serveWebhooks {
+		mux.Handle(webhook.PathPrefix, webhook.New(repo, *serveWebhookRemote, *serveWebhookSecret))
+	}
+	if serveWeb {
+		mux.Handle("/", web.New(repo))
+	}
+	return mux, nil
+}
+
+// isLoopbackAddr reports whether the given address to serve on can only be reached from the local machine.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// listenAndServe serves HTTP requests on the given address until the repo's context is done.
+func listenAndServe(repo repository.Repo, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-repo.Context().Done()
+		server.Close()
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// serve runs an HTTP server for the reviews in the repo.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func serve(repo repository.Repo, args []string) error {
+	serveFlagSet.Parse(args)
+	if len(serveFlagSet.Args()) > 0 {
+		return errors.New("The serve command does not take any arguments.")
+	}
+	if *serveWithWebhooks && *serveWebhookSecret == "" && !isLoopbackAddr(*serveAddr) {
+		return fmt.Errorf("Receiving webhooks on %q, which is not a loopback address, requires a -webhook-secret.", *serveAddr)
+	}
+	handler, err := buildServeHandler(repo, *serveWithAPI, *serveWithWeb, *serveWithWebhooks)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving reviews at http://%s/\n", *serveAddr)
+	return listenAndServe(repo, *serveAddr, handler)
+}
+
+// serveCmd defines the "serve" subcommand.
+var serveCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s serve [<option>...]\n\nOptions:\n", arg0)
+		serveFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return serve(repo, args)
+	},
+	NoSync: true,
+}
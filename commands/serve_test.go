@@ -0,0 +1,38 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"testing"
+)
+
+func TestIsLoopbackAddr(t *testing.T) {
+	for addr, expected := range map[string]bool{
+		"localhost:8080":    true,
+		"127.0.0.1:8080":    true,
+		"[::1]:8080":        true,
+		":8080":             false,
+		"0.0.0.0:8080":      false,
+		"192.168.1.10:8080": false,
+		"example.com:8080":  false,
+		"localhost":         false,
+	} {
+		if actual := isLoopbackAddr(addr); actual != expected {
+			t.Errorf("Unexpected result for %q: %v", addr, actual)
+		}
+	}
+}
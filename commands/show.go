@@ -23,17 +23,43 @@ import (
 	"github.com/promet/git-appraise/commands/output"
 	"github.com/promet/git-appraise/repository"
 	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/archive"
+	"github.com/promet/git-appraise/review/viewed"
+	"os"
+	"os/exec"
+	"strconv"
 	"strings"
+	"text/template"
 )
 
 var showFlagSet = flag.NewFlagSet("show", flag.ExitOnError)
 
 var (
 	showJSONOutput  = showFlagSet.Bool("json", false, "Format the output as JSON")
-	showDiffOutput  = showFlagSet.Bool("diff", false, "Show the current diff for the review")
+	showComments    = showFlagSet.Bool("comments", false, "Show only the comment threads for the review, including whether each one is still open")
+	showDiffOutput  = showFlagSet.Bool("diff", false, "Show the current diff for the review, with the comments on each line shown inline")
 	showDiffOptions = showFlagSet.String("diff-opts", "", "Options to pass to the diff tool; can only be used with the --diff option")
+	showColor       = showFlagSet.String("color", "auto", "Whether to color the diff: \"always\", \"never\", or \"auto\" to only color output to a terminal")
+	showPager       = showFlagSet.Bool("pager", true, "Page the diff using the pager configured for git, when writing to a terminal")
+	showViewed      = showFlagSet.Bool("include-viewed", false, "Include the files and hunks that have been marked as viewed in the diff")
+	showWhitespace  = showFlagSet.String("ignore-whitespace", "", "Whitespace changes to leave out of the diff: \"all\", \"change\" for changes in the amount of whitespace, or \"none\"; defaults to the \"appraise.diffIgnoreWhitespace\" setting")
+	showIgnoreMoved = showFlagSet.Bool("ignore-moved", false, "Dim the lines of code that were only moved, so that the changes stand out; defaults to the \"appraise.diffIgnoreMoved\" setting")
+	showSideBySide  = showFlagSet.Bool("side-by-side", false, "Show the diff in two columns, with the old version of each file on the left and the new version on the right; can only be used with the --diff option")
+	showArchived    = showFlagSet.Bool("archived", false, "Show a review that has been archived")
+	showPlain       = showFlagSet.Bool("plain", false, "Print the description and comments as they were written, rather than rendering their Markdown")
+	showFormat      = showFlagSet.String("format", "", "Print the review using the given Go template, or one of the built-in ones (oneline, short, or markdown); prefix a path with @ to read the template from a file")
 )
 
+// showDiffResult is the JSON output of the "show" subcommand when the diff is requested.
+type showDiffResult struct {
+	Review      string                 `json:"review"`
+	Diff        string                 `json:"diff"`
+	HiddenFiles int                    `json:"hiddenFiles,omitempty"`
+	HiddenHunks int                    `json:"hiddenHunks,omitempty"`
+	Submodules  []review.SubmoduleDiff `json:"submodules,omitempty"`
+	BinaryFiles []review.BinaryDiff    `json:"binaryFiles,omitempty"`
+}
+
 // showReview prints the current code review.
 func showReview(repo repository.Repo, args []string) error {
 	showFlagSet.Parse(args)
@@ -41,38 +67,284 @@ func showReview(repo repository.Repo, args []string) error {
 	if *showDiffOptions != "" && !*showDiffOutput {
 		return errors.New("The --diff-opts flag can only be used if the --diff flag is set.")
 	}
+	if *showViewed && !*showDiffOutput {
+		return errors.New("The --include-viewed flag can only be used if the --diff flag is set.")
+	}
+	if (*showWhitespace != "" || isFlagSet(showFlagSet, "ignore-moved")) && !*showDiffOutput {
+		return errors.New("The --ignore-whitespace and --ignore-moved flags can only be used if the --diff flag is set.")
+	}
+	if *showSideBySide && !*showDiffOutput {
+		return errors.New("The --side-by-side flag can only be used if the --diff flag is set.")
+	}
+	var format *template.Template
+	if *showFormat != "" {
+		if JSONOutput || *showJSONOutput || *showDiffOutput || *showComments {
+			return errors.New("The --format flag cannot be used with the --json, --diff, or --comments flags.")
+		}
+		var err error
+		if format, err = output.ParseFormat(*showFormat); err != nil {
+			return err
+		}
+	}
 
 	var r *review.Review
 	var err error
 	if len(args) > 1 {
 		return errors.New("Only showing a single review is supported.")
 	}
+	if *showArchived {
+		repo = archive.NewRepo(repo)
+	}
 
 	if len(args) == 1 {
-		r, err = review.Get(repo, args[0])
+		r, err = getReview(repo, args[0])
 	} else {
 		r, err = review.GetCurrent(repo)
 	}
 
 	if err != nil {
-		return fmt.Errorf("Failed to load the review: %v\n", err)
+		return fmt.Errorf("Failed to load the review: %w\n", err)
 	}
 	if r == nil {
-		return errors.New("There is no matching review.")
+		return review.ErrReviewNotFound
+	}
+	r.CheckMergeable()
+	identity, err := ageIdentityFile(repo)
+	if err != nil {
+		return err
+	}
+	r.DecryptComments(identity)
+	if format != nil {
+		return output.PrintFormatted(os.Stdout, format, r)
 	}
-	if *showJSONOutput {
+	if *showJSONOutput && !JSONOutput {
 		return output.PrintJSON(r)
 	}
+	warnSkippedCIReports(r.Reports)
 	if *showDiffOutput {
-		var diffArgs []string
+		diffArgs, err := whitespaceDiffArgs(repo, *showWhitespace)
+		if err != nil {
+			return err
+		}
 		if *showDiffOptions != "" {
-			diffArgs = strings.Split(*showDiffOptions, ",")
+			diffArgs = append(diffArgs, strings.Split(*showDiffOptions, ",")...)
+		}
+		diff, err := r.GetDiff(append([]string{"--no-color"}, diffArgs...)...)
+		if err != nil {
+			return err
+		}
+		submodules, err := r.GetSubmoduleDiffs(append([]string{"--no-color"}, diffArgs...)...)
+		if err != nil {
+			return err
+		}
+		binaries, err := r.GetBinaryDiffs()
+		if err != nil {
+			return err
 		}
-		return output.PrintDiff(r, diffArgs...)
+		var hiddenFiles, hiddenHunks int
+		if !*showViewed {
+			diff, hiddenFiles, hiddenHunks = viewed.Load(repo, r.Revision).Filter(diff)
+		}
+		if JSONOutput {
+			return output.PrintJSONResult("show", showDiffResult{
+				Review:      r.Revision,
+				Diff:        diff,
+				HiddenFiles: hiddenFiles,
+				HiddenHunks: hiddenHunks,
+				Submodules:  submodules,
+				BinaryFiles: binaries,
+			})
+		}
+		style, err := diffStyle(repo, *showColor, showFlagSet, *showIgnoreMoved)
+		if err != nil {
+			return err
+		}
+		threads, err := r.RemapComments()
+		if err != nil {
+			return err
+		}
+		remapped := *r
+		remapped.Comments = threads
+		var base string
+		var width int
+		if *showSideBySide {
+			if base, err = r.GetBaseCommit(); err != nil {
+				return err
+			}
+			width = terminalWidth()
+		}
+		printDiff := func() error {
+			if hiddenFiles > 0 || hiddenHunks > 0 {
+				fmt.Printf("Hiding %d viewed file(s) and %d viewed hunk(s); use --include-viewed to show them.\n\n",
+					hiddenFiles, hiddenHunks)
+			}
+			if diff != "" && *showSideBySide {
+				if err := output.PrintSideBySideDiff(&remapped, diff, base, style, width); err != nil {
+					return err
+				}
+			} else if diff != "" {
+				if err := output.PrintInlineDiff(&remapped, diff, style); err != nil {
+					return err
+				}
+			}
+			output.PrintBinaryDiffs(binaries, style)
+			output.PrintSubmoduleDiffs(submodules, style)
+			return nil
+		}
+		if *showPager && isTerminal(os.Stdout) {
+			return runWithPager(repo, printDiff)
+		}
+		return printDiff()
+	}
+	// The comments shown inline in a diff are already colored, so they are left as written.
+	if output.Markdown, err = useColor(*showColor); err != nil {
+		return err
+	}
+	output.Markdown = output.Markdown && !*showPlain
+	if *showComments {
+		if JSONOutput {
+			return output.PrintJSONResult("show", r.Comments)
+		}
+		return output.PrintComments(r)
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("show", r)
 	}
 	return output.PrintDetails(r)
 }
 
+// isTerminal returns whether or not the given file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// useColor returns whether or not output should be colored, given the value of the "-color" flag.
+func useColor(setting string) (bool, error) {
+	switch setting {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb", nil
+	}
+	return false, fmt.Errorf("Invalid color setting %q; expected \"always\", \"never\", or \"auto\".", setting)
+}
+
+// diffStyle returns the style of printing diffs, given the values of the "-color" and "-ignore-moved" flags of the given flag set.
+//
+// Colored diffs are highlighted as configured by the "appraise.diffSyntax" and
+// "appraise.diffWords" settings, and moved code is dimmed as configured by the
+// "appraise.diffIgnoreMoved" setting unless the "-ignore-moved" flag is passed.
+func diffStyle(repo repository.Repo, colorSetting string, flags *flag.FlagSet, ignoreMoved bool) (output.DiffStyle, error) {
+	var style output.DiffStyle
+	var err error
+	if style.Color, err = useColor(colorSetting); err != nil || !style.Color {
+		return style, err
+	}
+	if style.Syntax, err = getConfigBool(repo, "diffSyntax", true); err != nil {
+		return style, err
+	}
+	if style.Words, err = getConfigBool(repo, "diffWords", true); err != nil {
+		return style, err
+	}
+	if isFlagSet(flags, "ignore-moved") {
+		style.Moved = ignoreMoved
+		return style, nil
+	}
+	style.Moved, err = getConfigBool(repo, "diffIgnoreMoved", false)
+	return style, err
+}
+
+// whitespaceDiffArgs returns the options of git diff that leave out the
+// changes to whitespace named by the "-ignore-whitespace" flag, or by the
+// "appraise.diffIgnoreWhitespace" setting if the flag is empty.
+func whitespaceDiffArgs(repo repository.Repo, setting string) ([]string, error) {
+	if setting == "" {
+		var err error
+		if setting, err = getConfigValue(repo, "diffIgnoreWhitespace"); err != nil {
+			return nil, err
+		}
+	}
+	switch setting {
+	case "", "none":
+		return nil, nil
+	case "change":
+		return []string{"--ignore-space-change"}, nil
+	case "all":
+		return []string{"--ignore-all-space"}, nil
+	}
+	return nil, fmt.Errorf("Invalid whitespace setting %q; expected \"all\", \"change\", or \"none\".", setting)
+}
+
+// defaultTerminalWidth is the width that side-by-side diffs fit within if the width of the terminal is not known.
+const defaultTerminalWidth = 160
+
+// terminalWidth returns the number of columns of the terminal, as given by the
+// COLUMNS environment variable, or else by the terminal that the tool was run from.
+func terminalWidth() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	cmd := exec.Command("stty", "size")
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		defer tty.Close()
+		cmd.Stdin = tty
+	}
+	if out, err := cmd.Output(); err == nil {
+		if fields := strings.Fields(string(out)); len(fields) == 2 {
+			if columns, err := strconv.Atoi(fields[1]); err == nil && columns > 0 {
+				return columns
+			}
+		}
+	}
+	return defaultTerminalWidth
+}
+
+// runWithPager runs the given function with its standard output sent through the pager configured for git.
+func runWithPager(repo repository.Repo, print func() error) error {
+	pager, err := getConfigValue(repo, "pager")
+	if err == nil && pager == "" {
+		pager, err = repo.GetCorePager()
+	}
+	if err != nil || pager == "" || pager == "cat" {
+		return print()
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = reader
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Use the same defaults that git itself uses when starting the pager.
+	cmd.Env = os.Environ()
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(cmd.Env, "LESS=FRX")
+	}
+	if os.Getenv("LV") == "" {
+		cmd.Env = append(cmd.Env, "LV=-c")
+	}
+	if err := cmd.Start(); err != nil {
+		reader.Close()
+		writer.Close()
+		return print()
+	}
+	reader.Close()
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	printErr := print()
+	os.Stdout = stdout
+	writer.Close()
+	if err := cmd.Wait(); err != nil && printErr == nil {
+		return err
+	}
+	return printErr
+}
+
 // showCmd defines the "show" subcommand.
 var showCmd = &Command{
 	Usage: func(arg0 string) {
@@ -0,0 +1,190 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/stats"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var statsFlagSet = flag.NewFlagSet("stats", flag.ExitOnError)
+
+var (
+	statsSince     = statsFlagSet.String("since", "", "Only include the reviews requested since the given date (YYYY-MM-DD or RFC 3339), or duration ago (e.g. 36h or 30d).")
+	statsUntil     = statsFlagSet.String("until", "", "Only include the reviews requested before the given date (YYYY-MM-DD or RFC 3339), or duration ago.")
+	statsFormat    = statsFlagSet.String("format", "text", "Format of the output; one of \"text\", \"csv\", or \"json\".")
+	statsReviewers = statsFlagSet.Bool("reviewers", false, "With -format csv, write one row per reviewer instead of one row per review.")
+)
+
+// formatSeconds formats an optional number of seconds as a duration.
+func formatSeconds(seconds *int64) string {
+	if seconds == nil {
+		return "n/a"
+	}
+	return (time.Duration(*seconds) * time.Second).String()
+}
+
+// formatOptionalSeconds formats an optional number of seconds as a CSV field.
+func formatOptionalSeconds(seconds *int64) string {
+	if seconds == nil {
+		return ""
+	}
+	return strconv.FormatInt(*seconds, 10)
+}
+
+// formatBreaches formats the objectives of the SLA that a review was late for as a CSV field.
+func formatBreaches(breaches []stats.Breach) string {
+	var objectives []string
+	for _, b := range breaches {
+		objectives = append(objectives, b.Objective)
+	}
+	return strings.Join(objectives, ";")
+}
+
+// writeStatsCSV writes either the per-review or the per-reviewer rows of the report as CSV.
+func writeStatsCSV(report *stats.Report, perReviewer bool) error {
+	w := csv.NewWriter(os.Stdout)
+	if perReviewer {
+		w.Write([]string{"reviewer", "assigned", "reviewed", "comments"})
+		for _, load := range report.Reviewers {
+			w.Write([]string{
+				load.Reviewer,
+				strconv.Itoa(load.Assigned),
+				strconv.Itoa(load.Reviewed),
+				strconv.Itoa(load.Comments),
+			})
+		}
+	} else {
+		w.Write([]string{"revision", "requester", "status", "created", "time_to_first_review", "time_to_merge",
+			"files_changed", "lines_added", "lines_deleted", "comments", "sla_breaches"})
+		for _, r := range report.Reviews {
+			w.Write([]string{
+				r.Revision,
+				r.Requester,
+				r.Status,
+				time.Unix(r.Created, 0).UTC().Format(time.RFC3339),
+				formatOptionalSeconds(r.TimeToFirstReview),
+				formatOptionalSeconds(r.TimeToMerge),
+				strconv.Itoa(r.FilesChanged),
+				strconv.Itoa(r.LinesAdded),
+				strconv.Itoa(r.LinesDeleted),
+				strconv.Itoa(r.Comments),
+				formatBreaches(r.Breaches),
+			})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// printStatsText prints a human readable summary of the report.
+func printStatsText(report *stats.Report) {
+	totals := report.Totals
+	fmt.Printf("Reviews requested: %d (%d submitted)\n", totals.Reviews, totals.Submitted)
+	fmt.Printf("Median time to first review: %s\n", formatSeconds(totals.MedianTimeToFirstReview))
+	fmt.Printf("Median time to merge: %s\n", formatSeconds(totals.MedianTimeToMerge))
+	fmt.Printf("Median lines changed: %d\n", totals.MedianLinesChanged)
+	fmt.Printf("Comments per review: %.1f\n", totals.CommentsPerReview)
+	if totals.FirstResponseBreaches > 0 || totals.ResolutionBreaches > 0 {
+		fmt.Printf("SLA breaches: %d of first response, %d of resolution\n", totals.FirstResponseBreaches, totals.ResolutionBreaches)
+		for _, r := range report.Reviews {
+			for _, b := range r.Breaches {
+				state := "still unmet"
+				if b.Met {
+					state = "met late"
+				}
+				fmt.Printf("  %.12s: %s %s overdue (%s)\n", r.Revision, b.Objective, time.Duration(b.Overdue)*time.Second, state)
+			}
+		}
+	}
+	if len(report.Reviewers) == 0 {
+		return
+	}
+	fmt.Println("\nReviewer load:")
+	for _, load := range report.Reviewers {
+		fmt.Printf("  %s: reviewed %d, assigned %d, %d comments\n", load.Reviewer, load.Reviewed, load.Assigned, load.Comments)
+	}
+}
+
+// showStats prints metrics about the reviews requested within a date range.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func showStats(repo repository.Repo, args []string) error {
+	statsFlagSet.Parse(args)
+	if statsFlagSet.NArg() > 0 {
+		return errors.New("The stats command does not take any arguments.")
+	}
+	now := time.Now()
+	var since, until time.Time
+	var err error
+	if *statsSince != "" {
+		if since, err = parseSince(*statsSince, now); err != nil {
+			return err
+		}
+	}
+	if *statsUntil != "" {
+		if until, err = parseSince(*statsUntil, now); err != nil {
+			return err
+		}
+	}
+
+	identities, err := identity.Load(repo, "HEAD")
+	if err != nil {
+		return err
+	}
+	report := stats.Compute(review.ListAll(repo), since, until, identities)
+	sla, err := loadSLA(repo)
+	if err != nil {
+		return err
+	}
+	if !sla.IsZero() {
+		report.ApplySLA(sla, now)
+	}
+	if JSONOutput || *statsFormat == "json" {
+		return output.PrintJSONResult("stats", report)
+	}
+	switch *statsFormat {
+	case "csv":
+		return writeStatsCSV(report, *statsReviewers)
+	case "text":
+		printStatsText(report)
+		return nil
+	}
+	return fmt.Errorf("Unknown stats format %q.", *statsFormat)
+}
+
+// statsCmd defines the "stats" subcommand.
+var statsCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s stats [<option>...]\n\nOptions:\n", arg0)
+		statsFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return showStats(repo, args)
+	},
+}
@@ -0,0 +1,334 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/event"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/policy"
+	"github.com/promet/git-appraise/review/schema"
+	"github.com/promet/git-appraise/search"
+	"sort"
+	"strings"
+)
+
+var statusFlagSet = flag.NewFlagSet("status", flag.ExitOnError)
+
+// statusThread summarizes a comment thread that is waiting on the user.
+type statusThread struct {
+	Hash        string `json:"hash"`
+	Author      string `json:"author"`
+	Path        string `json:"path,omitempty"`
+	Line        uint32 `json:"line,omitempty"`
+	Description string `json:"description"`
+}
+
+// statusCI holds the latest result reported by a single CI agent for the head of a review.
+type statusCI struct {
+	Agent  string `json:"agent"`
+	Status string `json:"status"`
+	URL    string `json:"url,omitempty"`
+}
+
+// statusResult is the JSON output of the "status" subcommand.
+type statusResult struct {
+	Ref         string         `json:"ref"`
+	Review      string         `json:"review,omitempty"`
+	ShortID     int            `json:"shortId,omitempty"`
+	Status      string         `json:"status,omitempty"`
+	TargetRef   string         `json:"targetRef,omitempty"`
+	Head        string         `json:"head,omitempty"`
+	CI          []statusCI     `json:"ci,omitempty"`
+	Warnings    []string       `json:"warnings,omitempty"`
+	Approvers   []string       `json:"approvers,omitempty"`
+	Stale       []string       `json:"staleApprovers,omitempty"`
+	Awaiting    []string       `json:"awaiting,omitempty"`
+	Rerequested []string       `json:"rerequested,omitempty"`
+	Threads     []statusThread `json:"threads,omitempty"`
+	Blockers    []string       `json:"blockers,omitempty"`
+}
+
+// hasParticipated reports whether the given user wrote any of the comments in the thread.
+func hasParticipated(thread review.CommentThread, user string, identities *identity.Map) bool {
+	if identities.Same(thread.Comment.Author, user) {
+		return true
+	}
+	for _, child := range thread.Children {
+		if hasParticipated(child, user, identities) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastCommentAuthor returns the author of the most recent comment in the thread.
+func lastCommentAuthor(thread review.CommentThread) string {
+	author, latest := thread.Comment.Author, thread.Comment.Timestamp
+	for _, reply := range thread.Replies() {
+		if schema.CompareTimestamps(reply.Comment.Timestamp, latest) >= 0 {
+			author, latest = reply.Comment.Author, reply.Comment.Timestamp
+		}
+	}
+	return author
+}
+
+// latestCommentBy returns the timestamp of the most recent comment by the given user in the threads, or an empty string if there is none.
+func latestCommentBy(threads []review.CommentThread, user string, identities *identity.Map) string {
+	var latest string
+	for _, thread := range threads {
+		if identities.Same(thread.Comment.Author, user) && schema.CompareTimestamps(thread.Comment.Timestamp, latest) > 0 {
+			latest = thread.Comment.Timestamp
+		}
+		if timestamp := latestCommentBy(thread.Children, user, identities); schema.CompareTimestamps(timestamp, latest) > 0 {
+			latest = timestamp
+		}
+	}
+	return latest
+}
+
+// threadsAwaiting returns the open threads of the review that are waiting on the given user.
+//
+// A thread is waiting on the user if someone else wrote its latest comment,
+// and either the user requested the review, or has taken part in the thread.
+func threadsAwaiting(r *review.Review, user string, identities *identity.Map) []statusThread {
+	requester := identities.Same(r.Request.Requester, user)
+	var threads []statusThread
+	for _, thread := range r.Comments {
+		if !thread.IsOpen() || identities.Same(lastCommentAuthor(thread), user) {
+			continue
+		}
+		if !requester && !hasParticipated(thread, user, identities) {
+			continue
+		}
+		t := statusThread{
+			Hash:        thread.Hash,
+			Author:      thread.Comment.Author,
+			Description: firstLine(thread.Latest().Description),
+		}
+		if location := thread.Comment.Location; location != nil {
+			t.Path = location.Path
+			if location.Range != nil {
+				t.Line = location.Range.StartLine
+			}
+		}
+		threads = append(threads, t)
+	}
+	return threads
+}
+
+// headCIResults returns the latest result reported by each CI agent for the given commit,
+// along with warnings about any reports that were skipped.
+func headCIResults(repo repository.Repo, commit string) ([]statusCI, []string, error) {
+	reports := ci.ParseAllValid(repo.GetNotes(ci.Ref, commit))
+	latestReports, err := ci.GetLatestCIReportsByAgent(reports)
+	if err != nil {
+		return nil, nil, err
+	}
+	var results []statusCI
+	for agent, report := range latestReports {
+		results = append(results, statusCI{Agent: agent, Status: report.Status, URL: report.URL})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Agent < results[j].Agent })
+	return results, ci.SkippedReportWarnings(reports), nil
+}
+
+// buildStatus summarizes the given review from the point of view of the given user.
+func buildStatus(repo repository.Repo, r *review.Review, user string) (*statusResult, error) {
+	identities, err := identity.Load(repo, r.Request.TargetRef)
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	result := &statusResult{
+		Ref:       r.Request.ReviewRef,
+		Review:    r.Revision,
+		Status:    search.Status(r.Summary),
+		TargetRef: r.Request.TargetRef,
+		Head:      head,
+		Threads:   threadsAwaiting(r, user, identities),
+	}
+	if result.CI, result.Warnings, err = headCIResults(repo, head); err != nil {
+		return nil, err
+	}
+	levels, err := policy.LoadLevels(repo)
+	if err != nil {
+		return nil, err
+	}
+	stale, err := policy.LoadStale(r)
+	if err != nil {
+		return nil, err
+	}
+	approvers := policy.ApproversAtLevels(r, identities, levels, stale)
+	for approver := range approvers {
+		result.Approvers = append(result.Approvers, approver)
+	}
+	sort.Strings(result.Approvers)
+	for approver := range policy.ApproversAtLevels(r, identities, levels, nil) {
+		if !approvers[approver] {
+			result.Stale = append(result.Stale, approver)
+		}
+	}
+	sort.Strings(result.Stale)
+	for _, reviewer := range r.Request.Reviewers {
+		if !approvers[identities.Resolve(reviewer)] && !identities.Same(reviewer, r.Request.Requester) {
+			result.Awaiting = append(result.Awaiting, reviewer)
+		}
+	}
+	for reviewer, timestamp := range event.LatestRerequests(r.Events) {
+		if schema.CompareTimestamps(latestCommentBy(r.Comments, reviewer, identities), timestamp) < 0 {
+			result.Rerequested = append(result.Rerequested, reviewer)
+		}
+	}
+	sort.Strings(result.Rerequested)
+	if !r.Submitted {
+		if result.Blockers, err = submitBlockers(repo, r, false); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// printStatus prints a human readable summary of the review status.
+func printStatus(result *statusResult) {
+	id := ""
+	if result.ShortID != 0 {
+		id = fmt.Sprintf("#%d ", result.ShortID)
+	}
+	fmt.Printf("Review %s%.12s [%s]: %s -> %s\n", id, result.Review, result.Status, result.Ref, result.TargetRef)
+	if len(result.CI) == 0 {
+		fmt.Printf("CI for %.12s: no results reported\n", result.Head)
+	} else {
+		fmt.Printf("CI for %.12s:\n", result.Head)
+		for _, c := range result.CI {
+			agent := c.Agent
+			if agent == "" {
+				agent = "unknown agent"
+			}
+			fmt.Printf("  %s: %s %s\n", agent, c.Status, c.URL)
+		}
+	}
+	for _, warning := range result.Warnings {
+		fmt.Printf("  warning: %s\n", warning)
+	}
+	if len(result.Approvers) > 0 {
+		fmt.Printf("Approved by: %s\n", strings.Join(result.Approvers, ", "))
+	}
+	if len(result.Stale) > 0 {
+		fmt.Printf("Stale approvals of an earlier revision from: %s\n", strings.Join(result.Stale, ", "))
+	}
+	if len(result.Awaiting) > 0 {
+		fmt.Printf("Awaiting approval from: %s\n", strings.Join(result.Awaiting, ", "))
+	}
+	if len(result.Rerequested) > 0 {
+		fmt.Printf("Requested again from: %s\n", strings.Join(result.Rerequested, ", "))
+	}
+	if len(result.Threads) > 0 {
+		fmt.Printf("Open threads waiting on you (%d):\n", len(result.Threads))
+		for _, t := range result.Threads {
+			location := ""
+			if t.Path != "" {
+				location = fmt.Sprintf(" on %s", t.Path)
+				if t.Line != 0 {
+					location += fmt.Sprintf(":%d", t.Line)
+				}
+			}
+			fmt.Printf("  %.12s by %s%s: %s\n", t.Hash, t.Author, location, t.Description)
+		}
+	}
+	switch {
+	case result.Status == "submitted":
+	case len(result.Blockers) == 0:
+		fmt.Println("Ready to submit.")
+	default:
+		fmt.Println("Blocking submit:")
+		for _, blocker := range result.Blockers {
+			fmt.Printf("  %s\n", blocker)
+		}
+	}
+}
+
+// showStatus summarizes the review of the current branch, or of the given review.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func showStatus(repo repository.Repo, args []string) error {
+	statusFlagSet.Parse(args)
+	args = statusFlagSet.Args()
+
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return errors.New("Only showing the status of a single review is supported.")
+	}
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		ref, err := repo.GetHeadRef()
+		if err != nil {
+			return err
+		}
+		if JSONOutput {
+			return output.PrintJSONResult("status", statusResult{Ref: ref})
+		}
+		fmt.Printf("There is no open review for %s.\n", ref)
+		return nil
+	}
+
+	user, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	result, err := buildStatus(repo, r, user)
+	if err != nil {
+		return err
+	}
+	if shortIDs, err := loadShortIDs(repo); err == nil {
+		result.ShortID = shortIDs.ID(r.Revision)
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("status", result)
+	}
+	printStatus(result)
+	return nil
+}
+
+// statusCmd defines the "status" subcommand.
+var statusCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s status [<review-hash>]\n\n", arg0)
+		fmt.Printf("Summarizes the review of the current branch: the CI results for its head,\n")
+		fmt.Printf("its approvals, the open threads waiting on you, and what blocks submitting it.\n")
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return showStatus(repo, args)
+	},
+}
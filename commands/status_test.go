@@ -0,0 +1,77 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"testing"
+)
+
+func TestThreadsAwaiting(t *testing.T) {
+	open, resolved := false, true
+	r := &review.Review{Summary: &review.Summary{
+		Request: request.Request{Requester: "alice@example.com"},
+		Comments: []review.CommentThread{
+			{
+				Hash:     "answered",
+				Comment:  comment.Comment{Author: "bob@example.com", Timestamp: "1", Description: "Why?"},
+				Resolved: &open,
+				Children: []review.CommentThread{{Comment: comment.Comment{Author: "alice@example.com", Timestamp: "2"}}},
+			},
+			{
+				Hash:     "waiting",
+				Comment:  comment.Comment{Author: "bob@example.com", Timestamp: "3", Description: "Rename this.\nIt is unclear."},
+				Resolved: &open,
+			},
+			{
+				Hash:     "resolved",
+				Comment:  comment.Comment{Author: "carol@example.com", Timestamp: "4"},
+				Resolved: &resolved,
+			},
+		},
+	}}
+	threads := threadsAwaiting(r, "alice@example.com", nil)
+	if len(threads) != 1 || threads[0].Hash != "waiting" || threads[0].Description != "Rename this." {
+		t.Fatalf("Unexpected threads waiting on the requester: %v", threads)
+	}
+	threads = threadsAwaiting(r, "carol@example.com", nil)
+	if len(threads) != 0 {
+		t.Fatalf("Unexpected threads waiting on a reviewer who has not taken part in them: %v", threads)
+	}
+	threads = threadsAwaiting(r, "bob@example.com", nil)
+	if len(threads) != 1 || threads[0].Hash != "answered" {
+		t.Fatalf("Unexpected threads waiting on the reviewer: %v", threads)
+	}
+}
+
+func TestLatestCommentBy(t *testing.T) {
+	threads := []review.CommentThread{
+		{
+			Comment:  comment.Comment{Author: "bob@example.com", Timestamp: "0000000001"},
+			Children: []review.CommentThread{{Comment: comment.Comment{Author: "bob@example.com", Timestamp: "0000000003"}}},
+		},
+		{Comment: comment.Comment{Author: "carol@example.com", Timestamp: "0000000004"}},
+	}
+	if latest := latestCommentBy(threads, "bob@example.com", nil); latest != "0000000003" {
+		t.Fatalf("Unexpected latest comment by a reviewer: %q", latest)
+	}
+	if latest := latestCommentBy(threads, "dave@example.com", nil); latest != "" {
+		t.Fatalf("Unexpected latest comment by someone who has not commented: %q", latest)
+	}
+}
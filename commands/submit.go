@@ -20,18 +20,33 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"github.com/promet/git-appraise/commands/output"
 	"github.com/promet/git-appraise/repository"
 	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/checklist"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/policy"
+	"github.com/promet/git-appraise/review/template"
+	"sort"
+	"strings"
 )
 
+// submitResult is the JSON output of the "submit" subcommand.
+type submitResult struct {
+	Review    string `json:"review"`
+	TargetRef string `json:"targetRef"`
+	Commit    string `json:"commit"`
+}
+
 var submitFlagSet = flag.NewFlagSet("submit", flag.ExitOnError)
 
 var (
 	submitMerge       = submitFlagSet.Bool("merge", false, "Create a merge of the source and target refs.")
 	submitRebase      = submitFlagSet.Bool("rebase", false, "Rebase the source ref onto the target ref.")
+	submitSquash      = submitFlagSet.Bool("squash", false, "Squash the source ref into a single commit on the target ref, using the review description as the commit message.")
 	submitFastForward = submitFlagSet.Bool("fast-forward", false, "Create a merge using the default fast-forward mode.")
-	submitTBR         = submitFlagSet.Bool("tbr", false, "(To be reviewed) Force the submission of a review that has not been accepted.")
-	submitArchive     = submitFlagSet.Bool("archive", true, "Prevent the original commit from being garbage collected; only affects rebased submits.")
+	submitTBR         = submitFlagSet.Bool("tbr", false, "(To be reviewed) Force the submission of a review that has not been accepted, or whose build is failing. This does not bypass the approval policy.")
+	submitArchive     = submitFlagSet.Bool("archive", true, "Prevent the original commit from being garbage collected; only affects rebased and squashed submits.")
 )
 
 // Submit the current code review request.
@@ -41,8 +56,14 @@ func submitReview(repo repository.Repo, args []string) error {
 	submitFlagSet.Parse(args)
 	args = submitFlagSet.Args()
 
-	if *submitMerge && *submitRebase {
-		return errors.New("Only one of --merge or --rebase is allowed.")
+	strategies := 0
+	for _, selected := range []bool{*submitMerge, *submitRebase, *submitSquash} {
+		if selected {
+			strategies++
+		}
+	}
+	if strategies > 1 {
+		return errors.New("Only one of --merge, --rebase, or --squash is allowed.")
 	}
 
 	var r *review.Review
@@ -51,24 +72,32 @@ func submitReview(repo repository.Repo, args []string) error {
 		return errors.New("Only accepting a single review is supported.")
 	}
 	if len(args) == 1 {
-		r, err = review.Get(repo, args[0])
+		r, err = getReview(repo, args[0])
 	} else {
 		r, err = review.GetCurrent(repo)
 	}
 
 	if err != nil {
-		return fmt.Errorf("Failed to load the review: %v\n", err)
+		return fmt.Errorf("Failed to load the review: %w\n", err)
 	}
 	if r == nil {
-		return errors.New("There is no matching review.")
+		return review.ErrReviewNotFound
 	}
 
 	if r.Submitted {
 		return errors.New("The review has already been submitted.")
 	}
 
-	if !*submitTBR && (r.Resolved == nil || !*r.Resolved) {
-		return errors.New("Not submitting as the review has not yet been accepted.")
+	warnSkippedCIReports(r.Reports)
+	blockers, err := submitBlockers(repo, r, *submitTBR)
+	if err != nil {
+		return err
+	}
+	if len(blockers) == 1 {
+		return fmt.Errorf("Not submitting as %s.", blockers[0])
+	}
+	if len(blockers) > 1 {
+		return fmt.Errorf("Not submitting as:\n  %s", strings.Join(blockers, "\n  "))
 	}
 
 	target := r.Request.TargetRef
@@ -80,34 +109,237 @@ func submitReview(repo repository.Repo, args []string) error {
 		return err
 	}
 
-	isAncestor, err := repo.IsAncestor(target, source)
+	if !(*submitRebase || *submitMerge || *submitSquash || *submitFastForward) {
+		submitStrategy, err := repo.GetSubmitStrategy()
+		if err != nil {
+			return err
+		}
+		switch submitStrategy {
+		case "merge":
+			*submitMerge = true
+		case "rebase":
+			*submitRebase = true
+		case "squash":
+			*submitSquash = true
+		case "fast-forward":
+			*submitFastForward = true
+		}
+	}
+
+	if *submitSquash {
+		err = r.Squash(buildSquashMessage(r), *submitArchive)
+	} else {
+		err = mergeReview(r, source)
+	}
+	if err != nil || !JSONOutput {
+		return err
+	}
+	submitted, err := repo.GetCommitHash(target)
 	if err != nil {
 		return err
 	}
+	return output.PrintJSONResult("submit", submitResult{Review: r.Revision, TargetRef: target, Commit: submitted})
+}
+
+// submitBlockers returns the reasons why the given review cannot be submitted yet.
+//
+// If tbr is set, then the checks that a TBR ("to be reviewed") submission
+// bypasses are skipped; the approval policy, description template, checklist,
+// and dependencies still apply.
+func submitBlockers(repo repository.Repo, r *review.Review, tbr bool) ([]string, error) {
+	blockers, err := reviewBlockers(repo, r, tbr)
+	if err != nil {
+		return nil, err
+	}
+	if isFixedTarget(r.Request.TargetRef) {
+		blockers = append(blockers, fmt.Sprintf("the review targets %q, which is not a branch, and so cannot be updated", r.Request.TargetRef))
+		return blockers, nil
+	}
+	source, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	isAncestor, err := repo.IsAncestor(r.Request.TargetRef, source)
+	if err != nil {
+		return nil, err
+	}
 	if !isAncestor {
-		return errors.New("Refusing to submit a non-fast-forward review. First merge the target ref.")
+		// Check for conflicts up front, so that the guidance covers resolving them.
+		conflicts, err := r.GetMergeConflicts()
+		if err == nil && len(conflicts) > 0 {
+			blockers = append(blockers, fmt.Sprintf("the review conflicts with its target ref in: %s; merge the target ref into the review branch (or rebase it with \"git appraise rebase\"), resolve the conflicts, and update the review", strings.Join(conflicts, ", ")))
+		} else {
+			blockers = append(blockers, "the review is not a fast-forward of its target ref, which must be merged into it first")
+		}
 	}
+	return blockers, nil
+}
 
-	if !(*submitRebase || *submitMerge || *submitFastForward) {
-		submitStrategy, err := repo.GetSubmitStrategy()
+// reviewBlockers returns the reasons why the given review cannot be submitted yet, other than it being out of date with its target ref.
+//
+// The tbr parameter is the same as for submitBlockers.
+func reviewBlockers(repo repository.Repo, r *review.Review, tbr bool) ([]string, error) {
+	var blockers []string
+	if r.IsDraft() {
+		blockers = append(blockers, "the review is still a draft, and must be published first")
+	}
+
+	if r.Request.DependsOn != "" {
+		dependency, err := review.GetSummary(repo, r.Request.DependsOn)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if submitStrategy == "merge" && !*submitRebase && !*submitFastForward {
-			*submitMerge = true
+		if dependency == nil || !dependency.Submitted {
+			blockers = append(blockers, fmt.Sprintf("the review depends on review %.12s, which has not yet been submitted", r.Request.DependsOn))
 		}
-		if submitStrategy == "rebase" && !*submitMerge && !*submitFastForward {
-			*submitRebase = true
+	}
+
+	if !tbr {
+		levels, err := policy.LoadLevels(repo)
+		if err != nil {
+			return nil, err
 		}
-		if submitStrategy == "fast-forward" && !*submitRebase && !*submitMerge {
-			*submitFastForward = true
+		stale, err := policy.LoadStale(r)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case r.Resolved == nil || !*r.Resolved:
+			blockers = append(blockers, "the review has not yet been accepted")
+		case hasApprovalAtLevels(r, levels, stale):
+		case hasApprovalAtLevels(r, levels, nil):
+			blockers = append(blockers, "the review has only been accepted at an earlier revision; it must be accepted again, or the approvals carried forward with \"git appraise carry-forward\"")
+		default:
+			blockers = append(blockers, fmt.Sprintf("the review has not yet been accepted at one of the levels: %s", strings.Join(levels, ", ")))
+		}
+	}
+
+	// The approval policy is enforced even for TBR submissions, since it
+	// records the approvals required by the owners of the target ref.
+	requirements, err := policy.Check(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, requirement := range requirements {
+		blockers = append(blockers, fmt.Sprintf("the review policy requires an approval from an owner of %s (one of: %s)", requirement.Path, strings.Join(requirement.Owners, ", ")))
+	}
+
+	// Like the approval policy, the description template is enforced even for TBR submissions.
+	t, err := template.Load(repo, r.Request.TargetRef)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		if missing := t.Missing(r.Request.Description); len(missing) > 0 {
+			blockers = append(blockers, fmt.Sprintf("the review description is missing the required field(s): %s", strings.Join(missing, ", ")))
+		}
+	}
+
+	// So is the checklist, whose required items a TBR submission is no excuse to skip.
+	c, err := checklist.Load(repo, r.Request.TargetRef)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		if unchecked := c.Unchecked(checklist.ParseAllValid(repo.GetNotes(checklist.Ref, r.Revision))); len(unchecked) > 0 {
+			blockers = append(blockers, fmt.Sprintf("the required checklist item(s) have not been checked off: %s; check them off with \"git appraise checklist\"", strings.Join(unchecked, ", ")))
+		}
+	}
+
+	if !tbr {
+		failingAgents, err := r.GetFailingCIAgents()
+		if err != nil {
+			return nil, err
+		}
+		for i, agent := range failingAgents {
+			if agent == "" {
+				failingAgents[i] = "unknown agent"
+			}
+		}
+		if len(failingAgents) > 0 {
+			blockers = append(blockers, fmt.Sprintf("the latest build failed for the CI agent(s): %s", strings.Join(failingAgents, ", ")))
+		}
+		coverage, err := checkCoverageThreshold(repo, r)
+		if err != nil {
+			return nil, err
+		}
+		if coverage != "" {
+			blockers = append(blockers, coverage)
 		}
 	}
+	return blockers, nil
+}
+
+// checkCoverageThreshold returns the reason the review cannot be submitted, if
+// the repository requires a minimum code coverage, and the coverage most
+// recently reported for the review is either missing or below that minimum.
+func checkCoverageThreshold(repo repository.Repo, r *review.Review) (string, error) {
+	threshold, err := repo.GetCoverageThreshold()
+	if err != nil || threshold <= 0 {
+		return "", err
+	}
+	coverage, _, err := r.GetCoverage()
+	if err != nil {
+		return "", err
+	}
+	if coverage == nil {
+		return fmt.Sprintf("no code coverage has been reported, and at least %.1f%% is required", threshold), nil
+	}
+	if coverage.Percent < threshold {
+		return fmt.Sprintf("the code coverage is %.1f%%, which is below the required %.1f%%", coverage.Percent, threshold), nil
+	}
+	return "", nil
+}
 
+// hasApprovalAtLevels reports whether anyone's latest resolution of the review, including its requester's,
+// was an approval at one of the given levels, other than one of the given stale approvals.
+func hasApprovalAtLevels(r *review.Review, levels []string, stale map[string]bool) bool {
+	identities, _ := identity.Load(r.Repo, r.Request.TargetRef)
+	for _, level := range policy.LatestLevels(r, identities, stale) {
+		for _, l := range levels {
+			if level != "" && level == l {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buildSquashMessage returns the commit message for a review that is submitted as a single commit.
+//
+// The message is the review description, followed by trailers that link the
+// commit back to the review and record who approved it.
+func buildSquashMessage(r *review.Review) string {
+	message := strings.TrimSpace(r.Request.Description)
+	message += fmt.Sprintf("\n\nReview: %s", r.Revision)
+	// Read the identities from the target, like the policy, so that each approver is only listed once.
+	identities, _ := identity.Load(r.Repo, r.Request.TargetRef)
+	var approvers []string
+	levels, err := policy.LoadLevels(r.Repo)
+	if err != nil {
+		levels = policy.DefaultLevels
+	}
+	// Stale approvals are left out, since they did not review what is being submitted.
+	stale, _ := policy.LoadStale(r)
+	for approver := range policy.ApproversAtLevels(r, identities, levels, stale) {
+		approvers = append(approvers, approver)
+	}
+	sort.Strings(approvers)
+	for _, approver := range approvers {
+		message += fmt.Sprintf("\nReviewed-by: %s", approver)
+	}
+	return message
+}
+
+// mergeReview merges the given head of a review into its target ref, after first rebasing it if requested.
+func mergeReview(r *review.Review, source string) error {
+	repo := r.Repo
+	target := r.Request.TargetRef
 	if *submitRebase {
 		if err := r.Rebase(*submitArchive); err != nil {
 			return err
 		}
+		var err error
 		source, err = r.GetHeadCommit()
 		if err != nil {
 			return err
@@ -120,9 +352,8 @@ func submitReview(repo repository.Repo, args []string) error {
 	if *submitMerge {
 		submitMessage := fmt.Sprintf("Submitting review %.12s", r.Revision)
 		return repo.MergeRef(source, false, submitMessage, r.Request.Description)
-	} else {
-		return repo.MergeRef(source, true)
 	}
+	return repo.MergeRef(source, true)
 }
 
 // submitCmd defines the "submit" subcommand.
@@ -134,4 +365,5 @@ var submitCmd = &Command{
 	RunMethod: func(repo repository.Repo, args []string) error {
 		return submitReview(repo, args)
 	},
+	NeedsWorktree: true,
 }
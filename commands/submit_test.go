@@ -0,0 +1,96 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"strings"
+	"testing"
+)
+
+// conflictingRepo is a mock repo in which every merge conflicts in the given paths.
+type conflictingRepo struct {
+	repository.Repo
+	conflicts []string
+}
+
+func (repo conflictingRepo) MergeConflicts(ours, theirs string) ([]string, error) {
+	return repo.conflicts, nil
+}
+
+func TestSubmitBlockersMergeConflicts(t *testing.T) {
+	for _, test := range []struct {
+		conflicts []string
+		expected  string
+	}{
+		{nil, "the review is not a fast-forward of its target ref"},
+		{[]string{"a.go", "b.go"}, "the review conflicts with its target ref in: a.go, b.go;"},
+	} {
+		repo := conflictingRepo{Repo: repository.NewMockRepoForTest(), conflicts: test.conflicts}
+		r, err := review.Get(repo, repository.TestCommitG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blockers, err := submitBlockers(repo, r, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, blocker := range blockers {
+			found = found || strings.HasPrefix(blocker, test.expected)
+		}
+		if !found {
+			t.Errorf("Missing the blocker %q for the merge conflicts %q: %q", test.expected, test.conflicts, blockers)
+		}
+	}
+}
+
+func TestSubmitBlockersUnnamedCIAgent(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	note, err := ci.New("", ci.StatusFailure, "").Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(ci.Ref, head, note); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = review.Get(repo, repository.TestCommitG); err != nil {
+		t.Fatal(err)
+	}
+	blockers, err := submitBlockers(repo, r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "the latest build failed for the CI agent(s): unknown agent"
+	found := false
+	for _, blocker := range blockers {
+		found = found || blocker == expected
+	}
+	if !found {
+		t.Errorf("Missing the blocker %q for a CI report without an agent: %q", expected, blockers)
+	}
+}
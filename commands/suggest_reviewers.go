@@ -0,0 +1,137 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/policy"
+	"github.com/promet/git-appraise/review/request"
+	"github.com/promet/git-appraise/review/stats"
+	"strings"
+)
+
+var suggestReviewersFlagSet = flag.NewFlagSet("suggest-reviewers", flag.ExitOnError)
+
+var (
+	suggestReviewersCount = suggestReviewersFlagSet.Int("n", 5, "Maximum number of reviewers to suggest.")
+)
+
+// changeToReview returns the base commit, the touched paths, and the author of the given commit, or of the review for it.
+func changeToReview(repo repository.Repo, commit string) (string, []string, string, error) {
+	if len(request.ParseAllValid(repo.GetNotes(request.Ref, commit))) > 0 {
+		r, err := review.Get(repo, commit)
+		if err != nil {
+			return "", nil, "", fmt.Errorf("Failed to load the review: %w\n", err)
+		}
+		base, err := r.GetBaseCommit()
+		if err != nil {
+			return "", nil, "", err
+		}
+		paths, err := policy.ChangedPaths(r)
+		return base, paths, r.Request.Requester, err
+	}
+
+	base, err := repo.GetLastParent(commit)
+	if err != nil {
+		return "", nil, "", err
+	}
+	details, err := repo.GetCommitDetails(commit)
+	if err != nil {
+		return "", nil, "", err
+	}
+	diff, err := repo.Diff(base, commit, "--name-only")
+	if err != nil {
+		return "", nil, "", err
+	}
+	var paths []string
+	for _, line := range strings.Split(diff, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return base, paths, details.AuthorEmail, nil
+}
+
+// suggestReviewers ranks the people best suited to review the given commit or review.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func suggestReviewers(repo repository.Repo, args []string) error {
+	suggestReviewersFlagSet.Parse(args)
+	args = suggestReviewersFlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("Only suggesting reviewers for a single commit is supported.")
+	}
+	revision := "HEAD"
+	if len(args) == 1 {
+		revision = args[0]
+	} else if current, err := review.GetCurrent(repo); err == nil && current != nil {
+		revision = current.Revision
+	}
+
+	commit, err := repo.GetCommitHash(revision)
+	if err != nil {
+		return fmt.Errorf("Could not find a commit named %q", revision)
+	}
+	base, paths, author, err := changeToReview(repo, commit)
+	if err != nil {
+		return err
+	}
+	var pastReviews []review.Summary
+	for _, r := range review.ListAll(repo) {
+		if r.Revision != commit {
+			pastReviews = append(pastReviews, r)
+		}
+	}
+	identities, err := identity.Load(repo, "HEAD")
+	if err != nil {
+		return err
+	}
+	suggestions := stats.SuggestReviewers(repo, base, paths, pastReviews, []string{author}, identities)
+	if *suggestReviewersCount >= 0 && len(suggestions) > *suggestReviewersCount {
+		suggestions = suggestions[:*suggestReviewersCount]
+	}
+
+	if JSONOutput {
+		return output.PrintJSONResult("suggest-reviewers", suggestions)
+	}
+	if len(suggestions) == 0 {
+		fmt.Println("No reviewers to suggest.")
+		return nil
+	}
+	for _, s := range suggestions {
+		fmt.Printf("%s: score %.2f (last changed %.0f%% of the touched lines, commented on them in %d reviews, %d open reviews)\n",
+			s.Reviewer, s.Score, 100*s.Ownership, s.PastReviews, s.OpenReviews)
+	}
+	return nil
+}
+
+// suggestReviewersCmd defines the "suggest-reviewers" subcommand.
+var suggestReviewersCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s suggest-reviewers [<option>...] [<commit>]\n\nOptions:\n", arg0)
+		suggestReviewersFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return suggestReviewers(repo, args)
+	},
+}
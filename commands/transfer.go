@@ -0,0 +1,126 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/event"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/request"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var transferFlagSet = flag.NewFlagSet("transfer", flag.ExitOnError)
+
+var (
+	transferTo      = transferFlagSet.String("to", "", "The new requester of the review")
+	transferMessage = transferFlagSet.String("m", "", "Message explaining the transfer")
+)
+
+// eventResult is the JSON output of the commands that record an event on a review.
+type eventResult struct {
+	Review string      `json:"review"`
+	Event  event.Event `json:"event"`
+}
+
+// transferReview hands a review over to a new requester.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func transferReview(repo repository.Repo, args []string) error {
+	transferFlagSet.Parse(args)
+	args = transferFlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("Only transferring a single review is supported.")
+	}
+	to := strings.TrimSpace(*transferTo)
+	if to == "" {
+		return errors.New("The --to flag is required.")
+	}
+
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+	if !r.IsOpen() {
+		return errors.New("Only open reviews can be transferred.")
+	}
+	identities, err := identity.Load(repo, r.Request.TargetRef)
+	if err != nil {
+		return err
+	}
+	from := r.Request.Requester
+	if identities.Same(from, to) {
+		return fmt.Errorf("The review is already requested by %s.", from)
+	}
+
+	userEmail, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	r.Request.Requester = to
+	// The new requester is no longer one of the reviewers.
+	r.Request.Reviewers = updateLabels(r.Request.Reviewers, nil, []string{to})
+	r.Request.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	r.Request.Signature = ""
+	note, err := r.Request.Write()
+	if err != nil {
+		return err
+	}
+	if err := repo.AppendNote(request.Ref, r.Revision, note); err != nil {
+		return err
+	}
+	e := event.NewTransfer(userEmail, from, to, head, *transferMessage)
+	if err := r.AddEvent(e); err != nil {
+		return err
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("transfer", eventResult{Review: r.Revision, Event: e})
+	}
+	fmt.Printf("Transferred review %.12s from %s to %s\n", r.Revision, from, to)
+	return nil
+}
+
+// transferCmd defines the "transfer" subcommand.
+var transferCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s transfer --to=<requester> [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		transferFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return transferReview(repo, args)
+	},
+}
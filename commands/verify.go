@@ -0,0 +1,214 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/gpg"
+	"strings"
+)
+
+var verifyFlagSet = flag.NewFlagSet("verify", flag.ExitOnError)
+
+var (
+	verifyRequireSigned = verifyFlagSet.Bool("require-signed", false, "Fail if any of the review metadata is unsigned")
+)
+
+// signable is implemented by the review metadata that can carry a GPG signature.
+type signable interface {
+	Sign(key string) error
+}
+
+// signMetadata signs the given review metadata using the user's configured signing key.
+func signMetadata(repo repository.Repo, metadata signable) error {
+	key, err := repo.GetUserSigningKey()
+	if err != nil {
+		return err
+	}
+	return metadata.Sign(key)
+}
+
+// Verification states reported by the "verify" subcommand.
+const (
+	verificationValid      = "valid"
+	verificationUnsigned   = "unsigned"
+	verificationInvalid    = "invalid"
+	verificationMismatched = "mismatched"
+)
+
+// verificationResult records the outcome of checking the signature of one piece of review metadata.
+type verificationResult struct {
+	Description string      `json:"description"`
+	Author      string      `json:"author,omitempty"`
+	Status      string      `json:"status"`
+	Signer      *gpg.Signer `json:"signer,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+func newVerificationResult(description, author string, signer *gpg.Signer, err error) verificationResult {
+	result := verificationResult{
+		Description: description,
+		Author:      author,
+		Status:      verificationValid,
+		Signer:      signer,
+	}
+	if err == gpg.ErrUnsigned {
+		result.Status = verificationUnsigned
+	} else if err != nil {
+		result.Status = verificationInvalid
+		result.Error = err.Error()
+	} else if author != "" && !signedBy(signer, author) {
+		// Anyone with a key can sign metadata that names someone else as its author.
+		result.Status = verificationMismatched
+		result.Error = fmt.Sprintf("the signer does not match the author %q", author)
+	}
+	return result
+}
+
+// signedBy reports whether the user ID of the given signer is, or contains the email address of, the given author.
+func signedBy(signer *gpg.Signer, author string) bool {
+	return signer != nil && (signer.User == author || strings.Contains(signer.User, "<"+author+">"))
+}
+
+// String returns a single-line summary of the verification result.
+func (result verificationResult) String() string {
+	if result.Status == verificationUnsigned {
+		return fmt.Sprintf("%s: unsigned", result.Description)
+	}
+	if result.Status == verificationInvalid {
+		return fmt.Sprintf("%s: BAD SIGNATURE: %s", result.Description, result.Error)
+	}
+	if result.Status == verificationMismatched {
+		return fmt.Sprintf("%s: BAD SIGNER: signed by %s, but %s", result.Description, result.Signer, result.Error)
+	}
+	return fmt.Sprintf("%s: signed by %s", result.Description, result.Signer)
+}
+
+// verifyResult is the JSON output of the "verify" subcommand.
+type verifyResult struct {
+	Review   string               `json:"review"`
+	Verified bool                 `json:"verified"`
+	Results  []verificationResult `json:"results"`
+}
+
+// verifyThreads checks the signatures of every comment in the given threads, including all replies.
+func verifyThreads(threads []review.CommentThread) []verificationResult {
+	var results []verificationResult
+	for _, thread := range threads {
+		signer, err := thread.Comment.Verify()
+		description := fmt.Sprintf("comment %.12s", thread.Hash)
+		results = append(results, newVerificationResult(description, thread.Comment.Author, signer, err))
+		results = append(results, verifyThreads(thread.Children)...)
+	}
+	return results
+}
+
+// verifyReviewSignatures checks the signatures of the requests, comments, and CI reports in a review.
+func verifyReviewSignatures(r *review.Review) []verificationResult {
+	var results []verificationResult
+	for i, req := range r.AllRequests {
+		signer, err := req.Verify()
+		description := fmt.Sprintf("request %.12s (update %d)", r.Revision, i)
+		results = append(results, newVerificationResult(description, req.Requester, signer, err))
+	}
+	results = append(results, verifyThreads(r.Comments)...)
+	for _, report := range r.Reports {
+		signer, err := report.Verify()
+		description := fmt.Sprintf("ci report from %q at %s", report.Agent, report.Timestamp)
+		results = append(results, newVerificationResult(description, "", signer, err))
+	}
+	return results
+}
+
+// verifyReview checks all of the signatures on a code review.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func verifyReview(repo repository.Repo, args []string) error {
+	verifyFlagSet.Parse(args)
+	args = verifyFlagSet.Args()
+
+	var r *review.Review
+	var err error
+	if len(args) > 1 {
+		return errors.New("Only verifying a single review is supported.")
+	}
+
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+
+	results := verifyReviewSignatures(r)
+	var invalid, mismatched, unsigned int
+	for _, result := range results {
+		if !JSONOutput {
+			fmt.Println(result)
+		}
+		switch result.Status {
+		case verificationUnsigned:
+			unsigned++
+		case verificationInvalid:
+			invalid++
+		case verificationMismatched:
+			mismatched++
+		}
+	}
+	failed := invalid > 0 || mismatched > 0 || (*verifyRequireSigned && unsigned > 0)
+	if JSONOutput {
+		if err := output.PrintJSONResult("verify", verifyResult{Review: r.Revision, Verified: !failed, Results: results}); err != nil {
+			return err
+		}
+		if failed {
+			return ErrReported
+		}
+		return nil
+	}
+	if invalid > 0 {
+		return fmt.Errorf("Found %d invalid signature(s).", invalid)
+	}
+	if mismatched > 0 {
+		return fmt.Errorf("Found %d signature(s) by someone other than the author.", mismatched)
+	}
+	if *verifyRequireSigned && unsigned > 0 {
+		return fmt.Errorf("Found %d unsigned item(s).", unsigned)
+	}
+	return nil
+}
+
+// verifyCmd defines the "verify" subcommand.
+var verifyCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s verify [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		verifyFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return verifyReview(repo, args)
+	},
+}
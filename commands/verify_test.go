@@ -0,0 +1,40 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/review/gpg"
+	"testing"
+)
+
+func TestNewVerificationResult(t *testing.T) {
+	signer := &gpg.Signer{KeyID: "ABCD", User: "Some User <user@example.com>"}
+	if result := newVerificationResult("comment", "user@example.com", signer, nil); result.Status != verificationValid {
+		t.Errorf("Unexpected status of a signature by the author: %+v", result)
+	}
+	if result := newVerificationResult("ci report", "", signer, nil); result.Status != verificationValid {
+		t.Errorf("Unexpected status of a signature of metadata without an author: %+v", result)
+	}
+	for _, author := range []string{"other@example.com", "er@example.com"} {
+		if result := newVerificationResult("comment", author, signer, nil); result.Status != verificationMismatched {
+			t.Errorf("Unexpected status of a signature by someone other than %q: %+v", author, result)
+		}
+	}
+	if result := newVerificationResult("comment", "user@example.com", nil, gpg.ErrUnsigned); result.Status != verificationUnsigned {
+		t.Errorf("Unexpected status of unsigned metadata: %+v", result)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/commands/output"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/viewed"
+	"path/filepath"
+	"strings"
+)
+
+var viewedFlagSet = flag.NewFlagSet("viewed", flag.ExitOnError)
+
+var (
+	viewedUnmark = viewedFlagSet.Bool("unmark", false, "Mark the files or hunks as not viewed instead")
+	viewedList   = viewedFlagSet.Bool("list", false, "List the files and hunks of the review along with whether each has been viewed")
+	viewedFiles  = viewedFlagSet.String("f", "", "Comma-separated list of the files to mark; defaults to every file in the review")
+	viewedHunks  = viewedFlagSet.String("hunk", "", "Comma-separated list of the hashes (or hash prefixes) of individual hunks to mark, as shown by --list")
+)
+
+// viewedFileStatus is the JSON output for a single file when listing the viewed state.
+type viewedFileStatus struct {
+	Path   string           `json:"path"`
+	Hash   string           `json:"hash"`
+	Viewed bool             `json:"viewed"`
+	Hunks  []viewedHunkInfo `json:"hunks,omitempty"`
+}
+
+// viewedHunkInfo is the JSON output for a single hunk when listing the viewed state.
+type viewedHunkInfo struct {
+	Hash   string `json:"hash"`
+	Header string `json:"header"`
+	Viewed bool   `json:"viewed"`
+}
+
+// hunkHeader returns the first line of the given hunk.
+func hunkHeader(hunk viewed.Hunk) string {
+	return strings.SplitN(hunk.Text, "\n", 2)[0]
+}
+
+// listViewed prints the files and hunks of a review, along with whether each has been viewed.
+func listViewed(files []viewed.File, state viewed.State) error {
+	var statuses []viewedFileStatus
+	for _, file := range files {
+		status := viewedFileStatus{Path: file.Path, Hash: file.Hash, Viewed: state.FileViewed(file)}
+		for _, hunk := range file.Hunks {
+			status.Hunks = append(status.Hunks, viewedHunkInfo{
+				Hash:   hunk.Hash,
+				Header: hunkHeader(hunk),
+				Viewed: state.HunkViewed(file, hunk),
+			})
+		}
+		statuses = append(statuses, status)
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("viewed", statuses)
+	}
+	for _, status := range statuses {
+		marker := " "
+		if status.Viewed {
+			marker = "x"
+		}
+		fmt.Printf("[%s] %s\n", marker, status.Path)
+		for _, hunk := range status.Hunks {
+			marker := " "
+			if hunk.Viewed {
+				marker = "x"
+			}
+			fmt.Printf("    [%s] %.12s %s\n", marker, hunk.Hash, hunk.Header)
+		}
+	}
+	return nil
+}
+
+// findHunk returns the file and hunk whose hash starts with the given prefix.
+func findHunk(files []viewed.File, prefix string) (*viewed.File, *viewed.Hunk, error) {
+	var matchFile *viewed.File
+	var matchHunk *viewed.Hunk
+	for i := range files {
+		for j := range files[i].Hunks {
+			if strings.HasPrefix(files[i].Hunks[j].Hash, prefix) {
+				if matchHunk != nil && matchHunk.Hash != files[i].Hunks[j].Hash {
+					return nil, nil, fmt.Errorf("The hunk hash %q is ambiguous.", prefix)
+				}
+				matchFile = &files[i]
+				matchHunk = &files[i].Hunks[j]
+			}
+		}
+	}
+	if matchHunk == nil {
+		return nil, nil, fmt.Errorf("There is no hunk matching %q.", prefix)
+	}
+	return matchFile, matchHunk, nil
+}
+
+// buildViewedMarks returns the marks for the files and hunks selected on the command line.
+//
+// When unmarking a whole file, every hunk within it is unmarked as well, so
+// that no part of the file is left hidden.
+func buildViewedMarks(files []viewed.File, paths, hunks []string, unmark bool) ([]viewed.Mark, error) {
+	var marks []viewed.Mark
+	for _, prefix := range hunks {
+		file, hunk, err := findHunk(files, prefix)
+		if err != nil {
+			return nil, err
+		}
+		marks = append(marks, viewed.New(file.Path, hunk.Hash, true))
+	}
+	if len(hunks) > 0 && len(paths) == 0 {
+		return marks, nil
+	}
+	for _, path := range paths {
+		found := false
+		for _, file := range files {
+			found = found || file.Path == path
+		}
+		if !found {
+			return nil, fmt.Errorf("The file %q is not part of the review.", path)
+		}
+	}
+	for _, file := range files {
+		if len(paths) == 0 || containsValue(paths, file.Path) {
+			marks = append(marks, viewed.New(file.Path, file.Hash, false))
+			if unmark {
+				for _, hunk := range file.Hunks {
+					marks = append(marks, viewed.New(file.Path, hunk.Hash, true))
+				}
+			}
+		}
+	}
+	for i := range marks {
+		marks[i].Unviewed = unmark
+	}
+	return marks, nil
+}
+
+// markViewed marks files (or hunks) of a review as having been viewed.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func markViewed(repo repository.Repo, args []string) error {
+	viewedFlagSet.Parse(args)
+	args = viewedFlagSet.Args()
+
+	if len(args) > 1 {
+		return errors.New("Only marking a single review is supported.")
+	}
+	var r *review.Review
+	var err error
+	if len(args) == 1 {
+		r, err = getReview(repo, args[0])
+	} else {
+		r, err = review.GetCurrent(repo)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load the review: %w\n", err)
+	}
+	if r == nil {
+		return review.ErrReviewNotFound
+	}
+
+	diff, err := r.GetDiff("--no-color")
+	if err != nil {
+		return err
+	}
+	files := viewed.Split(diff)
+	if *viewedList {
+		return listViewed(files, viewed.Load(repo, r.Revision))
+	}
+
+	var paths []string
+	for _, path := range splitValues(*viewedFiles) {
+		// Paths typed on Windows use backslashes, but git always uses forward slashes.
+		paths = append(paths, filepath.ToSlash(path))
+	}
+	marks, err := buildViewedMarks(files, paths, splitValues(*viewedHunks), *viewedUnmark)
+	if err != nil {
+		return err
+	}
+	for _, mark := range marks {
+		note, err := mark.Write()
+		if err != nil {
+			return err
+		}
+		if err := repo.AppendNote(viewed.Ref, r.Revision, note); err != nil {
+			return err
+		}
+	}
+	if JSONOutput {
+		return output.PrintJSONResult("viewed", marks)
+	}
+	return nil
+}
+
+// viewedCmd defines the "viewed" subcommand.
+var viewedCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s viewed [<option>...] [<review-hash>]\n\nOptions:\n", arg0)
+		viewedFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return markViewed(repo, args)
+	},
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/notify"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/identity"
+	"os"
+	"time"
+)
+
+var watchFlagSet = flag.NewFlagSet("watch", flag.ExitOnError)
+
+var (
+	watchInterval = watchFlagSet.Duration("interval", 30*time.Second, "How often to check for updates")
+	watchRemote   = watchFlagSet.String("remote", "", "Remote to pull the reviews from before each check; defaults to the one in appraise.autoSync, or \"origin\" if that exists")
+	watchOffline  = watchFlagSet.Bool("offline", false, "Only watch the local reviews, without pulling from a remote")
+	watchDesktop  = watchFlagSet.Bool("desktop", false, "Show a desktop notification for each update, as well as printing it")
+	watchAll      = watchFlagSet.Bool("all", false, "Watch every open review, not just the ones that you requested or were asked to review")
+)
+
+// isWatched reports whether the given event should be reported to the given user.
+//
+// Users are not told about their own actions, and unless all is set, only
+// about the reviews that they requested or were asked to review.
+func isWatched(event notify.Event, user string, identities *identity.Map, all bool) bool {
+	if identities.Same(event.Author, user) {
+		return false
+	}
+	return all || identities.Same(event.Requester, user) || containsIdentity(identities, event.Reviewers, user)
+}
+
+// watchRemoteName returns the remote to pull the reviews from, or an empty string if they should not be pulled.
+func watchRemoteName(repo repository.Repo) (string, error) {
+	if *watchOffline {
+		return "", nil
+	}
+	if *watchRemote != "" {
+		return *watchRemote, nil
+	}
+	remote, err := repo.GetAutoSyncRemote()
+	if err != nil || remote != "" {
+		return remote, err
+	}
+	if urls, err := repo.GetConfig("remote.origin.url"); err != nil || len(urls) == 0 {
+		return "", err
+	}
+	return "origin", nil
+}
+
+// collectWatchedEvents returns the events on the open reviews that should be reported to the given user.
+func collectWatchedEvents(repo repository.Repo, user string) ([]notify.Event, error) {
+	identities, err := identity.Load(repo, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	reviews := review.ListOpen(repo)
+	var events []notify.Event
+	for _, event := range append(notify.Collect(reviews), notify.CollectCISuccesses(reviews)...) {
+		if isWatched(event, user, identities, *watchAll) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// watchReviews keeps running, and reports every new comment, approval, and CI result on the user's reviews.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func watchReviews(repo repository.Repo, args []string) error {
+	watchFlagSet.Parse(args)
+	if len(watchFlagSet.Args()) > 0 {
+		return errors.New("The watch command does not take any arguments.")
+	}
+	if *watchInterval <= 0 {
+		return errors.New("The watch interval must be positive.")
+	}
+	user, err := repo.GetUserEmail()
+	if err != nil {
+		return err
+	}
+	remote, err := watchRemoteName(repo)
+	if err != nil {
+		return err
+	}
+	sinks := []notify.Sink{notify.WriterSink{Writer: os.Stdout}}
+	if *watchDesktop {
+		sinks = append(sinks, notify.DesktopSink{})
+	}
+
+	// Everything that happened before the watch started is considered to have been seen already.
+	state := notify.NewState()
+	var lastStateHash string
+	for first := true; ; first = false {
+		if remote != "" {
+			if err := repo.PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to pull the reviews from %q: %v\n", remote, err)
+			}
+		}
+		stateHash, err := repo.GetRepoStateHash()
+		if repo.Context().Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if stateHash != lastStateHash {
+			events, err := collectWatchedEvents(repo, user)
+			if err != nil {
+				return err
+			}
+			events = state.Unseen(events)
+			if first {
+				fmt.Printf("Watching for updates to your reviews; press Ctrl-C to stop.\n")
+			} else if err := notify.Dispatch(events, sinks); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			lastStateHash = stateHash
+		}
+		select {
+		case <-time.After(*watchInterval):
+		case <-repo.Context().Done():
+			// Interrupting the watch is the usual way to stop it.
+			return nil
+		}
+	}
+}
+
+// watchCmd defines the "watch" subcommand.
+var watchCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s watch [<option>...]\n\nOptions:\n", arg0)
+		watchFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return watchReviews(repo, args)
+	},
+	NoSync: true,
+}
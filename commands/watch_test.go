@@ -0,0 +1,38 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/promet/git-appraise/notify"
+	"testing"
+)
+
+func TestIsWatched(t *testing.T) {
+	event := notify.Event{Type: notify.EventComment, Requester: "alice", Reviewers: []string{"bob"}, Author: "bob"}
+	if !isWatched(event, "alice", nil, false) {
+		t.Fatal("A comment on the user's review was not watched")
+	}
+	if isWatched(event, "bob", nil, false) {
+		t.Fatal("The user was told about their own comment")
+	}
+	if isWatched(event, "carol", nil, false) {
+		t.Fatal("The user was told about a review that they are not involved in")
+	}
+	if !isWatched(event, "carol", nil, true) {
+		t.Fatal("A comment was not watched when watching every review")
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/web"
+)
+
+var webFlagSet = flag.NewFlagSet("web", flag.ExitOnError)
+
+var (
+	webAddr = webFlagSet.String("addr", "localhost:8080", "Address on which to serve the dashboard")
+)
+
+// serveWeb runs a read-only web dashboard for the reviews in the repo.
+//
+// The "args" parameter is all of the command line arguments that followed the subcommand.
+func serveWeb(repo repository.Repo, args []string) error {
+	webFlagSet.Parse(args)
+	if len(webFlagSet.Args()) > 0 {
+		return errors.New("The web command does not take any arguments.")
+	}
+	fmt.Printf("Serving the review dashboard at http://%s/\n", *webAddr)
+	return listenAndServe(repo, *webAddr, web.New(repo))
+}
+
+// webCmd defines the "web" subcommand.
+var webCmd = &Command{
+	Usage: func(arg0 string) {
+		fmt.Printf("Usage: %s web [<option>...]\n\nOptions:\n", arg0)
+		webFlagSet.PrintDefaults()
+	},
+	RunMethod: func(repo repository.Repo, args []string) error {
+		return serveWeb(repo, args)
+	},
+	NoSync: true,
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// workspaceRepo is one of the repositories that reviews are listed from in workspace mode.
+type workspaceRepo struct {
+	Name string
+	Repo repository.Repo
+}
+
+// findRepoRoot returns the top level directory of the git repo that contains the given path.
+func findRepoRoot(path string) (string, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("No git repo contains %q.", path)
+		}
+		dir = parent
+	}
+}
+
+// discoverRepos returns the paths of the git repos directly inside of the given directory, in alphabetical order.
+func discoverRepos(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// workspacePaths returns the paths of the repos in the workspace of the given repo.
+//
+// These are the ones listed in the multi-valued "appraise.workspace" setting,
+// relative to the top level of the repo, if it is set. Otherwise, they are the
+// repo itself and its sibling repos, or the repos in the current directory
+// when there is no repo.
+func workspacePaths(repo repository.Repo) ([]string, error) {
+	if repo == nil {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		return discoverRepos(cwd)
+	}
+	root, err := findRepoRoot(repo.GetPath())
+	if err != nil {
+		return nil, err
+	}
+	configured, err := getConfigList(repo, "workspace")
+	if err != nil {
+		return nil, err
+	}
+	if len(configured) == 0 {
+		return discoverRepos(filepath.Dir(root))
+	}
+	var paths []string
+	for _, path := range configured {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		paths = append(paths, filepath.Clean(path))
+	}
+	return paths, nil
+}
+
+// openWorkspace opens every repo in the workspace of the given repo.
+//
+// The repos that cannot be opened are skipped with a warning, so that one
+// missing checkout does not hide the reviews in all of the others.
+func openWorkspace(repo repository.Repo) ([]workspaceRepo, error) {
+	paths, err := workspacePaths(repo)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if repo != nil {
+		ctx = repo.Context()
+	}
+	var repos []workspaceRepo
+	for _, path := range paths {
+		gitRepo, err := repository.NewGitRepo(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %q, which is not a git repo: %v\n", path, err)
+			continue
+		}
+		repos = append(repos, workspaceRepo{
+			Name: filepath.Base(path),
+			Repo: gitRepo.WithContext(ctx),
+		})
+	}
+	if len(repos) == 0 {
+		return nil, errors.New("No git repos were found in the workspace.")
+	}
+	return repos, nil
+}
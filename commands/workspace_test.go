@@ -0,0 +1,50 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiscoverRepos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, path := range []string{"frontend/.git", "backend/.git/refs", "docs", "backend/src"} {
+		if err := os.MkdirAll(filepath.Join(dir, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths, err := discoverRepos(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(dir, "backend"), filepath.Join(dir, "frontend")}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Unexpected repos: %v", paths)
+	}
+	root, err := findRepoRoot(filepath.Join(dir, "backend", "src"))
+	if err != nil || root != filepath.Join(dir, "backend") {
+		t.Fatalf("Unexpected repo root: %q, %v", root, err)
+	}
+}
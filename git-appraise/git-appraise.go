@@ -26,21 +26,48 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/promet/git-appraise/commands"
+	"github.com/promet/git-appraise/commands/output"
 	"github.com/promet/git-appraise/repository"
 	"os"
+	"os/signal"
+	"runtime/pprof"
 	"sort"
 	"strings"
+	"syscall"
+	"time"
 )
 
-const usageMessageTemplate = `Usage: %s <command>
+const usageMessageTemplate = `Usage: %s [-json] [-timeout <duration>] [-profile <file>] [-namespace <notes-ref>] <command>
 
 Where <command> is one of:
   %s
 
 For individual command usage, run:
   %s help <command>
+
+The -json flag makes every command report its result in a versioned JSON format.
+
+The -timeout flag abandons the command, and any git commands that it is running,
+once the given duration (such as "30s" or "5m") has passed. Interrupting the
+command with Ctrl-C abandons it in the same way.
+
+The -profile flag writes a CPU profile of the command to the given file, for
+"go tool pprof", and reports how many git commands it ran and how long it took.
+
+The -namespace flag keeps the reviews under the given notes refs (such as
+"refs/notes/devtools/teamA"), rather than under "refs/notes/pullrequests", in
+place of the appraise.notesNamespace setting.
+
+Failed commands exit with one of the following codes:
+  1  a failure without a more specific code
+  2  an invalid command line
+  3  there is no matching review
+  4  there are no notes refs to work on, such as when pushing before any review exists
+  5  the data is stored in a format version that is not supported
 `
 
 func usage() {
@@ -67,7 +94,129 @@ func help() {
 	subcommand.Usage(os.Args[0])
 }
 
+// timeout is the duration after which the command is abandoned, or zero for no limit.
+var timeout time.Duration
+
+// profileFile is the file to which a CPU profile of the command is written, or empty for none.
+var profileFile string
+
+// stopProfiling stops writing the CPU profile started by startProfiling.
+var stopProfiling = func() {}
+
+// parseGlobalFlags removes any flags that apply to every command from the command line arguments.
+func parseGlobalFlags() error {
+	for len(os.Args) > 1 && strings.HasPrefix(os.Args[1], "-") {
+		switch strings.TrimPrefix(strings.TrimPrefix(os.Args[1], "-"), "-") {
+		case "json":
+			commands.JSONOutput = true
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		case "timeout":
+			if len(os.Args) < 3 {
+				return fmt.Errorf("The %s flag requires a duration.", os.Args[1])
+			}
+			d, err := time.ParseDuration(os.Args[2])
+			if err != nil || d <= 0 {
+				return fmt.Errorf("Invalid timeout %q.", os.Args[2])
+			}
+			timeout = d
+			os.Args = append(os.Args[:1], os.Args[3:]...)
+		case "profile":
+			if len(os.Args) < 3 {
+				return fmt.Errorf("The %s flag requires a file.", os.Args[1])
+			}
+			profileFile = os.Args[2]
+			os.Args = append(os.Args[:1], os.Args[3:]...)
+		case "namespace":
+			if len(os.Args) < 3 {
+				return fmt.Errorf("The %s flag requires a notes ref.", os.Args[1])
+			}
+			commands.NotesNamespace = os.Args[2]
+			os.Args = append(os.Args[:1], os.Args[3:]...)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// startProfiling starts writing a CPU profile to the profile file, if one was given.
+//
+// Stopping it also reports the number of git commands that were run, since
+// the time spent waiting for those does not show up in the profile itself.
+func startProfiling() error {
+	if profileFile == "" {
+		return nil
+	}
+	f, err := os.Create(profileFile)
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	start := time.Now()
+	stopProfiling = func() {
+		pprof.StopCPUProfile()
+		f.Close()
+		fmt.Fprintf(os.Stderr, "Ran %d git commands in %s; wrote the CPU profile to %q.\n",
+			repository.GitCommandCount(), time.Since(start).Round(time.Millisecond), profileFile)
+	}
+	return nil
+}
+
+// exit stops any profiling, and then exits with the given code.
+func exit(code int) {
+	stopProfiling()
+	os.Exit(code)
+}
+
+// commandContext returns the context that bounds the command: it is cancelled
+// by an interrupt, or once the timeout passes.
+//
+// Only the first interrupt cancels the context; a second one kills the tool as usual.
+func commandContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return ctx, stop
+}
+
+// describeError replaces the errors of an abandoned command with a description of why it was abandoned.
+func describeError(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("Timed out after %s.", timeout)
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("Interrupted.")
+	}
+	return err
+}
+
+// runsWithoutRepo reports whether the command named on the command line can run outside of a git repo.
+func runsWithoutRepo() bool {
+	if len(os.Args) < 2 {
+		return false
+	}
+	subcommand, ok := commands.CommandMap[os.Args[1]]
+	return ok && subcommand.NoRepo
+}
+
 func main() {
+	if err := parseGlobalFlags(); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(commands.ExitUsage)
+	}
+	if err := startProfiling(); err != nil {
+		fmt.Printf("Unable to write the CPU profile: %v\n", err)
+		os.Exit(commands.ExitFailure)
+	}
+	defer stopProfiling()
 	if len(os.Args) > 1 && os.Args[1] == "help" {
 		help()
 		return
@@ -77,10 +226,14 @@ func main() {
 		fmt.Printf("Unable to get the current working directory: %q\n", err)
 		return
 	}
-	repo, err := repository.NewGitRepo(cwd)
-	if err != nil {
+	ctx, cancel := commandContext()
+	defer cancel()
+	var repo repository.Repo
+	if gitRepo, err := repository.NewGitRepo(cwd); err == nil {
+		repo = gitRepo.WithContext(ctx)
+	} else if !runsWithoutRepo() {
 		fmt.Printf("%s must be run from within a git repo.\n", os.Args[0])
-		return
+		exit(commands.ExitFailure)
 	}
 	if len(os.Args) < 2 {
 		subcommand, ok := commands.CommandMap["list"]
@@ -88,17 +241,36 @@ func main() {
 			fmt.Printf("Unable to list reviews")
 			return
 		}
-		subcommand.Run(repo, []string{})
+		if err := subcommand.Run(repo, []string{}); err != nil {
+			if commands.JSONOutput {
+				output.PrintJSONError("list", describeError(err), commands.ExitCode(err))
+			} else {
+				fmt.Println(describeError(err).Error())
+			}
+			exit(commands.ExitCode(err))
+		}
 		return
 	}
 	subcommand, ok := commands.CommandMap[os.Args[1]]
 	if !ok {
 		fmt.Printf("Unknown command: %q\n", os.Args[1])
 		usage()
-		return
+		exit(commands.ExitUsage)
 	}
-	if err := subcommand.Run(repo, os.Args[2:]); err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
+	err = subcommand.Run(repo, os.Args[2:])
+	if err == nil && ctx.Err() == context.DeadlineExceeded {
+		err = ctx.Err()
+	}
+	if err != nil {
+		exitCode := commands.ExitCode(err)
+		err = describeError(err)
+		if commands.JSONOutput {
+			if err != commands.ErrReported {
+				output.PrintJSONError(os.Args[1], err, exitCode)
+			}
+		} else {
+			fmt.Println(err.Error())
+		}
+		exit(exitCode)
 	}
 }
@@ -0,0 +1,409 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package email supports mailing-list based reviews, by exporting a review
+// as a series of patch emails and importing the replies to those emails.
+//
+// Every exported message is given a Message-ID that identifies the review
+// and the commit or comment that it contains. Replies are matched back to
+// the review using their In-Reply-To and References headers, and inline
+// replies that quote the patch are anchored at the last quoted line.
+package email
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/promet/git-appraise/mirror"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	messageIDDomain = "git-appraise"
+	kindCover       = "cover"
+	kindPatch       = "patch"
+	kindComment     = "comment"
+	// mboxDate is the fixed date used in the "From " separator lines, as git format-patch does.
+	mboxDate = "Mon Sep 17 00:00:00 2001"
+)
+
+var (
+	messageIDPattern = regexp.MustCompile(`<appraise\.([0-9a-f]{40,64})\.(cover|patch|comment)(?:\.([0-9a-f]{40,64}))?@git-appraise>`)
+	hunkPattern      = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+	approvalPattern  = regexp.MustCompile(`(?m)^(Reviewed|Acked)-by: `)
+	rejectionPattern = regexp.MustCompile(`(?m)^Nacked-by: `)
+)
+
+// messageID returns the Message-ID of an exported message.
+func messageID(reviewHash, kind, id string) string {
+	if id == "" {
+		return fmt.Sprintf("<appraise.%s.%s@%s>", reviewHash, kind, messageIDDomain)
+	}
+	return fmt.Sprintf("<appraise.%s.%s.%s@%s>", reviewHash, kind, id, messageIDDomain)
+}
+
+// anchor identifies the part of a review that an exported message contains.
+type anchor struct {
+	Review string
+	Kind   string
+	ID     string
+}
+
+// parseAnchor finds the anchor in the given Message-ID, if it is one generated by the exporter.
+func parseAnchor(id string) *anchor {
+	match := messageIDPattern.FindStringSubmatch(id)
+	if match == nil {
+		return nil
+	}
+	return &anchor{Review: match[1], Kind: match[2], ID: match[3]}
+}
+
+// writeMessage writes a single message in the mbox format.
+func writeMessage(w io.Writer, from string, headers [][2]string, body string) {
+	fmt.Fprintf(w, "From %s %s\n", from, mboxDate)
+	for _, header := range headers {
+		fmt.Fprintf(w, "%s: %s\n", header[0], mime.QEncoding.Encode("utf-8", header[1]))
+	}
+	fmt.Fprintln(w)
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		// Escape lines that would otherwise be mistaken for the start of a new message (mboxrd).
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			line = ">" + line
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w)
+}
+
+// Export writes the given review as an mbox-formatted patch series.
+//
+// The series consists of a cover letter holding the review description,
+// one message per commit in the review, and one reply for each existing
+// comment, so that emailed replies can be threaded under them.
+func Export(w io.Writer, r *review.Review) error {
+	commits, err := r.ListCommits()
+	if err != nil {
+		return err
+	}
+	cover := messageID(r.Revision, kindCover, "")
+	subject := strings.SplitN(r.Request.Description, "\n", 2)[0]
+	coverBody := r.Request.Description + "\n\n" +
+		fmt.Sprintf("Review: %s\nTarget: %s\nRequester: %s\n", r.Revision, r.Request.TargetRef, r.Request.Requester)
+	writeMessage(w, r.Revision, [][2]string{
+		{"From", r.Request.Requester},
+		{"Subject", fmt.Sprintf("[PATCH 0/%d] %s", len(commits), subject)},
+		{"Message-ID", cover},
+	}, coverBody)
+
+	for i, commit := range commits {
+		patch, err := r.Repo.FormatPatch(commit)
+		if err != nil {
+			return err
+		}
+		headers, body := splitPatch(patch)
+		headers = append(headers,
+			[2]string{"Message-ID", messageID(r.Revision, kindPatch, commit)},
+			[2]string{"In-Reply-To", cover},
+			[2]string{"References", cover})
+		for j, header := range headers {
+			if header[0] == "Subject" {
+				headers[j][1] = strings.Replace(header[1], "[PATCH]", fmt.Sprintf("[PATCH %d/%d]", i+1, len(commits)), 1)
+			}
+		}
+		writeMessage(w, commit, headers, body)
+	}
+
+	for _, thread := range mirror.FlattenThreads(r.Comments) {
+		parent := cover
+		if thread.Comment.Parent != "" {
+			parent = messageID(r.Revision, kindComment, thread.Comment.Parent)
+		} else if thread.Comment.Location != nil {
+			for _, commit := range commits {
+				if commit == thread.Comment.Location.Commit {
+					parent = messageID(r.Revision, kindPatch, commit)
+				}
+			}
+		}
+		writeMessage(w, thread.Hash, [][2]string{
+			{"From", thread.Comment.Author},
+			{"Subject", "Re: " + subject},
+			{"Message-ID", messageID(r.Revision, kindComment, thread.Hash)},
+			{"In-Reply-To", parent},
+			{"References", cover + " " + parent},
+		}, mirror.FormatExportedComment(thread))
+	}
+	return nil
+}
+
+// splitPatch separates the headers of a message generated by git format-patch from its body.
+func splitPatch(patch string) ([][2]string, string) {
+	var headers [][2]string
+	lines := strings.Split(patch, "\n")
+	i := 0
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "From ") {
+		i = 1
+	}
+	for ; i < len(lines) && lines[i] != ""; i++ {
+		line := lines[i]
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(headers) > 0 {
+			headers[len(headers)-1][1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 {
+			headers = append(headers, [2]string{parts[0], decodeHeader(strings.TrimSpace(parts[1]))})
+		}
+	}
+	if i < len(lines) {
+		i++
+	}
+	return headers, strings.Join(lines[i:], "\n")
+}
+
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// splitMbox separates an mbox file into its individual messages.
+func splitMbox(r io.Reader) ([]string, error) {
+	var messages []string
+	var current []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "From ") {
+			if current != nil {
+				messages = append(messages, strings.Join(current, "\n"))
+			}
+			current = []string{}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") && strings.HasPrefix(line, ">") {
+			line = line[1:]
+		}
+		current = append(current, line)
+	}
+	if current != nil {
+		messages = append(messages, strings.Join(current, "\n"))
+	}
+	return messages, scanner.Err()
+}
+
+// messageText returns the plain text body of a message, taking the first text part of multipart messages.
+func messageText(msg *mail.Message) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		body, err := ioutil.ReadAll(msg.Body)
+		return string(body), err
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if partType == "" || partType == "text/plain" {
+			body, err := ioutil.ReadAll(part)
+			return string(body), err
+		}
+	}
+}
+
+// inlineComment is a block of reply text, along with the location in the quoted patch that it follows.
+type inlineComment struct {
+	Path string
+	Line uint32
+	Text string
+}
+
+// parseReply splits the body of a reply into the comments it contains.
+//
+// Text that follows quoted lines of a patch is attributed to the last quoted
+// line of that patch. Any other text is returned as a general comment.
+func parseReply(body string) []inlineComment {
+	var comments []inlineComment
+	var path string
+	var line uint32
+	inHunk := false
+	var pending []string
+	pendingPath, pendingLine := "", uint32(0)
+
+	flush := func() {
+		text := strings.TrimSpace(strings.Join(pending, "\n"))
+		if text != "" {
+			comments = append(comments, inlineComment{Path: pendingPath, Line: pendingLine, Text: text})
+		}
+		pending = nil
+	}
+
+	lines := strings.Split(body, "\n")
+	for i, raw := range lines {
+		if raw == "-- " {
+			// Everything after the signature separator is the signature.
+			break
+		}
+		if strings.HasPrefix(raw, ">") {
+			flush()
+			quoted := strings.TrimPrefix(strings.TrimPrefix(raw, ">"), " ")
+			switch {
+			case strings.HasPrefix(quoted, "diff --git "):
+				fields := strings.Fields(quoted)
+				path = strings.TrimPrefix(fields[len(fields)-1], "b/")
+				inHunk = false
+			case strings.HasPrefix(quoted, "+++ "):
+				path = strings.TrimPrefix(strings.TrimPrefix(quoted, "+++ "), "b/")
+				inHunk = false
+			case hunkPattern.MatchString(quoted):
+				start, _ := strconv.ParseUint(hunkPattern.FindStringSubmatch(quoted)[1], 10, 32)
+				line = uint32(start) - 1
+				inHunk = true
+			case inHunk && (strings.HasPrefix(quoted, "+") || strings.HasPrefix(quoted, " ") || quoted == ""):
+				line++
+			}
+			continue
+		}
+		if len(pending) == 0 && strings.TrimSpace(raw) == "" {
+			continue
+		}
+		if len(pending) == 0 {
+			// Skip the attribution line that introduces the quoted text.
+			if strings.HasSuffix(strings.TrimSpace(raw), "wrote:") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], ">") {
+				continue
+			}
+			pendingPath, pendingLine = "", 0
+			if inHunk && path != "" && line > 0 {
+				pendingPath, pendingLine = path, line
+			}
+		}
+		pending = append(pending, raw)
+	}
+	flush()
+	return comments
+}
+
+// ImportResult summarizes the comments imported for a single review.
+type ImportResult struct {
+	Review   string `json:"review"`
+	Imported int    `json:"imported"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Import reads the replies in the given mbox file, and adds them as comments on the corresponding reviews.
+//
+// Messages that are not replies to an exported review, as well as the exported
+// messages themselves, are ignored. Importing the same messages more than once
+// does not add duplicate comments.
+func Import(repo repository.Repo, mbox io.Reader) ([]ImportResult, error) {
+	messages, err := splitMbox(mbox)
+	if err != nil {
+		return nil, err
+	}
+	reviews := make(map[string]*mirror.Review)
+	var order []string
+	for _, rawMessage := range messages {
+		msg, err := mail.ReadMessage(strings.NewReader(rawMessage))
+		if err != nil {
+			continue
+		}
+		if parseAnchor(msg.Header.Get("Message-ID")) != nil {
+			continue
+		}
+		target := parseAnchor(msg.Header.Get("In-Reply-To"))
+		if target == nil {
+			for _, reference := range strings.Fields(msg.Header.Get("References")) {
+				if parsed := parseAnchor(reference); parsed != nil {
+					target = parsed
+				}
+			}
+		}
+		if target == nil {
+			continue
+		}
+		body, err := messageText(msg)
+		if err != nil {
+			return nil, err
+		}
+		author := msg.Header.Get("From")
+		if address, err := mail.ParseAddress(author); err == nil {
+			author = address.Address
+		}
+		timestamp := ""
+		if date, err := msg.Header.Date(); err == nil {
+			timestamp = mirror.Timestamp(date)
+		}
+
+		imported, ok := reviews[target.Review]
+		if !ok {
+			imported = &mirror.Review{Revision: target.Review}
+			reviews[target.Review] = imported
+			order = append(order, target.Review)
+		}
+		for _, inline := range parseReply(body) {
+			c := comment.New(author, inline.Text)
+			c.Timestamp = timestamp
+			switch target.Kind {
+			case kindPatch:
+				c.Location = &comment.Location{Commit: target.ID}
+				if inline.Path != "" {
+					c.Location.Path = inline.Path
+					c.Location.Range = &comment.Range{StartLine: inline.Line}
+				}
+			case kindComment:
+				c.Parent = target.ID
+			}
+			if approvalPattern.MatchString(inline.Text) {
+				accepted := true
+				c.Resolved = &accepted
+			} else if rejectionPattern.MatchString(inline.Text) {
+				rejected := false
+				c.Resolved = &rejected
+			}
+			imported.Comments = append(imported.Comments, c)
+		}
+	}
+
+	var results []ImportResult
+	for _, revision := range order {
+		result := ImportResult{Review: revision}
+		result.Imported, err = mirror.Import(repo, *reviews[revision])
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
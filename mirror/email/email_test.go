@@ -0,0 +1,130 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package email
+
+import (
+	"bytes"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"strings"
+	"testing"
+)
+
+const (
+	reviewHash = "0123456789abcdef0123456789abcdef01234567"
+	commitHash = "89abcdef0123456789abcdef0123456789abcdef"
+)
+
+const inlineReply = `On Mon, Jan 4, 2016, Sam <sam@example.com> wrote:
+> diff --git a/main.go b/main.go
+> --- a/main.go
+> +++ b/main.go
+> @@ -10,3 +10,4 @@ func main() {
+>  	a := 1
+> +	b := 2
+
+This should be a constant.
+
+>  	fmt.Println(a)
+
+Reviewed-by: Alex <alex@example.com>
+-- 
+Alex
+`
+
+func TestParseReply(t *testing.T) {
+	comments := parseReply(inlineReply)
+	if len(comments) != 2 {
+		t.Fatalf("Unexpected comments parsed: %v", comments)
+	}
+	if comments[0].Path != "main.go" || comments[0].Line != 11 || comments[0].Text != "This should be a constant." {
+		t.Fatalf("The inline comment was not anchored at the quoted line: %+v", comments[0])
+	}
+	if comments[1].Path != "main.go" || comments[1].Line != 12 || !approvalPattern.MatchString(comments[1].Text) {
+		t.Fatalf("The trailing comment was not parsed: %+v", comments[1])
+	}
+	general := parseReply("Looks good overall.\n\nThanks")
+	if len(general) != 1 || general[0].Path != "" || general[0].Text != "Looks good overall.\n\nThanks" {
+		t.Fatalf("The general comment was not parsed: %v", general)
+	}
+}
+
+func TestParseAnchor(t *testing.T) {
+	a := parseAnchor(messageID(reviewHash, kindPatch, commitHash))
+	if a == nil || a.Review != reviewHash || a.Kind != kindPatch || a.ID != commitHash {
+		t.Fatalf("Failed to parse a patch message ID: %+v", a)
+	}
+	a = parseAnchor(messageID(reviewHash, kindCover, ""))
+	if a == nil || a.Review != reviewHash || a.Kind != kindCover || a.ID != "" {
+		t.Fatalf("Failed to parse a cover letter message ID: %+v", a)
+	}
+	if parseAnchor("<20160104.1234@example.com>") != nil {
+		t.Fatal("Unexpectedly parsed an unrelated message ID")
+	}
+}
+
+func TestExport(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	r, err := review.Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Export(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := splitMbox(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, err := r.ListCommits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) < len(commits)+1 {
+		t.Fatalf("Unexpected number of exported messages: %d", len(messages))
+	}
+	if !strings.Contains(messages[0], "Subject: [PATCH 0/") || !strings.Contains(messages[0], r.Request.Description) {
+		t.Fatalf("Unexpected cover letter: %q", messages[0])
+	}
+	if !strings.Contains(messages[1], "Subject: [PATCH 1/") || !strings.Contains(messages[1], "In-Reply-To: "+messageID(r.Revision, kindCover, "")) {
+		t.Fatalf("Unexpected patch email: %q", messages[1])
+	}
+}
+
+func TestImportSkipsUnknownReviews(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	mbox := "From sam@example.com Mon Jan  4 00:00:00 2016\n" +
+		"From: Sam <sam@example.com>\n" +
+		"Date: Mon, 4 Jan 2016 00:00:00 +0000\n" +
+		"Message-ID: <reply.1@example.com>\n" +
+		"In-Reply-To: " + messageID(reviewHash, kindCover, "") + "\n" +
+		"Subject: Re: [PATCH 0/1] Fix it\n\n" +
+		"LGTM\n" +
+		"From unrelated@example.com Mon Jan  4 00:00:00 2016\n" +
+		"From: unrelated@example.com\n" +
+		"Message-ID: <reply.2@example.com>\n" +
+		"Subject: Hello\n\n" +
+		"Not a review\n"
+	results, err := Import(repo, strings.NewReader(mbox))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Review != reviewHash || results[0].Imported != 0 || results[0].Error == "" {
+		t.Fatalf("Unexpected import results: %+v", results)
+	}
+}
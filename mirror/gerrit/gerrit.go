@@ -0,0 +1,437 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gerrit mirrors Gerrit changes into git-appraise reviews, and
+// exports git-appraise comments back to the corresponding changes.
+//
+// Each change is anchored at the commit of its first patch set, and every
+// later patch set is recorded as an update to the review request with its
+// commit as the alias. Votes on the Code-Review label become approvals or
+// rejections, and votes on the Verified label become CI reports.
+//
+// The commits of every patch set must be fetched into the local repo before
+// a change can be imported, e.g. by running
+// "git fetch origin '+refs/changes/*:refs/changes/*'".
+package gerrit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/mirror"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TimestampLayout is the layout of the timestamps returned by the Gerrit REST API.
+const TimestampLayout = "2006-01-02 15:04:05.000000000"
+
+// xssiPrefix is prepended by Gerrit to every JSON response.
+const xssiPrefix = ")]}'"
+
+const (
+	codeReviewLabel = "Code-Review"
+	verifiedLabel   = "Verified"
+	// agentPrefix is prepended to the names of the accounts whose Verified votes are imported as CI reports.
+	agentPrefix = "gerrit/"
+)
+
+// Account is a Gerrit user.
+type Account struct {
+	ID       int    `json:"_account_id"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+// String returns the identity used for the account in git-appraise metadata.
+func (a Account) String() string {
+	if a.Email != "" {
+		return a.Email
+	}
+	if a.Username != "" {
+		return a.Username
+	}
+	return a.Name
+}
+
+// Approval is a single vote on a label.
+type Approval struct {
+	Account
+	Value int    `json:"value"`
+	Date  string `json:"date"`
+}
+
+// Label holds all of the votes on one label of a change.
+type Label struct {
+	All []Approval `json:"all"`
+}
+
+// Revision is a single patch set of a change.
+type Revision struct {
+	Number int    `json:"_number"`
+	Ref    string `json:"ref"`
+	Commit string `json:"-"`
+}
+
+// Message is a message posted on a change.
+type Message struct {
+	ID       string  `json:"id"`
+	Author   Account `json:"author"`
+	Date     string  `json:"date"`
+	Message  string  `json:"message"`
+	PatchSet int     `json:"_revision_number"`
+}
+
+// Comment is an inline comment on a file in a patch set.
+type Comment struct {
+	ID        string  `json:"id"`
+	Path      string  `json:"path"`
+	Line      uint32  `json:"line"`
+	Message   string  `json:"message"`
+	Author    Account `json:"author"`
+	Updated   string  `json:"updated"`
+	PatchSet  int     `json:"patch_set"`
+	InReplyTo string  `json:"in_reply_to"`
+}
+
+// Change is a Gerrit change, including every one of its patch sets.
+type Change struct {
+	ID              string              `json:"id"`
+	Number          int                 `json:"_number"`
+	Project         string              `json:"project"`
+	Branch          string              `json:"branch"`
+	Subject         string              `json:"subject"`
+	Status          string              `json:"status"`
+	Created         string              `json:"created"`
+	Updated         string              `json:"updated"`
+	Owner           Account             `json:"owner"`
+	CurrentRevision string              `json:"current_revision"`
+	Revisions       map[string]Revision `json:"revisions"`
+	Labels          map[string]Label    `json:"labels"`
+	Messages        []Message           `json:"messages"`
+	MoreChanges     bool                `json:"_more_changes"`
+}
+
+// PatchSets returns the patch sets of the change, in order.
+func (change Change) PatchSets() []Revision {
+	var patchSets []Revision
+	for commit, revision := range change.Revisions {
+		revision.Commit = commit
+		patchSets = append(patchSets, revision)
+	}
+	sort.Sort(byPatchSetNumber(patchSets))
+	return patchSets
+}
+
+type byPatchSetNumber []Revision
+
+func (revisions byPatchSetNumber) Len() int { return len(revisions) }
+func (revisions byPatchSetNumber) Swap(i, j int) {
+	revisions[i], revisions[j] = revisions[j], revisions[i]
+}
+func (revisions byPatchSetNumber) Less(i, j int) bool {
+	return revisions[i].Number < revisions[j].Number
+}
+
+// ChangeData is all of the data about a single change needed to mirror it.
+type ChangeData struct {
+	Change Change
+	// Comments maps file paths to the inline comments on those files.
+	Comments map[string][]Comment
+}
+
+// Client is a minimal client for the Gerrit REST API.
+//
+// If a username is provided, then requests are made to the authenticated
+// endpoints using HTTP basic authentication.
+type Client struct {
+	BaseURL    string
+	Username   string
+	Password   string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a new client for the Gerrit server at the given URL.
+func NewClient(baseURL, username, password string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Username:   username,
+		Password:   password,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+func (c *Client) do(method, path string, body interface{}, result interface{}) error {
+	if c.Username != "" {
+		path = "/a" + path
+	}
+	var bodyBytes []byte
+	if body != nil {
+		var err error
+		bodyBytes, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Gerrit request %s %s failed with %s: %s", method, path, resp.Status, strings.TrimSpace(string(contents)))
+	}
+	if result == nil {
+		return nil
+	}
+	contents = bytes.TrimPrefix(bytes.TrimSpace(contents), []byte(xssiPrefix))
+	return json.Unmarshal(contents, result)
+}
+
+// ListChanges returns every change, open or closed, in the given Gerrit project.
+func (c *Client) ListChanges(project string) ([]Change, error) {
+	var changes []Change
+	for {
+		query := url.Values{}
+		query.Set("q", "project:"+project)
+		query.Set("S", strconv.Itoa(len(changes)))
+		query.Add("o", "ALL_REVISIONS")
+		query.Add("o", "DETAILED_LABELS")
+		query.Add("o", "DETAILED_ACCOUNTS")
+		query.Add("o", "MESSAGES")
+		var page []Change
+		if err := c.do("GET", "/changes/?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		changes = append(changes, page...)
+		if len(page) == 0 || !page[len(page)-1].MoreChanges {
+			return changes, nil
+		}
+	}
+}
+
+// GetChangeData reads everything about the given change that is needed to mirror it.
+func (c *Client) GetChangeData(change Change) (*ChangeData, error) {
+	data := &ChangeData{Change: change}
+	if err := c.do("GET", fmt.Sprintf("/changes/%s/comments", url.PathEscape(change.ID)), nil, &data.Comments); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// PostMessage adds a message to the current patch set of the given change.
+func (c *Client) PostMessage(change Change, message string) error {
+	path := fmt.Sprintf("/changes/%s/revisions/current/review", url.PathEscape(change.ID))
+	return c.do("POST", path, map[string]string{"message": message}, nil)
+}
+
+// timestamp converts a Gerrit timestamp into the format used in git-appraise notes.
+func timestamp(value string) string {
+	converted, err := mirror.ParseTimestamp(TimestampLayout, value)
+	if err != nil {
+		return ""
+	}
+	return converted
+}
+
+type commentsByTime []Comment
+
+func (comments commentsByTime) Len() int      { return len(comments) }
+func (comments commentsByTime) Swap(i, j int) { comments[i], comments[j] = comments[j], comments[i] }
+func (comments commentsByTime) Less(i, j int) bool {
+	return comments[i].Updated < comments[j].Updated
+}
+
+// Convert translates a change into the corresponding git-appraise review.
+//
+// Messages and comments that were originally exported from git-appraise are
+// skipped, and the hashes of the comments they were exported from are
+// returned instead.
+func Convert(data *ChangeData) (mirror.Review, map[string]bool) {
+	change := data.Change
+	exported := make(map[string]bool)
+	patchSets := change.PatchSets()
+	commitsByPatchSet := make(map[int]string)
+	for _, patchSet := range patchSets {
+		commitsByPatchSet[patchSet.Number] = patchSet.Commit
+	}
+	result := mirror.Review{
+		Revision: change.CurrentRevision,
+		Reports:  make(map[string][]ci.Report),
+	}
+	if len(patchSets) > 0 {
+		result.Revision = patchSets[0].Commit
+	}
+
+	requestTimestamp := timestamp(change.Created)
+	for _, patchSet := range patchSets {
+		req := request.New(change.Owner.String(), nil, patchSet.Ref, "refs/heads/"+change.Branch, change.Subject)
+		req.Timestamp = requestTimestamp
+		if patchSet.Commit != result.Revision {
+			req.Alias = patchSet.Commit
+		}
+		// Find the time at which the patch set was uploaded from the message that announced it.
+		for _, message := range change.Messages {
+			if message.PatchSet == patchSet.Number && patchSet.Number > 1 {
+				req.Timestamp = timestamp(message.Date)
+				break
+			}
+		}
+		result.Requests = append(result.Requests, req)
+	}
+	if change.Status == "ABANDONED" && len(result.Requests) > 0 {
+		abandoned := result.Requests[len(result.Requests)-1]
+		abandoned.Timestamp = timestamp(change.Updated)
+		abandoned.TargetRef = ""
+		result.Requests = append(result.Requests, abandoned)
+	}
+
+	for _, message := range change.Messages {
+		if hash := mirror.ExportedHash(message.Message); hash != "" {
+			exported[hash] = true
+			continue
+		}
+		c := comment.New(message.Author.String(), message.Message)
+		c.Timestamp = timestamp(message.Date)
+		if commit, ok := commitsByPatchSet[message.PatchSet]; ok {
+			c.Location = &comment.Location{Commit: commit}
+		}
+		result.Comments = append(result.Comments, c)
+	}
+
+	var inlineComments []Comment
+	for path, comments := range data.Comments {
+		for _, inlineComment := range comments {
+			inlineComment.Path = path
+			inlineComments = append(inlineComments, inlineComment)
+		}
+	}
+	sort.Stable(commentsByTime(inlineComments))
+	hashesByID := make(map[string]string)
+	for _, inlineComment := range inlineComments {
+		if hash := mirror.ExportedHash(inlineComment.Message); hash != "" {
+			exported[hash] = true
+			hashesByID[inlineComment.ID] = hash
+			continue
+		}
+		c := comment.New(inlineComment.Author.String(), inlineComment.Message)
+		c.Timestamp = timestamp(inlineComment.Updated)
+		location := comment.Location{
+			Commit: commitsByPatchSet[inlineComment.PatchSet],
+			Path:   inlineComment.Path,
+		}
+		if inlineComment.Line != 0 {
+			location.Range = &comment.Range{StartLine: inlineComment.Line}
+		}
+		c.Location = &location
+		if inlineComment.InReplyTo != "" {
+			c.Parent = hashesByID[inlineComment.InReplyTo]
+		}
+		if hash, err := c.Hash(); err == nil {
+			hashesByID[inlineComment.ID] = hash
+		}
+		result.Comments = append(result.Comments, c)
+	}
+
+	for _, approval := range change.Labels[codeReviewLabel].All {
+		if approval.Value == 0 || approval.Date == "" {
+			continue
+		}
+		c := comment.New(approval.Account.String(), fmt.Sprintf("%s%+d", codeReviewLabel, approval.Value))
+		c.Timestamp = timestamp(approval.Date)
+		c.Location = &comment.Location{Commit: change.CurrentRevision}
+		// Only the maximum and negative votes are treated as approvals and rejections;
+		// a +1 means that the change looks good, but still needs approval by someone else.
+		if approval.Value >= 2 || approval.Value < 0 {
+			resolved := approval.Value > 0
+			c.Resolved = &resolved
+		}
+		result.Comments = append(result.Comments, c)
+	}
+
+	for _, approval := range change.Labels[verifiedLabel].All {
+		if approval.Value == 0 || approval.Date == "" {
+			continue
+		}
+		status := ci.StatusSuccess
+		if approval.Value < 0 {
+			status = ci.StatusFailure
+		}
+		report := ci.New(agentPrefix+approval.Account.String(), status, "")
+		report.Timestamp = timestamp(approval.Date)
+		result.Reports[change.CurrentRevision] = append(result.Reports[change.CurrentRevision], report)
+	}
+	return result, exported
+}
+
+// syncChange imports a single change, and optionally exports local comments back to it.
+func syncChange(repo repository.Repo, client *Client, change Change, export bool) mirror.SyncResult {
+	data, err := client.GetChangeData(change)
+	if err != nil {
+		return mirror.SyncResult{Number: change.Number, Error: err.Error()}
+	}
+	converted, exported := Convert(data)
+	var post func(review.CommentThread) error
+	if export {
+		post = func(thread review.CommentThread) error {
+			return client.PostMessage(change, mirror.FormatExportedComment(thread))
+		}
+	}
+	result := mirror.SyncReview(repo, converted, exported, post)
+	result.Number = change.Number
+	return result
+}
+
+// Sync mirrors every change in the given Gerrit project into the local repo.
+//
+// If export is true, then local comments on the mirrored reviews are also
+// posted back to the corresponding changes.
+func Sync(repo repository.Repo, client *Client, project string, export bool) ([]mirror.SyncResult, error) {
+	changes, err := client.ListChanges(project)
+	if err != nil {
+		return nil, err
+	}
+	var results []mirror.SyncResult
+	for _, change := range changes {
+		results = append(results, syncChange(repo, client, change, export))
+	}
+	return results, nil
+}
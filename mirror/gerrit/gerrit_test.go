@@ -0,0 +1,153 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gerrit
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testChanges = `)]}'
+[{"id": "proj~master~I123", "_number": 42, "project": "proj", "branch": "master", "subject": "Fix it",
+  "status": "NEW", "created": "2016-01-01 00:00:00.000000000", "updated": "2016-01-04 00:00:00.000000000",
+  "owner": {"_account_id": 1, "name": "Owner", "email": "owner@example.com"},
+  "current_revision": "I",
+  "revisions": {"F": {"_number": 1, "ref": "refs/changes/42/42/1"}, "I": {"_number": 2, "ref": "refs/changes/42/42/2"}},
+  "labels": {
+    "Code-Review": {"all": [
+      {"_account_id": 2, "email": "reviewer@example.com", "value": 2, "date": "2016-01-03 00:00:00.000000000"},
+      {"_account_id": 3, "email": "other@example.com", "value": 0}]},
+    "Verified": {"all": [{"_account_id": 4, "username": "ci-bot", "value": -1, "date": "2016-01-03 00:00:01.000000000"}]}},
+  "messages": [
+    {"id": "m1", "author": {"_account_id": 1, "email": "owner@example.com"}, "date": "2016-01-01 00:00:00.000000000", "message": "Uploaded patch set 1.", "_revision_number": 1},
+    {"id": "m2", "author": {"_account_id": 1, "email": "owner@example.com"}, "date": "2016-01-02 00:00:00.000000000", "message": "Uploaded patch set 2.", "_revision_number": 2}]}]`
+	testComments = `)]}'
+{"main.go": [
+  {"id": "c2", "line": 5, "message": "Done", "author": {"email": "owner@example.com"}, "updated": "2016-01-02 12:00:00.000000000", "patch_set": 1, "in_reply_to": "c1"},
+  {"id": "c1", "line": 5, "message": "Typo", "author": {"email": "reviewer@example.com"}, "updated": "2016-01-01 12:00:00.000000000", "patch_set": 1}]}`
+)
+
+func newTestServer(posted *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == "POST":
+			*posted = append(*posted, req.URL.Path)
+			w.Write([]byte(")]}'\n{}"))
+		case strings.TrimPrefix(req.URL.Path, "/a") == "/changes/":
+			w.Write([]byte(testChanges))
+		case strings.HasSuffix(req.URL.Path, "/comments"):
+			w.Write([]byte(testComments))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+}
+
+func TestConvert(t *testing.T) {
+	var posted []string
+	server := newTestServer(&posted)
+	defer server.Close()
+	client := NewClient(server.URL, "", "")
+
+	changes, err := client.ListChanges("proj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("Unexpected changes: %v", changes)
+	}
+	data, err := client.GetChangeData(changes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, _ := Convert(data)
+	if converted.Revision != "F" {
+		t.Fatalf("The review was not anchored at the first patch set: %q", converted.Revision)
+	}
+	if len(converted.Requests) != 2 || converted.Requests[1].Alias != "I" || converted.Requests[1].ReviewRef != "refs/changes/42/42/2" {
+		t.Fatalf("Unexpected requests: %v", converted.Requests)
+	}
+	var approval, reply, typo string
+	for _, c := range converted.Comments {
+		switch c.Description {
+		case "Code-Review+2":
+			if c.Resolved == nil || !*c.Resolved {
+				t.Fatalf("The +2 vote was not recorded as an approval: %v", c)
+			}
+			approval = c.Author
+		case "Typo":
+			typo, _ = c.Hash()
+		case "Done":
+			reply = c.Parent
+		}
+	}
+	if approval != "reviewer@example.com" {
+		t.Fatalf("Missing the Code-Review approval: %v", converted.Comments)
+	}
+	if typo == "" || reply != typo {
+		t.Fatalf("The reply was not threaded under its parent: %v", converted.Comments)
+	}
+	reports := converted.Reports["I"]
+	if len(reports) != 1 || reports[0].Agent != "gerrit/ci-bot" || reports[0].Status != "failure" {
+		t.Fatalf("Unexpected CI reports: %v", reports)
+	}
+}
+
+func TestSync(t *testing.T) {
+	var posted []string
+	server := newTestServer(&posted)
+	defer server.Close()
+	client := NewClient(server.URL, "user", "secret")
+	repo := repository.NewMockRepoForTest()
+
+	results, err := Sync(repo, client, "proj", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Error != "" || results[0].Number != 42 {
+		t.Fatalf("Unexpected sync results: %v", results)
+	}
+	r, err := review.Get(repo, "F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || r.Request.Alias != "I" || r.Resolved == nil || !*r.Resolved {
+		t.Fatalf("The change was not imported: %v", r)
+	}
+	local := r.Comments[0].Comment
+	local.Author = "user@example.com"
+	local.Description = "Local comment"
+	local.Resolved = nil
+	if err := r.AddComment(local); err != nil {
+		t.Fatal(err)
+	}
+	results, err = Sync(repo, client, "proj", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Imported != 0 || results[0].Exported != 1 {
+		t.Fatalf("Unexpected sync results: %v", results)
+	}
+	if len(posted) != 1 || !strings.HasPrefix(posted[0], "/a/changes/") {
+		t.Fatalf("Unexpected exported messages: %v", posted)
+	}
+}
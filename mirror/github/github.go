@@ -0,0 +1,413 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package github mirrors GitHub pull requests into git-appraise reviews, and
+// exports git-appraise comments back to the corresponding pull requests.
+//
+// Pull requests are anchored at their first commit, so the commits of a pull
+// request must be fetched into the local repo before it can be imported, e.g.
+// by running "git fetch origin '+refs/pull/*/head:refs/pull/*/head'".
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/mirror"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the base URL of the public GitHub API.
+const DefaultBaseURL = "https://api.github.com"
+
+// agentPrefix is prepended to the names of GitHub status contexts when they are imported as CI reports.
+const agentPrefix = "github/"
+
+var nextPagePattern = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// User is a GitHub account.
+type User struct {
+	Login string `json:"login"`
+}
+
+// Branch is one end of a pull request.
+type Branch struct {
+	Ref string `json:"ref"`
+	SHA string `json:"sha"`
+}
+
+// PullRequest is a GitHub pull request.
+type PullRequest struct {
+	Number    int        `json:"number"`
+	State     string     `json:"state"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	User      User       `json:"user"`
+	Head      Branch     `json:"head"`
+	Base      Branch     `json:"base"`
+	CreatedAt time.Time  `json:"created_at"`
+	ClosedAt  *time.Time `json:"closed_at"`
+	MergedAt  *time.Time `json:"merged_at"`
+}
+
+// Commit is one of the commits in a pull request.
+type Commit struct {
+	SHA string `json:"sha"`
+}
+
+// ReviewComment is a comment on a specific line of a pull request.
+type ReviewComment struct {
+	ID               int64     `json:"id"`
+	Body             string    `json:"body"`
+	User             User      `json:"user"`
+	Path             string    `json:"path"`
+	Line             *uint32   `json:"line"`
+	OriginalLine     *uint32   `json:"original_line"`
+	CommitID         string    `json:"commit_id"`
+	OriginalCommitID string    `json:"original_commit_id"`
+	InReplyTo        int64     `json:"in_reply_to_id"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// IssueComment is a comment on a pull request as a whole.
+type IssueComment struct {
+	ID        int64     `json:"id"`
+	Body      string    `json:"body"`
+	User      User      `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Review is an approval, rejection, or general review of a pull request.
+type Review struct {
+	ID          int64     `json:"id"`
+	Body        string    `json:"body"`
+	User        User      `json:"user"`
+	State       string    `json:"state"`
+	CommitID    string    `json:"commit_id"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// Status is a commit status reported by a CI system.
+type Status struct {
+	Context   string    `json:"context"`
+	State     string    `json:"state"`
+	TargetURL string    `json:"target_url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PullRequestData is all of the data about a single pull request needed to mirror it.
+type PullRequestData struct {
+	PullRequest    PullRequest
+	Commits        []Commit
+	ReviewComments []ReviewComment
+	IssueComments  []IssueComment
+	Reviews        []Review
+	Statuses       []Status
+}
+
+// Client is a minimal client for the GitHub REST API.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a new client for the public GitHub API, authenticating with the given token if it is not empty.
+func NewClient(token string) *Client {
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		Token:      token,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+func (c *Client) do(method, url string, body interface{}) (*http.Response, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(bodyBytes)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "token "+c.Token)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		message, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GitHub request %s %s failed with %s: %s", method, url, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// getAll reads every page of a paginated GitHub list endpoint into the given slice pointer.
+func (c *Client) getAll(path string, result interface{}) error {
+	var pages []json.RawMessage
+	url := c.BaseURL + path
+	if strings.Contains(url, "?") {
+		url += "&per_page=100"
+	} else {
+		url += "?per_page=100"
+	}
+	for url != "" {
+		resp, err := c.do("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		var page []json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		pages = append(pages, page...)
+		url = ""
+		if match := nextPagePattern.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+			url = match[1]
+		}
+	}
+	combined, err := json.Marshal(pages)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(combined, result)
+}
+
+// ListPullRequests returns every pull request, open or closed, in the given GitHub repository.
+func (c *Client) ListPullRequests(owner, name string) ([]PullRequest, error) {
+	var prs []PullRequest
+	err := c.getAll(fmt.Sprintf("/repos/%s/%s/pulls?state=all", owner, name), &prs)
+	return prs, err
+}
+
+// GetPullRequestData reads everything about the given pull request that is needed to mirror it.
+func (c *Client) GetPullRequestData(owner, name string, pr PullRequest) (*PullRequestData, error) {
+	data := &PullRequestData{PullRequest: pr}
+	prPath := fmt.Sprintf("/repos/%s/%s/pulls/%d", owner, name, pr.Number)
+	if err := c.getAll(prPath+"/commits", &data.Commits); err != nil {
+		return nil, err
+	}
+	if err := c.getAll(prPath+"/comments", &data.ReviewComments); err != nil {
+		return nil, err
+	}
+	if err := c.getAll(prPath+"/reviews", &data.Reviews); err != nil {
+		return nil, err
+	}
+	if err := c.getAll(fmt.Sprintf("/repos/%s/%s/issues/%d/comments", owner, name, pr.Number), &data.IssueComments); err != nil {
+		return nil, err
+	}
+	if err := c.getAll(fmt.Sprintf("/repos/%s/%s/commits/%s/statuses", owner, name, pr.Head.SHA), &data.Statuses); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// PostComment adds a comment to the conversation of the given pull request.
+func (c *Client) PostComment(owner, name string, number int, body string) error {
+	resp, err := c.do("POST", fmt.Sprintf("%s/repos/%s/%s/issues/%d/comments", c.BaseURL, owner, name, number), map[string]string{"body": body})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// convertStatusState maps the state of a GitHub commit status onto a CI report status.
+func convertStatusState(state string) string {
+	switch state {
+	case "success":
+		return ci.StatusSuccess
+	case "failure", "error":
+		return ci.StatusFailure
+	case "pending":
+		return ci.StatusPending
+	}
+	return ""
+}
+
+type reviewCommentsByID []ReviewComment
+
+func (comments reviewCommentsByID) Len() int { return len(comments) }
+func (comments reviewCommentsByID) Swap(i, j int) {
+	comments[i], comments[j] = comments[j], comments[i]
+}
+func (comments reviewCommentsByID) Less(i, j int) bool { return comments[i].ID < comments[j].ID }
+
+// Convert translates a pull request into the corresponding git-appraise review.
+//
+// Comments that were originally exported from git-appraise are skipped, and
+// the hashes of the comments they were exported from are returned instead.
+func Convert(data *PullRequestData) (mirror.Review, map[string]bool) {
+	pr := data.PullRequest
+	exported := make(map[string]bool)
+	result := mirror.Review{
+		Revision: pr.Head.SHA,
+		Reports:  make(map[string][]ci.Report),
+	}
+	if len(data.Commits) > 0 {
+		result.Revision = data.Commits[0].SHA
+	}
+
+	description := pr.Title
+	if pr.Body != "" {
+		description += "\n\n" + pr.Body
+	}
+	req := request.New(pr.User.Login, nil, fmt.Sprintf("refs/pull/%d/head", pr.Number), "refs/heads/"+pr.Base.Ref, description)
+	req.Timestamp = mirror.Timestamp(pr.CreatedAt)
+	result.Requests = append(result.Requests, req)
+	if pr.State == "closed" && pr.MergedAt == nil && pr.ClosedAt != nil {
+		abandoned := req
+		abandoned.Timestamp = mirror.Timestamp(*pr.ClosedAt)
+		abandoned.TargetRef = ""
+		result.Requests = append(result.Requests, abandoned)
+	}
+
+	for _, issueComment := range data.IssueComments {
+		if hash := mirror.ExportedHash(issueComment.Body); hash != "" {
+			exported[hash] = true
+			continue
+		}
+		c := comment.New(issueComment.User.Login, issueComment.Body)
+		c.Timestamp = mirror.Timestamp(issueComment.CreatedAt)
+		c.Location = &comment.Location{Commit: pr.Head.SHA}
+		result.Comments = append(result.Comments, c)
+	}
+
+	for _, ghReview := range data.Reviews {
+		if hash := mirror.ExportedHash(ghReview.Body); hash != "" {
+			exported[hash] = true
+			continue
+		}
+		var resolved *bool
+		switch ghReview.State {
+		case "APPROVED":
+			accepted := true
+			resolved = &accepted
+		case "CHANGES_REQUESTED":
+			rejected := false
+			resolved = &rejected
+		case "COMMENTED":
+			if ghReview.Body == "" {
+				continue
+			}
+		default:
+			continue
+		}
+		c := comment.New(ghReview.User.Login, ghReview.Body)
+		c.Timestamp = mirror.Timestamp(ghReview.SubmittedAt)
+		c.Location = &comment.Location{Commit: ghReview.CommitID}
+		c.Resolved = resolved
+		result.Comments = append(result.Comments, c)
+	}
+
+	reviewComments := append([]ReviewComment(nil), data.ReviewComments...)
+	sort.Sort(reviewCommentsByID(reviewComments))
+	hashesByID := make(map[int64]string)
+	for _, reviewComment := range reviewComments {
+		if hash := mirror.ExportedHash(reviewComment.Body); hash != "" {
+			exported[hash] = true
+			hashesByID[reviewComment.ID] = hash
+			continue
+		}
+		c := comment.New(reviewComment.User.Login, reviewComment.Body)
+		c.Timestamp = mirror.Timestamp(reviewComment.CreatedAt)
+		location := comment.Location{
+			Commit: reviewComment.CommitID,
+			Path:   reviewComment.Path,
+		}
+		line := reviewComment.Line
+		if line == nil {
+			location.Commit = reviewComment.OriginalCommitID
+			line = reviewComment.OriginalLine
+		}
+		if line != nil {
+			location.Range = &comment.Range{StartLine: *line}
+		}
+		c.Location = &location
+		if reviewComment.InReplyTo != 0 {
+			c.Parent = hashesByID[reviewComment.InReplyTo]
+		}
+		if hash, err := c.Hash(); err == nil {
+			hashesByID[reviewComment.ID] = hash
+		}
+		result.Comments = append(result.Comments, c)
+	}
+
+	for _, status := range data.Statuses {
+		report := ci.New(agentPrefix+status.Context, convertStatusState(status.State), status.TargetURL)
+		report.Timestamp = mirror.Timestamp(status.CreatedAt)
+		result.Reports[pr.Head.SHA] = append(result.Reports[pr.Head.SHA], report)
+	}
+	return result, exported
+}
+
+// syncPullRequest imports a single pull request, and optionally exports local comments back to it.
+func syncPullRequest(repo repository.Repo, client *Client, owner, name string, pr PullRequest, export bool) mirror.SyncResult {
+	data, err := client.GetPullRequestData(owner, name, pr)
+	if err != nil {
+		return mirror.SyncResult{Number: pr.Number, Error: err.Error()}
+	}
+	converted, exported := Convert(data)
+	var post func(review.CommentThread) error
+	if export {
+		post = func(thread review.CommentThread) error {
+			return client.PostComment(owner, name, pr.Number, mirror.FormatExportedComment(thread))
+		}
+	}
+	result := mirror.SyncReview(repo, converted, exported, post)
+	result.Number = pr.Number
+	return result
+}
+
+// Sync mirrors every pull request in the given GitHub repository into the local repo.
+//
+// If export is true, then local comments on the mirrored reviews are also
+// posted back to the corresponding pull requests.
+func Sync(repo repository.Repo, client *Client, owner, name string, export bool) ([]mirror.SyncResult, error) {
+	prs, err := client.ListPullRequests(owner, name)
+	if err != nil {
+		return nil, err
+	}
+	var results []mirror.SyncResult
+	for _, pr := range prs {
+		results = append(results, syncPullRequest(repo, client, owner, name, pr, export))
+	}
+	return results, nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"encoding/json"
+	"github.com/promet/git-appraise/mirror"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testResponses = map[string]string{
+	"/repos/o/r/pulls":              `[{"number": 7, "state": "open", "title": "Fix it", "body": "Details", "user": {"login": "octocat"}, "head": {"ref": "fix", "sha": "I"}, "base": {"ref": "master", "sha": "J"}, "created_at": "2016-01-01T00:00:00Z"}]`,
+	"/repos/o/r/pulls/7/commits":    `[{"sha": "F"}, {"sha": "I"}]`,
+	"/repos/o/r/pulls/7/comments":   `[{"id": 2, "body": "Agreed", "user": {"login": "octocat"}, "path": "a.go", "line": 3, "commit_id": "I", "in_reply_to_id": 1, "created_at": "2016-01-02T00:00:01Z"}, {"id": 1, "body": "Nit", "user": {"login": "hubot"}, "path": "a.go", "line": 3, "commit_id": "I", "created_at": "2016-01-02T00:00:00Z"}]`,
+	"/repos/o/r/pulls/7/reviews":    `[{"id": 3, "body": "", "user": {"login": "hubot"}, "state": "APPROVED", "commit_id": "I", "submitted_at": "2016-01-03T00:00:00Z"}]`,
+	"/repos/o/r/issues/7/comments":  `[{"id": 4, "body": "user@example.com commented:\n\nOld\n\n<!-- git-appraise:0123456789abcdef0123456789abcdef01234567 -->", "user": {"login": "bot"}, "created_at": "2016-01-04T00:00:00Z"}]`,
+	"/repos/o/r/commits/I/statuses": `[{"context": "travis", "state": "error", "target_url": "https://ci.example.com/7", "created_at": "2016-01-05T00:00:00Z"}]`,
+}
+
+func newTestServer(t *testing.T, posted *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" {
+			var body map[string]string
+			bytes, _ := ioutil.ReadAll(req.Body)
+			if err := json.Unmarshal(bytes, &body); err != nil {
+				t.Fatal(err)
+			}
+			*posted = append(*posted, body["body"])
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+			return
+		}
+		response, ok := testResponses[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+}
+
+func TestConvert(t *testing.T) {
+	var posted []string
+	server := newTestServer(t, &posted)
+	defer server.Close()
+	client := NewClient("")
+	client.BaseURL = server.URL
+
+	prs, err := client.ListPullRequests("o", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := client.GetPullRequestData("o", "r", prs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, exported := Convert(data)
+	if converted.Revision != "F" {
+		t.Fatalf("The review was not anchored at the first commit: %q", converted.Revision)
+	}
+	if len(converted.Requests) != 1 || converted.Requests[0].Description != "Fix it\n\nDetails" || converted.Requests[0].TargetRef != "refs/heads/master" {
+		t.Fatalf("Unexpected requests: %v", converted.Requests)
+	}
+	if !exported["0123456789abcdef0123456789abcdef01234567"] {
+		t.Fatalf("The exported comment was not recognized: %v", exported)
+	}
+	if len(converted.Comments) != 3 {
+		t.Fatalf("Unexpected comments: %v", converted.Comments)
+	}
+	var nit, reply string
+	for _, c := range converted.Comments {
+		if c.Description == "Nit" {
+			nit, _ = c.Hash()
+		}
+		if c.Description == "Agreed" {
+			reply = c.Parent
+		}
+	}
+	if nit == "" || reply != nit {
+		t.Fatalf("The reply was not threaded under its parent: %v", converted.Comments)
+	}
+	reports := converted.Reports["I"]
+	if len(reports) != 1 || reports[0].Agent != "github/travis" || reports[0].Status != "failure" {
+		t.Fatalf("Unexpected CI reports: %v", reports)
+	}
+}
+
+func TestSync(t *testing.T) {
+	var posted []string
+	server := newTestServer(t, &posted)
+	defer server.Close()
+	client := NewClient("token")
+	client.BaseURL = server.URL
+	repo := repository.NewMockRepoForTest()
+
+	results, err := Sync(repo, client, "o", "r", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Error != "" || results[0].Imported == 0 {
+		t.Fatalf("Unexpected sync results: %v", results)
+	}
+	r, err := review.Get(repo, "F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || r.Request.Requester != "octocat" {
+		t.Fatalf("The pull request was not imported: %v", r)
+	}
+	local := r.Comments[0].Comment
+	local.Author = "user@example.com"
+	local.Description = "Local reply"
+	local.Resolved = nil
+	if err := r.AddComment(local); err != nil {
+		t.Fatal(err)
+	}
+	results, err = Sync(repo, client, "o", "r", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Imported != 0 || results[0].Exported != 1 {
+		t.Fatalf("Unexpected sync results: %v", results)
+	}
+	if len(posted) != 1 || !strings.Contains(posted[0], "Local reply") || mirror.ExportedHash(posted[0]) == "" {
+		t.Fatalf("Unexpected exported comments: %v", posted)
+	}
+}
@@ -0,0 +1,402 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gitlab mirrors GitLab merge requests into git-appraise reviews, and
+// exports git-appraise comments and approvals back to the merge requests.
+//
+// Merge requests are keyed by the SHA of their first commit, which is where
+// the corresponding review is anchored. The commits of a merge request must
+// be fetched into the local repo before it can be imported, e.g. by running
+// "git fetch origin '+refs/merge-requests/*/head:refs/merge-requests/*/head'".
+package gitlab
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/mirror"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the base URL of the API for gitlab.com.
+const DefaultBaseURL = "https://gitlab.com/api/v4"
+
+const (
+	// agentPrefix is prepended to the pipeline sources when they are imported as CI reports.
+	agentPrefix = "gitlab/pipeline"
+	// approvedNote and unapprovedNote are the bodies of the system notes that GitLab adds when approvals change.
+	approvedNote   = "approved this merge request"
+	unapprovedNote = "unapproved this merge request"
+)
+
+// User is a GitLab account.
+type User struct {
+	Username string `json:"username"`
+}
+
+// MergeRequest is a GitLab merge request.
+type MergeRequest struct {
+	IID          int        `json:"iid"`
+	Title        string     `json:"title"`
+	Description  string     `json:"description"`
+	State        string     `json:"state"`
+	Author       User       `json:"author"`
+	SourceBranch string     `json:"source_branch"`
+	TargetBranch string     `json:"target_branch"`
+	SHA          string     `json:"sha"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ClosedAt     *time.Time `json:"closed_at"`
+}
+
+// Commit is one of the commits in a merge request.
+type Commit struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Position is the location in the diff that a note refers to.
+type Position struct {
+	HeadSHA string  `json:"head_sha"`
+	NewPath string  `json:"new_path"`
+	NewLine *uint32 `json:"new_line"`
+	OldPath string  `json:"old_path"`
+	OldLine *uint32 `json:"old_line"`
+}
+
+// Note is a single comment in a discussion.
+type Note struct {
+	ID        int64     `json:"id"`
+	Body      string    `json:"body"`
+	Author    User      `json:"author"`
+	System    bool      `json:"system"`
+	CreatedAt time.Time `json:"created_at"`
+	Position  *Position `json:"position"`
+}
+
+// Discussion is a thread of notes on a merge request.
+type Discussion struct {
+	ID    string `json:"id"`
+	Notes []Note `json:"notes"`
+}
+
+// Pipeline is a CI pipeline run for a commit in a merge request.
+type Pipeline struct {
+	ID        int64     `json:"id"`
+	SHA       string    `json:"sha"`
+	Status    string    `json:"status"`
+	WebURL    string    `json:"web_url"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MergeRequestData is all of the data about a single merge request needed to mirror it.
+type MergeRequestData struct {
+	MergeRequest MergeRequest
+	Commits      []Commit
+	Discussions  []Discussion
+	Pipelines    []Pipeline
+}
+
+// Client is a minimal client for the GitLab REST API.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a new client for gitlab.com, authenticating with the given token if it is not empty.
+func NewClient(token string) *Client {
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		Token:      token,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+func (c *Client) do(method, path string, body interface{}) (*http.Response, error) {
+	var bodyBytes []byte
+	if body != nil {
+		var err error
+		bodyBytes, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("PRIVATE-TOKEN", c.Token)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		message, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GitLab request %s %s failed with %s: %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// getAll reads every page of a paginated GitLab list endpoint into the given slice pointer.
+func (c *Client) getAll(path string, result interface{}) error {
+	var items []json.RawMessage
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	page := "1"
+	for page != "" {
+		resp, err := c.do("GET", path+separator+"per_page=100&page="+page, nil)
+		if err != nil {
+			return err
+		}
+		var pageItems []json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&pageItems)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		items = append(items, pageItems...)
+		page = resp.Header.Get("X-Next-Page")
+	}
+	combined, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(combined, result)
+}
+
+func projectPath(project string) string {
+	return "/projects/" + url.PathEscape(project)
+}
+
+func mergeRequestPath(project string, iid int) string {
+	return fmt.Sprintf("%s/merge_requests/%d", projectPath(project), iid)
+}
+
+// ListMergeRequests returns every merge request, open or closed, in the given GitLab project.
+func (c *Client) ListMergeRequests(project string) ([]MergeRequest, error) {
+	var mrs []MergeRequest
+	err := c.getAll(projectPath(project)+"/merge_requests?state=all", &mrs)
+	return mrs, err
+}
+
+// GetMergeRequestData reads everything about the given merge request that is needed to mirror it.
+func (c *Client) GetMergeRequestData(project string, mr MergeRequest) (*MergeRequestData, error) {
+	data := &MergeRequestData{MergeRequest: mr}
+	mrPath := mergeRequestPath(project, mr.IID)
+	if err := c.getAll(mrPath+"/commits", &data.Commits); err != nil {
+		return nil, err
+	}
+	if err := c.getAll(mrPath+"/discussions", &data.Discussions); err != nil {
+		return nil, err
+	}
+	if err := c.getAll(mrPath+"/pipelines", &data.Pipelines); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// PostNote adds a note to the given merge request.
+func (c *Client) PostNote(project string, iid int, body string) error {
+	resp, err := c.do("POST", mergeRequestPath(project, iid)+"/notes", map[string]string{"body": body})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Approve approves the given merge request, provided that its head is still the given commit.
+func (c *Client) Approve(project string, iid int, sha string) error {
+	resp, err := c.do("POST", mergeRequestPath(project, iid)+"/approve", map[string]string{"sha": sha})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// convertPipelineStatus maps the status of a GitLab pipeline onto a CI report status.
+func convertPipelineStatus(status string) string {
+	switch status {
+	case "success":
+		return ci.StatusSuccess
+	case "failed", "canceled":
+		return ci.StatusFailure
+	case "running":
+		return ci.StatusRunning
+	case "created", "waiting_for_resource", "preparing", "pending", "scheduled", "manual":
+		return ci.StatusPending
+	}
+	return ""
+}
+
+// convertNote translates a single GitLab note into a git-appraise comment.
+func convertNote(mr MergeRequest, note Note, parent string) comment.Comment {
+	c := comment.New(note.Author.Username, note.Body)
+	c.Timestamp = mirror.Timestamp(note.CreatedAt)
+	c.Parent = parent
+	location := comment.Location{Commit: mr.SHA}
+	if note.Position != nil {
+		if note.Position.HeadSHA != "" {
+			location.Commit = note.Position.HeadSHA
+		}
+		location.Path = note.Position.NewPath
+		line := note.Position.NewLine
+		if line == nil {
+			location.Path = note.Position.OldPath
+			line = note.Position.OldLine
+		}
+		if line != nil {
+			location.Range = &comment.Range{StartLine: *line}
+		}
+	}
+	c.Location = &location
+	return c
+}
+
+// Convert translates a merge request into the corresponding git-appraise review.
+//
+// Notes that were originally exported from git-appraise are skipped, and the
+// hashes of the comments they were exported from are returned instead.
+func Convert(data *MergeRequestData) (mirror.Review, map[string]bool) {
+	mr := data.MergeRequest
+	exported := make(map[string]bool)
+	result := mirror.Review{
+		Revision: mr.SHA,
+		Reports:  make(map[string][]ci.Report),
+	}
+	// GitLab lists the commits of a merge request with the newest first.
+	if len(data.Commits) > 0 {
+		result.Revision = data.Commits[len(data.Commits)-1].ID
+	}
+
+	description := mr.Title
+	if mr.Description != "" {
+		description += "\n\n" + mr.Description
+	}
+	req := request.New(mr.Author.Username, nil, "refs/heads/"+mr.SourceBranch, "refs/heads/"+mr.TargetBranch, description)
+	req.Timestamp = mirror.Timestamp(mr.CreatedAt)
+	result.Requests = append(result.Requests, req)
+	if mr.State == "closed" && mr.ClosedAt != nil {
+		abandoned := req
+		abandoned.Timestamp = mirror.Timestamp(*mr.ClosedAt)
+		abandoned.TargetRef = ""
+		result.Requests = append(result.Requests, abandoned)
+	}
+
+	for _, discussion := range data.Discussions {
+		parent := ""
+		for _, note := range discussion.Notes {
+			if note.System {
+				if strings.HasPrefix(note.Body, approvedNote) {
+					accepted := true
+					c := comment.New(note.Author.Username, "")
+					c.Timestamp = mirror.Timestamp(note.CreatedAt)
+					c.Location = &comment.Location{Commit: mr.SHA}
+					c.Resolved = &accepted
+					result.Comments = append(result.Comments, c)
+				} else if strings.HasPrefix(note.Body, unapprovedNote) {
+					c := comment.New(note.Author.Username, note.Body)
+					c.Timestamp = mirror.Timestamp(note.CreatedAt)
+					c.Location = &comment.Location{Commit: mr.SHA}
+					result.Comments = append(result.Comments, c)
+				}
+				continue
+			}
+			if hash := mirror.ExportedHash(note.Body); hash != "" {
+				exported[hash] = true
+				if parent == "" {
+					parent = hash
+				}
+				continue
+			}
+			c := convertNote(mr, note, parent)
+			result.Comments = append(result.Comments, c)
+			if parent == "" {
+				if hash, err := c.Hash(); err == nil {
+					parent = hash
+				}
+			}
+		}
+	}
+
+	for _, pipeline := range data.Pipelines {
+		report := ci.New(agentPrefix, convertPipelineStatus(pipeline.Status), pipeline.WebURL)
+		report.Timestamp = mirror.Timestamp(pipeline.UpdatedAt)
+		result.Reports[pipeline.SHA] = append(result.Reports[pipeline.SHA], report)
+	}
+	return result, exported
+}
+
+// syncMergeRequest imports a single merge request, and optionally exports local comments and approvals back to it.
+func syncMergeRequest(repo repository.Repo, client *Client, project string, mr MergeRequest, export bool) mirror.SyncResult {
+	data, err := client.GetMergeRequestData(project, mr)
+	if err != nil {
+		return mirror.SyncResult{Number: mr.IID, Error: err.Error()}
+	}
+	converted, exported := Convert(data)
+	var post func(review.CommentThread) error
+	if export {
+		post = func(thread review.CommentThread) error {
+			if err := client.PostNote(project, mr.IID, mirror.FormatExportedComment(thread)); err != nil {
+				return err
+			}
+			if thread.Comment.Resolved == nil || !*thread.Comment.Resolved || thread.Comment.Parent != "" {
+				return nil
+			}
+			// Approvals are only exported if they were made against the current head of the merge request.
+			if thread.Comment.Location == nil || thread.Comment.Location.Commit != mr.SHA {
+				return nil
+			}
+			return client.Approve(project, mr.IID, mr.SHA)
+		}
+	}
+	result := mirror.SyncReview(repo, converted, exported, post)
+	result.Number = mr.IID
+	return result
+}
+
+// Sync mirrors every merge request in the given GitLab project into the local repo.
+//
+// If export is true, then local comments and approvals on the mirrored
+// reviews are also posted back to the corresponding merge requests.
+func Sync(repo repository.Repo, client *Client, project string, export bool) ([]mirror.SyncResult, error) {
+	mrs, err := client.ListMergeRequests(project)
+	if err != nil {
+		return nil, err
+	}
+	var results []mirror.SyncResult
+	for _, mr := range mrs {
+		results = append(results, syncMergeRequest(repo, client, project, mr, export))
+	}
+	return results, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitlab
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var testResponses = map[string]string{
+	"/projects/group%2Fproj/merge_requests":               `[{"iid": 3, "title": "Fix it", "description": "Details", "state": "opened", "author": {"username": "owner"}, "source_branch": "fix", "target_branch": "master", "sha": "I", "created_at": "2016-01-01T00:00:00Z"}]`,
+	"/projects/group%2Fproj/merge_requests/3/commits":     `[{"id": "I"}, {"id": "F"}]`,
+	"/projects/group%2Fproj/merge_requests/3/discussions": `[{"id": "d1", "notes": [{"id": 1, "body": "Typo", "author": {"username": "reviewer"}, "created_at": "2016-01-02T00:00:00Z", "position": {"head_sha": "I", "new_path": "main.go", "new_line": 4}}, {"id": 2, "body": "Fixed", "author": {"username": "owner"}, "created_at": "2016-01-02T01:00:00Z"}]}, {"id": "d2", "notes": [{"id": 3, "body": "approved this merge request", "system": true, "author": {"username": "reviewer"}, "created_at": "2016-01-03T00:00:00Z"}]}]`,
+	"/projects/group%2Fproj/merge_requests/3/pipelines":   `[{"id": 9, "sha": "I", "status": "failed", "web_url": "https://gitlab.example.com/p/9", "updated_at": "2016-01-02T02:00:00Z"}]`,
+}
+
+func newTestServer(posted *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" {
+			*posted = append(*posted, req.URL.EscapedPath())
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+			return
+		}
+		response, ok := testResponses[req.URL.EscapedPath()]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+}
+
+func TestConvert(t *testing.T) {
+	var posted []string
+	server := newTestServer(&posted)
+	defer server.Close()
+	client := NewClient("")
+	client.BaseURL = server.URL
+
+	mrs, err := client.ListMergeRequests("group/proj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := client.GetMergeRequestData("group/proj", mrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, _ := Convert(data)
+	if converted.Revision != "F" {
+		t.Fatalf("The review was not anchored at the first commit: %q", converted.Revision)
+	}
+	if len(converted.Comments) != 3 {
+		t.Fatalf("Unexpected comments: %v", converted.Comments)
+	}
+	typo, err := converted.Comments[0].Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := converted.Comments[0].Location; loc.Path != "main.go" || loc.Range == nil || loc.Range.StartLine != 4 {
+		t.Fatalf("Unexpected comment location: %v", loc)
+	}
+	if converted.Comments[1].Parent != typo {
+		t.Fatalf("The reply was not threaded under its parent: %v", converted.Comments)
+	}
+	if resolved := converted.Comments[2].Resolved; resolved == nil || !*resolved {
+		t.Fatalf("The approval was not imported: %v", converted.Comments[2])
+	}
+	if reports := converted.Reports["I"]; len(reports) != 1 || reports[0].Status != "failure" {
+		t.Fatalf("Unexpected CI reports: %v", reports)
+	}
+}
+
+func TestSyncExportsApprovals(t *testing.T) {
+	var posted []string
+	server := newTestServer(&posted)
+	defer server.Close()
+	client := NewClient("token")
+	client.BaseURL = server.URL
+	repo := repository.NewMockRepoForTest()
+
+	if _, err := Sync(repo, client, "group/proj", false); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, "F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := true
+	approval := comment.New("user@example.com", "LGTM")
+	approval.Location = &comment.Location{Commit: "I"}
+	approval.Resolved = &accepted
+	if err := r.AddComment(approval); err != nil {
+		t.Fatal(err)
+	}
+	results, err := Sync(repo, client, "group/proj", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Error != "" || results[0].Exported != 1 {
+		t.Fatalf("Unexpected sync results: %v", results)
+	}
+	if len(posted) != 2 || posted[0] != "/projects/group%2Fproj/merge_requests/3/notes" || posted[1] != "/projects/group%2Fproj/merge_requests/3/approve" {
+		t.Fatalf("Unexpected exports: %v", posted)
+	}
+}
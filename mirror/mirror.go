@@ -0,0 +1,259 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mirror contains the pieces shared by the bridges that copy code
+// reviews between git-appraise and other code review systems.
+//
+// Each bridge converts the reviews of the other system into the Review type
+// defined here, and then uses Import to write them as git notes. Because
+// the conversion is deterministic, importing the same data again does not
+// write any new notes. Comments that are exported to the other system are
+// tagged with a marker that includes the hash of the original comment, so
+// that they are not imported back as new comments.
+package mirror
+
+import (
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// exportMarkerTemplate is appended to every comment exported to another system.
+const exportMarkerTemplate = "<!-- git-appraise:%s -->"
+
+var exportMarkerPattern = regexp.MustCompile(`<!-- git-appraise:([0-9a-f]{40}) -->`)
+
+// Review represents a code review from another system, converted into git-appraise metadata.
+type Review struct {
+	// Revision is the commit that the review is anchored at.
+	Revision string
+	Requests []request.Request
+	Comments []comment.Comment
+	// Reports maps commit hashes to the CI reports that annotate those commits.
+	Reports map[string][]ci.Report
+}
+
+// Timestamp converts the given time into the format used in git-appraise notes.
+func Timestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// ParseTimestamp converts a time in the given layout into the format used in git-appraise notes.
+func ParseTimestamp(layout, value string) (string, error) {
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return "", err
+	}
+	return Timestamp(t), nil
+}
+
+// MarkExported appends a marker to the given comment body identifying the git-appraise comment it was exported from.
+func MarkExported(body, commentHash string) string {
+	return body + "\n\n" + fmt.Sprintf(exportMarkerTemplate, commentHash)
+}
+
+// ExportedHash returns the hash of the git-appraise comment that the given body was exported from, if any.
+func ExportedHash(body string) string {
+	match := exportMarkerPattern.FindStringSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// appendNewNotes appends each of the given notes to the revision, unless an identical note is already present.
+func appendNewNotes(repo repository.Repo, ref, revision string, notes []repository.Note) (int, error) {
+	existing := make(map[string]bool)
+	for _, note := range repo.GetNotes(ref, revision) {
+		existing[string(note)] = true
+	}
+	var added []repository.Note
+	for _, note := range notes {
+		if existing[string(note)] {
+			continue
+		}
+		added = append(added, note)
+		existing[string(note)] = true
+	}
+	if err := repo.AppendNotes(ref, map[string][]repository.Note{revision: added}); err != nil {
+		return 0, err
+	}
+	return len(added), nil
+}
+
+// Import writes the given review into the repo's notes, and returns the number of new notes written.
+//
+// The review is skipped, with an error, if its revision is not a commit in the local repo.
+func Import(repo repository.Repo, r Review) (int, error) {
+	if err := repo.VerifyCommit(r.Revision); err != nil {
+		return 0, fmt.Errorf("Skipping the review of %q, as that commit has not been fetched", r.Revision)
+	}
+	var requestNotes []repository.Note
+	for _, req := range r.Requests {
+		note, err := req.Write()
+		if err != nil {
+			return 0, err
+		}
+		requestNotes = append(requestNotes, note)
+	}
+	written, err := appendNewNotes(repo, request.Ref, r.Revision, requestNotes)
+	if err != nil {
+		return written, err
+	}
+
+	var commentNotes []repository.Note
+	for _, c := range r.Comments {
+		note, err := c.Write()
+		if err != nil {
+			return written, err
+		}
+		commentNotes = append(commentNotes, note)
+	}
+	n, err := appendNewNotes(repo, comment.Ref, r.Revision, commentNotes)
+	written += n
+	if err != nil {
+		return written, err
+	}
+
+	for commit, reports := range r.Reports {
+		if repo.VerifyCommit(commit) != nil {
+			continue
+		}
+		var reportNotes []repository.Note
+		for _, report := range reports {
+			note, err := report.Write()
+			if err != nil {
+				return written, err
+			}
+			reportNotes = append(reportNotes, note)
+		}
+		n, err := appendNewNotes(repo, ci.Ref, commit, reportNotes)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// FlattenThreads returns every comment thread in the given list, including all of their descendants.
+func FlattenThreads(threads []review.CommentThread) []review.CommentThread {
+	var flattened []review.CommentThread
+	for _, thread := range threads {
+		flattened = append(flattened, thread)
+		flattened = append(flattened, FlattenThreads(thread.Children)...)
+	}
+	return flattened
+}
+
+// CommentsToExport returns the comments in the local review that did not come
+// from the other system, and that have not already been exported to it.
+//
+// The imported argument is the review as converted from the other system,
+// and the exported argument is the set of comment hashes found in the
+// export markers of the comments in the other system.
+func CommentsToExport(local *review.Review, imported Review, exported map[string]bool) ([]review.CommentThread, error) {
+	importedHashes := make(map[string]bool)
+	for _, c := range imported.Comments {
+		hash, err := c.Hash()
+		if err != nil {
+			return nil, err
+		}
+		importedHashes[hash] = true
+	}
+	var toExport []review.CommentThread
+	for _, thread := range FlattenThreads(local.Comments) {
+		if importedHashes[thread.Hash] || exported[thread.Hash] {
+			continue
+		}
+		toExport = append(toExport, thread)
+	}
+	return toExport, nil
+}
+
+// SyncResult summarizes the outcome of mirroring a single review.
+type SyncResult struct {
+	// Number is the identifier of the review in the other system.
+	Number   int    `json:"number"`
+	Revision string `json:"revision"`
+	Imported int    `json:"imported"`
+	Exported int    `json:"exported"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SyncReview imports the given review, and then exports each local comment on it using the post function.
+//
+// The exported argument is the set of comment hashes found in the export markers
+// of the comments in the other system. If post is nil, then nothing is exported.
+// The post function will typically use FormatExportedComment to build the text
+// of the exported comment.
+func SyncReview(repo repository.Repo, imported Review, exported map[string]bool, post func(thread review.CommentThread) error) SyncResult {
+	result := SyncResult{Revision: imported.Revision}
+	var err error
+	result.Imported, err = Import(repo, imported)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if post == nil {
+		return result
+	}
+	local, err := review.Get(repo, imported.Revision)
+	if err != nil || local == nil {
+		result.Error = fmt.Sprintf("Failed to load the imported review: %v", err)
+		return result
+	}
+	toExport, err := CommentsToExport(local, imported, exported)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for _, thread := range toExport {
+		if err := post(thread); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Exported++
+	}
+	return result
+}
+
+// FormatExportedComment returns the body used when exporting the given comment to another system.
+func FormatExportedComment(thread review.CommentThread) string {
+	c := thread.Comment
+	body := fmt.Sprintf("%s commented", c.Author)
+	if c.Location != nil && c.Location.Path != "" {
+		body += " on " + c.Location.Path
+		if c.Location.Range != nil {
+			body += fmt.Sprintf(":%d", c.Location.Range.StartLine)
+		}
+	}
+	if c.Resolved != nil {
+		if *c.Resolved {
+			body += " (LGTM)"
+		} else {
+			body += " (needs more work)"
+		}
+	}
+	body += ":\n\n" + c.Description
+	return MarkExported(body, thread.Hash)
+}
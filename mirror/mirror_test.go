@@ -0,0 +1,96 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"testing"
+)
+
+func TestExportMarkers(t *testing.T) {
+	hash := "0123456789abcdef0123456789abcdef01234567"
+	body := MarkExported("Looks good", hash)
+	if ExportedHash(body) != hash {
+		t.Fatalf("Failed to find the export marker in %q", body)
+	}
+	if ExportedHash("Looks good") != "" {
+		t.Fatal("Unexpected export marker found in a plain comment")
+	}
+}
+
+func TestImportAndExport(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	req := request.New("octocat", nil, "refs/pull/1/head", "refs/heads/master", "Mirrored change")
+	req.Timestamp = "0000000100"
+	c := comment.New("octocat", "Remote comment")
+	c.Timestamp = "0000000101"
+	c.Location = &comment.Location{Commit: repository.TestCommitF}
+	report := ci.New("github/travis", ci.StatusSuccess, "https://ci.example.com/1")
+	imported := Review{
+		Revision: repository.TestCommitF,
+		Requests: []request.Request{req},
+		Comments: []comment.Comment{c},
+		Reports:  map[string][]ci.Report{repository.TestCommitF: []ci.Report{report}},
+	}
+	written, err := Import(repo, imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 3 {
+		t.Fatalf("Unexpected number of notes written: %d", written)
+	}
+	written, err = Import(repo, imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 0 {
+		t.Fatalf("Re-importing the same review wrote %d notes", written)
+	}
+	if _, err := Import(repo, Review{Revision: "missing"}); err == nil {
+		t.Fatal("Failed to reject a review whose commit is missing")
+	}
+
+	local, err := review.Get(repo, repository.TestCommitF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := local.AddComment(comment.New("user@example.com", "Local comment")); err != nil {
+		t.Fatal(err)
+	}
+	local, err = review.Get(repo, repository.TestCommitF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toExport, err := CommentsToExport(local, imported, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toExport) != 1 || toExport[0].Comment.Description != "Local comment" {
+		t.Fatalf("Unexpected comments to export: %v", toExport)
+	}
+	toExport, err = CommentsToExport(local, imported, map[string]bool{toExport[0].Hash: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toExport) != 0 {
+		t.Fatalf("Already exported comments were exported again: %v", toExport)
+	}
+}
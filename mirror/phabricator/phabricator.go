@@ -0,0 +1,633 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package phabricator imports the Differential revisions of a Phabricator
+// repository into git-appraise reviews.
+//
+// The revisions are read either from the Conduit API, or from a dump that
+// was previously saved from it, so that the review history can still be
+// imported after the Phabricator server has been shut down.
+//
+// Each revision that has landed is anchored at the commit it landed as, so
+// that it shows up as submitted. Other revisions are anchored at the commit
+// of their first diff. Every diff is recorded as a patchset, inline comments
+// are attached to the files and lines of the diffs they were made on, and
+// the accept and request-changes actions become approvals and rejections.
+package phabricator
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/mirror"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	statusLanded    = "published"
+	statusAbandoned = "abandoned"
+
+	// landedEdgeType is the type of the edges from each revision to the commits it landed as.
+	landedEdgeType = "revision.commit"
+)
+
+// Revision is a Differential revision, as returned by "differential.revision.search".
+type Revision struct {
+	ID     int    `json:"id"`
+	PHID   string `json:"phid"`
+	Fields struct {
+		Title   string `json:"title"`
+		Summary string `json:"summary"`
+		Status  struct {
+			Value string `json:"value"`
+		} `json:"status"`
+		AuthorPHID   string `json:"authorPHID"`
+		DateCreated  int64  `json:"dateCreated"`
+		DateModified int64  `json:"dateModified"`
+	} `json:"fields"`
+	Attachments struct {
+		Reviewers struct {
+			Reviewers []struct {
+				ReviewerPHID string `json:"reviewerPHID"`
+			} `json:"reviewers"`
+		} `json:"reviewers"`
+	} `json:"attachments"`
+}
+
+// DiffRef is one of the refs recorded when a diff was created, such as its base commit or branch.
+type DiffRef struct {
+	Type       string `json:"type"`
+	Identifier string `json:"identifier"`
+	Name       string `json:"name"`
+}
+
+// Diff is a single diff uploaded to a revision, as returned by "differential.diff.search".
+type Diff struct {
+	ID     int    `json:"id"`
+	PHID   string `json:"phid"`
+	Fields struct {
+		RevisionPHID string    `json:"revisionPHID"`
+		DateCreated  int64     `json:"dateCreated"`
+		Refs         []DiffRef `json:"refs"`
+	} `json:"fields"`
+	Attachments struct {
+		Commits struct {
+			Commits []struct {
+				Identifier string `json:"identifier"`
+			} `json:"commits"`
+		} `json:"commits"`
+	} `json:"attachments"`
+}
+
+// ref returns the identifier, or failing that the name, of the diff's ref of the given type.
+func (diff Diff) ref(refType string) string {
+	for _, ref := range diff.Fields.Refs {
+		if ref.Type == refType {
+			if ref.Identifier != "" {
+				return ref.Identifier
+			}
+			return ref.Name
+		}
+	}
+	return ""
+}
+
+// Commit returns the commit that the diff was uploaded from, or an empty string if it is not known.
+func (diff Diff) Commit() string {
+	if commits := diff.Attachments.Commits.Commits; len(commits) > 0 {
+		return commits[0].Identifier
+	}
+	return ""
+}
+
+// TransactionComment is a version of the text of a comment made in a transaction.
+type TransactionComment struct {
+	PHID    string `json:"phid"`
+	Removed bool   `json:"removed"`
+	Content struct {
+		Raw string `json:"raw"`
+	} `json:"content"`
+}
+
+// Transaction is a single action taken on a revision, as returned by "transaction.search".
+type Transaction struct {
+	ID          int    `json:"id"`
+	Type        string `json:"type"`
+	AuthorPHID  string `json:"authorPHID"`
+	DateCreated int64  `json:"dateCreated"`
+	// Comments holds each version of the transaction's comment, with the latest one first.
+	Comments []TransactionComment `json:"comments"`
+	Fields   struct {
+		Diff *struct {
+			ID int `json:"id"`
+		} `json:"diff"`
+		Path               string `json:"path"`
+		Line               uint32 `json:"line"`
+		ReplyToCommentPHID string `json:"replyToCommentPHID"`
+	} `json:"fields"`
+}
+
+// text returns the latest version of the transaction's comment, or an empty string if it has none.
+func (transaction Transaction) text() string {
+	if len(transaction.Comments) == 0 || transaction.Comments[0].Removed {
+		return ""
+	}
+	return transaction.Comments[0].Content.Raw
+}
+
+// User is a Phabricator user, as returned by "user.search".
+type User struct {
+	PHID   string `json:"phid"`
+	Fields struct {
+		Username string `json:"username"`
+		RealName string `json:"realName"`
+	} `json:"fields"`
+}
+
+// Dump holds all of the data about the revisions of a repository needed to import them.
+//
+// It can be saved as JSON, and imported later without access to the server.
+type Dump struct {
+	Revisions []Revision `json:"revisions"`
+	Diffs     []Diff     `json:"diffs"`
+	// Transactions maps the PHID of each revision to its transactions.
+	Transactions map[string][]Transaction `json:"transactions"`
+	Users        []User                   `json:"users"`
+	// LandedCommits maps the PHID of each landed revision to the hash of the commit it landed as.
+	LandedCommits map[string]string `json:"landedCommits,omitempty"`
+}
+
+// ParseDump parses a dump previously saved as JSON.
+func ParseDump(data []byte) (*Dump, error) {
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, fmt.Errorf("Failed to parse the Phabricator dump: %v", err)
+	}
+	return &dump, nil
+}
+
+// Client is a minimal client for the Phabricator Conduit API.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a new client for the Phabricator server at the given URL, authenticating with the given API token.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// call invokes a Conduit method, and parses its result into the given value.
+func (c *Client) call(method string, params map[string]interface{}, result interface{}) error {
+	withToken := map[string]interface{}{
+		"__conduit__": map[string]string{"token": c.Token},
+	}
+	for key, value := range params {
+		withToken[key] = value
+	}
+	encoded, err := json.Marshal(withToken)
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	form.Set("params", string(encoded))
+	form.Set("output", "json")
+	form.Set("__conduit__", "1")
+	resp, err := c.HTTPClient.PostForm(c.BaseURL+"/api/"+method, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Conduit call %s failed with %s: %s", method, resp.Status, strings.TrimSpace(string(contents)))
+	}
+	var response struct {
+		Result    json.RawMessage `json:"result"`
+		ErrorCode string          `json:"error_code"`
+		ErrorInfo string          `json:"error_info"`
+	}
+	if err := json.Unmarshal(contents, &response); err != nil {
+		return err
+	}
+	if response.ErrorCode != "" {
+		return fmt.Errorf("Conduit call %s failed with %s: %s", method, response.ErrorCode, response.ErrorInfo)
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// search invokes a Conduit search method, passing each page of the results to the given function.
+func (c *Client) search(method string, params map[string]interface{}, page func(data json.RawMessage) error) error {
+	for {
+		var result struct {
+			Data   json.RawMessage `json:"data"`
+			Cursor struct {
+				After *string `json:"after"`
+			} `json:"cursor"`
+		}
+		if err := c.call(method, params, &result); err != nil {
+			return err
+		}
+		if err := page(result.Data); err != nil {
+			return err
+		}
+		if result.Cursor.After == nil || *result.Cursor.After == "" {
+			return nil
+		}
+		params["after"] = *result.Cursor.After
+	}
+}
+
+// resolveRepository returns the PHID of the repository with the given PHID, callsign, or short name.
+func (c *Client) resolveRepository(repository string) (string, error) {
+	if strings.HasPrefix(repository, "PHID-") {
+		return repository, nil
+	}
+	for _, constraint := range []string{"callsigns", "shortNames"} {
+		var result struct {
+			Data []struct {
+				PHID string `json:"phid"`
+			} `json:"data"`
+		}
+		params := map[string]interface{}{
+			"constraints": map[string]interface{}{constraint: []string{repository}},
+		}
+		if err := c.call("diffusion.repository.search", params, &result); err != nil {
+			return "", err
+		}
+		if len(result.Data) > 0 {
+			return result.Data[0].PHID, nil
+		}
+	}
+	return "", fmt.Errorf("There is no Phabricator repository named %q.", repository)
+}
+
+// Fetch reads everything about the revisions of the given repository that is needed to import them.
+//
+// The repository may be given as a PHID, a callsign, or a short name.
+func (c *Client) Fetch(repository string) (*Dump, error) {
+	repositoryPHID, err := c.resolveRepository(repository)
+	if err != nil {
+		return nil, err
+	}
+	dump := &Dump{
+		Transactions:  make(map[string][]Transaction),
+		LandedCommits: make(map[string]string),
+	}
+	revisionParams := map[string]interface{}{
+		"constraints": map[string]interface{}{"repositoryPHIDs": []string{repositoryPHID}},
+		"attachments": map[string]bool{"reviewers": true},
+	}
+	if err := c.search("differential.revision.search", revisionParams, func(data json.RawMessage) error {
+		var page []Revision
+		err := json.Unmarshal(data, &page)
+		dump.Revisions = append(dump.Revisions, page...)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if len(dump.Revisions) == 0 {
+		return dump, nil
+	}
+
+	var revisionPHIDs []string
+	userPHIDs := make(map[string]bool)
+	for _, revision := range dump.Revisions {
+		revisionPHIDs = append(revisionPHIDs, revision.PHID)
+		userPHIDs[revision.Fields.AuthorPHID] = true
+		for _, reviewer := range revision.Attachments.Reviewers.Reviewers {
+			userPHIDs[reviewer.ReviewerPHID] = true
+		}
+	}
+	diffParams := map[string]interface{}{
+		"constraints": map[string]interface{}{"revisionPHIDs": revisionPHIDs},
+		"attachments": map[string]bool{"commits": true},
+	}
+	if err := c.search("differential.diff.search", diffParams, func(data json.RawMessage) error {
+		var page []Diff
+		err := json.Unmarshal(data, &page)
+		dump.Diffs = append(dump.Diffs, page...)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	for _, revisionPHID := range revisionPHIDs {
+		transactionParams := map[string]interface{}{"objectIdentifier": revisionPHID}
+		if err := c.search("transaction.search", transactionParams, func(data json.RawMessage) error {
+			var page []Transaction
+			err := json.Unmarshal(data, &page)
+			for _, transaction := range page {
+				userPHIDs[transaction.AuthorPHID] = true
+			}
+			dump.Transactions[revisionPHID] = append(dump.Transactions[revisionPHID], page...)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	var users []string
+	for phid := range userPHIDs {
+		if strings.HasPrefix(phid, "PHID-USER-") {
+			users = append(users, phid)
+		}
+	}
+	sort.Strings(users)
+	if len(users) > 0 {
+		userParams := map[string]interface{}{
+			"constraints": map[string]interface{}{"phids": users},
+		}
+		if err := c.search("user.search", userParams, func(data json.RawMessage) error {
+			var page []User
+			err := json.Unmarshal(data, &page)
+			dump.Users = append(dump.Users, page...)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.fetchLandedCommits(dump, revisionPHIDs); err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
+
+// fetchLandedCommits records the commits that the given revisions landed as in the dump.
+func (c *Client) fetchLandedCommits(dump *Dump, revisionPHIDs []string) error {
+	revisionsByCommitPHID := make(map[string]string)
+	edgeParams := map[string]interface{}{
+		"sourcePHIDs": revisionPHIDs,
+		"types":       []string{landedEdgeType},
+	}
+	if err := c.search("edge.search", edgeParams, func(data json.RawMessage) error {
+		var page []struct {
+			SourcePHID      string `json:"sourcePHID"`
+			DestinationPHID string `json:"destinationPHID"`
+		}
+		err := json.Unmarshal(data, &page)
+		for _, edge := range page {
+			revisionsByCommitPHID[edge.DestinationPHID] = edge.SourcePHID
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	if len(revisionsByCommitPHID) == 0 {
+		return nil
+	}
+	var commitPHIDs []string
+	for phid := range revisionsByCommitPHID {
+		commitPHIDs = append(commitPHIDs, phid)
+	}
+	sort.Strings(commitPHIDs)
+	commitParams := map[string]interface{}{
+		"constraints": map[string]interface{}{"phids": commitPHIDs},
+	}
+	return c.search("diffusion.commit.search", commitParams, func(data json.RawMessage) error {
+		var page []struct {
+			PHID   string `json:"phid"`
+			Fields struct {
+				Identifier string `json:"identifier"`
+			} `json:"fields"`
+		}
+		err := json.Unmarshal(data, &page)
+		for _, commit := range page {
+			dump.LandedCommits[revisionsByCommitPHID[commit.PHID]] = commit.Fields.Identifier
+		}
+		return err
+	})
+}
+
+// Options controls how revisions are converted into reviews.
+type Options struct {
+	// EmailDomain, if set, turns each username into an email address in that domain.
+	EmailDomain string
+	// TargetRef is the target of the reviews whose diffs do not record the branch they were made onto.
+	TargetRef string
+}
+
+// Converter translates the revisions in a dump into git-appraise reviews.
+type Converter struct {
+	options         Options
+	users           map[string]string
+	diffsByRevision map[string][]Diff
+	transactions    map[string][]Transaction
+	landedCommits   map[string]string
+}
+
+// NewConverter returns a converter for the revisions in the given dump.
+func NewConverter(dump *Dump, options Options) *Converter {
+	c := &Converter{
+		options:         options,
+		users:           make(map[string]string),
+		diffsByRevision: make(map[string][]Diff),
+		transactions:    dump.Transactions,
+		landedCommits:   dump.LandedCommits,
+	}
+	for _, user := range dump.Users {
+		identity := user.Fields.Username
+		if options.EmailDomain != "" {
+			identity += "@" + strings.TrimPrefix(options.EmailDomain, "@")
+		}
+		c.users[user.PHID] = identity
+	}
+	for _, diff := range dump.Diffs {
+		c.diffsByRevision[diff.Fields.RevisionPHID] = append(c.diffsByRevision[diff.Fields.RevisionPHID], diff)
+	}
+	for _, diffs := range c.diffsByRevision {
+		sort.Slice(diffs, func(i, j int) bool { return diffs[i].ID < diffs[j].ID })
+	}
+	return c
+}
+
+// identity returns the identity used in git-appraise metadata for the user with the given PHID.
+func (c *Converter) identity(phid string) string {
+	if identity, ok := c.users[phid]; ok {
+		return identity
+	}
+	return phid
+}
+
+func timestamp(seconds int64) string {
+	return mirror.Timestamp(time.Unix(seconds, 0))
+}
+
+// qualifyBranch returns the fully qualified name of the given branch.
+func qualifyBranch(branch string) string {
+	if branch == "" || strings.HasPrefix(branch, "refs/") {
+		return branch
+	}
+	return "refs/heads/" + branch
+}
+
+// Convert translates the given revision into the corresponding git-appraise review.
+func (c *Converter) Convert(revision Revision) mirror.Review {
+	diffs := c.diffsByRevision[revision.PHID]
+	status := revision.Fields.Status.Value
+	landed := ""
+	if status == statusLanded {
+		landed = c.landedCommits[revision.PHID]
+	}
+	result := mirror.Review{Revision: landed}
+	commitsByDiff := make(map[int]string)
+	for _, diff := range diffs {
+		if commit := diff.Commit(); commit != "" {
+			commitsByDiff[diff.ID] = commit
+			if result.Revision == "" {
+				result.Revision = commit
+			}
+		}
+	}
+
+	author := c.identity(revision.Fields.AuthorPHID)
+	var reviewers []string
+	for _, reviewer := range revision.Attachments.Reviewers.Reviewers {
+		reviewers = append(reviewers, c.identity(reviewer.ReviewerPHID))
+	}
+	description := revision.Fields.Title
+	if revision.Fields.Summary != "" {
+		description += "\n\n" + revision.Fields.Summary
+	}
+	targetRef := qualifyBranch(c.options.TargetRef)
+	var patchsets []request.Patchset
+	for _, diff := range diffs {
+		commit := commitsByDiff[diff.ID]
+		if commit == "" {
+			continue
+		}
+		if onto := qualifyBranch(diff.ref("onto")); onto != "" {
+			targetRef = onto
+		}
+		patchsets = append(patchsets, request.Patchset{
+			Timestamp: timestamp(diff.Fields.DateCreated),
+			Commit:    commit,
+			Base:      diff.ref("base"),
+		})
+		req := request.New(author, reviewers, qualifyBranch(diff.ref("branch")), targetRef, description)
+		req.Timestamp = timestamp(diff.Fields.DateCreated)
+		req.BaseCommit = diff.ref("base")
+		if commit != result.Revision {
+			req.Alias = commit
+		}
+		req.Patchsets = append([]request.Patchset(nil), patchsets...)
+		result.Requests = append(result.Requests, req)
+	}
+	if len(result.Requests) == 0 {
+		req := request.New(author, reviewers, "", targetRef, description)
+		req.Timestamp = timestamp(revision.Fields.DateCreated)
+		result.Requests = append(result.Requests, req)
+	}
+	if landed != "" || status == statusAbandoned {
+		// Record the final state of the revision as one last update to the request.
+		final := result.Requests[len(result.Requests)-1]
+		final.Timestamp = timestamp(revision.Fields.DateModified)
+		if landed != "" {
+			final.Alias = ""
+			final.BaseCommit = ""
+		} else {
+			final.TargetRef = ""
+		}
+		result.Requests = append(result.Requests, final)
+	}
+
+	transactions := append([]Transaction(nil), c.transactions[revision.PHID]...)
+	sort.SliceStable(transactions, func(i, j int) bool {
+		if transactions[i].DateCreated != transactions[j].DateCreated {
+			return transactions[i].DateCreated < transactions[j].DateCreated
+		}
+		return transactions[i].ID < transactions[j].ID
+	})
+	hashesByCommentPHID := make(map[string]string)
+	for _, transaction := range transactions {
+		var imported comment.Comment
+		text := transaction.text()
+		switch transaction.Type {
+		case "comment":
+			if text == "" {
+				continue
+			}
+			imported = comment.New(c.identity(transaction.AuthorPHID), text)
+		case "inline":
+			if text == "" {
+				continue
+			}
+			imported = comment.New(c.identity(transaction.AuthorPHID), text)
+			location := comment.Location{Path: transaction.Fields.Path}
+			if transaction.Fields.Diff != nil {
+				location.Commit = commitsByDiff[transaction.Fields.Diff.ID]
+			}
+			if transaction.Fields.Line != 0 {
+				location.Range = &comment.Range{StartLine: transaction.Fields.Line}
+			}
+			imported.Location = &location
+			imported.Parent = hashesByCommentPHID[transaction.Fields.ReplyToCommentPHID]
+		case "accept":
+			if text == "" {
+				text = "Accepted this revision."
+			}
+			imported = comment.New(c.identity(transaction.AuthorPHID), text)
+			resolved := true
+			imported.Resolved = &resolved
+		case "request-changes", "reject":
+			if text == "" {
+				text = "Requested changes to this revision."
+			}
+			imported = comment.New(c.identity(transaction.AuthorPHID), text)
+			resolved := false
+			imported.Resolved = &resolved
+		default:
+			continue
+		}
+		imported.Timestamp = timestamp(transaction.DateCreated)
+		if len(transaction.Comments) > 0 {
+			if hash, err := imported.Hash(); err == nil {
+				hashesByCommentPHID[transaction.Comments[0].PHID] = hash
+			}
+		}
+		result.Comments = append(result.Comments, imported)
+	}
+	return result
+}
+
+// Import imports every revision in the given dump into the local repo.
+//
+// Importing the same dump again does not write any new notes.
+func Import(repo repository.Repo, dump *Dump, options Options) []mirror.SyncResult {
+	converter := NewConverter(dump, options)
+	var results []mirror.SyncResult
+	for _, revision := range dump.Revisions {
+		result := mirror.SyncReview(repo, converter.Convert(revision), nil, nil)
+		result.Number = revision.ID
+		results = append(results, result)
+	}
+	return results
+}
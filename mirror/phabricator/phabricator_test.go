@@ -0,0 +1,200 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package phabricator
+
+import (
+	"encoding/json"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testRevisionLanded = `{"id": 1, "phid": "PHID-DREV-1", "fields": {"title": "Fix it", "summary": "Details",
+  "status": {"value": "published"}, "authorPHID": "PHID-USER-a", "dateCreated": 100, "dateModified": 400},
+  "attachments": {"reviewers": {"reviewers": [{"reviewerPHID": "PHID-USER-r", "status": "accepted"}]}}}`
+	testRevisionAbandoned = `{"id": 2, "phid": "PHID-DREV-2", "fields": {"title": "Try it",
+  "status": {"value": "abandoned"}, "authorPHID": "PHID-USER-a", "dateCreated": 150, "dateModified": 500},
+  "attachments": {"reviewers": {"reviewers": []}}}`
+	testDiffs = `[
+  {"id": 11, "phid": "PHID-DIFF-11", "fields": {"revisionPHID": "PHID-DREV-1", "dateCreated": 100,
+    "refs": [{"type": "branch", "name": "fix"}, {"type": "base", "identifier": "E"}, {"type": "onto", "name": "master"}]},
+    "attachments": {"commits": {"commits": [{"identifier": "F"}]}}},
+  {"id": 12, "phid": "PHID-DIFF-12", "fields": {"revisionPHID": "PHID-DREV-1", "dateCreated": 200,
+    "refs": [{"type": "branch", "name": "fix"}, {"type": "base", "identifier": "E"}]},
+    "attachments": {"commits": {"commits": [{"identifier": "I"}]}}},
+  {"id": 21, "phid": "PHID-DIFF-21", "fields": {"revisionPHID": "PHID-DREV-2", "dateCreated": 150, "refs": []},
+    "attachments": {"commits": {"commits": [{"identifier": "H"}]}}}]`
+	testTransactions = `[
+  {"id": 3, "type": "inline", "authorPHID": "PHID-USER-a", "dateCreated": 250,
+    "comments": [{"phid": "PHID-XCMT-3", "content": {"raw": "Done"}}],
+    "fields": {"diff": {"id": 11}, "path": "main.go", "line": 5, "replyToCommentPHID": "PHID-XCMT-2"}},
+  {"id": 2, "type": "inline", "authorPHID": "PHID-USER-r", "dateCreated": 120,
+    "comments": [{"phid": "PHID-XCMT-2", "content": {"raw": "Typo"}}],
+    "fields": {"diff": {"id": 11}, "path": "main.go", "line": 5}},
+  {"id": 4, "type": "accept", "authorPHID": "PHID-USER-r", "dateCreated": 300, "comments": [], "fields": {}},
+  {"id": 5, "type": "status", "authorPHID": "PHID-USER-r", "dateCreated": 300, "comments": [], "fields": {}}]`
+	testUsers = `[{"phid": "PHID-USER-a", "fields": {"username": "author"}}, {"phid": "PHID-USER-r", "fields": {"username": "reviewer"}}]`
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var params struct {
+			Conduit struct {
+				Token string `json:"token"`
+			} `json:"__conduit__"`
+			ObjectIdentifier string `json:"objectIdentifier"`
+			After            string `json:"after"`
+		}
+		if err := json.Unmarshal([]byte(req.FormValue("params")), &params); err != nil || params.Conduit.Token != "secret" {
+			w.Write([]byte(`{"result": null, "error_code": "ERR-INVALID-AUTH", "error_info": "Bad token."}`))
+			return
+		}
+		data, after := "[]", "null"
+		switch strings.TrimPrefix(req.URL.Path, "/api/") {
+		case "diffusion.repository.search":
+			data = `[{"phid": "PHID-REPO-1"}]`
+		case "differential.revision.search":
+			// Return the revisions in two pages, to exercise the cursor handling.
+			if params.After == "" {
+				data, after = "["+testRevisionLanded+"]", `"1"`
+			} else {
+				data = "[" + testRevisionAbandoned + "]"
+			}
+		case "differential.diff.search":
+			data = testDiffs
+		case "transaction.search":
+			if params.ObjectIdentifier == "PHID-DREV-1" {
+				data = testTransactions
+			}
+		case "user.search":
+			data = testUsers
+		case "edge.search":
+			data = `[{"sourcePHID": "PHID-DREV-1", "edgeType": "revision.commit", "destinationPHID": "PHID-CMIT-1"}]`
+		case "diffusion.commit.search":
+			data = `[{"phid": "PHID-CMIT-1", "fields": {"identifier": "J"}}]`
+		default:
+			t.Errorf("Unexpected Conduit call: %q", req.URL.Path)
+		}
+		w.Write([]byte(`{"result": {"data": ` + data + `, "cursor": {"after": ` + after + `}}, "error_code": null, "error_info": null}`))
+	}))
+}
+
+func TestFetch(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	if _, err := NewClient(server.URL, "wrong").Fetch("R"); err == nil || !strings.Contains(err.Error(), "ERR-INVALID-AUTH") {
+		t.Fatalf("Expected an authentication error, but got %v", err)
+	}
+	dump, err := NewClient(server.URL, "secret").Fetch("R")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dump.Revisions) != 2 || len(dump.Diffs) != 3 || len(dump.Users) != 2 {
+		t.Fatalf("Unexpected dump: %v", dump)
+	}
+	if len(dump.Transactions["PHID-DREV-1"]) != 4 || dump.LandedCommits["PHID-DREV-1"] != "J" {
+		t.Fatalf("Unexpected dump: %v", dump)
+	}
+}
+
+func newTestDump(t *testing.T) *Dump {
+	var dump Dump
+	data := `{"revisions": [` + testRevisionLanded + `, ` + testRevisionAbandoned + `], "diffs": ` + testDiffs +
+		`, "transactions": {"PHID-DREV-1": ` + testTransactions + `}, "users": ` + testUsers +
+		`, "landedCommits": {"PHID-DREV-1": "J"}}`
+	if err := json.Unmarshal([]byte(data), &dump); err != nil {
+		t.Fatal(err)
+	}
+	return &dump
+}
+
+func TestConvert(t *testing.T) {
+	converter := NewConverter(newTestDump(t), Options{EmailDomain: "example.com", TargetRef: "develop"})
+
+	landed := converter.Convert(newTestDump(t).Revisions[0])
+	if landed.Revision != "J" {
+		t.Fatalf("The landed revision was not anchored at its landed commit: %q", landed.Revision)
+	}
+	if len(landed.Requests) != 3 {
+		t.Fatalf("Unexpected requests: %v", landed.Requests)
+	}
+	first, last := landed.Requests[0], landed.Requests[2]
+	if first.Alias != "F" || first.ReviewRef != "refs/heads/fix" || first.TargetRef != "refs/heads/master" || first.BaseCommit != "E" {
+		t.Fatalf("Unexpected first request: %v", first)
+	}
+	if first.Requester != "author@example.com" || len(first.Reviewers) != 1 || first.Reviewers[0] != "reviewer@example.com" {
+		t.Fatalf("Unexpected identities: %v", first)
+	}
+	if last.Alias != "" || last.TargetRef != "refs/heads/master" || len(last.Patchsets) != 2 || last.Description != "Fix it\n\nDetails" {
+		t.Fatalf("Unexpected final request: %v", last)
+	}
+	var approved bool
+	var reply, typo string
+	for _, c := range landed.Comments {
+		switch c.Description {
+		case "Accepted this revision.":
+			approved = c.Resolved != nil && *c.Resolved && c.Author == "reviewer@example.com"
+		case "Typo":
+			if c.Location == nil || c.Location.Commit != "F" || c.Location.Path != "main.go" || c.Location.Range.StartLine != 5 {
+				t.Fatalf("Unexpected inline comment location: %v", c.Location)
+			}
+			typo, _ = c.Hash()
+		case "Done":
+			reply = c.Parent
+		}
+	}
+	if len(landed.Comments) != 3 || !approved {
+		t.Fatalf("Unexpected comments: %v", landed.Comments)
+	}
+	if typo == "" || reply != typo {
+		t.Fatalf("The reply was not threaded under its parent: %v", landed.Comments)
+	}
+
+	abandoned := converter.Convert(newTestDump(t).Revisions[1])
+	if abandoned.Revision != "H" || len(abandoned.Requests) != 2 {
+		t.Fatalf("Unexpected abandoned review: %v", abandoned)
+	}
+	if abandoned.Requests[0].TargetRef != "refs/heads/develop" || abandoned.Requests[1].TargetRef != "" {
+		t.Fatalf("Unexpected abandoned requests: %v", abandoned.Requests)
+	}
+}
+
+func TestImport(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	dump := newTestDump(t)
+
+	results := Import(repo, dump, Options{})
+	if len(results) != 2 || results[0].Error != "" || results[0].Number != 1 || results[0].Imported == 0 {
+		t.Fatalf("Unexpected import results: %v", results)
+	}
+	r, err := review.Get(repo, "J")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || r.Request.Requester != "author" || r.Resolved == nil || !*r.Resolved || len(r.Comments) != 2 {
+		t.Fatalf("The revision was not imported: %v", r)
+	}
+	results = Import(repo, dump, Options{})
+	if results[0].Imported != 0 || results[1].Imported != 0 {
+		t.Fatalf("Importing the same dump again wrote new notes: %v", results)
+	}
+}
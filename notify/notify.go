@@ -0,0 +1,310 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notify tells people about changes to the reviews in a repository.
+//
+// Events are derived from the current state of the open reviews, and every
+// event has a stable ID. A State records the IDs of the events that have
+// already been dispatched, so that repeatedly collecting the events (e.g.
+// from a post-receive hook, or a polling daemon) only reports new ones.
+package notify
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/event"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// EventReview is the type of an event for a newly requested (or published) review.
+	EventReview = "review"
+	// EventComment is the type of an event for a new comment on a review.
+	EventComment = "comment"
+	// EventApproval is the type of an event for a comment that accepts a review.
+	EventApproval = "approval"
+	// EventRejection is the type of an event for a comment that rejects a review.
+	EventRejection = "rejection"
+	// EventCIFailure is the type of an event for a failing CI report on the head of a review.
+	EventCIFailure = "ci-failure"
+	// EventCISuccess is the type of an event for a passing CI report on the head of a review.
+	EventCISuccess = "ci-success"
+	// EventTransfer is the type of an event for a review that was handed over to a new requester.
+	EventTransfer = "transfer"
+	// EventRerequest is the type of an event for a review that was requested again from some of its reviewers.
+	EventRerequest = "rerequest"
+	// EventNudge is the type of an event for a reminder about a review that is overdue for one of the objectives of the SLA.
+	EventNudge = "nudge"
+)
+
+// Event represents a single change to a review.
+type Event struct {
+	ID          string   `json:"id"`
+	Type        string   `json:"type"`
+	Revision    string   `json:"revision"`
+	Description string   `json:"description,omitempty"`
+	TargetRef   string   `json:"targetRef,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	Requester   string   `json:"requester,omitempty"`
+	Reviewers   []string `json:"reviewers,omitempty"`
+	// Author is the user who caused the event, and Message describes what they did.
+	Author    string `json:"author,omitempty"`
+	Message   string `json:"message,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// Subject returns a one-line summary of the event.
+func (event Event) Subject() string {
+	description := strings.SplitN(strings.TrimSpace(event.Description), "\n", 2)[0]
+	switch event.Type {
+	case EventReview:
+		return fmt.Sprintf("Review %.12s requested by %s: %s", event.Revision, event.Author, description)
+	case EventComment:
+		return fmt.Sprintf("%s commented on review %.12s: %s", event.Author, event.Revision, description)
+	case EventApproval:
+		return fmt.Sprintf("%s accepted review %.12s: %s", event.Author, event.Revision, description)
+	case EventRejection:
+		return fmt.Sprintf("%s rejected review %.12s: %s", event.Author, event.Revision, description)
+	case EventCIFailure:
+		return fmt.Sprintf("CI agent %s failed on review %.12s: %s", event.Author, event.Revision, description)
+	case EventCISuccess:
+		return fmt.Sprintf("CI agent %s passed on review %.12s: %s", event.Author, event.Revision, description)
+	case EventTransfer:
+		return fmt.Sprintf("%s transferred review %.12s to %s: %s", event.Author, event.Revision, event.Requester, description)
+	case EventRerequest:
+		return fmt.Sprintf("%s requested review %.12s again: %s", event.Author, event.Revision, description)
+	case EventNudge:
+		return fmt.Sprintf("Review %.12s is overdue: %s", event.Revision, description)
+	}
+	return fmt.Sprintf("Review %.12s updated: %s", event.Revision, description)
+}
+
+// Recipients returns the users involved in the review, other than the one who caused the event.
+func (event Event) Recipients() []string {
+	seen := map[string]bool{event.Author: true}
+	var recipients []string
+	for _, user := range append([]string{event.Requester}, event.Reviewers...) {
+		if user != "" && !seen[user] {
+			seen[user] = true
+			recipients = append(recipients, user)
+		}
+	}
+	return recipients
+}
+
+func newEvent(r *review.Summary, eventType, id string) Event {
+	return Event{
+		ID:          id,
+		Type:        eventType,
+		Revision:    r.Revision,
+		Description: r.Request.Description,
+		TargetRef:   r.Request.TargetRef,
+		Labels:      r.Request.Labels,
+		Requester:   r.Request.Requester,
+		Reviewers:   r.Request.Reviewers,
+	}
+}
+
+func collectThreads(r *review.Summary, threads []review.CommentThread, events []Event) []Event {
+	for _, thread := range threads {
+		event := newEvent(r, EventComment, "comment:"+thread.Hash)
+		location := thread.Comment.Location
+		if thread.Comment.Parent == "" && (location == nil || location.Path == "") && thread.Comment.Resolved != nil {
+			if *thread.Comment.Resolved {
+				event.Type = EventApproval
+			} else {
+				event.Type = EventRejection
+			}
+		}
+		event.Author = thread.Comment.Author
+		event.Message = thread.Comment.Description
+		event.Timestamp = thread.Comment.Timestamp
+		events = append(events, event)
+		events = collectThreads(r, thread.Children, events)
+	}
+	return events
+}
+
+// Collect returns the events for the given reviews.
+//
+// Drafts are skipped, as they are not yet ready to be seen by anyone else.
+func Collect(reviews []review.Summary) []Event {
+	var events []Event
+	for i := range reviews {
+		r := &reviews[i]
+		if r.IsDraft() {
+			continue
+		}
+		event := newEvent(r, EventReview, "review:"+r.Revision)
+		event.Author = r.Request.Requester
+		event.Timestamp = r.Request.Timestamp
+		events = append(events, event)
+		events = collectThreads(r, r.Comments, events)
+		events = collectReviewEvents(r, events)
+		events = collectCIReports(r, ci.StatusFailure, EventCIFailure, events)
+	}
+	return events
+}
+
+// CollectCISuccesses returns the events for the passing CI reports on the given reviews.
+//
+// These are not included by Collect, since most notifications are only
+// wanted for the builds that need attention.
+func CollectCISuccesses(reviews []review.Summary) []Event {
+	var events []Event
+	for i := range reviews {
+		if r := &reviews[i]; !r.IsDraft() {
+			events = collectCIReports(r, ci.StatusSuccess, EventCISuccess, events)
+		}
+	}
+	return events
+}
+
+// collectReviewEvents appends an event for each transfer, re-request, and nudge of the review.
+func collectReviewEvents(r *review.Summary, events []Event) []Event {
+	details, err := r.Details()
+	if err != nil {
+		return events
+	}
+	for _, e := range details.Events {
+		eventType := EventTransfer
+		switch e.Type {
+		case event.TypeRerequest:
+			eventType = EventRerequest
+		case event.TypeNudge:
+			eventType = EventNudge
+		}
+		id := fmt.Sprintf("%s:%s:%s:%s", eventType, r.Revision, e.Author, e.Timestamp)
+		collected := newEvent(r, eventType, id)
+		collected.Author = e.Author
+		collected.Message = e.Message
+		collected.Timestamp = e.Timestamp
+		events = append(events, collected)
+	}
+	return events
+}
+
+// collectCIReports appends an event for the latest report of each CI agent on the review, if it has the given status.
+func collectCIReports(r *review.Summary, status, eventType string, events []Event) []Event {
+	details, err := r.Details()
+	if err != nil {
+		return events
+	}
+	latest, err := ci.GetLatestCIReportsByAgent(details.Reports)
+	if err != nil {
+		return events
+	}
+	var agents []string
+	for agent := range latest {
+		agents = append(agents, agent)
+	}
+	sort.Strings(agents)
+	for _, agent := range agents {
+		report := latest[agent]
+		if report.Status != status {
+			continue
+		}
+		event := newEvent(r, eventType, fmt.Sprintf("ci:%s:%s:%s", r.Revision, agent, report.Timestamp))
+		event.Author = agent
+		event.Message = report.Log
+		event.URL = report.URL
+		event.Timestamp = report.Timestamp
+		events = append(events, event)
+	}
+	return events
+}
+
+// State records which events have already been dispatched.
+type State struct {
+	Seen map[string]bool
+}
+
+// NewState returns an empty state, in which no events have been dispatched.
+func NewState() *State {
+	return &State{Seen: make(map[string]bool)}
+}
+
+// Unseen returns the events that have not yet been recorded, and records them.
+func (state *State) Unseen(events []Event) []Event {
+	if state.Seen == nil {
+		state.Seen = make(map[string]bool)
+	}
+	var unseen []Event
+	for _, event := range events {
+		if !state.Seen[event.ID] {
+			state.Seen[event.ID] = true
+			unseen = append(unseen, event)
+		}
+	}
+	return unseen
+}
+
+// LoadState reads a previously saved state from the given file.
+func LoadState(file string) (*State, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	state := NewState()
+	if err := gob.NewDecoder(f).Decode(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Save writes the state to the given file.
+func (state *State) Save(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(state)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Dispatch sends every event to every sink.
+//
+// A failure to send to one sink does not prevent sending to the others; the
+// first such failure is returned once every event has been dispatched.
+func Dispatch(events []Event, sinks []Sink) error {
+	var firstErr error
+	for _, event := range events {
+		for _, sink := range sinks {
+			if err := sink.Send(event); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("Failed to send the notification for %s: %v", event.ID, err)
+			}
+		}
+	}
+	return firstErr
+}
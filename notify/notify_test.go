@@ -0,0 +1,148 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollect(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	events := Collect(review.ListOpen(repo))
+	types := make(map[string]int)
+	ids := make(map[string]bool)
+	for _, event := range events {
+		types[event.Type]++
+		if ids[event.ID] {
+			t.Fatalf("Duplicate event ID: %q", event.ID)
+		}
+		ids[event.ID] = true
+	}
+	if types[EventReview] != len(review.ListOpen(repo)) {
+		t.Fatalf("Unexpected number of review events: %v", types)
+	}
+	if types[EventApproval] == 0 {
+		t.Fatalf("Failed to collect the approvals: %v", types)
+	}
+}
+
+func TestStateUnseen(t *testing.T) {
+	events := []Event{{ID: "review:a"}, {ID: "comment:b"}}
+	state := NewState()
+	if unseen := state.Unseen(events); len(unseen) != 2 {
+		t.Fatalf("Unexpected unseen events on the first call: %v", unseen)
+	}
+	dir, err := ioutil.TempDir("", "notify-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state.gob")
+	if err := state.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unseen := loaded.Unseen(append(events, Event{ID: "comment:c"}))
+	if len(unseen) != 1 || unseen[0].ID != "comment:c" {
+		t.Fatalf("Unexpected unseen events after reloading: %v", unseen)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	event := Event{
+		ID:          "comment:c",
+		Type:        EventComment,
+		Revision:    "abcdef0123456789",
+		Description: "Fix the parser\n\nDetails",
+		Requester:   "alice",
+		Reviewers:   []string{"bob", "carol"},
+		Author:      "bob",
+		Message:     "Looks good",
+	}
+
+	var out bytes.Buffer
+	if err := (WriterSink{Writer: &out}).Send(event); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "[comment] bob commented on review abcdef012345: Fix the parser\n" {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+
+	var posted Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &posted)
+	}))
+	defer server.Close()
+	if err := (WebhookSink{URL: server.URL}).Send(event); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(posted, event) {
+		t.Fatalf("Unexpected posted event: %v", posted)
+	}
+
+	var recipients []string
+	var message string
+	sink := SMTPSink{
+		Addr: "localhost:25",
+		From: "appraise@example.com",
+		SendMail: func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+			recipients = to
+			message = string(msg)
+			return nil
+		},
+	}
+	if err := sink.Send(event); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recipients, []string{"alice", "carol"}) {
+		t.Fatalf("Unexpected recipients: %v", recipients)
+	}
+	if !strings.Contains(message, "Subject: bob commented on review abcdef012345") || !strings.Contains(message, "Looks good") {
+		t.Fatalf("Unexpected message: %q", message)
+	}
+
+	var command []string
+	desktop := DesktopSink{Run: func(name string, args ...string) error {
+		command = append([]string{name}, args...)
+		return nil
+	}}
+	if err := desktop.Send(event); err != nil {
+		t.Fatal(err)
+	}
+	if len(command) == 0 || !strings.Contains(command[len(command)-1], "Looks good") {
+		t.Fatalf("Unexpected desktop notification command: %q", command)
+	}
+	if name, args := desktopCommand("darwin", "title", `say "hi"`); name != "osascript" || args[1] != `display notification "say \"hi\"" with title "title"` {
+		t.Fatalf("Unexpected macOS notification command: %s %q", name, args)
+	}
+}
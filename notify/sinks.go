@@ -0,0 +1,146 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Sink is a destination for events.
+type Sink interface {
+	Send(event Event) error
+}
+
+// WriterSink writes a line describing each event to a writer, such as stdout.
+type WriterSink struct {
+	Writer io.Writer
+}
+
+// Send implements the Sink interface.
+func (sink WriterSink) Send(event Event) error {
+	_, err := fmt.Fprintf(sink.Writer, "[%s] %s\n", event.Type, event.Subject())
+	return err
+}
+
+// WebhookSink posts each event, encoded as JSON, to a URL.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// Send implements the Sink interface.
+func (sink WebhookSink) Send(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	client := sink.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(sink.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook %q returned %s.", sink.URL, resp.Status)
+	}
+	return nil
+}
+
+// SMTPSink emails each event.
+//
+// If no recipients are given, then each event is sent to the requester and
+// reviewers of the corresponding review.
+type SMTPSink struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+	To   []string
+	// SendMail sends the message; it defaults to smtp.SendMail.
+	SendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// Send implements the Sink interface.
+func (sink SMTPSink) Send(event Event) error {
+	to := sink.To
+	if len(to) == 0 {
+		to = event.Recipients()
+	}
+	if len(to) == 0 {
+		return nil
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", sink.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", event.Subject())
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&msg, "Review: %s\r\nTarget: %s\r\n", event.Revision, event.TargetRef)
+	if event.URL != "" {
+		fmt.Fprintf(&msg, "URL: %s\r\n", event.URL)
+	}
+	if event.Message != "" {
+		fmt.Fprintf(&msg, "\r\n%s\r\n", strings.Replace(event.Message, "\n", "\r\n", -1))
+	}
+	sendMail := sink.SendMail
+	if sendMail == nil {
+		sendMail = smtp.SendMail
+	}
+	return sendMail(sink.Addr, sink.Auth, sink.From, to, msg.Bytes())
+}
+
+// DesktopSink shows each event as a desktop notification.
+//
+// The notification is shown by running "notify-send", or "osascript" on macOS.
+type DesktopSink struct {
+	// Run runs the given command; it defaults to running it with os/exec.
+	Run func(name string, args ...string) error
+}
+
+// desktopCommand returns the command that shows a notification with the given title and body on the given OS.
+func desktopCommand(goos, title, body string) (string, []string) {
+	if goos == "darwin" {
+		return "osascript", []string{"-e", fmt.Sprintf("display notification %s with title %s", strconv.Quote(body), strconv.Quote(title))}
+	}
+	return "notify-send", []string{title, body}
+}
+
+// Send implements the Sink interface.
+func (sink DesktopSink) Send(event Event) error {
+	body := event.Subject()
+	if message := strings.TrimSpace(event.Message); message != "" {
+		body += "\n\n" + message
+	}
+	name, args := desktopCommand(runtime.GOOS, "git-appraise", body)
+	run := sink.Run
+	if run == nil {
+		run = func(name string, args ...string) error {
+			return exec.Command(name, args...).Run()
+		}
+	}
+	return run(name, args...)
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Route sends the events matching its conditions to a chat channel.
+//
+// An empty condition matches every event.
+type Route struct {
+	Channel   string
+	TargetRef string
+	Label     string
+}
+
+// ParseRoute parses a route of the form "<channel>[=target:<ref>|=label:<label>]".
+func ParseRoute(route string) (Route, error) {
+	parts := strings.SplitN(route, "=", 2)
+	result := Route{Channel: strings.TrimSpace(parts[0])}
+	if result.Channel == "" {
+		return Route{}, fmt.Errorf("Invalid route %q: no channel was given.", route)
+	}
+	if len(parts) == 1 {
+		return result, nil
+	}
+	condition := strings.SplitN(strings.TrimSpace(parts[1]), ":", 2)
+	if len(condition) != 2 || condition[1] == "" {
+		return Route{}, fmt.Errorf("Invalid route %q: expected a condition of the form target:<ref> or label:<label>.", route)
+	}
+	switch condition[0] {
+	case "target":
+		result.TargetRef = condition[1]
+	case "label":
+		result.Label = condition[1]
+	default:
+		return Route{}, fmt.Errorf("Invalid route %q: unknown condition %q.", route, condition[0])
+	}
+	return result, nil
+}
+
+func qualifyRef(ref string) string {
+	if strings.HasPrefix(ref, "refs/") {
+		return ref
+	}
+	return "refs/heads/" + ref
+}
+
+// Matches reports whether or not the given event should be sent along the route.
+func (route Route) Matches(event Event) bool {
+	if route.TargetRef != "" && qualifyRef(route.TargetRef) != qualifyRef(event.TargetRef) {
+		return false
+	}
+	if route.Label != "" {
+		for _, label := range event.Labels {
+			if label == route.Label {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// SlackSink posts each event to a Slack (or Mattermost) incoming webhook.
+//
+// If any routes are given, then each event is posted to the channel of every
+// matching route, and events that match no route are dropped. Otherwise,
+// every event is posted to the webhook's default channel.
+type SlackSink struct {
+	URL    string
+	Routes []Route
+	// WebURL is the base URL of the web dashboard, used to link to reviews.
+	WebURL string
+	Client *http.Client
+}
+
+type slackMessage struct {
+	Channel string `json:"channel,omitempty"`
+	Text    string `json:"text"`
+}
+
+// escapeSlack escapes the characters that have special meaning in Slack's message format.
+func escapeSlack(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// Text returns the message posted for the given event.
+func (sink SlackSink) Text(event Event) string {
+	review := fmt.Sprintf("%.12s", event.Revision)
+	if sink.WebURL != "" {
+		// This matches the path of the review pages served by the web dashboard.
+		review = fmt.Sprintf("<%s/review/%s|%s>", strings.TrimSuffix(sink.WebURL, "/"), event.Revision, review)
+	}
+	description := escapeSlack(strings.SplitN(strings.TrimSpace(event.Description), "\n", 2)[0])
+	author := escapeSlack(event.Author)
+	var text string
+	switch event.Type {
+	case EventReview:
+		text = fmt.Sprintf("%s requested review %s: %s", author, review, description)
+		if len(event.Reviewers) > 0 {
+			text += fmt.Sprintf("\nReview requested for %s", escapeSlack(strings.Join(event.Reviewers, ", ")))
+		}
+	case EventComment:
+		text = fmt.Sprintf("%s commented on review %s: %s", author, review, description)
+	case EventApproval:
+		text = fmt.Sprintf(":white_check_mark: %s accepted review %s: %s", author, review, description)
+	case EventRejection:
+		text = fmt.Sprintf(":x: %s rejected review %s: %s", author, review, description)
+	case EventCIFailure:
+		text = fmt.Sprintf(":rotating_light: CI agent %s failed on review %s: %s", author, review, description)
+		if event.URL != "" {
+			text += fmt.Sprintf(" (<%s|build>)", event.URL)
+		}
+	case EventTransfer:
+		text = fmt.Sprintf("%s transferred review %s to %s: %s", author, review, escapeSlack(event.Requester), description)
+	case EventRerequest:
+		text = fmt.Sprintf("%s requested review %s again: %s", author, review, description)
+	default:
+		text = fmt.Sprintf("Review %s updated: %s", review, description)
+	}
+	if event.Message != "" && event.Type != EventCIFailure {
+		text += "\n>" + strings.Replace(escapeSlack(strings.TrimSpace(event.Message)), "\n", "\n>", -1)
+	}
+	return text
+}
+
+// channels returns the channels that the given event should be posted to,
+// where the empty string denotes the webhook's default channel.
+func (sink SlackSink) channels(event Event) []string {
+	if len(sink.Routes) == 0 {
+		return []string{""}
+	}
+	seen := make(map[string]bool)
+	var channels []string
+	for _, route := range sink.Routes {
+		if route.Matches(event) && !seen[route.Channel] {
+			seen[route.Channel] = true
+			channels = append(channels, route.Channel)
+		}
+	}
+	return channels
+}
+
+// Send implements the Sink interface.
+func (sink SlackSink) Send(event Event) error {
+	client := sink.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	text := sink.Text(event)
+	for _, channel := range sink.channels(event) {
+		body, err := json.Marshal(slackMessage{Channel: channel, Text: text})
+		if err != nil {
+			return err
+		}
+		resp, err := client.Post(sink.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("Slack webhook returned %s.", resp.Status)
+		}
+	}
+	return nil
+}
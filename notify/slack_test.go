@@ -0,0 +1,96 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseRoute(t *testing.T) {
+	for value, expected := range map[string]Route{
+		"#general":                   {Channel: "#general"},
+		"#release=target:release":    {Channel: "#release", TargetRef: "release"},
+		"#backend=label:backend":     {Channel: "#backend", Label: "backend"},
+		" #ops = target:refs/tags/x": {Channel: "#ops", TargetRef: "refs/tags/x"},
+	} {
+		route, err := ParseRoute(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if route != expected {
+			t.Fatalf("Unexpected route for %q: %+v", value, route)
+		}
+	}
+	for _, value := range []string{"", "=label:x", "#a=label:", "#a=author:bob"} {
+		if _, err := ParseRoute(value); err == nil {
+			t.Fatalf("Failed to reject the invalid route %q", value)
+		}
+	}
+}
+
+func TestSlackSink(t *testing.T) {
+	var posted []slackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var message slackMessage
+		json.Unmarshal(body, &message)
+		posted = append(posted, message)
+	}))
+	defer server.Close()
+
+	sink := SlackSink{
+		URL: server.URL,
+		Routes: []Route{
+			{Channel: "#release", TargetRef: "release"},
+			{Channel: "#backend", Label: "backend"},
+			{Channel: "#backend", TargetRef: "refs/heads/release"},
+		},
+		WebURL: "http://localhost:8080/",
+	}
+	event := Event{
+		Type:        EventReview,
+		Revision:    "abcdef0123456789",
+		Description: "Fix <the> parser",
+		TargetRef:   "refs/heads/release",
+		Labels:      []string{"backend"},
+		Author:      "alice",
+		Reviewers:   []string{"bob"},
+	}
+	if err := sink.Send(event); err != nil {
+		t.Fatal(err)
+	}
+	text := "alice requested review <http://localhost:8080/review/abcdef0123456789|abcdef012345>: Fix &lt;the&gt; parser\nReview requested for bob"
+	expected := []slackMessage{{Channel: "#release", Text: text}, {Channel: "#backend", Text: text}}
+	if !reflect.DeepEqual(posted, expected) {
+		t.Fatalf("Unexpected messages: %+v", posted)
+	}
+
+	posted = nil
+	event.TargetRef = "refs/heads/master"
+	event.Labels = nil
+	if err := sink.Send(event); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 0 {
+		t.Fatalf("Unexpectedly posted an event matching no routes: %+v", posted)
+	}
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// notesCacheDir is the directory, relative to the git directory, under which the notes caches are stored.
+const notesCacheDir = "appraise/cache"
+
+// notesCacheVersion is the version of the format of the notes caches.
+//
+// This must be incremented whenever a change to the notes cache would make
+// the caches written before it wrong, so that those caches are rebuilt.
+const notesCacheVersion = 1
+
+// notesCache is the on-disk representation of all the notes under a single notes ref.
+type notesCache struct {
+	Version int
+	// Tip is the commit that the notes ref pointed to when the cache was written.
+	Tip   string
+	Notes map[string][]Note
+	// Pending holds the notes of the annotated objects that were not commits when they
+	// were read, such as commits that had not been fetched yet. They are checked again
+	// every time that the cache is read, and moved to Notes once they are commits.
+	Pending map[string][]Note
+}
+
+// notesCachePath returns the path of the file used to cache the notes under the given ref.
+func (repo *GitRepo) notesCachePath(notesRef string) (string, error) {
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gitDir, notesCacheDir, filepath.FromSlash(notesRef)+".gob"), nil
+}
+
+// readNotesCache reads the cache at the given path, returning nil if it is missing or unreadable.
+func readNotesCache(path string) *notesCache {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var cache notesCache
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&cache); err != nil || cache.Version != notesCacheVersion {
+		return nil
+	}
+	return &cache
+}
+
+// writeNotesCache writes the given cache to the given path.
+//
+// The cache is written to a temporary file which is then renamed, so
+// that concurrent readers never see a partially written cache.
+func writeNotesCache(path string, cache *notesCache) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = gob.NewEncoder(w).Encode(cache)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// changedNotes returns an overview of the notes that differ between two commits of a notes ref,
+// along with the list of annotated objects whose notes were removed.
+func (repo *GitRepo) changedNotes(oldTip, newTip string) (*notesOverview, []string, error) {
+	out, err := repo.runGitCommand("diff-tree", "-r", "--no-renames", oldTip, newTip)
+	if err != nil {
+		return nil, nil, err
+	}
+	var mappings []*notesMapping
+	var objHashes, notesHashes []*string
+	var removed []string
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+		// Each line is of the form ":<old mode> <new mode> <old hash> <new hash> <status>\t<path>"
+		parts := strings.SplitN(line, "\t", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) != 2 || len(fields) != 5 {
+			return nil, nil, fmt.Errorf("Malformed output line from 'git diff-tree': %q", line)
+		}
+		// Notes trees may fan out the annotated object's hash into nested directories.
+		objHash := strings.Replace(parts[1], "/", "", -1)
+		if fields[4] == "D" {
+			removed = append(removed, objHash)
+			continue
+		}
+		notesHash := fields[3]
+		mappings = append(mappings, &notesMapping{ObjectHash: &objHash, NotesHash: &notesHash})
+		objHashes = append(objHashes, &objHash)
+		notesHashes = append(notesHashes, &notesHash)
+	}
+	return &notesOverview{
+		NotesMappings:      mappings,
+		ObjectHashesReader: stringsReader(objHashes),
+		NotesHashesReader:  stringsReader(notesHashes),
+	}, removed, nil
+}
+
+// updateNotesCache brings the given cache up to date with the given tip of its notes ref,
+// by reading only the notes that have changed since the cache was written.
+//
+// It reports whether the cache was changed.
+func (repo *GitRepo) updateNotesCache(cache *notesCache, tip string) (bool, error) {
+	if cache.Notes == nil {
+		cache.Notes = make(map[string][]Note)
+	}
+	if cache.Pending == nil {
+		cache.Pending = make(map[string][]Note)
+	}
+	updated := false
+	if cache.Tip != tip {
+		changed, removed, err := repo.changedNotes(cache.Tip, tip)
+		if err != nil {
+			return false, err
+		}
+		for _, objHash := range removed {
+			delete(cache.Notes, objHash)
+			delete(cache.Pending, objHash)
+		}
+		if len(changed.NotesMappings) > 0 {
+			commitNotes, otherNotes, err := changed.readAllNotes(repo)
+			if err != nil {
+				return false, err
+			}
+			for objHash, notes := range commitNotes {
+				cache.Notes[objHash] = notes
+				delete(cache.Pending, objHash)
+			}
+			for objHash, notes := range otherNotes {
+				cache.Pending[objHash] = notes
+				delete(cache.Notes, objHash)
+			}
+		}
+		cache.Tip = tip
+		updated = true
+	}
+	if len(cache.Pending) > 0 {
+		promoted, err := repo.promotePendingNotes(cache)
+		if err != nil {
+			return false, err
+		}
+		updated = updated || promoted
+	}
+	return updated, nil
+}
+
+// promotePendingNotes moves the pending notes of the objects that are now commits,
+// such as ones that were fetched after their notes, into the notes of the cache.
+//
+// It reports whether any notes were moved.
+func (repo *GitRepo) promotePendingNotes(cache *notesCache) (bool, error) {
+	var objHashes []*string
+	for objHash := range cache.Pending {
+		objHash := objHash
+		objHashes = append(objHashes, &objHash)
+	}
+	overview := &notesOverview{ObjectHashesReader: stringsReader(objHashes)}
+	isCommit, err := overview.getIsCommitMap(repo)
+	if err != nil {
+		return false, err
+	}
+	promoted := false
+	for objHash, notes := range cache.Pending {
+		if isCommit[objHash] {
+			cache.Notes[objHash] = notes
+			delete(cache.Pending, objHash)
+			promoted = true
+		}
+	}
+	return promoted, nil
+}
+
+// getCachedNotes returns all of the notes under the given ref, using (and refreshing) the on-disk cache.
+//
+// Any problems with the cache itself are ignored, in which case the notes are read directly.
+func (repo *GitRepo) getCachedNotes(notesRef, tip string) (map[string][]Note, error) {
+	path, err := repo.notesCachePath(notesRef)
+	if err != nil {
+		return nil, err
+	}
+	cache := readNotesCache(path)
+	if cache != nil && cache.Tip == tip && len(cache.Pending) == 0 {
+		return cache.Notes, nil
+	}
+	updated := true
+	if cache != nil {
+		updated, err = repo.updateNotesCache(cache, tip)
+	}
+	if cache == nil || err != nil {
+		overview, err := repo.notesOverview(notesRef)
+		if err != nil {
+			return nil, err
+		}
+		notes, pending, err := overview.readAllNotes(repo)
+		if err != nil {
+			return nil, err
+		}
+		cache = &notesCache{Version: notesCacheVersion, Tip: tip, Notes: notes, Pending: pending}
+		updated = true
+	}
+	if updated {
+		writeNotesCache(path, cache)
+	}
+	return cache.Notes, nil
+}
+
+// commitCache holds the metadata read from commits, which never changes once a
+// commit has been written, so that reading it again does not have to run git.
+//
+// It is safe for concurrent use.
+type commitCache struct {
+	mutex sync.Mutex
+	// formatted maps each format to the result of showing each commit hash in it.
+	formatted map[string]map[string]string
+}
+
+func newCommitCache() *commitCache {
+	return &commitCache{formatted: make(map[string]map[string]string)}
+}
+
+// get returns the cached result of showing the given commit in the given format, if there is one.
+func (c *commitCache) get(commit, format string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result, ok := c.formatted[format][commit]
+	return result, ok
+}
+
+// put caches the result of showing the given commit in the given format.
+func (c *commitCache) put(commit, format, result string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.formatted[format] == nil {
+		c.formatted[format] = make(map[string]string)
+	}
+	c.formatted[format][commit] = result
+}
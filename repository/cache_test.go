@@ -0,0 +1,103 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNotesCacheRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notes-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "refs", "notes", "pullrequests", "reviews.gob")
+	if readNotesCache(path) != nil {
+		t.Fatal("Unexpectedly read a cache that does not exist")
+	}
+	cache := &notesCache{
+		Version: notesCacheVersion,
+		Tip:     "ddbdcb9d5aa71d35de481789bacece9a2f8138d0",
+		Notes: map[string][]Note{
+			"de9ebcdf2a1e93365eefc2739f73f2c68a280c11": {Note(`{"v":0}`), Note(`{"v":1}`)},
+		},
+		Pending: map[string][]Note{
+			"2e0ec8ed5d1b4e2e1f4e3fd4c1f2ba740ab7b8cc": {Note(`{"v":0}`)},
+		},
+	}
+	if err := writeNotesCache(path, cache); err != nil {
+		t.Fatal(err)
+	}
+	read := readNotesCache(path)
+	if read == nil || !reflect.DeepEqual(read, cache) {
+		t.Fatalf("Unexpected cache contents: %+v", read)
+	}
+	if err := ioutil.WriteFile(path, []byte("not a cache"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if readNotesCache(path) != nil {
+		t.Fatal("Unexpectedly read a corrupt cache")
+	}
+}
+
+func TestCachedNotesOfCommitsFetchedLater(t *testing.T) {
+	origin := newTestRepo(t, 1)
+	defer os.RemoveAll(origin.Path)
+	dir, err := ioutil.TempDir("", "clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	clone := &GitRepo{Path: dir, commits: newCommitCache()}
+	if _, err := clone.runGitCommand("clone", origin.Path, "."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := origin.runGitCommand("commit", "--allow-empty", "-m", "Remote commit"); err != nil {
+		t.Fatal(err)
+	}
+	commit, err := origin.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const notesRef = "refs/notes/test"
+	if err := origin.AppendNote(notesRef, commit, Note("note")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The notes are fetched before the commit that they annotate.
+	if err := clone.FetchRefs(origin.Path, notesRef); err != nil {
+		t.Fatal(err)
+	}
+	if notes, err := clone.GetAllNotes(notesRef); err != nil || len(notes) != 0 {
+		t.Fatalf("Unexpected notes of a missing commit: %v, %v", notes, err)
+	}
+	if _, err := clone.runGitCommand("fetch", "origin"); err != nil {
+		t.Fatal(err)
+	}
+	notes, err := clone.GetAllNotes(notesRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes[commit]) == 0 || string(notes[commit][0]) != "note" {
+		t.Errorf("Unexpected notes once the commit was fetched: %v", notes)
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotesRefMissing is matched, by errors.Is, by the errors for operations that need notes refs which do not exist.
+	ErrNotesRefMissing = errors.New("The notes ref does not exist.")
+
+	// ErrSchemaVersion is matched, by errors.Is, by the errors for stored data in a format version that is not supported.
+	ErrSchemaVersion = errors.New("The format version is not supported.")
+)
+
+// NotesRefMissingError is returned when no notes refs match the given pattern.
+type NotesRefMissingError struct {
+	Pattern string
+}
+
+func (e *NotesRefMissingError) Error() string {
+	return fmt.Sprintf("There are no notes refs matching %q.", e.Pattern)
+}
+
+// Is reports whether the target is ErrNotesRefMissing.
+func (e *NotesRefMissingError) Is(target error) bool {
+	return target == ErrNotesRefMissing
+}
+
+// SchemaVersionError is returned when a note, or other stored item, has a format version newer than the tool supports.
+type SchemaVersionError struct {
+	// Kind describes the item, such as "CI report".
+	Kind string
+	// Version is the format version of the item.
+	Version int
+	// Supported is the latest format version that the tool supports.
+	Supported int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("Unsupported %s version %d; this tool supports up to version %d.", e.Kind, e.Version, e.Supported)
+}
+
+// Is reports whether the target is ErrSchemaVersion.
+func (e *SchemaVersionError) Is(target error) bool {
+	return target == ErrSchemaVersion
+}
@@ -20,14 +20,20 @@ package repository
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha1"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const branchRefPrefix = "refs/heads/"
@@ -35,16 +41,66 @@ const branchRefPrefix = "refs/heads/"
 // GitRepo represents an instance of a (local) git repository.
 type GitRepo struct {
 	Path string
+	ctx  context.Context
+	// commits caches the metadata of the commits that have been read, or is nil if they are not cached.
+	commits *commitCache
+	// env holds the environment variables that are added to that of every git command.
+	env []string
+}
+
+// gitCommandCount is the number of git commands that have been run by this process.
+var gitCommandCount int64
+
+// GitCommandCount returns the number of git commands that have been run by this process.
+//
+// This is meant for profiling, since running git is what usually dominates the time that a command takes.
+func GitCommandCount() int64 {
+	return atomic.LoadInt64(&gitCommandCount)
+}
+
+// Context returns the context that bounds the git commands run in the repo.
+func (repo *GitRepo) Context() context.Context {
+	if repo.ctx == nil {
+		return context.Background()
+	}
+	return repo.ctx
+}
+
+// WithContext returns a copy of the repo whose git commands are killed once the given context is done.
+func (repo *GitRepo) WithContext(ctx context.Context) Repo {
+	return &GitRepo{Path: repo.Path, ctx: ctx, commits: repo.commits, env: repo.env}
+}
+
+// WithAuthor returns a copy of the repo whose git commands author and commit as the given identity.
+func (repo *GitRepo) WithAuthor(name, email string) Repo {
+	env := append([]string(nil), repo.env...)
+	env = append(env,
+		"GIT_AUTHOR_NAME="+name, "GIT_AUTHOR_EMAIL="+email,
+		"GIT_COMMITTER_NAME="+name, "GIT_COMMITTER_EMAIL="+email)
+	return &GitRepo{Path: repo.Path, ctx: repo.ctx, commits: repo.commits, env: env}
 }
 
 // Run the given git command with the given I/O reader/writers, returning an error if it fails.
+//
+// If the repo's context is done before the command finishes, then the command is killed and the context's error is returned.
 func (repo *GitRepo) runGitCommandWithIO(stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
-	cmd := exec.Command("git", args...)
+	ctx := repo.Context()
+	atomic.AddInt64(&gitCommandCount, 1)
+	cmd := exec.CommandContext(ctx, "git", args...)
 	cmd.Dir = repo.Path
+	if repo.env != nil {
+		cmd.Env = append(os.Environ(), repo.env...)
+	}
 	cmd.Stdin = stdin
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
-	return cmd.Run()
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
 }
 
 // Run the given git command and return its stdout, or an error if the command fails.
@@ -55,14 +111,34 @@ func (repo *GitRepo) runGitCommandRaw(args ...string) (string, string, error) {
 	return strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String()), err
 }
 
+// Run the given git command with the given input, and return its stdout, or an error if the command fails.
+//
+// Passing large or multi-line text, such as notes and commit messages, through
+// the standard input rather than as an argument avoids both the limit on the
+// length of a command line and the differences in how it is quoted on Windows.
+func (repo *GitRepo) runGitCommandWithInput(input string, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	if err := repo.runGitCommandWithIO(strings.NewReader(input), &stdout, &stderr, args...); err != nil {
+		if err == repo.Context().Err() {
+			return "", err
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", errors.New("Error running git command: " + strings.Join(args, " "))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
 // Run the given git command and return its stdout, or an error if the command fails.
 func (repo *GitRepo) runGitCommand(args ...string) (string, error) {
 	stdout, stderr, err := repo.runGitCommandRaw(args...)
-	if err != nil {
+	if err != nil && err != repo.Context().Err() {
 		if stderr == "" {
 			stderr = "Error running git command: " + strings.Join(args, " ")
 		}
-		err = fmt.Errorf(stderr)
+		err = errors.New(stderr)
 	}
 	return stdout, err
 }
@@ -75,7 +151,7 @@ func (repo *GitRepo) runGitCommandInline(args ...string) error {
 // NewGitRepo determines if the given working directory is inside of a git repository,
 // and returns the corresponding GitRepo instance if it is.
 func NewGitRepo(path string) (*GitRepo, error) {
-	repo := &GitRepo{Path: path}
+	repo := &GitRepo{Path: path, commits: newCommitCache()}
 	_, _, err := repo.runGitCommandRaw("rev-parse")
 	if err == nil {
 		return repo, nil
@@ -91,6 +167,24 @@ func (repo *GitRepo) GetPath() string {
 	return repo.Path
 }
 
+// GetGitDir returns the absolute path of the repo's git directory.
+func (repo *GitRepo) GetGitDir() (string, error) {
+	gitDir, err := repo.runGitCommand("rev-parse", "--git-dir")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(repo.Path, gitDir)
+	}
+	return gitDir, nil
+}
+
+// IsBare reports whether the repo is bare, and so has no worktree.
+func (repo *GitRepo) IsBare() (bool, error) {
+	out, err := repo.runGitCommand("rev-parse", "--is-bare-repository")
+	return out == "true", err
+}
+
 // GetRepoStateHash returns a hash which embodies the entire current state of a repository.
 func (repo *GitRepo) GetRepoStateHash() (string, error) {
 	stateSummary, error := repo.runGitCommand("show-ref")
@@ -102,17 +196,163 @@ func (repo *GitRepo) GetUserEmail() (string, error) {
 	return repo.runGitCommand("config", "user.email")
 }
 
+// GetUserSigningKey returns the key that the user has configured git to sign with.
+func (repo *GitRepo) GetUserSigningKey() (string, error) {
+	signingKey, _ := repo.runGitCommand("config", "user.signingkey")
+	return signingKey, nil
+}
+
 // GetCoreEditor returns the name of the editor that the user has used to configure git.
 func (repo *GitRepo) GetCoreEditor() (string, error) {
 	return repo.runGitCommand("var", "GIT_EDITOR")
 }
 
+// GetCorePager returns the name of the pager that the user has used to configure git.
+func (repo *GitRepo) GetCorePager() (string, error) {
+	return repo.runGitCommand("var", "GIT_PAGER")
+}
+
 // GetSubmitStrategy returns the way in which a review is submitted
 func (repo *GitRepo) GetSubmitStrategy() (string, error) {
 	submitStrategy, _ := repo.runGitCommand("config", "appraise.submit")
 	return submitStrategy, nil
 }
 
+// GetCoverageThreshold returns the minimum code coverage percentage that a review
+// must have in order to be submitted, or 0 if there is no minimum.
+func (repo *GitRepo) GetCoverageThreshold() (float64, error) {
+	threshold, _ := repo.runGitCommand("config", "appraise.coverageThreshold")
+	if threshold == "" {
+		return 0, nil
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value %q for appraise.coverageThreshold; expected a percentage", threshold)
+	}
+	return percent, nil
+}
+
+// GetAssignStrategy returns the way in which reviewers are automatically assigned to a review.
+func (repo *GitRepo) GetAssignStrategy() (string, error) {
+	assignStrategy, _ := repo.runGitCommand("config", "appraise.assign")
+	return assignStrategy, nil
+}
+
+// GetReviewerPool returns the reviewers that may be automatically assigned to a review.
+//
+// These are read from every value of the "appraise.reviewers" config setting,
+// each of which may hold a comma-separated list of reviewers.
+func (repo *GitRepo) GetReviewerPool() ([]string, error) {
+	values, _ := repo.runGitCommand("config", "--get-all", "appraise.reviewers")
+	var reviewers []string
+	for _, line := range strings.Split(values, "\n") {
+		for _, reviewer := range strings.Split(line, ",") {
+			if reviewer = strings.TrimSpace(reviewer); reviewer != "" {
+				reviewers = append(reviewers, reviewer)
+			}
+		}
+	}
+	return reviewers, nil
+}
+
+// GetIssueTrackers returns the configuration of each issue tracker that review issue IDs are linked to.
+//
+// These are read from every value of the "appraise.issueTracker" config setting.
+func (repo *GitRepo) GetIssueTrackers() ([]string, error) {
+	values, _ := repo.runGitCommand("config", "--get-all", "appraise.issueTracker")
+	var trackers []string
+	for _, line := range strings.Split(values, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			trackers = append(trackers, line)
+		}
+	}
+	return trackers, nil
+}
+
+// GetAutoRequestPatterns returns the patterns of the branch names for which a
+// review is automatically requested when they are first pushed.
+//
+// These are read from every value of the "appraise.autoRequest" config setting,
+// each of which may hold a comma-separated list of patterns.
+func (repo *GitRepo) GetAutoRequestPatterns() ([]string, error) {
+	values, _ := repo.runGitCommand("config", "--get-all", "appraise.autoRequest")
+	var patterns []string
+	for _, line := range strings.Split(values, "\n") {
+		for _, pattern := range strings.Split(line, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return patterns, nil
+}
+
+// GetAutoRequestTarget returns the target ref of automatically requested
+// reviews, as configured in "appraise.autoRequestTarget".
+func (repo *GitRepo) GetAutoRequestTarget() (string, error) {
+	target, _ := repo.runGitCommand("config", "appraise.autoRequestTarget")
+	return target, nil
+}
+
+// GetAutoSyncRemote returns the remote that review notes are automatically
+// pulled from and pushed to by every command, as configured in "appraise.autoSync".
+//
+// The setting may name a remote, or be a boolean, in which case "true" means "origin".
+func (repo *GitRepo) GetAutoSyncRemote() (string, error) {
+	remote, _ := repo.runGitCommand("config", "appraise.autoSync")
+	return parseRemoteSetting(remote), nil
+}
+
+// GetAutoPushRemote returns the remote that review notes are automatically
+// pushed to by every command that changes them, as configured in "appraise.autoPush".
+//
+// Like "appraise.autoSync", the setting may name a remote or be a boolean.
+func (repo *GitRepo) GetAutoPushRemote() (string, error) {
+	remote, _ := repo.runGitCommand("config", "appraise.autoPush")
+	return parseRemoteSetting(remote), nil
+}
+
+// parseRemoteSetting interprets a config setting that either names a remote, or is a boolean meaning "origin".
+func parseRemoteSetting(remote string) string {
+	switch strings.ToLower(remote) {
+	case "", "false", "no", "off", "0":
+		return ""
+	case "true", "yes", "on", "1":
+		return "origin"
+	}
+	return remote
+}
+
+// GetConfig returns every value of the given git config setting.
+func (repo *GitRepo) GetConfig(key string) ([]string, error) {
+	values, _ := repo.runGitCommand("config", "--get-all", key)
+	var result []string
+	for _, line := range strings.Split(values, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			result = append(result, line)
+		}
+	}
+	return result, nil
+}
+
+// SetConfig replaces the values of the given git config setting, or unsets
+// it if no values are given. If global is set, then the user's global config
+// is changed instead of the repo's.
+func (repo *GitRepo) SetConfig(key string, global bool, values ...string) error {
+	args := []string{"config"}
+	if global {
+		args = append(args, "--global")
+	}
+	// Unsetting a setting that has no values fails, but is harmless.
+	repo.runGitCommandRaw(append(args, "--unset-all", key)...)
+	for _, value := range values {
+		if _, err := repo.runGitCommand(append(args, "--add", key, value)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // HasUncommittedChanges returns true if there are local, uncommitted changes.
 func (repo *GitRepo) HasUncommittedChanges() (bool, error) {
 	out, err := repo.runGitCommand("status", "--porcelain")
@@ -150,8 +390,10 @@ func (repo *GitRepo) GetHeadRef() (string, error) {
 }
 
 // GetCommitHash returns the hash of the commit pointed to by the given ref.
+//
+// Annotated tags are peeled to the commits that they tag.
 func (repo *GitRepo) GetCommitHash(ref string) (string, error) {
-	return repo.runGitCommand("show", "-s", "--format=%H", ref)
+	return repo.runGitCommand("show", "-s", "--format=%H", ref+"^{commit}")
 }
 
 // ResolveRefCommit returns the commit pointed to by the given ref, which may be a remote ref.
@@ -167,6 +409,13 @@ func (repo *GitRepo) ResolveRefCommit(ref string) (string, error) {
 	if err := repo.VerifyGitRef(ref); err == nil {
 		return repo.GetCommitHash(ref)
 	}
+	if IsCommitHash(ref) {
+		// Reviews of commits that are not on any branch record the commit itself.
+		if err := repo.VerifyCommit(ref); err != nil {
+			return "", err
+		}
+		return ref, nil
+	}
 	if strings.HasPrefix(ref, "refs/heads/") {
 		// The ref is a branch. Check if it exists in exactly one remote
 		pattern := strings.Replace(ref, "refs/heads", "**", 1)
@@ -184,14 +433,31 @@ func (repo *GitRepo) ResolveRefCommit(ref string) (string, error) {
 	return "", fmt.Errorf("Unknown git ref %q", ref)
 }
 
+// showCommit returns the given format of the metadata of the commit pointed to by the given ref.
+//
+// The result is cached when the ref is a commit hash, since that always names the same commit.
+func (repo *GitRepo) showCommit(ref, format string) (string, error) {
+	cacheable := repo.commits != nil && IsCommitHash(ref)
+	if cacheable {
+		if result, ok := repo.commits.get(ref, format); ok {
+			return result, nil
+		}
+	}
+	result, err := repo.runGitCommand("show", "-s", "--format="+format, ref)
+	if err == nil && cacheable {
+		repo.commits.put(ref, format, result)
+	}
+	return result, err
+}
+
 // GetCommitMessage returns the message stored in the commit pointed to by the given ref.
 func (repo *GitRepo) GetCommitMessage(ref string) (string, error) {
-	return repo.runGitCommand("show", "-s", "--format=%B", ref)
+	return repo.showCommit(ref, "%B")
 }
 
 // GetCommitTime returns the commit time of the commit pointed to by the given ref.
 func (repo *GitRepo) GetCommitTime(ref string) (string, error) {
-	return repo.runGitCommand("show", "-s", "--format=%ct", ref)
+	return repo.showCommit(ref, "%ct")
 }
 
 // GetLastParent returns the last parent of the given commit (as ordered by git).
@@ -199,36 +465,29 @@ func (repo *GitRepo) GetLastParent(ref string) (string, error) {
 	return repo.runGitCommand("rev-list", "--skip", "1", "-n", "1", ref)
 }
 
+// commitDetailsFormat is the format in which GetCommitDetails reads all of
+// the fields of a commit with a single git command. The fields are separated
+// by NUL characters, since those cannot occur in any of them.
+const commitDetailsFormat = "tformat:%T%x00%at%x00%an%x00%ae%x00%s%x00%P"
+
 // GetCommitDetails returns the details of a commit's metadata.
 func (repo GitRepo) GetCommitDetails(ref string) (*CommitDetails, error) {
-	var err error
-	show := func(formatString string) (result string) {
-		if err != nil {
-			return ""
-		}
-		result, err = repo.runGitCommand("show", "-s", ref, fmt.Sprintf("--format=tformat:%s", formatString))
-		return result
-	}
-
-	jsonFormatString := "{\"tree\":\"%T\", \"time\": \"%at\"}"
-	detailsJSON := show(jsonFormatString)
-	if err != nil {
-		return nil, err
-	}
-	var details CommitDetails
-	err = json.Unmarshal([]byte(detailsJSON), &details)
+	out, err := repo.showCommit(ref, commitDetailsFormat)
 	if err != nil {
 		return nil, err
 	}
-	details.Author = show("%an")
-	details.AuthorEmail = show("%ae")
-	details.Summary = show("%s")
-	parentsString := show("%P")
-	details.Parents = strings.Split(parentsString, " ")
-	if err != nil {
-		return nil, err
+	fields := strings.Split(out, "\x00")
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("Unexpected details of the commit %q: %q", ref, out)
 	}
-	return &details, nil
+	return &CommitDetails{
+		Tree:        fields[0],
+		Time:        fields[1],
+		Author:      fields[2],
+		AuthorEmail: fields[3],
+		Summary:     fields[4],
+		Parents:     strings.Split(fields[5], " "),
+	}, nil
 }
 
 // MergeBase determines if the first commit that is an ancestor of the two arguments.
@@ -248,6 +507,58 @@ func (repo *GitRepo) IsAncestor(ancestor, descendant string) (bool, error) {
 	return false, fmt.Errorf("Error while trying to determine commit ancestry: %v", err)
 }
 
+// GetLandingCommit returns the commit that brought the given commit into the history of the given ref.
+func (repo *GitRepo) GetLandingCommit(commit, ref string) (string, error) {
+	commit, err := repo.ResolveRefCommit(commit)
+	if err != nil {
+		return "", err
+	}
+	firstParents, err := repo.runGitCommand("rev-list", "--first-parent", ref)
+	if err != nil {
+		return "", err
+	}
+	descendants, err := repo.runGitCommand("rev-list", "--ancestry-path", commit+".."+ref)
+	if err != nil {
+		return "", err
+	}
+	isDescendant := map[string]bool{commit: true}
+	for _, descendant := range strings.Split(descendants, "\n") {
+		isDescendant[descendant] = true
+	}
+	landing := ""
+	for _, firstParent := range strings.Split(firstParents, "\n") {
+		if !isDescendant[firstParent] {
+			break
+		}
+		landing = firstParent
+	}
+	if landing == "" {
+		return "", fmt.Errorf("The commit %q is not in the history of %q.", commit, ref)
+	}
+	return landing, nil
+}
+
+// MergeConflicts returns the paths that would conflict if the second commit were merged into the first.
+//
+// This uses "git merge-tree --write-tree", which needs git 2.38 or later.
+func (repo *GitRepo) MergeConflicts(ours, theirs string) ([]string, error) {
+	stdout, stderr, err := repo.runGitCommandRaw("merge-tree", "--write-tree", "--name-only", "--no-messages", ours, theirs)
+	if err == nil {
+		return nil, nil
+	}
+	// An exit status of 1 means that the merge has conflicts, which are listed after the resulting tree.
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		lines := strings.Split(stdout, "\n")
+		if len(lines) > 1 {
+			return lines[1:], nil
+		}
+	}
+	if stderr == "" {
+		stderr = err.Error()
+	}
+	return nil, fmt.Errorf("Failed to check for merge conflicts: %s", stderr)
+}
+
 // Diff computes the diff between two given commits.
 func (repo *GitRepo) Diff(left, right string, diffArgs ...string) (string, error) {
 	args := []string{"diff"}
@@ -256,11 +567,164 @@ func (repo *GitRepo) Diff(left, right string, diffArgs ...string) (string, error
 	return repo.runGitCommand(args...)
 }
 
+// isSubmoduleMode reports whether the given file mode, as printed by "git diff --raw", is that of a submodule.
+func isSubmoduleMode(mode string) bool {
+	return strings.TrimPrefix(mode, ":") == "160000"
+}
+
+// parseSubmoduleChanges returns the changes to submodules listed in the output of "git diff --raw".
+func parseSubmoduleChanges(out string) []SubmoduleChange {
+	var changes []SubmoduleChange
+	for _, line := range strings.Split(out, "\n") {
+		// Each line is of the form ":<old mode> <new mode> <old hash> <new hash> <status>\t<path>".
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		info := strings.Fields(fields[0])
+		if len(info) < 4 || !(isSubmoduleMode(info[0]) || isSubmoduleMode(info[1])) {
+			continue
+		}
+		change := SubmoduleChange{Path: fields[1]}
+		if isSubmoduleMode(info[0]) {
+			change.OldCommit = info[2]
+		}
+		if isSubmoduleMode(info[1]) {
+			change.NewCommit = info[3]
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// ListSubmoduleChanges returns the submodules whose commits differ between two given commits.
+func (repo *GitRepo) ListSubmoduleChanges(left, right string) ([]SubmoduleChange, error) {
+	out, err := repo.runGitCommand("diff", "--raw", "--no-abbrev", "--no-renames", left, right)
+	if err != nil {
+		return nil, err
+	}
+	return parseSubmoduleChanges(out), nil
+}
+
+// zeroHash is the hash that "git diff --raw" prints for the missing side of an added or removed file.
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// parseBinaryChanges returns the changes to binary files listed in the output of "git diff --raw --numstat".
+//
+// The numstat lines of binary files have "-" in place of the numbers of added
+// and removed lines, and their blobs are taken from the matching raw lines.
+func parseBinaryChanges(out string) []BinaryChange {
+	blobs := make(map[string][2]string)
+	var changes []BinaryChange
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if strings.HasPrefix(line, ":") && len(fields) == 2 {
+			if info := strings.Fields(fields[0]); len(info) >= 4 {
+				blobs[fields[1]] = [2]string{info[2], info[3]}
+			}
+			continue
+		}
+		if len(fields) != 3 || fields[0] != "-" || fields[1] != "-" {
+			continue
+		}
+		change := BinaryChange{Path: fields[2]}
+		if hashes, ok := blobs[change.Path]; ok {
+			if hashes[0] != zeroHash {
+				change.OldBlob = hashes[0]
+			}
+			if hashes[1] != zeroHash {
+				change.NewBlob = hashes[1]
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// ListBinaryChanges returns the binary files that differ between two given commits.
+func (repo *GitRepo) ListBinaryChanges(left, right string) ([]BinaryChange, error) {
+	out, err := repo.runGitCommand("diff", "--raw", "--numstat", "--no-abbrev", "--no-renames", left, right)
+	if err != nil {
+		return nil, err
+	}
+	return parseBinaryChanges(out), nil
+}
+
+// GetSubmodule returns the repo checked out for the submodule at the given path.
+func (repo *GitRepo) GetSubmodule(path string) (Repo, error) {
+	top, err := repo.runGitCommand("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(top, path)
+	submodule := &GitRepo{Path: dir, ctx: repo.ctx, commits: newCommitCache()}
+	// The directory of a submodule that has not been checked out is part of the enclosing repo.
+	if subTop, err := submodule.runGitCommand("rev-parse", "--show-toplevel"); err != nil || filepath.Clean(subTop) != filepath.Clean(dir) {
+		return nil, fmt.Errorf("The submodule %q has not been checked out.", path)
+	}
+	return submodule, nil
+}
+
 // Show returns the contents of the given file at the given commit.
 func (repo *GitRepo) Show(commit, path string) (string, error) {
 	return repo.runGitCommand("show", fmt.Sprintf("%s:%s", commit, path))
 }
 
+// Blame returns the number of lines of the given file at the given commit
+// that were last changed by each author, keyed by the author's email.
+func (repo *GitRepo) Blame(commit, path string) (map[string]int, error) {
+	out, err := repo.runGitCommand("blame", "--line-porcelain", commit, "--", path)
+	if err != nil {
+		return nil, err
+	}
+	authors := make(map[string]int)
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "author-mail ") {
+			email := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(line, "author-mail "), "<"), ">")
+			authors[email]++
+		}
+	}
+	return authors, nil
+}
+
+// GetPatchIDs returns the stable patch ID of each of the given commits.
+func (repo *GitRepo) GetPatchIDs(commits []string) (map[string]string, error) {
+	patchIDs := make(map[string]string)
+	if len(commits) == 0 {
+		return patchIDs, nil
+	}
+	patches, err := repo.runGitCommand(append([]string{"log", "-p", "--no-walk=unsorted", "--no-merges", "--format=commit %H"}, commits...)...)
+	if err != nil {
+		return nil, err
+	}
+	out, err := repo.runGitCommandWithInput(patches+"\n", "patch-id", "--stable")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		// Each line is of the form "<patch ID> <commit>".
+		if fields := strings.Fields(line); len(fields) == 2 {
+			patchIDs[fields[1]] = fields[0]
+		}
+	}
+	return patchIDs, nil
+}
+
+// FormatPatch returns the given commit formatted as an email message, in the mbox format.
+func (repo *GitRepo) FormatPatch(commit string) (string, error) {
+	return repo.runGitCommand("format-patch", "-1", "--stdout", "--no-signature", commit)
+}
+
+// SetRef points the given ref at the given commit, or deletes the ref if the commit is empty.
+func (repo *GitRepo) SetRef(ref, commit string) error {
+	if commit == "" {
+		_, err := repo.runGitCommand("update-ref", "-d", ref)
+		return err
+	}
+	_, err := repo.runGitCommand("update-ref", ref, commit)
+	return err
+}
+
 // SwitchToRef changes the currently-checked-out ref.
 func (repo *GitRepo) SwitchToRef(ref string) error {
 	// If the ref starts with "refs/heads/", then we have to trim that prefix,
@@ -383,6 +847,91 @@ func (repo *GitRepo) RebaseRef(ref string) error {
 	return repo.runGitCommandInline("rebase", "-i", ref)
 }
 
+// AbortRebase abandons a rebase that stopped partway through, and restores the ref that was being rebased.
+func (repo *GitRepo) AbortRebase() error {
+	_, err := repo.runGitCommand("rebase", "--abort")
+	return err
+}
+
+// CherryPick applies the changes of the given commits, in order, as new commits on top of the current ref.
+//
+// The message of each new commit records the commit that it was picked from.
+func (repo *GitRepo) CherryPick(commits ...string) error {
+	return repo.runGitCommandInline(append([]string{"cherry-pick", "-x"}, commits...)...)
+}
+
+// AbortCherryPick abandons a cherry-pick that stopped partway through, and restores the current ref.
+func (repo *GitRepo) AbortCherryPick() error {
+	_, err := repo.runGitCommand("cherry-pick", "--abort")
+	return err
+}
+
+// SquashRef squashes the changes in the given ref into a single commit
+// on top of the current ref, using the given commit message.
+func (repo *GitRepo) SquashRef(ref, message string) error {
+	if _, err := repo.runGitCommand("merge", "--squash", ref); err != nil {
+		return err
+	}
+	_, err := repo.runGitCommandWithInput(message, "commit", "-F", "-")
+	return err
+}
+
+// ApplyPatch applies the given patch (in the unified diff format) to both
+// the working directory and the index.
+func (repo *GitRepo) ApplyPatch(patch string) error {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	if err := repo.runGitCommandWithIO(strings.NewReader(patch), &stdout, &stderr, "apply", "--index", "-"); err != nil {
+		return fmt.Errorf("Failed to apply the patch: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// ApplyPatchToTree applies the given patch (in the unified diff format) to
+// the tree of the given commit, and returns the hash of the resulting tree.
+//
+// This uses a temporary index file so that neither the working directory
+// nor the index are modified.
+func (repo *GitRepo) ApplyPatchToTree(commit, patch string) (string, error) {
+	dir, err := ioutil.TempDir("", "git-appraise-index")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	env := append(append(os.Environ(), repo.env...), "GIT_INDEX_FILE="+filepath.Join(dir, "index"))
+	run := func(stdin io.Reader, args ...string) (string, error) {
+		var stdout bytes.Buffer
+		var stderr bytes.Buffer
+		atomic.AddInt64(&gitCommandCount, 1)
+		cmd := exec.CommandContext(repo.Context(), "git", args...)
+		cmd.Dir = repo.Path
+		cmd.Env = env
+		cmd.Stdin = stdin
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", errors.New(strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	}
+	if _, err := run(nil, "read-tree", commit); err != nil {
+		return "", err
+	}
+	if _, err := run(strings.NewReader(patch), "apply", "--cached", "--3way", "-"); err != nil {
+		return "", fmt.Errorf("Failed to apply the patch: %v", err)
+	}
+	return run(nil, "write-tree")
+}
+
+// Commit creates a new commit from the current contents of the index, and
+// returns the hash of the newly created commit.
+func (repo *GitRepo) Commit(message string) (string, error) {
+	if _, err := repo.runGitCommandWithInput(message, "commit", "-F", "-"); err != nil {
+		return "", err
+	}
+	return repo.GetCommitHash("HEAD")
+}
+
 // ListCommits returns the list of commits reachable from the given ref.
 //
 // The generated list is in chronological order (with the oldest commit first).
@@ -430,14 +979,22 @@ func (repo *GitRepo) ListCommitsBetween(from, to string) ([]string, error) {
 
 // GetNotes uses the "git" command-line tool to read the notes from the given ref for a given revision.
 func (repo *GitRepo) GetNotes(notesRef, revision string) []Note {
-	var notes []Note
 	rawNotes, err := repo.runGitCommand("notes", "--ref", notesRef, "show", revision)
 	if err != nil {
 		// We just assume that this means there are no notes
 		return nil
 	}
-	for _, line := range strings.Split(rawNotes, "\n") {
-		notes = append(notes, Note([]byte(line)))
+	return splitNotes(rawNotes)
+}
+
+// splitNotes splits the contents of a notes object into its individual notes, one per line.
+//
+// Lines may also end with a carriage return, as they do when the notes were
+// edited or written by tools on Windows, which is dropped.
+func splitNotes(contents string) []Note {
+	var notes []Note
+	for _, line := range strings.Split(contents, "\n") {
+		notes = append(notes, Note(strings.TrimSuffix(line, "\r")))
 	}
 	return notes
 }
@@ -651,39 +1208,495 @@ func (repo *GitRepo) GetAllNotes(notesRef string) (map[string][]Note, error) {
 	//  1. One to list all the annotated objects (and their notes hash)
 	//  2. A second one to filter out all of the annotated objects that are not commits.
 	//  3. A final one to get the contents of all of the notes blobs.
-	overview, err := repo.notesOverview(notesRef)
+	//
+	// On top of that, the result is cached on disk keyed by the commit the
+	// notes ref points to, so that repeated calls only have to read the notes
+	// that changed since the last call.
+	tip, err := repo.GetCommitHash(notesRef)
 	if err != nil {
-		return nil, err
+		// The notes ref does not exist, so there is nothing to cache.
+		overview, err := repo.notesOverview(notesRef)
+		if err != nil {
+			return nil, err
+		}
+		return overview.readNotes(repo)
 	}
+	return repo.getCachedNotes(notesRef, tip)
+}
+
+// readNotes reads the notes for every commit included in the overview.
+func (overview *notesOverview) readNotes(repo *GitRepo) (map[string][]Note, error) {
+	commitNotesMap, _, err := overview.readAllNotes(repo)
+	return commitNotesMap, err
+}
+
+// readAllNotes reads the notes for every object included in the overview, and splits
+// them between the objects that are commits, and those that are not (or are missing).
+func (overview *notesOverview) readAllNotes(repo *GitRepo) (map[string][]Note, map[string][]Note, error) {
 	isCommit, err := overview.getIsCommitMap(repo)
 	if err != nil {
-		return nil, fmt.Errorf("Failure building the set of commit objects: %v", err)
+		return nil, nil, fmt.Errorf("Failure building the set of commit objects: %v", err)
 	}
 	noteContentsMap, err := overview.getNoteContentsMap(repo)
 	if err != nil {
-		return nil, fmt.Errorf("Failure building the mapping from notes hash to contents: %v", err)
+		return nil, nil, fmt.Errorf("Failure building the mapping from notes hash to contents: %v", err)
 	}
 	commitNotesMap := make(map[string][]Note)
+	otherNotesMap := make(map[string][]Note)
 	for _, notesMapping := range overview.NotesMappings {
-		if !isCommit[*notesMapping.ObjectHash] {
-			continue
-		}
 		noteBytes := noteContentsMap[*notesMapping.NotesHash]
-		byteSlices := bytes.Split(noteBytes, []byte("\n"))
-		var notes []Note
-		for _, slice := range byteSlices {
-			notes = append(notes, Note(slice))
+		if isCommit[*notesMapping.ObjectHash] {
+			commitNotesMap[*notesMapping.ObjectHash] = splitNotes(string(noteBytes))
+		} else {
+			otherNotesMap[*notesMapping.ObjectHash] = splitNotes(string(noteBytes))
 		}
-		commitNotesMap[*notesMapping.ObjectHash] = notes
 	}
 
-	return commitNotesMap, nil
+	return commitNotesMap, otherNotesMap, nil
+}
+
+// maxNotesWriteAttempts is the number of times that a write of the notes is attempted,
+// while other writes keep updating the notes ref first.
+const maxNotesWriteAttempts = 10
+
+// scratchNotesRefPrefix is the prefix of the private refs on which writes of the notes are prepared.
+const scratchNotesRefPrefix = "refs/notes/appraise-scratch/"
+
+// scratchNotesRefCount distinguishes the scratch refs of the writes made by a single process.
+var scratchNotesRefCount uint64
+
+// updateNotes updates the given notes ref, by running the given write on a copy of it.
+//
+// Git does not update the notes ref atomically with the notes that it reads, so
+// two processes writing to it at the same time can lose one of the writes. To
+// avoid that, the write is made to a private scratch ref that starts at the
+// current tip of the notes ref, and the notes ref is then moved to the new tip
+// of the scratch ref only if it still points to the commit that the write
+// started from. If someone else has updated it in the meantime, then the write
+// is made again on top of their notes, which merges the two, and retried. So
+// that the retried write does not undo theirs, it must compute what it writes
+// from the notes of the scratch ref, rather than from notes read beforehand.
+func (repo *GitRepo) updateNotes(notesRef string, write func(scratchRef string) error) error {
+	scratchRef := fmt.Sprintf("%s%d-%d", scratchNotesRefPrefix, os.Getpid(), atomic.AddUint64(&scratchNotesRefCount, 1))
+	defer repo.runGitCommand("update-ref", "-d", scratchRef)
+	for attempt := 1; ; attempt++ {
+		tip, err := repo.GetCommitHash(notesRef)
+		if err != nil {
+			// The notes ref does not exist yet, so it must still not exist when it is created.
+			tip = ""
+			repo.runGitCommand("update-ref", "-d", scratchRef)
+		} else if _, err := repo.runGitCommand("update-ref", scratchRef, tip); err != nil {
+			return err
+		}
+		if err := write(scratchRef); err != nil {
+			return err
+		}
+		updated, err := repo.GetCommitHash(scratchRef)
+		if err != nil || updated == tip {
+			// The write did not change the notes.
+			return err
+		}
+		_, _, err = repo.runGitCommandRaw("update-ref", notesRef, updated, tip)
+		if err == nil {
+			return nil
+		}
+		if current, _ := repo.GetCommitHash(notesRef); current == tip || attempt == maxNotesWriteAttempts {
+			return fmt.Errorf("Failed to update %q: %v", notesRef, err)
+		}
+		time.Sleep(time.Duration(attempt) * 10 * time.Millisecond)
+	}
 }
 
 // AppendNote appends a note to a revision under the given ref.
+//
+// Concurrent writes to the same notes ref are merged; see updateNotes.
 func (repo *GitRepo) AppendNote(notesRef, revision string, note Note) error {
-	_, err := repo.runGitCommand("notes", "--ref", notesRef, "append", "-m", string(note), revision)
-	return err
+	return repo.updateNotes(notesRef, func(scratchRef string) error {
+		_, err := repo.runGitCommandWithInput(string(note), "notes", "--ref", scratchRef, "append", "-F", "-", revision)
+		return err
+	})
+}
+
+// AppendNotes appends each of the given notes to the revision that it is keyed by, in a single commit of the notes ref.
+//
+// The notes are written with "git fast-import", which can only annotate commits.
+// Concurrent writes to the same notes ref are merged; see updateNotes.
+func (repo *GitRepo) AppendNotes(notesRef string, notes map[string][]Note) error {
+	var revisions []string
+	count := 0
+	for revision, revisionNotes := range notes {
+		if len(revisionNotes) > 0 {
+			revisions = append(revisions, revision)
+			count += len(revisionNotes)
+		}
+	}
+	if len(revisions) == 0 {
+		return nil
+	}
+	sort.Strings(revisions)
+	return repo.updateNotes(notesRef, func(scratchRef string) error {
+		return repo.appendNotesWithFastImport(scratchRef, revisions, notes, count)
+	})
+}
+
+// appendNotesWithFastImport appends the notes for the given revisions to the given notes ref, in a single commit.
+func (repo *GitRepo) appendNotesWithFastImport(notesRef string, revisions []string, notes map[string][]Note, count int) error {
+	existing := make(map[string]string)
+	tip, err := repo.GetCommitHash(notesRef)
+	if err == nil {
+		blobs, err := repo.listNoteBlobs(tip)
+		if err != nil {
+			return err
+		}
+		var mappings []*notesMapping
+		var notesHashes []*string
+		for _, revision := range revisions {
+			if blob, ok := blobs[revision]; ok {
+				revision := revision
+				mappings = append(mappings, &notesMapping{ObjectHash: &revision, NotesHash: &blob})
+				notesHashes = append(notesHashes, &blob)
+			}
+		}
+		overview := &notesOverview{NotesMappings: mappings, NotesHashesReader: stringsReader(notesHashes)}
+		contents, err := overview.getNoteContentsMap(repo)
+		if err != nil {
+			return fmt.Errorf("Failure reading the existing notes: %v", err)
+		}
+		for _, mapping := range mappings {
+			existing[*mapping.ObjectHash] = strings.TrimRight(string(contents[*mapping.NotesHash]), "\n")
+		}
+	} else {
+		tip = ""
+	}
+	ident, err := repo.runGitCommand("var", "GIT_COMMITTER_IDENT")
+	if err != nil {
+		return err
+	}
+	var stream bytes.Buffer
+	message := fmt.Sprintf("Notes added by 'git appraise' to %d objects", len(revisions))
+	fmt.Fprintf(&stream, "commit %s\ncommitter %s\ndata %d\n%s\n", notesRef, ident, len(message), message)
+	if tip != "" {
+		fmt.Fprintf(&stream, "from %s\n", tip)
+	}
+	for _, revision := range revisions {
+		var lines []string
+		for _, note := range notes[revision] {
+			lines = append(lines, string(note))
+		}
+		contents := strings.Join(lines, "\n") + "\n"
+		if previous := existing[revision]; previous != "" {
+			contents = previous + "\n" + contents
+		}
+		fmt.Fprintf(&stream, "N inline %s\ndata %d\n%s\n", revision, len(contents), contents)
+	}
+	var stderr bytes.Buffer
+	if err := repo.runGitCommandWithIO(&stream, ioutil.Discard, &stderr, "fast-import", "--quiet"); err != nil {
+		return fmt.Errorf("Failed to append %d notes to %q: %s", count, notesRef, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// setNotes replaces all of the notes annotating a revision under the given ref, or removes them if there are none.
+func (repo *GitRepo) setNotes(notesRef, revision string, notes []Note) error {
+	if len(notes) == 0 {
+		return repo.removeNotes(notesRef, []string{revision})
+	}
+	var contents []string
+	for _, note := range notes {
+		contents = append(contents, string(note))
+	}
+	var stderr bytes.Buffer
+	if err := repo.runGitCommandWithIO(strings.NewReader(strings.Join(contents, "\n")), ioutil.Discard, &stderr, "notes", "--ref", notesRef, "add", "-f", "-F", "-", revision); err != nil {
+		return fmt.Errorf("Failed to write the notes for %q: %s", revision, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// SetNotes replaces all of the notes annotating a revision under the given ref with the ones that update returns.
+//
+// Concurrent writes to the same notes ref are merged, by calling update again
+// with the notes that they left; see updateNotes.
+func (repo *GitRepo) SetNotes(notesRef, revision string, update func(notes []Note) []Note) error {
+	return repo.updateNotes(notesRef, func(scratchRef string) error {
+		return repo.setNotes(scratchRef, revision, update(repo.GetNotes(scratchRef, revision)))
+	})
+}
+
+// RewriteNotes replaces the notes annotating the given revision, and rewrites the history of the notes ref
+// so that none of its earlier versions remain reachable.
+//
+// The history is rewritten on the scratch ref of the write, so that concurrent
+// writes to the notes ref are merged as they are by SetNotes.
+//
+// The lines that the rewrite removes, along with those removed by the earlier rewrites,
+// are recorded in the commit message; see rewrittenNoteLines.
+func (repo *GitRepo) RewriteNotes(notesRef, revision string, update func(notes []Note) []Note, message string) error {
+	err := repo.updateNotes(notesRef, func(scratchRef string) error {
+		rewritten := make(map[string]bool)
+		if _, err := repo.GetCommitHash(scratchRef); err == nil {
+			if rewritten, err = repo.rewrittenNoteLines(scratchRef); err != nil {
+				return err
+			}
+		}
+		notes := repo.GetNotes(scratchRef, revision)
+		updated := update(notes)
+		kept := make(map[string]bool)
+		for _, note := range updated {
+			kept[noteLineHash(string(note))] = true
+		}
+		for _, note := range notes {
+			if hash := noteLineHash(string(note)); strings.TrimSpace(string(note)) != "" && !kept[hash] {
+				rewritten[hash] = true
+			}
+		}
+		if err := repo.setNotes(scratchRef, revision, updated); err != nil {
+			return err
+		}
+		tree, err := repo.runGitCommand("rev-parse", scratchRef+"^{tree}")
+		if err != nil {
+			return err
+		}
+		commit, err := repo.runGitCommandWithInput(message+rewrittenNoteLinesTrailers(rewritten), "commit-tree", tree)
+		if err != nil {
+			return err
+		}
+		_, err = repo.runGitCommand("update-ref", scratchRef, commit)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// Refresh the cache of the notes, so that it does not keep a copy of the old ones.
+	_, err = repo.GetAllNotes(notesRef)
+	return err
+}
+
+// MoveNotes moves the notes annotating the given objects from one notes ref to another.
+//
+// If an object is already annotated in the destination ref, then the moved
+// note is merged into the existing one by taking the union of their lines.
+// Objects that are not annotated in the source ref are skipped.
+//
+// Each ref is updated as it is by the other writes; see updateNotes. Since the
+// two refs cannot be updated together, a note that another write changes in
+// the source ref after it was copied is not removed, but copied again, which
+// merges the lines that were added to it.
+func (repo *GitRepo) MoveNotes(fromRef, toRef string, objects []string) error {
+	for attempt := 1; len(objects) > 0; attempt++ {
+		if _, err := repo.GetCommitHash(fromRef); err != nil {
+			// The source notes do not exist, so there is nothing to move
+			return nil
+		}
+		fromBlobs, err := repo.listNoteBlobs(fromRef)
+		if err != nil {
+			return err
+		}
+		// moved maps each object whose notes were copied to the blob of the notes that were copied.
+		var moved map[string]string
+		err = repo.updateNotes(toRef, func(scratchRef string) error {
+			moved = make(map[string]string)
+			toBlobs := make(map[string]string)
+			if _, err := repo.GetCommitHash(scratchRef); err == nil {
+				if toBlobs, err = repo.listNoteBlobs(scratchRef); err != nil {
+					return err
+				}
+			}
+			for _, object := range objects {
+				fromBlob, ok := fromBlobs[object]
+				if !ok {
+					continue
+				}
+				contents, err := repo.ReadBlob(fromBlob)
+				if err != nil {
+					return err
+				}
+				var existing []byte
+				if toBlob, ok := toBlobs[object]; ok {
+					if existing, err = repo.ReadBlob(toBlob); err != nil {
+						return err
+					}
+				}
+				merged := unionNoteLines(string(existing), string(contents))
+				var stderr bytes.Buffer
+				if err := repo.runGitCommandWithIO(strings.NewReader(merged), ioutil.Discard, &stderr, "notes", "--ref", scratchRef, "add", "-f", "-F", "-", object); err != nil {
+					return fmt.Errorf("Failed to move the notes for %q: %s", object, strings.TrimSpace(stderr.String()))
+				}
+				moved[object] = fromBlob
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		var changed []string
+		err = repo.updateNotes(fromRef, func(scratchRef string) error {
+			blobs, err := repo.listNoteBlobs(scratchRef)
+			if err != nil {
+				return err
+			}
+			changed = nil
+			var unchanged []string
+			for object, blob := range moved {
+				if current, ok := blobs[object]; ok && current != blob {
+					changed = append(changed, object)
+				} else {
+					unchanged = append(unchanged, object)
+				}
+			}
+			sort.Strings(unchanged)
+			return repo.removeNotes(scratchRef, unchanged)
+		})
+		if err != nil {
+			return err
+		}
+		if len(changed) > 0 && attempt == maxNotesWriteAttempts {
+			return fmt.Errorf("Failed to move the notes from %q, which kept changing.", fromRef)
+		}
+		sort.Strings(changed)
+		objects = changed
+	}
+	return nil
+}
+
+// RemapNotes moves the notes under the given ref from each object in the mapping to the object that it maps to.
+//
+// The notes are moved in a single update of the notes ref, so concurrent
+// writes to it are merged as they are by SetNotes; see updateNotes.
+func (repo *GitRepo) RemapNotes(notesRef string, mapping map[string]string) ([]string, error) {
+	if _, err := repo.GetCommitHash(notesRef); err != nil {
+		// The notes do not exist, so there is nothing to remap
+		return nil, nil
+	}
+	var remapped []string
+	err := repo.updateNotes(notesRef, func(scratchRef string) error {
+		remapped = nil
+		blobs, err := repo.listNoteBlobs(scratchRef)
+		if err != nil {
+			return err
+		}
+		for oldObject, newObject := range mapping {
+			oldBlob, ok := blobs[oldObject]
+			if !ok || oldObject == newObject {
+				continue
+			}
+			contents, err := repo.ReadBlob(oldBlob)
+			if err != nil {
+				return err
+			}
+			var existing []byte
+			if newBlob, ok := blobs[newObject]; ok {
+				if existing, err = repo.ReadBlob(newBlob); err != nil {
+					return err
+				}
+			}
+			merged := unionNoteLines(string(existing), string(contents))
+			if _, err := repo.runGitCommandWithInput(merged, "notes", "--ref", scratchRef, "add", "-f", "-F", "-", newObject); err != nil {
+				return fmt.Errorf("Failed to remap the notes for %q: %v", oldObject, err)
+			}
+			remapped = append(remapped, oldObject)
+		}
+		sort.Strings(remapped)
+		return repo.removeNotes(scratchRef, remapped)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return remapped, nil
+}
+
+// ListOrphanedNotes returns the objects annotated by notes in the given ref that are missing from the repo.
+func (repo *GitRepo) ListOrphanedNotes(notesRef string) ([]string, error) {
+	if _, err := repo.GetCommitHash(notesRef); err != nil {
+		// The notes do not exist, so none of them are orphaned
+		return nil, nil
+	}
+	overview, err := repo.notesOverview(notesRef)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	if err := repo.runGitCommandWithIO(overview.ObjectHashesReader, &stdout, &stderr, "cat-file", "--batch-check=%(objectname)"); err != nil {
+		return nil, fmt.Errorf("Failure performing a batch file check: %v", err)
+	}
+	var orphaned []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		// Missing objects are reported as "<object> missing".
+		if fields := strings.Fields(line); len(fields) == 2 && fields[1] == "missing" {
+			orphaned = append(orphaned, fields[0])
+		}
+	}
+	return orphaned, nil
+}
+
+// emptyTree is the hash of the tree with no entries, which every git repo implicitly contains.
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// GetAddedNotes returns the notes that were added between two commits of a notes ref,
+// keyed by the objects that they annotate.
+func (repo *GitRepo) GetAddedNotes(oldCommit, newCommit string) (map[string][]Note, error) {
+	oldTree := emptyTree
+	if oldCommit != "" {
+		oldTree = oldCommit
+	}
+	overview, _, err := repo.changedNotes(oldTree, newCommit)
+	if err != nil {
+		return nil, err
+	}
+	noteContentsMap, err := overview.getNoteContentsMap(repo)
+	if err != nil {
+		return nil, fmt.Errorf("Failure building the mapping from notes hash to contents: %v", err)
+	}
+	oldBlobs := make(map[string]string)
+	if oldCommit != "" {
+		if oldBlobs, err = repo.listNoteBlobs(oldCommit); err != nil {
+			return nil, err
+		}
+	}
+	added := make(map[string][]Note)
+	for _, mapping := range overview.NotesMappings {
+		existing := make(map[string]bool)
+		if blob, ok := oldBlobs[*mapping.ObjectHash]; ok {
+			contents, err := repo.ReadBlob(blob)
+			if err != nil {
+				return nil, err
+			}
+			for _, note := range splitNotes(string(contents)) {
+				existing[string(note)] = true
+			}
+		}
+		for _, note := range splitNotes(string(noteContentsMap[*mapping.NotesHash])) {
+			if !existing[string(note)] {
+				added[*mapping.ObjectHash] = append(added[*mapping.ObjectHash], note)
+			}
+		}
+	}
+	return added, nil
+}
+
+// StoreBlob writes the given contents to the repo as a blob, and returns its hash.
+func (repo *GitRepo) StoreBlob(notesRef string, contents []byte) (string, error) {
+	var stdout, stderr bytes.Buffer
+	if err := repo.runGitCommandWithIO(bytes.NewReader(contents), &stdout, &stderr, "hash-object", "-w", "--stdin"); err != nil {
+		return "", fmt.Errorf("Failed to store the blob: %s", strings.TrimSpace(stderr.String()))
+	}
+	hash := strings.TrimSpace(stdout.String())
+	err := repo.updateNotes(notesRef, func(scratchRef string) error {
+		_, err := repo.runGitCommand("notes", "--ref", scratchRef, "add", "-f", "-C", hash, hash)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// ReadBlob returns the contents of the blob with the given hash.
+func (repo *GitRepo) ReadBlob(hash string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	if err := repo.runGitCommandWithIO(nil, &stdout, &stderr, "cat-file", "blob", hash); err != nil {
+		return nil, fmt.Errorf("Failed to read the blob %q: %s", hash, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
 }
 
 // ListNotedRevisions returns the collection of revisions that are annotated by notes in the given ref.
@@ -709,8 +1722,26 @@ func (repo *GitRepo) ListNotedRevisions(notesRef string) []string {
 	return revisions
 }
 
+// verifyRefsMatch returns a *NotesRefMissingError if no refs match any of the given patterns.
+//
+// Pushing a refspec that matches nothing fails with a confusing message from
+// git, so this is checked first.
+func (repo *GitRepo) verifyRefsMatch(patterns ...string) error {
+	refs, err := repo.runGitCommand(append([]string{"for-each-ref", "--count=1", "--format=%(refname)"}, patterns...)...)
+	if err != nil {
+		return err
+	}
+	if refs == "" {
+		return &NotesRefMissingError{Pattern: patterns[0]}
+	}
+	return nil
+}
+
 // PushNotes pushes git notes to a remote repo.
 func (repo *GitRepo) PushNotes(remote, notesRefPattern string) error {
+	if err := repo.verifyRefsMatch(notesRefPattern); err != nil {
+		return fmt.Errorf("Failed to push to the remote '%s': %w", remote, err)
+	}
 	refspec := fmt.Sprintf("%s:%s", notesRefPattern, notesRefPattern)
 
 	// The push is liable to fail if the user forgot to do a pull first, so
@@ -722,8 +1753,48 @@ func (repo *GitRepo) PushNotes(remote, notesRefPattern string) error {
 	return nil
 }
 
+// PushRefs pushes the given refs to the refs of the same names in a remote repo.
+func (repo *GitRepo) PushRefs(remote string, force bool, refs ...string) error {
+	args := []string{"push"}
+	if force {
+		args = append(args, "--force")
+	}
+	args = append(args, remote)
+	for _, ref := range refs {
+		args = append(args, fmt.Sprintf("%s:%s", ref, ref))
+	}
+	if err := repo.runGitCommandInline(args...); err != nil {
+		return fmt.Errorf("Failed to push to the remote '%s': %v", remote, err)
+	}
+	return nil
+}
+
+// PushRefWithLease pushes the given ref to the ref of the same name in a remote repo, if it still points to the expected commit.
+func (repo *GitRepo) PushRefWithLease(remote, ref, expected string) error {
+	lease := fmt.Sprintf("--force-with-lease=%s:%s", ref, expected)
+	if err := repo.runGitCommandInline("push", lease, remote, fmt.Sprintf("%s:%s", ref, ref)); err != nil {
+		return fmt.Errorf("Failed to push to the remote '%s': %v", remote, err)
+	}
+	return nil
+}
+
+// FetchRefs fetches the given refs from the refs of the same names in a remote repo.
+func (repo *GitRepo) FetchRefs(remote string, refs ...string) error {
+	args := []string{"fetch", "--no-tags", remote}
+	for _, ref := range refs {
+		args = append(args, fmt.Sprintf("+%s:%s", ref, ref))
+	}
+	if _, err := repo.runGitCommand(args...); err != nil {
+		return fmt.Errorf("Failed to fetch from the remote '%s': %v", remote, err)
+	}
+	return nil
+}
+
 // PushNotesAndArchive pushes the given notes and archive refs to a remote repo.
 func (repo *GitRepo) PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	if err := repo.verifyRefsMatch(notesRefPattern, archiveRefPattern); err != nil {
+		return fmt.Errorf("Failed to push the local archive to the remote '%s': %w", remote, err)
+	}
 	notesRefspec := fmt.Sprintf("%s:%s", notesRefPattern, notesRefPattern)
 	archiveRefspec := fmt.Sprintf("%s:%s", archiveRefPattern, archiveRefPattern)
 	err := repo.runGitCommandInline("push", remote, notesRefspec, archiveRefspec)
@@ -738,6 +1809,299 @@ func getRemoteNotesRef(remote, localNotesRef string) string {
 	return "refs/notes/" + remote + "/" + relativeNotesRef
 }
 
+// unionNoteLines returns the lines of the first note followed by any lines of
+// the second note that are not already in it, leaving out blank and duplicate lines.
+func unionNoteLines(first, second string) string {
+	seen := make(map[string]bool)
+	var lines []string
+	for _, line := range strings.Split(first+"\n"+second, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// listNoteBlobs returns the mapping from each object annotated in the given notes commit to the blob holding its note.
+//
+// The notes commit may be given as either a ref or a hash.
+func (repo *GitRepo) listNoteBlobs(notesCommit string) (map[string]string, error) {
+	out, err := repo.runGitCommand("ls-tree", "-r", notesCommit)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		lineParts := strings.Split(line, "\t")
+		if len(lineParts) != 2 {
+			continue
+		}
+		if objectParts := strings.Fields(lineParts[0]); len(objectParts) == 3 && objectParts[1] == "blob" {
+			// Notes trees may fan out the annotated objects' hashes into subdirectories.
+			blobs[strings.Replace(lineParts[1], "/", "", -1)] = objectParts[2]
+		}
+	}
+	return blobs, nil
+}
+
+// RemoveNotes removes the notes annotating the given objects from the given notes ref, in a single commit.
+//
+// Concurrent writes to the same notes ref are merged; see updateNotes.
+func (repo *GitRepo) RemoveNotes(notesRef string, objects []string) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	return repo.updateNotes(notesRef, func(scratchRef string) error {
+		return repo.removeNotes(scratchRef, objects)
+	})
+}
+
+// removeNotes removes the notes annotating the given objects from the given notes ref, in a single commit.
+func (repo *GitRepo) removeNotes(notesRef string, objects []string) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	var stderr bytes.Buffer
+	stdin := strings.NewReader(strings.Join(objects, "\n") + "\n")
+	if err := repo.runGitCommandWithIO(stdin, ioutil.Discard, &stderr, "notes", "--ref", notesRef, "remove", "--ignore-missing", "--stdin"); err != nil {
+		return fmt.Errorf("Failed to remove the notes from %q: %s", notesRef, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// removedNoteLineTrailer is the trailer with which a rewrite of the notes records the hash of each line that it removed.
+const removedNoteLineTrailer = "Removed-note-line:"
+
+// noteLineHash returns the hash by which a rewrite of the notes records that it removed the given line.
+func noteLineHash(line string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.TrimSpace(line))))
+}
+
+// rewrittenNoteLines returns the hashes of the lines that were removed by the rewrites
+// in the histories of the given notes commits, which may be given as refs or hashes.
+//
+// A copy of the notes made before a rewrite still has those lines, and merging it
+// must not bring them back.
+func (repo *GitRepo) rewrittenNoteLines(notesCommits ...string) (map[string]bool, error) {
+	args := append([]string{"log", "--format=%B", "--grep=^" + removedNoteLineTrailer}, notesCommits...)
+	out, err := repo.runGitCommand(args...)
+	if err != nil {
+		return nil, err
+	}
+	rewritten := make(map[string]bool)
+	for _, line := range strings.Split(out, "\n") {
+		if hash := strings.TrimPrefix(line, removedNoteLineTrailer); hash != line {
+			rewritten[strings.TrimSpace(hash)] = true
+		}
+	}
+	return rewritten, nil
+}
+
+// rewrittenNoteLinesTrailers returns the trailers of a commit message that record the given hashes of removed lines.
+func rewrittenNoteLinesTrailers(rewritten map[string]bool) string {
+	if len(rewritten) == 0 {
+		return ""
+	}
+	var hashes []string
+	for hash := range rewritten {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	trailers := "\n"
+	for _, hash := range hashes {
+		trailers += "\n" + removedNoteLineTrailer + " " + hash
+	}
+	return trailers + "\n"
+}
+
+// dropRewrittenNoteLines returns the given note without the lines that a rewrite removed,
+// and reports whether there were any.
+func dropRewrittenNoteLines(note string, rewritten map[string]bool) (string, bool) {
+	if len(rewritten) == 0 {
+		return note, false
+	}
+	var kept []string
+	dropped := false
+	for _, line := range strings.Split(note, "\n") {
+		if strings.TrimSpace(line) != "" && rewritten[noteLineHash(line)] {
+			dropped = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n"), dropped
+}
+
+// writeMergedNote replaces the note annotating the given object with the given merged note,
+// or removes it if nothing is left of it.
+func (repo *GitRepo) writeMergedNote(notesRef, object, merged string) error {
+	if strings.TrimSpace(merged) == "" {
+		return repo.removeNotes(notesRef, []string{object})
+	}
+	var stderr bytes.Buffer
+	if err := repo.runGitCommandWithIO(strings.NewReader(merged), ioutil.Discard, &stderr, "notes", "--ref", notesRef, "add", "-f", "-F", "-", object); err != nil {
+		return fmt.Errorf("Failed to merge the notes for %q: %s", object, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// mergeNotes merges the remote notes ref into the local one.
+//
+// Notes are treated as append-only sets of lines (every git-appraise schema
+// fits that requirement), so the merged note for an object is the union of
+// its local and remote notes, with duplicate lines removed. Unlike "git notes
+// merge", this never needs a merge strategy to be configured, never stops to
+// resolve conflicts, and keeps existing lines in their original order.
+//
+// Notes that were removed on one side since the two sides diverged (e.g.
+// because they were moved to an archive ref), and left unchanged on the
+// other side, stay removed.
+//
+// Lines that a rewrite of either side removed (see RewriteNotes) are left out
+// of the merge, and a side that still had any of them is not recorded as a
+// parent of the merge, so that the lines do not stay reachable from its history.
+func (repo *GitRepo) mergeNotes(notesRef, remoteNotesRef string) error {
+	remoteHash, err := repo.GetCommitHash(remoteNotesRef)
+	if err != nil || remoteHash == "" {
+		// The remote notes do not exist, so we have nothing to do
+		return nil
+	}
+	// The merge is made on a scratch ref like every other write, so that it does not race with them.
+	return repo.updateNotes(notesRef, func(scratchRef string) error {
+		localHash, err := repo.GetCommitHash(scratchRef)
+		if err != nil || localHash == "" {
+			// The local notes do not exist, so we merely need to set them
+			_, err := repo.runGitCommand("update-ref", scratchRef, remoteHash)
+			return err
+		}
+		if isAncestor, err := repo.IsAncestor(remoteHash, localHash); err != nil || isAncestor {
+			return err
+		}
+		if isAncestor, err := repo.IsAncestor(localHash, remoteHash); err != nil {
+			return err
+		} else if isAncestor {
+			_, err := repo.runGitCommand("update-ref", scratchRef, remoteHash, localHash)
+			return err
+		}
+
+		localBlobs, err := repo.listNoteBlobs(scratchRef)
+		if err != nil {
+			return err
+		}
+		remoteBlobs, err := repo.listNoteBlobs(remoteNotesRef)
+		if err != nil {
+			return err
+		}
+		baseBlobs := make(map[string]string)
+		if base, err := repo.MergeBase(localHash, remoteHash); err == nil && base != "" {
+			if baseBlobs, err = repo.listNoteBlobs(base); err != nil {
+				return err
+			}
+		}
+		rewritten, err := repo.rewrittenNoteLines(localHash, remoteHash)
+		if err != nil {
+			return err
+		}
+		var removed []string
+		for object, localBlob := range localBlobs {
+			if _, ok := remoteBlobs[object]; !ok && baseBlobs[object] == localBlob {
+				removed = append(removed, object)
+			}
+		}
+		localStale, remoteStale := false, false
+		if len(rewritten) > 0 {
+			for object, localBlob := range localBlobs {
+				if _, ok := remoteBlobs[object]; ok || baseBlobs[object] == localBlob {
+					continue
+				}
+				localContents, err := repo.ReadBlob(localBlob)
+				if err != nil {
+					return err
+				}
+				kept, dropped := dropRewrittenNoteLines(string(localContents), rewritten)
+				if !dropped {
+					continue
+				}
+				localStale = true
+				if err := repo.writeMergedNote(scratchRef, object, kept); err != nil {
+					return err
+				}
+			}
+		}
+		if err := repo.removeNotes(scratchRef, removed); err != nil {
+			return err
+		}
+		for object, remoteBlob := range remoteBlobs {
+			localBlob, ok := localBlobs[object]
+			if ok && localBlob == remoteBlob {
+				continue
+			}
+			if !ok && baseBlobs[object] == remoteBlob {
+				// The note was removed locally.
+				continue
+			}
+			var localContents []byte
+			if ok {
+				if localContents, err = repo.ReadBlob(localBlob); err != nil {
+					return err
+				}
+			}
+			remoteContents, err := repo.ReadBlob(remoteBlob)
+			if err != nil {
+				return err
+			}
+			var dropped bool
+			if _, dropped = dropRewrittenNoteLines(string(localContents), rewritten); dropped {
+				localStale = true
+			}
+			if _, dropped = dropRewrittenNoteLines(string(remoteContents), rewritten); dropped {
+				remoteStale = true
+			}
+			merged, _ := dropRewrittenNoteLines(unionNoteLines(string(localContents), string(remoteContents)), rewritten)
+			if ok && merged == unionNoteLines(string(localContents), "") {
+				// Every remote line is already in the local note.
+				continue
+			}
+			if err := repo.writeMergedNote(scratchRef, object, merged); err != nil {
+				return err
+			}
+		}
+
+		// Record the remote notes as merged, so that the result can be pushed back to the remote.
+		mergedHash, err := repo.GetCommitHash(scratchRef)
+		if err != nil {
+			return err
+		}
+		if remoteStale && mergedHash == localHash {
+			// The remote notes have nothing to add, and must not be recorded as merged.
+			return nil
+		}
+		mergedDetails, err := repo.GetCommitDetails(mergedHash)
+		if err != nil {
+			return err
+		}
+		args := []string{"commit-tree"}
+		if !localStale {
+			args = append(args, "-p", mergedHash)
+		}
+		if !remoteStale {
+			args = append(args, "-p", remoteHash)
+		}
+		message := "Merge local and remote notes"
+		if localStale || remoteStale {
+			// The rewritten lines are no longer recorded by the history of the side that is left out.
+			message += rewrittenNoteLinesTrailers(rewritten)
+		}
+		mergeCommit, err := repo.runGitCommandWithInput(message, append(args, mergedDetails.Tree)...)
+		if err != nil {
+			return err
+		}
+		_, err = repo.runGitCommand("update-ref", scratchRef, strings.TrimSpace(mergeCommit), mergedHash)
+		return err
+	})
+}
+
 func (repo *GitRepo) mergeRemoteNotes(remote, notesRefPattern string) error {
 	remoteRefs, err := repo.runGitCommand("ls-remote", remote, notesRefPattern)
 	if err != nil {
@@ -748,8 +2112,7 @@ func (repo *GitRepo) mergeRemoteNotes(remote, notesRefPattern string) error {
 		if len(lineParts) == 2 {
 			ref := lineParts[1]
 			remoteRef := getRemoteNotesRef(remote, ref)
-			_, err := repo.runGitCommand("notes", "--ref", ref, "merge", remoteRef, "-s", "cat_sort_uniq")
-			if err != nil {
+			if err := repo.mergeNotes(ref, remoteRef); err != nil {
 				return err
 			}
 		}
@@ -758,8 +2121,8 @@ func (repo *GitRepo) mergeRemoteNotes(remote, notesRefPattern string) error {
 }
 
 // PullNotes fetches the contents of the given notes ref from a remote repo,
-// and then merges them with the corresponding local notes using the
-// "cat_sort_uniq" strategy.
+// and then merges them with the corresponding local notes, taking the union
+// of the notes for each object.
 func (repo *GitRepo) PullNotes(remote, notesRefPattern string) error {
 	remoteNotesRefPattern := getRemoteNotesRef(remote, notesRefPattern)
 	fetchRefSpec := fmt.Sprintf("+%s:%s", notesRefPattern, remoteNotesRefPattern)
@@ -797,9 +2160,10 @@ func (repo *GitRepo) mergeRemoteArchives(remote, archiveRefPattern string) error
 // PullNotesAndArchive fetches the contents of the notes and archives refs from
 // a remote repo, and merges them with the corresponding local refs.
 //
-// For notes refs, we assume that every note can be automatically merged using
-// the 'cat_sort_uniq' strategy (the git-appraise schemas fit that requirement),
-// so we automatically merge the remote notes into the local notes.
+// For notes refs, we assume that every note is an append-only set of lines
+// (the git-appraise schemas fit that requirement), so we automatically merge
+// the remote notes into the local notes by taking their union. Notes that
+// were removed on one side, and left unchanged on the other, stay removed.
 //
 // For "archive" refs, they are expected to be used solely for maintaining
 // reachability of commits that are part of the history of any reviews,
@@ -18,6 +18,12 @@ package repository
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -92,3 +98,537 @@ func TestSplitBatchCatFileOutput(t *testing.T) {
 		t.Fatal("Failed to parse the contents of the last cat'ed file")
 	}
 }
+
+func TestUnionNoteLines(t *testing.T) {
+	merged := unionNoteLines("{\"a\":1}\n\n{\"b\":2}\n", "{\"c\":3}\n{\"a\":1}\n  \n")
+	expected := "{\"a\":1}\n{\"b\":2}\n{\"c\":3}\n"
+	if merged != expected {
+		t.Errorf("Unexpected merged note: %q", merged)
+	}
+	if merged := unionNoteLines("", "{\"c\":3}"); merged != "{\"c\":3}\n" {
+		t.Errorf("Unexpected merged note for a missing local note: %q", merged)
+	}
+}
+
+func TestParseSubmoduleChanges(t *testing.T) {
+	out := `:160000 160000 9164c7239959139126873666fa96fc1bfef84942 9afa01a48c4332fc0141154893ff5b7b5fe519bb M	lib
+:000000 100644 0000000000000000000000000000000000000000 587be6b1e3c2a7a9b6e7c3b3f1a8d6c5e4b3a291 A	x
+:000000 160000 0000000000000000000000000000000000000000 1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d A	vendor/dep`
+	changes := parseSubmoduleChanges(out)
+	expected := []SubmoduleChange{
+		{Path: "lib", OldCommit: "9164c7239959139126873666fa96fc1bfef84942", NewCommit: "9afa01a48c4332fc0141154893ff5b7b5fe519bb"},
+		{Path: "vendor/dep", NewCommit: "1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Unexpected submodule changes: %+v", changes)
+	}
+}
+
+func TestParseBinaryChanges(t *testing.T) {
+	out := `:000000 100644 0000000000000000000000000000000000000000 e86e210e7d367ba8d4ad03d3b8e5e4b9d4c2d1a0 A	logo.png
+:100644 100644 59fed82c2a9a6ff8e4ade1b4dbe0c8e3b5e8c0f1 0a83f5474c4d4e7a9e0b1c2d3e4f5a6b7c8d9e0f M	main.go
+:100644 000000 c866266a1bd1a8b1a0d0b0e5c7d9a3f2e1b0c9d8 0000000000000000000000000000000000000000 D	data.bin
+-	-	logo.png
+2	1	main.go
+-	-	data.bin`
+	changes := parseBinaryChanges(out)
+	expected := []BinaryChange{
+		{Path: "logo.png", NewBlob: "e86e210e7d367ba8d4ad03d3b8e5e4b9d4c2d1a0"},
+		{Path: "data.bin", OldBlob: "c866266a1bd1a8b1a0d0b0e5c7d9a3f2e1b0c9d8"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Unexpected binary changes: %+v", changes)
+	}
+}
+
+func TestSplitNotes(t *testing.T) {
+	notes := splitNotes("{\"a\":1}\r\n{\"b\":2}\n{\"c\":3}")
+	expected := []Note{Note(`{"a":1}`), Note(`{"b":2}`), Note(`{"c":3}`)}
+	if !reflect.DeepEqual(notes, expected) {
+		t.Fatalf("Unexpected notes: %q", notes)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := (&GitRepo{Path: "."}).WithContext(ctx)
+	if repo.Context() != ctx {
+		t.Fatal("The repo is not bound to the given context")
+	}
+	cancel()
+	if _, err := repo.GetRepoStateHash(); err != context.Canceled {
+		t.Fatalf("Unexpected error running a git command with a cancelled context: %v", err)
+	}
+	if (&GitRepo{Path: "."}).Context().Err() != nil {
+		t.Fatal("Unexpected error from the default context")
+	}
+}
+
+func TestBareRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bare-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := &GitRepo{Path: dir}
+	if _, err := repo.runGitCommand("init", "--bare"); err != nil {
+		t.Fatal(err)
+	}
+	if bare, err := repo.IsBare(); err != nil || !bare {
+		t.Fatalf("Failed to recognize a bare repo: %v, %v", bare, err)
+	}
+	gitDir, err := repo.GetGitDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Clean(gitDir) != filepath.Clean(dir) {
+		t.Fatalf("Unexpected git directory of a bare repo: %q", gitDir)
+	}
+}
+
+func TestAppendNotes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "append-notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := &GitRepo{Path: dir}
+	var commits []string
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "user.email", "user@example.com"},
+		{"config", "user.name", "User"},
+		{"commit", "--allow-empty", "-m", "First"},
+		{"commit", "--allow-empty", "-m", "Second"},
+	} {
+		if _, err := repo.runGitCommand(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, commit := range []string{"HEAD~1", "HEAD"} {
+		hash, err := repo.GetCommitHash(commit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, hash)
+	}
+	const notesRef = "refs/notes/test"
+	if err := repo.AppendNote(notesRef, commits[0], Note("existing")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNotes(notesRef, map[string][]Note{
+		commits[0]: {Note("first"), Note("second")},
+		commits[1]: {Note("third")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if notes := repo.GetNotes(notesRef, commits[0]); !reflect.DeepEqual(notes, []Note{Note("existing"), Note("first"), Note("second")}) {
+		t.Errorf("Unexpected notes for the first commit: %q", notes)
+	}
+	if notes := repo.GetNotes(notesRef, commits[1]); !reflect.DeepEqual(notes, []Note{Note("third")}) {
+		t.Errorf("Unexpected notes for the second commit: %q", notes)
+	}
+	if count, err := repo.runGitCommand("rev-list", "--count", notesRef); err != nil || count != "2" {
+		t.Errorf("Unexpected number of commits of the notes ref: %q, %v", count, err)
+	}
+}
+
+func TestConcurrentAppendNote(t *testing.T) {
+	dir, err := ioutil.TempDir("", "concurrent-notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := &GitRepo{Path: dir}
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "user.email", "user@example.com"},
+		{"config", "user.name", "User"},
+		{"commit", "--allow-empty", "-m", "First"},
+	} {
+		if _, err := repo.runGitCommand(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const notesRef = "refs/notes/test"
+	const writers = 8
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			errs <- repo.AppendNote(notesRef, commit, Note(fmt.Sprintf("note %d", i)))
+		}(i)
+	}
+	for i := 0; i < writers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	written := make(map[string]bool)
+	for _, note := range repo.GetNotes(notesRef, commit) {
+		written[string(note)] = true
+	}
+	for i := 0; i < writers; i++ {
+		if !written[fmt.Sprintf("note %d", i)] {
+			t.Errorf("Lost the write of note %d: %q", i, repo.GetNotes(notesRef, commit))
+		}
+	}
+	if refs, err := repo.runGitCommand("for-each-ref", scratchNotesRefPrefix); err != nil || refs != "" {
+		t.Errorf("Unexpected scratch refs left behind: %q, %v", refs, err)
+	}
+}
+
+// newTestRepo returns a new repo in a temporary directory, with the given number of empty commits.
+func newTestRepo(t testing.TB, commits int) *GitRepo {
+	dir, err := ioutil.TempDir("", "test-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &GitRepo{Path: dir, commits: newCommitCache()}
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "user.email", "user@example.com"},
+		{"config", "user.name", "User"},
+	} {
+		if _, err := repo.runGitCommand(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < commits; i++ {
+		if _, err := repo.runGitCommand("commit", "--allow-empty", "-m", fmt.Sprintf("Commit %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestGetCommitDetails(t *testing.T) {
+	repo := newTestRepo(t, 2)
+	defer os.RemoveAll(repo.Path)
+	parent, err := repo.GetCommitHash("HEAD~1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	details, err := repo.GetCommitDetails(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Author != "User" || details.AuthorEmail != "user@example.com" || details.Summary != "Commit 1" ||
+		!reflect.DeepEqual(details.Parents, []string{parent}) || !IsCommitHash(details.Tree) || details.Time == "" {
+		t.Fatalf("Unexpected commit details: %+v", details)
+	}
+	before := GitCommandCount()
+	if cached, err := repo.GetCommitDetails(head); err != nil || !reflect.DeepEqual(cached, details) {
+		t.Fatalf("Unexpected cached commit details: %+v, %v", cached, err)
+	}
+	if ran := GitCommandCount() - before; ran != 0 {
+		t.Fatalf("Ran %d git commands to read the cached details of a commit", ran)
+	}
+	if details, err := repo.GetCommitDetails("HEAD~1"); err != nil || details.Summary != "Commit 0" || details.Parents[0] != "" {
+		t.Fatalf("Unexpected details of the root commit: %+v, %v", details, err)
+	}
+}
+
+func BenchmarkGetCommitDetails(b *testing.B) {
+	repo := newTestRepo(b, 10)
+	defer os.RemoveAll(repo.Path)
+	commits, err := repo.ListCommitsBetween("HEAD~9", "HEAD")
+	if err != nil {
+		b.Fatal(err)
+	}
+	// Without the cache, every call has to read the commit from git.
+	repo.commits = nil
+	before := GitCommandCount()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.GetCommitDetails(commits[i%len(commits)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(GitCommandCount()-before)/float64(b.N), "git-commands/op")
+}
+
+func TestFetchRefs(t *testing.T) {
+	origin := newTestRepo(t, 1)
+	defer os.RemoveAll(origin.Path)
+	branch, err := origin.GetHeadRef()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	clone := &GitRepo{Path: dir, commits: newCommitCache()}
+	if _, err := clone.runGitCommand("clone", origin.Path, "."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clone.runGitCommand("checkout", "--detach"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := origin.runGitCommand("commit", "--allow-empty", "-m", "Remote commit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.FetchRefs(origin.Path, branch); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := origin.GetCommitHash(branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched, err := clone.GetCommitHash(branch); err != nil || fetched != expected {
+		t.Errorf("Unexpected commit of the fetched ref: %q, %v", fetched, err)
+	}
+}
+
+func TestSetNotesConflict(t *testing.T) {
+	repo := newTestRepo(t, 1)
+	defer os.RemoveAll(repo.Path)
+	commit, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const notesRef = "refs/notes/test"
+	if err := repo.AppendNote(notesRef, commit, Note("first")); err != nil {
+		t.Fatal(err)
+	}
+	for _, rewrite := range []bool{false, true} {
+		calls := 0
+		update := func(notes []Note) []Note {
+			calls++
+			if calls == 1 {
+				// Another write lands in between reading the notes and writing them.
+				if err := repo.AppendNote(notesRef, commit, Note(fmt.Sprintf("concurrent %v", rewrite))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return append(notes, Note(fmt.Sprintf("set %v", rewrite)))
+		}
+		if rewrite {
+			err = repo.RewriteNotes(notesRef, commit, update, "Rewrite")
+		} else {
+			err = repo.SetNotes(notesRef, commit, update)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != 2 {
+			t.Errorf("Unexpected number of updates of the notes: %d", calls)
+		}
+	}
+	expected := []Note{Note("first"), Note("concurrent false"), Note("set false"), Note("concurrent true"), Note("set true")}
+	var notes []Note
+	for _, note := range repo.GetNotes(notesRef, commit) {
+		// Appending to a note separates the appended lines with a blank one.
+		if len(note) > 0 {
+			notes = append(notes, note)
+		}
+	}
+	if !reflect.DeepEqual(notes, expected) {
+		t.Errorf("Lost a concurrent write of the notes: %q", notes)
+	}
+	if count, err := repo.runGitCommand("rev-list", "--count", notesRef); err != nil || count != "1" {
+		t.Errorf("Unexpected number of commits of the rewritten notes ref: %q, %v", count, err)
+	}
+}
+
+func TestConcurrentRemapAndMoveNotes(t *testing.T) {
+	repo := newTestRepo(t, 3)
+	defer os.RemoveAll(repo.Path)
+	var commits []string
+	for _, commit := range []string{"HEAD~2", "HEAD~1", "HEAD"} {
+		hash, err := repo.GetCommitHash(commit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, hash)
+	}
+	const notesRef = "refs/notes/test"
+	const archiveRef = "refs/notes/archive"
+	if err := repo.AppendNote(notesRef, commits[0], Note("remapped")); err != nil {
+		t.Fatal(err)
+	}
+	const writers = 4
+	errs := make(chan error, writers+1)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			errs <- repo.AppendNote(notesRef, commits[2], Note(fmt.Sprintf("note %d", i)))
+		}(i)
+	}
+	go func() {
+		_, err := repo.RemapNotes(notesRef, map[string]string{commits[0]: commits[1]})
+		errs <- err
+	}()
+	for i := 0; i < writers+1; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if notes := repo.GetNotes(notesRef, commits[1]); !reflect.DeepEqual(notes, []Note{Note("remapped")}) {
+		t.Errorf("Unexpected notes of the remapped commit: %q", notes)
+	}
+	if notes := repo.GetNotes(notesRef, commits[0]); notes != nil {
+		t.Errorf("Unexpected notes left on the original commit: %q", notes)
+	}
+	if err := repo.MoveNotes(notesRef, archiveRef, []string{commits[2]}); err != nil {
+		t.Fatal(err)
+	}
+	if notes := repo.GetNotes(archiveRef, commits[2]); len(notes) != writers {
+		t.Errorf("Lost a concurrent write of the moved notes: %q", notes)
+	}
+	if notes := repo.GetNotes(notesRef, commits[2]); notes != nil {
+		t.Errorf("Unexpected notes left in the source ref: %q", notes)
+	}
+}
+
+func TestConcurrentMergeNotes(t *testing.T) {
+	repo := newTestRepo(t, 1)
+	defer os.RemoveAll(repo.Path)
+	commit, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const notesRef = "refs/notes/test"
+	remoteNotesRef := getRemoteNotesRef("origin", notesRef)
+	if err := repo.AppendNote(notesRef, commit, Note("local")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(remoteNotesRef, commit, Note("remote")); err != nil {
+		t.Fatal(err)
+	}
+	const writers = 4
+	errs := make(chan error, writers+1)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			errs <- repo.AppendNote(notesRef, commit, Note(fmt.Sprintf("note %d", i)))
+		}(i)
+	}
+	go func() {
+		errs <- repo.mergeNotes(notesRef, remoteNotesRef)
+	}()
+	for i := 0; i < writers+1; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	written := make(map[string]bool)
+	for _, note := range repo.GetNotes(notesRef, commit) {
+		written[string(note)] = true
+	}
+	for _, expected := range []string{"local", "remote", "note 0", "note 1", "note 2", "note 3"} {
+		if !written[expected] {
+			t.Errorf("Lost the note %q: %q", expected, repo.GetNotes(notesRef, commit))
+		}
+	}
+	remoteHash, err := repo.GetCommitHash(remoteNotesRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged, err := repo.IsAncestor(remoteHash, notesRef); err != nil || !merged {
+		t.Errorf("The remote notes were not recorded as merged: %v, %v", merged, err)
+	}
+}
+
+func TestMergeNotesDropsRewrittenLines(t *testing.T) {
+	repo := newTestRepo(t, 1)
+	defer os.RemoveAll(repo.Path)
+	commit, err := repo.GetCommitHash("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redact := func(notes []Note) []Note {
+		var kept []Note
+		for _, note := range notes {
+			if string(note) != "secret" {
+				kept = append(kept, note)
+			}
+		}
+		return kept
+	}
+	for _, rewriteLocal := range []bool{true, false} {
+		notesRef := fmt.Sprintf("refs/notes/test-%t", rewriteLocal)
+		remoteNotesRef := getRemoteNotesRef("origin", notesRef)
+		for _, note := range []string{"kept", "secret"} {
+			if err := repo.AppendNote(notesRef, commit, Note(note)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := repo.runGitCommand("update-ref", remoteNotesRef, notesRef); err != nil {
+			t.Fatal(err)
+		}
+		rewrittenRef, staleRef := notesRef, remoteNotesRef
+		if !rewriteLocal {
+			rewrittenRef, staleRef = remoteNotesRef, notesRef
+		}
+		if err := repo.RewriteNotes(rewrittenRef, commit, redact, "Redact the secret"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.AppendNote(staleRef, commit, Note("stale")); err != nil {
+			t.Fatal(err)
+		}
+		staleHash, err := repo.GetCommitHash(staleRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.mergeNotes(notesRef, remoteNotesRef); err != nil {
+			t.Fatal(err)
+		}
+		merged := make(map[string]bool)
+		for _, note := range repo.GetNotes(notesRef, commit) {
+			merged[string(note)] = true
+		}
+		if merged["secret"] || !merged["kept"] || !merged["stale"] {
+			t.Errorf("Unexpected merged notes after rewriting the local notes (%t): %q", rewriteLocal, repo.GetNotes(notesRef, commit))
+		}
+		if reachable, err := repo.IsAncestor(staleHash, notesRef); err != nil || reachable {
+			t.Errorf("The notes from before the rewrite are still reachable after rewriting the local notes (%t): %v, %v", rewriteLocal, reachable, err)
+		}
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	repo := newTestRepo(t, 0)
+	defer os.RemoveAll(repo.Path)
+	commitFiles := func(message string, files map[string]string) string {
+		for name, contents := range files {
+			if err := ioutil.WriteFile(filepath.Join(repo.Path, name), []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := repo.runGitCommand("add", "."); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.runGitCommand("commit", "-m", message); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := repo.GetCommitHash("HEAD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	base := commitFiles("Base", map[string]string{"a.txt": "base\n", "b.txt": "base\n", "c.txt": "base\n"})
+	ours := commitFiles("Ours", map[string]string{"a.txt": "ours\n", "b.txt": "ours\n"})
+	if _, err := repo.runGitCommand("checkout", "-q", base); err != nil {
+		t.Fatal(err)
+	}
+	theirs := commitFiles("Theirs", map[string]string{"a.txt": "theirs\n", "b.txt": "theirs\n", "c.txt": "theirs\n"})
+	conflicts, err := repo.MergeConflicts(ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conflicts, []string{"a.txt", "b.txt"}) {
+		t.Errorf("Unexpected merge conflicts: %q", conflicts)
+	}
+	if conflicts, err := repo.MergeConflicts(ours, base); err != nil || conflicts != nil {
+		t.Errorf("Unexpected merge conflicts with an ancestor: %q, %v", conflicts, err)
+	}
+}
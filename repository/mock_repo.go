@@ -17,9 +17,11 @@ limitations under the License.
 package repository
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -77,6 +79,8 @@ type mockRepoForTest struct {
 	Refs    map[string]string            `json:"refs,omitempty"`
 	Commits map[string]mockCommit        `json:"commits,omitempty"`
 	Notes   map[string]map[string]string `json:"notes,omitempty"`
+	Blobs   map[string]string            `json:"blobs,omitempty"`
+	Config  map[string][]string          `json:"config,omitempty"`
 }
 
 func (r *mockRepoForTest) createCommit(message string, time string, parents []string) (string, error) {
@@ -182,6 +186,23 @@ func NewMockRepoForTest() Repo {
 // GetPath returns the path to the repo.
 func (r *mockRepoForTest) GetPath() string { return "~/mockRepo/" }
 
+// GetGitDir returns the absolute path of the repo's git directory.
+func (r *mockRepoForTest) GetGitDir() (string, error) { return "~/mockRepo/.git", nil }
+
+// IsBare reports whether the repo is bare, and so has no worktree.
+func (r *mockRepoForTest) IsBare() (bool, error) { return false, nil }
+
+// Context returns the context that bounds the operations on the repo.
+//
+// The operations on the mock repo never block, so they are not bound by any context.
+func (r *mockRepoForTest) Context() context.Context { return context.Background() }
+
+// WithContext returns the mock repo itself, as its operations are not bound by any context.
+func (r *mockRepoForTest) WithContext(ctx context.Context) Repo { return r }
+
+// WithAuthor returns the mock repo itself, as it does not record who wrote anything.
+func (r *mockRepoForTest) WithAuthor(name, email string) Repo { return r }
+
 // GetRepoStateHash returns a hash which embodies the entire current state of a repository.
 func (r *mockRepoForTest) GetRepoStateHash() (string, error) {
 	repoJSON, err := json.Marshal(r)
@@ -194,12 +215,62 @@ func (r *mockRepoForTest) GetRepoStateHash() (string, error) {
 // GetUserEmail returns the email address that the user has used to configure git.
 func (r *mockRepoForTest) GetUserEmail() (string, error) { return "user@example.com", nil }
 
+// GetUserSigningKey returns the key that the user has configured git to sign with.
+func (r *mockRepoForTest) GetUserSigningKey() (string, error) { return "", nil }
+
 // GetCoreEditor returns the name of the editor that the user has used to configure git.
 func (r *mockRepoForTest) GetCoreEditor() (string, error) { return "vi", nil }
 
+// GetCorePager returns the name of the pager that the user has used to configure git.
+func (r *mockRepoForTest) GetCorePager() (string, error) { return "less", nil }
+
 // GetSubmitStrategy returns the way in which a review is submitted
 func (r *mockRepoForTest) GetSubmitStrategy() (string, error) { return "merge", nil }
 
+// GetCoverageThreshold returns the minimum code coverage percentage that a review
+// must have in order to be submitted, or 0 if there is no minimum.
+func (r *mockRepoForTest) GetCoverageThreshold() (float64, error) { return 0, nil }
+
+// GetAssignStrategy returns the way in which reviewers are automatically assigned to a review.
+func (r *mockRepoForTest) GetAssignStrategy() (string, error) { return "", nil }
+
+// GetReviewerPool returns the reviewers that may be automatically assigned to a review.
+func (r *mockRepoForTest) GetReviewerPool() ([]string, error) { return nil, nil }
+
+// GetIssueTrackers returns the configuration of each issue tracker that review issue IDs are linked to.
+func (r *mockRepoForTest) GetIssueTrackers() ([]string, error) { return nil, nil }
+
+// GetAutoRequestPatterns returns the patterns of the branch names for which a
+// review is automatically requested when they are first pushed.
+func (r *mockRepoForTest) GetAutoRequestPatterns() ([]string, error) { return nil, nil }
+
+// GetAutoRequestTarget returns the target ref of automatically requested reviews.
+func (r *mockRepoForTest) GetAutoRequestTarget() (string, error) { return "", nil }
+
+// GetAutoSyncRemote returns the remote that review notes are automatically synchronized with.
+func (r *mockRepoForTest) GetAutoSyncRemote() (string, error) { return "", nil }
+
+// GetAutoPushRemote returns the remote that review notes are automatically pushed to.
+func (r *mockRepoForTest) GetAutoPushRemote() (string, error) { return "", nil }
+
+// GetConfig returns every value of the given git config setting.
+func (r *mockRepoForTest) GetConfig(key string) ([]string, error) {
+	return r.Config[key], nil
+}
+
+// SetConfig replaces the values of the given git config setting, or unsets it if no values are given.
+func (r *mockRepoForTest) SetConfig(key string, global bool, values ...string) error {
+	if r.Config == nil {
+		r.Config = make(map[string][]string)
+	}
+	if len(values) == 0 {
+		delete(r.Config, key)
+		return nil
+	}
+	r.Config[key] = values
+	return nil
+}
+
 // HasUncommittedChanges returns true if there are local, uncommitted changes.
 func (r *mockRepoForTest) HasUncommittedChanges() (bool, error) { return false, nil }
 
@@ -351,6 +422,42 @@ func (r *mockRepoForTest) IsAncestor(ancestor, descendant string) (bool, error)
 	return false, nil
 }
 
+// GetLandingCommit returns the commit that brought the given commit into the history of the given ref.
+func (r *mockRepoForTest) GetLandingCommit(commit, ref string) (string, error) {
+	landing, err := r.resolveLocalRef(ref)
+	if err != nil {
+		return "", err
+	}
+	if isAncestor, err := r.IsAncestor(commit, landing); err != nil || !isAncestor {
+		return "", fmt.Errorf("The commit %q is not in the history of %q.", commit, ref)
+	}
+	for {
+		details, err := r.getCommit(landing)
+		if err != nil {
+			return "", err
+		}
+		if len(details.Parents) == 0 {
+			return landing, nil
+		}
+		if isAncestor, err := r.IsAncestor(commit, details.Parents[0]); err != nil || !isAncestor {
+			return landing, err
+		}
+		landing = details.Parents[0]
+	}
+}
+
+// MergeConflicts returns the paths that would conflict if the second commit were merged into the first.
+//
+// The mock repo does not track file contents, so every merge is clean.
+func (r *mockRepoForTest) MergeConflicts(ours, theirs string) ([]string, error) {
+	for _, ref := range []string{ours, theirs} {
+		if _, err := r.resolveLocalRef(ref); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
 // MergeBase determines if the first commit that is an ancestor of the two arguments.
 func (r *mockRepoForTest) MergeBase(a, b string) (string, error) {
 	ancestors, err := r.ancestors(a)
@@ -370,11 +477,68 @@ func (r *mockRepoForTest) Diff(left, right string, diffArgs ...string) (string,
 	return fmt.Sprintf("Diff between %q and %q", left, right), nil
 }
 
+// ListSubmoduleChanges returns the submodules whose commits differ between two given commits.
+func (r *mockRepoForTest) ListSubmoduleChanges(left, right string) ([]SubmoduleChange, error) {
+	return nil, nil
+}
+
+// ListBinaryChanges returns the binary files that differ between two given commits.
+func (r *mockRepoForTest) ListBinaryChanges(left, right string) ([]BinaryChange, error) {
+	return nil, nil
+}
+
+// GetSubmodule returns the repo checked out for the submodule at the given path.
+func (r *mockRepoForTest) GetSubmodule(path string) (Repo, error) {
+	return nil, fmt.Errorf("The submodule %q has not been checked out.", path)
+}
+
 // Show returns the contents of the given file at the given commit.
 func (r *mockRepoForTest) Show(commit, path string) (string, error) {
 	return fmt.Sprintf("%s:%s", commit, path), nil
 }
 
+// Blame returns the number of lines of the given file at the given commit
+// that were last changed by each author, keyed by the author's email.
+func (r *mockRepoForTest) Blame(commit, path string) (map[string]int, error) {
+	return map[string]int{"ojarjur@google.com": 1}, nil
+}
+
+// GetPatchIDs returns the stable patch ID of each of the given commits.
+//
+// The patch ID of a mock commit is derived from its message, since mock commits have no diffs.
+func (r *mockRepoForTest) GetPatchIDs(commits []string) (map[string]string, error) {
+	patchIDs := make(map[string]string)
+	for _, commit := range commits {
+		c, err := r.getCommit(commit)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.Parents) < 2 {
+			patchIDs[commit] = fmt.Sprintf("%x", sha1.Sum([]byte(c.Message)))
+		}
+	}
+	return patchIDs, nil
+}
+
+// FormatPatch returns the given commit formatted as an email message, in the mbox format.
+func (r *mockRepoForTest) FormatPatch(commit string) (string, error) {
+	c, err := r.getCommit(commit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("From %s Mon Sep 17 00:00:00 2001\nFrom: user@example.com\nSubject: [PATCH] %s\n\n---\n", commit, c.Message), nil
+}
+
+// SetRef points the given ref at the given commit, or deletes the ref if the commit is empty.
+func (r *mockRepoForTest) SetRef(ref, commit string) error {
+	if commit == "" {
+		delete(r.Refs, ref)
+		return nil
+	}
+	r.Refs[ref] = commit
+	return nil
+}
+
 // SwitchToRef changes the currently-checked-out ref.
 func (r *mockRepoForTest) SwitchToRef(ref string) error {
 	r.Head = ref
@@ -460,6 +624,95 @@ func (r *mockRepoForTest) RebaseRef(ref string) error {
 	return nil
 }
 
+// AbortRebase abandons a rebase that stopped partway through.
+//
+// The mock repo never stops partway through a rebase, so this does nothing.
+func (r *mockRepoForTest) AbortRebase() error {
+	return nil
+}
+
+// CherryPick applies the changes of the given commits, in order, as new commits on top of the current ref.
+func (r *mockRepoForTest) CherryPick(commits ...string) error {
+	parentHash, err := r.resolveLocalRef(r.Head)
+	if err != nil {
+		return err
+	}
+	for _, commit := range commits {
+		pickedCommit, err := r.getCommit(commit)
+		if err != nil {
+			return err
+		}
+		parentHash, err = r.createCommit(pickedCommit.Message, pickedCommit.Time, []string{parentHash})
+		if err != nil {
+			return err
+		}
+	}
+	if strings.HasPrefix(r.Head, "refs/heads/") {
+		r.Refs[r.Head] = parentHash
+	} else {
+		r.Head = parentHash
+	}
+	return nil
+}
+
+// AbortCherryPick abandons a cherry-pick that stopped partway through.
+//
+// The mock repo never stops partway through a cherry-pick, so this does nothing.
+func (r *mockRepoForTest) AbortCherryPick() error {
+	return nil
+}
+
+// SquashRef squashes the changes in the given ref into a single commit
+// on top of the current ref, using the given commit message.
+func (r *mockRepoForTest) SquashRef(ref, message string) error {
+	parent, err := r.resolveLocalRef(r.Head)
+	if err != nil {
+		return err
+	}
+	squashedCommit, err := r.getCommit(ref)
+	if err != nil {
+		return err
+	}
+	newCommitHash, err := r.createCommit(message, squashedCommit.Time, []string{parent})
+	if err != nil {
+		return err
+	}
+	r.Refs[r.Head] = newCommitHash
+	return nil
+}
+
+// ApplyPatch applies the given patch (in the unified diff format) to both
+// the working directory and the index.
+func (r *mockRepoForTest) ApplyPatch(patch string) error { return nil }
+
+// ApplyPatchToTree applies the given patch (in the unified diff format) to
+// the tree of the given commit, and returns the hash of the resulting tree.
+func (r *mockRepoForTest) ApplyPatchToTree(commit, patch string) (string, error) {
+	if _, err := r.getCommit(commit); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// Commit creates a new commit from the current contents of the index, and
+// returns the hash of the newly created commit.
+func (r *mockRepoForTest) Commit(message string) (string, error) {
+	parent, err := r.resolveLocalRef(r.Head)
+	if err != nil {
+		return "", err
+	}
+	newCommitHash, err := r.createCommit(message, "Nowish", []string{parent})
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(r.Head, "refs/heads/") {
+		r.Refs[r.Head] = newCommitHash
+	} else {
+		r.Head = newCommitHash
+	}
+	return newCommitHash, nil
+}
+
 // ListCommits returns the list of commits reachable from the given ref.
 //
 // The generated list is in chronological order (with the oldest commit first).
@@ -498,12 +751,7 @@ func (r *mockRepoForTest) ListCommitsBetween(from, to string) ([]string, error)
 
 // GetNotes reads the notes from the given ref that annotate the given revision.
 func (r *mockRepoForTest) GetNotes(notesRef, revision string) []Note {
-	notesText := r.Notes[notesRef][revision]
-	var notes []Note
-	for _, line := range strings.Split(notesText, "\n") {
-		notes = append(notes, Note(line))
-	}
-	return notes
+	return splitNotes(r.Notes[notesRef][revision])
 }
 
 // GetAllNotes reads the contents of the notes under the given ref for every commit.
@@ -521,12 +769,133 @@ func (r *mockRepoForTest) GetAllNotes(notesRef string) (map[string][]Note, error
 
 // AppendNote appends a note to a revision under the given ref.
 func (r *mockRepoForTest) AppendNote(ref, revision string, note Note) error {
+	if _, ok := r.Notes[ref]; !ok {
+		r.Notes[ref] = make(map[string]string)
+	}
 	existingNotes := r.Notes[ref][revision]
 	newNotes := existingNotes + "\n" + string(note)
 	r.Notes[ref][revision] = newNotes
 	return nil
 }
 
+// AppendNotes appends each of the given notes to the revision that it is keyed by.
+func (r *mockRepoForTest) AppendNotes(notesRef string, notes map[string][]Note) error {
+	for revision, revisionNotes := range notes {
+		for _, note := range revisionNotes {
+			if err := r.AppendNote(notesRef, revision, note); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SetNotes replaces all of the notes annotating a revision under the given ref with the ones that update returns.
+func (r *mockRepoForTest) SetNotes(ref, revision string, update func(notes []Note) []Note) error {
+	notes := update(r.GetNotes(ref, revision))
+	if len(notes) == 0 {
+		delete(r.Notes[ref], revision)
+		return nil
+	}
+	if _, ok := r.Notes[ref]; !ok {
+		r.Notes[ref] = make(map[string]string)
+	}
+	var contents []string
+	for _, note := range notes {
+		contents = append(contents, string(note))
+	}
+	r.Notes[ref][revision] = strings.Join(contents, "\n")
+	return nil
+}
+
+// RewriteNotes replaces the notes annotating the given revision.
+//
+// The mock notes have no history, so there is nothing else to rewrite.
+func (r *mockRepoForTest) RewriteNotes(notesRef, revision string, update func(notes []Note) []Note, message string) error {
+	return r.SetNotes(notesRef, revision, update)
+}
+
+// MoveNotes moves the notes annotating the given objects from one notes ref to another.
+func (r *mockRepoForTest) MoveNotes(fromRef, toRef string, objects []string) error {
+	for _, object := range objects {
+		notes, ok := r.Notes[fromRef][object]
+		if !ok {
+			continue
+		}
+		r.AppendNote(toRef, object, Note(notes))
+		delete(r.Notes[fromRef], object)
+	}
+	return nil
+}
+
+// RemapNotes moves the notes under the given ref from each object in the mapping to the object that it maps to.
+func (r *mockRepoForTest) RemapNotes(notesRef string, mapping map[string]string) ([]string, error) {
+	var remapped []string
+	for oldObject, newObject := range mapping {
+		notes, ok := r.Notes[notesRef][oldObject]
+		if !ok || oldObject == newObject {
+			continue
+		}
+		r.AppendNote(notesRef, newObject, Note(notes))
+		delete(r.Notes[notesRef], oldObject)
+		remapped = append(remapped, oldObject)
+	}
+	sort.Strings(remapped)
+	return remapped, nil
+}
+
+// RemoveNotes removes the notes annotating the given objects from the given notes ref.
+func (r *mockRepoForTest) RemoveNotes(notesRef string, objects []string) error {
+	for _, object := range objects {
+		delete(r.Notes[notesRef], object)
+	}
+	return nil
+}
+
+// ListOrphanedNotes returns the objects annotated by notes in the given ref that are missing from the repo.
+func (r *mockRepoForTest) ListOrphanedNotes(notesRef string) ([]string, error) {
+	var orphaned []string
+	for object := range r.Notes[notesRef] {
+		_, isCommit := r.Commits[object]
+		_, isBlob := r.Blobs[object]
+		if !isCommit && !isBlob {
+			orphaned = append(orphaned, object)
+		}
+	}
+	sort.Strings(orphaned)
+	return orphaned, nil
+}
+
+// GetAddedNotes returns the notes that were added between two commits of a notes ref.
+//
+// The mock notes have no history, so no notes are ever added between its commits.
+func (r *mockRepoForTest) GetAddedNotes(oldCommit, newCommit string) (map[string][]Note, error) {
+	return map[string][]Note{}, nil
+}
+
+// StoreBlob writes the given contents to the repo as a blob, and returns its hash.
+func (r *mockRepoForTest) StoreBlob(notesRef string, contents []byte) (string, error) {
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("blob %d\x00%s", len(contents), contents))))
+	if r.Blobs == nil {
+		r.Blobs = make(map[string]string)
+	}
+	r.Blobs[hash] = string(contents)
+	if _, ok := r.Notes[notesRef]; !ok {
+		r.Notes[notesRef] = make(map[string]string)
+	}
+	r.Notes[notesRef][hash] = string(contents)
+	return hash, nil
+}
+
+// ReadBlob returns the contents of the blob with the given hash.
+func (r *mockRepoForTest) ReadBlob(hash string) ([]byte, error) {
+	contents, ok := r.Blobs[hash]
+	if !ok {
+		return nil, fmt.Errorf("The blob %q does not exist", hash)
+	}
+	return []byte(contents), nil
+}
+
 // ListNotedRevisions returns the collection of revisions that are annotated by notes in the given ref.
 func (r *mockRepoForTest) ListNotedRevisions(notesRef string) []string {
 	var revisions []string
@@ -541,9 +910,18 @@ func (r *mockRepoForTest) ListNotedRevisions(notesRef string) []string {
 // PushNotes pushes git notes to a remote repo.
 func (r *mockRepoForTest) PushNotes(remote, notesRefPattern string) error { return nil }
 
+// PushRefs pushes the given refs to a remote repo.
+func (r *mockRepoForTest) PushRefs(remote string, force bool, refs ...string) error { return nil }
+
+// PushRefWithLease pushes the given ref to a remote repo, if the remote ref points to the expected commit.
+func (r *mockRepoForTest) PushRefWithLease(remote, ref, expected string) error { return nil }
+
+// FetchRefs fetches the given refs from a remote repo.
+func (r *mockRepoForTest) FetchRefs(remote string, refs ...string) error { return nil }
+
 // PullNotes fetches the contents of the given notes ref from a remote repo,
-// and then merges them with the corresponding local notes using the
-// "cat_sort_uniq" strategy.
+// and then merges them with the corresponding local notes, taking the union
+// of the notes for each object.
 func (r *mockRepoForTest) PullNotes(remote, notesRefPattern string) error { return nil }
 
 // PushNotesAndArchive pushes the given notes and archive refs to a remote repo.
@@ -554,9 +932,10 @@ func (r *mockRepoForTest) PushNotesAndArchive(remote, notesRefPattern, archiveRe
 // PullNotesAndArchive fetches the contents of the notes and archives refs from
 // a remote repo, and merges them with the corresponding local refs.
 //
-// For notes refs, we assume that every note can be automatically merged using
-// the 'cat_sort_uniq' strategy (the git-appraise schemas fit that requirement),
-// so we automatically merge the remote notes into the local notes.
+// For notes refs, we assume that every note is an append-only set of lines
+// (the git-appraise schemas fit that requirement), so we automatically merge
+// the remote notes into the local notes by taking their union. Notes that
+// were removed on one side, and left unchanged on the other, stay removed.
 //
 // For "archive" refs, they are expected to be used solely for maintaining
 // reachability of commits that are part of the history of any reviews,
@@ -17,6 +17,18 @@ limitations under the License.
 // Package repository contains helper methods for working with a Git repo.
 package repository
 
+import (
+	"context"
+	"regexp"
+)
+
+var commitHashPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// IsCommitHash reports whether the given revision is the full hash of a commit, rather than the name of a ref.
+func IsCommitHash(revision string) bool {
+	return commitHashPattern.MatchString(revision)
+}
+
 // Note represents the contents of a git-note
 type Note []byte
 
@@ -30,23 +42,105 @@ type CommitDetails struct {
 	Summary     string   `json:"summary,omitempty"`
 }
 
+// SubmoduleChange describes how the commit of a submodule differs between two revisions.
+//
+// The old commit is empty if the submodule was added, and the new commit is empty if it was removed.
+type SubmoduleChange struct {
+	Path      string `json:"path"`
+	OldCommit string `json:"oldCommit,omitempty"`
+	NewCommit string `json:"newCommit,omitempty"`
+}
+
+// BinaryChange describes how a binary file differs between two revisions.
+//
+// The old blob is empty if the file was added, and the new blob is empty if it was removed.
+type BinaryChange struct {
+	Path    string `json:"path"`
+	OldBlob string `json:"oldBlob,omitempty"`
+	NewBlob string `json:"newBlob,omitempty"`
+}
+
 // Repo represents a source code repository.
 type Repo interface {
 	// GetPath returns the path to the repo.
 	GetPath() string
 
+	// GetGitDir returns the absolute path of the repo's git directory.
+	//
+	// This is the ".git" directory of a repo with a worktree, and the repo itself if it is bare.
+	GetGitDir() (string, error)
+
+	// IsBare reports whether the repo is bare, and so has no worktree.
+	IsBare() (bool, error)
+
+	// Context returns the context that bounds the operations on the repo.
+	Context() context.Context
+
+	// WithContext returns a copy of the repo whose operations are abandoned once the given context is done.
+	//
+	// Operations that are abandoned return the context's error.
+	WithContext(ctx context.Context) Repo
+
+	// WithAuthor returns a copy of the repo whose writes, including the commits of
+	// the notes refs, are attributed to the given identity rather than to the user.
+	WithAuthor(name, email string) Repo
+
 	// GetRepoStateHash returns a hash which embodies the entire current state of a repository.
 	GetRepoStateHash() (string, error)
 
 	// GetUserEmail returns the email address that the user has used to configure git.
 	GetUserEmail() (string, error)
 
+	// GetUserSigningKey returns the key that the user has configured git to sign with,
+	// or an empty string if no signing key has been configured.
+	GetUserSigningKey() (string, error)
+
 	// GetCoreEditor returns the name of the editor that the user has used to configure git.
 	GetCoreEditor() (string, error)
 
+	// GetCorePager returns the name of the pager that the user has used to configure git.
+	GetCorePager() (string, error)
+
 	// GetSubmitStrategy returns the way in which a review is submitted
 	GetSubmitStrategy() (string, error)
 
+	// GetCoverageThreshold returns the minimum code coverage percentage that a review
+	// must have in order to be submitted, or 0 if there is no minimum.
+	GetCoverageThreshold() (float64, error)
+
+	// GetAssignStrategy returns the way in which reviewers are automatically assigned to a review.
+	GetAssignStrategy() (string, error)
+
+	// GetReviewerPool returns the reviewers that may be automatically assigned to a review.
+	GetReviewerPool() ([]string, error)
+
+	// GetIssueTrackers returns the configuration of each issue tracker that review issue IDs are linked to.
+	GetIssueTrackers() ([]string, error)
+
+	// GetAutoRequestPatterns returns the patterns of the branch names for which a
+	// review is automatically requested when they are first pushed.
+	GetAutoRequestPatterns() ([]string, error)
+
+	// GetAutoRequestTarget returns the target ref of automatically requested
+	// reviews, or an empty string if none has been configured.
+	GetAutoRequestTarget() (string, error)
+
+	// GetAutoSyncRemote returns the remote that review notes are automatically
+	// pulled from and pushed to by every command, or an empty string if they are not.
+	GetAutoSyncRemote() (string, error)
+
+	// GetAutoPushRemote returns the remote that review notes are automatically
+	// pushed to by every command that changes them, or an empty string if they are not.
+	GetAutoPushRemote() (string, error)
+
+	// GetConfig returns every value of the given git config setting.
+	GetConfig(key string) ([]string, error)
+
+	// SetConfig replaces the values of the given git config setting, or unsets
+	// it if no values are given. If global is set, then the user's global config
+	// is changed instead of the repo's.
+	SetConfig(key string, global bool, values ...string) error
+
 	// HasUncommittedChanges returns true if there are local, uncommitted changes.
 	HasUncommittedChanges() (bool, error)
 
@@ -91,12 +185,53 @@ type Repo interface {
 	// IsAncestor determines if the first argument points to a commit that is an ancestor of the second.
 	IsAncestor(ancestor, descendant string) (bool, error)
 
+	// GetLandingCommit returns the commit that brought the given commit into the history of the given ref.
+	//
+	// This is the oldest commit on the first-parent history of the ref that has the
+	// given commit as an ancestor: the commit itself if the ref was fast-forwarded to
+	// it, or else the merge commit that merged it. Both commits must already exist,
+	// and the given commit must be an ancestor of the ref.
+	GetLandingCommit(commit, ref string) (string, error)
+
+	// MergeConflicts returns the paths that would conflict if the second
+	// commit were merged into the first, or nil if they merge cleanly.
+	//
+	// This does not touch the working directory or the index.
+	MergeConflicts(ours, theirs string) ([]string, error)
+
 	// Diff computes the diff between two given commits.
 	Diff(left, right string, diffArgs ...string) (string, error)
 
+	// ListSubmoduleChanges returns the submodules whose commits differ between two given commits.
+	ListSubmoduleChanges(left, right string) ([]SubmoduleChange, error)
+
+	// ListBinaryChanges returns the binary files that differ between two given commits.
+	ListBinaryChanges(left, right string) ([]BinaryChange, error)
+
+	// GetSubmodule returns the repo checked out for the submodule at the given path.
+	//
+	// This returns an error if the submodule has not been checked out.
+	GetSubmodule(path string) (Repo, error)
+
 	// Show returns the contents of the given file at the given commit.
 	Show(commit, path string) (string, error)
 
+	// Blame returns the number of lines of the given file at the given commit
+	// that were last changed by each author, keyed by the author's email.
+	Blame(commit, path string) (map[string]int, error)
+
+	// GetPatchIDs returns the stable patch ID of each of the given commits, which
+	// stays the same when a commit is rebased or amended without changing its diff.
+	//
+	// Merge commits, and commits with an empty diff, have no patch ID.
+	GetPatchIDs(commits []string) (map[string]string, error)
+
+	// FormatPatch returns the given commit formatted as an email message, in the mbox format.
+	FormatPatch(commit string) (string, error)
+
+	// SetRef points the given ref at the given commit, or deletes the ref if the commit is empty.
+	SetRef(ref, commit string) error
+
 	// SwitchToRef changes the currently-checked-out ref.
 	SwitchToRef(ref string) error
 
@@ -122,6 +257,36 @@ type Repo interface {
 	// RebaseRef rebases the current ref onto the given one.
 	RebaseRef(ref string) error
 
+	// AbortRebase abandons a rebase that stopped partway through, such as
+	// because of a conflict, and restores the ref that was being rebased.
+	AbortRebase() error
+
+	// CherryPick applies the changes of the given commits, in order, as new
+	// commits on top of the current ref.
+	CherryPick(commits ...string) error
+
+	// AbortCherryPick abandons a cherry-pick that stopped partway through,
+	// such as because of a conflict, and restores the current ref.
+	AbortCherryPick() error
+
+	// SquashRef squashes the changes in the given ref into a single commit
+	// on top of the current ref, using the given commit message.
+	SquashRef(ref, message string) error
+
+	// ApplyPatch applies the given patch (in the unified diff format) to both
+	// the working directory and the index.
+	ApplyPatch(patch string) error
+
+	// ApplyPatchToTree applies the given patch (in the unified diff format) to
+	// the tree of the given commit, and returns the hash of the resulting tree.
+	//
+	// Neither the working directory nor the index are modified.
+	ApplyPatchToTree(commit, patch string) (string, error)
+
+	// Commit creates a new commit from the current contents of the index, and
+	// returns the hash of the newly created commit.
+	Commit(message string) (string, error)
+
 	// ListCommits returns the list of commits reachable from the given ref.
 	//
 	// The generated list is in chronological order (with the oldest commit first).
@@ -158,15 +323,90 @@ type Repo interface {
 	// AppendNote appends a note to a revision under the given ref.
 	AppendNote(ref, revision string, note Note) error
 
+	// AppendNotes appends each of the given notes to the revision that it is keyed by,
+	// under the given ref, in a single commit of the notes ref.
+	//
+	// This fails, without writing any of the notes, if the notes ref is updated
+	// by someone else while the notes are being written.
+	AppendNotes(notesRef string, notes map[string][]Note) error
+
+	// SetNotes replaces all of the notes annotating a revision under the given
+	// ref with the ones that update returns, given the current ones. If update
+	// returns no notes, then the revision's notes are removed.
+	//
+	// If another write updates the notes ref in the meantime, then update is
+	// called again with the notes that it left, so that it does not undo that
+	// write; update must therefore not have any side effects.
+	SetNotes(ref, revision string, update func(notes []Note) []Note) error
+
+	// RewriteNotes replaces the notes annotating the given revision, as SetNotes does, and rewrites the
+	// history of the notes ref so that none of its earlier versions remain reachable.
+	//
+	// This replaces the whole history of the notes ref with a single commit, with the
+	// given message, and so it has to be force-pushed to the remotes that have it.
+	RewriteNotes(notesRef, revision string, update func(notes []Note) []Note, message string) error
+
+	// MoveNotes moves the notes annotating the given objects from one notes ref to another.
+	//
+	// If an object is already annotated in the destination ref, then the moved
+	// note is merged into the existing one by taking the union of their lines.
+	MoveNotes(fromRef, toRef string, objects []string) error
+
+	// RemapNotes moves the notes under the given ref from each object in the mapping to the object that it maps to.
+	//
+	// Notes that the new object already has are merged with the moved ones by
+	// taking the union of their lines. The objects whose notes were moved are returned.
+	RemapNotes(notesRef string, mapping map[string]string) ([]string, error)
+
+	// RemoveNotes removes the notes annotating the given objects from the given notes ref.
+	RemoveNotes(notesRef string, objects []string) error
+
+	// ListOrphanedNotes returns the objects annotated by notes in the given ref that are
+	// missing from the repo, such as commits that were garbage collected after a rebase.
+	ListOrphanedNotes(notesRef string) ([]string, error)
+
+	// GetAddedNotes returns the notes that were added between two commits of a notes ref,
+	// keyed by the objects that they annotate.
+	//
+	// The old commit may be empty, for a notes ref that did not exist before. Unlike
+	// GetAllNotes, this includes the notes of objects that are missing from the repo.
+	GetAddedNotes(oldCommit, newCommit string) (map[string][]Note, error)
+
+	// StoreBlob writes the given contents to the repo as a blob, and returns its hash.
+	//
+	// The blob is kept reachable, so that it is neither garbage collected nor
+	// left out when pushing or pulling notes, by annotating it with itself
+	// under the given notes ref.
+	StoreBlob(notesRef string, contents []byte) (string, error)
+
+	// ReadBlob returns the contents of the blob with the given hash.
+	ReadBlob(hash string) ([]byte, error)
+
 	// ListNotedRevisions returns the collection of revisions that are annotated by notes in the given ref.
 	ListNotedRevisions(notesRef string) []string
 
 	// PushNotes pushes git notes to a remote repo.
 	PushNotes(remote, notesRefPattern string) error
 
+	// PushRefs pushes the given refs to the refs of the same names in a remote repo.
+	//
+	// If force is set, then the remote refs are updated even if that
+	// discards commits that are not in the local refs.
+	PushRefs(remote string, force bool, refs ...string) error
+
+	// PushRefWithLease pushes the given ref to the ref of the same name in a
+	// remote repo, replacing it only if it still points to the expected commit.
+	PushRefWithLease(remote, ref, expected string) error
+
+	// FetchRefs fetches the given refs from the refs of the same names in a remote repo.
+	//
+	// The local refs are updated even if that discards commits that are not in
+	// the remote refs, so none of them may be checked out.
+	FetchRefs(remote string, refs ...string) error
+
 	// PullNotes fetches the contents of the given notes ref from a remote repo,
-	// and then merges them with the corresponding local notes using the
-	// "cat_sort_uniq" strategy.
+	// and then merges them with the corresponding local notes, taking the union
+	// of the notes for each object.
 	PullNotes(remote, notesRefPattern string) error
 
 	// PushNotesAndArchive pushes the given notes and archive refs to a remote repo.
@@ -175,9 +415,10 @@ type Repo interface {
 	// PullNotesAndArchive fetches the contents of the notes and archives refs from
 	// a remote repo, and merges them with the corresponding local refs.
 	//
-	// For notes refs, we assume that every note can be automatically merged using
-	// the 'cat_sort_uniq' strategy (the git-appraise schemas fit that requirement),
-	// so we automatically merge the remote notes into the local notes.
+	// For notes refs, we assume that every note is an append-only set of lines
+	// (the git-appraise schemas fit that requirement), so we automatically merge
+	// the remote notes into the local notes by taking their union. Notes that
+	// were removed on one side, and left unchanged on the other, stay removed.
 	//
 	// For "archive" refs, they are expected to be used solely for maintaining
 	// reachability of commits that are part of the history of any reviews,
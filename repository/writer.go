@@ -0,0 +1,86 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"time"
+)
+
+// NoteWriter stages note appends to a single notes ref, and writes them in batches.
+//
+// Every batch is written with a single commit of the notes ref; see AppendNotes.
+// This is much faster than appending the notes one at a time when there are
+// many of them, such as the comments posted by a bot, and keeps the notes ref
+// from being updated once for each of them.
+//
+// A NoteWriter is not safe for concurrent use.
+type NoteWriter struct {
+	repo     Repo
+	notesRef string
+	// Interval is the minimum time between the batches written by Append. If it is
+	// zero, then the staged notes are only written by Flush.
+	Interval time.Duration
+	staged   map[string][]Note
+	count    int
+	written  time.Time
+}
+
+// NewNoteWriter returns a writer that stages note appends to the given notes ref.
+func NewNoteWriter(repo Repo, notesRef string, interval time.Duration) *NoteWriter {
+	return &NoteWriter{
+		repo:     repo,
+		notesRef: notesRef,
+		Interval: interval,
+		staged:   make(map[string][]Note),
+		written:  time.Now(),
+	}
+}
+
+// Append stages a note to be appended to the given revision.
+//
+// If the interval has passed since the last batch was written, then the staged
+// notes, including this one, are written.
+func (w *NoteWriter) Append(revision string, note Note) error {
+	w.staged[revision] = append(w.staged[revision], note)
+	w.count++
+	if w.Interval > 0 && time.Since(w.written) >= w.Interval {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Pending returns the number of notes that have been staged but not yet written.
+func (w *NoteWriter) Pending() int {
+	return w.count
+}
+
+// Flush writes all of the staged notes in a single batch.
+//
+// If writing the batch fails, then none of its notes are written, and they
+// remain staged so that a later call can retry them.
+func (w *NoteWriter) Flush() error {
+	if w.count == 0 {
+		return nil
+	}
+	if err := w.repo.AppendNotes(w.notesRef, w.staged); err != nil {
+		return err
+	}
+	w.staged = make(map[string][]Note)
+	w.count = 0
+	w.written = time.Now()
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+// countNotes returns the number of non-empty notes annotating the given revision.
+func countNotes(repo Repo, notesRef, revision string) int {
+	count := 0
+	for _, note := range repo.GetNotes(notesRef, revision) {
+		if len(note) > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func TestNoteWriter(t *testing.T) {
+	repo := NewMockRepoForTest()
+	const notesRef = "refs/notes/test"
+	writer := NewNoteWriter(repo, notesRef, 0)
+	for _, note := range []Note{Note("first"), Note("second")} {
+		if err := writer.Append(TestCommitB, note); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pending := writer.Pending(); pending != 2 || countNotes(repo, notesRef, TestCommitB) != 0 {
+		t.Fatalf("Unexpected notes written before the writer was flushed: %d pending", pending)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if count := countNotes(repo, notesRef, TestCommitB); count != 2 || writer.Pending() != 0 {
+		t.Fatalf("Unexpected number of notes after the writer was flushed: %d", count)
+	}
+	writer.Interval = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if err := writer.Append(TestCommitD, Note("third")); err != nil {
+		t.Fatal(err)
+	}
+	if count := countNotes(repo, notesRef, TestCommitD); count != 1 || writer.Pending() != 0 {
+		t.Fatalf("Unexpected number of notes after the interval passed: %d", count)
+	}
+}
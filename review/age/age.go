@@ -0,0 +1,67 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package age contains helper methods for encrypting review metadata to age keys, and for
+// decrypting it, using the age command line tool.
+package age
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// IsRecipient reports whether the given recipient is an age key, rather than a GPG user ID.
+//
+// Besides native X25519 keys, age can encrypt to SSH public keys.
+func IsRecipient(recipient string) bool {
+	return strings.HasPrefix(recipient, "age1") || strings.HasPrefix(recipient, "ssh-")
+}
+
+// Run age with the given stdin and arguments, returning its stdout and stderr.
+func runAge(stdin []byte, args ...string) (string, string, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd := exec.Command("age", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.String(), strings.TrimSpace(stderr.String()), err
+}
+
+// Encrypt encrypts the given content to the given age keys, returning the ASCII-armored ciphertext.
+func Encrypt(recipients []string, content []byte) (string, error) {
+	args := []string{"--encrypt", "--armor"}
+	for _, recipient := range recipients {
+		args = append(args, "--recipient", recipient)
+	}
+	ciphertext, stderr, err := runAge(content, args...)
+	if err != nil {
+		return "", fmt.Errorf("Failed to encrypt the metadata: %s", stderr)
+	}
+	return ciphertext, nil
+}
+
+// Decrypt decrypts the given ASCII-armored ciphertext with the identity in the given file.
+func Decrypt(ciphertext, identityFile string) ([]byte, error) {
+	content, stderr, err := runAge([]byte(ciphertext), "--decrypt", "--identity", identityFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt the metadata: %s", stderr)
+	}
+	return []byte(content), nil
+}
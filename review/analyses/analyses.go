@@ -20,10 +20,10 @@ package analyses
 import (
 	"encoding/json"
 	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/schema"
 	"io/ioutil"
 	"net/http"
-	"sort"
-	"strconv"
+	"time"
 )
 
 const (
@@ -67,6 +67,8 @@ type Note struct {
 	Location    *Location `json:"location,omitempty"`
 	Category    string    `json:"category,omitempty"`
 	Description string    `json:"description"`
+	// Severity is the level reported by the analyzer (e.g. "error" or "warning"), if any.
+	Severity string `json:"severity,omitempty"`
 }
 
 // AnalyzeResponse represents the response from a static-analysis tool.
@@ -80,6 +82,8 @@ type ReportDetails struct {
 }
 
 // GetLintReportResult downloads the details of a lint report and returns the responses embedded in it.
+//
+// The details may either be in the format described by the analysis schema, or a SARIF log.
 func (analysesReport Report) GetLintReportResult() ([]AnalyzeResponse, error) {
 	if analysesReport.URL == "" {
 		return nil, nil
@@ -93,6 +97,9 @@ func (analysesReport Report) GetLintReportResult() ([]AnalyzeResponse, error) {
 	if err != nil {
 		return nil, err
 	}
+	if IsSARIF(analysesResults) {
+		return ParseSARIF(analysesResults)
+	}
 	var details ReportDetails
 	err = json.Unmarshal([]byte(analysesResults), &details)
 	if err != nil {
@@ -124,22 +131,19 @@ func Parse(note repository.Note) (Report, error) {
 
 // GetLatestAnalysesReport takes a collection of analysis reports, and returns the one with the most recent timestamp.
 func GetLatestAnalysesReport(reports []Report) (*Report, error) {
-	timestampReportMap := make(map[int]*Report)
-	var timestamps []int
-
-	for _, report := range reports {
-		timestamp, err := strconv.Atoi(report.Timestamp)
+	var latest *Report
+	var latestTime time.Time
+	for i, report := range reports {
+		timestamp, err := schema.ParseTimestamp(report.Timestamp)
 		if err != nil {
 			return nil, err
 		}
-		timestamps = append(timestamps, timestamp)
-		timestampReportMap[timestamp] = &report
-	}
-	if len(timestamps) == 0 {
-		return nil, nil
+		// Of the reports with the same timestamp, the last one wins.
+		if latest == nil || !timestamp.Before(latestTime) {
+			latest, latestTime = &reports[i], timestamp
+		}
 	}
-	sort.Sort(sort.Reverse(sort.IntSlice(timestamps)))
-	return timestampReportMap[timestamps[0]], nil
+	return latest, nil
 }
 
 // ParseAllValid takes collection of git notes and tries to parse a analyses report
@@ -147,6 +151,10 @@ func GetLatestAnalysesReport(reports []Report) (*Report, error) {
 func ParseAllValid(notes []repository.Note) []Report {
 	var reports []Report
 	for _, note := range notes {
+		note, err := schema.Upgrade(Ref, note, FormatVersion)
+		if err != nil {
+			continue
+		}
 		report, err := Parse(note)
 		if err == nil && report.Version == FormatVersion {
 			reports = append(reports, report)
@@ -43,6 +43,38 @@ const (
 }`
 )
 
+const mockSARIFResults = `{
+  "version": "2.1.0",
+  "runs": [{
+    "tool": {
+      "driver": {
+        "name": "golangci-lint",
+        "rules": [{"id": "errcheck", "shortDescription": {"text": "Unchecked error"}}]
+      }
+    },
+    "results": [{
+      "ruleId": "errcheck",
+      "level": "error",
+      "message": {"text": "Error return value is not checked"},
+      "locations": [{
+        "physicalLocation": {
+          "artifactLocation": {"uri": "file:///src/main.go"},
+          "region": {"startLine": 12}
+        }
+      }]
+    }, {
+      "ruleIndex": 0,
+      "message": {},
+      "locations": [{
+        "physicalLocation": {
+          "artifactLocation": {"uri": "./main.go"},
+          "region": {"startLine": 3}
+        }
+      }]
+    }]
+  }]
+}`
+
 func mockHandler(t *testing.T) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		t.Log(r)
@@ -75,3 +107,52 @@ func TestGetLatestResult(t *testing.T) {
 		t.Fatal("Unexpected report result", reportResult)
 	}
 }
+
+func TestParseSARIF(t *testing.T) {
+	if !IsSARIF([]byte(mockSARIFResults)) || IsSARIF([]byte(mockResults)) {
+		t.Fatal("Failed to detect the format of analysis results")
+	}
+	responses, err := ParseSARIF([]byte(mockSARIFResults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || len(responses[0].Notes) != 2 {
+		t.Fatal("Unexpected analysis responses", responses)
+	}
+	notesByFile := NotesByFile(responses[0].Notes)
+	if len(notesByFile["/src/main.go"]) != 1 {
+		t.Fatal("Unexpected notes for an absolute file URI", notesByFile)
+	}
+	relativeNotes := notesByFile["main.go"]
+	if len(relativeNotes) != 1 {
+		t.Fatal("Unexpected notes for a relative file URI", notesByFile)
+	}
+	note := relativeNotes[0]
+	if note.Category != "errcheck" || note.Description != "Unchecked error" || note.Location.Range.StartLine != 3 {
+		t.Fatal("Unexpected note for a result referencing a rule by index", note)
+	}
+}
+
+func TestParseSARIFWithoutRule(t *testing.T) {
+	results := `{
+  "version": "2.1.0",
+  "runs": [{
+    "tool": {"driver": {"name": "lint", "rules": [{"id": "errcheck"}]}},
+    "results": [{
+      "ruleIndex": -1,
+      "message": {"text": "Something is wrong"},
+      "locations": [{"physicalLocation": {"artifactLocation": {"uri": "main.go"}, "region": {"startLine": 1}}}]
+    }]
+  }]
+}`
+	responses, err := ParseSARIF([]byte(results))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || len(responses[0].Notes) != 1 {
+		t.Fatal("Unexpected analysis responses", responses)
+	}
+	if note := responses[0].Notes[0]; note.Category != "" || note.Description != "Something is wrong" {
+		t.Fatal("Unexpected note for a result without a rule", note)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package analyses
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// The following types represent the subset of the SARIF (Static Analysis
+// Results Interchange Format) log format that we use.
+//
+// The full specification is available at
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+type sarifLog struct {
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex *int            `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// IsSARIF returns whether or not the given analysis results are formatted as a SARIF log.
+func IsSARIF(results []byte) bool {
+	var log struct {
+		Runs json.RawMessage `json:"runs"`
+	}
+	return json.Unmarshal(results, &log) == nil && log.Runs != nil
+}
+
+// sarifPath converts a SARIF artifact URI into a path relative to the root of the repository.
+func sarifPath(uri string) string {
+	if parsed, err := url.Parse(uri); err == nil && parsed.Scheme == "file" {
+		uri = parsed.Path
+	} else if unescaped, err := url.PathUnescape(uri); err == nil {
+		uri = unescaped
+	}
+	return strings.TrimPrefix(uri, "./")
+}
+
+// toNote converts a single SARIF result into an analysis note.
+func (result sarifResult) toNote(rules []sarifRule) Note {
+	category := result.RuleID
+	if category == "" && result.RuleIndex != nil && *result.RuleIndex >= 0 && *result.RuleIndex < len(rules) {
+		category = rules[*result.RuleIndex].ID
+	}
+	description := result.Message.Text
+	if description == "" {
+		for _, rule := range rules {
+			if rule.ID == category {
+				description = rule.ShortDescription.Text
+			}
+		}
+	}
+	note := Note{
+		Category:    category,
+		Description: description,
+		Severity:    result.Level,
+	}
+	if len(result.Locations) > 0 {
+		physicalLocation := result.Locations[0].PhysicalLocation
+		note.Location = &Location{
+			Path: sarifPath(physicalLocation.ArtifactLocation.URI),
+		}
+		if physicalLocation.Region != nil && physicalLocation.Region.StartLine > 0 {
+			note.Location.Range = &LocationRange{
+				StartLine: physicalLocation.Region.StartLine,
+			}
+		}
+	}
+	return note
+}
+
+// ParseSARIF parses a SARIF log, and returns the results as analysis responses.
+//
+// Each run in the log (which corresponds to a single analysis tool) is
+// converted to a separate analysis response.
+func ParseSARIF(results []byte) ([]AnalyzeResponse, error) {
+	var log sarifLog
+	if err := json.Unmarshal(results, &log); err != nil {
+		return nil, fmt.Errorf("Failure parsing the SARIF log: %v", err)
+	}
+	var responses []AnalyzeResponse
+	for _, run := range log.Runs {
+		var response AnalyzeResponse
+		for _, result := range run.Results {
+			response.Notes = append(response.Notes, result.toNote(run.Tool.Driver.Rules))
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+// NotesByFile groups the given analysis notes by the file they apply to.
+//
+// The notes for each file are sorted by line number. Notes that do not
+// specify a location are grouped under the empty string.
+func NotesByFile(notes []Note) map[string][]Note {
+	result := make(map[string][]Note)
+	for _, note := range notes {
+		path := ""
+		if note.Location != nil {
+			path = note.Location.Path
+		}
+		result[path] = append(result[path], note)
+	}
+	for _, fileNotes := range result {
+		sort.Stable(notesByLine(fileNotes))
+	}
+	return result
+}
+
+type notesByLine []Note
+
+// Interface methods for sorting analysis notes by line number
+func (notes notesByLine) Len() int      { return len(notes) }
+func (notes notesByLine) Swap(i, j int) { notes[i], notes[j] = notes[j], notes[i] }
+func (notes notesByLine) Less(i, j int) bool {
+	return notes[i].startLine() < notes[j].startLine()
+}
+
+func (note Note) startLine() int {
+	if note.Location == nil || note.Location.Range == nil {
+		return 0
+	}
+	return note.Location.Range.StartLine
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package analyzer runs the static analyzers configured for a repository against a review.
+//
+// Analyzers are configured in a JSON file that lists, for each analyzer, the
+// command to run and the format of its output. The output of each analyzer is
+// converted into robot findings, so that the results of every analyzer are
+// recorded the same way regardless of the tool that produced them.
+package analyzer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/robot"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfigFile is the path, relative to the root of the repository, of the analyzer configuration.
+const ConfigFile = ".appraise/analyzers.json"
+
+// The formats that an analyzer's output may be in.
+const (
+	// FormatText is one finding per line, in the form "<path>:<line>[:<column>]: <message>".
+	FormatText = "text"
+	// FormatJSON is a JSON list of robot findings.
+	FormatJSON = "json"
+	// FormatSARIF is a SARIF log.
+	FormatSARIF = "sarif"
+)
+
+// Analyzer describes how to run a single analyzer.
+type Analyzer struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+	// Format is the format of the analyzer's standard output; defaults to FormatText.
+	Format string `json:"format,omitempty"`
+	// Files, if set, restricts the analyzer to the changed files matching one
+	// of these patterns. The matching files are passed to the command as
+	// additional arguments, and the analyzer is skipped if there are none.
+	Files []string `json:"files,omitempty"`
+	// Severity is the severity given to findings in the text format; defaults to a warning.
+	Severity string `json:"severity,omitempty"`
+}
+
+// Config is the set of analyzers configured for a repository.
+type Config struct {
+	Analyzers []Analyzer `json:"analyzers"`
+}
+
+// Parse parses and validates an analyzer configuration.
+func Parse(contents []byte) (*Config, error) {
+	var config Config
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i, analyzer := range config.Analyzers {
+		if analyzer.Name == "" {
+			return nil, fmt.Errorf("Analyzer number %d does not have a name.", i+1)
+		}
+		if names[analyzer.Name] {
+			return nil, fmt.Errorf("There are multiple analyzers named %q.", analyzer.Name)
+		}
+		names[analyzer.Name] = true
+		if len(analyzer.Command) == 0 {
+			return nil, fmt.Errorf("The analyzer %q does not have a command.", analyzer.Name)
+		}
+		switch analyzer.Format {
+		case "":
+			config.Analyzers[i].Format = FormatText
+		case FormatText, FormatJSON, FormatSARIF:
+		default:
+			return nil, fmt.Errorf("The analyzer %q has an unknown format %q.", analyzer.Name, analyzer.Format)
+		}
+		if analyzer.Severity == "" {
+			config.Analyzers[i].Severity = robot.SeverityWarning
+		} else if !robot.IsValidSeverity(analyzer.Severity) {
+			return nil, fmt.Errorf("The analyzer %q has an unknown severity %q.", analyzer.Name, analyzer.Severity)
+		}
+	}
+	return &config, nil
+}
+
+// Load reads the analyzer configuration from the given commit.
+//
+// The configuration is read from the commit being merged into, rather than
+// from the review itself, so that a review cannot change the commands that
+// are run against it. If there is no configuration, then this returns nil.
+func Load(repo repository.Repo, commit string) (*Config, error) {
+	contents, err := repo.Show(commit, ConfigFile)
+	if err != nil {
+		return nil, nil
+	}
+	config, err := Parse([]byte(contents))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %q: %v", ConfigFile, err)
+	}
+	return config, nil
+}
+
+// Matches returns whether or not the analyzer applies to the given path.
+func (analyzer Analyzer) Matches(filePath string) bool {
+	if len(analyzer.Files) == 0 {
+		return true
+	}
+	for _, pattern := range analyzer.Files {
+		if matched, _ := path.Match(pattern, filePath); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, path.Base(filePath)); matched && !strings.Contains(pattern, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+var textFindingPattern = regexp.MustCompile(`^(?:\./)?([^:\s][^:]*):(\d+)(?::\d+)?:\s*(.*)$`)
+
+// parseText parses findings in the text format, ignoring any lines that are not findings.
+func parseText(output []byte, severity string) []robot.Finding {
+	var findings []robot.Finding
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		match := textFindingPattern.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		line, _ := strconv.ParseUint(match[2], 10, 32)
+		findings = append(findings, robot.Finding{
+			Location: &comment.Location{
+				Path:  match[1],
+				Range: &comment.Range{StartLine: uint32(line)},
+			},
+			Severity:    severity,
+			Description: match[3],
+		})
+	}
+	return findings
+}
+
+// ParseOutput converts the output of the analyzer into findings.
+func (analyzer Analyzer) ParseOutput(output []byte) ([]robot.Finding, error) {
+	switch analyzer.Format {
+	case FormatJSON, FormatSARIF:
+		if len(bytes.TrimSpace(output)) == 0 {
+			return nil, nil
+		}
+		return robot.ParseFindings(output)
+	}
+	return parseText(output, analyzer.Severity), nil
+}
+
+// Run runs the analyzer in the given directory against the given changed files.
+//
+// Analyzers commonly exit with a failure status when they report findings,
+// so a failure is only treated as an error if the analyzer reported nothing.
+func (analyzer Analyzer) Run(dir string, changedFiles []string) ([]robot.Finding, error) {
+	args := append([]string(nil), analyzer.Command[1:]...)
+	if len(analyzer.Files) > 0 {
+		for _, file := range changedFiles {
+			if analyzer.Matches(file) {
+				args = append(args, file)
+			}
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(analyzer.Command[0], args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	if _, ok := runErr.(*exec.ExitError); runErr != nil && !ok {
+		return nil, runErr
+	}
+	findings, err := analyzer.ParseOutput(stdout.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if analyzer.Format == FormatText && len(findings) == 0 {
+		// Many tools (e.g. "go vet") write their findings to stderr instead.
+		findings = parseText(stderr.Bytes(), analyzer.Severity)
+	}
+	if runErr != nil && len(findings) == 0 {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, errors.New(message)
+		}
+		return nil, runErr
+	}
+	return findings, nil
+}
+
+// FilterFindings returns the findings that are about one of the given files,
+// along with any findings that are not about a specific file.
+func FilterFindings(findings []robot.Finding, files []string) []robot.Finding {
+	changed := make(map[string]bool)
+	for _, file := range files {
+		changed[file] = true
+	}
+	var result []robot.Finding
+	for _, finding := range findings {
+		if finding.Location == nil || finding.Location.Path == "" || changed[finding.Location.Path] {
+			result = append(result, finding)
+		}
+	}
+	return result
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package analyzer
+
+import (
+	"github.com/promet/git-appraise/review/robot"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(`{"analyzers": [
+		{"name": "vet", "command": ["go", "vet", "./..."]},
+		{"name": "lint", "command": ["golint"], "format": "sarif", "files": ["*.go"], "severity": "info"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Analyzers) != 2 || config.Analyzers[0].Format != FormatText || config.Analyzers[0].Severity != robot.SeverityWarning {
+		t.Errorf("Unexpected analyzers: %v", config.Analyzers)
+	}
+
+	invalid := []string{
+		`{"analyzers": [{"command": ["true"]}]}`,
+		`{"analyzers": [{"name": "a", "command": ["true"]}, {"name": "a", "command": ["true"]}]}`,
+		`{"analyzers": [{"name": "a"}]}`,
+		`{"analyzers": [{"name": "a", "command": ["true"], "format": "xml"}]}`,
+		`{"analyzers": [{"name": "a", "command": ["true"], "severity": "fatal"}]}`,
+	}
+	for _, contents := range invalid {
+		if _, err := Parse([]byte(contents)); err == nil {
+			t.Errorf("Failed to reject the invalid config %s", contents)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	a := Analyzer{Files: []string{"*.go", "docs/*.md"}}
+	testCases := map[string]bool{
+		"main.go":          true,
+		"review/review.go": true,
+		"docs/index.md":    true,
+		"README.md":        false,
+		"other/docs/a.md":  false,
+	}
+	for path, expected := range testCases {
+		if a.Matches(path) != expected {
+			t.Errorf("Unexpected match result for %q", path)
+		}
+	}
+	if !(Analyzer{}).Matches("anything") {
+		t.Error("An analyzer without file patterns did not match every file")
+	}
+}
+
+func TestRun(t *testing.T) {
+	a := Analyzer{
+		Name:     "test",
+		Command:  []string{"sh", "-c", `echo "./foo.go:12:3: unused variable"; echo "not a finding"; echo "bar.go:4: shadowed" >&2; exit 1`},
+		Format:   FormatText,
+		Severity: robot.SeverityError,
+	}
+	findings, err := a.Run(".", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Location.Path != "foo.go" || findings[0].Location.Range.StartLine != 12 ||
+		findings[0].Description != "unused variable" || findings[0].Severity != robot.SeverityError {
+		t.Errorf("Unexpected findings: %v", findings)
+	}
+	if filtered := FilterFindings(findings, []string{"bar.go"}); len(filtered) != 0 {
+		t.Errorf("Unexpected findings after filtering: %v", filtered)
+	}
+
+	a.Command = []string{"sh", "-c", "echo broken >&2; exit 2"}
+	if _, err := a.Run(".", nil); err == nil || err.Error() != "broken" {
+		t.Errorf("Unexpected error from a failing analyzer: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package anchor translates the locations of comments between revisions of a file.
+//
+// Comments are anchored to a line of a file at a specific commit. When the
+// review is updated (and in particular when it is rebased), that commit is
+// no longer the one being reviewed, so its anchors are translated to the new
+// head using the diff between the two commits. Comments on lines that were
+// themselves changed or removed cannot be translated, and are reported as
+// outdated instead.
+package anchor
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/comment"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// hunk is the range of lines replaced by a single hunk of a diff.
+type hunk struct {
+	oldStart, oldCount uint32
+	newStart, newCount uint32
+}
+
+// fileMap describes how the lines of a single file changed.
+type fileMap struct {
+	newPath string
+	deleted bool
+	hunks   []hunk
+}
+
+// LineMap translates lines in the old side of a diff to the new side.
+type LineMap map[string]*fileMap
+
+func parseCount(count string) uint32 {
+	if count == "" {
+		return 1
+	}
+	n, _ := strconv.ParseUint(count, 10, 32)
+	return uint32(n)
+}
+
+// NewLineMap builds a line map from a diff in the git format.
+//
+// The diff should be generated without any context lines (i.e. with "-U0"),
+// so that only the lines that actually changed are treated as lost.
+func NewLineMap(diff string) LineMap {
+	lineMap := make(LineMap)
+	var oldPath string
+	var file *fileMap
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			file = &fileMap{}
+			oldPath = ""
+			if i := strings.LastIndex(line, " b/"); i >= 0 {
+				file.newPath = line[i+3:]
+				if j := strings.Index(line, " a/"); j >= 0 && j < i {
+					oldPath = line[j+3 : i]
+				}
+			}
+			lineMap[oldPath] = file
+		case file == nil:
+			continue
+		case strings.HasPrefix(line, "rename from "):
+			delete(lineMap, oldPath)
+			oldPath = strings.TrimPrefix(line, "rename from ")
+			lineMap[oldPath] = file
+		case strings.HasPrefix(line, "rename to "):
+			file.newPath = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "--- "):
+			if path := strings.TrimPrefix(line, "--- "); path != "/dev/null" {
+				delete(lineMap, oldPath)
+				oldPath = strings.TrimPrefix(path, "a/")
+				lineMap[oldPath] = file
+			}
+		case strings.HasPrefix(line, "+++ "):
+			if path := strings.TrimPrefix(line, "+++ "); path == "/dev/null" {
+				file.deleted = true
+			} else {
+				file.newPath = strings.TrimPrefix(path, "b/")
+			}
+		case hunkHeaderPattern.MatchString(line):
+			match := hunkHeaderPattern.FindStringSubmatch(line)
+			oldStart, _ := strconv.ParseUint(match[1], 10, 32)
+			newStart, _ := strconv.ParseUint(match[3], 10, 32)
+			file.hunks = append(file.hunks, hunk{
+				oldStart: uint32(oldStart),
+				oldCount: parseCount(match[2]),
+				newStart: uint32(newStart),
+				newCount: parseCount(match[4]),
+			})
+		}
+	}
+	return lineMap
+}
+
+// Translate returns the path and line in the new side of the diff that
+// corresponds to the given path and line in the old side.
+//
+// A line of 0 refers to the file as a whole. The returned boolean is false
+// if the line was changed or removed, or if the file was deleted.
+func (lineMap LineMap) Translate(path string, line uint32) (string, uint32, bool) {
+	file, ok := lineMap[path]
+	if !ok {
+		return path, line, true
+	}
+	if file.deleted {
+		return path, line, false
+	}
+	if line == 0 {
+		return file.newPath, 0, true
+	}
+	newLine := int64(line)
+	for _, h := range file.hunks {
+		if h.oldCount == 0 {
+			// A pure insertion, which follows the line numbered oldStart.
+			if line > h.oldStart {
+				newLine += int64(h.newCount)
+			}
+			continue
+		}
+		if line < h.oldStart {
+			continue
+		}
+		if line < h.oldStart+h.oldCount {
+			return file.newPath, 0, false
+		}
+		newLine += int64(h.newCount) - int64(h.oldCount)
+	}
+	return file.newPath, uint32(newLine), true
+}
+
+// Remapper translates comment locations from any commit to a single head commit.
+//
+// The line map for each commit is computed once, and then reused for every
+// comment on that commit.
+type Remapper struct {
+	repo     repository.Repo
+	head     string
+	lineMaps map[string]LineMap
+}
+
+// NewRemapper returns a remapper that translates locations to the given head commit.
+func NewRemapper(repo repository.Repo, head string) *Remapper {
+	return &Remapper{
+		repo:     repo,
+		head:     head,
+		lineMaps: make(map[string]LineMap),
+	}
+}
+
+func (remapper *Remapper) getLineMap(commit string) (LineMap, error) {
+	if lineMap, ok := remapper.lineMaps[commit]; ok {
+		return lineMap, nil
+	}
+	diff, err := remapper.repo.Diff(commit, remapper.head, "-U0", "-M", "--no-color")
+	if err != nil {
+		return nil, err
+	}
+	lineMap := NewLineMap(diff)
+	remapper.lineMaps[commit] = lineMap
+	return lineMap, nil
+}
+
+// Remap returns the location in the head commit that corresponds to the given location.
+//
+// The returned boolean is false if the location is outdated, meaning that the
+// line it refers to has since been changed or removed. In that case, the
+// original location is returned unchanged.
+func (remapper *Remapper) Remap(location comment.Location) (comment.Location, bool, error) {
+	if location.Commit == "" || location.Commit == remapper.head || location.Path == "" {
+		return location, true, nil
+	}
+	lineMap, err := remapper.getLineMap(location.Commit)
+	if err != nil {
+		return location, false, err
+	}
+	var line uint32
+	if location.Range != nil {
+		line = location.Range.StartLine
+	}
+	path, newLine, ok := lineMap.Translate(location.Path, line)
+	if !ok {
+		return location, false, nil
+	}
+	remapped := comment.Location{Commit: remapper.head, Path: path}
+	if location.Range != nil {
+		remapped.Range = &comment.Range{StartLine: newLine}
+	}
+	return remapped, true, nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package anchor
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/comment"
+	"testing"
+)
+
+const testDiff = `diff --git a/foo.txt b/foo.txt
+index 1111111..2222222 100644
+--- a/foo.txt
++++ b/foo.txt
+@@ -2,0 +3,2 @@ first
++inserted
++inserted
+@@ -5,2 +7 @@ fourth
+-fifth
+-sixth
++replaced
+diff --git a/old.txt b/new.txt
+similarity index 100%
+rename from old.txt
+rename to new.txt
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+index 3333333..0000000
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-gone
+`
+
+func TestTranslate(t *testing.T) {
+	lineMap := NewLineMap(testDiff)
+	testCases := []struct {
+		path     string
+		line     uint32
+		newPath  string
+		newLine  uint32
+		expectOK bool
+	}{
+		{"foo.txt", 1, "foo.txt", 1, true},
+		{"foo.txt", 2, "foo.txt", 2, true},
+		{"foo.txt", 3, "foo.txt", 5, true},
+		{"foo.txt", 4, "foo.txt", 6, true},
+		{"foo.txt", 5, "foo.txt", 0, false},
+		{"foo.txt", 6, "foo.txt", 0, false},
+		{"foo.txt", 7, "foo.txt", 8, true},
+		{"foo.txt", 0, "foo.txt", 0, true},
+		{"old.txt", 3, "new.txt", 3, true},
+		{"gone.txt", 1, "gone.txt", 1, false},
+		{"other.txt", 9, "other.txt", 9, true},
+	}
+	for _, testCase := range testCases {
+		newPath, newLine, ok := lineMap.Translate(testCase.path, testCase.line)
+		if ok != testCase.expectOK || (ok && (newPath != testCase.newPath || newLine != testCase.newLine)) {
+			t.Errorf("Unexpected translation of %s:%d: %s:%d, %v", testCase.path, testCase.line, newPath, newLine, ok)
+		}
+	}
+}
+
+// diffRepo is a repo that returns the same diff between any two commits.
+type diffRepo struct {
+	repository.Repo
+	diff  string
+	diffs int
+}
+
+func (repo *diffRepo) Diff(left, right string, diffArgs ...string) (string, error) {
+	repo.diffs++
+	return repo.diff, nil
+}
+
+func TestRemap(t *testing.T) {
+	repo := &diffRepo{diff: testDiff}
+	remapper := NewRemapper(repo, "head")
+
+	location, ok, err := remapper.Remap(comment.Location{Commit: "old", Path: "foo.txt", Range: &comment.Range{StartLine: 4}})
+	if err != nil || !ok || location.Commit != "head" || location.Path != "foo.txt" || location.Range.StartLine != 6 {
+		t.Errorf("Unexpected remapped location: %v, %v, %v", location, ok, err)
+	}
+	original := comment.Location{Commit: "old", Path: "foo.txt", Range: &comment.Range{StartLine: 5}}
+	location, ok, err = remapper.Remap(original)
+	if err != nil || ok || location.Commit != "old" || location.Range.StartLine != 5 {
+		t.Errorf("Unexpected location for an outdated comment: %v, %v, %v", location, ok, err)
+	}
+	location, ok, err = remapper.Remap(comment.Location{Commit: "old", Path: "old.txt"})
+	if err != nil || !ok || location.Path != "new.txt" || location.Range != nil {
+		t.Errorf("Unexpected location for a comment on a renamed file: %v, %v, %v", location, ok, err)
+	}
+	if repo.diffs != 1 {
+		t.Errorf("Unexpected number of diffs computed: %d", repo.diffs)
+	}
+	if _, ok, _ := remapper.Remap(comment.Location{Commit: "head", Path: "foo.txt"}); !ok || repo.diffs != 1 {
+		t.Errorf("Computed a diff for a comment on the head commit")
+	}
+}
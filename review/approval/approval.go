@@ -0,0 +1,129 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package approval defines the marks that record whether an approval still applies to a review after new revisions.
+package approval
+
+import (
+	"encoding/json"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Ref defines the git-notes ref that we expect to contain approval marks.
+const Ref = "refs/notes/pullrequests/approvals"
+
+// FormatVersion defines the latest version of the approval mark format supported by the tool.
+const FormatVersion = 0
+
+// The statuses of an approval mark.
+const (
+	// StatusStale means that the approval was of an earlier revision, and no longer counts.
+	StatusStale = "stale"
+	// StatusCarriedForward means that the approval counts for the revision of the mark,
+	// as if it had been given for that revision.
+	StatusCarriedForward = "carried-forward"
+)
+
+// Mark records that an approval became stale when a new revision was pushed, or that it was carried forward to that revision.
+//
+// Marks annotate the first revision in a review, like the approvals they refer to.
+type Mark struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Author    string `json:"author,omitempty"`
+	// Approval is the hash of the approval comment that the mark applies to.
+	Approval string `json:"approval"`
+	// Commit is the head of the review when the mark was made.
+	Commit string `json:"commit"`
+	Status string `json:"status"`
+	// Version represents the version of the metadata format.
+	Version int `json:"v,omitempty"`
+}
+
+// New returns a new mark by the given author, with the given status, of the given approval for the given commit.
+//
+// The Timestamp field is automatically filled in with the current time.
+func New(author, approvalHash, commit, status string) Mark {
+	return Mark{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Author:    author,
+		Approval:  approvalHash,
+		Commit:    commit,
+		Status:    status,
+	}
+}
+
+// Parse parses an approval mark from a git note.
+func Parse(note repository.Note) (Mark, error) {
+	var mark Mark
+	err := json.Unmarshal([]byte(note), &mark)
+	return mark, err
+}
+
+// ParseAllValid takes collection of git notes and tries to parse an approval
+// mark from each one. Any notes that are not valid marks get ignored.
+func ParseAllValid(notes []repository.Note) []Mark {
+	var marks []Mark
+	for _, note := range notes {
+		note, err := schema.Upgrade(Ref, note, FormatVersion)
+		if err != nil {
+			continue
+		}
+		mark, err := Parse(note)
+		if err == nil && mark.Version == FormatVersion && mark.Approval != "" &&
+			(mark.Status == StatusStale || mark.Status == StatusCarriedForward) {
+			marks = append(marks, mark)
+		}
+	}
+	return marks
+}
+
+// Write writes an approval mark as a JSON-formatted git note.
+func (mark Mark) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(mark)
+	return repository.Note(bytes), err
+}
+
+// Latest maps each approval to its latest mark.
+//
+// Of the marks with the same timestamp, the one that comes last wins.
+func Latest(marks []Mark) map[string]Mark {
+	sorted := append([]Mark(nil), marks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return schema.CompareTimestamps(sorted[i].Timestamp, sorted[j].Timestamp) < 0
+	})
+	latest := make(map[string]Mark)
+	for _, mark := range sorted {
+		latest[mark.Approval] = mark
+	}
+	return latest
+}
+
+// IsStale reports whether an approval of the given commit, whose latest mark (if any) is
+// given, no longer counts for a review whose head is the given one.
+//
+// An approval that has never been marked is stale once the head of the review
+// moves away from the commit that it approved. Approvals that do not say which
+// commit they approved are only stale once they are marked as such.
+func IsStale(approvedCommit string, latest *Mark, head string) bool {
+	if latest != nil {
+		return latest.Status == StatusStale || latest.Commit != head
+	}
+	return approvedCommit != "" && approvedCommit != head
+}
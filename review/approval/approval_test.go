@@ -0,0 +1,59 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package approval
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"testing"
+)
+
+func TestLatestAndIsStale(t *testing.T) {
+	marks := ParseAllValid([]repository.Note{
+		repository.Note(`{"timestamp": "0000000002", "approval": "a", "commit": "c2", "status": "carried-forward"}`),
+		repository.Note(`{"timestamp": "0000000001", "approval": "a", "commit": "c2", "status": "stale"}`),
+		repository.Note(`{"timestamp": "0000000003", "approval": "b", "commit": "c2", "status": "stale"}`),
+		repository.Note(`{"timestamp": "0000000003", "approval": "b", "commit": "c2", "status": "carried-forward"}`),
+		repository.Note(`{"timestamp": "0000000004", "approval": "c", "commit": "c2", "status": "unknown"}`),
+		repository.Note(`{"timestamp": "0000000004", "commit": "c2", "status": "stale"}`),
+	})
+	if len(marks) != 4 {
+		t.Fatalf("Unexpected valid marks: %v", marks)
+	}
+	latest := Latest(marks)
+	if len(latest) != 2 || latest["a"].Status != StatusCarriedForward || latest["b"].Status != StatusCarriedForward {
+		t.Fatalf("Unexpected latest marks: %v", latest)
+	}
+	a := latest["a"]
+	stale := Mark{Status: StatusStale, Commit: "c2"}
+	for _, test := range []struct {
+		approved string
+		latest   *Mark
+		head     string
+		expected bool
+	}{
+		{"c1", nil, "c1", false},
+		{"c1", nil, "c2", true},
+		{"", nil, "c2", false},
+		{"c1", &a, "c2", false},
+		{"c1", &a, "c3", true},
+		{"c2", &stale, "c2", true},
+	} {
+		if actual := IsStale(test.approved, test.latest, test.head); actual != test.expected {
+			t.Errorf("Unexpected staleness of an approval of %q with the mark %v at %q: %v", test.approved, test.latest, test.head, actual)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package archive moves the notes of old, closed reviews out of the notes refs that are read by default.
+//
+// Every notes ref under "refs/notes/pullrequests/" has a corresponding archive
+// ref under "refs/notes/pullrequests/archive/". Since the archive refs share
+// the namespace of the other notes refs, they are pushed and pulled with them.
+package archive
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/analyses"
+	"github.com/promet/git-appraise/review/approval"
+	"github.com/promet/git-appraise/review/blind"
+	"github.com/promet/git-appraise/review/checklist"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/event"
+	"github.com/promet/git-appraise/review/reaction"
+	"github.com/promet/git-appraise/review/request"
+	"github.com/promet/git-appraise/review/robot"
+	"strings"
+)
+
+const notesRefPrefix = "refs/notes/pullrequests/"
+
+// RefPrefix is the prefix of the git-notes refs that hold archived notes.
+const RefPrefix = notesRefPrefix + "archive/"
+
+// NotesRefs lists the notes refs whose notes are moved when a review is archived.
+var NotesRefs = []string{
+	request.Ref,
+	comment.Ref,
+	comment.AttachmentsRef,
+	reaction.Ref,
+	approval.Ref,
+	event.Ref,
+	robot.Ref,
+	ci.Ref,
+	ci.RerunRef,
+	analyses.Ref,
+	blind.Ref,
+	checklist.Ref,
+}
+
+// Ref returns the archive ref that holds the archived notes of the given notes ref.
+//
+// Refs outside of the "refs/notes/pullrequests/" namespace, such as the local
+// record of viewed files, are not archived and are returned unchanged.
+func Ref(notesRef string) string {
+	if !strings.HasPrefix(notesRef, notesRefPrefix) || strings.HasPrefix(notesRef, RefPrefix) {
+		return notesRef
+	}
+	return RefPrefix + strings.TrimPrefix(notesRef, notesRefPrefix)
+}
+
+// archivedRepo is a view of a repo that reads and writes the archived notes.
+type archivedRepo struct {
+	repository.Repo
+}
+
+// NewRepo returns a view of the given repo in which the notes of the archived
+// reviews take the place of the notes of the other reviews.
+//
+// This allows archived reviews to be loaded using the functions of the review package.
+func NewRepo(repo repository.Repo) repository.Repo {
+	return &archivedRepo{repo}
+}
+
+// GetNotes reads the archived notes that annotate the given revision.
+func (repo *archivedRepo) GetNotes(notesRef, revision string) []repository.Note {
+	return repo.Repo.GetNotes(Ref(notesRef), revision)
+}
+
+// GetAllNotes reads the archived notes for every commit.
+func (repo *archivedRepo) GetAllNotes(notesRef string) (map[string][]repository.Note, error) {
+	return repo.Repo.GetAllNotes(Ref(notesRef))
+}
+
+// AppendNote appends a note to a revision under the archive ref.
+func (repo *archivedRepo) AppendNote(notesRef, revision string, note repository.Note) error {
+	return repo.Repo.AppendNote(Ref(notesRef), revision, note)
+}
+
+// AppendNotes appends each of the given notes to the revision that it is keyed by, under the archive ref.
+func (repo *archivedRepo) AppendNotes(notesRef string, notes map[string][]repository.Note) error {
+	return repo.Repo.AppendNotes(Ref(notesRef), notes)
+}
+
+// StoreBlob writes the given contents to the repo as a blob, keeping it reachable from the archive ref.
+func (repo *archivedRepo) StoreBlob(notesRef string, contents []byte) (string, error) {
+	return repo.Repo.StoreBlob(Ref(notesRef), contents)
+}
+
+// ListNotedRevisions returns the revisions that are annotated by archived notes.
+func (repo *archivedRepo) ListNotedRevisions(notesRef string) []string {
+	return repo.Repo.ListNotedRevisions(Ref(notesRef))
+}
+
+// Objects returns the objects whose notes belong to the given review.
+//
+// These are the review's revision, the commits in the review, the commits of
+// its patchsets, and the blobs of any attachments to its comments.
+func Objects(r *review.Review) []string {
+	seen := make(map[string]bool)
+	var objects []string
+	add := func(object string) {
+		if object != "" && !seen[object] {
+			seen[object] = true
+			objects = append(objects, object)
+		}
+	}
+	add(r.Revision)
+	add(r.Request.Alias)
+	for _, patchset := range r.Request.Patchsets {
+		add(patchset.Commit)
+	}
+	if commits, err := r.ListCommits(); err == nil {
+		for _, commit := range commits {
+			add(commit)
+		}
+	}
+	if head, err := r.GetHeadCommit(); err == nil {
+		add(head)
+	}
+	var visit func(threads []review.CommentThread)
+	visit = func(threads []review.CommentThread) {
+		for _, thread := range threads {
+			for _, attachment := range thread.Comment.Attachments {
+				add(attachment.Hash)
+			}
+			visit(thread.Children)
+		}
+	}
+	visit(r.Comments)
+	return objects
+}
+
+// Archive moves the notes annotating the given objects into the archive refs.
+func Archive(repo repository.Repo, objects []string) error {
+	for _, notesRef := range NotesRefs {
+		if err := repo.MoveNotes(notesRef, Ref(notesRef), objects); err != nil {
+			return err
+		}
+	}
+	return nil
+}
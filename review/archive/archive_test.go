@@ -0,0 +1,70 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/viewed"
+	"testing"
+)
+
+func TestRef(t *testing.T) {
+	if ref := Ref(ci.Ref); ref != "refs/notes/pullrequests/archive/ci" {
+		t.Errorf("Unexpected archive ref for %q: %q", ci.Ref, ref)
+	}
+	if ref := Ref(Ref(ci.Ref)); ref != Ref(ci.Ref) {
+		t.Errorf("Archive refs should not be archived again, but got %q", ref)
+	}
+	if ref := Ref(viewed.Ref); ref != viewed.Ref {
+		t.Errorf("Refs outside of the pullrequests namespace should not be archived, but got %q", ref)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	r, err := review.Get(repo, repository.TestCommitB)
+	if err != nil || r == nil {
+		t.Fatalf("Failed to load the review: %v", err)
+	}
+	if err := Archive(repo, Objects(r)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, remaining := range review.ListAll(repo) {
+		if remaining.Revision == repository.TestCommitB {
+			t.Errorf("The archived review is still listed: %v", remaining)
+		}
+	}
+	if remaining := len(review.ListAll(repo)); remaining != 2 {
+		t.Errorf("Unexpected number of remaining reviews: %d", remaining)
+	}
+
+	archivedRepo := NewRepo(repo)
+	archived := review.ListAll(archivedRepo)
+	if len(archived) != 1 || archived[0].Revision != repository.TestCommitB {
+		t.Fatalf("Unexpected archived reviews: %v", archived)
+	}
+	archivedReview, err := review.Get(archivedRepo, repository.TestCommitB)
+	if err != nil || archivedReview == nil {
+		t.Fatalf("Failed to load the archived review: %v", err)
+	}
+	if archivedReview.Request.Description != r.Request.Description || len(archivedReview.Comments) != len(r.Comments) {
+		t.Errorf("The archived review does not match the original: %v vs %v", archivedReview, r)
+	}
+}
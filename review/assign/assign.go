@@ -0,0 +1,134 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package assign picks reviewers for a review from a pool of candidates.
+//
+// The pool and the strategy used to pick from it are configured per
+// repository. Strategies only depend upon the existing reviews, so every
+// clone of a repository makes the same choices.
+package assign
+
+import (
+	"fmt"
+	"github.com/promet/git-appraise/review"
+	"sort"
+	"strings"
+)
+
+// DefaultStrategy is the name of the strategy used when none is configured.
+const DefaultStrategy = "round-robin"
+
+// Strategy picks reviewers from a pool of candidates.
+type Strategy interface {
+	// Assign returns up to count members of the pool, skipping the excluded ones.
+	//
+	// The reviews argument holds the existing reviews, ordered with the most recent first.
+	Assign(pool []string, reviews []review.Summary, exclude []string, count int) []string
+}
+
+// Strategies holds every known strategy, keyed by name.
+var Strategies = map[string]Strategy{
+	"round-robin": RoundRobin{},
+	"load":        LoadBased{},
+}
+
+// GetStrategy returns the strategy with the given name, or the default one if the name is empty.
+func GetStrategy(name string) (Strategy, error) {
+	if name == "" {
+		name = DefaultStrategy
+	}
+	strategy, ok := Strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown assignment strategy %q.", name)
+	}
+	return strategy, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if strings.EqualFold(v, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// RoundRobin assigns reviewers in turn, starting after the pool member
+// that was most recently assigned to a review.
+type RoundRobin struct{}
+
+// Assign implements the Strategy interface.
+func (RoundRobin) Assign(pool []string, reviews []review.Summary, exclude []string, count int) []string {
+	last := -1
+	for _, r := range reviews {
+		for _, reviewer := range r.Request.Reviewers {
+			if i := indexOf(pool, reviewer); i >= 0 {
+				last = i
+			}
+		}
+		if last >= 0 {
+			break
+		}
+	}
+	var assigned []string
+	for offset := 1; offset <= len(pool) && len(assigned) < count; offset++ {
+		candidate := pool[(last+offset)%len(pool)]
+		if !contains(exclude, candidate) && !contains(assigned, candidate) {
+			assigned = append(assigned, candidate)
+		}
+	}
+	return assigned
+}
+
+// LoadBased assigns the pool members with the fewest open reviews assigned to them.
+//
+// Ties are broken by the order of the pool.
+type LoadBased struct{}
+
+// Assign implements the Strategy interface.
+func (LoadBased) Assign(pool []string, reviews []review.Summary, exclude []string, count int) []string {
+	load := make(map[string]int)
+	for _, r := range reviews {
+		if !r.IsOpen() {
+			continue
+		}
+		for _, reviewer := range r.Request.Reviewers {
+			load[strings.ToLower(reviewer)]++
+		}
+	}
+	var candidates []string
+	for _, candidate := range pool {
+		if !contains(exclude, candidate) && !contains(candidates, candidate) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return load[strings.ToLower(candidates[i])] < load[strings.ToLower(candidates[j])]
+	})
+	if len(candidates) > count {
+		candidates = candidates[:count]
+	}
+	return candidates
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package assign
+
+import (
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/request"
+	"reflect"
+	"testing"
+)
+
+var pool = []string{"alice", "bob", "carol"}
+
+func reviewFor(submitted bool, reviewers ...string) review.Summary {
+	return review.Summary{
+		Request:   request.Request{TargetRef: "refs/heads/master", Reviewers: reviewers},
+		Submitted: submitted,
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	if assigned := (RoundRobin{}).Assign(pool, nil, nil, 1); !reflect.DeepEqual(assigned, []string{"alice"}) {
+		t.Fatalf("Unexpected first assignment: %v", assigned)
+	}
+	reviews := []review.Summary{reviewFor(false, "bob"), reviewFor(false, "carol")}
+	if assigned := (RoundRobin{}).Assign(pool, reviews, nil, 1); !reflect.DeepEqual(assigned, []string{"carol"}) {
+		t.Fatalf("Unexpected assignment after bob: %v", assigned)
+	}
+	if assigned := (RoundRobin{}).Assign(pool, reviews, []string{"carol"}, 2); !reflect.DeepEqual(assigned, []string{"alice", "bob"}) {
+		t.Fatalf("Unexpected assignment excluding carol: %v", assigned)
+	}
+	if assigned := (RoundRobin{}).Assign(pool, nil, pool, 1); len(assigned) != 0 {
+		t.Fatalf("Unexpectedly assigned an excluded reviewer: %v", assigned)
+	}
+}
+
+func TestLoadBased(t *testing.T) {
+	reviews := []review.Summary{
+		reviewFor(false, "alice"),
+		reviewFor(false, "alice", "bob"),
+		reviewFor(true, "carol"),
+		reviewFor(true, "carol"),
+	}
+	if assigned := (LoadBased{}).Assign(pool, reviews, nil, 2); !reflect.DeepEqual(assigned, []string{"carol", "bob"}) {
+		t.Fatalf("Unexpected assignment: %v", assigned)
+	}
+	if assigned := (LoadBased{}).Assign(pool, reviews, []string{"carol"}, 1); !reflect.DeepEqual(assigned, []string{"bob"}) {
+		t.Fatalf("Unexpected assignment excluding carol: %v", assigned)
+	}
+}
+
+func TestGetStrategy(t *testing.T) {
+	if strategy, err := GetStrategy(""); err != nil || strategy != Strategies[DefaultStrategy] {
+		t.Fatalf("Unexpected default strategy: %v, %v", strategy, err)
+	}
+	if _, err := GetStrategy("lottery"); err == nil {
+		t.Fatal("Failed to reject an unknown strategy")
+	}
+}
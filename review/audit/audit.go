@@ -0,0 +1,235 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit reconstructs the timeline of the events in the life of a code review.
+//
+// Every event is derived from the review notes, which are only ever appended
+// to, so the timeline of a review can be rebuilt at any later time and always
+// comes out the same, other than for comments that were since redacted. The
+// events recorded by comments carry the hashes that name those comments, so
+// they can be checked against the notes. As submitting a review does not write
+// any notes, its submission is dated by the commit that landed it in its target
+// ref; for a fast-forward, that is the commit time of the review's head.
+package audit
+
+import (
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/event"
+	"github.com/promet/git-appraise/review/request"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
+)
+
+// The types of events.
+const (
+	// TypeRequested means that the review was first requested.
+	TypeRequested = "requested"
+	// TypePublished means that a draft review was published.
+	TypePublished = "published"
+	// TypeRevised means that a new patchset of the review was published.
+	TypeRevised = "revised"
+	// TypeAbandoned means that the review was abandoned.
+	TypeAbandoned = "abandoned"
+	// TypeReopened means that an abandoned review was requested again.
+	TypeReopened = "reopened"
+	// TypeCommented means that a comment was added to the review.
+	TypeCommented = "commented"
+	// TypeApproved means that a comment accepted the review.
+	TypeApproved = "approved"
+	// TypeRejected means that a comment rejected the review.
+	TypeRejected = "rejected"
+	// TypeEdited means that a comment was edited.
+	TypeEdited = "edited"
+	// TypeDeleted means that a comment was deleted.
+	TypeDeleted = "deleted"
+	// TypeRedacted means that a comment was purged from the history of the review.
+	TypeRedacted = "redacted"
+	// TypeTransferred means that the review was handed over to a new requester.
+	TypeTransferred = "transferred"
+	// TypeRerequested means that the review was requested again from some of its reviewers.
+	TypeRerequested = "rerequested"
+	// TypeNudged means that the reviewers of a review were reminded of it, as it was overdue.
+	TypeNudged = "nudged"
+	// TypeSubmitted means that the review was merged into its target ref.
+	TypeSubmitted = "submitted"
+)
+
+// Entry is a single event in the timeline of a review.
+type Entry struct {
+	Timestamp string `json:"timestamp"`
+	Type      string `json:"type"`
+	// Actor is the user who caused the event, if known.
+	Actor string `json:"actor,omitempty"`
+	// Users are the other users that the event concerns, such as the new requester of a transferred review.
+	Users []string `json:"users,omitempty"`
+	// Commit is the commit that the event concerns, such as the head of a new patchset.
+	Commit string `json:"commit,omitempty"`
+	// Hash is the hash of the comment that recorded the event, for the events recorded by comments.
+	Hash string `json:"hash,omitempty"`
+	Path string `json:"path,omitempty"`
+	// Description is the message of the event, such as the text of a comment.
+	Description string `json:"description,omitempty"`
+}
+
+// Timeline returns the events in the life of the given review, oldest first.
+func Timeline(r *review.Review) ([]Entry, error) {
+	entries := requestEntries(r)
+	entries = commentEntries(r.Comments, entries)
+	for _, e := range r.Events {
+		entry := Entry{
+			Timestamp:   e.Timestamp,
+			Type:        TypeRerequested,
+			Actor:       e.Author,
+			Users:       e.Reviewers,
+			Commit:      e.Commit,
+			Description: e.Message,
+		}
+		switch e.Type {
+		case event.TypeTransfer:
+			entry.Type = TypeTransferred
+			entry.Users = []string{e.To}
+		case event.TypeNudge:
+			entry.Type = TypeNudged
+		}
+		entries = append(entries, entry)
+	}
+	if r.Submitted {
+		entry, err := submittedEntry(r)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return schema.CompareTimestamps(entries[i].Timestamp, entries[j].Timestamp) < 0
+	})
+	return entries, nil
+}
+
+// requestEntries returns the events recorded by the successive versions of the review's request.
+func requestEntries(r *review.Review) []Entry {
+	var entries []Entry
+	var previous *request.Request
+	for i := range r.AllRequests {
+		req := &r.AllRequests[i]
+		entry := Entry{Timestamp: req.Timestamp, Actor: req.Requester, Users: req.Reviewers}
+		switch {
+		case previous == nil:
+			entry.Type = TypeRequested
+			entry.Commit = r.Revision
+			entry.Description = req.Description
+		case previous.TargetRef != "" && req.TargetRef == "":
+			entry.Type = TypeAbandoned
+		case previous.TargetRef == "" && req.TargetRef != "":
+			entry.Type = TypeReopened
+		case previous.Draft && !req.Draft:
+			entry.Type = TypePublished
+		}
+		if entry.Type != "" {
+			entries = append(entries, entry)
+		}
+		previous = req
+	}
+	// Every patchset after the first one is a new revision of the review, which
+	// was published by whoever was the requester of the review at the time.
+	for i, patchset := range r.Request.Patchsets {
+		if i == 0 {
+			continue
+		}
+		entry := Entry{Timestamp: patchset.Timestamp, Type: TypeRevised, Commit: patchset.Commit}
+		for _, req := range r.AllRequests {
+			if schema.CompareTimestamps(req.Timestamp, patchset.Timestamp) <= 0 {
+				entry.Actor = req.Requester
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// commentEntries appends the events recorded by the comments in the given threads, and by their revisions.
+func commentEntries(threads []review.CommentThread, entries []Entry) []Entry {
+	for _, thread := range threads {
+		c := thread.Comment
+		entry := Entry{
+			Timestamp:   c.Timestamp,
+			Type:        TypeCommented,
+			Actor:       c.Author,
+			Hash:        thread.Hash,
+			Description: c.Description,
+		}
+		if c.Resolved != nil {
+			entry.Type = TypeRejected
+			if *c.Resolved {
+				entry.Type = TypeApproved
+			}
+		}
+		if c.Location != nil {
+			entry.Commit = c.Location.Commit
+			entry.Path = c.Location.Path
+		}
+		entries = append(entries, entry)
+		for _, revision := range thread.Revisions {
+			revisionType := TypeEdited
+			if revision.Deleted {
+				revisionType = TypeDeleted
+			}
+			entries = append(entries, Entry{
+				Timestamp:   revision.Timestamp,
+				Type:        revisionType,
+				Actor:       revision.Author,
+				Hash:        thread.Hash,
+				Description: revision.Description,
+			})
+		}
+		if redacted := thread.Redacted; redacted != nil {
+			entry := Entry{Timestamp: redacted.Timestamp, Type: TypeRedacted, Actor: redacted.Author, Hash: thread.Hash}
+			if redacted.Redaction != nil {
+				entry.Description = redacted.Redaction.Reason
+			}
+			entries = append(entries, entry)
+		}
+		entries = commentEntries(thread.Children, entries)
+	}
+	return entries
+}
+
+// submittedEntry returns the event of the submission of the given review, as dated by the commit that landed it.
+//
+// The actor is only known for a merge, as the author of the merge commit.
+func submittedEntry(r *review.Review) (Entry, error) {
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return Entry{}, err
+	}
+	landing, err := r.Repo.GetLandingCommit(head, r.Request.TargetRef)
+	if err != nil {
+		return Entry{}, err
+	}
+	timestamp, err := r.Repo.GetCommitTime(landing)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry := Entry{Timestamp: timestamp, Type: TypeSubmitted, Commit: landing}
+	if landing != head {
+		details, err := r.Repo.GetCommitDetails(landing)
+		if err != nil {
+			return Entry{}, err
+		}
+		entry.Actor = details.AuthorEmail
+	}
+	return entry, nil
+}
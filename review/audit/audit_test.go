@@ -0,0 +1,77 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"testing"
+)
+
+func TestTimeline(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	reply := comment.New("bob@example.com", "Why?")
+	reply.Timestamp = "0000000002"
+	note, err := reply.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(comment.Ref, repository.TestCommitB, note); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := reply.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := comment.NewRevision("bob@example.com", hash, "Why not?")
+	edit.Timestamp = "0000000003"
+	if note, err = edit.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(comment.Ref, repository.TestCommitB, note); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(repo, repository.TestCommitB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := Timeline(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Entry{
+		{Timestamp: "0000000001", Type: TypeRequested, Actor: "ojarjur", Users: []string{"ojarjur"}, Commit: repository.TestCommitB, Description: "B"},
+		{Timestamp: "0000000001", Type: TypeApproved, Actor: "ojarjur", Commit: repository.TestCommitB},
+		{Timestamp: "1", Type: TypeSubmitted, Commit: repository.TestCommitB},
+		{Timestamp: "0000000002", Type: TypeCommented, Actor: "bob@example.com", Hash: hash, Description: "Why?"},
+		{Timestamp: "0000000003", Type: TypeEdited, Actor: "bob@example.com", Hash: hash, Description: "Why not?"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Unexpected timeline: %+v", entries)
+	}
+	for i, entry := range entries {
+		// The hashes of the comments in the mock repo are not worth spelling out.
+		if entry.Type == TypeApproved {
+			entry.Hash = ""
+		}
+		if entry.Timestamp != expected[i].Timestamp || entry.Type != expected[i].Type || entry.Actor != expected[i].Actor ||
+			entry.Commit != expected[i].Commit || entry.Hash != expected[i].Hash || entry.Description != expected[i].Description {
+			t.Errorf("Unexpected entry %d: %+v", i, entry)
+		}
+	}
+}
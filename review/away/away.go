@@ -0,0 +1,208 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package away defines the statuses that people set while they are away, such as on vacation.
+//
+// While someone is away, review requests are rerouted to their delegate, if
+// they named one, and their delegate may approve the changes that they own.
+// Statuses do not belong to any review, so they all annotate the empty tree,
+// which every repo contains.
+package away
+
+import (
+	"encoding/json"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ref defines the git-notes ref that we expect to contain away statuses.
+const Ref = "refs/notes/pullrequests/away"
+
+// FormatVersion defines the latest version of the away status format supported by the tool.
+const FormatVersion = 0
+
+// Subject is the object that every away status annotates: the tree with no entries.
+const Subject = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// Status records that someone is away, or that they are back.
+type Status struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	User      string `json:"user"`
+	// Until is the time at which the user is back; if it is empty, they are away until they say otherwise.
+	Until string `json:"until,omitempty"`
+	// Delegate is the person that the user's reviews are rerouted to while they are away.
+	Delegate string `json:"delegate,omitempty"`
+	Message  string `json:"message,omitempty"`
+	// Back indicates that the user has returned, which ends their earlier status.
+	Back bool `json:"back,omitempty"`
+	// Version represents the version of the metadata format.
+	Version int `json:"v,omitempty"`
+}
+
+// New returns a new status of the given user being away until the given time, if it is not zero.
+//
+// The Timestamp field is automatically filled in with the current time.
+func New(user string, until time.Time, delegate, message string) Status {
+	status := Status{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		User:      user,
+		Delegate:  delegate,
+		Message:   message,
+	}
+	if !until.IsZero() {
+		status.Until = strconv.FormatInt(until.Unix(), 10)
+	}
+	return status
+}
+
+// NewBack returns a new status of the given user being back.
+//
+// The Timestamp field is automatically filled in with the current time.
+func NewBack(user string) Status {
+	return Status{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		User:      user,
+		Back:      true,
+	}
+}
+
+// Parse parses an away status from a git note.
+func Parse(note repository.Note) (Status, error) {
+	var status Status
+	err := json.Unmarshal([]byte(note), &status)
+	return status, err
+}
+
+// ParseAllValid takes collection of git notes and tries to parse an away
+// status from each one. Any notes that are not valid statuses get ignored.
+func ParseAllValid(notes []repository.Note) []Status {
+	var statuses []Status
+	for _, note := range notes {
+		note, err := schema.Upgrade(Ref, note, FormatVersion)
+		if err != nil {
+			continue
+		}
+		status, err := Parse(note)
+		if err == nil && status.Version == FormatVersion && status.User != "" {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// Write writes an away status as a JSON-formatted git note.
+func (status Status) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(status)
+	return repository.Note(bytes), err
+}
+
+// IsAway reports whether the status has the user away at the given time.
+func (status Status) IsAway(now time.Time) bool {
+	if status.Back {
+		return false
+	}
+	if status.Until == "" {
+		return true
+	}
+	until, err := schema.ParseTimestamp(status.Until)
+	return err == nil && now.Before(until)
+}
+
+// Statuses holds the latest status of each user, keyed by their lower-cased identity.
+type Statuses map[string]Status
+
+// Latest returns the latest of the given statuses of each user.
+//
+// Statuses with the same timestamp are kept in the order that they were given.
+func Latest(statuses []Status) Statuses {
+	sorted := append([]Status(nil), statuses...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return schema.CompareTimestamps(sorted[i].Timestamp, sorted[j].Timestamp) < 0
+	})
+	latest := make(Statuses)
+	for _, status := range sorted {
+		latest[strings.ToLower(status.User)] = status
+	}
+	return latest
+}
+
+// Load returns the latest status of each user in the repo.
+func Load(repo repository.Repo) Statuses {
+	return Latest(ParseAllValid(repo.GetNotes(Ref, Subject)))
+}
+
+// Away returns the status of the given user, if they are away at the given time.
+func (statuses Statuses) Away(user string, now time.Time) *Status {
+	status, ok := statuses[strings.ToLower(user)]
+	if !ok || !status.IsAway(now) {
+		return nil
+	}
+	return &status
+}
+
+// Delegate returns who stands in for the given user at the given time, or an
+// empty string if they are not away, or nobody who is around stands in for them.
+//
+// A delegate who is away themselves passes the reviews on to their own
+// delegate, and so on, unless that leads back to someone earlier in the chain.
+func (statuses Statuses) Delegate(user string, now time.Time) string {
+	seen := map[string]bool{strings.ToLower(user): true}
+	delegate := ""
+	for status := statuses.Away(user, now); status != nil; status = statuses.Away(delegate, now) {
+		if status.Delegate == "" || seen[strings.ToLower(status.Delegate)] {
+			return ""
+		}
+		delegate = status.Delegate
+		seen[strings.ToLower(delegate)] = true
+	}
+	return delegate
+}
+
+// Reroute returns the given users, with each one that is away at the given
+// time replaced by their delegate, if they have one. Users that would be
+// listed twice are only listed once.
+func (statuses Statuses) Reroute(users []string, now time.Time) []string {
+	var result []string
+	for _, user := range users {
+		if delegate := statuses.Delegate(user, now); delegate != "" {
+			user = delegate
+		}
+		duplicate := false
+		for _, existing := range result {
+			duplicate = duplicate || strings.EqualFold(existing, user)
+		}
+		if !duplicate {
+			result = append(result, user)
+		}
+	}
+	return result
+}
+
+// Delegators returns the users that the given one stands in for at the given time.
+func (statuses Statuses) Delegators(user string, now time.Time) []string {
+	var delegators []string
+	for _, status := range statuses {
+		if strings.EqualFold(statuses.Delegate(status.User, now), user) {
+			delegators = append(delegators, status.User)
+		}
+	}
+	sort.Strings(delegators)
+	return delegators
+}
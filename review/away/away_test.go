@@ -0,0 +1,60 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package away
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDelegate(t *testing.T) {
+	now := time.Unix(2000000000, 0)
+	statuses := Latest(ParseAllValid([]repository.Note{
+		repository.Note(`{"timestamp":"1000000000","user":"alice","delegate":"bob"}`),
+		repository.Note(`{"timestamp":"1000000001","user":"bob","until":"2000000001","delegate":"carol"}`),
+		repository.Note(`{"timestamp":"1000000000","user":"dave","until":"1999999999","delegate":"erin"}`),
+		repository.Note(`{"timestamp":"1000000000","user":"erin","delegate":"frank"}`),
+		repository.Note(`{"timestamp":"1000000001","user":"frank","delegate":"erin"}`),
+		repository.Note(`{"timestamp":"1000000000","user":"gina","delegate":"alice"}`),
+		repository.Note(`{"timestamp":"1000000001","user":"gina","back":true}`),
+		repository.Note(`{"timestamp":"1000000000","delegate":"nobody"}`),
+	}))
+	tests := map[string]string{
+		"alice": "carol",
+		"Bob":   "carol",
+		"carol": "",
+		"dave":  "",
+		"erin":  "",
+		"gina":  "",
+	}
+	for user, expected := range tests {
+		if delegate := statuses.Delegate(user, now); delegate != expected {
+			t.Errorf("Unexpected delegate for %s: %q", user, delegate)
+		}
+	}
+	if statuses.Away("dave", now) != nil || statuses.Away("erin", now) == nil {
+		t.Fatal("Unexpected away statuses")
+	}
+	if rerouted := statuses.Reroute([]string{"alice", "carol", "dave", "erin"}, now); !reflect.DeepEqual(rerouted, []string{"carol", "dave", "erin"}) {
+		t.Fatalf("Unexpected rerouted users: %v", rerouted)
+	}
+	if delegators := statuses.Delegators("carol", now); !reflect.DeepEqual(delegators, []string{"alice", "bob"}) {
+		t.Fatalf("Unexpected delegators: %v", delegators)
+	}
+}
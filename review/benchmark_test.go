@@ -0,0 +1,152 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// benchmarkReviews is the number of reviews in the repo used by the benchmarks.
+const benchmarkReviews = 200
+
+// newBenchmarkRepo returns a git repo in a temporary directory, holding the given
+// number of reviews that have each been commented upon; half of them are submitted.
+func newBenchmarkRepo(b *testing.B, reviews int) *repository.GitRepo {
+	dir, err := ioutil.TempDir("", "benchmark-repo")
+	if err != nil {
+		b.Fatal(err)
+	}
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			b.Fatalf("Failed to run git %v: %v", args, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("config", "user.email", "user@example.com")
+	git("config", "user.name", "User")
+	git("commit", "-q", "--allow-empty", "-m", "Initial")
+	git("branch", "-M", "master")
+	requests := make(map[string][]repository.Note)
+	comments := make(map[string][]repository.Note)
+	for i := 0; i < reviews; i++ {
+		branch := fmt.Sprintf("change-%d", i)
+		git("checkout", "-q", "-b", branch, "master")
+		git("commit", "-q", "--allow-empty", "-m", branch)
+		revision := git("rev-parse", "HEAD")
+		r := request.New("user@example.com", []string{"reviewer@example.com"}, "refs/heads/"+branch, "refs/heads/master", branch)
+		requestNote, err := r.Write()
+		if err != nil {
+			b.Fatal(err)
+		}
+		commentNote, err := comment.New("reviewer@example.com", "Looks good").Write()
+		if err != nil {
+			b.Fatal(err)
+		}
+		requests[revision] = []repository.Note{requestNote}
+		comments[revision] = []repository.Note{commentNote}
+		if i%2 == 0 {
+			git("checkout", "-q", "master")
+			git("merge", "-q", "--ff-only", branch)
+		}
+	}
+	git("checkout", "-q", "master")
+	repo, err := repository.NewGitRepo(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := repo.AppendNotes(request.Ref, requests); err != nil {
+		b.Fatal(err)
+	}
+	if err := repo.AppendNotes(comment.Ref, comments); err != nil {
+		b.Fatal(err)
+	}
+	return repo
+}
+
+// reportGitCommands reports the number of git commands that each iteration ran, given the count before the first one.
+func reportGitCommands(b *testing.B, before int64) {
+	b.ReportMetric(float64(repository.GitCommandCount()-before)/float64(b.N), "git-commands/op")
+}
+
+func BenchmarkListAll(b *testing.B) {
+	repo := newBenchmarkRepo(b, benchmarkReviews)
+	defer os.RemoveAll(repo.Path)
+	before := repository.GitCommandCount()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if reviews, err := ListAllContext(repo.Context(), repo); err != nil || len(reviews) != benchmarkReviews {
+			b.Fatalf("Unexpected reviews: %d, %v", len(reviews), err)
+		}
+	}
+	reportGitCommands(b, before)
+}
+
+func BenchmarkGetSummary(b *testing.B) {
+	repo := newBenchmarkRepo(b, benchmarkReviews)
+	defer os.RemoveAll(repo.Path)
+	revisions := repo.ListNotedRevisions(request.Ref)
+	before := repository.GitCommandCount()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := GetSummary(repo, revisions[i%len(revisions)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportGitCommands(b, before)
+}
+
+func BenchmarkDetails(b *testing.B) {
+	repo := newBenchmarkRepo(b, benchmarkReviews)
+	defer os.RemoveAll(repo.Path)
+	summaries := ListAll(repo)
+	before := repository.GitCommandCount()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range summaries {
+			if _, err := summaries[j].Details(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	reportGitCommands(b, before)
+}
+
+func BenchmarkDetailsAll(b *testing.B) {
+	repo := newBenchmarkRepo(b, benchmarkReviews)
+	defer os.RemoveAll(repo.Path)
+	summaries := ListAll(repo)
+	before := repository.GitCommandCount()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DetailsAll(repo, summaries); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportGitCommands(b, before)
+}
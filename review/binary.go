@@ -0,0 +1,105 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"bytes"
+	"github.com/promet/git-appraise/repository"
+	"image"
+	// Register the image formats whose dimensions are reported.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// BinaryDiff describes the change that a review makes to a binary file.
+//
+// The sizes are in bytes, and the dimensions are only set for images whose format is known.
+type BinaryDiff struct {
+	repository.BinaryChange
+	ContentType string `json:"contentType,omitempty"`
+	OldSize     int    `json:"oldSize,omitempty"`
+	NewSize     int    `json:"newSize,omitempty"`
+	OldWidth    int    `json:"oldWidth,omitempty"`
+	OldHeight   int    `json:"oldHeight,omitempty"`
+	NewWidth    int    `json:"newWidth,omitempty"`
+	NewHeight   int    `json:"newHeight,omitempty"`
+}
+
+// IsImage reports whether the binary file is an image.
+func (diff BinaryDiff) IsImage() bool {
+	return strings.HasPrefix(diff.ContentType, "image/")
+}
+
+// describeBlob returns the contents of the given blob, along with its dimensions if it is an image.
+func describeBlob(repo repository.Repo, hash string) ([]byte, int, int, error) {
+	contents, err := repo.ReadBlob(hash)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(contents))
+	if err != nil {
+		return contents, 0, 0, nil
+	}
+	return contents, config.Width, config.Height, nil
+}
+
+// GetBinaryDiffs returns the changes that the review makes to binary files,
+// whose contents are left out of its diff.
+//
+// The content type of each file is derived from its name, or else from its
+// contents (preferring the new version).
+func (r *Review) GetBinaryDiffs() ([]BinaryDiff, error) {
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := r.Repo.ListBinaryChanges(baseCommit, headCommit)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []BinaryDiff
+	for _, change := range changes {
+		diff := BinaryDiff{BinaryChange: change, ContentType: mime.TypeByExtension(path.Ext(change.Path))}
+		var contents []byte
+		if change.OldBlob != "" {
+			if contents, diff.OldWidth, diff.OldHeight, err = describeBlob(r.Repo, change.OldBlob); err != nil {
+				return nil, err
+			}
+			diff.OldSize = len(contents)
+		}
+		if change.NewBlob != "" {
+			if contents, diff.NewWidth, diff.NewHeight, err = describeBlob(r.Repo, change.NewBlob); err != nil {
+				return nil, err
+			}
+			diff.NewSize = len(contents)
+		}
+		if diff.ContentType == "" {
+			diff.ContentType = http.DetectContentType(contents)
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
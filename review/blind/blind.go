@@ -0,0 +1,138 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package blind hides who commented on a blind review behind stable pseudonyms.
+//
+// The pseudonym of a commenter is derived from a secret of theirs and the
+// revision of the review, so that it is the same for all of their comments on
+// the review, but it cannot be linked to them, or to their pseudonyms on other
+// reviews, without the secret. The identity behind each pseudonym is recorded
+// in a note encrypted to the administrators named by the review request, who
+// alone can reveal it.
+package blind
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/schema"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ref defines the git-notes ref that we expect to contain the pseudonyms of the commenters on blind reviews.
+const Ref = "refs/notes/pullrequests/pseudonyms"
+
+// FormatVersion defines the latest version of the pseudonym format supported by the tool.
+const FormatVersion = 0
+
+// pseudonymPrefix starts every pseudonym, so that they are easy to tell apart from real identities.
+const pseudonymPrefix = "reviewer-"
+
+// Domain is that of the email addresses of pseudonyms, which are used for the commits of the notes refs.
+const Domain = "blind.invalid"
+
+// Pseudonym records the identity behind a pseudonym used on a blind review,
+// encrypted so that only the administrators of the review can reveal it.
+type Pseudonym struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Pseudonym string `json:"pseudonym"`
+	// Identity is the email address of the person behind the pseudonym, encrypted to the administrators.
+	Identity *comment.Encrypted `json:"identity"`
+	// Version represents the version of the metadata format.
+	Version int `json:"v,omitempty"`
+}
+
+// NewPseudonym returns the pseudonym that the given secret gives its holder on the review of the given revision.
+func NewPseudonym(secret []byte, revision string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(revision))
+	return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil))[:8]
+}
+
+// IsPseudonym reports whether the given author of a comment is a pseudonym.
+func IsPseudonym(author string) bool {
+	return strings.HasPrefix(author, pseudonymPrefix)
+}
+
+// New returns the record of the given identity behind the given pseudonym, encrypted to the given administrators.
+//
+// The Timestamp field is automatically filled in with the current time.
+func New(pseudonym, identity string, administrators []string) (Pseudonym, error) {
+	if len(administrators) == 0 {
+		return Pseudonym{}, errors.New("A blind review needs at least one administrator to encrypt the identities of its reviewers to.")
+	}
+	encrypted, err := comment.Seal(administrators, []byte(identity))
+	if err != nil {
+		return Pseudonym{}, err
+	}
+	return Pseudonym{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Pseudonym: pseudonym,
+		Identity:  encrypted,
+	}, nil
+}
+
+// Reveal decrypts the identity behind the pseudonym, using the identity in the given file if it was encrypted with age.
+func (p Pseudonym) Reveal(ageIdentityFile string) (string, error) {
+	identity, err := p.Identity.Open(ageIdentityFile)
+	return string(identity), err
+}
+
+// Parse parses a pseudonym from a git note.
+func Parse(note repository.Note) (Pseudonym, error) {
+	var p Pseudonym
+	err := json.Unmarshal([]byte(note), &p)
+	return p, err
+}
+
+// ParseAllValid takes collection of git notes and tries to parse a pseudonym
+// from each one. Any notes that are not valid pseudonyms get ignored.
+func ParseAllValid(notes []repository.Note) []Pseudonym {
+	var pseudonyms []Pseudonym
+	for _, note := range notes {
+		note, err := schema.Upgrade(Ref, note, FormatVersion)
+		if err != nil {
+			continue
+		}
+		p, err := Parse(note)
+		if err == nil && p.Version == FormatVersion && IsPseudonym(p.Pseudonym) && p.Identity != nil {
+			pseudonyms = append(pseudonyms, p)
+		}
+	}
+	return pseudonyms
+}
+
+// Write writes a pseudonym as a JSON-formatted git note.
+func (p Pseudonym) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(p)
+	return repository.Note(bytes), err
+}
+
+// Contains reports whether the given pseudonym is among the given ones.
+func Contains(pseudonyms []Pseudonym, pseudonym string) bool {
+	for _, p := range pseudonyms {
+		if p.Pseudonym == pseudonym {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blind
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/comment"
+	"testing"
+)
+
+func TestNewPseudonym(t *testing.T) {
+	p := NewPseudonym([]byte("secret"), "abcd")
+	if !IsPseudonym(p) || len(p) != len(pseudonymPrefix)+8 {
+		t.Fatalf("Unexpected pseudonym %q", p)
+	}
+	if again := NewPseudonym([]byte("secret"), "abcd"); again != p {
+		t.Fatalf("The pseudonym changed from %q to %q", p, again)
+	}
+	if other := NewPseudonym([]byte("secret"), "ef01"); other == p {
+		t.Fatalf("The pseudonym %q was reused for another review", p)
+	}
+	if other := NewPseudonym([]byte("other"), "abcd"); other == p {
+		t.Fatalf("The pseudonym %q was given to another user", p)
+	}
+	if IsPseudonym("user@example.com") {
+		t.Fatal("Mistook an email address for a pseudonym")
+	}
+}
+
+func TestNewNeedsAdministrators(t *testing.T) {
+	if _, err := New("reviewer-01234567", "user@example.com", nil); err == nil {
+		t.Fatal("Recorded a pseudonym without any administrators to reveal it")
+	}
+}
+
+func TestParseAllValid(t *testing.T) {
+	encrypted := &comment.Encrypted{Scheme: comment.SchemeGPG, Recipients: []string{"chair@example.com"}, Data: "data"}
+	valid, err := Pseudonym{Pseudonym: "reviewer-01234567", Identity: encrypted}.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := []repository.Note{
+		valid,
+		repository.Note(`{"pseudonym":"user@example.com","identity":{"scheme":"gpg","recipients":["chair@example.com"],"data":"data"}}`),
+		repository.Note(`{"pseudonym":"reviewer-89abcdef"}`),
+		repository.Note(`{"pseudonym":"reviewer-89abcdef","identity":{"scheme":"gpg","data":"data"},"v":1}`),
+		repository.Note("not json"),
+	}
+	pseudonyms := ParseAllValid(notes)
+	if len(pseudonyms) != 1 || pseudonyms[0].Pseudonym != "reviewer-01234567" {
+		t.Fatalf("Unexpected pseudonyms: %v", pseudonyms)
+	}
+	if !Contains(pseudonyms, "reviewer-01234567") || Contains(pseudonyms, "reviewer-89abcdef") {
+		t.Fatal("Contains does not match the parsed pseudonyms")
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bundle packs the notes of code reviews into portable JSON bundles, and unpacks them into a repo.
+//
+// A bundle holds every note line of its reviews exactly as it was written, so
+// the hashes that identify comments, and any signatures, stay valid when the
+// bundle is imported into another repo (e.g. a fork) containing the same commits.
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/archive"
+	"github.com/promet/git-appraise/review/comment"
+	"strings"
+)
+
+// FormatVersion defines the latest version of the bundle format supported by the tool.
+const FormatVersion = 0
+
+// Review holds the notes of a single code review.
+type Review struct {
+	Revision string `json:"revision"`
+	// Notes maps each notes ref to the objects it annotates, and each of those to the lines of its notes.
+	Notes map[string]map[string][]string `json:"notes"`
+	// Attachments maps the hash of each file attached to a comment to its contents.
+	Attachments map[string][]byte `json:"attachments,omitempty"`
+}
+
+// Bundle holds the notes of a collection of code reviews.
+type Bundle struct {
+	Reviews []Review `json:"reviews"`
+	// Version represents the version of the bundle format.
+	Version int `json:"v,omitempty"`
+}
+
+// isBundledRef reports whether the notes under the given ref are included in bundles.
+func isBundledRef(notesRef string) bool {
+	for _, ref := range archive.NotesRefs {
+		if ref == notesRef && ref != comment.AttachmentsRef {
+			return true
+		}
+	}
+	return false
+}
+
+// collectAttachments adds the contents of the files attached to the given comment threads to the given map.
+func collectAttachments(repo repository.Repo, threads []review.CommentThread, attachments map[string][]byte) error {
+	for _, thread := range threads {
+		for _, attachment := range thread.Comment.Attachments {
+			contents, err := repo.ReadBlob(attachment.Hash)
+			if err != nil {
+				return err
+			}
+			attachments[attachment.Hash] = contents
+		}
+		if err := collectAttachments(repo, thread.Children, attachments); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewReview packs the notes of the given code review.
+func NewReview(r *review.Review) (*Review, error) {
+	packed := Review{
+		Revision: r.Revision,
+		Notes:    make(map[string]map[string][]string),
+	}
+	objects := archive.Objects(r)
+	for _, notesRef := range archive.NotesRefs {
+		if !isBundledRef(notesRef) {
+			// The attachment notes merely keep the attached blobs reachable, so they are recreated on import instead.
+			continue
+		}
+		for _, object := range objects {
+			var lines []string
+			for _, note := range r.Repo.GetNotes(notesRef, object) {
+				if line := strings.TrimSpace(string(note)); line != "" {
+					lines = append(lines, line)
+				}
+			}
+			if len(lines) == 0 {
+				continue
+			}
+			if packed.Notes[notesRef] == nil {
+				packed.Notes[notesRef] = make(map[string][]string)
+			}
+			packed.Notes[notesRef][object] = lines
+		}
+	}
+	attachments := make(map[string][]byte)
+	if err := collectAttachments(r.Repo, r.Comments, attachments); err != nil {
+		return nil, err
+	}
+	if len(attachments) > 0 {
+		packed.Attachments = attachments
+	}
+	return &packed, nil
+}
+
+// New packs the notes of the given code reviews into a bundle.
+func New(reviews []*review.Review) (*Bundle, error) {
+	b := Bundle{Reviews: []Review{}}
+	for _, r := range reviews {
+		packed, err := NewReview(r)
+		if err != nil {
+			return nil, err
+		}
+		b.Reviews = append(b.Reviews, *packed)
+	}
+	return &b, nil
+}
+
+// Parse parses a bundle from its JSON representation.
+func Parse(data []byte) (*Bundle, error) {
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("Failed to parse the bundle: %v", err)
+	}
+	if b.Version > FormatVersion {
+		return nil, &repository.SchemaVersionError{Kind: "bundle", Version: b.Version, Supported: FormatVersion}
+	}
+	return &b, nil
+}
+
+// Write writes the bundle as indented JSON.
+func (b *Bundle) Write() ([]byte, error) {
+	return json.MarshalIndent(b, "", "  ")
+}
+
+// Import writes the notes of the packed review into the given repo.
+//
+// Note lines that are already present in the repo are skipped, so importing
+// the same review more than once does not duplicate any of its notes. Every
+// annotated commit must already exist in the repo.
+func (packed *Review) Import(repo repository.Repo) error {
+	for notesRef, notesByObject := range packed.Notes {
+		if !isBundledRef(notesRef) {
+			return fmt.Errorf("The review %q has notes under the unsupported ref %q.", packed.Revision, notesRef)
+		}
+		for object := range notesByObject {
+			if err := repo.VerifyCommit(object); err != nil {
+				return fmt.Errorf("The commit %q of the review %q does not exist in this repo; fetch it before importing the review.", object, packed.Revision)
+			}
+		}
+	}
+	for hash, contents := range packed.Attachments {
+		stored, err := repo.StoreBlob(comment.AttachmentsRef, contents)
+		if err != nil {
+			return err
+		}
+		if stored != hash {
+			return fmt.Errorf("The contents of the attachment %q of the review %q do not match its hash.", hash, packed.Revision)
+		}
+	}
+	for notesRef, notesByObject := range packed.Notes {
+		for object, lines := range notesByObject {
+			existing := make(map[string]bool)
+			for _, note := range repo.GetNotes(notesRef, object) {
+				existing[strings.TrimSpace(string(note))] = true
+			}
+			var missing []string
+			for _, line := range lines {
+				if !existing[line] {
+					existing[line] = true
+					missing = append(missing, line)
+				}
+			}
+			if len(missing) == 0 {
+				continue
+			}
+			if err := repo.AppendNote(notesRef, object, repository.Note(strings.Join(missing, "\n"))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Import writes the notes of every review in the bundle into the given repo.
+func (b *Bundle) Import(repo repository.Repo) error {
+	for i := range b.Reviews {
+		if err := b.Reviews[i].Import(repo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
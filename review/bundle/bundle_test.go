@@ -0,0 +1,106 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundle
+
+import (
+	"errors"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	source := repository.NewMockRepoForTest()
+	attachment, err := comment.StoreAttachment(source, "log.txt", []byte("build failed\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := comment.New("reviewer@example.com", "See the attached log")
+	c.Attachments = []comment.Attachment{attachment}
+	note, err := c.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := source.AppendNote(comment.Ref, repository.TestCommitB, note); err != nil {
+		t.Fatal(err)
+	}
+	r, err := review.Get(source, repository.TestCommitB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New([]*review.Review{r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The destination repo has the same commits, but no notes for the review.
+	destination := repository.NewMockRepoForTest()
+	for _, ref := range []string{request.Ref, comment.Ref} {
+		if err := destination.MoveNotes(ref, "refs/notes/elsewhere", []string{repository.TestCommitB}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		// Importing the bundle again must not duplicate any notes.
+		if err := parsed.Import(destination); err != nil {
+			t.Fatal(err)
+		}
+	}
+	imported, err := review.Get(destination, repository.TestCommitB)
+	if err != nil || imported == nil {
+		t.Fatalf("Failed to load the imported review: %v", err)
+	}
+	if imported.Request.Description != r.Request.Description || len(imported.Comments) != len(r.Comments) {
+		t.Fatalf("The imported review does not match the original: %v vs %v", imported, r)
+	}
+	for i := range r.Comments {
+		if imported.Comments[i].Hash != r.Comments[i].Hash {
+			t.Errorf("The hash of comment %d changed from %q to %q", i, r.Comments[i].Hash, imported.Comments[i].Hash)
+		}
+	}
+	countLines := func(repo repository.Repo) int {
+		var count int
+		for _, note := range repo.GetNotes(comment.Ref, repository.TestCommitB) {
+			if len(note) > 0 {
+				count++
+			}
+		}
+		return count
+	}
+	if countLines(destination) != countLines(source) {
+		t.Errorf("Unexpected number of imported comment notes: %d vs %d", countLines(destination), countLines(source))
+	}
+	if contents, err := destination.ReadBlob(attachment.Hash); err != nil || string(contents) != "build failed\n" {
+		t.Errorf("The attachment was not imported: %q, %v", contents, err)
+	}
+}
+
+func TestParseUnsupportedVersion(t *testing.T) {
+	if _, err := Parse([]byte(`{"reviews": [], "v": 1}`)); !errors.Is(err, repository.ErrSchemaVersion) {
+		t.Errorf("Unexpected error parsing a bundle with an unsupported version: %v", err)
+	}
+}
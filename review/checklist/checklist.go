@@ -0,0 +1,222 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package checklist defines the items that reviewers check off before a review is submitted.
+//
+// Checklists are read from the ".appraise/checklist" file. Each non-comment
+// line declares a single item, in the form:
+//
+//	<name> [(required)] [: <hint>]
+//
+// For example:
+//
+//	Security review done (required): Has the security team looked at the change?
+//	Docs updated
+//
+// Checking an item off a review, or unchecking it, is recorded as a check in
+// the "refs/notes/pullrequests/checklist" ref. The latest check of each item
+// decides whether it is checked off, and a review cannot be submitted until
+// each of its required items is.
+package checklist
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is the path from which a checklist is read.
+const File = ".appraise/checklist"
+
+// Ref defines the git-notes ref that we expect to contain the checks of checklist items.
+const Ref = "refs/notes/pullrequests/checklist"
+
+// FormatVersion defines the latest version of the check format supported by the tool.
+const FormatVersion = 0
+
+const requiredMarker = "(required)"
+
+// Item is a single entry of a checklist.
+type Item struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required,omitempty"`
+	// Hint is an optional explanation of what checking off the item means.
+	Hint string `json:"hint,omitempty"`
+}
+
+// Checklist is an ordered list of items.
+type Checklist struct {
+	Items []Item `json:"items"`
+}
+
+// Parse parses the contents of a checklist file.
+func Parse(contents string) (*Checklist, error) {
+	c := &Checklist{}
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var item Item
+		if i := strings.Index(line, ":"); i >= 0 {
+			item.Hint = strings.TrimSpace(line[i+1:])
+			line = strings.TrimSpace(line[:i])
+		}
+		if strings.HasSuffix(line, requiredMarker) {
+			item.Required = true
+			line = strings.TrimSpace(strings.TrimSuffix(line, requiredMarker))
+		}
+		if line == "" {
+			return nil, fmt.Errorf("Missing item name on line %d", lineNumber)
+		}
+		item.Name = line
+		if seen[strings.ToLower(item.Name)] {
+			return nil, fmt.Errorf("Duplicate item %q on line %d", item.Name, lineNumber)
+		}
+		seen[strings.ToLower(item.Name)] = true
+		c.Items = append(c.Items, item)
+	}
+	return c, scanner.Err()
+}
+
+// Load reads the checklist that applies to reviews targeting the given commit.
+//
+// As with description templates, the checklist is read from the commit being
+// merged into, so that a review cannot change the checklist that applies to
+// it. If the checklist file does not exist, then this returns nil.
+func Load(repo repository.Repo, commit string) (*Checklist, error) {
+	contents, err := repo.Show(commit, File)
+	if err != nil {
+		return nil, nil
+	}
+	c, err := Parse(contents)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %q: %v", File, err)
+	}
+	return c, nil
+}
+
+// Find returns the item with the given name, ignoring case, or nil if there is none.
+func (c *Checklist) Find(name string) *Item {
+	for i := range c.Items {
+		if strings.EqualFold(c.Items[i].Name, strings.TrimSpace(name)) {
+			return &c.Items[i]
+		}
+	}
+	return nil
+}
+
+// Unchecked returns the names of the required items that the given checks leave unchecked.
+func (c *Checklist) Unchecked(checks []Check) []string {
+	latest := Latest(checks)
+	var unchecked []string
+	for _, item := range c.Items {
+		if check, ok := latest[strings.ToLower(item.Name)]; item.Required && (!ok || check.Unchecked) {
+			unchecked = append(unchecked, item.Name)
+		}
+	}
+	return unchecked
+}
+
+// Check records that an item of a review's checklist was checked off, or unchecked.
+//
+// Checks annotate the first revision in a review.
+type Check struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Author    string `json:"author,omitempty"`
+	// Item is the name of the checklist item.
+	Item string `json:"item"`
+	// Commit is the head of the review when the item was checked.
+	Commit string `json:"commit,omitempty"`
+	// Unchecked indicates that the item was unchecked again, after an earlier check.
+	Unchecked bool `json:"unchecked,omitempty"`
+	// Version represents the version of the metadata format.
+	Version int `json:"v,omitempty"`
+}
+
+// New returns a new check of the given item by the given author, made when the review's head was the given commit.
+//
+// The Timestamp field is automatically filled in with the current time.
+func New(author, item, commit string, unchecked bool) Check {
+	return Check{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Author:    author,
+		Item:      item,
+		Commit:    commit,
+		Unchecked: unchecked,
+	}
+}
+
+// ParseCheck parses a check from a git note.
+func ParseCheck(note repository.Note) (Check, error) {
+	var check Check
+	err := json.Unmarshal([]byte(note), &check)
+	return check, err
+}
+
+// ParseAllValid takes collection of git notes and tries to parse a check
+// from each one. Any notes that are not valid checks get ignored.
+func ParseAllValid(notes []repository.Note) []Check {
+	var checks []Check
+	for _, note := range notes {
+		note, err := schema.Upgrade(Ref, note, FormatVersion)
+		if err != nil {
+			continue
+		}
+		check, err := ParseCheck(note)
+		if err == nil && check.Version == FormatVersion && check.Item != "" {
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
+
+// Write writes a check as a JSON-formatted git note.
+func (check Check) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(check)
+	return repository.Note(bytes), err
+}
+
+type byTimestamp []Check
+
+func (c byTimestamp) Len() int      { return len(c) }
+func (c byTimestamp) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c byTimestamp) Less(i, j int) bool {
+	return schema.CompareTimestamps(c[i].Timestamp, c[j].Timestamp) < 0
+}
+
+// Latest returns the latest of the given checks of each item, keyed by the item's name in lower case.
+//
+// Checks with the same timestamp are kept in the order that they were given.
+func Latest(checks []Check) map[string]Check {
+	sorted := append([]Check(nil), checks...)
+	sort.Stable(byTimestamp(sorted))
+	latest := make(map[string]Check)
+	for _, check := range sorted {
+		latest[strings.ToLower(check.Item)] = check
+	}
+	return latest
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package checklist
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse("# Checked off by the reviewers.\nSecurity review done (required): Has the security team looked at it?\n\nDocs updated\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Item{
+		{Name: "Security review done", Required: true, Hint: "Has the security team looked at it?"},
+		{Name: "Docs updated"},
+	}
+	if !reflect.DeepEqual(c.Items, expected) {
+		t.Fatalf("Unexpected items: %+v", c.Items)
+	}
+	if item := c.Find(" docs UPDATED"); item == nil || item.Name != "Docs updated" {
+		t.Fatalf("Failed to find an item by name: %+v", item)
+	}
+	if _, err := Parse("Docs updated\ndocs updated (required)\n"); err == nil {
+		t.Fatal("Accepted a duplicate item")
+	}
+	if _, err := Parse("(required): hint\n"); err == nil {
+		t.Fatal("Accepted an item without a name")
+	}
+}
+
+func TestUnchecked(t *testing.T) {
+	c := &Checklist{Items: []Item{
+		{Name: "Security review done", Required: true},
+		{Name: "Tests added", Required: true},
+		{Name: "Docs updated"},
+	}}
+	checks := ParseAllValid([]repository.Note{
+		repository.Note(`{"timestamp":"0000000001","author":"bob","item":"security review done"}`),
+		repository.Note(`{"timestamp":"0000000001","author":"bob","item":"Tests added"}`),
+		repository.Note(`{"timestamp":"0000000002","author":"bob","item":"Tests added","unchecked":true}`),
+		repository.Note(`{"timestamp":"0000000003","author":"bob","item":"Security review done","v":1}`),
+		repository.Note(`{"timestamp":"0000000003","author":"bob"}`),
+	})
+	if len(checks) != 3 {
+		t.Fatalf("Unexpected checks: %+v", checks)
+	}
+	if unchecked := c.Unchecked(checks); !reflect.DeepEqual(unchecked, []string{"Tests added"}) {
+		t.Fatalf("Unexpected unchecked items: %v", unchecked)
+	}
+	if unchecked := c.Unchecked(nil); len(unchecked) != 2 {
+		t.Fatalf("Unexpected unchecked items without any checks: %v", unchecked)
+	}
+}
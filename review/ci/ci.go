@@ -14,14 +14,21 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package ci defines the internal representation of a continuous integration reports.
+// Package ci defines the representation of the continuous integration reports stored in git notes.
 package ci
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/promet/git-appraise/repository"
-	"sort"
+	"github.com/promet/git-appraise/review/gpg"
+	"github.com/promet/git-appraise/review/schema"
+	"io/ioutil"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -32,11 +39,28 @@ const (
 	StatusSuccess = "success"
 	// StatusFailure is the status string representing that a build and/or test failed.
 	StatusFailure = "failure"
+	// StatusPending is the status string representing that a build and/or test is queued, but has not started.
+	StatusPending = "pending"
+	// StatusRunning is the status string representing that a build and/or test is still executing.
+	StatusRunning = "running"
 
 	// FormatVersion defines the latest version of the request format supported by the tool.
 	FormatVersion = 0
+
+	// MaxLogLength defines the maximum size (in bytes) of a log excerpt stored in a report.
+	//
+	// Reports are stored inline in git-notes, so we only keep the tail of long
+	// logs, as that is typically where the cause of a failure is reported.
+	MaxLogLength = 4096
 )
 
+// Artifact represents a file produced by a build, such as a full log or a test report.
+type Artifact struct {
+	Name        string `json:"name,omitempty"`
+	URL         string `json:"url,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
 // Report represents a build/test status report generated by a continuous integration tool.
 //
 // Every field is optional.
@@ -45,8 +69,166 @@ type Report struct {
 	URL       string `json:"url,omitempty"`
 	Status    string `json:"status,omitempty"`
 	Agent     string `json:"agent,omitempty"`
+	// Log is an optional excerpt of the build log, such as the output preceding a failure.
+	Log string `json:"log,omitempty"`
+	// Artifacts are optional links to files produced by the build.
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	// Coverage is the optional code coverage measured by the build.
+	Coverage *Coverage `json:"coverage,omitempty"`
+	// Trigger is the optional hash of the rerun request that this report responds to.
+	Trigger string `json:"trigger,omitempty"`
+	// Tests is an optional summary of the results of the tests run by the build.
+	Tests *TestResults `json:"tests,omitempty"`
+	// Signature is an optional, ASCII-armored, detached GPG signature of the
+	// report, computed over the serialized report with this field left empty.
+	Signature string `json:"signature,omitempty"`
 	// Version represents the version of the metadata format.
 	Version int `json:"v,omitempty"`
+	// note is the note that the report was parsed from, before it was upgraded, against which its signature is verified.
+	note repository.Note
+}
+
+// New returns a new CI report for the given agent, status, and URL.
+//
+// The Timestamp field is automatically filled in with the current time.
+func New(agent, status, url string) Report {
+	return Report{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Agent:     agent,
+		Status:    status,
+		URL:       url,
+	}
+}
+
+// Validate checks that the report conforms to the CI report schema.
+func (report Report) Validate() error {
+	if report.Agent == "" {
+		return fmt.Errorf("CI reports must specify an agent")
+	}
+	if _, err := schema.ParseTimestamp(report.Timestamp); err != nil {
+		return fmt.Errorf("Invalid CI report timestamp %q: %v", report.Timestamp, err)
+	}
+	if !IsValidStatus(report.Status) {
+		return fmt.Errorf("Unknown CI report status %q", report.Status)
+	}
+	if report.Version != FormatVersion {
+		return &repository.SchemaVersionError{Kind: "CI report", Version: report.Version, Supported: FormatVersion}
+	}
+	return nil
+}
+
+// Write writes a CI report as a JSON-formatted git note.
+func (report Report) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(report)
+	return repository.Note(bytes), err
+}
+
+// Sign signs the report using the given GPG key, and stores the resulting signature in the report.
+//
+// If the key is empty, then the default GPG signing key is used.
+func (report *Report) Sign(key string) error {
+	report.Signature = ""
+	report.note = nil
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	signature, err := gpg.Sign(key, bytes)
+	if err != nil {
+		return err
+	}
+	report.Signature = signature
+	return nil
+}
+
+// Verify checks the signature of the report, and returns the key that signed it.
+//
+// The signature is checked against the note that the report was parsed from, if there is one.
+func (report Report) Verify() (*gpg.Signer, error) {
+	signature := report.Signature
+	if unsigned, ok := gpg.Unsigned(report.note, signature); ok && signature != "" {
+		return gpg.Verify(unsigned, signature)
+	}
+	report.Signature = ""
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	return gpg.Verify(bytes, signature)
+}
+
+// AppendLog adds the given text to the end of the report's log excerpt.
+//
+// If the resulting excerpt exceeds MaxLogLength, then the beginning of it is discarded.
+func (report *Report) AppendLog(text string) {
+	log := report.Log + text
+	if len(log) > MaxLogLength {
+		log = log[len(log)-MaxLogLength:]
+		if newline := strings.Index(log, "\n"); newline >= 0 && newline < len(log)-1 {
+			// Avoid starting the excerpt in the middle of a line.
+			log = log[newline+1:]
+		}
+	}
+	report.Log = log
+}
+
+// AddArtifact adds a link to a build artifact to the report.
+//
+// If the report already has an artifact with the same name, then it is replaced.
+func (report *Report) AddArtifact(name, url, contentType string) {
+	artifact := Artifact{
+		Name:        name,
+		URL:         url,
+		ContentType: contentType,
+	}
+	for i, existing := range report.Artifacts {
+		if existing.Name == name {
+			report.Artifacts[i] = artifact
+			return
+		}
+	}
+	report.Artifacts = append(report.Artifacts, artifact)
+}
+
+// GetArtifact returns the artifact with the given name, or nil if the report does not include one.
+func (report Report) GetArtifact(name string) *Artifact {
+	for _, artifact := range report.Artifacts {
+		if artifact.Name == name {
+			return &artifact
+		}
+	}
+	return nil
+}
+
+// Fetch downloads the contents of a build artifact.
+func (artifact Artifact) Fetch() ([]byte, error) {
+	return artifact.FetchContext(context.Background(), http.DefaultClient)
+}
+
+// FetchContext downloads the contents of a build artifact using the given client.
+//
+// The download is abandoned if the context is cancelled or its deadline passes.
+func (artifact Artifact) FetchContext(ctx context.Context, client *http.Client) ([]byte, error) {
+	if artifact.URL == "" {
+		return nil, fmt.Errorf("The artifact %q does not specify a URL", artifact.Name)
+	}
+	req, err := http.NewRequest(http.MethodGet, artifact.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	contents, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch the artifact %q: %s", artifact.Name, res.Status)
+	}
+	return contents, nil
 }
 
 // Parse parses a CI report from a git note.
@@ -54,27 +236,88 @@ func Parse(note repository.Note) (Report, error) {
 	bytes := []byte(note)
 	var report Report
 	err := json.Unmarshal(bytes, &report)
+	report.note = note
 	return report, err
 }
 
 // GetLatestCIReport takes the collection of reports and returns the one with the most recent timestamp.
+//
+// Reports whose timestamps cannot be parsed are skipped, rather than hiding
+// the results of all the others; see SkippedReportWarnings. Of the reports
+// with the same timestamp, the one that comes last in the collection (which
+// is to say, the one that was added to the notes last) is returned.
+//
+// If there are no reports with a valid timestamp, then the result is nil.
+// The returned error is always nil, and is kept for compatibility.
 func GetLatestCIReport(reports []Report) (*Report, error) {
-	timestampReportMap := make(map[int]*Report)
-	var timestamps []int
+	var latest *Report
+	var latestTime time.Time
+	for i, report := range reports {
+		timestamp, err := schema.ParseTimestamp(report.Timestamp)
+		if err != nil {
+			continue
+		}
+		if latest == nil || !timestamp.Before(latestTime) {
+			latest, latestTime = &reports[i], timestamp
+		}
+	}
+	return latest, nil
+}
 
+// SkippedReportWarnings returns a warning for each of the given reports that is
+// skipped when choosing the latest reports, because its timestamp cannot be parsed.
+func SkippedReportWarnings(reports []Report) []string {
+	var warnings []string
+	for _, report := range reports {
+		if _, err := schema.ParseTimestamp(report.Timestamp); err != nil {
+			agent := report.Agent
+			if agent == "" {
+				agent = "unknown agent"
+			}
+			warnings = append(warnings, fmt.Sprintf("Skipped the CI report from %s with the invalid timestamp %q.", agent, report.Timestamp))
+		}
+	}
+	return warnings
+}
+
+// GetLatestCIReportsByAgent takes the collection of reports and returns the
+// most recent report from each CI agent.
+//
+// The returned value is a mapping from the agent name to that agent's latest
+// report. Reports that do not specify an agent are grouped under the empty string,
+// and agents that only posted reports with invalid timestamps are left out.
+func GetLatestCIReportsByAgent(reports []Report) (map[string]*Report, error) {
+	reportsByAgent := make(map[string][]Report)
 	for _, report := range reports {
-		timestamp, err := strconv.Atoi(report.Timestamp)
+		reportsByAgent[report.Agent] = append(reportsByAgent[report.Agent], report)
+	}
+	latestReports := make(map[string]*Report)
+	for agent, agentReports := range reportsByAgent {
+		latestReport, err := GetLatestCIReport(agentReports)
 		if err != nil {
 			return nil, err
 		}
-		timestamps = append(timestamps, timestamp)
-		timestampReportMap[timestamp] = &report
+		if latestReport != nil {
+			latestReports[agent] = latestReport
+		}
 	}
-	if len(timestamps) == 0 {
-		return nil, nil
+	return latestReports, nil
+}
+
+// IsValidStatus returns whether or not the given string is a status that the tool understands.
+//
+// The empty string is considered valid, as the status field is optional.
+func IsValidStatus(status string) bool {
+	switch status {
+	case "", StatusSuccess, StatusFailure, StatusPending, StatusRunning:
+		return true
 	}
-	sort.Sort(sort.Reverse(sort.IntSlice(timestamps)))
-	return timestampReportMap[timestamps[0]], nil
+	return false
+}
+
+// IsInProgress returns whether or not the report describes a build that has not yet finished.
+func (report Report) IsInProgress() bool {
+	return report.Status == StatusPending || report.Status == StatusRunning
 }
 
 // ParseAllValid takes collection of git notes and tries to parse a CI report
@@ -84,9 +327,14 @@ func GetLatestCIReport(reports []Report) (*Report, error) {
 func ParseAllValid(notes []repository.Note) []Report {
 	var reports []Report
 	for _, note := range notes {
-		report, err := Parse(note)
+		upgraded, err := schema.Upgrade(Ref, note, FormatVersion)
+		if err != nil {
+			continue
+		}
+		report, err := Parse(upgraded)
 		if err == nil && report.Version == FormatVersion {
-			if report.Status == "" || report.Status == StatusSuccess || report.Status == StatusFailure {
+			report.note = note
+			if IsValidStatus(report.Status) {
 				reports = append(reports, report)
 			}
 		}
@@ -17,7 +17,13 @@ limitations under the License.
 package ci
 
 import (
+	"context"
+	"fmt"
 	"github.com/promet/git-appraise/repository"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -63,7 +69,7 @@ func TestCIReport(t *testing.T) {
 	if err != nil {
 		t.Fatal("Failed to parse the expected report", err)
 	}
-	if *latestReport != expected {
+	if !reflect.DeepEqual(*latestReport, expected) {
 		t.Fatal("This is not the latest ", latestReport)
 	}
 	latestReport, err = GetLatestCIReport(ParseAllValid([]repository.Note{
@@ -79,7 +85,143 @@ func TestCIReport(t *testing.T) {
 	if err != nil {
 		t.Fatal("Failed to parse the expected report", err)
 	}
-	if *latestReport != expected {
+	if !reflect.DeepEqual(*latestReport, expected) {
 		t.Fatal("This is not the latest ", latestReport)
 	}
 }
+
+const testCINote6 = `{
+	"Timestamp": "31",
+	"URL": "www.prometsource.com",
+	"Status": "running"
+}`
+
+func TestInProgressCIReport(t *testing.T) {
+	reports := ParseAllValid([]repository.Note{
+		repository.Note(testCINote1),
+		repository.Note(testCINote3),
+		repository.Note(testCINote6),
+	})
+	if len(reports) != 2 {
+		t.Fatal("Unexpected set of valid reports", reports)
+	}
+	latestReport, err := GetLatestCIReport(reports)
+	if err != nil {
+		t.Fatal("Failed to properly fetch the latest report", err)
+	}
+	if latestReport.Status != StatusRunning || !latestReport.IsInProgress() {
+		t.Fatal("Failed to recognize the latest report as in progress", latestReport)
+	}
+	if reports[0].IsInProgress() {
+		t.Fatal("Incorrectly recognized a finished report as in progress", reports[0])
+	}
+}
+
+func TestLatestCIReportsByAgent(t *testing.T) {
+	reports := ParseAllValid([]repository.Note{
+		repository.Note(`{"timestamp": "0000000001", "agent": "unit", "status": "failure"}`),
+		repository.Note(`{"timestamp": "0000000002", "agent": "unit", "status": "success"}`),
+		repository.Note(`{"timestamp": "0000000003", "agent": "lint", "status": "failure"}`),
+		repository.Note(`{"timestamp": "0000000004", "status": "running"}`),
+	})
+	latestReports, err := GetLatestCIReportsByAgent(reports)
+	if err != nil {
+		t.Fatal("Failed to properly fetch the latest reports", err)
+	}
+	if len(latestReports) != 3 {
+		t.Fatal("Unexpected number of agents", latestReports)
+	}
+	if latestReports["unit"].Status != StatusSuccess {
+		t.Fatal("Unexpected latest report for the unit agent", latestReports["unit"])
+	}
+	if latestReports["lint"].Status != StatusFailure {
+		t.Fatal("Unexpected latest report for the lint agent", latestReports["lint"])
+	}
+	if latestReports[""].Status != StatusRunning {
+		t.Fatal("Unexpected latest report for the unnamed agent", latestReports[""])
+	}
+}
+
+func TestRFC3339CIReport(t *testing.T) {
+	reports := ParseAllValid([]repository.Note{
+		repository.Note(`{"timestamp": "2024-03-01T12:00:00+09:00", "agent": "unit", "status": "failure"}`),
+		repository.Note(`{"timestamp": "1709262000", "agent": "unit", "status": "success"}`),
+		repository.Note(`{"timestamp": "2024-03-01T03:30:00Z", "agent": "unit", "status": "running"}`),
+	})
+	latestReport, err := GetLatestCIReport(reports)
+	if err != nil {
+		t.Fatal("Failed to properly fetch the latest report", err)
+	}
+	if latestReport.Status != StatusRunning {
+		t.Fatal("Unexpected latest report", latestReport)
+	}
+	for _, report := range reports {
+		if err := report.Validate(); err != nil {
+			t.Fatal("Failed to validate a report", err)
+		}
+	}
+}
+
+func TestSkippedCIReports(t *testing.T) {
+	reports := ParseAllValid([]repository.Note{
+		repository.Note(`{"timestamp": "0000000001", "agent": "unit", "status": "failure"}`),
+		repository.Note(`{"timestamp": "0000000002", "agent": "unit", "status": "failure"}`),
+		repository.Note(`{"timestamp": "0000000002", "agent": "unit", "status": "success"}`),
+		repository.Note(`{"timestamp": "yesterday", "agent": "unit", "status": "running"}`),
+		repository.Note(`{"timestamp": "", "agent": "lint", "status": "failure"}`),
+	})
+	latestReports, err := GetLatestCIReportsByAgent(reports)
+	if err != nil {
+		t.Fatal("Failed to properly fetch the latest reports", err)
+	}
+	if len(latestReports) != 1 || latestReports["unit"].Status != StatusSuccess {
+		t.Fatal("Unexpected latest reports", latestReports)
+	}
+	warnings := SkippedReportWarnings(reports)
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "yesterday") || !strings.Contains(warnings[1], "lint") {
+		t.Fatal("Unexpected warnings about the skipped reports", warnings)
+	}
+}
+
+func TestLogsAndArtifacts(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "full build log")
+	}))
+	defer mockServer.Close()
+
+	var report Report
+	report.AppendLog("first line\n")
+	report.AppendLog("second line\n")
+	if report.Log != "first line\nsecond line\n" {
+		t.Fatalf("Unexpected log excerpt: %q", report.Log)
+	}
+	report.AppendLog(strings.Repeat("x\n", MaxLogLength))
+	if len(report.Log) > MaxLogLength || strings.HasPrefix(report.Log, "first") {
+		t.Fatalf("Failed to truncate the log excerpt: %d bytes", len(report.Log))
+	}
+
+	report.AddArtifact("log", "https://this-url-does-not-exist.test/log", "text/plain")
+	report.AddArtifact("log", mockServer.URL, "text/plain")
+	if len(report.Artifacts) != 1 {
+		t.Fatal("Failed to replace an existing artifact", report.Artifacts)
+	}
+	if report.GetArtifact("missing") != nil {
+		t.Fatal("Unexpected artifact")
+	}
+	artifact := report.GetArtifact("log")
+	if artifact == nil {
+		t.Fatal("Failed to find the log artifact")
+	}
+	contents, err := artifact.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "full build log" {
+		t.Fatalf("Unexpected artifact contents: %q", contents)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := artifact.FetchContext(ctx, mockServer.Client()); err == nil {
+		t.Fatal("Unexpected success fetching an artifact with a cancelled context")
+	}
+}
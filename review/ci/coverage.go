@@ -0,0 +1,212 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ci
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Coverage represents the code coverage measured by a build.
+type Coverage struct {
+	// Percent is the overall percentage of covered lines (or statements).
+	Percent float64 `json:"percent"`
+	// Files maps the path of each measured file to its coverage percentage.
+	Files map[string]float64 `json:"files,omitempty"`
+}
+
+// counts holds the number of covered and total lines (or statements) for a single file.
+type counts struct {
+	covered, total int64
+}
+
+func newCoverage(files map[string]*counts) *Coverage {
+	coverage := &Coverage{Files: make(map[string]float64)}
+	var covered, total int64
+	for path, c := range files {
+		covered += c.covered
+		total += c.total
+		if c.total > 0 {
+			coverage.Files[path] = 100 * float64(c.covered) / float64(c.total)
+		}
+	}
+	if total > 0 {
+		coverage.Percent = 100 * float64(covered) / float64(total)
+	}
+	return coverage
+}
+
+// parseGoProfile parses a coverage profile generated by "go test -coverprofile".
+func parseGoProfile(contents string) (*Coverage, error) {
+	type block struct {
+		statements int64
+		covered    bool
+	}
+	blocks := make(map[string]map[string]block)
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		// Each line is of the form "<file>:<start>,<end> <statements> <count>".
+		fields := strings.Fields(line)
+		if len(fields) != 3 || !strings.Contains(fields[0], ":") {
+			return nil, fmt.Errorf("Malformed coverage profile line %d: %q", lineNumber, line)
+		}
+		statements, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Malformed coverage profile line %d: %q", lineNumber, line)
+		}
+		count, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Malformed coverage profile line %d: %q", lineNumber, line)
+		}
+		colon := strings.LastIndex(fields[0], ":")
+		path, key := fields[0][:colon], fields[0][colon+1:]
+		if blocks[path] == nil {
+			blocks[path] = make(map[string]block)
+		}
+		// The same block may be listed more than once when profiles are merged.
+		existing := blocks[path][key]
+		blocks[path][key] = block{statements: statements, covered: existing.covered || count > 0}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	files := make(map[string]*counts)
+	for path, fileBlocks := range blocks {
+		c := &counts{}
+		for _, b := range fileBlocks {
+			c.total += b.statements
+			if b.covered {
+				c.covered += b.statements
+			}
+		}
+		files[path] = c
+	}
+	return newCoverage(files), nil
+}
+
+// parseLCOV parses a coverage report in the LCOV tracefile format.
+func parseLCOV(contents string) (*Coverage, error) {
+	files := make(map[string]*counts)
+	var current *counts
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			path := strings.TrimPrefix(line, "SF:")
+			if files[path] == nil {
+				files[path] = &counts{}
+			}
+			current = files[path]
+		case current == nil:
+			continue
+		case strings.HasPrefix(line, "LF:"):
+			n, err := strconv.ParseInt(strings.TrimPrefix(line, "LF:"), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Malformed LCOV line %q", line)
+			}
+			current.total += n
+		case strings.HasPrefix(line, "LH:"):
+			n, err := strconv.ParseInt(strings.TrimPrefix(line, "LH:"), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Malformed LCOV line %q", line)
+			}
+			current.covered += n
+		case line == "end_of_record":
+			current = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("The coverage report does not include any files")
+	}
+	return newCoverage(files), nil
+}
+
+// ParseCoverage parses a coverage report, which may either be a Go coverage profile or an LCOV tracefile.
+func ParseCoverage(contents string) (*Coverage, error) {
+	if strings.HasPrefix(strings.TrimSpace(contents), "mode:") {
+		return parseGoProfile(contents)
+	}
+	return parseLCOV(contents)
+}
+
+// GetLatestCoverage returns the coverage from the most recent of the given reports that includes any.
+func GetLatestCoverage(reports []Report) *Coverage {
+	var latest *Report
+	for i, report := range reports {
+		if report.Coverage != nil && (latest == nil || schema.CompareTimestamps(report.Timestamp, latest.Timestamp) >= 0) {
+			latest = &reports[i]
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	return latest.Coverage
+}
+
+// FileCoverageDelta represents the change in the coverage of a single file.
+type FileCoverageDelta struct {
+	Path string  `json:"path"`
+	Base float64 `json:"base"`
+	Head float64 `json:"head"`
+	// New indicates that the file was not measured in the base coverage.
+	New bool `json:"new,omitempty"`
+}
+
+// Delta returns the change in the coverage percentage of the file.
+func (delta FileCoverageDelta) Delta() float64 {
+	return delta.Head - delta.Base
+}
+
+// CompareFiles returns the files whose coverage differs between the base and head coverage, sorted by path.
+//
+// Files that are only measured in the base coverage are left out, as they
+// are typically files that were deleted.
+func CompareFiles(base, head *Coverage) []FileCoverageDelta {
+	if head == nil {
+		return nil
+	}
+	var deltas []FileCoverageDelta
+	for path, percent := range head.Files {
+		delta := FileCoverageDelta{Path: path, Head: percent}
+		if base == nil {
+			delta.New = true
+		} else if basePercent, ok := base.Files[path]; ok {
+			delta.Base = basePercent
+		} else {
+			delta.New = true
+		}
+		if delta.New || delta.Delta() != 0 {
+			deltas = append(deltas, delta)
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		return deltas[i].Path < deltas[j].Path
+	})
+	return deltas
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ci
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testGoProfile = `mode: set
+example.com/pkg/a.go:3.10,5.2 2 1
+example.com/pkg/a.go:7.10,9.2 2 0
+example.com/pkg/a.go:3.10,5.2 2 0
+example.com/pkg/b.go:1.1,2.2 4 1
+`
+
+const testLCOV = `TN:
+SF:src/a.js
+DA:1,1
+LF:10
+LH:5
+end_of_record
+SF:src/b.js
+LF:0
+LH:0
+end_of_record
+SF:src/c.js
+LF:10
+LH:10
+end_of_record
+`
+
+func TestParseCoverageGoProfile(t *testing.T) {
+	coverage, err := ParseCoverage(testGoProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The duplicated block for a.go is covered in one of its entries, so it counts as covered.
+	expected := &Coverage{
+		Percent: 75,
+		Files: map[string]float64{
+			"example.com/pkg/a.go": 50,
+			"example.com/pkg/b.go": 100,
+		},
+	}
+	if !reflect.DeepEqual(coverage, expected) {
+		t.Errorf("Unexpected coverage: %+v", coverage)
+	}
+}
+
+func TestParseCoverageLCOV(t *testing.T) {
+	coverage, err := ParseCoverage(testLCOV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Coverage{
+		Percent: 75,
+		Files: map[string]float64{
+			"src/a.js": 50,
+			"src/c.js": 100,
+		},
+	}
+	if !reflect.DeepEqual(coverage, expected) {
+		t.Errorf("Unexpected coverage: %+v", coverage)
+	}
+	if _, err := ParseCoverage("not a coverage report"); err == nil {
+		t.Error("Expected an error for an unrecognized coverage format")
+	}
+}
+
+func TestGetLatestCoverage(t *testing.T) {
+	reports := []Report{
+		{Timestamp: "2", Coverage: &Coverage{Percent: 20}},
+		{Timestamp: "3"},
+		{Timestamp: "1", Coverage: &Coverage{Percent: 10}},
+	}
+	if coverage := GetLatestCoverage(reports); coverage == nil || coverage.Percent != 20 {
+		t.Errorf("Unexpected latest coverage: %+v", coverage)
+	}
+	if coverage := GetLatestCoverage(reports[1:2]); coverage != nil {
+		t.Errorf("Unexpected coverage for reports without any: %+v", coverage)
+	}
+}
+
+func TestCompareFiles(t *testing.T) {
+	base := &Coverage{Files: map[string]float64{"a": 50, "b": 80, "deleted": 10}}
+	head := &Coverage{Files: map[string]float64{"a": 60, "b": 80, "c": 90}}
+	expected := []FileCoverageDelta{
+		{Path: "a", Base: 50, Head: 60},
+		{Path: "c", Head: 90, New: true},
+	}
+	if deltas := CompareFiles(base, head); !reflect.DeepEqual(deltas, expected) {
+		t.Errorf("Unexpected deltas: %+v", deltas)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ci
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/schema"
+	"strconv"
+	"time"
+)
+
+// RerunRef defines the git-notes ref that we expect to contain requests to re-run CI builds.
+const RerunRef = "refs/notes/pullrequests/ci-rerun"
+
+// Rerun represents a request for CI agents to build and test a commit again.
+//
+// CI systems are expected to poll for pending reruns, and to set the Trigger
+// field of the reports they post in response to the hash of the rerun.
+type Rerun struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Requester string `json:"requester,omitempty"`
+	// Agent is the CI agent that should re-run the build; if empty, every agent should.
+	Agent  string `json:"agent,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Version represents the version of the metadata format.
+	Version int `json:"v,omitempty"`
+}
+
+// NewRerun returns a new request for the given agent to re-run its build.
+//
+// The Timestamp field is automatically filled in with the current time.
+func NewRerun(requester, agent, reason string) Rerun {
+	return Rerun{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Requester: requester,
+		Agent:     agent,
+		Reason:    reason,
+	}
+}
+
+// Write writes a rerun request as a JSON-formatted git note.
+func (rerun Rerun) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(rerun)
+	return repository.Note(bytes), err
+}
+
+// Hash returns the SHA1 hash of a rerun request, which reports use to refer to it.
+func (rerun Rerun) Hash() (string, error) {
+	bytes, err := json.Marshal(rerun)
+	return fmt.Sprintf("%x", sha1.Sum(bytes)), err
+}
+
+// Matches returns whether or not the rerun request applies to the given agent.
+func (rerun Rerun) Matches(agent string) bool {
+	return rerun.Agent == "" || rerun.Agent == agent
+}
+
+// ParseAllValidReruns takes a collection of git notes and tries to parse a
+// rerun request from each one. Any notes that are not valid rerun requests
+// get ignored.
+func ParseAllValidReruns(notes []repository.Note) []Rerun {
+	var reruns []Rerun
+	for _, note := range notes {
+		note, err := schema.Upgrade(RerunRef, note, FormatVersion)
+		if err != nil {
+			continue
+		}
+		var rerun Rerun
+		if err := json.Unmarshal([]byte(note), &rerun); err == nil && rerun.Version == FormatVersion {
+			if _, err := schema.ParseTimestamp(rerun.Timestamp); err == nil {
+				reruns = append(reruns, rerun)
+			}
+		}
+	}
+	return reruns
+}
+
+// PendingReruns returns the rerun requests that the given agent has not yet
+// responded to, which is to say that none of the given reports from that
+// agent were triggered by them.
+//
+// If the agent is empty, then a request is only considered handled once a
+// report triggered by it has been posted by any agent.
+func PendingReruns(reruns []Rerun, reports []Report, agent string) ([]Rerun, error) {
+	handled := make(map[string]bool)
+	for _, report := range reports {
+		if report.Trigger != "" && (agent == "" || report.Agent == agent) {
+			handled[report.Trigger] = true
+		}
+	}
+	var pending []Rerun
+	for _, rerun := range reruns {
+		if agent != "" && !rerun.Matches(agent) {
+			continue
+		}
+		hash, err := rerun.Hash()
+		if err != nil {
+			return nil, err
+		}
+		if !handled[hash] {
+			pending = append(pending, rerun)
+		}
+	}
+	return pending, nil
+}
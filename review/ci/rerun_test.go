@@ -0,0 +1,74 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ci
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"testing"
+)
+
+func TestPendingReruns(t *testing.T) {
+	all := Rerun{Timestamp: "1", Requester: "a@example.com"}
+	jenkins := Rerun{Timestamp: "2", Requester: "a@example.com", Agent: "jenkins"}
+	allHash, err := all.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports := []Report{
+		{Timestamp: "3", Agent: "jenkins", Status: StatusRunning, Trigger: allHash},
+		{Timestamp: "4", Agent: "travis", Status: StatusSuccess},
+	}
+	reruns := []Rerun{all, jenkins}
+
+	pending, err := PendingReruns(reruns, reports, "jenkins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0] != jenkins {
+		t.Errorf("Unexpected pending reruns for jenkins: %v", pending)
+	}
+	pending, err = PendingReruns(reruns, reports, "travis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0] != all {
+		t.Errorf("Unexpected pending reruns for travis: %v", pending)
+	}
+	pending, err = PendingReruns(reruns, reports, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0] != jenkins {
+		t.Errorf("Unexpected pending reruns for any agent: %v", pending)
+	}
+}
+
+func TestParseAllValidReruns(t *testing.T) {
+	rerun := NewRerun("a@example.com", "jenkins", "flaky test")
+	note, err := rerun.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reruns := ParseAllValidReruns([]repository.Note{
+		note,
+		repository.Note(`{"timestamp": "not a number"}`),
+		repository.Note(`not json`),
+	})
+	if len(reruns) != 1 || reruns[0] != rerun {
+		t.Errorf("Unexpected reruns: %v", reruns)
+	}
+}
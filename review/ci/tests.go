@@ -0,0 +1,220 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ci
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxFailures defines the maximum number of failing tests whose details are stored in a report.
+	MaxFailures = 50
+
+	// MaxFailureMessageLength defines the maximum size (in bytes) of the message stored for a failing test.
+	MaxFailureMessageLength = 1024
+)
+
+// TestFailure represents a single failing test.
+type TestFailure struct {
+	Name string `json:"name"`
+	// Duration is the time taken by the test, in seconds.
+	Duration float64 `json:"duration,omitempty"`
+	// Message is an optional excerpt of the reason for the failure.
+	Message string `json:"message,omitempty"`
+}
+
+// TestResults summarizes the results of the tests run by a build.
+type TestResults struct {
+	Total   int `json:"total"`
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+	// Duration is the total time taken by the tests, in seconds.
+	Duration float64 `json:"duration,omitempty"`
+	// Failures lists the failing tests, up to MaxFailures of them.
+	Failures []TestFailure `json:"failures,omitempty"`
+}
+
+// addFailure records a failing test, truncating its message and dropping it
+// entirely if the results already list MaxFailures failures.
+func (results *TestResults) addFailure(failure TestFailure) {
+	if len(results.Failures) >= MaxFailures {
+		return
+	}
+	message := strings.TrimSpace(failure.Message)
+	if len(message) > MaxFailureMessageLength {
+		message = message[:MaxFailureMessageLength]
+	}
+	failure.Message = message
+	results.Failures = append(results.Failures, failure)
+}
+
+type junitCase struct {
+	Name      string `xml:"name,attr"`
+	Classname string `xml:"classname,attr"`
+	Time      string `xml:"time,attr"`
+	Failure   *struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	} `xml:"failure"`
+	Error *struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	} `xml:"error"`
+	Skipped *struct{} `xml:"skipped"`
+}
+
+// junitSuite matches both the <testsuites> and <testsuite> elements, as they may be nested arbitrarily.
+type junitSuite struct {
+	XMLName xml.Name
+	Suites  []junitSuite `xml:"testsuite"`
+	Cases   []junitCase  `xml:"testcase"`
+}
+
+func (suite junitSuite) addTo(results *TestResults) {
+	for _, c := range suite.Cases {
+		results.Total++
+		duration, _ := strconv.ParseFloat(c.Time, 64)
+		results.Duration += duration
+		name := c.Name
+		if c.Classname != "" {
+			name = c.Classname + "." + c.Name
+		}
+		switch {
+		case c.Failure != nil:
+			results.Failed++
+			results.addFailure(TestFailure{
+				Name:     name,
+				Duration: duration,
+				Message:  firstNonEmpty(c.Failure.Message, c.Failure.Text),
+			})
+		case c.Error != nil:
+			results.Failed++
+			results.addFailure(TestFailure{
+				Name:     name,
+				Duration: duration,
+				Message:  firstNonEmpty(c.Error.Message, c.Error.Text),
+			})
+		case c.Skipped != nil:
+			results.Skipped++
+		default:
+			results.Passed++
+		}
+	}
+	for _, child := range suite.Suites {
+		child.addTo(results)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseJUnit parses a JUnit XML test report.
+func parseJUnit(contents string) (*TestResults, error) {
+	var root junitSuite
+	if err := xml.Unmarshal([]byte(contents), &root); err != nil {
+		return nil, fmt.Errorf("Failed to parse the JUnit XML report: %v", err)
+	}
+	if root.XMLName.Local != "testsuites" && root.XMLName.Local != "testsuite" {
+		return nil, fmt.Errorf("Unexpected root element %q in the JUnit XML report", root.XMLName.Local)
+	}
+	results := &TestResults{}
+	root.addTo(results)
+	return results, nil
+}
+
+// goTestEvent is a single line of the output of "go test -json".
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// parseGoTestJSON parses the output of "go test -json".
+func parseGoTestJSON(contents string) (*TestResults, error) {
+	results := &TestResults{}
+	output := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	scanner.Buffer(nil, 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var event goTestEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			return nil, fmt.Errorf("Malformed go test output on line %d: %v", lineNumber, err)
+		}
+		if event.Test == "" {
+			// Package level events only report the aggregate of their tests.
+			if event.Action == "pass" || event.Action == "fail" {
+				results.Duration += event.Elapsed
+			}
+			continue
+		}
+		name := event.Package + "." + event.Test
+		switch event.Action {
+		case "output":
+			// Skip the framing lines (e.g. "=== RUN") that go test prints around the test's own output.
+			if !strings.HasPrefix(event.Output, "=== ") {
+				output[name] += event.Output
+			}
+		case "pass":
+			results.Total++
+			results.Passed++
+		case "skip":
+			results.Total++
+			results.Skipped++
+		case "fail":
+			results.Total++
+			results.Failed++
+			results.addFailure(TestFailure{
+				Name:     name,
+				Duration: event.Elapsed,
+				Message:  output[name],
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if results.Total == 0 {
+		return nil, fmt.Errorf("No test results found in the go test output")
+	}
+	return results, nil
+}
+
+// ParseTestResults parses test results from either a JUnit XML report or the output of "go test -json".
+func ParseTestResults(contents string) (*TestResults, error) {
+	if strings.HasPrefix(strings.TrimSpace(contents), "<") {
+		return parseJUnit(contents)
+	}
+	return parseGoTestJSON(contents)
+}
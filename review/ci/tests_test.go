@@ -0,0 +1,104 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ci
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testJUnit = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="outer">
+    <testcase classname="pkg.A" name="testPass" time="0.5"/>
+    <testcase classname="pkg.A" name="testFail" time="1.25">
+      <failure message="expected 1 but was 2">stack trace</failure>
+    </testcase>
+    <testsuite name="inner">
+      <testcase classname="pkg.B" name="testError" time="0.25">
+        <error>boom</error>
+      </testcase>
+      <testcase classname="pkg.B" name="testSkip"><skipped/></testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>
+`
+
+const testGoTestJSON = `{"Action":"run","Package":"example.com/pkg","Test":"TestPass"}
+{"Action":"output","Package":"example.com/pkg","Test":"TestPass","Output":"=== RUN   TestPass\n"}
+{"Action":"pass","Package":"example.com/pkg","Test":"TestPass","Elapsed":0.1}
+{"Action":"run","Package":"example.com/pkg","Test":"TestFail"}
+{"Action":"output","Package":"example.com/pkg","Test":"TestFail","Output":"=== RUN   TestFail\n"}
+{"Action":"output","Package":"example.com/pkg","Test":"TestFail","Output":"    pkg_test.go:10: wrong answer\n"}
+{"Action":"fail","Package":"example.com/pkg","Test":"TestFail","Elapsed":0.2}
+{"Action":"skip","Package":"example.com/pkg","Test":"TestSkip","Elapsed":0}
+{"Action":"fail","Package":"example.com/pkg","Elapsed":0.5}
+`
+
+func TestParseTestResultsJUnit(t *testing.T) {
+	results, err := ParseTestResults(testJUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &TestResults{
+		Total:    4,
+		Passed:   1,
+		Failed:   2,
+		Skipped:  1,
+		Duration: 2,
+		Failures: []TestFailure{
+			{Name: "pkg.A.testFail", Duration: 1.25, Message: "expected 1 but was 2"},
+			{Name: "pkg.B.testError", Duration: 0.25, Message: "boom"},
+		},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Unexpected test results: %+v", results)
+	}
+}
+
+func TestParseTestResultsGoTestJSON(t *testing.T) {
+	results, err := ParseTestResults(testGoTestJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &TestResults{
+		Total:    3,
+		Passed:   1,
+		Failed:   1,
+		Skipped:  1,
+		Duration: 0.5,
+		Failures: []TestFailure{
+			{Name: "example.com/pkg.TestFail", Duration: 0.2, Message: "pkg_test.go:10: wrong answer"},
+		},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Unexpected test results: %+v", results)
+	}
+	if _, err := ParseTestResults("not test output"); err == nil {
+		t.Error("Expected an error for unrecognized test output")
+	}
+}
+
+func TestTestResultsMaxFailures(t *testing.T) {
+	results := &TestResults{}
+	for i := 0; i < MaxFailures+5; i++ {
+		results.addFailure(TestFailure{Name: "test"})
+	}
+	if len(results.Failures) != MaxFailures {
+		t.Errorf("Unexpected number of failures stored: %d", len(results.Failures))
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package comment
+
+import (
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"mime"
+	"net/http"
+	"path/filepath"
+)
+
+// AttachmentsRef defines the git-notes ref that keeps the blobs attached to comments reachable.
+const AttachmentsRef = "refs/notes/pullrequests/attachments"
+
+// MaxAttachmentSize is the maximum size, in bytes, of a single attachment.
+//
+// Attachments are pushed and pulled along with every other review note, so
+// they are meant for small files such as screenshots or log excerpts.
+const MaxAttachmentSize = 1 << 20
+
+// Attachment represents a file attached to a comment, and stored in the repo as a git blob.
+type Attachment struct {
+	Name        string `json:"name"`
+	Hash        string `json:"hash"`
+	Size        int    `json:"size,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// StoreAttachment stores the given file contents in the repo, and returns the corresponding attachment.
+func StoreAttachment(repo repository.Repo, name string, contents []byte) (Attachment, error) {
+	if len(contents) > MaxAttachmentSize {
+		return Attachment{}, fmt.Errorf("The attachment %q is %d bytes, which exceeds the limit of %d bytes.", name, len(contents), MaxAttachmentSize)
+	}
+	hash, err := repo.StoreBlob(AttachmentsRef, contents)
+	if err != nil {
+		return Attachment{}, err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(contents)
+	}
+	return Attachment{
+		Name:        filepath.Base(name),
+		Hash:        hash,
+		Size:        len(contents),
+		ContentType: contentType,
+	}, nil
+}
+
+// FindAttachment returns the attachment of the comment with the given hash, or nil if there is none.
+func (comment Comment) FindAttachment(hash string) *Attachment {
+	for i, attachment := range comment.Attachments {
+		if attachment.Hash == hash {
+			return &comment.Attachments[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package comment
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"strings"
+	"testing"
+)
+
+func TestStoreAttachment(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	attachment, err := StoreAttachment(repo, "/tmp/logs/build.txt", []byte("FAIL: TestParser\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.Name != "build.txt" || attachment.Size != 17 || !strings.HasPrefix(attachment.ContentType, "text/plain") {
+		t.Fatalf("Unexpected attachment: %+v", attachment)
+	}
+	contents, err := repo.ReadBlob(attachment.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "FAIL: TestParser\n" {
+		t.Fatalf("Unexpected attachment contents: %q", contents)
+	}
+	if _, err := StoreAttachment(repo, "huge.bin", make([]byte, MaxAttachmentSize+1)); err == nil {
+		t.Fatal("Failed to reject an attachment that is too large")
+	}
+}
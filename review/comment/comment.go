@@ -14,14 +14,17 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package comment defines the internal representation of a review comment.
+// Package comment defines the representation of the review comments stored in git notes.
 package comment
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/gpg"
+	"github.com/promet/git-appraise/review/schema"
 	"strconv"
 	"time"
 )
@@ -32,6 +35,37 @@ const Ref = "refs/notes/pullrequests/discuss"
 // FormatVersion defines the latest version of the comment format supported by the tool.
 const FormatVersion = 0
 
+// The condition levels of an approval; see the Level field of a Comment.
+const (
+	// LevelApproved means that the change may be submitted as is.
+	LevelApproved = "approved"
+	// LevelApprovedWithNits means that the change may be submitted once some minor issues are fixed.
+	LevelApprovedWithNits = "approved-with-nits"
+	// LevelNeedsChanges means that the change is close, but must be fixed before it is submitted.
+	LevelNeedsChanges = "needs-changes"
+)
+
+// Levels lists the condition levels of an approval, from the least to the most severe.
+var Levels = []string{LevelApproved, LevelApprovedWithNits, LevelNeedsChanges}
+
+// IsValidLevel reports whether the given string is a condition level that the tool understands.
+func IsValidLevel(level string) bool {
+	for _, l := range Levels {
+		if level == l {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolvedForLevel returns the value of the resolved bit for an approval with the given condition level.
+//
+// Changes that still need work are recorded as rejections, so that tools which
+// do not know about condition levels do not mistake them for approvals.
+func ResolvedForLevel(level string) bool {
+	return level != LevelNeedsChanges
+}
+
 // Range represents the range of text that is under discussion.
 type Range struct {
 	StartLine uint32 `json:"startLine"`
@@ -68,8 +102,39 @@ type Comment struct {
 	// has been addressed. Otherwise, the parent is the commit, and this means that the
 	// change has been accepted. If the resolved bit is unset, then the comment is only an FYI.
 	Resolved *bool `json:"resolved,omitempty"`
+	// If level is provided, then the comment is an approval with that condition
+	// level (one of the Level constants), and the resolved bit is set to match it.
+	// An approval without a level is an unconditional one.
+	Level string `json:"level,omitempty"`
+	// If suggestion is provided, then the comment proposes a change to the code under
+	// review. The suggestion is a patch in the unified diff format, relative to the
+	// root of the repository, that can be applied on top of the commented-upon commit.
+	Suggestion string `json:"suggestion,omitempty"`
+	// Attachments are small files, such as screenshots, stored in the repo as git blobs.
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// If encrypted is provided, then the comment is private, and its description and
+	// suggestion are only readable by the recipients that they were encrypted to.
+	Encrypted *Encrypted `json:"encrypted,omitempty"`
+	// If original is provided, then the comment is a revision of that other comment
+	// (on the same revision), and replaces its description and suggestion. The parent
+	// of a revision is also set to the original comment, so that tools which do not
+	// understand revisions display it as a reply.
+	Original string `json:"original,omitempty"`
+	// The deleted bit indicates that the revision retracts the original comment.
+	Deleted bool `json:"deleted,omitempty"`
+	// If redaction is provided, then the revision redacts the original comment, which
+	// has been removed from the history of the notes. Unlike other revisions, it may be
+	// written by anyone, and it replaces the original comment along with its revisions.
+	Redaction *Redaction `json:"redaction,omitempty"`
+	// Signature is an optional, ASCII-armored, detached GPG signature of the
+	// comment, computed over the serialized comment with this field left empty.
+	Signature string `json:"signature,omitempty"`
 	// Version represents the version of the metadata format.
 	Version int `json:"v,omitempty"`
+	// unknown holds the fields that the comment was parsed with, but that this version of the tool does not know about.
+	unknown map[string]json.RawMessage
+	// note is the note that the comment was parsed from, before it was upgraded, against which its signature is verified.
+	note repository.Note
 }
 
 // New returns a new comment with the given description message.
@@ -83,14 +148,64 @@ func New(author string, description string) Comment {
 	}
 }
 
+// ApprovalLevel returns the condition level of the approval that the comment records, or an
+// empty string if it does not record one, as is the case for FYI comments and plain rejections.
+func (comment Comment) ApprovalLevel() string {
+	if comment.Resolved == nil {
+		return ""
+	}
+	if comment.Level != "" {
+		return comment.Level
+	}
+	if *comment.Resolved {
+		return LevelApproved
+	}
+	return ""
+}
+
+// NewRevision returns a new revision, by the given author, of the comment with the given hash.
+func NewRevision(author, original, description string) Comment {
+	revision := New(author, description)
+	revision.Parent = original
+	revision.Original = original
+	return revision
+}
+
 // Parse parses a review comment from a git note.
 func Parse(note repository.Note) (Comment, error) {
 	bytes := []byte(note)
 	var comment Comment
 	err := json.Unmarshal(bytes, &comment)
+	comment.note = note
 	return comment, err
 }
 
+// plainComment has the same fields as Comment, but is serialized without its MarshalJSON and UnmarshalJSON methods.
+type plainComment Comment
+
+// UnmarshalJSON parses a comment, keeping any fields that this version of the tool
+// does not know about, so that they are not lost if the comment is written back out.
+func (comment *Comment) UnmarshalJSON(data []byte) error {
+	var plain plainComment
+	unknown, err := schema.Decode(data, &plain)
+	if err != nil {
+		return err
+	}
+	*comment = Comment(plain)
+	comment.unknown = unknown
+	return nil
+}
+
+// MarshalJSON serializes a comment, including any fields that it was parsed with
+// but that this version of the tool does not know about.
+func (comment Comment) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(plainComment(comment))
+	if err != nil {
+		return nil, err
+	}
+	return schema.AppendFields(data, comment.unknown)
+}
+
 // ParseAllValid takes collection of git notes and tries to parse a review
 // comment from each one. Any notes that are not valid review comments get
 // ignored, as we expect the git notes to be a heterogenous list, with only
@@ -98,13 +213,26 @@ func Parse(note repository.Note) (Comment, error) {
 func ParseAllValid(notes []repository.Note) map[string]Comment {
 	comments := make(map[string]Comment)
 	for _, note := range notes {
-		comment, err := Parse(note)
-		if err == nil && comment.Version == FormatVersion {
-			hash, err := comment.Hash()
-			if err == nil {
-				comments[hash] = comment
+		upgraded, err := schema.Upgrade(Ref, note, FormatVersion)
+		if err != nil {
+			continue
+		}
+		comment, err := Parse(upgraded)
+		if err != nil || comment.Version != FormatVersion {
+			continue
+		}
+		comment.note = note
+		// Comments are named by their hash, which must not change when they are upgraded,
+		// as replies and reactions refer to them by it.
+		original := comment
+		if !bytes.Equal(upgraded, note) {
+			if original, err = Parse(note); err != nil {
+				continue
 			}
 		}
+		if hash, err := original.Hash(); err == nil {
+			comments[hash] = comment
+		}
 	}
 	return comments
 }
@@ -135,3 +263,37 @@ func (comment Comment) Hash() (string, error) {
 	bytes, err := comment.serialize()
 	return fmt.Sprintf("%x", sha1.Sum(bytes)), err
 }
+
+// Sign signs the comment using the given GPG key, and stores the resulting signature in the comment.
+//
+// If the key is empty, then the default GPG signing key is used.
+func (comment *Comment) Sign(key string) error {
+	comment.Signature = ""
+	comment.note = nil
+	bytes, err := comment.serialize()
+	if err != nil {
+		return err
+	}
+	signature, err := gpg.Sign(key, bytes)
+	if err != nil {
+		return err
+	}
+	comment.Signature = signature
+	return nil
+}
+
+// Verify checks the signature of the comment, and returns the key that signed it.
+//
+// The signature is checked against the note that the comment was parsed from, if there is one.
+func (comment Comment) Verify() (*gpg.Signer, error) {
+	signature := comment.Signature
+	if unsigned, ok := gpg.Unsigned(comment.note, signature); ok && signature != "" {
+		return gpg.Verify(unsigned, signature)
+	}
+	comment.Signature = ""
+	bytes, err := comment.serialize()
+	if err != nil {
+		return nil, err
+	}
+	return gpg.Verify(bytes, signature)
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package comment
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/gpg"
+	"testing"
+)
+
+func TestSignedContentIsTheStoredNote(t *testing.T) {
+	// A field that this version of the tool does not know about is written back out
+	// after the known ones, so serializing the parsed comment does not reproduce the note.
+	note := repository.Note(`{"timestamp":"0000000001","author":"a","future":true,"description":"d","signature":"SIG"}`)
+	comments := ParseAllValid([]repository.Note{note})
+	if len(comments) != 1 {
+		t.Fatalf("Unexpected comments: %v", comments)
+	}
+	for _, c := range comments {
+		unsigned, ok := gpg.Unsigned(c.note, c.Signature)
+		if expected := `{"timestamp":"0000000001","author":"a","future":true,"description":"d"}`; !ok || string(unsigned) != expected {
+			t.Errorf("Unexpected signed content: %q, %v", unsigned, ok)
+		}
+		c.Signature = ""
+		if serialized, err := c.serialize(); err != nil || string(serialized) == string(unsigned) {
+			t.Errorf("Expected the serialized comment to differ from the note: %q, %v", serialized, err)
+		}
+	}
+}
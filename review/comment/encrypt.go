@@ -0,0 +1,144 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package comment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/promet/git-appraise/review/age"
+	"github.com/promet/git-appraise/review/gpg"
+)
+
+// The schemes that the body of a private comment can be encrypted with.
+const (
+	SchemeGPG = "gpg"
+	SchemeAge = "age"
+)
+
+// Encrypted holds the body of a private comment, encrypted to the keys of its recipients.
+//
+// The rest of the comment, such as its author, location, and status, stays in
+// the clear, so that tools which cannot decrypt it still know where it belongs.
+type Encrypted struct {
+	Scheme string `json:"scheme"`
+	// Recipients are the GPG user IDs or age keys that the body was encrypted to.
+	Recipients []string `json:"recipients"`
+	// Data is the ASCII-armored ciphertext of the body.
+	Data string `json:"data"`
+}
+
+// body is the part of a comment that is encrypted.
+type body struct {
+	Description string `json:"description,omitempty"`
+	Suggestion  string `json:"suggestion,omitempty"`
+}
+
+// encryptionScheme returns the scheme that can encrypt to all of the given recipients.
+func encryptionScheme(recipients []string) (string, error) {
+	if len(recipients) == 0 {
+		return "", errors.New("A private comment needs at least one recipient.")
+	}
+	ageRecipients := 0
+	for _, recipient := range recipients {
+		if age.IsRecipient(recipient) {
+			ageRecipients++
+		}
+	}
+	switch ageRecipients {
+	case 0:
+		return SchemeGPG, nil
+	case len(recipients):
+		return SchemeAge, nil
+	}
+	return "", errors.New("The recipients of a private comment must either all be age keys, or all be GPG users.")
+}
+
+// Seal encrypts the given plaintext to the given recipients, with the scheme that can encrypt to all of them.
+func Seal(recipients []string, plaintext []byte) (*Encrypted, error) {
+	scheme, err := encryptionScheme(recipients)
+	if err != nil {
+		return nil, err
+	}
+	var data string
+	if scheme == SchemeAge {
+		data, err = age.Encrypt(recipients, plaintext)
+	} else {
+		data, err = gpg.Encrypt(recipients, plaintext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Encrypted{Scheme: scheme, Recipients: recipients, Data: data}, nil
+}
+
+// Open decrypts the plaintext, using the identity in the given file if it was encrypted with age.
+func (encrypted *Encrypted) Open(ageIdentityFile string) ([]byte, error) {
+	switch encrypted.Scheme {
+	case SchemeGPG:
+		return gpg.Decrypt(encrypted.Data)
+	case SchemeAge:
+		if ageIdentityFile == "" {
+			return nil, errors.New("Decrypting needs an age identity; set appraise.ageIdentity to the path of yours.")
+		}
+		return age.Decrypt(encrypted.Data, ageIdentityFile)
+	}
+	return nil, fmt.Errorf("Unknown encryption scheme %q.", encrypted.Scheme)
+}
+
+// Encrypt encrypts the description and suggestion of the comment to the given recipients, and clears them.
+//
+// Attachments are stored as plain blobs, and so cannot be part of a private comment.
+func (comment *Comment) Encrypt(recipients []string) error {
+	if len(comment.Attachments) > 0 {
+		return errors.New("Private comments cannot have attachments.")
+	}
+	plaintext, err := json.Marshal(body{Description: comment.Description, Suggestion: comment.Suggestion})
+	if err != nil {
+		return err
+	}
+	encrypted, err := Seal(recipients, plaintext)
+	if err != nil {
+		return err
+	}
+	comment.Encrypted = encrypted
+	comment.Description = ""
+	comment.Suggestion = ""
+	return nil
+}
+
+// Decrypt fills in the description and suggestion of a private comment from its encrypted body.
+//
+// Bodies encrypted with age are decrypted using the identity in the given file.
+// This is only meant for showing the comment: once decrypted, it no longer has
+// the same hash, and so it must not be written back out.
+func (comment *Comment) Decrypt(ageIdentityFile string) error {
+	if comment.Encrypted == nil {
+		return nil
+	}
+	plaintext, err := comment.Encrypted.Open(ageIdentityFile)
+	if err != nil {
+		return err
+	}
+	var b body
+	if err := json.Unmarshal(plaintext, &b); err != nil {
+		return fmt.Errorf("Failed to parse the decrypted comment: %v", err)
+	}
+	comment.Description = b.Description
+	comment.Suggestion = b.Suggestion
+	return nil
+}
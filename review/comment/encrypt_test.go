@@ -0,0 +1,49 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package comment
+
+import (
+	"testing"
+)
+
+func TestEncryptionScheme(t *testing.T) {
+	for _, test := range []struct {
+		recipients []string
+		scheme     string
+	}{
+		{[]string{"alice@example.com", "0x1234ABCD"}, SchemeGPG},
+		{[]string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p", "ssh-ed25519 AAAAC3Nza"}, SchemeAge},
+		{[]string{"alice@example.com", "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"}, ""},
+		{nil, ""},
+	} {
+		scheme, err := encryptionScheme(test.recipients)
+		if scheme != test.scheme || (err == nil) != (test.scheme != "") {
+			t.Errorf("Unexpected scheme for %v: %q, %v", test.recipients, scheme, err)
+		}
+	}
+}
+
+func TestEncryptWithAttachments(t *testing.T) {
+	c := New("alice@example.com", "secret")
+	c.Attachments = []Attachment{{Name: "screenshot.png", Hash: "abc"}}
+	if err := c.Encrypt([]string{"alice@example.com"}); err == nil {
+		t.Error("Failed to reject a private comment with attachments")
+	}
+	if c.Description != "secret" || c.Encrypted != nil {
+		t.Errorf("The comment was changed by a failed encryption: %+v", c)
+	}
+}
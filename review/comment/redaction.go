@@ -0,0 +1,43 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package comment
+
+// Redaction records the removal of a comment that should never have been written,
+// such as one that leaked a credential.
+type Redaction struct {
+	Reason string `json:"reason,omitempty"`
+	// Comment is what is left of the redacted comment: its timestamp, parent, and location.
+	// Once the redacted comment has been removed from the notes, it takes that comment's
+	// place, so that the replies to it stay in place.
+	Comment *Comment `json:"comment"`
+}
+
+// NewRedaction returns a new revision, by the given author, that redacts the given comment with the given hash.
+func NewRedaction(author, original string, redacted Comment, reason string) Comment {
+	revision := NewRevision(author, original, "")
+	revision.Deleted = true
+	revision.Redaction = &Redaction{
+		Reason: reason,
+		Comment: &Comment{
+			Timestamp: redacted.Timestamp,
+			Parent:    redacted.Parent,
+			Location:  redacted.Location,
+			Version:   redacted.Version,
+		},
+	}
+	return revision
+}
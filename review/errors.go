@@ -0,0 +1,80 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReviewNotFound is matched, by errors.Is, by the errors for names that do not identify a review.
+var ErrReviewNotFound = errors.New("There is no matching review.")
+
+// NotFoundError is returned when a review is named by something that is not a commit.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("Could not find a commit named %q", e.Name)
+}
+
+// Is reports whether the target is ErrReviewNotFound.
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrReviewNotFound
+}
+
+// NoReviewError is returned when a commit exists, but no review has been requested for it.
+type NoReviewError struct {
+	Revision string
+}
+
+func (e *NoReviewError) Error() string {
+	return fmt.Sprintf("Could not find any review requests for %q", e.Revision)
+}
+
+// Is reports whether the target is ErrReviewNotFound.
+func (e *NoReviewError) Is(target error) bool {
+	return target == ErrReviewNotFound
+}
+
+// AmbiguousError is returned when a name matches more than one review.
+type AmbiguousError struct {
+	// Name is the revision prefix, or the ref, that was looked up.
+	Name string
+	// Ref is true if the name is a ref with several open reviews, rather than a revision prefix.
+	Ref bool
+	// ShortID is true if the name is both a short ID, given without its "#", and a revision prefix.
+	ShortID bool
+	// Matches is the number of reviews that the name matches.
+	Matches int
+}
+
+func (e *AmbiguousError) Error() string {
+	if e.Ref {
+		return fmt.Sprintf("There are %d open reviews for the ref %q.", e.Matches, e.Name)
+	}
+	if e.ShortID {
+		return fmt.Sprintf("The name %q is ambiguous; it is the short ID #%s, and a revision prefix that matches %d reviews.", e.Name, e.Name, e.Matches)
+	}
+	return fmt.Sprintf("The revision prefix %q is ambiguous; it matches %d reviews.", e.Name, e.Matches)
+}
+
+// IsNotFound reports whether the given error, or any error that it wraps, means that there is no matching review.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrReviewNotFound)
+}
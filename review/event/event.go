@@ -0,0 +1,184 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package event defines the structured records of changes to who is responsible for a review.
+package event
+
+import (
+	"encoding/json"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Ref defines the git-notes ref that we expect to contain review events.
+const Ref = "refs/notes/pullrequests/events"
+
+// FormatVersion defines the latest version of the event format supported by the tool.
+const FormatVersion = 0
+
+// The types of events.
+const (
+	// TypeTransfer means that the review was handed over to a new requester.
+	TypeTransfer = "transfer"
+	// TypeRerequest means that the review was requested again from some of its reviewers.
+	TypeRerequest = "rerequest"
+	// TypeNudge means that the reviewers of a review were reminded of it, as it is overdue for one of the objectives of the SLA.
+	TypeNudge = "nudge"
+)
+
+// Event records a change to who is responsible for a review, such as a new
+// requester, or the reviewers who are asked to look at it again.
+//
+// Events annotate the first revision in a review.
+type Event struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Author    string `json:"author,omitempty"`
+	Type      string `json:"type"`
+	// From and To are the previous and new requesters of a transferred review.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Reviewers are the people that a review was requested from again, or who were nudged about it.
+	Reviewers []string `json:"reviewers,omitempty"`
+	// SLA is the objective of the SLA that a nudged review is overdue for, such as "first-response".
+	SLA string `json:"sla,omitempty"`
+	// Commit is the head of the review when the event happened.
+	Commit  string `json:"commit,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Version represents the version of the metadata format.
+	Version int `json:"v,omitempty"`
+}
+
+// NewTransfer returns a new event, by the given author, for handing the review over from one requester to another.
+//
+// The Timestamp field is automatically filled in with the current time.
+func NewTransfer(author, from, to, commit, message string) Event {
+	return Event{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Author:    author,
+		Type:      TypeTransfer,
+		From:      from,
+		To:        to,
+		Commit:    commit,
+		Message:   message,
+	}
+}
+
+// NewRerequest returns a new event, by the given author, for requesting the review again from the given reviewers.
+//
+// The Timestamp field is automatically filled in with the current time.
+func NewRerequest(author string, reviewers []string, commit, message string) Event {
+	return Event{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Author:    author,
+		Type:      TypeRerequest,
+		Reviewers: reviewers,
+		Commit:    commit,
+		Message:   message,
+	}
+}
+
+// NewNudge returns a new event, by the given author, for reminding the given
+// people of a review that is overdue for the given objective of the SLA.
+//
+// The Timestamp field is automatically filled in with the current time.
+func NewNudge(author string, reviewers []string, commit, objective, message string) Event {
+	return Event{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Author:    author,
+		Type:      TypeNudge,
+		Reviewers: reviewers,
+		SLA:       objective,
+		Commit:    commit,
+		Message:   message,
+	}
+}
+
+// Parse parses an event from a git note.
+func Parse(note repository.Note) (Event, error) {
+	var event Event
+	err := json.Unmarshal([]byte(note), &event)
+	return event, err
+}
+
+// isValid reports whether the event has the fields required by its type.
+func (event Event) isValid() bool {
+	switch event.Type {
+	case TypeTransfer:
+		return event.To != ""
+	case TypeRerequest:
+		return len(event.Reviewers) > 0
+	case TypeNudge:
+		return event.SLA != ""
+	}
+	return false
+}
+
+// ParseAllValid takes collection of git notes and tries to parse an event
+// from each one. Any notes that are not valid events get ignored.
+//
+// The events are returned sorted by their timestamps, oldest first.
+func ParseAllValid(notes []repository.Note) []Event {
+	var events []Event
+	for _, note := range notes {
+		note, err := schema.Upgrade(Ref, note, FormatVersion)
+		if err != nil {
+			continue
+		}
+		event, err := Parse(note)
+		if err == nil && event.Version == FormatVersion && event.isValid() {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return schema.CompareTimestamps(events[i].Timestamp, events[j].Timestamp) < 0
+	})
+	return events
+}
+
+// Write writes an event as a JSON-formatted git note.
+func (event Event) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(event)
+	return repository.Note(bytes), err
+}
+
+// LatestRerequests maps each reviewer that the review was requested from again to the timestamp of the latest such request.
+func LatestRerequests(events []Event) map[string]string {
+	latest := make(map[string]string)
+	for _, event := range events {
+		if event.Type != TypeRerequest {
+			continue
+		}
+		for _, reviewer := range event.Reviewers {
+			if schema.CompareTimestamps(event.Timestamp, latest[reviewer]) >= 0 {
+				latest[reviewer] = event.Timestamp
+			}
+		}
+	}
+	return latest
+}
+
+// Nudged reports whether the given events include a nudge about the given objective of the SLA.
+func Nudged(events []Event, objective string) bool {
+	for _, event := range events {
+		if event.Type == TypeNudge && event.SLA == objective {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"testing"
+)
+
+func TestParseAllValidAndLatestRerequests(t *testing.T) {
+	events := ParseAllValid([]repository.Note{
+		repository.Note(`{"timestamp": "0000000003", "type": "rerequest", "reviewers": ["bob"]}`),
+		repository.Note(`{"timestamp": "0000000001", "type": "rerequest", "reviewers": ["alice", "bob"]}`),
+		repository.Note(`{"timestamp": "0000000002", "type": "transfer", "from": "carol", "to": "dave"}`),
+		repository.Note(`{"timestamp": "0000000004", "type": "transfer", "from": "dave"}`),
+		repository.Note(`{"timestamp": "0000000004", "type": "rerequest"}`),
+		repository.Note(`{"timestamp": "0000000004", "type": "unknown", "reviewers": ["alice"]}`),
+	})
+	if len(events) != 3 {
+		t.Fatalf("Unexpected valid events: %v", events)
+	}
+	if events[0].Timestamp != "0000000001" || events[1].Type != TypeTransfer || events[2].Timestamp != "0000000003" {
+		t.Fatalf("The events were not sorted by timestamp: %v", events)
+	}
+	latest := LatestRerequests(events)
+	if len(latest) != 2 || latest["alice"] != "0000000001" || latest["bob"] != "0000000003" {
+		t.Fatalf("Unexpected latest re-requests: %v", latest)
+	}
+}
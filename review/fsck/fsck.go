@@ -0,0 +1,308 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fsck finds, and optionally repairs, the problems that accumulate in the review notes over time.
+//
+// Notes outlive the commits they annotate when those commits are rewritten and
+// garbage collected, lines get corrupted by hand edits, and tools that post the
+// same approval twice or reply to comments that were never pushed leave behind
+// metadata that is never shown.
+package fsck
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/approval"
+	"github.com/promet/git-appraise/review/archive"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/reaction"
+	"github.com/promet/git-appraise/review/schema"
+	"github.com/promet/git-appraise/review/viewed"
+	"sort"
+	"strings"
+)
+
+// The kinds of problems found in the review notes.
+const (
+	// KindOrphaned means that the annotated object is missing from the repo.
+	KindOrphaned = "orphaned"
+	// KindMalformed means that a line of a note is not valid JSON.
+	KindMalformed = "malformed"
+	// KindDuplicateApproval means that an approval repeats an earlier one, by the same
+	// author of the same commit with the same description, and nothing refers to it.
+	KindDuplicateApproval = "duplicate-approval"
+	// KindDanglingParent means that a comment replies to a comment that does not exist,
+	// or that is itself dangling, and so is never shown.
+	KindDanglingParent = "dangling-parent"
+)
+
+// Problem is a single problem found in the review notes.
+type Problem struct {
+	Kind string `json:"kind"`
+	Ref  string `json:"ref"`
+	// Object is the annotated object.
+	Object string `json:"object"`
+	// Note is the offending line of the note, if the problem is with a single line.
+	Note        string `json:"note,omitempty"`
+	Description string `json:"description"`
+}
+
+// String returns a single-line summary of the problem.
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s %.12s: %s", p.Kind, p.Ref, p.Object, p.Description)
+}
+
+// Refs lists the notes refs that are checked, including their archive refs.
+func Refs() []string {
+	var refs []string
+	for _, ref := range archive.NotesRefs {
+		refs = append(refs, ref, archive.Ref(ref))
+	}
+	return append(refs, viewed.Ref)
+}
+
+// Check returns the problems found in the review notes of the given repo.
+func Check(repo repository.Repo) ([]Problem, error) {
+	var problems []Problem
+	for _, ref := range Refs() {
+		orphaned, err := repo.ListOrphanedNotes(ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range orphaned {
+			problems = append(problems, Problem{
+				Kind:        KindOrphaned,
+				Ref:         ref,
+				Object:      object,
+				Description: "the annotated object is missing from the repo",
+			})
+		}
+		if ref == comment.AttachmentsRef || ref == archive.Ref(comment.AttachmentsRef) {
+			// Attachments are stored verbatim, rather than as JSON.
+			continue
+		}
+		allNotes, err := repo.GetAllNotes(ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range sortedObjects(allNotes) {
+			for _, note := range allNotes[object] {
+				if strings.TrimSpace(string(note)) != "" && !json.Valid([]byte(note)) {
+					problems = append(problems, Problem{
+						Kind:        KindMalformed,
+						Ref:         ref,
+						Object:      object,
+						Note:        string(note),
+						Description: "the note is not valid JSON",
+					})
+				}
+			}
+		}
+	}
+	for _, ref := range []string{comment.Ref, archive.Ref(comment.Ref)} {
+		commentProblems, err := checkComments(repo, ref)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, commentProblems...)
+	}
+	return problems, nil
+}
+
+// parsedComment is a single comment, along with the line of the note that it was parsed from.
+type parsedComment struct {
+	Hash    string
+	Comment comment.Comment
+	Note    repository.Note
+}
+
+// parseComments parses each line of the given notes as a comment, skipping the lines that are not valid comments.
+func parseComments(notes []repository.Note) []parsedComment {
+	var comments []parsedComment
+	for _, note := range notes {
+		for hash, c := range comment.ParseAllValid([]repository.Note{note}) {
+			comments = append(comments, parsedComment{Hash: hash, Comment: c, Note: note})
+		}
+	}
+	sort.SliceStable(comments, func(i, j int) bool {
+		return schema.CompareTimestamps(comments[i].Comment.Timestamp, comments[j].Comment.Timestamp) < 0
+	})
+	return comments
+}
+
+// checkComments finds the duplicate approvals and the dangling replies in the comments under the given ref.
+//
+// Reactions and approval marks are read from the refs that correspond to the
+// comments ref, so that archived comments are checked against archived references.
+func checkComments(repo repository.Repo, ref string) ([]Problem, error) {
+	reactionsRef, approvalsRef := reaction.Ref, approval.Ref
+	if ref != comment.Ref {
+		reactionsRef, approvalsRef = archive.Ref(reaction.Ref), archive.Ref(approval.Ref)
+	}
+	allNotes, err := repo.GetAllNotes(ref)
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	for _, object := range sortedObjects(allNotes) {
+		comments := parseComments(allNotes[object])
+		hashes := make(map[string]comment.Comment)
+		referenced := make(map[string]bool)
+		for _, c := range comments {
+			hashes[c.Hash] = c.Comment
+			referenced[c.Comment.Parent] = true
+		}
+		for _, c := range comments {
+			// What is left of a redacted comment takes its place, so that the replies to it are not dangling.
+			if redaction := c.Comment.Redaction; redaction != nil && redaction.Comment != nil {
+				hashes[c.Comment.Original] = *redaction.Comment
+			}
+		}
+		for _, r := range reaction.ParseAllValid(repo.GetNotes(reactionsRef, object)) {
+			referenced[r.Comment] = true
+		}
+		for _, mark := range approval.ParseAllValid(repo.GetNotes(approvalsRef, object)) {
+			referenced[mark.Approval] = true
+		}
+
+		approvals := make(map[string]bool)
+		seen := make(map[string]bool)
+		for _, c := range comments {
+			if c.Comment.Resolved == nil || !*c.Comment.Resolved || c.Comment.Parent != "" {
+				continue
+			}
+			key := c.Comment.Author + "\x00" + approvedCommit(c.Comment, object) + "\x00" + c.Comment.Description
+			if approvals[key] && (seen[c.Hash] || !referenced[c.Hash]) {
+				problems = append(problems, Problem{
+					Kind:        KindDuplicateApproval,
+					Ref:         ref,
+					Object:      object,
+					Note:        string(c.Note),
+					Description: fmt.Sprintf("the approval %.12s by %s repeats an earlier one", c.Hash, c.Comment.Author),
+				})
+			}
+			approvals[key] = true
+			seen[c.Hash] = true
+		}
+
+		for _, c := range comments {
+			if dangling, missing := findDanglingParent(c.Comment, hashes); dangling {
+				description := fmt.Sprintf("the comment %.12s replies to %.12s, which is itself a dangling reply", c.Hash, c.Comment.Parent)
+				if missing {
+					description = fmt.Sprintf("the comment %.12s replies to %.12s, which does not exist", c.Hash, c.Comment.Parent)
+				}
+				problems = append(problems, Problem{
+					Kind:        KindDanglingParent,
+					Ref:         ref,
+					Object:      object,
+					Note:        string(c.Note),
+					Description: description,
+				})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// approvedCommit returns the commit approved by the given comment on the given review.
+func approvedCommit(c comment.Comment, revision string) string {
+	if c.Location != nil && c.Location.Commit != "" {
+		return c.Location.Commit
+	}
+	return revision
+}
+
+// findDanglingParent reports whether following the parents of the given comment fails to reach a top-level
+// comment, and if so, whether it is the comment's own parent that is missing.
+func findDanglingParent(c comment.Comment, hashes map[string]comment.Comment) (dangling, missing bool) {
+	visited := make(map[string]bool)
+	for parent := c.Parent; parent != ""; {
+		next, ok := hashes[parent]
+		if !ok || visited[parent] {
+			return true, parent == c.Parent && !ok
+		}
+		visited[parent] = true
+		parent = next.Parent
+	}
+	return false, false
+}
+
+// sortedObjects returns the annotated objects of the given notes, in a stable order.
+func sortedObjects(allNotes map[string][]repository.Note) []string {
+	var objects []string
+	for object := range allNotes {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+	return objects
+}
+
+// noteKey identifies the note annotating a single object under a notes ref.
+type noteKey struct {
+	Ref    string
+	Object string
+}
+
+// Fix repairs the given problems.
+//
+// The notes of orphaned objects are removed, as are the offending lines of the
+// other problems. Identical lines are removed only as many times as they were
+// reported, so that the first copy of a duplicated line is kept.
+func Fix(repo repository.Repo, problems []Problem) error {
+	orphaned := make(map[string][]string)
+	removed := make(map[noteKey]map[string]int)
+	var keys []noteKey
+	for _, p := range problems {
+		if p.Kind == KindOrphaned {
+			orphaned[p.Ref] = append(orphaned[p.Ref], p.Object)
+			continue
+		}
+		key := noteKey{Ref: p.Ref, Object: p.Object}
+		if removed[key] == nil {
+			removed[key] = make(map[string]int)
+			keys = append(keys, key)
+		}
+		removed[key][p.Note]++
+	}
+	for _, ref := range Refs() {
+		if err := repo.RemoveNotes(ref, orphaned[ref]); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		offending := removed[key]
+		err := repo.SetNotes(key.Ref, key.Object, func(notes []repository.Note) []repository.Note {
+			remaining := make(map[string]int)
+			for note, count := range offending {
+				remaining[note] = count
+			}
+			var kept []repository.Note
+			for _, note := range notes {
+				if remaining[string(note)] > 0 {
+					remaining[string(note)]--
+					continue
+				}
+				kept = append(kept, note)
+			}
+			return kept
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsck
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"testing"
+)
+
+func TestCheckAndFix(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	problems, err := Check(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("Unexpected problems in the mock repo: %v", problems)
+	}
+
+	repo.AppendNote(request.Ref, "Z", repository.Note(repository.TestRequestB))
+	repo.AppendNote(comment.Ref, repository.TestCommitB, repository.Note("{not json"))
+	repo.AppendNote(comment.Ref, repository.TestCommitB, repository.Note(repository.TestDiscussB))
+	repo.AppendNote(comment.Ref, repository.TestCommitB, repository.Note(`{"timestamp": "0000000002", "author": "ojarjur", "parent": "missing", "description": "reply"}`))
+	expected := map[string]bool{
+		KindOrphaned:          true,
+		KindMalformed:         true,
+		KindDuplicateApproval: true,
+		KindDanglingParent:    true,
+	}
+	problems, err = Check(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+	for _, problem := range problems {
+		if !expected[problem.Kind] {
+			t.Errorf("Unexpected problem: %v", problem)
+		}
+	}
+
+	if err := Fix(repo, problems); err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := Check(repo); err != nil || len(problems) != 0 {
+		t.Errorf("Unexpected problems after fixing them: %v, %v", problems, err)
+	}
+	if approvals := comment.ParseAllValid(repo.GetNotes(comment.Ref, repository.TestCommitB)); len(approvals) != 1 {
+		t.Errorf("The original approval was not kept: %v", approvals)
+	}
+}
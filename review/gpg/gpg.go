@@ -0,0 +1,195 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gpg contains helper methods for signing review metadata, and for
+// verifying those signatures, using the GnuPG command line tool.
+package gpg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// statusPrefix is the prefix of every line that gpg writes to its status file descriptor.
+const statusPrefix = "[GNUPG:] "
+
+// ErrUnsigned is returned when verifying metadata that does not include a signature.
+var ErrUnsigned = errors.New("The metadata is not signed.")
+
+// Signer describes the key that produced a valid signature.
+type Signer struct {
+	KeyID string `json:"keyId"`
+	User  string `json:"user"`
+	// Fingerprint is the fingerprint of the signing key.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+func (s Signer) String() string {
+	return fmt.Sprintf("%s [%s]", s.User, s.KeyID)
+}
+
+// Run gpg with the given stdin and arguments, returning its stdout and stderr.
+func runGPG(stdin []byte, args ...string) (string, string, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd := exec.Command("gpg", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.String(), strings.TrimSpace(stderr.String()), err
+}
+
+// Sign generates an ASCII-armored, detached signature of the given content.
+//
+// If the key is empty, then gpg's default signing key is used.
+func Sign(key string, content []byte) (string, error) {
+	args := []string{"--batch", "--armor", "--detach-sign"}
+	if key != "" {
+		args = append(args, "--local-user", key)
+	}
+	signature, stderr, err := runGPG(content, args...)
+	if err != nil {
+		return "", fmt.Errorf("Failed to sign the metadata: %s", stderr)
+	}
+	return signature, nil
+}
+
+// parseStatus reads the output written by gpg to its status file descriptor
+// while verifying a signature, and returns the signer if the signature is good.
+//
+// The signing key must also be trusted (fully or ultimately) in the keyring,
+// since the user ID of a key that is merely present in it is whatever its
+// owner chose, and so says nothing about who made the signature.
+func parseStatus(status string) (*Signer, error) {
+	var signer *Signer
+	var fingerprint string
+	trusted := false
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, statusPrefix) {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimPrefix(line, statusPrefix), " ", 3)
+		switch fields[0] {
+		case "GOODSIG":
+			if len(fields) == 3 {
+				signer = &Signer{KeyID: fields[1], User: fields[2]}
+			}
+		case "VALIDSIG":
+			if len(fields) > 1 {
+				fingerprint = fields[1]
+			}
+		case "TRUST_FULLY", "TRUST_ULTIMATE":
+			trusted = true
+		case "BADSIG":
+			return nil, errors.New("The signature is not valid for the metadata.")
+		case "ERRSIG", "NO_PUBKEY":
+			return nil, errors.New("The signature could not be checked; the signing key may be missing from the keyring.")
+		case "EXPKEYSIG", "REVKEYSIG":
+			return nil, fmt.Errorf("The signature was made by an expired or revoked key: %s", strings.Join(fields[1:], " "))
+		}
+	}
+	if signer == nil || fingerprint == "" {
+		return nil, errors.New("The signature could not be verified.")
+	}
+	if !trusted {
+		return nil, fmt.Errorf("The signature was made by the key %s of %s, which is not trusted; certify the key with gpg to trust it.", fingerprint, signer.User)
+	}
+	signer.Fingerprint = fingerprint
+	return signer, nil
+}
+
+// Unsigned returns the given JSON-serialized metadata without the "signature" field holding the
+// given signature, which is what the signature was computed over, and reports whether it had one.
+//
+// This lets a signature be checked against the metadata exactly as it was stored, rather than
+// against a serialization of its parsed form, which changes when the metadata is upgraded
+// to a newer format as it is read.
+func Unsigned(content []byte, signature string) ([]byte, bool) {
+	value, err := json.Marshal(signature)
+	if err != nil {
+		return nil, false
+	}
+	field := append([]byte(`"signature":`), value...)
+	for _, member := range [][]byte{
+		append([]byte(","), field...),
+		append(append([]byte(nil), field...), ','),
+		field,
+	} {
+		if i := bytes.Index(content, member); i >= 0 {
+			unsigned := append([]byte(nil), content[:i]...)
+			return append(unsigned, content[i+len(member):]...), true
+		}
+	}
+	return nil, false
+}
+
+// Verify checks that the given detached signature is a valid signature of the given content.
+func Verify(content []byte, signature string) (*Signer, error) {
+	if signature == "" {
+		return nil, ErrUnsigned
+	}
+	signatureFile, err := ioutil.TempFile("", "appraise-signature")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(signatureFile.Name())
+	if _, err := signatureFile.WriteString(signature); err != nil {
+		signatureFile.Close()
+		return nil, err
+	}
+	if err := signatureFile.Close(); err != nil {
+		return nil, err
+	}
+	// The exit code of gpg is ignored, as the status output describes the failure in more detail.
+	status, _, _ := runGPG(content, "--batch", "--status-fd", "1", "--verify", signatureFile.Name(), "-")
+	return parseStatus(status)
+}
+
+// Encrypt encrypts the given content to the keys of the given recipients, returning the ASCII-armored ciphertext.
+//
+// Recipients may be named by anything that gpg accepts, such as their email
+// addresses or key IDs. Their keys are trusted as they are in the keyring,
+// since the recipients are chosen explicitly.
+func Encrypt(recipients []string, content []byte) (string, error) {
+	args := []string{"--batch", "--armor", "--encrypt", "--trust-model", "always"}
+	for _, recipient := range recipients {
+		args = append(args, "--recipient", recipient)
+	}
+	ciphertext, stderr, err := runGPG(content, args...)
+	if err != nil {
+		return "", fmt.Errorf("Failed to encrypt the metadata: %s", stderr)
+	}
+	return ciphertext, nil
+}
+
+// Decrypt decrypts the given ASCII-armored ciphertext with one of the secret keys in the keyring.
+func Decrypt(ciphertext string) ([]byte, error) {
+	content, stderr, err := runGPG([]byte(ciphertext), "--batch", "--quiet", "--decrypt")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt the metadata: %s", stderr)
+	}
+	return []byte(content), nil
+}
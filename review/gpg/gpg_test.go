@@ -0,0 +1,82 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gpg
+
+import (
+	"testing"
+)
+
+const (
+	goodSigStatus = `[GNUPG:] NEWSIG
+[GNUPG:] KEY_CONSIDERED 0123456789ABCDEF0123456789ABCDEF01234567 0
+[GNUPG:] SIG_ID abcdefghijklmnopqrstuvwxyz0 2016-05-01 1462060800
+[GNUPG:] GOODSIG 89ABCDEF01234567 Reviewer <reviewer@example.com>
+[GNUPG:] VALIDSIG 0123456789ABCDEF0123456789ABCDEF01234567 2016-05-01 1462060800 0 4 0 1 8 00 0123456789ABCDEF0123456789ABCDEF01234567
+[GNUPG:] TRUST_ULTIMATE 0 pgp
+`
+	untrustedSigStatus = `[GNUPG:] NEWSIG
+[GNUPG:] GOODSIG 89ABCDEF01234567 Reviewer <reviewer@example.com>
+[GNUPG:] VALIDSIG 0123456789ABCDEF0123456789ABCDEF01234567 2016-05-01 1462060800 0 4 0 1 8 00 0123456789ABCDEF0123456789ABCDEF01234567
+[GNUPG:] TRUST_UNDEFINED 0 pgp
+`
+	badSigStatus = `[GNUPG:] NEWSIG
+[GNUPG:] BADSIG 89ABCDEF01234567 Reviewer <reviewer@example.com>
+`
+	missingKeyStatus = `[GNUPG:] NEWSIG
+[GNUPG:] ERRSIG 89ABCDEF01234567 1 8 00 1462060800 9 -
+[GNUPG:] NO_PUBKEY 89ABCDEF01234567
+`
+)
+
+func TestParseStatus(t *testing.T) {
+	signer, err := parseStatus(goodSigStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.KeyID != "89ABCDEF01234567" || signer.User != "Reviewer <reviewer@example.com>" || signer.Fingerprint != "0123456789ABCDEF0123456789ABCDEF01234567" {
+		t.Fatalf("Unexpected signer: %v", signer)
+	}
+	if _, err := parseStatus(untrustedSigStatus); err == nil {
+		t.Fatal("Failed to reject a signature from an untrusted key")
+	}
+	if _, err := parseStatus(badSigStatus); err == nil {
+		t.Fatal("Failed to reject a bad signature")
+	}
+	if _, err := parseStatus(missingKeyStatus); err == nil {
+		t.Fatal("Failed to reject a signature from an unknown key")
+	}
+	if _, err := parseStatus(""); err == nil {
+		t.Fatal("Failed to reject an empty status")
+	}
+}
+
+func TestUnsigned(t *testing.T) {
+	for content, expected := range map[string]string{
+		`{"author":"a","signature":"SIG","v":1}`: `{"author":"a","v":1}`,
+		`{"author":"a","signature":"SIG"}`:       `{"author":"a"}`,
+		`{"signature":"SIG","author":"a"}`:       `{"author":"a"}`,
+		`{"signature":"SIG"}`:                    `{}`,
+	} {
+		unsigned, ok := Unsigned([]byte(content), "SIG")
+		if !ok || string(unsigned) != expected {
+			t.Errorf("Unexpected unsigned content of %q: %q, %v", content, unsigned, ok)
+		}
+	}
+	if _, ok := Unsigned([]byte(`{"author":"a","signature":"OTHER"}`), "SIG"); ok {
+		t.Error("Removed a different signature")
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package identity maps the different identities used by one person onto a single canonical one.
+//
+// The mapping is read from a file in a format similar to git's mailmap. Each
+// non-comment line lists the canonical identity of a person followed by their
+// aliases. Identities may be written in angle brackets, in which case any text
+// outside of the brackets (such as a name) is ignored:
+//
+//	Alice <alice@example.com> <alice@old.example.com> Alice J <ajones@example.com>
+//	bob@example.com bob bobby@example.com
+//
+// Identities are compared case-insensitively.
+package identity
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"strings"
+)
+
+// File is the path of the file from which the identity mapping is read.
+const File = ".appraise/identities"
+
+// Map resolves aliases to canonical identities.
+//
+// A nil *Map is valid, and maps every identity to itself.
+type Map struct {
+	canonical map[string]string
+}
+
+// parseLine returns the identities listed on a single line of an identities file.
+func parseLine(line string) []string {
+	if !strings.Contains(line, "<") {
+		return strings.Fields(line)
+	}
+	var identities []string
+	for {
+		start := strings.Index(line, "<")
+		if start < 0 {
+			return identities
+		}
+		end := strings.Index(line[start:], ">")
+		if end < 0 {
+			return identities
+		}
+		if identity := strings.TrimSpace(line[start+1 : start+end]); identity != "" {
+			identities = append(identities, identity)
+		}
+		line = line[start+end+1:]
+	}
+}
+
+// Parse parses the contents of an identities file.
+func Parse(contents string) (*Map, error) {
+	m := &Map{canonical: make(map[string]string)}
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		identities := parseLine(line)
+		if len(identities) == 0 {
+			continue
+		}
+		canonical := identities[0]
+		for _, identity := range identities {
+			key := strings.ToLower(identity)
+			if existing, ok := m.canonical[key]; ok && existing != canonical {
+				return nil, fmt.Errorf("The identity %q on line %d is already an alias of %q.", identity, lineNumber, existing)
+			}
+			m.canonical[key] = canonical
+		}
+	}
+	return m, scanner.Err()
+}
+
+// Load reads the identity mapping from the given commit.
+//
+// If the commit does not contain an identities file, then this returns nil.
+func Load(repo repository.Repo, commit string) (*Map, error) {
+	contents, err := repo.Show(commit, File)
+	if err != nil {
+		return nil, nil
+	}
+	m, err := Parse(contents)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %q: %v", File, err)
+	}
+	return m, nil
+}
+
+// Resolve returns the canonical identity of the given one.
+func (m *Map) Resolve(identity string) string {
+	if m == nil {
+		return identity
+	}
+	if canonical, ok := m.canonical[strings.ToLower(identity)]; ok {
+		return canonical
+	}
+	return identity
+}
+
+// Same reports whether the two given identities belong to the same person.
+func (m *Map) Same(a, b string) bool {
+	return strings.EqualFold(m.Resolve(a), m.Resolve(b))
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identity
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	m, err := Parse(`# The canonical identity comes first.
+Alice <alice@example.com> <alice@old.example.com> Alice J <AJones@example.com>
+bob@example.com bob   # Trailing comment
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for alias, expected := range map[string]string{
+		"alice@example.com":     "alice@example.com",
+		"alice@old.example.com": "alice@example.com",
+		"ajones@example.com":    "alice@example.com",
+		"BOB":                   "bob@example.com",
+		"carol@example.com":     "carol@example.com",
+	} {
+		if canonical := m.Resolve(alias); canonical != expected {
+			t.Errorf("Unexpected canonical identity for %q: %q", alias, canonical)
+		}
+	}
+	if !m.Same("bob", "Bob@Example.com") || m.Same("bob", "alice@example.com") {
+		t.Error("Failed to compare identities")
+	}
+
+	var unmapped *Map
+	if unmapped.Resolve("bob") != "bob" || !unmapped.Same("bob", "BOB") {
+		t.Error("A nil map should map every identity to itself")
+	}
+	if _, err := Parse("alice@example.com shared@example.com\nbob@example.com shared@example.com\n"); err == nil {
+		t.Error("Failed to reject an alias of two different identities")
+	}
+}
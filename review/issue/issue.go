@@ -0,0 +1,162 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package issue expands the issue IDs linked from a review into URLs in an issue tracker.
+//
+// Issue trackers are configured with the multi-valued "appraise.issueTracker"
+// setting, each value of which takes one of the forms:
+//
+//	jira <base-url>                 e.g. "jira https://jira.example.com", for IDs like "PROJ-123"
+//	github <owner>/<repo>           for IDs like "#123" or "owner/repo#123"
+//	pattern <regexp> <url-template> for any other tracker, e.g. "pattern ^BUG(\d+)$ https://bugs.example.com/$1"
+//
+// IDs that are already URLs are linked to as is.
+package issue
+
+import (
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"regexp"
+	"strings"
+)
+
+// Tracker converts issue IDs into URLs.
+type Tracker interface {
+	// Link returns the URL of the issue with the given ID, or false if the ID is not for this tracker.
+	Link(id string) (string, bool)
+}
+
+// Issue is an issue ID along with its URL, if any tracker recognizes it.
+type Issue struct {
+	ID  string `json:"id"`
+	URL string `json:"url,omitempty"`
+}
+
+// patternTracker links to every ID that matches a regular expression, by expanding a template with the submatches.
+type patternTracker struct {
+	pattern  *regexp.Regexp
+	template string
+}
+
+// Link implements the Tracker interface.
+func (t patternTracker) Link(id string) (string, bool) {
+	match := t.pattern.FindStringSubmatchIndex(id)
+	if match == nil {
+		return "", false
+	}
+	return string(t.pattern.ExpandString(nil, t.template, id, match)), true
+}
+
+var jiraPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*-[0-9]+$`)
+
+var gitHubPattern = regexp.MustCompile(`^([\w.-]+/[\w.-]+)?#([0-9]+)$`)
+
+var urlPattern = regexp.MustCompile(`^https?://\S+$`)
+
+// NewJIRA returns a Tracker for JIRA issue keys, in the JIRA instance at the given URL.
+func NewJIRA(baseURL string) Tracker {
+	return patternTracker{jiraPattern, strings.TrimSuffix(baseURL, "/") + "/browse/$0"}
+}
+
+// gitHubTracker links to GitHub issues, defaulting to a single repository.
+type gitHubTracker struct {
+	repo string
+}
+
+// Link implements the Tracker interface.
+func (t gitHubTracker) Link(id string) (string, bool) {
+	match := gitHubPattern.FindStringSubmatch(id)
+	if match == nil {
+		return "", false
+	}
+	repo := match[1]
+	if repo == "" {
+		repo = t.repo
+	}
+	return fmt.Sprintf("https://github.com/%s/issues/%s", repo, match[2]), true
+}
+
+// NewGitHub returns a Tracker for the issues of the given GitHub repository (of the form "owner/repo").
+func NewGitHub(repo string) Tracker {
+	return gitHubTracker{repo}
+}
+
+// NewPattern returns a Tracker for IDs that match the given regular expression.
+//
+// The URL is built by expanding the template, in which "$0" is the whole ID
+// and "$1" and so on are the submatches of the expression.
+func NewPattern(pattern, template string) (Tracker, error) {
+	matcher, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return patternTracker{matcher, template}, nil
+}
+
+// Parse parses a single issue tracker configuration value.
+func Parse(spec string) (Tracker, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("Empty issue tracker configuration")
+	}
+	switch {
+	case fields[0] == "jira" && len(fields) == 2:
+		return NewJIRA(fields[1]), nil
+	case fields[0] == "github" && len(fields) == 2:
+		return NewGitHub(fields[1]), nil
+	case fields[0] == "pattern" && len(fields) == 3:
+		return NewPattern(fields[1], fields[2])
+	}
+	return nil, fmt.Errorf("Malformed issue tracker configuration %q", spec)
+}
+
+// LoadTrackers returns the issue trackers configured for the given repo.
+func LoadTrackers(repo repository.Repo) ([]Tracker, error) {
+	specs, err := repo.GetIssueTrackers()
+	if err != nil {
+		return nil, err
+	}
+	var trackers []Tracker
+	for _, spec := range specs {
+		tracker, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		trackers = append(trackers, tracker)
+	}
+	return trackers, nil
+}
+
+// Expand returns the given issue IDs along with the URL of each, from the first tracker that recognizes it.
+func Expand(trackers []Tracker, ids []string) []Issue {
+	var issues []Issue
+	for _, id := range ids {
+		issue := Issue{ID: id}
+		if urlPattern.MatchString(id) {
+			issue.URL = id
+		}
+		for _, tracker := range trackers {
+			if issue.URL != "" {
+				break
+			}
+			if url, ok := tracker.Link(id); ok {
+				issue.URL = url
+			}
+		}
+		issues = append(issues, issue)
+	}
+	return issues
+}
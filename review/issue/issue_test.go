@@ -0,0 +1,57 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package issue
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	var trackers []Tracker
+	for _, spec := range []string{
+		"jira https://jira.example.com/",
+		"github owner/repo",
+		`pattern ^BUG([0-9]+)$ https://bugs.example.com/show?id=$1`,
+	} {
+		tracker, err := Parse(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trackers = append(trackers, tracker)
+	}
+	issues := Expand(trackers, []string{"PROJ-12", "#3", "other/project#4", "BUG56", "https://example.com/7", "unknown"})
+	expected := []Issue{
+		{ID: "PROJ-12", URL: "https://jira.example.com/browse/PROJ-12"},
+		{ID: "#3", URL: "https://github.com/owner/repo/issues/3"},
+		{ID: "other/project#4", URL: "https://github.com/other/project/issues/4"},
+		{ID: "BUG56", URL: "https://bugs.example.com/show?id=56"},
+		{ID: "https://example.com/7", URL: "https://example.com/7"},
+		{ID: "unknown"},
+	}
+	if !reflect.DeepEqual(issues, expected) {
+		t.Errorf("Unexpected issues: %+v", issues)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "jira", "bugzilla https://bugs.example.com", "pattern ( $0"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Failed to reject the tracker configuration %q", spec)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package namespace keeps the reviews of one project in a monorepo under notes refs of its own.
+//
+// By default, the review notes are kept under "refs/notes/pullrequests/". A
+// namespace is another prefix, such as "refs/notes/devtools/teamA/", under
+// which the same notes refs are kept instead, so that independent teams can
+// push and pull their reviews without touching those of the other teams.
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"strings"
+)
+
+// DefaultPrefix is the prefix of the notes refs that hold the reviews when no namespace is selected.
+const DefaultPrefix = "refs/notes/pullrequests/"
+
+const notesPrefix = "refs/notes/"
+
+// ParsePrefix returns the prefix of the notes refs of the given namespace.
+//
+// The namespace is either a ref under "refs/notes/", or a path relative to it,
+// so "devtools/teamA" and "refs/notes/devtools/teamA" name the same namespace.
+// A namespace may neither contain the default one nor be contained by it, since
+// then pushing or pulling the reviews of one would also include the other.
+func ParsePrefix(namespace string) (string, error) {
+	prefix := strings.TrimSuffix(namespace, "/") + "/"
+	if !strings.HasPrefix(prefix, "refs/") {
+		prefix = notesPrefix + prefix
+	}
+	if !strings.HasPrefix(prefix, notesPrefix) || prefix == notesPrefix {
+		return "", fmt.Errorf("Invalid notes namespace %q; expected a ref under %q.", namespace, notesPrefix)
+	}
+	for _, component := range strings.Split(strings.TrimSuffix(prefix, "/"), "/") {
+		if component == "" || component == "." || component == ".." || strings.ContainsAny(component, " ~^:?*[\\") {
+			return "", fmt.Errorf("Invalid notes namespace %q; it is not a valid ref name.", namespace)
+		}
+	}
+	if prefix != DefaultPrefix && (strings.HasPrefix(prefix, DefaultPrefix) || strings.HasPrefix(DefaultPrefix, prefix)) {
+		return "", fmt.Errorf("The notes namespace %q overlaps with the default one, %q.", namespace, DefaultPrefix)
+	}
+	return prefix, nil
+}
+
+// Ref returns the ref that takes the place of the given notes ref in the namespace with the given prefix.
+//
+// Refs outside of "refs/notes/pullrequests/", such as the local record of
+// viewed files, are shared by every namespace and are returned unchanged.
+func Ref(prefix, notesRef string) string {
+	if !strings.HasPrefix(notesRef, DefaultPrefix) {
+		return notesRef
+	}
+	return prefix + strings.TrimPrefix(notesRef, DefaultPrefix)
+}
+
+// namespacedRepo is a view of a repo that reads and writes the notes of a namespace.
+type namespacedRepo struct {
+	repository.Repo
+	prefix string
+}
+
+// NewRepo returns a view of the given repo in which the notes refs of the
+// namespace with the given prefix take the place of the default ones.
+//
+// The prefix must be one returned by ParsePrefix. For the default prefix,
+// the repo itself is returned.
+func NewRepo(repo repository.Repo, prefix string) repository.Repo {
+	if prefix == DefaultPrefix {
+		return repo
+	}
+	return &namespacedRepo{repo, prefix}
+}
+
+func (repo *namespacedRepo) ref(notesRef string) string {
+	return Ref(repo.prefix, notesRef)
+}
+
+// WithContext returns a copy of the view whose operations are abandoned once the given context is done.
+func (repo *namespacedRepo) WithContext(ctx context.Context) repository.Repo {
+	return &namespacedRepo{repo.Repo.WithContext(ctx), repo.prefix}
+}
+
+// WithAuthor returns a copy of the view whose writes are attributed to the given identity.
+func (repo *namespacedRepo) WithAuthor(name, email string) repository.Repo {
+	return &namespacedRepo{repo.Repo.WithAuthor(name, email), repo.prefix}
+}
+
+// VerifyGitRef verifies that the given ref, or the notes ref taking its place, points to a known commit.
+func (repo *namespacedRepo) VerifyGitRef(ref string) error {
+	return repo.Repo.VerifyGitRef(repo.ref(ref))
+}
+
+// GetCommitHash returns the hash of the commit pointed to by the given ref, or by the notes ref taking its place.
+func (repo *namespacedRepo) GetCommitHash(ref string) (string, error) {
+	return repo.Repo.GetCommitHash(repo.ref(ref))
+}
+
+// SetRef points the given ref, or the notes ref taking its place, at the given commit.
+func (repo *namespacedRepo) SetRef(ref, commit string) error {
+	return repo.Repo.SetRef(repo.ref(ref), commit)
+}
+
+// GetNotes reads the notes of the namespace that annotate the given revision.
+func (repo *namespacedRepo) GetNotes(notesRef, revision string) []repository.Note {
+	return repo.Repo.GetNotes(repo.ref(notesRef), revision)
+}
+
+// GetAllNotes reads the notes of the namespace for every commit.
+func (repo *namespacedRepo) GetAllNotes(notesRef string) (map[string][]repository.Note, error) {
+	return repo.Repo.GetAllNotes(repo.ref(notesRef))
+}
+
+// AppendNote appends a note to a revision under the notes ref of the namespace.
+func (repo *namespacedRepo) AppendNote(notesRef, revision string, note repository.Note) error {
+	return repo.Repo.AppendNote(repo.ref(notesRef), revision, note)
+}
+
+// AppendNotes appends each of the given notes to the revision that it is keyed by, under the notes ref of the namespace.
+func (repo *namespacedRepo) AppendNotes(notesRef string, notes map[string][]repository.Note) error {
+	return repo.Repo.AppendNotes(repo.ref(notesRef), notes)
+}
+
+// SetNotes replaces the notes of the namespace that annotate a revision.
+func (repo *namespacedRepo) SetNotes(notesRef, revision string, update func(notes []repository.Note) []repository.Note) error {
+	return repo.Repo.SetNotes(repo.ref(notesRef), revision, update)
+}
+
+// RewriteNotes replaces the notes of the namespace that annotate a revision, rewriting the history of its notes ref.
+func (repo *namespacedRepo) RewriteNotes(notesRef, revision string, update func(notes []repository.Note) []repository.Note, message string) error {
+	return repo.Repo.RewriteNotes(repo.ref(notesRef), revision, update, message)
+}
+
+// MoveNotes moves the notes annotating the given objects between two notes refs of the namespace.
+func (repo *namespacedRepo) MoveNotes(fromRef, toRef string, objects []string) error {
+	return repo.Repo.MoveNotes(repo.ref(fromRef), repo.ref(toRef), objects)
+}
+
+// RemapNotes moves the notes of the namespace from each object in the mapping to the object that it maps to.
+func (repo *namespacedRepo) RemapNotes(notesRef string, mapping map[string]string) ([]string, error) {
+	return repo.Repo.RemapNotes(repo.ref(notesRef), mapping)
+}
+
+// RemoveNotes removes the notes of the namespace that annotate the given objects.
+func (repo *namespacedRepo) RemoveNotes(notesRef string, objects []string) error {
+	return repo.Repo.RemoveNotes(repo.ref(notesRef), objects)
+}
+
+// ListOrphanedNotes returns the objects annotated by notes of the namespace that are missing from the repo.
+func (repo *namespacedRepo) ListOrphanedNotes(notesRef string) ([]string, error) {
+	return repo.Repo.ListOrphanedNotes(repo.ref(notesRef))
+}
+
+// StoreBlob writes the given contents to the repo as a blob, keeping it reachable from the notes ref of the namespace.
+func (repo *namespacedRepo) StoreBlob(notesRef string, contents []byte) (string, error) {
+	return repo.Repo.StoreBlob(repo.ref(notesRef), contents)
+}
+
+// ListNotedRevisions returns the revisions that are annotated by notes of the namespace.
+func (repo *namespacedRepo) ListNotedRevisions(notesRef string) []string {
+	return repo.Repo.ListNotedRevisions(repo.ref(notesRef))
+}
+
+// PushNotes pushes the notes refs of the namespace to a remote repo.
+func (repo *namespacedRepo) PushNotes(remote, notesRefPattern string) error {
+	return repo.Repo.PushNotes(remote, repo.ref(notesRefPattern))
+}
+
+// PushRefs pushes the given refs, or the notes refs of the namespace taking their places, to a remote repo.
+func (repo *namespacedRepo) PushRefs(remote string, force bool, refs ...string) error {
+	var mapped []string
+	for _, ref := range refs {
+		mapped = append(mapped, repo.ref(ref))
+	}
+	return repo.Repo.PushRefs(remote, force, mapped...)
+}
+
+// PushRefWithLease pushes the given ref, or the notes ref of the namespace taking its place, to a remote repo.
+func (repo *namespacedRepo) PushRefWithLease(remote, ref, expected string) error {
+	return repo.Repo.PushRefWithLease(remote, repo.ref(ref), expected)
+}
+
+// PullNotes fetches the notes refs of the namespace from a remote repo, and merges them with the local ones.
+func (repo *namespacedRepo) PullNotes(remote, notesRefPattern string) error {
+	return repo.Repo.PullNotes(remote, repo.ref(notesRefPattern))
+}
+
+// PushNotesAndArchive pushes the notes refs of the namespace, and the given archive refs, to a remote repo.
+func (repo *namespacedRepo) PushNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return repo.Repo.PushNotesAndArchive(remote, repo.ref(notesRefPattern), archiveRefPattern)
+}
+
+// PullNotesAndArchive fetches the notes refs of the namespace, and the given archive refs, from a remote repo,
+// and merges them with the local ones.
+func (repo *namespacedRepo) PullNotesAndArchive(remote, notesRefPattern, archiveRefPattern string) error {
+	return repo.Repo.PullNotesAndArchive(remote, repo.ref(notesRefPattern), archiveRefPattern)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"github.com/promet/git-appraise/review/viewed"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	for namespace, expected := range map[string]string{
+		"devtools/teamA":             "refs/notes/devtools/teamA/",
+		"refs/notes/devtools/teamA/": "refs/notes/devtools/teamA/",
+		"pullrequests":               DefaultPrefix,
+	} {
+		if prefix, err := ParsePrefix(namespace); err != nil || prefix != expected {
+			t.Errorf("Unexpected prefix for %q: %q, %v", namespace, prefix, err)
+		}
+	}
+	for _, namespace := range []string{"", "refs/heads/teamA", "refs/notes", "team A", "teams//a", "pullrequests/teamA"} {
+		if prefix, err := ParsePrefix(namespace); err == nil {
+			t.Errorf("Failed to reject the namespace %q: %q", namespace, prefix)
+		}
+	}
+}
+
+func TestRef(t *testing.T) {
+	const prefix = "refs/notes/devtools/teamA/"
+	if ref := Ref(prefix, comment.Ref); ref != "refs/notes/devtools/teamA/discuss" {
+		t.Errorf("Unexpected namespaced ref for %q: %q", comment.Ref, ref)
+	}
+	if ref := Ref(prefix, viewed.Ref); ref != viewed.Ref {
+		t.Errorf("Refs outside of the pullrequests namespace should be shared, but got %q", ref)
+	}
+	if ref := Ref(DefaultPrefix, comment.Ref); ref != comment.Ref {
+		t.Errorf("Unexpected ref in the default namespace: %q", ref)
+	}
+}
+
+func TestNewRepo(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	if NewRepo(repo, DefaultPrefix) != repo {
+		t.Fatal("The default namespace should not wrap the repo")
+	}
+	const prefix = "refs/notes/devtools/teamA/"
+	teamRepo := NewRepo(repo, prefix)
+	if reviews := review.ListAll(teamRepo); len(reviews) != 0 {
+		t.Fatalf("Unexpected reviews in an empty namespace: %v", reviews)
+	}
+	r := request.New("teamA@example.com", nil, "", "refs/heads/master", "Team A change")
+	note, err := r.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := teamRepo.AppendNote(request.Ref, repository.TestCommitE, note); err != nil {
+		t.Fatal(err)
+	}
+	if reviews := review.ListAll(teamRepo); len(reviews) != 1 || reviews[0].Revision != repository.TestCommitE {
+		t.Fatalf("Unexpected reviews in the namespace: %v", reviews)
+	}
+	if notes := repo.GetNotes(prefix+"reviews", repository.TestCommitE); len(request.ParseAllValid(notes)) != 1 {
+		t.Fatalf("The request was not written under the namespaced ref: %q", notes)
+	}
+	for _, listed := range review.ListAll(repo) {
+		if listed.Revision == repository.TestCommitE {
+			t.Fatal("The review of the namespace is listed in the default one")
+		}
+	}
+}
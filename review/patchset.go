@@ -0,0 +1,136 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/request"
+	"github.com/promet/git-appraise/review/schema"
+	"strconv"
+	"time"
+)
+
+// RecordPatchset records the current head of the review as a new patchset.
+//
+// If the head has not changed since the latest patchset, then nothing is
+// recorded and nil is returned. The head commit is archived, so that the
+// patchset can still be compared against after the review branch is rebased.
+func (r *Review) RecordPatchset() (*request.Patchset, error) {
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	if n := len(r.Request.Patchsets); n > 0 && r.Request.Patchsets[n-1].Commit == head {
+		return nil, nil
+	}
+	base, err := r.GetBaseCommit()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Repo.ArchiveRef(head, archiveRef); err != nil {
+		return nil, err
+	}
+	patchset := request.Patchset{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Commit:    head,
+		Base:      base,
+	}
+	r.Request.Patchsets = append(r.Request.Patchsets, patchset)
+	r.Request.Timestamp = patchset.Timestamp
+	r.Request.Signature = ""
+	note, err := r.Request.Write()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Repo.AppendNote(request.Ref, r.Revision, note); err != nil {
+		return nil, err
+	}
+	return &patchset, nil
+}
+
+// KeepCommit archives the given commit, so that it can still be reviewed after the ref that it is on is deleted or rewritten.
+func KeepCommit(repo repository.Repo, commit string) error {
+	return repo.ArchiveRef(commit, archiveRef)
+}
+
+// GetPatchset returns the patchset with the given number, counting from 1.
+func (r *Summary) GetPatchset(number int) (*request.Patchset, error) {
+	if number < 1 || number > len(r.Request.Patchsets) {
+		return nil, fmt.Errorf("There is no patchset %d; the review has %d patchset(s).", number, len(r.Request.Patchsets))
+	}
+	return &r.Request.Patchsets[number-1], nil
+}
+
+// LastReviewedPatchset returns the number of the latest patchset that the
+// given user had seen when they last commented on the review, or 0 if they
+// have not commented on it.
+func (r *Summary) LastReviewedPatchset(user string) int {
+	var lastComment string
+	var visit func(threads []CommentThread)
+	visit = func(threads []CommentThread) {
+		for _, thread := range threads {
+			if thread.Comment.Author == user && schema.CompareTimestamps(thread.Comment.Timestamp, lastComment) > 0 {
+				lastComment = thread.Comment.Timestamp
+			}
+			visit(thread.Children)
+		}
+	}
+	visit(r.Comments)
+	if lastComment == "" {
+		return 0
+	}
+	number := 0
+	for i, patchset := range r.Request.Patchsets {
+		if schema.CompareTimestamps(patchset.Timestamp, lastComment) <= 0 {
+			number = i + 1
+		}
+	}
+	return number
+}
+
+// GetPatchsetDiff returns the diff between the given patchset and the current head of the review.
+//
+// If the review branch has been rebased since the patchset, then the changes
+// made by the patchset are first reapplied on top of the current base of the
+// review, so that the diff does not include any unrelated changes from the
+// target ref. If that is not possible, then the returned boolean is false and
+// the diff is taken directly between the patchset and the current head.
+func (r *Review) GetPatchsetDiff(patchset request.Patchset, diffArgs ...string) (string, bool, error) {
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return "", false, err
+	}
+	if isAncestor, err := r.Repo.IsAncestor(patchset.Commit, head); err != nil || isAncestor || patchset.Base == "" {
+		diff, err := r.Repo.Diff(patchset.Commit, head, diffArgs...)
+		return diff, err == nil, err
+	}
+	base, err := r.GetBaseCommit()
+	if err != nil {
+		return "", false, err
+	}
+	patch, err := r.Repo.Diff(patchset.Base, patchset.Commit, "--binary", "--full-index", "--no-color")
+	if err != nil {
+		return "", false, err
+	}
+	if tree, err := r.Repo.ApplyPatchToTree(base, patch+"\n"); err == nil {
+		diff, err := r.Repo.Diff(tree, head, diffArgs...)
+		return diff, err == nil, err
+	}
+	diff, err := r.Repo.Diff(patchset.Commit, head, diffArgs...)
+	return diff, false, err
+}
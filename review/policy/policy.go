@@ -0,0 +1,561 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package policy computes which reviewers must approve a review before it can be submitted.
+//
+// Policies are read from a file in the CODEOWNERS format: each non-comment
+// line holds a path pattern followed by the owners of the matching paths.
+// When multiple patterns match a path, the last one wins. A review is
+// approved once, for every changed path that has owners, at least one of
+// those owners has accepted the review.
+//
+// Which approvals count is set by the "appraise.approvalLevels" git config
+// setting, which lists the condition levels of the approvals that count; by
+// default, approvals with nits count as well as unconditional ones.
+//
+// If the "appraise.staleApprovals" setting is true, then approvals only count
+// for the revision that they approved, unless they are explicitly carried
+// forward to a later one.
+//
+// While an owner is away, the delegate that they named may approve in their place.
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/approval"
+	"github.com/promet/git-appraise/review/away"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/schema"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Files lists the paths, in order of precedence, from which a policy is read.
+var Files = []string{
+	".appraise/policy",
+	"CODEOWNERS",
+	".github/CODEOWNERS",
+	"docs/CODEOWNERS",
+}
+
+// LevelsConfig is the git config setting that lists the condition levels of the approvals that count.
+const LevelsConfig = "appraise.approvalLevels"
+
+// StaleConfig is the git config setting that makes approvals stale once a new revision of the review is pushed.
+const StaleConfig = "appraise.staleApprovals"
+
+// DefaultLevels are the condition levels of the approvals that count, if none are configured.
+var DefaultLevels = []string{comment.LevelApproved, comment.LevelApprovedWithNits}
+
+// Rule assigns a set of owners to the paths matching a pattern.
+type Rule struct {
+	Pattern string
+	Owners  []string
+	matcher *regexp.Regexp
+}
+
+// Policy is an ordered list of rules.
+type Policy struct {
+	// File is the path from which the policy was read.
+	File  string
+	Rules []Rule
+	// Identities maps the aliases of owners and approvers to their canonical identities.
+	Identities *identity.Map
+	// Levels are the condition levels of the approvals that count; if empty, then DefaultLevels are used.
+	Levels []string
+	// StaleApprovals indicates that approvals of earlier revisions do not count.
+	StaleApprovals bool
+	// Away holds the statuses of the owners who are away, whose delegates may approve in their place.
+	Away away.Statuses
+}
+
+// Requirement describes a changed path that still needs the approval of one of its owners.
+type Requirement struct {
+	Path   string   `json:"path"`
+	Owners []string `json:"owners"`
+}
+
+// compilePattern converts a CODEOWNERS path pattern into an equivalent regular expression.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.HasPrefix(pattern, "/") || strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	glob := strings.TrimSuffix(strings.TrimPrefix(pattern, "/"), "/")
+	var expr []byte
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					// "**/" matches zero or more directories.
+					i++
+					expr = append(expr, "(?:.*/)?"...)
+				} else {
+					expr = append(expr, ".*"...)
+				}
+			} else {
+				expr = append(expr, "[^/]*"...)
+			}
+		case '?':
+			expr = append(expr, "[^/]"...)
+		default:
+			expr = append(expr, regexp.QuoteMeta(string(c))...)
+		}
+	}
+	prefix := "^(?:.*/)?"
+	if anchored {
+		prefix = "^"
+	}
+	return regexp.Compile(prefix + string(expr) + "(?:/.*)?$")
+}
+
+// Parse parses the contents of a policy file.
+func Parse(contents string) (*Policy, error) {
+	policy := &Policy{}
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		matcher, err := compilePattern(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q on line %d: %v", fields[0], lineNumber, err)
+		}
+		policy.Rules = append(policy.Rules, Rule{
+			Pattern: fields[0],
+			Owners:  fields[1:],
+			matcher: matcher,
+		})
+	}
+	return policy, scanner.Err()
+}
+
+// Load reads the policy that applies to changes merged into the given commit.
+//
+// The policy is read from the commit being merged into, rather than from the
+// review itself, so that a review cannot loosen the policy that applies to it.
+// If none of the policy files exist, then this returns nil. The identity
+// mapping is read from the same commit, for the same reason.
+func Load(repo repository.Repo, commit string) (*Policy, error) {
+	for _, file := range Files {
+		contents, err := repo.Show(commit, file)
+		if err != nil {
+			continue
+		}
+		policy, err := Parse(contents)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse %q: %v", file, err)
+		}
+		policy.File = file
+		policy.Identities, err = identity.Load(repo, commit)
+		if err != nil {
+			return nil, err
+		}
+		policy.Levels, err = LoadLevels(repo)
+		if err != nil {
+			return nil, err
+		}
+		policy.StaleApprovals, err = StaleApprovalsEnabled(repo)
+		if err != nil {
+			return nil, err
+		}
+		policy.Away = away.Load(repo)
+		return policy, nil
+	}
+	return nil, nil
+}
+
+// LoadLevels returns the condition levels of the approvals that count in the given repo.
+//
+// Approvals that need changes are recorded as rejections, and so can never count.
+func LoadLevels(repo repository.Repo) ([]string, error) {
+	values, err := repo.GetConfig(LevelsConfig)
+	if err != nil {
+		return nil, err
+	}
+	var levels []string
+	for _, value := range values {
+		for _, level := range strings.Split(value, ",") {
+			level = strings.TrimSpace(level)
+			if level == "" {
+				continue
+			}
+			if !comment.IsValidLevel(level) || !comment.ResolvedForLevel(level) {
+				return nil, fmt.Errorf("Invalid value %q for %s; expected a list of %s or %s.", level, LevelsConfig, comment.LevelApproved, comment.LevelApprovedWithNits)
+			}
+			levels = append(levels, level)
+		}
+	}
+	if len(levels) == 0 {
+		return DefaultLevels, nil
+	}
+	return levels, nil
+}
+
+// StaleApprovalsEnabled reports whether approvals become stale in the given repo once a new revision of a review is pushed.
+func StaleApprovalsEnabled(repo repository.Repo) (bool, error) {
+	values, err := repo.GetConfig(StaleConfig)
+	if err != nil || len(values) == 0 {
+		return false, err
+	}
+	enabled, err := strconv.ParseBool(values[len(values)-1])
+	if err != nil {
+		return false, fmt.Errorf("Invalid value %q for %s; expected true or false.", values[len(values)-1], StaleConfig)
+	}
+	return enabled, nil
+}
+
+// Owners returns the owners of the given path, as defined by the last matching rule.
+func (p *Policy) Owners(path string) []string {
+	for i := len(p.Rules) - 1; i >= 0; i-- {
+		if p.Rules[i].matcher.MatchString(path) {
+			return p.Rules[i].Owners
+		}
+	}
+	return nil
+}
+
+// resolution is an approval or rejection of a review by a single reviewer.
+type resolution struct {
+	// Hash is the hash of the thread of the comment that resolved the review.
+	Hash      string
+	Author    string
+	Timestamp int64
+	// Level is the condition level of an approval, or empty for a rejection.
+	Level string
+}
+
+func collectResolutions(threads []review.CommentThread, identities *identity.Map, resolutions []resolution) []resolution {
+	for _, thread := range threads {
+		if thread.Comment.Resolved != nil {
+			var timestamp int64
+			if t, err := schema.ParseTimestamp(thread.Comment.Timestamp); err == nil {
+				timestamp = t.Unix()
+			}
+			resolutions = append(resolutions, resolution{
+				Hash:      thread.Hash,
+				Author:    identities.Resolve(thread.Comment.Author),
+				Timestamp: timestamp,
+				Level:     thread.Comment.ApprovalLevel(),
+			})
+		}
+		resolutions = collectResolutions(thread.Children, identities, resolutions)
+	}
+	return resolutions
+}
+
+// LatestLevels maps everyone who resolved the review, including its requester,
+// to the condition level of their latest resolution of it.
+//
+// People are identified by their canonical identities, and those whose latest
+// resolution was a rejection, or one of the given stale approvals, are mapped
+// to an empty string.
+func LatestLevels(r *review.Review, identities *identity.Map, stale map[string]bool) map[string]string {
+	resolutions := collectResolutions(r.Comments, identities, nil)
+	sort.SliceStable(resolutions, func(i, j int) bool {
+		return resolutions[i].Timestamp < resolutions[j].Timestamp
+	})
+	levels := make(map[string]string)
+	for _, resolution := range resolutions {
+		levels[resolution.Author] = resolution.Level
+		if stale[resolution.Hash] {
+			levels[resolution.Author] = ""
+		}
+	}
+	return levels
+}
+
+// Approvers returns the set of reviewers whose latest resolution of the review was to accept it,
+// with one of the DefaultLevels.
+//
+// Reviewers are identified by their canonical identities, and the requester
+// of a review is never counted as one of its approvers.
+func Approvers(r *review.Review, identities *identity.Map) map[string]bool {
+	return ApproversAtLevels(r, identities, DefaultLevels, nil)
+}
+
+// ApproversAtLevels returns the set of reviewers whose latest resolution of the review was to accept it,
+// with one of the given condition levels, other than with one of the given stale approvals.
+func ApproversAtLevels(r *review.Review, identities *identity.Map, levels []string, stale map[string]bool) map[string]bool {
+	approvers := make(map[string]bool)
+	for author, level := range LatestLevels(r, identities, stale) {
+		if containsLevel(levels, level) && !identities.Same(author, r.Request.Requester) {
+			approvers[author] = true
+		}
+	}
+	return approvers
+}
+
+// containsLevel reports whether the given condition level is one of the given levels.
+func containsLevel(levels []string, level string) bool {
+	for _, l := range levels {
+		if level != "" && l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// approvals returns the comment threads of the review that record an approval of it.
+func approvals(threads []review.CommentThread, result []review.CommentThread) []review.CommentThread {
+	for _, thread := range threads {
+		if thread.Comment.ApprovalLevel() != "" {
+			result = append(result, thread)
+		}
+		result = approvals(thread.Children, result)
+	}
+	return result
+}
+
+// Stale returns the hashes of the approvals of the review that no longer count, because they
+// approved an earlier revision and have not been carried forward to its current head.
+//
+// This does not check whether stale approvals are enabled; see StaleApprovalsEnabled.
+func Stale(r *review.Review) (map[string]bool, error) {
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	threads := approvals(r.Comments, nil)
+	authors := make(map[string]string)
+	for _, thread := range threads {
+		authors[thread.Hash] = thread.Comment.Author
+	}
+	// Only the author of an approval may carry it forward, so the marks of anyone else that carry it forward are ignored.
+	var marks []approval.Mark
+	for _, mark := range approval.ParseAllValid(r.Repo.GetNotes(approval.Ref, r.Revision)) {
+		if mark.Status != approval.StatusCarriedForward || mark.Author == authors[mark.Approval] {
+			marks = append(marks, mark)
+		}
+	}
+	latest := approval.Latest(marks)
+	stale := make(map[string]bool)
+	for _, thread := range threads {
+		var approvedCommit string
+		if thread.Comment.Location != nil {
+			approvedCommit = thread.Comment.Location.Commit
+		}
+		var mark *approval.Mark
+		if m, ok := latest[thread.Hash]; ok {
+			mark = &m
+		}
+		if approval.IsStale(approvedCommit, mark, head) {
+			stale[thread.Hash] = true
+		}
+	}
+	return stale, nil
+}
+
+// LoadStale returns the stale approvals of the review, or nil if stale approvals are not enabled; see Stale.
+func LoadStale(r *review.Review) (map[string]bool, error) {
+	if enabled, err := StaleApprovalsEnabled(r.Repo); err != nil || !enabled {
+		return nil, err
+	}
+	return Stale(r)
+}
+
+// MarkStale records that the approvals of earlier revisions of the review are stale, if stale approvals are enabled.
+//
+// Approvals that have already been marked as stale are not marked again. This
+// returns the hashes of the approvals that were marked.
+func MarkStale(r *review.Review, author string) ([]string, error) {
+	if enabled, err := StaleApprovalsEnabled(r.Repo); err != nil || !enabled {
+		return nil, err
+	}
+	return writeMarks(r, author, approval.StatusStale, nil)
+}
+
+// CarryForward records that the given stale approvals of the review, or all of those given by the author
+// if none are given, count for its current head.
+//
+// Only the author of an approval may carry it forward; see Stale. This returns
+// the hashes of the approvals that were carried forward.
+func CarryForward(r *review.Review, author string, hashes []string) ([]string, error) {
+	threads := approvals(r.Comments, nil)
+	if len(hashes) == 0 {
+		stale, err := Stale(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, thread := range threads {
+			if stale[thread.Hash] && thread.Comment.Author == author {
+				hashes = append(hashes, thread.Hash)
+			}
+		}
+		if len(hashes) == 0 {
+			return nil, nil
+		}
+		sort.Strings(hashes)
+	}
+	for _, hash := range hashes {
+		for _, thread := range threads {
+			if thread.Hash == hash && thread.Comment.Author != author {
+				return nil, fmt.Errorf("Only %s, who gave the approval %q, can carry it forward.", thread.Comment.Author, hash)
+			}
+		}
+	}
+	return writeMarks(r, author, approval.StatusCarriedForward, hashes)
+}
+
+// writeMarks marks the given stale approvals of the review, or all of them if none are given, with the given status.
+func writeMarks(r *review.Review, author, status string, hashes []string) ([]string, error) {
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	stale, err := Stale(r)
+	if err != nil {
+		return nil, err
+	}
+	latest := approval.Latest(approval.ParseAllValid(r.Repo.GetNotes(approval.Ref, r.Revision)))
+	if len(hashes) == 0 {
+		for hash := range stale {
+			hashes = append(hashes, hash)
+		}
+		sort.Strings(hashes)
+	}
+	var marked []string
+	var notes []repository.Note
+	for _, hash := range hashes {
+		if !stale[hash] {
+			return nil, fmt.Errorf("There is no stale approval %q of the review.", hash)
+		}
+		if mark, ok := latest[hash]; ok && mark.Status == approval.StatusStale && status == approval.StatusStale {
+			continue
+		}
+		note, err := approval.New(author, hash, head, status).Write()
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+		marked = append(marked, hash)
+	}
+	for _, note := range notes {
+		if err := r.Repo.AppendNote(approval.Ref, r.Revision, note); err != nil {
+			return nil, err
+		}
+	}
+	return marked, nil
+}
+
+// ChangedPaths returns the paths of the files modified by the given review.
+func ChangedPaths(r *review.Review) ([]string, error) {
+	diff, err := r.GetDiff("--name-only")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, line := range strings.Split(diff, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, nil
+}
+
+// stewards returns the given owners, followed by the delegates of those of them who are away.
+func (p *Policy) stewards(owners []string) []string {
+	now := time.Now()
+	stewards := append([]string(nil), owners...)
+	for _, owner := range owners {
+		if delegate := p.Away.Delegate(owner, now); delegate != "" {
+			stewards = append(stewards, delegate)
+		}
+	}
+	return stewards
+}
+
+// Unsatisfied returns the requirements of the policy that the given review does not yet meet.
+func (p *Policy) Unsatisfied(r *review.Review) ([]Requirement, error) {
+	paths, err := ChangedPaths(r)
+	if err != nil {
+		return nil, err
+	}
+	levels := p.Levels
+	if len(levels) == 0 {
+		levels = DefaultLevels
+	}
+	var stale map[string]bool
+	if p.StaleApprovals {
+		if stale, err = Stale(r); err != nil {
+			return nil, err
+		}
+	}
+	approvers := ApproversAtLevels(r, p.Identities, levels, stale)
+	var requirements []Requirement
+	for _, path := range paths {
+		owners := p.stewards(p.Owners(path))
+		if len(owners) == 0 {
+			continue
+		}
+		approved := false
+		for _, owner := range owners {
+			if approvers[p.Identities.Resolve(owner)] {
+				approved = true
+			}
+		}
+		if !approved {
+			requirements = append(requirements, Requirement{Path: path, Owners: owners})
+		}
+	}
+	return requirements, nil
+}
+
+// MayApprove reports whether the given author is permitted to approve a change to the given paths.
+//
+// Anyone may approve a change that touches no owned paths; otherwise, the
+// author must own at least one of the changed paths that have owners.
+func (p *Policy) MayApprove(author string, paths []string) bool {
+	owned := false
+	for _, path := range paths {
+		owners := p.Owners(path)
+		if len(owners) > 0 {
+			owned = true
+		}
+		for _, owner := range p.stewards(owners) {
+			if p.Identities.Same(owner, author) {
+				return true
+			}
+		}
+	}
+	return !owned
+}
+
+// Check loads the policy that applies to the given review, and returns the requirements that it does not yet meet.
+func Check(r *review.Review) ([]Requirement, error) {
+	target, err := r.Repo.ResolveRefCommit(r.Request.TargetRef)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Load(r.Repo, target)
+	if err != nil || p == nil {
+		return nil, err
+	}
+	return p.Unsatisfied(r)
+}
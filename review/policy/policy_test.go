@@ -0,0 +1,225 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/approval"
+	"github.com/promet/git-appraise/review/away"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/request"
+	"reflect"
+	"testing"
+)
+
+const testPolicy = `# Default owners
+*                 lead@example.com
+
+*.md              docs@example.com writer@example.com
+/commands/        cli@example.com
+review/**/ci.go   ci@example.com
+vendor/
+`
+
+func TestOwners(t *testing.T) {
+	p, err := Parse(testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"main.go":              {"lead@example.com"},
+		"docs/README.md":       {"docs@example.com", "writer@example.com"},
+		"commands/submit.go":   {"cli@example.com"},
+		"other/commands/x.go":  {"lead@example.com"},
+		"review/ci/ci.go":      {"ci@example.com"},
+		"review/ci.go":         {"ci@example.com"},
+		"vendor/lib/lib.go":    nil,
+		"thirdparty/vendor/go": nil,
+	}
+	for path, owners := range expected {
+		if actual := p.Owners(path); !reflect.DeepEqual(actual, owners) && (len(actual) != 0 || len(owners) != 0) {
+			t.Errorf("Unexpected owners for %q: %v", path, actual)
+		}
+	}
+}
+
+func newResolution(author, timestamp string, accepted bool) review.CommentThread {
+	c := comment.New(author, "")
+	c.Timestamp = timestamp
+	c.Resolved = &accepted
+	return review.CommentThread{Comment: c}
+}
+
+func TestApprovers(t *testing.T) {
+	r := &review.Review{Summary: &review.Summary{
+		Request: request.Request{Requester: "author@example.com"},
+		Comments: []review.CommentThread{
+			newResolution("author@example.com", "1", true),
+			newResolution("lead@example.com", "3", true),
+			newResolution("cli@example.com", "2", true),
+			newResolution("cli@example.com", "4", false),
+		},
+	}}
+	r.Comments[1].Children = []review.CommentThread{newResolution("docs@example.com", "5", true)}
+	approvers := Approvers(r, nil)
+	if !reflect.DeepEqual(approvers, map[string]bool{"lead@example.com": true, "docs@example.com": true}) {
+		t.Fatalf("Unexpected approvers: %v", approvers)
+	}
+}
+
+func TestApproversWithIdentities(t *testing.T) {
+	identities, err := identity.Parse("<author@example.com> <author@old.example.com>\n<lead@example.com> <lead>\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &review.Review{Summary: &review.Summary{
+		Request: request.Request{Requester: "author@example.com"},
+		Comments: []review.CommentThread{
+			newResolution("author@old.example.com", "1", true),
+			newResolution("lead", "2", true),
+		},
+	}}
+	approvers := Approvers(r, identities)
+	if !reflect.DeepEqual(approvers, map[string]bool{"lead@example.com": true}) {
+		t.Fatalf("Unexpected approvers: %v", approvers)
+	}
+}
+
+func TestApproversAtLevels(t *testing.T) {
+	nits := newResolution("lead@example.com", "2", true)
+	nits.Comment.Level = comment.LevelApprovedWithNits
+	changes := newResolution("docs@example.com", "3", false)
+	changes.Comment.Level = comment.LevelNeedsChanges
+	r := &review.Review{Summary: &review.Summary{
+		Request: request.Request{Requester: "author@example.com"},
+		Comments: []review.CommentThread{
+			newResolution("cli@example.com", "1", true),
+			nits,
+			newResolution("docs@example.com", "2", true),
+			changes,
+		},
+	}}
+	if approvers := Approvers(r, nil); !reflect.DeepEqual(approvers, map[string]bool{"cli@example.com": true, "lead@example.com": true}) {
+		t.Fatalf("Unexpected approvers at the default levels: %v", approvers)
+	}
+	if approvers := ApproversAtLevels(r, nil, []string{comment.LevelApproved}, nil); !reflect.DeepEqual(approvers, map[string]bool{"cli@example.com": true}) {
+		t.Fatalf("Unexpected unconditional approvers: %v", approvers)
+	}
+	expected := map[string]string{
+		"cli@example.com":  comment.LevelApproved,
+		"lead@example.com": comment.LevelApprovedWithNits,
+		"docs@example.com": comment.LevelNeedsChanges,
+	}
+	if levels := LatestLevels(r, nil, nil); !reflect.DeepEqual(levels, expected) {
+		t.Fatalf("Unexpected latest levels: %v", levels)
+	}
+}
+
+func TestMayApprove(t *testing.T) {
+	p, err := Parse(testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Identities, err = identity.Parse("<cli@example.com> <cli@old.example.com>\n"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Author   string
+		Paths    []string
+		Expected bool
+	}{
+		{"cli@example.com", []string{"commands/submit.go"}, true},
+		{"cli@old.example.com", []string{"commands/submit.go"}, true},
+		{"docs@example.com", []string{"commands/submit.go"}, false},
+		{"docs@example.com", []string{"commands/submit.go", "README.md"}, true},
+		{"anyone@example.com", []string{"vendor/lib/lib.go"}, true},
+		{"anyone@example.com", nil, true},
+		{"deputy@example.com", []string{"commands/submit.go"}, false},
+	}
+	for _, test := range tests {
+		if actual := p.MayApprove(test.Author, test.Paths); actual != test.Expected {
+			t.Errorf("Unexpected result for %q approving %v: %v", test.Author, test.Paths, actual)
+		}
+	}
+
+	p.Away = away.Latest([]away.Status{{User: "cli@example.com", Delegate: "deputy@example.com"}})
+	if !p.MayApprove("deputy@example.com", []string{"commands/submit.go"}) {
+		t.Error("The delegate of an owner who is away may not approve in their place")
+	}
+	if p.MayApprove("deputy@example.com", []string{"README.md"}) {
+		t.Error("The delegate of an owner who is away may approve changes that the owner does not own")
+	}
+	if owners := p.Owners("commands/submit.go"); !reflect.DeepEqual(owners, []string{"cli@example.com"}) {
+		t.Errorf("Delegating changed the owners of a path: %v", owners)
+	}
+}
+
+func TestCarryForwardOnlyByApprovalAuthors(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	r, err := review.Get(repo, repository.TestCommitB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approved := true
+	hashes := make(map[string]string)
+	for _, author := range []string{"alice@example.com", "bob@example.com"} {
+		c := comment.New(author, "LGTM")
+		c.Resolved = &approved
+		c.Location = &comment.Location{Commit: repository.TestCommitA}
+		if err := r.AddComment(c); err != nil {
+			t.Fatal(err)
+		}
+		if hashes[author], err = c.Hash(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r, err = review.Get(repo, repository.TestCommitB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CarryForward(r, r.Request.Requester, []string{hashes["bob@example.com"]}); err == nil {
+		t.Fatal("Carried forward the approval of someone else")
+	}
+	carried, err := CarryForward(r, "alice@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(carried, []string{hashes["alice@example.com"]}) {
+		t.Fatalf("Unexpected approvals carried forward: %v", carried)
+	}
+
+	// A mark that carries forward someone else's approval, written without CarryForward, is ignored.
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := approval.New(r.Request.Requester, hashes["bob@example.com"], head, approval.StatusCarriedForward).Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AppendNote(approval.Ref, r.Revision, forged); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := Stale(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stale, map[string]bool{hashes["bob@example.com"]: true}) {
+		t.Errorf("Unexpected stale approvals: %v", stale)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reaction defines the internal representation of reactions to review comments.
+package reaction
+
+import (
+	"encoding/json"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Ref defines the git-notes ref that we expect to contain reactions.
+const Ref = "refs/notes/pullrequests/reactions"
+
+// FormatVersion defines the latest version of the reaction format supported by the tool.
+const FormatVersion = 0
+
+// Aliases maps the names of common reactions to the corresponding emoji.
+var Aliases = map[string]string{
+	"+1":       "\U0001F44D",
+	"-1":       "\U0001F44E",
+	"eyes":     "\U0001F440",
+	"heart":    "\u2764\uFE0F",
+	"laugh":    "\U0001F604",
+	"tada":     "\U0001F389",
+	"confused": "\U0001F615",
+	"rocket":   "\U0001F680",
+}
+
+// Reaction represents a lightweight acknowledgement of a review comment, such as an emoji.
+//
+// Reactions annotate the first revision in a review, like the comments they react to.
+type Reaction struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Author    string `json:"author,omitempty"`
+	// Comment is the hash of the comment being reacted to.
+	Comment  string `json:"comment"`
+	Reaction string `json:"reaction"`
+	// Removed indicates that the author has withdrawn an earlier, matching reaction.
+	Removed bool `json:"removed,omitempty"`
+	// Version represents the version of the metadata format.
+	Version int `json:"v,omitempty"`
+}
+
+// New returns a new reaction by the given author to the given comment.
+//
+// The Timestamp field is automatically filled in with the current time, and
+// the reaction is replaced by its emoji if it is one of the known aliases.
+func New(author, commentHash, reaction string) Reaction {
+	if emoji, ok := Aliases[reaction]; ok {
+		reaction = emoji
+	}
+	return Reaction{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Author:    author,
+		Comment:   commentHash,
+		Reaction:  reaction,
+	}
+}
+
+// Parse parses a reaction from a git note.
+func Parse(note repository.Note) (Reaction, error) {
+	var reaction Reaction
+	err := json.Unmarshal([]byte(note), &reaction)
+	return reaction, err
+}
+
+// ParseAllValid takes collection of git notes and tries to parse a reaction
+// from each one. Any notes that are not valid reactions get ignored.
+func ParseAllValid(notes []repository.Note) []Reaction {
+	var reactions []Reaction
+	for _, note := range notes {
+		note, err := schema.Upgrade(Ref, note, FormatVersion)
+		if err != nil {
+			continue
+		}
+		reaction, err := Parse(note)
+		if err == nil && reaction.Version == FormatVersion && reaction.Comment != "" && reaction.Reaction != "" {
+			reactions = append(reactions, reaction)
+		}
+	}
+	return reactions
+}
+
+// Write writes a reaction as a JSON-formatted git note.
+func (reaction Reaction) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(reaction)
+	return repository.Note(bytes), err
+}
+
+// Count represents everyone who has given the same reaction to a comment.
+type Count struct {
+	Reaction string   `json:"reaction"`
+	Authors  []string `json:"authors"`
+}
+
+type byTimestamp []Reaction
+
+func (r byTimestamp) Len() int      { return len(r) }
+func (r byTimestamp) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byTimestamp) Less(i, j int) bool {
+	return schema.CompareTimestamps(r[i].Timestamp, r[j].Timestamp) < 0
+}
+
+// Aggregate groups the given reactions by the comment they react to.
+//
+// For each author, only their latest reaction of each kind to a comment
+// counts, so a reaction can be withdrawn by a later one marked as removed.
+// The counts for each comment are ordered with the most popular first.
+func Aggregate(reactions []Reaction) map[string][]Count {
+	sorted := append([]Reaction(nil), reactions...)
+	sort.Stable(byTimestamp(sorted))
+	type key struct{ comment, author, reaction string }
+	latest := make(map[key]Reaction)
+	var order []key
+	for _, reaction := range sorted {
+		k := key{reaction.Comment, reaction.Author, reaction.Reaction}
+		if _, ok := latest[k]; !ok {
+			order = append(order, k)
+		}
+		latest[k] = reaction
+	}
+
+	counts := make(map[string][]Count)
+	for _, k := range order {
+		if latest[k].Removed {
+			continue
+		}
+		commentCounts := counts[k.comment]
+		found := false
+		for i := range commentCounts {
+			if commentCounts[i].Reaction == k.reaction {
+				commentCounts[i].Authors = append(commentCounts[i].Authors, k.author)
+				found = true
+			}
+		}
+		if !found {
+			commentCounts = append(commentCounts, Count{Reaction: k.reaction, Authors: []string{k.author}})
+		}
+		counts[k.comment] = commentCounts
+	}
+	for _, commentCounts := range counts {
+		sort.SliceStable(commentCounts, func(i, j int) bool {
+			return len(commentCounts[i].Authors) > len(commentCounts[j].Authors)
+		})
+	}
+	return counts
+}
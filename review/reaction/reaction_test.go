@@ -0,0 +1,55 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reaction
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"reflect"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	notes := []repository.Note{
+		repository.Note(`{"timestamp": "0000000001", "author": "alice", "comment": "c1", "reaction": "+1"}`),
+		repository.Note(`{"timestamp": "0000000002", "author": "bob", "comment": "c1", "reaction": "eyes"}`),
+		repository.Note(`{"timestamp": "0000000003", "author": "carol", "comment": "c1", "reaction": "eyes"}`),
+		repository.Note(`{"timestamp": "0000000004", "author": "alice", "comment": "c2", "reaction": "+1"}`),
+		repository.Note(`{"timestamp": "0000000005", "author": "alice", "comment": "c2", "reaction": "+1", "removed": true}`),
+		repository.Note(`{"timestamp": "0000000006", "author": "alice", "comment": "c1", "reaction": "+1"}`),
+		repository.Note(`{"timestamp": "0000000007", "author": "bob", "comment": "c3"}`),
+		repository.Note(`not a reaction`),
+	}
+	counts := Aggregate(ParseAllValid(notes))
+	expected := map[string][]Count{
+		"c1": {
+			{Reaction: "eyes", Authors: []string{"bob", "carol"}},
+			{Reaction: "+1", Authors: []string{"alice"}},
+		},
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("Unexpected reactions: %+v", counts)
+	}
+}
+
+func TestNewResolvesAliases(t *testing.T) {
+	if r := New("alice", "c1", "+1"); r.Reaction != "\U0001F44D" {
+		t.Fatalf("Failed to resolve an alias: %q", r.Reaction)
+	}
+	if r := New("alice", "c1", "\U0001F680"); r.Reaction != "\U0001F680" {
+		t.Fatalf("Unexpectedly changed an emoji: %q", r.Reaction)
+	}
+}
@@ -14,12 +14,14 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package request defines the internal representation of a review request.
+// Package request defines the representation of the review requests stored in git notes.
 package request
 
 import (
 	"encoding/json"
 	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/gpg"
+	"github.com/promet/git-appraise/review/schema"
 	"strconv"
 	"time"
 )
@@ -53,6 +55,48 @@ type Request struct {
 	// Alias stores a post-rebase commit ID for the review. This allows the tool
 	// to track the history of a review even if the commit history changes.
 	Alias string `json:"alias,omitempty"`
+	// DependsOn stores the revision of another review that must be submitted
+	// before this one. This allows a chain of small reviews to be stacked on
+	// top of each other.
+	DependsOn string `json:"dependsOn,omitempty"`
+	// Draft indicates that the review is still a work in progress, and so
+	// is not yet ready to be looked at by anyone other than the requester.
+	Draft bool `json:"draft,omitempty"`
+	// Labels are arbitrary tags used to categorize the review (e.g. "backend" or "urgent").
+	Labels []string `json:"labels,omitempty"`
+	// Issues are the IDs (or URLs) of the issues in an issue tracker that the review addresses.
+	Issues []string `json:"issues,omitempty"`
+	// BackportOf stores the revision of the review that this one cherry-picks
+	// onto another target ref, such as a release branch.
+	BackportOf string `json:"backportOf,omitempty"`
+	// Backports stores the revisions of the reviews that cherry-pick this one
+	// onto other target refs; it is the reverse of BackportOf.
+	Backports []string `json:"backports,omitempty"`
+	// Patchsets records each revision of the review branch that has been
+	// published for review, in the order that they were published.
+	Patchsets []Patchset `json:"patchsets,omitempty"`
+	// Blind lists the administrators (GPG users or age keys) of a blind review,
+	// to whom alone the identities behind the pseudonyms of its commenters are
+	// revealed. The review is blind if and only if this is not empty.
+	Blind []string `json:"blind,omitempty"`
+	// Signature is an optional, ASCII-armored, detached GPG signature of the
+	// request, computed over the serialized request with this field left empty.
+	Signature string `json:"signature,omitempty"`
+	// unknown holds the fields that the request was parsed with, but that this version of the tool does not know about.
+	unknown map[string]json.RawMessage
+	// note is the note that the request was parsed from, before it was upgraded, against which its signature is verified.
+	note repository.Note
+}
+
+// Patchset represents a single revision of the review branch that was published for review.
+//
+// Patchsets are numbered starting from 1, in the order in which they appear in the request.
+type Patchset struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	// Commit is the head of the review branch for this patchset.
+	Commit string `json:"commit"`
+	// Base is the commit that the patchset was compared against when it was published.
+	Base string `json:"base,omitempty"`
 }
 
 // New returns a new request.
@@ -74,10 +118,37 @@ func Parse(note repository.Note) (Request, error) {
 	bytes := []byte(note)
 	var request Request
 	err := json.Unmarshal(bytes, &request)
+	request.note = note
 	// TODO(ojarjur): If "requester" is not set, then use git-blame to fill it in.
 	return request, err
 }
 
+// plainRequest has the same fields as Request, but is serialized without its MarshalJSON and UnmarshalJSON methods.
+type plainRequest Request
+
+// UnmarshalJSON parses a request, keeping any fields that this version of the tool
+// does not know about, so that they are not lost if the request is written back out.
+func (request *Request) UnmarshalJSON(data []byte) error {
+	var plain plainRequest
+	unknown, err := schema.Decode(data, &plain)
+	if err != nil {
+		return err
+	}
+	*request = Request(plain)
+	request.unknown = unknown
+	return nil
+}
+
+// MarshalJSON serializes a request, including any fields that it was parsed with
+// but that this version of the tool does not know about.
+func (request Request) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(plainRequest(request))
+	if err != nil {
+		return nil, err
+	}
+	return schema.AppendFields(data, request.unknown)
+}
+
 // ParseAllValid takes collection of git notes and tries to parse a review
 // request from each one. Any notes that are not valid review requests get
 // ignored, as we expect the git notes to be a heterogenous list, with only
@@ -85,8 +156,13 @@ func Parse(note repository.Note) (Request, error) {
 func ParseAllValid(notes []repository.Note) []Request {
 	var requests []Request
 	for _, note := range notes {
-		request, err := Parse(note)
+		upgraded, err := schema.Upgrade(Ref, note, FormatVersion)
+		if err != nil {
+			continue
+		}
+		request, err := Parse(upgraded)
 		if err == nil && request.Version == FormatVersion {
+			request.note = note
 			requests = append(requests, request)
 		}
 	}
@@ -98,3 +174,37 @@ func (request *Request) Write() (repository.Note, error) {
 	bytes, err := json.Marshal(request)
 	return repository.Note(bytes), err
 }
+
+// Sign signs the request using the given GPG key, and stores the resulting signature in the request.
+//
+// If the key is empty, then the default GPG signing key is used.
+func (request *Request) Sign(key string) error {
+	request.Signature = ""
+	request.note = nil
+	bytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	signature, err := gpg.Sign(key, bytes)
+	if err != nil {
+		return err
+	}
+	request.Signature = signature
+	return nil
+}
+
+// Verify checks the signature of the request, and returns the key that signed it.
+//
+// The signature is checked against the note that the request was parsed from, if there is one.
+func (request Request) Verify() (*gpg.Signer, error) {
+	signature := request.Signature
+	if unsigned, ok := gpg.Unsigned(request.note, signature); ok && signature != "" {
+		return gpg.Verify(unsigned, signature)
+	}
+	request.Signature = ""
+	bytes, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	return gpg.Verify(bytes, signature)
+}
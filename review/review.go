@@ -15,22 +15,46 @@ limitations under the License.
 */
 
 // Package review contains the data structures used to represent code reviews.
+//
+// Together with its comment, request, and ci subpackages, this package is the
+// Go API for tools that want to read or write reviews without running the
+// git-appraise command. The exported identifiers in these four packages follow
+// semantic versioning: they are only removed or changed incompatibly in a new
+// major version, while new fields and functions may be added at any time.
+//
+// Nothing in them depends on package-level state, so reviews from several
+// repositories may be used at once. Failures to look up a review are reported
+// as a *NotFoundError, *NoReviewError, or *AmbiguousError, and the functions
+// that may take a long time on large repositories have variants that accept a
+// context.Context for cancellation.
 package review
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/promet/git-appraise/repository"
 	"github.com/promet/git-appraise/review/analyses"
+	"github.com/promet/git-appraise/review/anchor"
 	"github.com/promet/git-appraise/review/ci"
 	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/event"
+	"github.com/promet/git-appraise/review/reaction"
 	"github.com/promet/git-appraise/review/request"
+	"github.com/promet/git-appraise/review/robot"
+	"github.com/promet/git-appraise/review/schema"
+	"runtime"
 	"sort"
+	"strings"
+	"sync"
 )
 
 const archiveRef = "refs/pullrequests/archives/reviews"
 
+// RedactorsConfig is the git config setting that lists the users who may redact the comments of others.
+const RedactorsConfig = "appraise.redactors"
+
 // CommentThread represents the tree-based hierarchy of comments.
 //
 // The Resolved field represents the aggregate status of the entire thread. If
@@ -39,11 +63,74 @@ const archiveRef = "refs/pullrequests/archives/reviews"
 // FYI only, and that there are no unaddressed comments. If it is set to true,
 // then that means that there are no unaddressed comments, and that the root
 // comment has its resolved bit set to true.
+//
+// When the thread is resolved, the ResolvedBy and ResolvedAt fields hold the
+// author and timestamp of the most recent comment that resolved it.
+//
+// The Hash of the root comment in a thread serves as the ID of the thread.
+//
+// The Comment field always holds the comment as originally written, and any
+// later revisions of it by the same author are held, in chronological order,
+// in the Revisions field. Use the Latest method to get its current contents.
 type CommentThread struct {
-	Hash     string          `json:"hash,omitempty"`
-	Comment  comment.Comment `json:"comment"`
-	Children []CommentThread `json:"children,omitempty"`
-	Resolved *bool           `json:"resolved,omitempty"`
+	Hash       string            `json:"hash,omitempty"`
+	Comment    comment.Comment   `json:"comment"`
+	Children   []CommentThread   `json:"children,omitempty"`
+	Resolved   *bool             `json:"resolved,omitempty"`
+	ResolvedBy string            `json:"resolvedBy,omitempty"`
+	ResolvedAt string            `json:"resolvedAt,omitempty"`
+	Revisions  []comment.Comment `json:"revisions,omitempty"`
+	// Redacted is the revision that redacted the thread's comment, if any, in which
+	// case the Comment field holds only what is left of it, and there are no revisions.
+	Redacted *comment.Comment `json:"redacted,omitempty"`
+	// Reactions are only loaded as part of a review's details.
+	Reactions []reaction.Count `json:"reactions,omitempty"`
+	// Outdated is only set by RemapComments, and indicates that the line
+	// commented upon has changed since the comment was written.
+	Outdated bool `json:"outdated,omitempty"`
+}
+
+// Latest returns the current contents of the thread's comment, after applying any revisions.
+func (thread CommentThread) Latest() comment.Comment {
+	latest := thread.Comment
+	if len(thread.Revisions) == 0 {
+		return latest
+	}
+	revision := thread.Revisions[len(thread.Revisions)-1]
+	latest.Description = revision.Description
+	latest.Suggestion = revision.Suggestion
+	latest.Deleted = revision.Deleted
+	if revision.Deleted {
+		latest.Description = ""
+		latest.Suggestion = ""
+	}
+	return latest
+}
+
+// IsEdited returns whether or not the thread's comment has been revised.
+func (thread CommentThread) IsEdited() bool {
+	return len(thread.Revisions) > 0
+}
+
+// IsDeleted returns whether or not the thread's comment has been retracted.
+func (thread CommentThread) IsDeleted() bool {
+	return thread.Latest().Deleted
+}
+
+// IsOpen returns whether or not the thread still contains an unaddressed comment.
+func (thread *CommentThread) IsOpen() bool {
+	return thread.Resolved != nil && !*thread.Resolved
+}
+
+// Replies returns all of the descendant comments of the thread, in chronological order.
+func (thread *CommentThread) Replies() []CommentThread {
+	var replies []CommentThread
+	for _, child := range thread.Children {
+		replies = append(replies, child)
+		replies = append(replies, child.Replies()...)
+	}
+	sort.Stable(byTimestamp(replies))
+	return replies
 }
 
 // Summary represents the high-level state of a code review.
@@ -64,6 +151,9 @@ type Summary struct {
 	Comments    []CommentThread   `json:"comments,omitempty"`
 	Resolved    *bool             `json:"resolved,omitempty"`
 	Submitted   bool              `json:"submitted"`
+	// Mergeable and Conflicts are only set once they have been checked; see CheckMergeable.
+	Mergeable *bool    `json:"mergeable,omitempty"`
+	Conflicts []string `json:"conflicts,omitempty"`
 }
 
 // Review represents the entire state of a code review.
@@ -76,6 +166,11 @@ type Review struct {
 	*Summary
 	Reports  []ci.Report       `json:"reports,omitempty"`
 	Analyses []analyses.Report `json:"analyses,omitempty"`
+	// RobotComments are the findings of automated analyzers, which are kept
+	// separate from the comment threads written by people.
+	RobotComments []robot.Comment `json:"robotComments,omitempty"`
+	// Events record the transfers and re-requests of the review, oldest first.
+	Events []event.Event `json:"events,omitempty"`
 }
 
 type byTimestamp []CommentThread
@@ -84,7 +179,7 @@ type byTimestamp []CommentThread
 func (threads byTimestamp) Len() int      { return len(threads) }
 func (threads byTimestamp) Swap(i, j int) { threads[i], threads[j] = threads[j], threads[i] }
 func (threads byTimestamp) Less(i, j int) bool {
-	return threads[i].Comment.Timestamp < threads[j].Comment.Timestamp
+	return schema.CompareTimestamps(threads[i].Comment.Timestamp, threads[j].Comment.Timestamp) < 0
 }
 
 type requestsByTimestamp []request.Request
@@ -95,7 +190,7 @@ func (requests requestsByTimestamp) Swap(i, j int) {
 	requests[i], requests[j] = requests[j], requests[i]
 }
 func (requests requestsByTimestamp) Less(i, j int) bool {
-	return requests[i].Timestamp < requests[j].Timestamp
+	return schema.CompareTimestamps(requests[i].Timestamp, requests[j].Timestamp) < 0
 }
 
 type summariesWithNewestRequestsFirst []Summary
@@ -106,7 +201,7 @@ func (summaries summariesWithNewestRequestsFirst) Swap(i, j int) {
 	summaries[i], summaries[j] = summaries[j], summaries[i]
 }
 func (summaries summariesWithNewestRequestsFirst) Less(i, j int) bool {
-	return summaries[i].Request.Timestamp > summaries[j].Request.Timestamp
+	return schema.CompareTimestamps(summaries[i].Request.Timestamp, summaries[j].Request.Timestamp) > 0
 }
 
 // updateThreadsStatus calculates the aggregate status of a sequence of comment threads.
@@ -132,9 +227,12 @@ func updateThreadsStatus(threads []CommentThread) *bool {
 // updateResolvedStatus calculates the aggregate status of a single comment thread,
 // and updates the "Resolved" field of that thread accordingly.
 func (thread *CommentThread) updateResolvedStatus() {
+	thread.ResolvedBy = ""
+	thread.ResolvedAt = ""
 	resolved := updateThreadsStatus(thread.Children)
 	if resolved == nil {
 		thread.Resolved = thread.Comment.Resolved
+		thread.updateResolver()
 		return
 	}
 
@@ -149,13 +247,32 @@ func (thread *CommentThread) updateResolvedStatus() {
 	}
 
 	thread.Resolved = resolved
+	thread.updateResolver()
+}
+
+// updateResolver sets the ResolvedBy and ResolvedAt fields of a resolved thread
+// based on the most recent comment in it that has the resolved bit set.
+func (thread *CommentThread) updateResolver() {
+	if thread.Resolved == nil || !*thread.Resolved {
+		return
+	}
+	thread.ResolvedBy = thread.Comment.Author
+	thread.ResolvedAt = thread.Comment.Timestamp
+	for _, child := range thread.Children {
+		if child.Resolved != nil && *child.Resolved && schema.CompareTimestamps(child.ResolvedAt, thread.ResolvedAt) >= 0 {
+			thread.ResolvedBy = child.ResolvedBy
+			thread.ResolvedAt = child.ResolvedAt
+		}
+	}
 }
 
 // mutableThread is an internal-only data structure used to store partially constructed comment threads.
 type mutableThread struct {
-	Hash     string
-	Comment  comment.Comment
-	Children []*mutableThread
+	Hash      string
+	Comment   comment.Comment
+	Children  []*mutableThread
+	Revisions []comment.Comment
+	Redacted  *comment.Comment
 }
 
 // fixMutableThread is a helper method to finalize a mutableThread struct
@@ -166,10 +283,15 @@ func fixMutableThread(mutableThread *mutableThread) CommentThread {
 	for _, mutableChild := range mutableThread.Children {
 		children = append(children, fixMutableThread(mutableChild))
 	}
+	sort.SliceStable(mutableThread.Revisions, func(i, j int) bool {
+		return schema.CompareTimestamps(mutableThread.Revisions[i].Timestamp, mutableThread.Revisions[j].Timestamp) < 0
+	})
 	return CommentThread{
-		Hash:     mutableThread.Hash,
-		Comment:  mutableThread.Comment,
-		Children: children,
+		Hash:      mutableThread.Hash,
+		Comment:   mutableThread.Comment,
+		Children:  children,
+		Revisions: mutableThread.Revisions,
+		Redacted:  mutableThread.Redacted,
 	}
 }
 
@@ -177,9 +299,17 @@ func fixMutableThread(mutableThread *mutableThread) CommentThread {
 //
 // Since the comments can be processed in any order, this uses an internal mutable
 // data structure, and then converts it to the proper CommentThread structure at the end.
-func buildCommentThreads(commentsByHash map[string]comment.Comment) []CommentThread {
+//
+// A redaction is only honored if mayRedact reports that its author may redact the comment
+// that it redacts, or if that comment is already gone and the replies to it are all that is left.
+func buildCommentThreads(commentsByHash map[string]comment.Comment, mayRedact func(redactor, author string) bool) []CommentThread {
 	threadsByHash := make(map[string]*mutableThread)
+	var revisions []comment.Comment
 	for hash, comment := range commentsByHash {
+		if comment.Original != "" {
+			revisions = append(revisions, comment)
+			continue
+		}
 		thread, ok := threadsByHash[hash]
 		if !ok {
 			thread = &mutableThread{
@@ -189,6 +319,54 @@ func buildCommentThreads(commentsByHash map[string]comment.Comment) []CommentThr
 			threadsByHash[hash] = thread
 		}
 	}
+	referenced := make(map[string]bool)
+	for _, c := range commentsByHash {
+		if c.Original == "" {
+			referenced[c.Parent] = true
+		}
+	}
+	for i := range revisions {
+		// A redaction takes the place of the redacted comment, even if a copy of it is still around.
+		redaction := revisions[i].Redaction
+		if redaction == nil {
+			continue
+		}
+		var placeholder comment.Comment
+		if original, ok := commentsByHash[revisions[i].Original]; ok && original.Original == "" {
+			if !mayRedact(revisions[i].Author, original.Author) {
+				continue
+			}
+			placeholder = comment.Comment{
+				Timestamp: original.Timestamp,
+				Author:    original.Author,
+				Parent:    original.Parent,
+				Location:  original.Location,
+				Version:   original.Version,
+			}
+		} else if redaction.Comment != nil && referenced[revisions[i].Original] {
+			// The redacted comment is gone, so only the replies to it vouch for its existence,
+			// and nothing but its position in the thread is taken from the redaction.
+			placeholder = comment.Comment{
+				Timestamp: redaction.Comment.Timestamp,
+				Parent:    redaction.Comment.Parent,
+				Location:  redaction.Comment.Location,
+				Version:   redaction.Comment.Version,
+			}
+		} else {
+			continue
+		}
+		threadsByHash[revisions[i].Original] = &mutableThread{
+			Hash:     revisions[i].Original,
+			Comment:  placeholder,
+			Redacted: &revisions[i],
+		}
+	}
+	for _, revision := range revisions {
+		// Only the author of a comment may revise it, and redacted comments have no revisions.
+		if thread, ok := threadsByHash[revision.Original]; ok && thread.Comment.Author == revision.Author && thread.Redacted == nil {
+			thread.Revisions = append(thread.Revisions, revision)
+		}
+	}
 	var rootHashes []string
 	for hash, thread := range threadsByHash {
 		if thread.Comment.Parent == "" {
@@ -211,15 +389,46 @@ func buildCommentThreads(commentsByHash map[string]comment.Comment) []CommentThr
 // and then builds the corresponding tree-structured comment threads.
 func (r *Summary) loadComments(commentNotes []repository.Note) []CommentThread {
 	commentsByHash := comment.ParseAllValid(commentNotes)
-	return buildCommentThreads(commentsByHash)
+	var redactors map[string]bool
+	return buildCommentThreads(commentsByHash, func(redactor, author string) bool {
+		if redactor == author {
+			return true
+		}
+		if redactors == nil {
+			redactors = loadRedactors(r.Repo)
+		}
+		return redactors[redactor]
+	})
+}
+
+// loadRedactors returns the set of users who may redact the comments of others.
+func loadRedactors(repo repository.Repo) map[string]bool {
+	redactors := make(map[string]bool)
+	values, err := repo.GetConfig(RedactorsConfig)
+	if err != nil {
+		return redactors
+	}
+	for _, value := range values {
+		for _, redactor := range strings.Split(value, ",") {
+			if redactor = strings.TrimSpace(redactor); redactor != "" {
+				redactors[redactor] = true
+			}
+		}
+	}
+	return redactors
 }
 
 func getSummaryFromNotes(repo repository.Repo, revision string, requestNotes, commentNotes []repository.Note) (*Summary, error) {
 	requests := request.ParseAllValid(requestNotes)
 	if requests == nil {
-		return nil, fmt.Errorf("Could not find any review requests for %q", revision)
+		return nil, &NoReviewError{Revision: revision}
 	}
 	sort.Stable(requestsByTimestamp(requests))
+	return getSummaryFromRequests(repo, revision, requests, commentNotes), nil
+}
+
+// getSummaryFromRequests returns the summary of a review, given its requests sorted oldest first.
+func getSummaryFromRequests(repo repository.Repo, revision string, requests []request.Request, commentNotes []repository.Note) *Summary {
 	reviewSummary := Summary{
 		Repo:        repo,
 		Revision:    revision,
@@ -228,7 +437,25 @@ func getSummaryFromNotes(repo repository.Repo, revision string, requestNotes, co
 	}
 	reviewSummary.Comments = reviewSummary.loadComments(commentNotes)
 	reviewSummary.Resolved = updateThreadsStatus(reviewSummary.Comments)
-	return &reviewSummary, nil
+	return &reviewSummary
+}
+
+// DecryptComments decrypts the private comments of the review that the user has a key for, so that they can be shown.
+//
+// Private comments that cannot be decrypted are left as they are. Bodies
+// encrypted with age are decrypted using the identity in the given file.
+func (r *Summary) DecryptComments(ageIdentityFile string) {
+	var visit func(threads []CommentThread)
+	visit = func(threads []CommentThread) {
+		for i := range threads {
+			threads[i].Comment.Decrypt(ageIdentityFile)
+			for j := range threads[i].Revisions {
+				threads[i].Revisions[j].Decrypt(ageIdentityFile)
+			}
+			visit(threads[i].Children)
+		}
+	}
+	visit(r.Comments)
 }
 
 // GetSummary returns the summary of the specified code review.
@@ -236,7 +463,7 @@ func getSummaryFromNotes(repo repository.Repo, revision string, requestNotes, co
 // If no review request exists, the returned review summary is nil.
 func GetSummary(repo repository.Repo, revision string) (*Summary, error) {
 	if err := repo.VerifyCommit(revision); err != nil {
-		return nil, fmt.Errorf("Could not find a commit named %q", revision)
+		return nil, &NotFoundError{Name: revision}
 	}
 	requestNotes := repo.GetNotes(request.Ref, revision)
 	commentNotes := repo.GetNotes(comment.Ref, revision)
@@ -259,19 +486,63 @@ func GetSummary(repo repository.Repo, revision string) (*Summary, error) {
 	return summary, nil
 }
 
+// setReactions attaches the given reactions to the comment threads they react to.
+func setReactions(threads []CommentThread, reactions map[string][]reaction.Count) {
+	for i := range threads {
+		threads[i].Reactions = reactions[threads[i].Hash]
+		setReactions(threads[i].Children, reactions)
+	}
+}
+
 // Details returns the detailed review for the given summary.
 func (r *Summary) Details() (*Review, error) {
+	return r.details(r.Repo.GetNotes)
+}
+
+// details returns the detailed review for the given summary, reading its notes with the given function.
+func (r *Summary) details(getNotes func(notesRef, revision string) []repository.Note) (*Review, error) {
 	review := Review{
 		Summary: r,
 	}
 	currentCommit, err := review.GetHeadCommit()
 	if err == nil {
-		review.Reports = ci.ParseAllValid(review.Repo.GetNotes(ci.Ref, currentCommit))
-		review.Analyses = analyses.ParseAllValid(review.Repo.GetNotes(analyses.Ref, currentCommit))
+		review.Reports = ci.ParseAllValid(getNotes(ci.Ref, currentCommit))
+		review.Analyses = analyses.ParseAllValid(getNotes(analyses.Ref, currentCommit))
 	}
+	setReactions(r.Comments, reaction.Aggregate(reaction.ParseAllValid(getNotes(reaction.Ref, r.Revision))))
+	review.RobotComments = robot.Aggregate(robot.ParseAllValid(getNotes(robot.Ref, r.Revision)))
+	review.Events = event.ParseAllValid(getNotes(event.Ref, r.Revision))
 	return &review, nil
 }
 
+// DetailsAll returns the detailed reviews for the given summaries, all of which must be reviews in the given repo.
+//
+// Rather than reading the notes of each review separately, as Details does,
+// this reads all of the notes under each ref at once, so that the number of
+// git commands it runs does not grow with the number of reviews.
+func DetailsAll(repo repository.Repo, summaries []Summary) ([]*Review, error) {
+	allNotes := make(map[string]map[string][]repository.Note)
+	for _, notesRef := range []string{ci.Ref, analyses.Ref, reaction.Ref, robot.Ref, event.Ref} {
+		notes, err := repo.GetAllNotes(notesRef)
+		if err != nil {
+			return nil, err
+		}
+		allNotes[notesRef] = notes
+	}
+	getNotes := func(notesRef, revision string) []repository.Note {
+		return allNotes[notesRef][revision]
+	}
+	var reviews []*Review
+	for i := range summaries {
+		r, err := summaries[i].details(getNotes)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, r)
+	}
+	return reviews, nil
+}
+
 // IsAbandoned returns whether or not the given review has been abandoned.
 func (r *Summary) IsAbandoned() bool {
 	return r.Request.TargetRef == ""
@@ -282,6 +553,28 @@ func (r *Summary) IsOpen() bool {
 	return !r.Submitted && !r.IsAbandoned()
 }
 
+// IsDraft returns whether or not the given review is an open review that has not yet been published.
+func (r *Summary) IsDraft() bool {
+	return r.Request.Draft && r.IsOpen()
+}
+
+// CheckMergeable records whether the open review merges cleanly into its target ref, in its Mergeable and Conflicts fields.
+//
+// Both fields are left unset for closed reviews, and for reviews that cannot
+// be checked, such as because their target ref does not exist locally.
+func (r *Summary) CheckMergeable() {
+	if !r.IsOpen() {
+		return
+	}
+	conflicts, err := (&Review{Summary: r}).GetMergeConflicts()
+	if err != nil {
+		return
+	}
+	mergeable := len(conflicts) == 0
+	r.Mergeable = &mergeable
+	r.Conflicts = conflicts
+}
+
 // Get returns the specified code review.
 //
 // If no review request exists, the returned review is nil.
@@ -296,20 +589,34 @@ func Get(repo repository.Repo, revision string) (*Review, error) {
 	return summary.Details()
 }
 
+// refCommits lazily computes the set of commits reachable from a ref.
+type refCommits struct {
+	once    sync.Once
+	commits map[string]bool
+}
+
+// getIsSubmittedCheck returns a function that reports whether a commit is reachable from a ref.
+//
+// The returned function is safe for concurrent use, and lists the commits of each ref at most once.
 func getIsSubmittedCheck(repo repository.Repo) func(ref, commit string) bool {
-	refCommitsMap := make(map[string]map[string]bool)
+	var mutex sync.Mutex
+	refCommitsMap := make(map[string]*refCommits)
 
 	getRefCommitsMap := func(ref string) map[string]bool {
-		commitsMap, ok := refCommitsMap[ref]
-		if ok {
-			return commitsMap
-		}
-		commitsMap = make(map[string]bool)
-		for _, commit := range repo.ListCommits(ref) {
-			commitsMap[commit] = true
+		mutex.Lock()
+		commits, ok := refCommitsMap[ref]
+		if !ok {
+			commits = &refCommits{}
+			refCommitsMap[ref] = commits
 		}
-		refCommitsMap[ref] = commitsMap
-		return commitsMap
+		mutex.Unlock()
+		commits.once.Do(func() {
+			commits.commits = make(map[string]bool)
+			for _, commit := range repo.ListCommits(ref) {
+				commits.commits[commit] = true
+			}
+		})
+		return commits.commits
 	}
 
 	return func(ref, commit string) bool {
@@ -317,48 +624,276 @@ func getIsSubmittedCheck(repo repository.Repo) func(ref, commit string) bool {
 	}
 }
 
-func unsortedListAll(repo repository.Repo) []Summary {
+func unsortedListAll(ctx context.Context, repo repository.Repo, loadWorkers int) ([]Summary, error) {
 	reviewNotesMap, err := repo.GetAllNotes(request.Ref)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	discussNotesMap, err := repo.GetAllNotes(comment.Ref)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
+	// The summaries are parsed, and checked for having been submitted, by a
+	// fixed pool of workers. The channels are bounded so that the number of
+	// summaries in flight at once stays small regardless of the repo size.
 	isSubmittedCheck := getIsSubmittedCheck(repo)
-	var reviews []Summary
-	for commit, notes := range reviewNotesMap {
-		summary, err := getSummaryFromNotes(repo, commit, notes, discussNotesMap[commit])
-		if err != nil {
-			continue
-		}
-		if !summary.IsAbandoned() {
-			summary.Submitted = isSubmittedCheck(summary.Request.TargetRef, summary.getStartingCommit())
+	commits := make(chan string, loadWorkers)
+	summaries := make(chan *Summary, loadWorkers)
+	var workers sync.WaitGroup
+	for i := 0; i < loadWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for commit := range commits {
+				if ctx.Err() != nil {
+					continue
+				}
+				summary, err := getSummaryFromNotes(repo, commit, reviewNotesMap[commit], discussNotesMap[commit])
+				if err != nil {
+					continue
+				}
+				if !summary.IsAbandoned() {
+					summary.Submitted = isSubmittedCheck(summary.Request.TargetRef, summary.getStartingCommit())
+				}
+				summaries <- summary
+			}
+		}()
+	}
+	go func() {
+		defer close(commits)
+		for commit := range reviewNotesMap {
+			select {
+			case commits <- commit:
+			case <-ctx.Done():
+				return
+			}
 		}
+	}()
+	go func() {
+		workers.Wait()
+		close(summaries)
+	}()
+
+	var reviews []Summary
+	for summary := range summaries {
 		reviews = append(reviews, *summary)
 	}
-	return reviews
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// Sort by revision so that the ordering does not depend on which worker finished first.
+	sort.Slice(reviews, func(i, j int) bool {
+		return reviews[i].Revision < reviews[j].Revision
+	})
+	return reviews, nil
+}
+
+// ListAllContext returns all reviews stored in the git-notes, newest first.
+//
+// Loading the reviews stops early, with the context's error, if the context
+// is cancelled or its deadline passes.
+func ListAllContext(ctx context.Context, repo repository.Repo) ([]Summary, error) {
+	reviews, err := unsortedListAll(ctx, repo, runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	sort.Stable(summariesWithNewestRequestsFirst(reviews))
+	return reviews, nil
 }
 
 // ListAll returns all reviews stored in the git-notes.
+//
+// Loading the reviews is bounded by the repo's context. Any failure to read
+// the notes results in an empty list; use ListAllContext to see the error.
 func ListAll(repo repository.Repo) []Summary {
-	reviews := unsortedListAll(repo)
-	sort.Stable(summariesWithNewestRequestsFirst(reviews))
+	reviews, _ := ListAllContext(repo.Context(), repo)
 	return reviews
 }
 
-// ListOpen returns all reviews that are not yet incorporated into their target refs.
-func ListOpen(repo repository.Repo) []Summary {
+// ListOpenContext returns all reviews that are not yet incorporated into their target refs.
+//
+// Loading the reviews stops early, with the context's error, if the context
+// is cancelled or its deadline passes.
+func ListOpenContext(ctx context.Context, repo repository.Repo) ([]Summary, error) {
+	reviews, err := unsortedListAll(ctx, repo, runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
 	var openReviews []Summary
-	for _, review := range unsortedListAll(repo) {
+	for _, review := range reviews {
 		if review.IsOpen() {
 			openReviews = append(openReviews, review)
 		}
 	}
 	sort.Stable(summariesWithNewestRequestsFirst(openReviews))
-	return openReviews
+	return openReviews, nil
+}
+
+// ListOpen returns all reviews that are not yet incorporated into their target refs.
+//
+// Loading the reviews is bounded by the repo's context. Any failure to read
+// the notes results in an empty list; use ListOpenContext to see the error.
+func ListOpen(repo repository.Repo) []Summary {
+	reviews, _ := ListOpenContext(repo.Context(), repo)
+	return reviews
+}
+
+// pendingSummary is a review whose requests have been parsed, but whose summary is still being loaded.
+type pendingSummary struct {
+	revision string
+	requests []request.Request
+	result   chan *Summary
+}
+
+func (p *pendingSummary) latestRequest() request.Request {
+	return p.requests[len(p.requests)-1]
+}
+
+func streamReviews(ctx context.Context, repo repository.Repo, requestedSince int64, loadWorkers int, visit func(Summary) bool) error {
+	reviewNotesMap, err := repo.GetAllNotes(request.Ref)
+	if err != nil {
+		return err
+	}
+	discussNotesMap, err := repo.GetAllNotes(comment.Ref)
+	if err != nil {
+		return err
+	}
+
+	// Only the requests are parsed up front, since they are all that is
+	// needed to put the reviews in order; the rest of each summary is
+	// loaded once the reviews before it have been visited.
+	var pending []pendingSummary
+	for commit, notes := range reviewNotesMap {
+		requests := request.ParseAllValid(notes)
+		if requests == nil {
+			continue
+		}
+		sort.Stable(requestsByTimestamp(requests))
+		p := pendingSummary{revision: commit, requests: requests}
+		if requestedSince != 0 {
+			t, err := schema.ParseTimestamp(p.latestRequest().Timestamp)
+			if err != nil || t.Unix() < requestedSince {
+				continue
+			}
+		}
+		pending = append(pending, p)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if c := schema.CompareTimestamps(pending[i].latestRequest().Timestamp, pending[j].latestRequest().Timestamp); c != 0 {
+			return c > 0
+		}
+		return pending[i].revision < pending[j].revision
+	})
+
+	// The summaries are loaded by a fixed pool of workers, at most
+	// loadWorkers ahead of the one being visited, and visited in order.
+	loadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	isSubmittedCheck := getIsSubmittedCheck(repo)
+	jobs := make(chan *pendingSummary)
+	order := make(chan *pendingSummary, loadWorkers)
+	var workers sync.WaitGroup
+	for i := 0; i < loadWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for p := range jobs {
+				if loadCtx.Err() != nil {
+					p.result <- nil
+					continue
+				}
+				summary := getSummaryFromRequests(repo, p.revision, p.requests, discussNotesMap[p.revision])
+				if !summary.IsAbandoned() {
+					summary.Submitted = isSubmittedCheck(summary.Request.TargetRef, summary.getStartingCommit())
+				}
+				p.result <- summary
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		defer close(order)
+		for i := range pending {
+			p := &pending[i]
+			p.result = make(chan *Summary, 1)
+			select {
+			case order <- p:
+			case <-loadCtx.Done():
+				return
+			}
+			select {
+			case jobs <- p:
+			case <-loadCtx.Done():
+				return
+			}
+		}
+	}()
+
+visiting:
+	for p := range order {
+		select {
+		case summary := <-p.result:
+			if summary != nil && !visit(*summary) {
+				break visiting
+			}
+		case <-loadCtx.Done():
+			break visiting
+		}
+	}
+	cancel()
+	workers.Wait()
+	return ctx.Err()
+}
+
+// StreamContext calls visit with each of the reviews stored in the git-notes,
+// newest first, as soon as that review has been loaded, until visit returns false.
+//
+// Only the reviews whose latest request was made at or after the requestedSince
+// Unix time are loaded; zero selects all of them. Unlike ListAllContext, this
+// orders the reviews using their requests alone, so the first ones are visited
+// without waiting for the rest to load.
+//
+// Loading the reviews stops early, with the context's error, if the context
+// is cancelled or its deadline passes.
+func StreamContext(ctx context.Context, repo repository.Repo, requestedSince int64, visit func(Summary) bool) error {
+	return streamReviews(ctx, repo, requestedSince, runtime.NumCPU(), visit)
+}
+
+// HasLabel returns whether or not the given review has been tagged with the given label.
+func (r *Summary) HasLabel(label string) bool {
+	for _, l := range r.Request.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterByLabels returns the reviews from the given list that have been tagged with every one of the given labels.
+func FilterByLabels(reviews []Summary, labels []string) []Summary {
+	var filtered []Summary
+	for _, review := range reviews {
+		matches := true
+		for _, label := range labels {
+			matches = matches && review.HasLabel(label)
+		}
+		if matches {
+			filtered = append(filtered, review)
+		}
+	}
+	return filtered
+}
+
+// FilterDrafts removes the draft reviews from the given list, other than those requested by the given user.
+func FilterDrafts(reviews []Summary, user string) []Summary {
+	var filtered []Summary
+	for _, review := range reviews {
+		if !review.IsDraft() || (user != "" && review.Request.Requester == user) {
+			filtered = append(filtered, review)
+		}
+	}
+	return filtered
 }
 
 // GetCurrent returns the current, open code review.
@@ -379,23 +914,96 @@ func GetCurrent(repo repository.Repo) (*Review, error) {
 		return nil, nil
 	}
 	if len(matchingReviews) != 1 {
-		return nil, fmt.Errorf("There are %d open reviews for the ref \"%s\"", len(matchingReviews), reviewRef)
+		return nil, &AmbiguousError{Name: reviewRef, Ref: true, Matches: len(matchingReviews)}
 	}
 	return matchingReviews[0].Details()
 }
 
 // GetBuildStatusMessage returns a string of the current build-and-test status
 // of the review, or "unknown" if the build-and-test status cannot be determined.
+//
+// If multiple CI agents have reported on the review, then the message includes
+// the latest status from each agent, one per line.
 func (r *Review) GetBuildStatusMessage() string {
-	statusMessage := "unknown"
-	ciReport, err := ci.GetLatestCIReport(r.Reports)
+	latestReports, err := ci.GetLatestCIReportsByAgent(r.Reports)
 	if err != nil {
 		return fmt.Sprintf("unknown: %s", err)
 	}
-	if ciReport != nil {
-		statusMessage = fmt.Sprintf("%s (%q)", ciReport.Status, ciReport.URL)
+	if len(latestReports) == 0 {
+		return "unknown"
 	}
-	return statusMessage
+	if len(latestReports) == 1 {
+		for _, ciReport := range latestReports {
+			return getReportStatusMessage(ciReport)
+		}
+	}
+	var agents []string
+	for agent := range latestReports {
+		agents = append(agents, agent)
+	}
+	sort.Strings(agents)
+	var statusMessages []string
+	for _, agent := range agents {
+		agentName := agent
+		if agentName == "" {
+			agentName = "unknown agent"
+		}
+		statusMessages = append(statusMessages, fmt.Sprintf("\n    %s: %s", agentName, getReportStatusMessage(latestReports[agent])))
+	}
+	return strings.Join(statusMessages, "")
+}
+
+// getReportStatusMessage returns a string describing the status of a single CI report.
+func getReportStatusMessage(ciReport *ci.Report) string {
+	if ciReport.IsInProgress() {
+		return fmt.Sprintf("%s, the build is still executing (%q)", ciReport.Status, ciReport.URL)
+	}
+	return fmt.Sprintf("%s (%q)", ciReport.Status, ciReport.URL)
+}
+
+// GetFailingCIAgents returns the names of all of the CI agents whose latest
+// report for the review indicates a failure.
+func (r *Review) GetFailingCIAgents() ([]string, error) {
+	latestReports, err := ci.GetLatestCIReportsByAgent(r.Reports)
+	if err != nil {
+		return nil, err
+	}
+	var failingAgents []string
+	for agent, report := range latestReports {
+		if report.Status == ci.StatusFailure {
+			failingAgents = append(failingAgents, agent)
+		}
+	}
+	sort.Strings(failingAgents)
+	return failingAgents, nil
+}
+
+// GetPendingReruns returns the requests to re-run the CI build of the review's
+// head commit that no CI report has responded to yet.
+func (r *Review) GetPendingReruns() ([]ci.Rerun, error) {
+	commit, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	reruns := ci.ParseAllValidReruns(r.Repo.GetNotes(ci.RerunRef, commit))
+	return ci.PendingReruns(reruns, r.Reports, "")
+}
+
+// GetCoverage returns the latest code coverage reported for the review, along
+// with the coverage reported for its base commit (i.e. for the target ref).
+//
+// Either value may be nil if no coverage has been reported for that commit.
+func (r *Review) GetCoverage() (*ci.Coverage, *ci.Coverage, error) {
+	head := ci.GetLatestCoverage(r.Reports)
+	if head == nil {
+		return nil, nil, nil
+	}
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return nil, nil, err
+	}
+	base := ci.GetLatestCoverage(ci.ParseAllValid(r.Repo.GetNotes(ci.Ref, baseCommit)))
+	return head, base, nil
 }
 
 // GetAnalysesNotes returns all of the notes from the most recent static
@@ -490,7 +1098,8 @@ func (r *Review) findLastCommit(startingCommit, latestCommit string, commentThre
 		return ct > lt
 	}
 	updateLatest := func(commit string) {
-		if commit == "" {
+		if commit == "" || commit == latestCommit {
+			// Most comments are on the latest commit, which does not need to be checked again.
 			return
 		}
 		if isLater(commit) {
@@ -538,7 +1147,25 @@ func (r *Review) GetHeadCommit() (string, error) {
 		return r.Repo.ResolveRefCommit(r.Request.ReviewRef)
 	}
 
-	return r.findLastCommit(currentCommit, currentCommit, r.Comments), nil
+	// The review ref may also have been deleted, in which case the latest patchset still records the head.
+	latestCommit := currentCommit
+	if n := len(r.Request.Patchsets); n > 0 && r.Repo.VerifyCommit(r.Request.Patchsets[n-1].Commit) == nil {
+		latestCommit = r.Request.Patchsets[n-1].Commit
+	}
+	return r.findLastCommit(currentCommit, latestCommit, r.Comments), nil
+}
+
+// GetMergeConflicts returns the paths in which the head of the review conflicts with its target ref, or nil if it merges cleanly.
+func (r *Review) GetMergeConflicts() ([]string, error) {
+	target, err := r.Repo.ResolveRefCommit(r.Request.TargetRef)
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	return r.Repo.MergeConflicts(target, head)
 }
 
 // GetBaseCommit returns the commit against which a review should be compared.
@@ -597,6 +1224,51 @@ func (r *Review) GetDiff(diffArgs ...string) (string, error) {
 	return "", err
 }
 
+// findThread searches the given comment threads for the one whose root comment has the given hash.
+func findThread(hash string, threads []CommentThread) *CommentThread {
+	for i := range threads {
+		if threads[i].Hash == hash {
+			return &threads[i]
+		}
+		if thread := findThread(hash, threads[i].Children); thread != nil {
+			return thread
+		}
+	}
+	return nil
+}
+
+// FindThread returns the comment thread with the given hash, or nil if the review does not include it.
+func (r *Summary) FindThread(hash string) *CommentThread {
+	return findThread(hash, r.Comments)
+}
+
+// FindComment returns the comment with the given hash, or nil if the review does not include it.
+func (r *Summary) FindComment(hash string) *comment.Comment {
+	thread := findThread(hash, r.Comments)
+	if thread == nil {
+		return nil
+	}
+	return &thread.Comment
+}
+
+// FindRobotComment returns the robot comment whose hash starts with the given
+// prefix, or nil if there is not exactly one such comment.
+func (r *Review) FindRobotComment(prefix string) *robot.Comment {
+	if prefix == "" {
+		return nil
+	}
+	var match *robot.Comment
+	for i := range r.RobotComments {
+		if strings.HasPrefix(r.RobotComments[i].Hash, prefix) {
+			if match != nil {
+				return nil
+			}
+			match = &r.RobotComments[i]
+		}
+	}
+	return match
+}
+
 // AddComment adds the given comment to the review.
 func (r *Review) AddComment(c comment.Comment) error {
 	commentNote, err := c.Write()
@@ -608,8 +1280,58 @@ func (r *Review) AddComment(c comment.Comment) error {
 	return nil
 }
 
+// AddEvent adds the given event to the review.
+func (r *Review) AddEvent(e event.Event) error {
+	note, err := e.Write()
+	if err != nil {
+		return err
+	}
+	return r.Repo.AppendNote(event.Ref, r.Revision, note)
+}
+
+// Redact removes the comment with the given hash, along with its revisions, from the history of the
+// review's comments, and records a redaction by the given author in its place.
+//
+// Redacting a comment again, such as after a copy of it was merged back in from
+// a clone that still had it, removes that copy and keeps the earlier redaction.
+// Since the history of the comments ref is rewritten, it has to be force-pushed.
+func (r *Review) Redact(hash, author, reason string) error {
+	thread := r.FindThread(hash)
+	if thread == nil {
+		return fmt.Errorf("There is no comment %q in the review.", hash)
+	}
+	var redaction repository.Note
+	if thread.Redacted == nil {
+		if author != thread.Comment.Author && !loadRedactors(r.Repo)[author] {
+			return fmt.Errorf("Only the author of the comment %q, or one of the users listed in %s, may redact it.", hash, RedactorsConfig)
+		}
+		var err error
+		if redaction, err = comment.NewRedaction(author, hash, thread.Comment, reason).Write(); err != nil {
+			return err
+		}
+	}
+	return r.Repo.RewriteNotes(comment.Ref, r.Revision, func(notes []repository.Note) []repository.Note {
+		var kept []repository.Note
+		for _, note := range notes {
+			redacted := false
+			for noteHash, c := range comment.ParseAllValid([]repository.Note{note}) {
+				redacted = noteHash == hash || (c.Original == hash && c.Redaction == nil)
+			}
+			if !redacted {
+				kept = append(kept, note)
+			}
+		}
+		if len(redaction) > 0 {
+			kept = append(kept, redaction)
+		}
+		return kept
+	}, fmt.Sprintf("Redact the comment %s", hash))
+}
+
 // Rebase performs an interactive rebase of the review onto its target ref.
 //
+// The rebased head of the review is recorded as a new patchset.
+//
 // If the 'archivePrevious' argument is true, then the previous head of the
 // review will be added to the 'refs/pullrequests/archives/reviews' ref prior
 // to being rewritten. That ensures the review history is kept from being
@@ -639,5 +1361,73 @@ func (r *Review) Rebase(archivePrevious bool) error {
 	if err != nil {
 		return err
 	}
+	if err := r.Repo.AppendNote(request.Ref, r.Revision, newNote); err != nil {
+		return err
+	}
+	_, err = r.RecordPatchset()
+	return err
+}
+
+// Squash submits the review as a single commit on top of its target ref, with the given commit message.
+//
+// The request is updated so that its alias points to the squashed commit,
+// which keeps the review linked to the commit that was actually submitted.
+// If the 'archivePrevious' argument is true, then the previous head of the
+// review is added to the 'refs/pullrequests/archives/reviews' ref, so that
+// the review history is kept from being garbage collected.
+func (r *Review) Squash(message string, archivePrevious bool) error {
+	orig, err := r.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	if archivePrevious {
+		if err := r.Repo.ArchiveRef(orig, archiveRef); err != nil {
+			return err
+		}
+	}
+	if err := r.Repo.SwitchToRef(r.Request.TargetRef); err != nil {
+		return err
+	}
+	if err := r.Repo.SquashRef(orig, message); err != nil {
+		return err
+	}
+	alias, err := r.Repo.GetCommitHash(r.Request.TargetRef)
+	if err != nil {
+		return err
+	}
+	r.Request.Alias = alias
+	newNote, err := r.Request.Write()
+	if err != nil {
+		return err
+	}
 	return r.Repo.AppendNote(request.Ref, r.Revision, newNote)
 }
+
+// RemapComments returns the review's comment threads with their locations
+// translated to the current head of the review.
+//
+// This keeps comments made on an earlier patchset (or before a rebase)
+// attached to the same lines. Threads on lines that have since changed keep
+// their original location, and are marked as outdated. Only the root comment
+// of each thread is translated, as that is the one that determines where the
+// thread is shown.
+func (r *Review) RemapComments() ([]CommentThread, error) {
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	remapper := anchor.NewRemapper(r.Repo, head)
+	var threads []CommentThread
+	for _, thread := range r.Comments {
+		if location := thread.Comment.Location; location != nil {
+			// Comments on commits that can no longer be diffed (e.g. because
+			// they were garbage collected) are left as they are.
+			if remapped, ok, err := remapper.Remap(*location); err == nil {
+				thread.Comment.Location = &remapped
+				thread.Outdated = !ok
+			}
+		}
+		threads = append(threads, thread)
+	}
+	return threads, nil
+}
@@ -17,10 +17,15 @@ limitations under the License.
 package review
 
 import (
+	"context"
 	"github.com/promet/git-appraise/repository"
 	"github.com/promet/git-appraise/review/comment"
 	"github.com/promet/git-appraise/review/request"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -548,7 +553,7 @@ func TestBuildCommentThreads(t *testing.T) {
 		childHash: child,
 		leafHash:  leaf,
 	}
-	threads := buildCommentThreads(commentsByHash)
+	threads := buildCommentThreads(commentsByHash, onlyAuthorsRedact)
 	if len(threads) != 1 {
 		t.Fatalf("Unexpected threads: %v", threads)
 	}
@@ -573,6 +578,125 @@ func TestBuildCommentThreads(t *testing.T) {
 	if len(threadLeaf.Children) != 0 {
 		t.Fatalf("Unexpected leaf children: %v", threadLeaf.Children)
 	}
+
+	summary := Summary{Comments: threads}
+	if found := summary.FindComment(leafHash); found == nil || found.Description != "leaf" {
+		t.Fatalf("Failed to find the leaf comment: %v", found)
+	}
+	if found := summary.FindComment("missing"); found != nil {
+		t.Fatalf("Unexpected comment found: %v", found)
+	}
+}
+
+func TestBuildCommentThreadsWithRevisions(t *testing.T) {
+	original := comment.Comment{
+		Timestamp:   "0000000001",
+		Author:      "alice",
+		Description: "tpyo",
+	}
+	originalHash, err := original.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := comment.Comment{
+		Timestamp:   "0000000003",
+		Author:      "alice",
+		Parent:      originalHash,
+		Original:    originalHash,
+		Description: "typo",
+	}
+	foreignEdit := comment.Comment{
+		Timestamp:   "0000000004",
+		Author:      "mallory",
+		Parent:      originalHash,
+		Original:    originalHash,
+		Description: "hijacked",
+	}
+	deletion := comment.Comment{
+		Timestamp: "0000000005",
+		Author:    "alice",
+		Parent:    originalHash,
+		Original:  originalHash,
+		Deleted:   true,
+	}
+	commentsByHash := make(map[string]comment.Comment)
+	for _, c := range []comment.Comment{original, edit, foreignEdit} {
+		hash, err := c.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		commentsByHash[hash] = c
+	}
+	threads := buildCommentThreads(commentsByHash, onlyAuthorsRedact)
+	if len(threads) != 1 || len(threads[0].Children) != 0 {
+		t.Fatalf("Unexpected threads: %v", threads)
+	}
+	thread := threads[0]
+	if thread.Hash != originalHash || thread.Comment.Description != "tpyo" {
+		t.Fatalf("The original comment was not preserved: %v", thread)
+	}
+	if !thread.IsEdited() || thread.Latest().Description != "typo" || thread.IsDeleted() {
+		t.Fatalf("Unexpected latest version of the comment: %v", thread.Latest())
+	}
+
+	deletionHash, err := deletion.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commentsByHash[deletionHash] = deletion
+	thread = buildCommentThreads(commentsByHash, onlyAuthorsRedact)[0]
+	if len(thread.Revisions) != 2 || !thread.IsDeleted() || thread.Latest().Description != "" {
+		t.Fatalf("Failed to delete the comment: %v", thread)
+	}
+}
+
+func TestThreadResolver(t *testing.T) {
+	rejected := false
+	accepted := true
+	sampleThread := CommentThread{
+		Comment: comment.Comment{
+			Timestamp: "012345",
+			Author:    "reviewer",
+			Resolved:  &accepted,
+		},
+		Children: []CommentThread{
+			CommentThread{
+				Comment: comment.Comment{
+					Timestamp: "012347",
+					Author:    "second-reviewer",
+					Resolved:  &rejected,
+				},
+			},
+			CommentThread{
+				Comment: comment.Comment{
+					Timestamp: "012346",
+					Author:    "requester",
+					Resolved:  nil,
+				},
+			},
+		},
+	}
+	sampleThread.updateResolvedStatus()
+	if !sampleThread.IsOpen() {
+		t.Fatal("Expected the thread to still be open")
+	}
+	if sampleThread.ResolvedBy != "" {
+		t.Fatalf("Unexpected resolver for an open thread: %q", sampleThread.ResolvedBy)
+	}
+	replies := sampleThread.Replies()
+	if len(replies) != 2 || replies[0].Comment.Author != "requester" {
+		t.Fatalf("Unexpected reply ordering: %v", replies)
+	}
+
+	sampleThread.Children[1].Comment.Resolved = &accepted
+	sampleThread.Children[0].Comment.Resolved = &accepted
+	sampleThread.updateResolvedStatus()
+	if sampleThread.IsOpen() {
+		t.Fatal("Expected the thread to be resolved")
+	}
+	if sampleThread.ResolvedBy != "second-reviewer" || sampleThread.ResolvedAt != "012347" {
+		t.Fatalf("Unexpected resolver: %q at %q", sampleThread.ResolvedBy, sampleThread.ResolvedAt)
+	}
 }
 
 func TestGetHeadCommit(t *testing.T) {
@@ -815,3 +939,409 @@ func TestRebaseDetachedHead(t *testing.T) {
 		t.Fatalf("Failed to submit the review: %q", submittedReviewJSON)
 	}
 }
+
+func TestSquash(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	pendingReview, err := Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pendingReview.Squash("Squashed", true); err != nil {
+		t.Fatal(err)
+	}
+	headRef, err := repo.GetHeadRef()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headRef != pendingReview.Request.TargetRef {
+		t.Fatal("Failed to switch to the target ref during a squash")
+	}
+	isAncestor, err := repo.IsAncestor(pendingReview.Revision, archiveRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isAncestor {
+		t.Fatalf("Commit %q is not archived", pendingReview.Revision)
+	}
+	targetCommit, err := repo.GetCommitHash(pendingReview.Request.TargetRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message, err := repo.GetCommitMessage(targetCommit); err != nil || message != "Squashed" {
+		t.Fatalf("Unexpected message for the squashed commit: %q, %v", message, err)
+	}
+	if pendingReview.Request.Alias != targetCommit {
+		t.Fatalf("Failed to set the review alias to the squashed commit: %q", pendingReview.Request.Alias)
+	}
+
+	// Reread the review and confirm that it is linked to the squashed commit.
+	submittedReview, err := Get(repo, pendingReview.Revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submittedReviewJSON, err := submittedReview.GetJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !submittedReview.Submitted {
+		t.Fatalf("Failed to submit the review: %q", submittedReviewJSON)
+	}
+}
+
+func TestFilterDrafts(t *testing.T) {
+	reviews := []Summary{
+		{Revision: "A", Request: request.Request{TargetRef: "refs/heads/master", Requester: "me", Draft: true}},
+		{Revision: "B", Request: request.Request{TargetRef: "refs/heads/master", Requester: "you", Draft: true}},
+		{Revision: "C", Request: request.Request{TargetRef: "refs/heads/master", Requester: "you"}},
+	}
+	filtered := FilterDrafts(reviews, "me")
+	if len(filtered) != 2 || filtered[0].Revision != "A" || filtered[1].Revision != "C" {
+		t.Fatalf("Unexpected reviews after filtering the drafts of other users: %v", filtered)
+	}
+	filtered = FilterDrafts(reviews, "")
+	if len(filtered) != 1 || filtered[0].Revision != "C" {
+		t.Fatalf("Unexpected reviews after filtering all drafts: %v", filtered)
+	}
+}
+
+func TestListAllWorkers(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	serial, err := unsortedListAll(context.Background(), repo, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parallel, err := unsortedListAll(context.Background(), repo, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serial) != 3 || len(parallel) != len(serial) {
+		t.Fatalf("Unexpected number of reviews: %d serially and %d in parallel", len(serial), len(parallel))
+	}
+	for i := range serial {
+		if serial[i].Revision != parallel[i].Revision || serial[i].Submitted != parallel[i].Submitted {
+			t.Fatalf("Mismatched reviews at index %d: %v vs %v", i, serial[i], parallel[i])
+		}
+	}
+}
+
+func TestUnknownFieldsPreserved(t *testing.T) {
+	r, err := request.Parse(repository.Note(`{"targetRef": "refs/heads/master", "description": "Old", "priority": "high"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Description = "New"
+	note, err := r.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(note) != `{"targetRef":"refs/heads/master","description":"New","priority":"high"}` {
+		t.Fatalf("Unexpected rewritten request: %s", note)
+	}
+
+	note = repository.Note(`{"timestamp": "0000000001", "author": "alice", "description": "Nit", "severity": "minor"}`)
+	c, err := comment.Parse(note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := c.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := comment.ParseAllValid([]repository.Note{note})[hash]; !ok {
+		t.Fatalf("The comment was not found by its hash %q", hash)
+	}
+	if written, err := c.Write(); err != nil || !strings.Contains(string(written), `"severity":"minor"`) {
+		t.Fatalf("Unexpected rewritten comment: %s, %v", written, err)
+	}
+}
+
+func TestListAllContext(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	reviews, err := ListAllContext(context.Background(), repo)
+	if err != nil || len(reviews) != 3 {
+		t.Fatalf("Unexpected reviews: %v, %v", reviews, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ListOpenContext(ctx, repo); err != context.Canceled {
+		t.Fatalf("Unexpected error listing the reviews with a cancelled context: %v", err)
+	}
+}
+
+func TestStreamReviews(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	listed, err := ListAllContext(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{1, 8} {
+		var streamed []Summary
+		if err := streamReviews(context.Background(), repo, 0, workers, func(r Summary) bool {
+			streamed = append(streamed, r)
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(streamed) != len(listed) {
+			t.Fatalf("Unexpected number of reviews streamed by %d workers: %d", workers, len(streamed))
+		}
+		for i := range listed {
+			if streamed[i].Revision != listed[i].Revision || streamed[i].Submitted != listed[i].Submitted {
+				t.Fatalf("Mismatched reviews at index %d: %v vs %v", i, streamed[i], listed[i])
+			}
+		}
+	}
+
+	var visited []string
+	if err := StreamContext(context.Background(), repo, 0, func(r Summary) bool {
+		visited = append(visited, r.Revision)
+		return false
+	}); err != nil || len(visited) != 1 || visited[0] != repository.TestCommitG {
+		t.Fatalf("Unexpected reviews visited before stopping: %v, %v", visited, err)
+	}
+	visited = nil
+	if err := StreamContext(context.Background(), repo, 2, func(r Summary) bool {
+		visited = append(visited, r.Revision)
+		return true
+	}); err != nil || len(visited) != 2 || visited[1] != repository.TestCommitD {
+		t.Fatalf("Unexpected reviews requested since time 2: %v, %v", visited, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := StreamContext(ctx, repo, 0, func(r Summary) bool { return true }); err != context.Canceled {
+		t.Fatalf("Unexpected error streaming the reviews with a cancelled context: %v", err)
+	}
+}
+
+func TestErrorTypes(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	if _, err := Get(repo, "not-a-commit"); !IsNotFound(err) {
+		t.Fatalf("Unexpected error for a missing commit: %v", err)
+	}
+	_, err := Get(repo, repository.TestCommitA)
+	if _, ok := err.(*NoReviewError); !ok || !IsNotFound(err) {
+		t.Fatalf("Unexpected error for a commit without a review: %v", err)
+	}
+	if IsNotFound(&AmbiguousError{Name: "abcd", Matches: 2}) {
+		t.Fatal("Unexpected not found error for an ambiguous name")
+	}
+}
+
+func TestRecordPatchset(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	pendingReview, err := Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchset, err := pendingReview.RecordPatchset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := pendingReview.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patchset == nil || patchset.Commit != head {
+		t.Fatalf("Unexpected patchset recorded: %v", patchset)
+	}
+	if patchset, err := pendingReview.RecordPatchset(); err != nil || patchset != nil {
+		t.Fatalf("Recorded a duplicate patchset: %v, %v", patchset, err)
+	}
+
+	reloaded, err := Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Request.Patchsets) != 1 || reloaded.Request.Patchsets[0].Commit != head {
+		t.Fatalf("Unexpected patchsets after reloading the review: %v", reloaded.Request.Patchsets)
+	}
+	if _, err := reloaded.GetPatchset(2); err == nil {
+		t.Fatal("Unexpectedly found a patchset that was never recorded")
+	}
+}
+
+func TestLastReviewedPatchset(t *testing.T) {
+	r := Summary{
+		Request: request.Request{Patchsets: []request.Patchset{
+			{Timestamp: "0000000001", Commit: "A"},
+			{Timestamp: "0000000003", Commit: "B"},
+			{Timestamp: "0000000005", Commit: "C"},
+		}},
+		Comments: []CommentThread{{
+			Comment: comment.Comment{Timestamp: "0000000002", Author: "alice"},
+			Children: []CommentThread{{
+				Comment: comment.Comment{Timestamp: "0000000004", Author: "bob"},
+			}},
+		}},
+	}
+	if n := r.LastReviewedPatchset("alice"); n != 1 {
+		t.Errorf("Unexpected patchset last reviewed by alice: %d", n)
+	}
+	if n := r.LastReviewedPatchset("bob"); n != 2 {
+		t.Errorf("Unexpected patchset last reviewed by bob: %d", n)
+	}
+	if n := r.LastReviewedPatchset("carol"); n != 0 {
+		t.Errorf("Unexpected patchset last reviewed by carol: %d", n)
+	}
+}
+
+func TestShortIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "short-ids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "short-ids.gob")
+	repo := repository.NewMockRepoForTest()
+	shortIDs, err := LoadShortIDs(repo, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shortIDs.ID(repository.TestCommitB) != 1 || shortIDs.ID(repository.TestCommitD) != 2 || shortIDs.ID(repository.TestCommitG) != 3 {
+		t.Fatalf("The short IDs were not assigned in the order of the requests: %v", shortIDs.Revisions)
+	}
+	reloaded, err := LoadShortIDs(repo, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Revision(3) != repository.TestCommitG {
+		t.Fatalf("The short IDs were not persisted: %v", reloaded.Revisions)
+	}
+	if revision, err := Resolve(repo, "#2", reloaded); err != nil || revision != repository.TestCommitD {
+		t.Fatalf("Failed to resolve a short ID: %q, %v", revision, err)
+	}
+	if revision, err := Resolve(repo, "no-such-ref", nil); err != nil || revision != "no-such-ref" {
+		t.Fatalf("Changed a name that does not identify a commit: %q, %v", revision, err)
+	}
+}
+
+// extraReviewRepo adds a review with the given revision to the revisions that have requests.
+type extraReviewRepo struct {
+	repository.Repo
+	revision string
+}
+
+func (r extraReviewRepo) ListNotedRevisions(notesRef string) []string {
+	revisions := r.Repo.ListNotedRevisions(notesRef)
+	if notesRef == request.Ref {
+		revisions = append(revisions, r.revision)
+	}
+	return revisions
+}
+
+func TestResolveShortIDThatIsARevisionPrefix(t *testing.T) {
+	repo := extraReviewRepo{repository.NewMockRepoForTest(), "1234abcd"}
+	shortIDs := &ShortIDs{Revisions: make([]string, 1234)}
+	shortIDs.Revisions[1233] = repository.TestCommitD
+	if revision, err := Resolve(repo, "1234", shortIDs); err == nil {
+		t.Fatalf("Resolved a name that is both a short ID and a revision prefix to %q", revision)
+	}
+	if revision, err := Resolve(repo, "#1234", shortIDs); err != nil || revision != repository.TestCommitD {
+		t.Fatalf("Failed to resolve a short ID: %q, %v", revision, err)
+	}
+	if revision, err := Resolve(repo, "1234", nil); err != nil || revision != "1234abcd" {
+		t.Fatalf("Failed to resolve a revision prefix: %q, %v", revision, err)
+	}
+}
+
+// onlyAuthorsRedact lets the authors of comments, and no one else, redact them.
+func onlyAuthorsRedact(redactor, author string) bool {
+	return redactor == author
+}
+
+func TestRedact(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	if err := repo.SetConfig(RedactorsConfig, false, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leak := comment.New("ojarjur", "password=hunter2")
+	leakHash, err := leak.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := comment.New("someone", "Please redact that")
+	reply.Parent = leakHash
+	for _, c := range []comment.Comment{leak, comment.NewRevision("ojarjur", leakHash, "still hunter2"), reply} {
+		if err := r.AddComment(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r, err = Get(repo, repository.TestCommitG); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Redact(leakHash, "someone", "leaked a password"); err == nil {
+		t.Fatal("Redacted the comment of another user without being a redactor")
+	}
+	if err := r.Redact(leakHash, "admin", "leaked a password"); err != nil {
+		t.Fatal(err)
+	}
+	for _, note := range repo.GetNotes(comment.Ref, repository.TestCommitG) {
+		if strings.Contains(string(note), "hunter2") {
+			t.Errorf("The redacted comment is still in the notes: %q", note)
+		}
+	}
+	redacted, err := Get(repo, repository.TestCommitG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := redacted.FindThread(leakHash)
+	if thread == nil || thread.Redacted == nil || thread.Redacted.Redaction.Reason != "leaked a password" {
+		t.Fatalf("Unexpected redacted thread: %+v", thread)
+	}
+	if thread.Comment.Author != "" || thread.Comment.Timestamp != leak.Timestamp || thread.Comment.Description != "" || len(thread.Revisions) != 0 {
+		t.Errorf("Unexpected contents of the redacted comment: %+v", thread)
+	}
+	if len(thread.Children) != 1 || thread.Children[0].Comment.Description != "Please redact that" {
+		t.Errorf("The reply to the redacted comment was not kept: %+v", thread.Children)
+	}
+}
+
+func TestForgedRedactions(t *testing.T) {
+	resolved := true
+	approval := comment.Comment{
+		Timestamp:   "012345",
+		Author:      "boss",
+		Description: "Looks bad",
+	}
+	approvalHash, err := approval.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forge := func(original string) comment.Comment {
+		redaction := comment.NewRedaction("mallory", original, approval, "")
+		redaction.Redaction.Comment.Author = "boss"
+		redaction.Redaction.Comment.Resolved = &resolved
+		return redaction
+	}
+	commentsByHash := map[string]comment.Comment{approvalHash: approval}
+	for _, redaction := range []comment.Comment{forge(approvalHash), forge("0123456789abcdef0123456789abcdef01234567")} {
+		redactionHash, err := redaction.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		commentsByHash[redactionHash] = redaction
+	}
+	threads := buildCommentThreads(commentsByHash, onlyAuthorsRedact)
+	if len(threads) != 1 || threads[0].Hash != approvalHash || threads[0].Redacted != nil || threads[0].Comment.Description != "Looks bad" {
+		t.Fatalf("A forged redaction was honored: %+v", threads)
+	}
+	if updateThreadsStatus(threads) != nil {
+		t.Errorf("A forged redaction resolved the review: %+v", threads)
+	}
+
+	mayRedact := func(redactor, author string) bool { return redactor == "mallory" }
+	threads = buildCommentThreads(commentsByHash, mayRedact)
+	if len(threads) != 1 || threads[0].Redacted == nil {
+		t.Fatalf("A redaction by a redactor was not honored: %+v", threads)
+	}
+	if c := threads[0].Comment; c.Author != "boss" || c.Resolved != nil || c.Description != "" {
+		t.Errorf("The redacted comment was not built from the original: %+v", c)
+	}
+}
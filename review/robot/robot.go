@@ -0,0 +1,266 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package robot defines the internal representation of comments generated by automated analyzers.
+//
+// Robot comments are kept separate from the comments written by people. Each
+// time an analyzer is run against a revision of a review, it records all of
+// its findings for that revision as a single run. A finding that was reported
+// by an earlier run of the same analyzer, but not by its latest run, is
+// considered to have been resolved by the revision of that latest run.
+package robot
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/analyses"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Ref defines the git-notes ref that we expect to contain robot comments.
+const Ref = "refs/notes/pullrequests/robot"
+
+// FormatVersion defines the latest version of the robot comment format supported by the tool.
+const FormatVersion = 0
+
+// The severities that a finding may have, from least to most severe.
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+var severityRanks = map[string]int{
+	SeverityInfo:    0,
+	SeverityWarning: 1,
+	SeverityError:   2,
+}
+
+// IsValidSeverity returns whether or not the given string is one of the known severities.
+func IsValidSeverity(severity string) bool {
+	_, ok := severityRanks[severity]
+	return ok
+}
+
+// Finding represents a single message reported by an analyzer.
+type Finding struct {
+	// Location is the location of the finding. If its commit is omitted, then
+	// the commit of the run that reported the finding is used.
+	Location    *comment.Location `json:"location,omitempty"`
+	Category    string            `json:"category,omitempty"`
+	Severity    string            `json:"severity,omitempty"`
+	Description string            `json:"description"`
+	// Fix is an optional suggested change that addresses the finding, in the unified diff format.
+	Fix string `json:"fix,omitempty"`
+}
+
+// Run represents all of the findings reported by a single analyzer for a single commit.
+//
+// Runs annotate the first revision in a review, so that they follow the
+// review as it is updated.
+type Run struct {
+	Timestamp string    `json:"timestamp,omitempty"`
+	Analyzer  string    `json:"analyzer"`
+	Commit    string    `json:"commit"`
+	Findings  []Finding `json:"findings,omitempty"`
+	// Version represents the version of the metadata format.
+	Version int `json:"v,omitempty"`
+}
+
+// NewRun returns a new run of the given analyzer against the given commit.
+//
+// The Timestamp field is automatically filled in with the current time.
+func NewRun(analyzer, commit string, findings []Finding) Run {
+	return Run{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Analyzer:  analyzer,
+		Commit:    commit,
+		Findings:  findings,
+	}
+}
+
+// FromAnalyses converts the notes from a static analysis report into findings.
+func FromAnalyses(notes []analyses.Note) []Finding {
+	var findings []Finding
+	for _, note := range notes {
+		finding := Finding{
+			Category:    note.Category,
+			Description: note.Description,
+			Severity:    SeverityWarning,
+		}
+		switch note.Severity {
+		case "error":
+			finding.Severity = SeverityError
+		case "note", "none":
+			finding.Severity = SeverityInfo
+		}
+		if note.Location != nil {
+			finding.Location = &comment.Location{Path: note.Location.Path}
+			if note.Location.Range != nil && note.Location.Range.StartLine > 0 {
+				finding.Location.Range = &comment.Range{StartLine: uint32(note.Location.Range.StartLine)}
+			}
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+// ParseFindings parses a list of findings, which may either be a JSON list or a SARIF log.
+//
+// Findings that do not specify a severity are treated as warnings.
+func ParseFindings(contents []byte) ([]Finding, error) {
+	if analyses.IsSARIF(contents) {
+		responses, err := analyses.ParseSARIF(contents)
+		if err != nil {
+			return nil, err
+		}
+		var notes []analyses.Note
+		for _, response := range responses {
+			notes = append(notes, response.Notes...)
+		}
+		return FromAnalyses(notes), nil
+	}
+	var findings []Finding
+	if err := json.Unmarshal(contents, &findings); err != nil {
+		return nil, fmt.Errorf("Failed to parse the findings: %v", err)
+	}
+	for i, finding := range findings {
+		if finding.Severity == "" {
+			findings[i].Severity = SeverityWarning
+		} else if !IsValidSeverity(finding.Severity) {
+			return nil, fmt.Errorf("Invalid severity %q; expected %q, %q, or %q.", finding.Severity,
+				SeverityInfo, SeverityWarning, SeverityError)
+		}
+	}
+	return findings, nil
+}
+
+// Parse parses a robot run from a git note.
+func Parse(note repository.Note) (Run, error) {
+	var run Run
+	err := json.Unmarshal([]byte(note), &run)
+	return run, err
+}
+
+// ParseAllValid takes collection of git notes and tries to parse a robot run
+// from each one. Any notes that are not valid runs get ignored.
+func ParseAllValid(notes []repository.Note) []Run {
+	var runs []Run
+	for _, note := range notes {
+		note, err := schema.Upgrade(Ref, note, FormatVersion)
+		if err != nil {
+			continue
+		}
+		run, err := Parse(note)
+		if err == nil && run.Version == FormatVersion && run.Analyzer != "" && run.Commit != "" {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// Write writes a robot run as a JSON-formatted git note.
+func (run Run) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(run)
+	return repository.Note(bytes), err
+}
+
+// Comment represents a finding, along with its status across the runs of its analyzer.
+type Comment struct {
+	// Hash identifies the finding across runs, regardless of the line it is reported on.
+	Hash     string `json:"hash"`
+	Analyzer string `json:"analyzer"`
+	Finding
+	// Commit is the commit of the latest run that reported the finding.
+	Commit   string `json:"commit"`
+	Resolved bool   `json:"resolved,omitempty"`
+	// ResolvedIn is the commit of the first run after which the finding was no longer reported.
+	ResolvedIn string `json:"resolvedIn,omitempty"`
+}
+
+// fingerprint returns the hash identifying a finding.
+//
+// The line number is left out, so that a finding is still recognized after
+// unrelated changes move it, and findings that would otherwise be identical
+// are told apart by the order in which they were reported.
+func fingerprint(analyzer string, finding Finding, occurrence int) string {
+	var path string
+	if finding.Location != nil {
+		path = finding.Location.Path
+	}
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%d", analyzer, finding.Category, path, finding.Description, occurrence)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(key)))
+}
+
+// Aggregate combines the given runs into the list of robot comments they describe.
+//
+// Open comments are listed before resolved ones, with the most severe first.
+func Aggregate(runs []Run) []Comment {
+	sorted := append([]Run(nil), runs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return schema.CompareTimestamps(sorted[i].Timestamp, sorted[j].Timestamp) < 0
+	})
+	comments := make(map[string]*Comment)
+	var order []string
+	for _, run := range sorted {
+		reported := make(map[string]bool)
+		occurrences := make(map[string]int)
+		for _, finding := range run.Findings {
+			base := fingerprint(run.Analyzer, finding, 0)
+			hash := fingerprint(run.Analyzer, finding, occurrences[base])
+			occurrences[base]++
+			if finding.Location != nil && finding.Location.Commit == "" {
+				location := *finding.Location
+				location.Commit = run.Commit
+				finding.Location = &location
+			}
+			if _, ok := comments[hash]; !ok {
+				order = append(order, hash)
+			}
+			comments[hash] = &Comment{
+				Hash:     hash,
+				Analyzer: run.Analyzer,
+				Finding:  finding,
+				Commit:   run.Commit,
+			}
+			reported[hash] = true
+		}
+		for _, c := range comments {
+			if c.Analyzer == run.Analyzer && !c.Resolved && !reported[c.Hash] {
+				c.Resolved = true
+				c.ResolvedIn = run.Commit
+			}
+		}
+	}
+
+	var result []Comment
+	for _, hash := range order {
+		result = append(result, *comments[hash])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Resolved != result[j].Resolved {
+			return !result[i].Resolved
+		}
+		return severityRanks[result[i].Severity] > severityRanks[result[j].Severity]
+	})
+	return result
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package robot
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	notes := []repository.Note{
+		repository.Note(`{"timestamp": "0000000001", "analyzer": "vet", "commit": "A", "findings": [
+			{"location": {"path": "foo.go", "range": {"startLine": 3}}, "category": "printf", "severity": "warning", "description": "bad format"},
+			{"location": {"path": "bar.go", "range": {"startLine": 7}}, "category": "unused", "severity": "error", "description": "unused variable"}
+		]}`),
+		repository.Note(`{"timestamp": "0000000002", "analyzer": "lint", "commit": "A", "findings": [
+			{"location": {"path": "foo.go"}, "severity": "info", "description": "missing doc comment"}
+		]}`),
+		repository.Note(`{"timestamp": "0000000003", "analyzer": "vet", "commit": "B", "findings": [
+			{"location": {"path": "foo.go", "range": {"startLine": 5}}, "category": "printf", "severity": "warning", "description": "bad format"}
+		]}`),
+		repository.Note(`{"timestamp": "0000000004", "commit": "B"}`),
+		repository.Note(`not a robot comment`),
+	}
+	comments := Aggregate(ParseAllValid(notes))
+	if len(comments) != 3 {
+		t.Fatalf("Unexpected robot comments: %v", comments)
+	}
+
+	printf, lint, unused := comments[0], comments[1], comments[2]
+	if printf.Category != "printf" || printf.Resolved || printf.Commit != "B" ||
+		printf.Location.Commit != "B" || printf.Location.Range.StartLine != 5 {
+		t.Errorf("Unexpected comment for a finding that moved: %v", printf)
+	}
+	if lint.Analyzer != "lint" || lint.Resolved {
+		t.Errorf("A later run of one analyzer resolved the findings of another: %v", lint)
+	}
+	if unused.Category != "unused" || !unused.Resolved || unused.ResolvedIn != "B" {
+		t.Errorf("Unexpected comment for a finding that was fixed: %v", unused)
+	}
+}
+
+func TestAggregateDuplicates(t *testing.T) {
+	finding := Finding{Description: "line too long"}
+	comments := Aggregate([]Run{
+		{Timestamp: "0000000001", Analyzer: "lint", Commit: "A", Findings: []Finding{finding, finding}},
+		{Timestamp: "0000000002", Analyzer: "lint", Commit: "B", Findings: []Finding{finding}},
+	})
+	if len(comments) != 2 || comments[0].Resolved || !comments[1].Resolved {
+		t.Errorf("Unexpected robot comments for duplicate findings: %v", comments)
+	}
+}
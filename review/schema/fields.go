@@ -0,0 +1,136 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// knownFieldsCache maps each struct type to the lower-cased names of its JSON fields.
+var knownFieldsCache sync.Map
+
+// knownFields returns the lower-cased names of the JSON fields of the given struct type.
+//
+// The names are lower-cased because encoding/json matches object keys to
+// fields without regard to case.
+func knownFields(t reflect.Type) map[string]bool {
+	if fields, ok := knownFieldsCache.Load(t); ok {
+		return fields.(map[string]bool)
+	}
+	fields := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embedded := range knownFields(field.Type) {
+				fields[embedded] = true
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = true
+	}
+	knownFieldsCache.Store(t, fields)
+	return fields
+}
+
+// unknownFields returns the top-level fields of the given JSON object that do
+// not correspond to any field of the given struct type.
+func unknownFields(data []byte, t reflect.Type) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	known := knownFields(t)
+	var unknown map[string]json.RawMessage
+	for name, value := range fields {
+		if !known[strings.ToLower(name)] {
+			if unknown == nil {
+				unknown = make(map[string]json.RawMessage)
+			}
+			unknown[name] = value
+		}
+	}
+	return unknown, nil
+}
+
+// Decode parses the given JSON object into v, which must point to a struct,
+// and returns the top-level fields of the object that the struct has no field
+// for, such as those added by a newer version of the tool.
+//
+// If there are no such fields, then it returns nil. Types that keep these
+// fields so as to write them back out should call this from their
+// UnmarshalJSON method, using a type without that method for v.
+func Decode(data []byte, v interface{}) (map[string]json.RawMessage, error) {
+	// Almost every note only has known fields, so check for that first to avoid decoding it twice.
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err == nil {
+		return nil, nil
+	}
+	value := reflect.ValueOf(v).Elem()
+	value.Set(reflect.Zero(value.Type()))
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	return unknownFields(data, value.Type())
+}
+
+// AppendFields adds the given fields, in the order of their names, to the end of the given JSON object.
+//
+// The fields must not already be in the object, which holds for the fields
+// returned by Decode when the object was serialized from the same struct.
+func AppendFields(data []byte, fields map[string]json.RawMessage) ([]byte, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.Write(bytes.TrimSuffix(bytes.TrimSpace(data), []byte("}")))
+	empty := bytes.Equal(bytes.TrimSpace(buf.Bytes()), []byte("{"))
+	for _, name := range names {
+		if !empty {
+			buf.WriteByte(',')
+		}
+		empty = false
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		if err := json.Compact(&buf, fields[name]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package schema upgrades notes written in older versions of their format.
+//
+// Each kind of note is stored under its own notes ref, and carries the
+// version of its format in its "v" field, which is zero when omitted. When the
+// format of a kind of note changes, its FormatVersion is incremented and a
+// Migration from the previous version is registered for its ref. Notes are
+// then upgraded whenever they are read, by applying the migrations in turn,
+// so that old notes are never stranded; the "migrate" command can also
+// rewrite them in place.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"sync"
+)
+
+// Migration upgrades the notes under a single ref from one format version to the next.
+type Migration struct {
+	// Ref is the notes ref holding the notes that the migration applies to.
+	Ref string
+	// From is the version that the migration upgrades from, to From+1.
+	From int
+	// Upgrade changes the top-level fields of a note in place. It does not need to update the "v" field.
+	Upgrade func(fields map[string]json.RawMessage) error
+}
+
+// Registry holds the migrations for every kind of note.
+type Registry struct {
+	mu         sync.RWMutex
+	migrations map[string]map[int]Migration
+}
+
+// Register adds a migration to the registry.
+//
+// It panics if a migration from the same version of the same ref was already registered.
+func (r *Registry) Register(m Migration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.migrations == nil {
+		r.migrations = make(map[string]map[int]Migration)
+	}
+	if r.migrations[m.Ref] == nil {
+		r.migrations[m.Ref] = make(map[int]Migration)
+	}
+	if _, ok := r.migrations[m.Ref][m.From]; ok {
+		panic(fmt.Sprintf("schema: duplicate migration of %s from version %d", m.Ref, m.From))
+	}
+	r.migrations[m.Ref][m.From] = m
+}
+
+// version returns the format version of the given note.
+func version(note repository.Note) (int, error) {
+	var versioned struct {
+		Version int `json:"v"`
+	}
+	err := json.Unmarshal([]byte(note), &versioned)
+	return versioned.Version, err
+}
+
+// Upgrade returns the given note from the given ref, upgraded to the latest version of its format.
+//
+// Notes that are already at the latest version are returned unchanged. Notes
+// at a newer version than the latest one, or at a version with no path of
+// migrations to the latest one, result in an error matching
+// repository.ErrSchemaVersion.
+func (r *Registry) Upgrade(ref string, note repository.Note, latest int) (repository.Note, error) {
+	r.mu.RLock()
+	migrations := r.migrations[ref]
+	r.mu.RUnlock()
+	if len(migrations) == 0 {
+		// Nothing can be upgraded, so leave it to the parser to check the version.
+		return note, nil
+	}
+	v, err := version(note)
+	if err != nil {
+		return nil, err
+	}
+	if v == latest {
+		return note, nil
+	}
+	if v > latest {
+		return nil, &repository.SchemaVersionError{Kind: ref + " note", Version: v, Supported: latest}
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(note), &fields); err != nil {
+		return nil, err
+	}
+	for ; v < latest; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("There is no migration of the %s notes from version %d: %w", ref, v, repository.ErrSchemaVersion)
+		}
+		if err := m.Upgrade(fields); err != nil {
+			return nil, fmt.Errorf("Failed to upgrade a %s note from version %d: %v", ref, v, err)
+		}
+		fields["v"] = json.RawMessage(fmt.Sprint(v + 1))
+	}
+	upgraded, err := json.Marshal(fields)
+	return repository.Note(upgraded), err
+}
+
+// Default is the registry used by the parsers of every kind of note.
+var Default = &Registry{}
+
+// Register adds a migration to the default registry.
+func Register(m Migration) {
+	Default.Register(m)
+}
+
+// Upgrade upgrades the given note using the default registry.
+func Upgrade(ref string, note repository.Note, latest int) (repository.Note, error) {
+	return Default.Upgrade(ref, note, latest)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/promet/git-appraise/repository"
+	"testing"
+)
+
+const testRef = "refs/notes/test"
+
+func testRegistry() *Registry {
+	r := &Registry{}
+	r.Register(Migration{Ref: testRef, From: 0, Upgrade: func(fields map[string]json.RawMessage) error {
+		fields["description"] = fields["desc"]
+		delete(fields, "desc")
+		return nil
+	}})
+	r.Register(Migration{Ref: testRef, From: 1, Upgrade: func(fields map[string]json.RawMessage) error {
+		fields["reviewers"] = json.RawMessage(`[]`)
+		return nil
+	}})
+	return r
+}
+
+func TestUpgrade(t *testing.T) {
+	r := testRegistry()
+	upgraded, err := r.Upgrade(testRef, repository.Note(`{"desc":"Fix it"}`), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(upgraded) != `{"description":"Fix it","reviewers":[],"v":2}` {
+		t.Fatalf("Unexpected upgraded note: %s", upgraded)
+	}
+	upgraded, err = r.Upgrade(testRef, repository.Note(`{"description":"Fix it","v":1}`), 2)
+	if err != nil || string(upgraded) != `{"description":"Fix it","reviewers":[],"v":2}` {
+		t.Fatalf("Unexpected note upgraded from version 1: %s, %v", upgraded, err)
+	}
+	current := repository.Note(`{"description":"Fix it", "v": 2}`)
+	if upgraded, err := r.Upgrade(testRef, current, 2); err != nil || string(upgraded) != string(current) {
+		t.Fatalf("A note in the latest format was changed: %s, %v", upgraded, err)
+	}
+	if upgraded, err := r.Upgrade("refs/notes/other", repository.Note(`{"v": 7}`), 0); err != nil || string(upgraded) != `{"v": 7}` {
+		t.Fatalf("A note without any migrations was changed: %s, %v", upgraded, err)
+	}
+}
+
+func TestUpgradeErrors(t *testing.T) {
+	r := testRegistry()
+	if _, err := r.Upgrade(testRef, repository.Note(`{"v": 3}`), 2); !errors.Is(err, repository.ErrSchemaVersion) {
+		t.Fatalf("Unexpected error upgrading a note from a newer version: %v", err)
+	}
+	if _, err := r.Upgrade(testRef, repository.Note(`{"v": 1}`), 3); !errors.Is(err, repository.ErrSchemaVersion) {
+		t.Fatalf("Unexpected error upgrading a note without a path of migrations: %v", err)
+	}
+	if _, err := r.Upgrade(testRef, repository.Note(`not json`), 2); err == nil {
+		t.Fatal("Unexpected success upgrading a malformed note")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Registering a duplicate migration did not panic")
+		}
+	}()
+	r.Register(Migration{Ref: testRef, From: 1})
+}
+
+type testNote struct {
+	Description string `json:"description,omitempty"`
+	Version     int    `json:"v,omitempty"`
+	hidden      string
+}
+
+func TestDecodeAndAppendFields(t *testing.T) {
+	var note testNote
+	unknown, err := Decode([]byte(`{"Description": "Fix it", "v": 1}`), &note)
+	if err != nil || unknown != nil || note.Description != "Fix it" || note.Version != 1 {
+		t.Fatalf("Unexpected result of decoding a note with only known fields: %+v, %v, %v", note, unknown, err)
+	}
+	unknown, err = Decode([]byte(`{"priority": "high", "description": "Fix it", "hidden": "x", "tags": ["a"]}`), &note)
+	if err != nil || note.Description != "Fix it" || note.Version != 0 || len(unknown) != 3 {
+		t.Fatalf("Unexpected result of decoding a note with unknown fields: %+v, %v, %v", note, unknown, err)
+	}
+	data, err := json.Marshal(note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err = AppendFields(data, unknown); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"description":"Fix it","hidden":"x","priority":"high","tags":["a"]}` {
+		t.Fatalf("Unexpected note with the unknown fields appended: %s", data)
+	}
+	if data, err := AppendFields([]byte(`{}`), unknown); err != nil || string(data) != `{"hidden":"x","priority":"high","tags":["a"]}` {
+		t.Fatalf("Unexpected empty note with the unknown fields appended: %s, %v", data, err)
+	}
+	if _, err := Decode([]byte(`not json`), &note); err == nil {
+		t.Fatal("Unexpected success decoding a malformed note")
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	parsed, err := ParseTimestamp("2024-03-01T12:00:00+09:00")
+	if err != nil || parsed.Unix() != 1709262000 {
+		t.Fatalf("Unexpected result parsing an RFC 3339 timestamp: %v, %v", parsed, err)
+	}
+	if parsed, err := ParseTimestamp("0001709262000"); err != nil || parsed.Unix() != 1709262000 {
+		t.Fatalf("Unexpected result parsing a Unix timestamp: %v, %v", parsed, err)
+	}
+	if _, err := ParseTimestamp("yesterday"); err == nil {
+		t.Fatal("Unexpected success parsing an invalid timestamp")
+	}
+	for _, test := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"9", "10", -1},
+		{"1709262000", "2024-03-01T12:00:00+09:00", 0},
+		{"2024-03-01T03:00:01Z", "2024-03-01T12:00:00+09:00", 1},
+		{"2024-03-01T12:00:00.5+09:00", "1709262000", 1},
+		{"", "1", -1},
+		{"b", "a", 1},
+	} {
+		if actual := CompareTimestamps(test.a, test.b); actual != test.expected {
+			t.Errorf("Unexpected comparison of %q and %q: %d", test.a, test.b, actual)
+		}
+	}
+}
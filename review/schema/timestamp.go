@@ -0,0 +1,62 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTimestamp parses the timestamp of a note.
+//
+// Timestamps are written as the number of seconds since the Unix epoch, but
+// any note may instead use an RFC 3339 timestamp, which records the writer's
+// time zone and may have a fractional second.
+func ParseTimestamp(timestamp string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid timestamp %q; expected the seconds since the Unix epoch, or an RFC 3339 time.", timestamp)
+	}
+	return t, nil
+}
+
+// CompareTimestamps returns -1, 0, or 1 depending on whether the first note timestamp is before, the same as, or after the second.
+//
+// Timestamps that cannot be parsed sort before all the others, and among
+// themselves in alphabetical order.
+func CompareTimestamps(a, b string) int {
+	aTime, aErr := ParseTimestamp(a)
+	bTime, bErr := ParseTimestamp(b)
+	switch {
+	case aErr != nil && bErr != nil:
+		return strings.Compare(a, b)
+	case aErr != nil:
+		return -1
+	case bErr != nil:
+		return 1
+	case aTime.Before(bTime):
+		return -1
+	case aTime.After(bTime):
+		return 1
+	}
+	return 0
+}
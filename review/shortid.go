@@ -0,0 +1,249 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/request"
+	"github.com/promet/git-appraise/review/schema"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ShortIDs is a local index assigning each review a small, sequential number.
+//
+// The numbers are only meaningful within the clone that assigned them, as
+// each clone numbers the reviews in the order in which it first sees them.
+type ShortIDs struct {
+	// Revisions holds the revision of each review, in the order of their short IDs, which start at one.
+	Revisions []string
+	ids       map[string]int
+}
+
+// ParseShortID parses a short ID, which may be prefixed by a "#".
+//
+// If the given string is not a short ID, then this returns zero.
+func ParseShortID(id string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(id, "#"))
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+// assign gives the next short ID to the given revision, if it does not have one yet.
+func (s *ShortIDs) assign(revision string) bool {
+	if s.ids[revision] != 0 {
+		return false
+	}
+	s.Revisions = append(s.Revisions, revision)
+	s.ids[revision] = len(s.Revisions)
+	return true
+}
+
+// ID returns the short ID of the given review, or zero if it does not have one.
+func (s *ShortIDs) ID(revision string) int {
+	if s == nil {
+		return 0
+	}
+	return s.ids[revision]
+}
+
+// Revision returns the revision of the review with the given short ID, or an empty string if there is none.
+func (s *ShortIDs) Revision(id int) string {
+	if s == nil || id <= 0 || id > len(s.Revisions) {
+		return ""
+	}
+	return s.Revisions[id-1]
+}
+
+// firstRequested returns the timestamp of the earliest request of the review.
+func firstRequested(r *Summary) string {
+	first := r.Request.Timestamp
+	for _, req := range r.AllRequests {
+		if req.Timestamp != "" && (first == "" || schema.CompareTimestamps(req.Timestamp, first) < 0) {
+			first = req.Timestamp
+		}
+	}
+	return first
+}
+
+// update assigns short IDs to any reviews in the repo that do not have one, in the order in which they were requested.
+func (s *ShortIDs) update(repo repository.Repo) bool {
+	missing := false
+	for _, revision := range repo.ListNotedRevisions(request.Ref) {
+		if s.ids[revision] == 0 {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return false
+	}
+	reviews, _ := unsortedListAll(context.Background(), repo, runtime.NumCPU())
+	sort.SliceStable(reviews, func(i, j int) bool {
+		if c := schema.CompareTimestamps(firstRequested(&reviews[i]), firstRequested(&reviews[j])); c != 0 {
+			return c < 0
+		}
+		return reviews[i].Revision < reviews[j].Revision
+	})
+	changed := false
+	for _, r := range reviews {
+		changed = s.assign(r.Revision) || changed
+	}
+	return changed
+}
+
+// LoadShortIDs reads the index of short IDs from the given file, and assigns IDs to any new reviews in the repo.
+//
+// If any IDs were assigned, then the index is written back to the file.
+func LoadShortIDs(repo repository.Repo, file string) (*ShortIDs, error) {
+	s := &ShortIDs{}
+	if f, err := os.Open(file); err == nil {
+		err = gob.NewDecoder(f).Decode(s)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the short IDs from %q: %v", file, err)
+		}
+	}
+	s.ids = make(map[string]int)
+	for i, revision := range s.Revisions {
+		s.ids[revision] = i + 1
+	}
+	if s.update(repo) {
+		if err := s.save(file); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Remap gives the short ID of each review in the mapping to the revision that it maps to, and writes the index to the given file.
+//
+// This keeps the short IDs of reviews whose commits were rewritten, such as by a rebase.
+func (s *ShortIDs) Remap(mapping map[string]string, file string) error {
+	changed := false
+	for i, revision := range s.Revisions {
+		newRevision, ok := mapping[revision]
+		if !ok || s.ids[newRevision] != 0 {
+			continue
+		}
+		s.Revisions[i] = newRevision
+		delete(s.ids, revision)
+		s.ids[newRevision] = i + 1
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return s.save(file)
+}
+
+// save writes the index to the given file.
+func (s *ShortIDs) save(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(s)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// isHex reports whether the given string consists only of hexadecimal digits.
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// minPrefixLength is the length of the shortest revision prefix that identifies a review, which matches git's.
+const minPrefixLength = 4
+
+// Resolve returns the revision of the review named by the given string.
+//
+// The name may be the short ID of a review (if shortIDs is not nil), a unique
+// prefix of its revision, or the branch under review. Any other name is
+// resolved to a commit in any of the ways that git understands, or returned
+// unchanged if it does not name one.
+func Resolve(repo repository.Repo, name string, shortIDs *ShortIDs) (string, error) {
+	shortIDRevision := shortIDs.Revision(ParseShortID(name))
+	if shortIDRevision != "" && strings.HasPrefix(name, "#") {
+		return shortIDRevision, nil
+	}
+	var prefixMatches []string
+	if len(name) >= minPrefixLength && isHex(name) {
+		for _, revision := range repo.ListNotedRevisions(request.Ref) {
+			if strings.HasPrefix(revision, strings.ToLower(name)) {
+				prefixMatches = append(prefixMatches, revision)
+			}
+		}
+	}
+	if shortIDRevision != "" {
+		// A bare number may also be a prefix of a revision, and only the "#" form of a short ID says which was meant.
+		if len(prefixMatches) > 0 {
+			return "", &AmbiguousError{Name: name, ShortID: true, Matches: len(prefixMatches)}
+		}
+		return shortIDRevision, nil
+	}
+	if len(prefixMatches) == 1 {
+		return prefixMatches[0], nil
+	}
+	if len(prefixMatches) > 1 {
+		return "", &AmbiguousError{Name: name, Matches: len(prefixMatches)}
+	}
+	ref := name
+	if !strings.HasPrefix(ref, "refs/") {
+		ref = "refs/heads/" + ref
+	}
+	var matches []string
+	for _, r := range ListOpen(repo) {
+		if r.Request.ReviewRef == ref {
+			matches = append(matches, r.Revision)
+		}
+	}
+	if len(matches) > 1 {
+		return "", &AmbiguousError{Name: ref, Ref: true, Matches: len(matches)}
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	if hash, err := repo.GetCommitHash(name); err == nil {
+		return hash, nil
+	}
+	return name, nil
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sidebyside lays out unified diffs in two columns, with the old
+// version of each file on the left and the new version on the right.
+//
+// The removed and added lines of each change are paired up in order, so that
+// each line is shown next to the one that replaced it. Comment threads are
+// anchored to a line in one of the columns: threads on removed lines (made on
+// the base commit of the diff, and so outdated for its head) belong in the
+// left column, and every other thread belongs in the right one.
+package sidebyside
+
+import (
+	"github.com/promet/git-appraise/review"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// Side is one of the two columns of a side-by-side diff.
+type Side int
+
+const (
+	// Old is the left column, which shows the old version of each file.
+	Old Side = iota
+	// New is the right column, which shows the new version of each file.
+	New
+)
+
+// Line is a line of a file shown in one column of a row.
+type Line struct {
+	// Number is the number of the line within its version of the file, or 0 if the column of the row is empty.
+	Number uint32
+	// Text is the contents of the line, without the prefix that marks the type of a line in a unified diff.
+	Text string
+	// Changed indicates that the line was removed (in the old column) or added (in the new column).
+	Changed bool
+}
+
+// Row is a single row of a side-by-side diff.
+type Row struct {
+	// Header is the text of a line that spans both columns, such as the
+	// header of a file or of a hunk. Rows with a header do not have any lines.
+	Header string
+	// OldPath and NewPath are the paths of the file in its old and new versions.
+	OldPath string
+	NewPath string
+	Old     Line
+	New     Line
+}
+
+// Anchor identifies a line in one column of a side-by-side diff.
+//
+// An anchor with a line number of 0 refers to a whole file.
+type Anchor struct {
+	Side Side
+	Path string
+	Line uint32
+}
+
+// Anchors returns the anchors of the lines in the row, left column first.
+//
+// The last line of the header of a file, which names its new version, is
+// where the threads on the whole file are anchored.
+func (row Row) Anchors() []Anchor {
+	if strings.HasPrefix(row.Header, "+++ ") {
+		path := row.NewPath
+		if path == "" {
+			path = row.OldPath
+		}
+		return []Anchor{{Side: New, Path: path}}
+	}
+	var anchors []Anchor
+	if row.Old.Number > 0 {
+		anchors = append(anchors, Anchor{Side: Old, Path: row.OldPath, Line: row.Old.Number})
+	}
+	if row.New.Number > 0 {
+		anchors = append(anchors, Anchor{Side: New, Path: row.NewPath, Line: row.New.Number})
+	}
+	return anchors
+}
+
+// trimPath returns the path named in the "---" or "+++" header line of a file, without its "a/" or "b/" prefix.
+func trimPath(line, prefix string) string {
+	path := strings.TrimPrefix(line[4:], prefix)
+	if path == "/dev/null" {
+		return ""
+	}
+	return path
+}
+
+// Split lays out the given unified diff as rows of a side-by-side diff.
+func Split(diff string) []Row {
+	var rows []Row
+	var oldPath, newPath string
+	var oldLine, newLine uint32
+	inHunk := false
+	// The removed lines that have not been paired with an added line yet.
+	var removed []Row
+	flush := func() {
+		rows = append(rows, removed...)
+		removed = nil
+	}
+	if diff == "" {
+		return nil
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff "):
+			flush()
+			inHunk = false
+			oldPath, newPath = "", ""
+			rows = append(rows, Row{Header: line})
+		case !inHunk && strings.HasPrefix(line, "--- "):
+			oldPath = trimPath(line, "a/")
+			rows = append(rows, Row{Header: line})
+		case !inHunk && strings.HasPrefix(line, "+++ "):
+			newPath = trimPath(line, "b/")
+			rows = append(rows, Row{Header: line, OldPath: oldPath, NewPath: newPath})
+		case hunkHeaderPattern.MatchString(line):
+			flush()
+			match := hunkHeaderPattern.FindStringSubmatch(line)
+			oldStart, _ := strconv.ParseUint(match[1], 10, 32)
+			newStart, _ := strconv.ParseUint(match[2], 10, 32)
+			oldLine, newLine = uint32(oldStart), uint32(newStart)
+			inHunk = true
+			rows = append(rows, Row{Header: line, OldPath: oldPath, NewPath: newPath})
+		case inHunk && strings.HasPrefix(line, "-"):
+			removed = append(removed, Row{
+				OldPath: oldPath,
+				NewPath: newPath,
+				Old:     Line{Number: oldLine, Text: line[1:], Changed: true},
+			})
+			oldLine++
+		case inHunk && strings.HasPrefix(line, "+"):
+			added := Line{Number: newLine, Text: line[1:], Changed: true}
+			newLine++
+			if len(removed) > 0 {
+				removed[0].New = added
+				rows = append(rows, removed[0])
+				removed = removed[1:]
+				continue
+			}
+			rows = append(rows, Row{OldPath: oldPath, NewPath: newPath, New: added})
+		case inHunk && strings.HasPrefix(line, " "):
+			flush()
+			rows = append(rows, Row{
+				OldPath: oldPath,
+				NewPath: newPath,
+				Old:     Line{Number: oldLine, Text: line[1:]},
+				New:     Line{Number: newLine, Text: line[1:]},
+			})
+			oldLine++
+			newLine++
+		default:
+			// Anything else, such as the extended header lines of a file, or
+			// the marker of a missing newline at the end of it, spans both columns.
+			flush()
+			if !strings.HasPrefix(line, "\\") {
+				inHunk = false
+			}
+			rows = append(rows, Row{Header: line, OldPath: oldPath, NewPath: newPath})
+		}
+	}
+	flush()
+	return rows
+}
+
+// ThreadAnchor returns the anchor of the given comment thread in the side-by-side diff from the given base commit.
+//
+// The thread's location is expected to have been remapped to the head of the
+// diff, as by review.RemapComments, so that only the threads left outdated on
+// the base commit are anchored in the old column. It returns false if the
+// thread is not anchored to any file.
+func ThreadAnchor(thread review.CommentThread, base string) (Anchor, bool) {
+	location := thread.Comment.Location
+	if location == nil || location.Path == "" {
+		return Anchor{}, false
+	}
+	anchor := Anchor{Side: New, Path: location.Path}
+	if location.Range != nil {
+		anchor.Line = location.Range.StartLine
+	}
+	if thread.Outdated {
+		if base == "" || location.Commit != base {
+			return Anchor{}, false
+		}
+		anchor.Side = Old
+	}
+	return anchor, true
+}
+
+// AnchorThreads groups the given comment threads by their anchors in the side-by-side diff from the given base commit.
+//
+// The threads that are not anchored to any file are returned separately.
+func AnchorThreads(threads []review.CommentThread, base string) (map[Anchor][]review.CommentThread, []review.CommentThread) {
+	anchored := make(map[Anchor][]review.CommentThread)
+	var unanchored []review.CommentThread
+	for _, thread := range threads {
+		if anchor, ok := ThreadAnchor(thread, base); ok {
+			anchored[anchor] = append(anchored[anchor], thread)
+		} else {
+			unanchored = append(unanchored, thread)
+		}
+	}
+	return anchored, unanchored
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidebyside
+
+import (
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"reflect"
+	"testing"
+)
+
+const testDiff = `diff --git a/main.go b/main.go
+index 1234567..89abcde 100644
+--- a/main.go
++++ b/main.go
+@@ -1,4 +1,4 @@
+ package main
+-func a() {}
+-func b() {}
++func c() {}
+ // end
++// more
+`
+
+func TestSplit(t *testing.T) {
+	rows := Split(testDiff)
+	if len(rows) != 10 {
+		t.Fatalf("Unexpected rows: %+v", rows)
+	}
+	for i := 0; i < 5; i++ {
+		if rows[i].Header == "" {
+			t.Errorf("Expected row %d to be a header: %+v", i, rows[i])
+		}
+	}
+	expected := []Row{
+		{OldPath: "main.go", NewPath: "main.go", Old: Line{1, "package main", false}, New: Line{1, "package main", false}},
+		{OldPath: "main.go", NewPath: "main.go", Old: Line{2, "func a() {}", true}, New: Line{2, "func c() {}", true}},
+		{OldPath: "main.go", NewPath: "main.go", Old: Line{3, "func b() {}", true}},
+		{OldPath: "main.go", NewPath: "main.go", Old: Line{4, "// end", false}, New: Line{3, "// end", false}},
+	}
+	if !reflect.DeepEqual(rows[5:], append(expected, Row{OldPath: "main.go", NewPath: "main.go", New: Line{4, "// more", true}})) {
+		t.Errorf("Unexpected lines: %+v", rows[5:])
+	}
+	if anchors := rows[3].Anchors(); len(anchors) != 1 || anchors[0] != (Anchor{Side: New, Path: "main.go"}) {
+		t.Errorf("Unexpected anchors of the file header: %v", anchors)
+	}
+	if Split("") != nil {
+		t.Error("Unexpected rows for an empty diff")
+	}
+}
+
+func TestAnchorThreads(t *testing.T) {
+	location := func(commit string, line uint32) *comment.Location {
+		return &comment.Location{Commit: commit, Path: "main.go", Range: &comment.Range{StartLine: line}}
+	}
+	threads := []review.CommentThread{
+		{Hash: "added", Comment: comment.Comment{Location: location("head", 2)}},
+		{Hash: "removed", Comment: comment.Comment{Location: location("base", 3)}, Outdated: true},
+		{Hash: "earlier", Comment: comment.Comment{Location: location("earlier", 3)}, Outdated: true},
+		{Hash: "review", Comment: comment.Comment{}},
+	}
+	anchored, unanchored := AnchorThreads(threads, "base")
+	if got := anchored[Anchor{Side: New, Path: "main.go", Line: 2}]; len(got) != 1 || got[0].Hash != "added" {
+		t.Errorf("Unexpected threads in the new column: %v", got)
+	}
+	if got := anchored[Anchor{Side: Old, Path: "main.go", Line: 3}]; len(got) != 1 || got[0].Hash != "removed" {
+		t.Errorf("Unexpected threads in the old column: %v", got)
+	}
+	if len(unanchored) != 2 || unanchored[0].Hash != "earlier" || unanchored[1].Hash != "review" {
+		t.Errorf("Unexpected unanchored threads: %v", unanchored)
+	}
+}
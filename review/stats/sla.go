@@ -0,0 +1,132 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stats
+
+import (
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/search"
+	"time"
+)
+
+// The objectives of an SLA.
+const (
+	// ObjectiveFirstResponse is the objective of a first review by someone other than the requester.
+	ObjectiveFirstResponse = "first-response"
+	// ObjectiveResolution is the objective of the review being submitted.
+	ObjectiveResolution = "resolution"
+)
+
+// SLA holds how soon after they are requested reviews are expected to meet each objective.
+//
+// A zero duration leaves the corresponding objective out of the SLA.
+type SLA struct {
+	FirstResponse time.Duration
+	Resolution    time.Duration
+}
+
+// Breach records that a review was late for one of the objectives of an SLA.
+type Breach struct {
+	Objective string `json:"objective"`
+	// Deadline is the time, in seconds since the epoch, by which the objective should have been met.
+	Deadline int64 `json:"deadline"`
+	// Overdue is the number of seconds past the deadline that the objective was met, or has gone unmet until now.
+	Overdue int64 `json:"overdue"`
+	// Met indicates that the objective has since been met, albeit late.
+	Met bool `json:"met,omitempty"`
+}
+
+// IsZero reports whether the SLA has no objectives.
+func (sla SLA) IsZero() bool {
+	return sla.FirstResponse <= 0 && sla.Resolution <= 0
+}
+
+// breach returns the breach of the objective with the given limit, if the
+// time it took to meet it (or until now, if it is unmet) exceeds the limit.
+func breach(objective string, limit time.Duration, created int64, elapsed *int64, now int64) []Breach {
+	if limit <= 0 {
+		return nil
+	}
+	deadline := created + int64(limit/time.Second)
+	b := Breach{Objective: objective, Deadline: deadline, Overdue: now - deadline}
+	if elapsed != nil {
+		b.Met = true
+		b.Overdue = created + *elapsed - deadline
+	}
+	if b.Overdue <= 0 {
+		return nil
+	}
+	return []Breach{b}
+}
+
+// breaches returns the objectives of the SLA that a review with the given metrics was late for.
+//
+// Drafts are not yet expected to meet any objectives, and abandoned reviews
+// are only held to those that they met before they were abandoned.
+func (sla SLA) breaches(status string, created int64, firstReview, merge *int64, now int64) []Breach {
+	if status == "draft" || created == 0 {
+		return nil
+	}
+	var result []Breach
+	if firstReview != nil || status != "abandoned" {
+		result = append(result, breach(ObjectiveFirstResponse, sla.FirstResponse, created, firstReview, now)...)
+	}
+	if merge != nil || status != "abandoned" {
+		result = append(result, breach(ObjectiveResolution, sla.Resolution, created, merge, now)...)
+	}
+	return result
+}
+
+// Check returns the objectives of the SLA that the review with the given metrics was late for, as of the given time.
+func (sla SLA) Check(stats ReviewStats, now time.Time) []Breach {
+	return sla.breaches(stats.Status, stats.Created, stats.TimeToFirstReview, stats.TimeToMerge, now.Unix())
+}
+
+// Overdue returns the objectives of the SLA that the given review is past due
+// for, and has not yet met, as of the given time.
+//
+// Unlike Check, this only needs the review's notes, and not its diff.
+func (sla SLA) Overdue(r *review.Summary, identities *identity.Map, now time.Time) []Breach {
+	if r.Submitted {
+		return nil
+	}
+	created := created(r)
+	var overdue []Breach
+	for _, b := range sla.breaches(search.Status(r), created, timeToFirstReview(r, created, identities), nil, now.Unix()) {
+		if !b.Met {
+			overdue = append(overdue, b)
+		}
+	}
+	return overdue
+}
+
+// ApplySLA records which objectives of the given SLA each review in the report was late for, as of the given time.
+func (report *Report) ApplySLA(sla SLA, now time.Time) {
+	report.Totals.FirstResponseBreaches = 0
+	report.Totals.ResolutionBreaches = 0
+	for i := range report.Reviews {
+		report.Reviews[i].Breaches = sla.Check(report.Reviews[i], now)
+		for _, b := range report.Reviews[i].Breaches {
+			switch b.Objective {
+			case ObjectiveFirstResponse:
+				report.Totals.FirstResponseBreaches++
+			case ObjectiveResolution:
+				report.Totals.ResolutionBreaches++
+			}
+		}
+	}
+}
@@ -0,0 +1,327 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package stats computes metrics about the code reviews in a repo.
+//
+// Every metric is derived from the review notes alone, plus the diffs of the
+// reviewed commits. The time to first review of a review is the time between
+// its first request and the first comment by anyone other than its requester.
+// As submitting a review does not write any notes, the time to merge of a
+// submitted review is measured up to the later of the commit time of its head
+// commit, and the time its request was last updated.
+package stats
+
+import (
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/schema"
+	"github.com/promet/git-appraise/search"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReviewStats holds the metrics of a single review.
+type ReviewStats struct {
+	Revision  string `json:"revision"`
+	Requester string `json:"requester"`
+	Status    string `json:"status"`
+	// Created is the time, in seconds since the epoch, at which the review was first requested.
+	Created int64 `json:"created"`
+	// TimeToFirstReview is the number of seconds until the first comment by a reviewer, if there has been one.
+	TimeToFirstReview *int64 `json:"timeToFirstReview,omitempty"`
+	// TimeToMerge is the number of seconds until the review was submitted, if it has been.
+	TimeToMerge  *int64 `json:"timeToMerge,omitempty"`
+	FilesChanged int    `json:"filesChanged"`
+	LinesAdded   int    `json:"linesAdded"`
+	LinesDeleted int    `json:"linesDeleted"`
+	Comments     int    `json:"comments"`
+	// Breaches are the objectives of the SLA that the review was late for, if an SLA was applied.
+	Breaches []Breach `json:"slaBreaches,omitempty"`
+}
+
+// ReviewerLoad holds how much reviewing work a single person has done.
+type ReviewerLoad struct {
+	Reviewer string `json:"reviewer"`
+	// Assigned is the number of reviews in which they were asked to review.
+	Assigned int `json:"assigned"`
+	// Reviewed is the number of other people's reviews that they commented on.
+	Reviewed int `json:"reviewed"`
+	// Comments is the number of comments they wrote on other people's reviews.
+	Comments int `json:"comments"`
+}
+
+// Totals aggregates the metrics of all of the reviews in a report.
+type Totals struct {
+	Reviews                 int     `json:"reviews"`
+	Submitted               int     `json:"submitted"`
+	MedianTimeToFirstReview *int64  `json:"medianTimeToFirstReview,omitempty"`
+	MedianTimeToMerge       *int64  `json:"medianTimeToMerge,omitempty"`
+	MedianLinesChanged      int     `json:"medianLinesChanged"`
+	CommentsPerReview       float64 `json:"commentsPerReview"`
+	// FirstResponseBreaches and ResolutionBreaches count the reviews that were late for each objective of the SLA.
+	FirstResponseBreaches int `json:"firstResponseBreaches,omitempty"`
+	ResolutionBreaches    int `json:"resolutionBreaches,omitempty"`
+}
+
+// Report holds the metrics of the reviews requested within a date range.
+type Report struct {
+	// Since and Until bound the creation times of the reviews, in seconds since the epoch; zero means unbounded.
+	Since     int64          `json:"since,omitempty"`
+	Until     int64          `json:"until,omitempty"`
+	Totals    Totals         `json:"totals"`
+	Reviews   []ReviewStats  `json:"reviews"`
+	Reviewers []ReviewerLoad `json:"reviewers"`
+}
+
+func parseTimestamp(timestamp string) int64 {
+	t, err := schema.ParseTimestamp(timestamp)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// created returns the time of the first request of the review.
+func created(r *review.Summary) int64 {
+	first := parseTimestamp(r.Request.Timestamp)
+	for _, req := range r.AllRequests {
+		if t := parseTimestamp(req.Timestamp); t != 0 && (first == 0 || t < first) {
+			first = t
+		}
+	}
+	return first
+}
+
+// lastRequestUpdate returns the time of the latest request of the review.
+func lastRequestUpdate(r *review.Summary) int64 {
+	latest := parseTimestamp(r.Request.Timestamp)
+	for _, req := range r.AllRequests {
+		if t := parseTimestamp(req.Timestamp); t > latest {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// visitComments calls the given function on every comment in the given threads, including replies.
+func visitComments(threads []review.CommentThread, visit func(thread review.CommentThread)) {
+	for _, thread := range threads {
+		visit(thread)
+		visitComments(thread.Children, visit)
+	}
+}
+
+// timeToFirstReview returns the number of seconds from the given creation time
+// of the review until its first comment by anyone other than its requester, or
+// nil if there has not been one.
+func timeToFirstReview(r *review.Summary, created int64, identities *identity.Map) *int64 {
+	var firstReview int64
+	visitComments(r.Comments, func(thread review.CommentThread) {
+		if identities.Same(thread.Comment.Author, r.Request.Requester) {
+			return
+		}
+		if t := parseTimestamp(thread.Comment.Timestamp); t != 0 && (firstReview == 0 || t < firstReview) {
+			firstReview = t
+		}
+	})
+	if firstReview == 0 {
+		return nil
+	}
+	elapsed := firstReview - created
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return &elapsed
+}
+
+// parseNumstat adds up the output of "git diff --numstat".
+//
+// Binary files count as changed files, but do not add any lines.
+func parseNumstat(numstat string) (files, added, deleted int) {
+	for _, line := range strings.Split(numstat, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		files++
+		if n, err := strconv.Atoi(fields[0]); err == nil {
+			added += n
+		}
+		if n, err := strconv.Atoi(fields[1]); err == nil {
+			deleted += n
+		}
+	}
+	return files, added, deleted
+}
+
+// NewReviewStats computes the metrics of the given review, attributing them to the canonical identities of the people involved.
+func NewReviewStats(r *review.Summary, identities *identity.Map) ReviewStats {
+	stats := ReviewStats{
+		Revision:  r.Revision,
+		Requester: identities.Resolve(r.Request.Requester),
+		Status:    search.Status(r),
+		Created:   created(r),
+	}
+	visitComments(r.Comments, func(thread review.CommentThread) {
+		stats.Comments++
+	})
+	stats.TimeToFirstReview = timeToFirstReview(r, stats.Created, identities)
+
+	// Only the commits of the review are needed, so none of its other notes are read.
+	details := &review.Review{Summary: r}
+	if r.Submitted {
+		merged := lastRequestUpdate(r)
+		if head, err := details.GetHeadCommit(); err == nil {
+			if commitTime, err := r.Repo.GetCommitTime(head); err == nil && parseTimestamp(commitTime) > merged {
+				merged = parseTimestamp(commitTime)
+			}
+		}
+		elapsed := merged - stats.Created
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		stats.TimeToMerge = &elapsed
+	}
+	if numstat, err := details.GetDiff("--numstat"); err == nil {
+		stats.FilesChanged, stats.LinesAdded, stats.LinesDeleted = parseNumstat(numstat)
+	}
+	return stats
+}
+
+// computeReviewerLoads tallies the reviewing work done by each person on the given reviews.
+func computeReviewerLoads(reviews []*review.Summary, identities *identity.Map) []ReviewerLoad {
+	loads := make(map[string]*ReviewerLoad)
+	load := func(reviewer string) *ReviewerLoad {
+		reviewer = identities.Resolve(reviewer)
+		key := strings.ToLower(reviewer)
+		if loads[key] == nil {
+			loads[key] = &ReviewerLoad{Reviewer: reviewer}
+		}
+		return loads[key]
+	}
+	for _, r := range reviews {
+		assigned := make(map[*ReviewerLoad]bool)
+		for _, reviewer := range r.Request.Reviewers {
+			if !identities.Same(reviewer, r.Request.Requester) {
+				assigned[load(reviewer)] = true
+			}
+		}
+		for l := range assigned {
+			l.Assigned++
+		}
+		commented := make(map[*ReviewerLoad]bool)
+		visitComments(r.Comments, func(thread review.CommentThread) {
+			if thread.Comment.Author == "" || identities.Same(thread.Comment.Author, r.Request.Requester) {
+				return
+			}
+			l := load(thread.Comment.Author)
+			l.Comments++
+			commented[l] = true
+		})
+		for l := range commented {
+			l.Reviewed++
+		}
+	}
+	var result []ReviewerLoad
+	for _, l := range loads {
+		result = append(result, *l)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Reviewed != result[j].Reviewed {
+			return result[i].Reviewed > result[j].Reviewed
+		}
+		if result[i].Assigned != result[j].Assigned {
+			return result[i].Assigned > result[j].Assigned
+		}
+		return result[i].Reviewer < result[j].Reviewer
+	})
+	return result
+}
+
+// median returns the median of the given values, or nil if there are none.
+func median(values []int64) *int64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	middle := values[len(values)/2]
+	if len(values)%2 == 0 {
+		middle = (values[len(values)/2-1] + middle) / 2
+	}
+	return &middle
+}
+
+// computeTotals aggregates the metrics of the given reviews.
+func computeTotals(reviews []ReviewStats) Totals {
+	totals := Totals{Reviews: len(reviews)}
+	var firstReviews, merges, sizes []int64
+	comments := 0
+	for _, r := range reviews {
+		if r.TimeToFirstReview != nil {
+			firstReviews = append(firstReviews, *r.TimeToFirstReview)
+		}
+		if r.TimeToMerge != nil {
+			totals.Submitted++
+			merges = append(merges, *r.TimeToMerge)
+		}
+		sizes = append(sizes, int64(r.LinesAdded+r.LinesDeleted))
+		comments += r.Comments
+	}
+	totals.MedianTimeToFirstReview = median(firstReviews)
+	totals.MedianTimeToMerge = median(merges)
+	if size := median(sizes); size != nil {
+		totals.MedianLinesChanged = int(*size)
+	}
+	if len(reviews) > 0 {
+		totals.CommentsPerReview = float64(comments) / float64(len(reviews))
+	}
+	return totals
+}
+
+// Compute returns the metrics of the given reviews that were requested within the given range.
+//
+// A zero time leaves the corresponding end of the range unbounded. Reviewers
+// with several identities are counted once, under their canonical identity.
+func Compute(reviews []review.Summary, since, until time.Time, identities *identity.Map) *Report {
+	report := &Report{
+		Reviews:   []ReviewStats{},
+		Reviewers: []ReviewerLoad{},
+	}
+	if !since.IsZero() {
+		report.Since = since.Unix()
+	}
+	if !until.IsZero() {
+		report.Until = until.Unix()
+	}
+	var selected []*review.Summary
+	for i := range reviews {
+		r := &reviews[i]
+		t := created(r)
+		if (report.Since != 0 && t < report.Since) || (report.Until != 0 && t >= report.Until) {
+			continue
+		}
+		selected = append(selected, r)
+		report.Reviews = append(report.Reviews, NewReviewStats(r, identities))
+	}
+	sort.SliceStable(report.Reviews, func(i, j int) bool { return report.Reviews[i].Created < report.Reviews[j].Created })
+	if loads := computeReviewerLoads(selected, identities); loads != nil {
+		report.Reviewers = loads
+	}
+	report.Totals = computeTotals(report.Reviews)
+	return report
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stats
+
+import (
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/identity"
+	"github.com/promet/git-appraise/review/request"
+	"testing"
+	"time"
+)
+
+func TestParseNumstat(t *testing.T) {
+	files, added, deleted := parseNumstat("3\t1\tmain.go\n-\t-\tlogo.png\n10\t0\tREADME.md\n")
+	if files != 3 || added != 13 || deleted != 1 {
+		t.Fatalf("Unexpected numstat totals: %d files, +%d, -%d", files, added, deleted)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	if m := median(nil); m != nil {
+		t.Fatalf("Unexpected median of no values: %d", *m)
+	}
+	if m := median([]int64{5, 1, 3}); m == nil || *m != 3 {
+		t.Fatalf("Unexpected median of an odd number of values: %v", m)
+	}
+	if m := median([]int64{4, 1, 3, 2}); m == nil || *m != 2 {
+		t.Fatalf("Unexpected median of an even number of values: %v", m)
+	}
+}
+
+func TestComputeReviewerLoads(t *testing.T) {
+	thread := func(author string, children ...review.CommentThread) review.CommentThread {
+		return review.CommentThread{Comment: comment.Comment{Author: author}, Children: children}
+	}
+	reviews := []*review.Summary{
+		{
+			Request:  request.Request{Requester: "alice", Reviewers: []string{"bob", "carol"}},
+			Comments: []review.CommentThread{thread("bob", thread("alice"), thread("Bob"))},
+		},
+		{
+			Request:  request.Request{Requester: "bob", Reviewers: []string{"alice"}},
+			Comments: []review.CommentThread{thread("carol"), thread("bob")},
+		},
+	}
+	loads := computeReviewerLoads(reviews, nil)
+	if len(loads) != 3 {
+		t.Fatalf("Unexpected reviewer loads: %v", loads)
+	}
+	if loads[0].Reviewer != "bob" || loads[0].Assigned != 1 || loads[0].Reviewed != 1 || loads[0].Comments != 2 {
+		t.Fatalf("Unexpected load for bob: %v", loads[0])
+	}
+	if loads[1].Reviewer != "carol" || loads[1].Assigned != 1 || loads[1].Reviewed != 1 || loads[1].Comments != 1 {
+		t.Fatalf("Unexpected load for carol: %v", loads[1])
+	}
+	if loads[2].Reviewer != "alice" || loads[2].Assigned != 1 || loads[2].Reviewed != 0 {
+		t.Fatalf("Unexpected load for alice: %v", loads[2])
+	}
+}
+
+func TestCompute(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	reviews := review.ListAll(repo)
+
+	report := Compute(reviews, time.Time{}, time.Time{}, nil)
+	if report.Totals.Reviews != len(reviews) || len(report.Reviews) != len(reviews) {
+		t.Fatalf("Unexpected report of all reviews: %v", report)
+	}
+	for i := 1; i < len(report.Reviews); i++ {
+		if report.Reviews[i-1].Created > report.Reviews[i].Created {
+			t.Fatalf("The reviews are not sorted by creation time: %v", report.Reviews)
+		}
+	}
+
+	since := time.Unix(report.Reviews[1].Created, 0)
+	until := time.Unix(report.Reviews[len(report.Reviews)-1].Created, 0)
+	report = Compute(reviews, since, until, nil)
+	if len(report.Reviews) != len(reviews)-2 || report.Since != since.Unix() || report.Until != until.Unix() {
+		t.Fatalf("Unexpected report of the reviews in a date range: %v", report)
+	}
+}
+
+func TestSLA(t *testing.T) {
+	sla := SLA{FirstResponse: 24 * time.Hour, Resolution: 72 * time.Hour}
+	now := time.Unix(1000000, 0)
+	day := int64(24 * 60 * 60)
+	late, early := 2*day, day/2
+	tests := []struct {
+		stats      ReviewStats
+		objectives []string
+	}{
+		{ReviewStats{Status: "pending", Created: now.Unix() - day/2}, nil},
+		{ReviewStats{Status: "pending", Created: now.Unix() - 2*day}, []string{ObjectiveFirstResponse}},
+		{ReviewStats{Status: "accepted", Created: now.Unix() - 4*day, TimeToFirstReview: &early}, []string{ObjectiveResolution}},
+		{ReviewStats{Status: "submitted", Created: now.Unix() - 5*day, TimeToFirstReview: &late, TimeToMerge: &late}, []string{ObjectiveFirstResponse}},
+		{ReviewStats{Status: "draft", Created: now.Unix() - 5*day}, nil},
+		{ReviewStats{Status: "abandoned", Created: now.Unix() - 5*day}, nil},
+	}
+	for i, test := range tests {
+		var objectives []string
+		for _, b := range sla.Check(test.stats, now) {
+			objectives = append(objectives, b.Objective)
+		}
+		if fmt.Sprint(objectives) != fmt.Sprint(test.objectives) {
+			t.Errorf("Unexpected breaches for case %d: %v", i, objectives)
+		}
+	}
+	breaches := sla.Check(tests[3].stats, now)
+	if len(breaches) != 1 || !breaches[0].Met || breaches[0].Overdue != day {
+		t.Fatalf("Unexpected breach of a late first response: %+v", breaches)
+	}
+
+	report := &Report{Reviews: []ReviewStats{tests[1].stats, tests[2].stats, tests[3].stats}}
+	report.ApplySLA(sla, now)
+	if report.Totals.FirstResponseBreaches != 2 || report.Totals.ResolutionBreaches != 1 {
+		t.Fatalf("Unexpected SLA totals: %+v", report.Totals)
+	}
+}
+
+func TestSuggestReviewers(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	onPath := func(author, path string) review.CommentThread {
+		return review.CommentThread{Comment: comment.Comment{Author: author, Location: &comment.Location{Path: path}}}
+	}
+	reviews := []review.Summary{
+		{
+			Request:   request.Request{Requester: "alice", TargetRef: "refs/heads/master"},
+			Comments:  []review.CommentThread{onPath("bob", "main.go"), onPath("carol", "other.go"), onPath("alice", "main.go")},
+			Submitted: true,
+		},
+		{
+			Request:  request.Request{Requester: "dave", Reviewers: []string{"bob"}, TargetRef: "refs/heads/master"},
+			Comments: []review.CommentThread{onPath("carol", "main.go")},
+		},
+		{
+			Request:  request.Request{Requester: "dave", Reviewers: []string{"bob@example.com", "bob"}, TargetRef: "refs/heads/master"},
+			Comments: []review.CommentThread{onPath("robert", "main.go")},
+		},
+	}
+	identities, err := identity.Parse("<bob> <bob@example.com> Robert <robert>\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suggestions := SuggestReviewers(repo, repository.TestCommitA, []string{"main.go"}, reviews, []string{"OJarjur@google.com"}, identities)
+	if len(suggestions) != 2 {
+		t.Fatalf("Unexpected suggestions: %v", suggestions)
+	}
+	if suggestions[0].Reviewer != "carol" || suggestions[0].PastReviews != 1 || suggestions[0].OpenReviews != 0 {
+		t.Fatalf("Unexpected top suggestion: %v", suggestions[0])
+	}
+	if suggestions[1].Reviewer != "bob" || suggestions[1].PastReviews != 2 || suggestions[1].OpenReviews != 2 || suggestions[1].Score >= suggestions[0].Score {
+		t.Fatalf("The open reviews of bob did not lower the score: %v", suggestions[1])
+	}
+
+	suggestions = SuggestReviewers(repo, repository.TestCommitA, []string{"main.go"}, nil, nil, nil)
+	if len(suggestions) != 1 || suggestions[0].Reviewer != "ojarjur@google.com" || suggestions[0].Ownership != 1 {
+		t.Fatalf("Unexpected suggestions from blame alone: %v", suggestions)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stats
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/identity"
+	"sort"
+	"strings"
+)
+
+// pastReviewWeight is how much each past review of the touched files counts, relative to having last changed all of their lines.
+const pastReviewWeight = 0.5
+
+// Suggestion describes how well suited a candidate is to review a change.
+type Suggestion struct {
+	Reviewer string  `json:"reviewer"`
+	Score    float64 `json:"score"`
+	// Ownership is the fraction of the existing lines of the touched files that were last changed by the candidate.
+	Ownership float64 `json:"ownership"`
+	// PastReviews is the number of other reviews in which the candidate commented on one of the touched files.
+	PastReviews int `json:"pastReviews"`
+	// OpenReviews is the number of open reviews that the candidate is currently assigned to.
+	OpenReviews int `json:"openReviews"`
+}
+
+// SuggestReviewers ranks candidate reviewers for a change that touches the given paths.
+//
+// Candidates are the people who last changed the lines of those paths as of
+// the given base commit, and who commented on them in the given past reviews.
+// Each candidate's familiarity with the paths is then divided by one plus the
+// number of open reviews they are assigned to, so that the reviewing is spread
+// out instead of always landing on the same people. The excluded identities,
+// such as the author of the change, are never suggested.
+func SuggestReviewers(repo repository.Repo, base string, paths []string, reviews []review.Summary, exclude []string, identities *identity.Map) []Suggestion {
+	suggestions := make(map[string]*Suggestion)
+	candidate := func(reviewer string) *Suggestion {
+		reviewer = identities.Resolve(reviewer)
+		key := strings.ToLower(reviewer)
+		if suggestions[key] == nil {
+			suggestions[key] = &Suggestion{Reviewer: reviewer}
+		}
+		return suggestions[key]
+	}
+
+	touched := make(map[string]bool)
+	totalLines := 0
+	for _, path := range paths {
+		touched[path] = true
+		// Files added by the change have no history to blame.
+		lines, err := repo.Blame(base, path)
+		if err != nil {
+			continue
+		}
+		for _, count := range lines {
+			totalLines += count
+		}
+		for author, count := range lines {
+			candidate(author).Ownership += float64(count)
+		}
+	}
+	if totalLines > 0 {
+		for _, s := range suggestions {
+			s.Ownership /= float64(totalLines)
+		}
+	}
+
+	for i := range reviews {
+		r := &reviews[i]
+		commented := make(map[*Suggestion]bool)
+		visitComments(r.Comments, func(thread review.CommentThread) {
+			c := thread.Comment
+			if c.Location == nil || !touched[c.Location.Path] || c.Author == "" || identities.Same(c.Author, r.Request.Requester) {
+				return
+			}
+			commented[candidate(c.Author)] = true
+		})
+		for s := range commented {
+			s.PastReviews++
+		}
+	}
+
+	openReviews := make(map[string]int)
+	for i := range reviews {
+		if !reviews[i].IsOpen() {
+			continue
+		}
+		assigned := make(map[string]bool)
+		for _, reviewer := range reviews[i].Request.Reviewers {
+			assigned[strings.ToLower(identities.Resolve(reviewer))] = true
+		}
+		for key := range assigned {
+			openReviews[key]++
+		}
+	}
+
+	var result []Suggestion
+	for key, s := range suggestions {
+		excluded := false
+		for _, excludedIdentity := range exclude {
+			excluded = excluded || identities.Same(excludedIdentity, s.Reviewer)
+		}
+		if excluded {
+			continue
+		}
+		s.OpenReviews = openReviews[key]
+		s.Score = (s.Ownership + pastReviewWeight*float64(s.PastReviews)) / float64(1+s.OpenReviews)
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Reviewer < result[j].Reviewer
+	})
+	return result
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package review
+
+import (
+	"github.com/promet/git-appraise/repository"
+)
+
+// SubmoduleDiff describes the change that a review makes to the commit of a submodule.
+type SubmoduleDiff struct {
+	repository.SubmoduleChange
+	// CheckedOut is whether the submodule is checked out, without which its diff cannot be computed.
+	CheckedOut bool `json:"checkedOut"`
+	// Diff is the diff between the old and new commits of the submodule, if both of them exist.
+	Diff string `json:"diff,omitempty"`
+	// Review is the revision of the review, in the submodule's repo, of the new commit.
+	Review string `json:"review,omitempty"`
+}
+
+// FindByCommit returns the review that includes the given commit, or nil if there is none.
+//
+// The newest review is returned if there are several of them. Reviews whose
+// commits cannot be listed are skipped.
+func FindByCommit(repo repository.Repo, commit string) *Review {
+	for _, summary := range ListAll(repo) {
+		r, err := summary.Details()
+		if err != nil {
+			continue
+		}
+		if head, err := r.GetHeadCommit(); err == nil && head == commit {
+			return r
+		}
+		commits, err := r.ListCommits()
+		if err != nil {
+			continue
+		}
+		for _, c := range commits {
+			if c == commit {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// GetSubmoduleDiffs returns the changes that the review makes to the commits of submodules.
+//
+// The diff of each submodule is computed with the given arguments, and each
+// one is linked to the review of its new commit in the submodule's repo.
+func (r *Review) GetSubmoduleDiffs(diffArgs ...string) ([]SubmoduleDiff, error) {
+	baseCommit, err := r.GetBaseCommit()
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := r.Repo.ListSubmoduleChanges(baseCommit, headCommit)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []SubmoduleDiff
+	for _, change := range changes {
+		diff := SubmoduleDiff{SubmoduleChange: change}
+		submodule, err := r.Repo.GetSubmodule(change.Path)
+		if err == nil {
+			diff.CheckedOut = true
+			if change.OldCommit != "" && change.NewCommit != "" {
+				// The new commit may not have been fetched into the submodule yet, in which case there is no diff.
+				diff.Diff, _ = submodule.Diff(change.OldCommit, change.NewCommit, diffArgs...)
+			}
+			if change.NewCommit != "" {
+				if linked := FindByCommit(submodule, change.NewCommit); linked != nil {
+					diff.Review = linked.Revision
+				}
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package template defines the fields that review descriptions are expected to contain.
+//
+// Templates are read from the ".appraise/template" file. Each non-comment
+// line declares a single field, in the form:
+//
+//	<name> [(required)] [: <hint>]
+//
+// For example:
+//
+//	Test plan (required): How did you verify this change?
+//	Security considerations
+//	Issue (required): A link to the issue that this fixes
+//
+// A description fills in a field with a line that starts with the field's
+// name followed by a colon. The value of the field is the rest of that line,
+// along with every following line up to the next field.
+package template
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"strings"
+)
+
+// File is the path from which a template is read.
+const File = ".appraise/template"
+
+const requiredMarker = "(required)"
+
+// Field is a single section that a review description is expected to contain.
+type Field struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required,omitempty"`
+	// Hint is an optional explanation of what the field should contain.
+	Hint string `json:"hint,omitempty"`
+}
+
+// Template is an ordered list of fields.
+type Template struct {
+	Fields []Field `json:"fields"`
+}
+
+// Parse parses the contents of a template file.
+func Parse(contents string) (*Template, error) {
+	t := &Template{}
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var field Field
+		if i := strings.Index(line, ":"); i >= 0 {
+			field.Hint = strings.TrimSpace(line[i+1:])
+			line = strings.TrimSpace(line[:i])
+		}
+		if strings.HasSuffix(line, requiredMarker) {
+			field.Required = true
+			line = strings.TrimSpace(strings.TrimSuffix(line, requiredMarker))
+		}
+		if line == "" {
+			return nil, fmt.Errorf("Missing field name on line %d", lineNumber)
+		}
+		field.Name = line
+		if seen[strings.ToLower(field.Name)] {
+			return nil, fmt.Errorf("Duplicate field %q on line %d", field.Name, lineNumber)
+		}
+		seen[strings.ToLower(field.Name)] = true
+		t.Fields = append(t.Fields, field)
+	}
+	return t, scanner.Err()
+}
+
+// Load reads the template that applies to reviews targeting the given commit.
+//
+// As with review policies, the template is read from the commit being merged
+// into, so that a review cannot change the template that applies to it. If
+// the template file does not exist, then this returns nil.
+func Load(repo repository.Repo, commit string) (*Template, error) {
+	contents, err := repo.Show(commit, File)
+	if err != nil {
+		return nil, nil
+	}
+	t, err := Parse(contents)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %q: %v", File, err)
+	}
+	return t, nil
+}
+
+// fieldName returns the name of the field that the given description line starts, if any.
+func (t *Template) fieldName(line string) (string, string, bool) {
+	for _, field := range t.Fields {
+		prefix := field.Name + ":"
+		if len(line) >= len(prefix) && strings.EqualFold(line[:len(prefix)], prefix) {
+			return field.Name, line[len(prefix):], true
+		}
+	}
+	return "", "", false
+}
+
+// Values returns the value of each field that the description contains, keyed by the field name.
+func (t *Template) Values(description string) map[string]string {
+	values := make(map[string]string)
+	current := ""
+	for _, line := range strings.Split(description, "\n") {
+		if name, rest, ok := t.fieldName(strings.TrimSpace(line)); ok {
+			current = name
+			values[current] = rest
+			continue
+		}
+		if current != "" {
+			values[current] += "\n" + line
+		}
+	}
+	for name, value := range values {
+		values[name] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// Missing returns the names of the required fields that the description leaves empty.
+func (t *Template) Missing(description string) []string {
+	values := t.Values(description)
+	var missing []string
+	for _, field := range t.Fields {
+		if field.Required && values[field.Name] == "" {
+			missing = append(missing, field.Name)
+		}
+	}
+	return missing
+}
+
+// Inject adds a heading for each of the template's fields that is not already
+// in the description, so that the description can be filled in using an editor.
+//
+// The hint for each added field is included as a comment line, which is
+// expected to be removed (using StripComments) once editing is finished.
+func (t *Template) Inject(description string) string {
+	values := t.Values(description)
+	result := strings.TrimRight(description, "\n") + "\n"
+	for _, field := range t.Fields {
+		if _, ok := values[field.Name]; ok {
+			continue
+		}
+		result += "\n" + field.Name + ":\n"
+		if field.Hint != "" {
+			result += "# " + field.Hint + "\n"
+		}
+	}
+	var required []string
+	for _, field := range t.Fields {
+		if field.Required {
+			required = append(required, field.Name)
+		}
+	}
+	if len(required) > 0 {
+		result += fmt.Sprintf("\n# The following fields are required: %s.\n", strings.Join(required, ", "))
+	}
+	result += "# Lines starting with '#' will be ignored.\n"
+	return result
+}
+
+// StripComments removes the comment lines (starting with '#') from an edited description.
+func StripComments(description string) string {
+	var lines []string
+	for _, line := range strings.Split(description, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")) + "\n"
+}
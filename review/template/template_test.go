@@ -0,0 +1,90 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTemplate = `# Fields for every review
+Test plan (required): How did you verify this change?
+Security considerations
+Issue (required)
+`
+
+func TestParse(t *testing.T) {
+	tmpl, err := Parse(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Field{
+		{Name: "Test plan", Required: true, Hint: "How did you verify this change?"},
+		{Name: "Security considerations"},
+		{Name: "Issue", Required: true},
+	}
+	if !reflect.DeepEqual(tmpl.Fields, expected) {
+		t.Errorf("Unexpected fields: %+v", tmpl.Fields)
+	}
+	if _, err := Parse("Issue\nissue (required)\n"); err == nil {
+		t.Error("Failed to reject a duplicate field")
+	}
+}
+
+func TestMissing(t *testing.T) {
+	tmpl, err := Parse(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	description := `Fix the frobnicator
+
+Test plan: ran the unit tests
+and tried it by hand.
+Security considerations:
+issue:
+`
+	values := tmpl.Values(description)
+	if values["Test plan"] != "ran the unit tests\nand tried it by hand." {
+		t.Errorf("Unexpected test plan: %q", values["Test plan"])
+	}
+	if missing := tmpl.Missing(description); !reflect.DeepEqual(missing, []string{"Issue"}) {
+		t.Errorf("Unexpected missing fields: %v", missing)
+	}
+}
+
+func TestInject(t *testing.T) {
+	tmpl, err := Parse(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	injected := tmpl.Inject("Fix the frobnicator\n\nIssue: #123\n")
+	if !strings.Contains(injected, "\nTest plan:\n# How did you verify this change?\n") {
+		t.Errorf("The test plan field was not injected: %q", injected)
+	}
+	if strings.Count(injected, "Issue:") != 1 {
+		t.Errorf("The existing issue field was injected again: %q", injected)
+	}
+	edited := strings.Replace(injected, "Test plan:\n", "Test plan: manual\n", 1)
+	stripped := StripComments(edited)
+	if strings.Contains(stripped, "# How did") || !strings.Contains(stripped, "Issue: #123") {
+		t.Errorf("Comments were not stripped: %q", stripped)
+	}
+	if missing := tmpl.Missing(stripped); len(missing) != 0 {
+		t.Errorf("Unexpected missing fields after editing: %v", missing)
+	}
+}
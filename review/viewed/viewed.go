@@ -0,0 +1,242 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package viewed tracks which parts of a review's diff a reviewer has already looked at.
+//
+// Marks are identified by a hash of the diff of a single file (or of a single
+// hunk within that file), so a mark only applies for as long as the
+// corresponding part of the diff stays the same. Once the review is updated
+// in a way that changes a file, that file is shown as unviewed again.
+//
+// Marks are personal, so they are stored in a notes ref outside of the
+// "refs/notes/pullrequests" namespace, which keeps them from being pushed.
+package viewed
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/schema"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ref defines the git-notes ref that holds the local user's viewed marks.
+const Ref = "refs/notes/appraise-local/viewed"
+
+// Mark records that a file, or a single hunk within it, has been viewed.
+type Mark struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Path      string `json:"path"`
+	// Hash identifies the viewed diff of the file, or of the hunk if Hunk is set.
+	Hash string `json:"hash"`
+	Hunk bool   `json:"hunk,omitempty"`
+	// Unviewed indicates that an earlier, matching mark has been withdrawn.
+	Unviewed bool `json:"unviewed,omitempty"`
+}
+
+// Hunk is a single hunk in the diff of a file.
+type Hunk struct {
+	Hash string
+	Text string
+}
+
+// File is the diff of a single file, split into its header and hunks.
+type File struct {
+	Path   string
+	Hash   string
+	Header string
+	Hunks  []Hunk
+}
+
+func hashText(text string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(text)))
+}
+
+// hashHunk hashes the body of a hunk, ignoring its header, so that the hash
+// does not change when earlier changes to the file shift the hunk's lines.
+func hashHunk(text string) string {
+	lines := strings.SplitN(text, "\n", 2)
+	if len(lines) < 2 {
+		return hashText("")
+	}
+	return hashText(lines[1])
+}
+
+// Split splits a diff in the git format into the diffs of each file.
+func Split(diff string) []File {
+	var files []File
+	var file *File
+	var hunk *strings.Builder
+	finishHunk := func() {
+		if file != nil && hunk != nil {
+			text := hunk.String()
+			file.Hunks = append(file.Hunks, Hunk{Hash: hashHunk(text), Text: text})
+		}
+		hunk = nil
+	}
+	finishFile := func() {
+		finishHunk()
+		if file != nil {
+			text := file.Header
+			for _, h := range file.Hunks {
+				text += h.Text
+			}
+			file.Hash = hashText(text)
+			files = append(files, *file)
+		}
+		file = nil
+	}
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		if line == "" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "diff "):
+			finishFile()
+			file = &File{Path: pathFromDiffLine(line)}
+		case file == nil:
+			continue
+		case strings.HasPrefix(line, "@@"):
+			finishHunk()
+			hunk = &strings.Builder{}
+		case hunk == nil && strings.HasPrefix(line, "+++ "):
+			if path := strings.TrimSpace(strings.TrimPrefix(line, "+++ ")); path != "/dev/null" {
+				file.Path = strings.TrimPrefix(path, "b/")
+			}
+		}
+		if file == nil {
+			continue
+		}
+		if hunk != nil {
+			hunk.WriteString(line)
+		} else {
+			file.Header += line
+		}
+	}
+	finishFile()
+	return files
+}
+
+// pathFromDiffLine extracts the path of the new version of a file from the
+// "diff --git a/<path> b/<path>" line that starts its diff.
+func pathFromDiffLine(line string) string {
+	line = strings.TrimSpace(line)
+	if i := strings.LastIndex(line, " b/"); i >= 0 {
+		return line[i+3:]
+	}
+	return line
+}
+
+// New returns a new mark for the given file or hunk.
+func New(path, hash string, hunk bool) Mark {
+	return Mark{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Path:      path,
+		Hash:      hash,
+		Hunk:      hunk,
+	}
+}
+
+// Write writes a mark as a JSON-formatted git note.
+func (mark Mark) Write() (repository.Note, error) {
+	bytes, err := json.Marshal(mark)
+	return repository.Note(bytes), err
+}
+
+// ParseAllValid takes collection of git notes and tries to parse a mark from
+// each one. Any notes that are not valid marks get ignored.
+func ParseAllValid(notes []repository.Note) []Mark {
+	var marks []Mark
+	for _, note := range notes {
+		var mark Mark
+		if err := json.Unmarshal([]byte(note), &mark); err == nil && mark.Path != "" && mark.Hash != "" {
+			marks = append(marks, mark)
+		}
+	}
+	return marks
+}
+
+// State is the set of files and hunks that are currently marked as viewed.
+type State map[Mark]bool
+
+func stateKey(mark Mark) Mark {
+	return Mark{Path: mark.Path, Hash: mark.Hash, Hunk: mark.Hunk}
+}
+
+// NewState returns the state resulting from the given marks, where later marks override earlier ones.
+func NewState(marks []Mark) State {
+	sorted := append([]Mark(nil), marks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return schema.CompareTimestamps(sorted[i].Timestamp, sorted[j].Timestamp) < 0
+	})
+	state := make(State)
+	for _, mark := range sorted {
+		state[stateKey(mark)] = !mark.Unviewed
+	}
+	return state
+}
+
+// Load reads the viewed state of the given review.
+func Load(repo repository.Repo, revision string) State {
+	return NewState(ParseAllValid(repo.GetNotes(Ref, revision)))
+}
+
+// FileViewed reports whether the given file has been viewed, either as a
+// whole or by viewing each of its hunks.
+func (state State) FileViewed(file File) bool {
+	if state[Mark{Path: file.Path, Hash: file.Hash}] {
+		return true
+	}
+	for _, hunk := range file.Hunks {
+		if !state.HunkViewed(file, hunk) {
+			return false
+		}
+	}
+	return len(file.Hunks) > 0
+}
+
+// HunkViewed reports whether the given hunk of a file has been viewed.
+func (state State) HunkViewed(file File, hunk Hunk) bool {
+	return state[Mark{Path: file.Path, Hash: file.Hash}] || state[Mark{Path: file.Path, Hash: hunk.Hash, Hunk: true}]
+}
+
+// Filter returns the parts of the given diff that have not been viewed, along
+// with the number of files and hunks that were left out.
+func (state State) Filter(diff string) (string, int, int) {
+	var result strings.Builder
+	var hiddenFiles, hiddenHunks int
+	for _, file := range Split(diff) {
+		if state.FileViewed(file) {
+			hiddenFiles++
+			continue
+		}
+		var hunks []string
+		for _, hunk := range file.Hunks {
+			if state.HunkViewed(file, hunk) {
+				hiddenHunks++
+				continue
+			}
+			hunks = append(hunks, hunk.Text)
+		}
+		result.WriteString(file.Header)
+		result.WriteString(strings.Join(hunks, ""))
+	}
+	return result.String(), hiddenFiles, hiddenHunks
+}
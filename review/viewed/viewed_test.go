@@ -0,0 +1,119 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package viewed
+
+import (
+	"testing"
+)
+
+const fooDiff = `diff --git a/foo.txt b/foo.txt
+index 1111111..2222222 100644
+--- a/foo.txt
++++ b/foo.txt
+@@ -1,2 +1,2 @@
+-one
++uno
+ two
+@@ -10,2 +10,2 @@
+ ten
+-eleven
++once
+`
+
+const barDiff = `diff --git a/bar.txt b/bar.txt
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/bar.txt
+@@ -0,0 +1 @@
++bar
+`
+
+func TestSplit(t *testing.T) {
+	files := Split(fooDiff + barDiff)
+	if len(files) != 2 {
+		t.Fatalf("Unexpected files: %v", files)
+	}
+	if files[0].Path != "foo.txt" || len(files[0].Hunks) != 2 {
+		t.Errorf("Unexpected first file: %v", files[0])
+	}
+	if files[1].Path != "bar.txt" || len(files[1].Hunks) != 1 {
+		t.Errorf("Unexpected second file: %v", files[1])
+	}
+	if text := files[0].Header + files[0].Hunks[0].Text + files[0].Hunks[1].Text; text != fooDiff {
+		t.Errorf("The split diff does not match the original: %q", text)
+	}
+
+	// Shifting a hunk should not change its hash, but should change the file's hash.
+	shifted := Split(`diff --git a/foo.txt b/foo.txt
+index 1111111..4444444 100644
+--- a/foo.txt
++++ b/foo.txt
+@@ -3,2 +3,2 @@
+-one
++uno
+ two
+`)
+	if shifted[0].Hunks[0].Hash != files[0].Hunks[0].Hash {
+		t.Errorf("Moving a hunk changed its hash")
+	}
+	if shifted[0].Hash == files[0].Hash {
+		t.Errorf("Changing a file did not change its hash")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	files := Split(fooDiff + barDiff)
+	foo, bar := files[0], files[1]
+
+	state := NewState([]Mark{New(bar.Path, bar.Hash, false)})
+	if diff, hiddenFiles, hiddenHunks := state.Filter(fooDiff + barDiff); diff != fooDiff || hiddenFiles != 1 || hiddenHunks != 0 {
+		t.Errorf("Unexpected result of filtering a viewed file: %q, %d, %d", diff, hiddenFiles, hiddenHunks)
+	}
+
+	state = NewState([]Mark{New(foo.Path, foo.Hunks[1].Hash, true)})
+	expected := foo.Header + foo.Hunks[0].Text + barDiff
+	if diff, hiddenFiles, hiddenHunks := state.Filter(fooDiff + barDiff); diff != expected || hiddenFiles != 0 || hiddenHunks != 1 {
+		t.Errorf("Unexpected result of filtering a viewed hunk: %q, %d, %d", diff, hiddenFiles, hiddenHunks)
+	}
+
+	state = NewState([]Mark{
+		New(foo.Path, foo.Hunks[0].Hash, true),
+		New(foo.Path, foo.Hunks[1].Hash, true),
+	})
+	if !state.FileViewed(foo) {
+		t.Errorf("A file with every hunk viewed was not considered viewed")
+	}
+
+	// A file that changes after being viewed should be shown again.
+	state = NewState([]Mark{New(foo.Path, "stale", false)})
+	if state.FileViewed(foo) {
+		t.Errorf("A stale mark was applied to a changed file")
+	}
+}
+
+func TestNewStateUnviewed(t *testing.T) {
+	viewed := Mark{Timestamp: "0000000001", Path: "foo.txt", Hash: "abc"}
+	unviewed := Mark{Timestamp: "0000000002", Path: "foo.txt", Hash: "abc", Unviewed: true}
+	if state := NewState([]Mark{unviewed, viewed}); state[stateKey(viewed)] {
+		t.Errorf("A later unviewed mark did not override an earlier mark")
+	}
+	viewed.Timestamp = "0000000003"
+	if state := NewState([]Mark{unviewed, viewed}); !state[stateKey(viewed)] {
+		t.Errorf("A later viewed mark did not override an earlier unviewed mark")
+	}
+}
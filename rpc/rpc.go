@@ -0,0 +1,392 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rpc contains a JSON-RPC 2.0 server for reading and updating the code reviews in a repo.
+//
+// It is meant to be run by editor plugins as a long-lived subprocess, and so
+// uses the same framing as the Language Server Protocol: every message is
+// preceded by a "Content-Length" header and a blank line. Besides the LSP
+// lifecycle methods ("initialize", "shutdown", and "exit"), it supports:
+//
+//	appraise/listReviews    {"all": bool}                       lists the open, published reviews, or every review
+//	appraise/currentReview  {}                                  returns the review of the checked out branch, or null
+//	appraise/getReview      {"review": hash}                    returns the details of a review
+//	appraise/fileComments   {"review": hash, "path": path}      returns the threads on a file, remapped to the review's head
+//	appraise/addComment     {"review": hash, "description": ..., "parent": hash, "path": path, "line": n, "resolved": bool}
+//	appraise/accept         {"review": hash, "description": ..., "level": "approved" | "approved-with-nits" | "needs-changes"}
+//	appraise/reject         {"review": hash, "description": ...}
+//
+// The "review" parameter may be omitted to use the review of the checked out
+// branch, or given as a short ID, a unique prefix of a review's revision, or a
+// branch name.
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// The error codes defined by JSON-RPC 2.0, along with one for failures to handle a valid request.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeRequestFailed  = -32000
+)
+
+// Request is a single JSON-RPC request, or a notification if it has no ID.
+type Request struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+// Error is the error of a failed JSON-RPC request.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Response is the response to a single JSON-RPC request.
+type Response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+// ReviewParams are the parameters of the methods that operate on a single review.
+type ReviewParams struct {
+	Review string `json:"review,omitempty"`
+}
+
+// ListParams are the parameters of "appraise/listReviews".
+type ListParams struct {
+	All bool `json:"all,omitempty"`
+}
+
+// FileCommentsParams are the parameters of "appraise/fileComments".
+type FileCommentsParams struct {
+	Review string `json:"review,omitempty"`
+	Path   string `json:"path"`
+}
+
+// CommentParams are the parameters of "appraise/addComment", "appraise/accept", and "appraise/reject".
+type CommentParams struct {
+	Review      string `json:"review,omitempty"`
+	Description string `json:"description"`
+	Parent      string `json:"parent,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Line        uint32 `json:"line,omitempty"`
+	Resolved    *bool  `json:"resolved,omitempty"`
+	Level       string `json:"level,omitempty"`
+}
+
+// CommentResult is the result of a method that added a comment.
+type CommentResult struct {
+	Hash    string          `json:"hash"`
+	Comment comment.Comment `json:"comment"`
+}
+
+// InitializeResult is the result of "initialize".
+type InitializeResult struct {
+	Capabilities map[string]interface{} `json:"capabilities"`
+	ServerInfo   map[string]string      `json:"serverInfo"`
+}
+
+// Server serves the JSON-RPC methods for a single repo.
+type Server struct {
+	repo     repository.Repo
+	shortIDs *review.ShortIDs
+	shutdown bool
+}
+
+// New returns a new Server for the given repo.
+//
+// If shortIDs is not nil, then reviews may also be named by their short IDs.
+func New(repo repository.Repo, shortIDs *review.ShortIDs) *Server {
+	return &Server{repo: repo, shortIDs: shortIDs}
+}
+
+// readMessage reads a single message, along with its headers.
+func readMessage(reader *bufio.Reader) ([]byte, error) {
+	headers, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("Invalid Content-Length header %q.", headers.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+	return body, err
+}
+
+// writeMessage writes a single message, preceded by its headers.
+func writeMessage(w io.Writer, message interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// Serve reads requests from the given reader, and writes the responses to the given writer, until the "exit" notification.
+//
+// It returns nil if the client asked the server to shut down before exiting.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+	for {
+		body, err := readMessage(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var request Request
+		if err := json.Unmarshal(body, &request); err != nil {
+			if err := writeMessage(w, Response{JSONRPC: "2.0", Error: &Error{Code: CodeParseError, Message: err.Error()}}); err != nil {
+				return err
+			}
+			continue
+		}
+		if request.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("Exited without being shut down first.")
+			}
+			return nil
+		}
+		result, err := s.Handle(request.Method, request.Params)
+		if request.ID == nil {
+			// Notifications, such as "initialized", do not get a response.
+			continue
+		}
+		response := Response{JSONRPC: "2.0", ID: request.ID, Result: result}
+		if result == nil {
+			// A successful response must have a result, even if it is null.
+			response.Result = json.RawMessage("null")
+		}
+		if err != nil {
+			rpcErr, ok := err.(*Error)
+			if !ok {
+				rpcErr = &Error{Code: CodeRequestFailed, Message: err.Error()}
+			}
+			response.Result = nil
+			response.Error = rpcErr
+		}
+		if err := writeMessage(w, response); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeParams decodes the parameters of a request into the given value.
+func decodeParams(params json.RawMessage, value interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, value); err != nil {
+		return &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// getReview returns the named review, or the review of the checked out branch if no name is given.
+func (s *Server) getReview(name string) (*review.Review, error) {
+	var r *review.Review
+	var err error
+	if name == "" {
+		r, err = review.GetCurrent(s.repo)
+	} else {
+		var revision string
+		if revision, err = review.Resolve(s.repo, name, s.shortIDs); err == nil {
+			r, err = review.Get(s.repo, revision)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, review.ErrReviewNotFound
+	}
+	return r, nil
+}
+
+// Handle runs the named method with the given parameters, and returns its result.
+func (s *Server) Handle(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		return InitializeResult{
+			Capabilities: map[string]interface{}{},
+			ServerInfo:   map[string]string{"name": "git-appraise"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "appraise/listReviews":
+		var p ListParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var reviews []review.Summary
+		if p.All {
+			reviews = review.ListAll(s.repo)
+		} else {
+			reviews = review.FilterDrafts(review.ListOpen(s.repo), "")
+		}
+		if reviews == nil {
+			reviews = []review.Summary{}
+		}
+		return reviews, nil
+	case "appraise/currentReview":
+		r, err := review.GetCurrent(s.repo)
+		if err != nil || r == nil {
+			return nil, err
+		}
+		return r, nil
+	case "appraise/getReview":
+		var p ReviewParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.getReview(p.Review)
+	case "appraise/fileComments":
+		var p FileCommentsParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.fileComments(p)
+	case "appraise/addComment":
+		var p CommentParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		p.Level = ""
+		return s.addComment(p)
+	case "appraise/accept", "appraise/reject":
+		var p CommentParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		accepted := method == "appraise/accept"
+		if !accepted {
+			p.Level = ""
+		} else if p.Level != "" {
+			if !comment.IsValidLevel(p.Level) {
+				return nil, &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("Unknown approval level %q.", p.Level)}
+			}
+			accepted = comment.ResolvedForLevel(p.Level)
+		}
+		p.Resolved = &accepted
+		p.Parent, p.Path, p.Line = "", "", 0
+		return s.addComment(p)
+	}
+	return nil, &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("Unknown method %q.", method)}
+}
+
+// fileComments returns the comment threads on the given file, with their locations translated to the head of the review.
+func (s *Server) fileComments(p FileCommentsParams) ([]review.CommentThread, error) {
+	if p.Path == "" {
+		return nil, &Error{Code: CodeInvalidParams, Message: "The path is required."}
+	}
+	r, err := s.getReview(p.Review)
+	if err != nil {
+		return nil, err
+	}
+	threads, err := r.RemapComments()
+	if err != nil {
+		return nil, err
+	}
+	path := strings.TrimPrefix(p.Path, "./")
+	result := []review.CommentThread{}
+	for _, thread := range threads {
+		if location := thread.Comment.Location; location != nil && location.Path == path {
+			result = append(result, thread)
+		}
+	}
+	return result, nil
+}
+
+// addComment adds the described comment to the review, on its head commit unless it replies to another comment.
+func (s *Server) addComment(p CommentParams) (*CommentResult, error) {
+	if p.Description == "" && p.Resolved == nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: "The comment description is required."}
+	}
+	if p.Line != 0 && p.Path == "" {
+		return nil, &Error{Code: CodeInvalidParams, Message: "Commenting on a line requires a path."}
+	}
+	r, err := s.getReview(p.Review)
+	if err != nil {
+		return nil, err
+	}
+	if p.Parent != "" && r.FindComment(p.Parent) == nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: "There is no matching parent comment."}
+	}
+	if p.Resolved != nil && p.Parent == "" && r.IsAbandoned() {
+		return nil, errors.New("The review was abandoned.")
+	}
+	userEmail, err := s.repo.GetUserEmail()
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.GetHeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	c := comment.New(userEmail, p.Description)
+	c.Parent = p.Parent
+	c.Resolved = p.Resolved
+	if p.Level != comment.LevelApproved {
+		c.Level = p.Level
+	}
+	c.Location = &comment.Location{Commit: head, Path: p.Path}
+	if p.Line != 0 {
+		c.Location.Range = &comment.Range{StartLine: p.Line}
+	}
+	if err := r.AddComment(c); err != nil {
+		return nil, err
+	}
+	hash, err := c.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return &CommentResult{Hash: hash, Comment: c}, nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"testing"
+)
+
+// session runs the server over the given messages, and returns the responses, in order.
+func session(t *testing.T, s *Server, messages ...string) []Response {
+	var in, out bytes.Buffer
+	for _, message := range messages {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(message), message)
+	}
+	if err := s.Serve(&in, &out); err != nil {
+		t.Fatal(err)
+	}
+	var responses []Response
+	reader := bufio.NewReader(&out)
+	for {
+		body, err := readMessage(reader)
+		if err != nil {
+			break
+		}
+		var response Response
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+// decodeResult decodes the result of a response into the given value.
+func decodeResult(t *testing.T, response Response, value interface{}) {
+	if response.Error != nil {
+		t.Fatalf("Unexpected error: %v", response.Error)
+	}
+	result, err := json.Marshal(response.Result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(result, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	s := New(repository.NewMockRepoForTest(), nil)
+	responses := session(t, s,
+		`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {}}`,
+		`{"jsonrpc": "2.0", "method": "initialized"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "appraise/unknown"}`,
+		`not json`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "shutdown"}`,
+		`{"jsonrpc": "2.0", "method": "exit"}`,
+		`{"jsonrpc": "2.0", "id": 4, "method": "initialize"}`)
+	if len(responses) != 4 {
+		t.Fatalf("Unexpected responses: %v", responses)
+	}
+	var initialize InitializeResult
+	decodeResult(t, responses[0], &initialize)
+	if initialize.ServerInfo["name"] != "git-appraise" {
+		t.Fatalf("Unexpected initialize result: %v", initialize)
+	}
+	if responses[1].Error == nil || responses[1].Error.Code != CodeMethodNotFound {
+		t.Fatalf("Unexpected response to an unknown method: %v", responses[1])
+	}
+	if responses[2].Error == nil || responses[2].Error.Code != CodeParseError {
+		t.Fatalf("Unexpected response to a malformed message: %v", responses[2])
+	}
+	if responses[3].Error != nil || string(*responses[3].ID) != "3" || responses[3].Result != nil {
+		t.Fatalf("Unexpected response to shutdown: %v", responses[3])
+	}
+
+	if err := New(repository.NewMockRepoForTest(), nil).Serve(bytes.NewBufferString("Content-Length: 33\r\n\r\n{\"jsonrpc\":\"2.0\",\"method\":\"exit\"}"), &bytes.Buffer{}); err == nil {
+		t.Fatal("Unexpected success exiting without a shutdown")
+	}
+}
+
+func TestReviews(t *testing.T) {
+	s := New(repository.NewMockRepoForTest(), nil)
+	responses := session(t, s,
+		`{"jsonrpc": "2.0", "id": 1, "method": "appraise/listReviews"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "appraise/getReview", "params": {"review": "`+repository.TestCommitG+`"}}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "appraise/getReview", "params": {"review": "`+repository.TestCommitA+`"}}`)
+	var reviews []review.Summary
+	decodeResult(t, responses[0], &reviews)
+	if len(reviews) == 0 || reviews[0].Revision != repository.TestCommitG {
+		t.Fatalf("Unexpected reviews: %v", reviews)
+	}
+	var r review.Review
+	decodeResult(t, responses[1], &r)
+	if r.Request.Description != "Final description of G" {
+		t.Fatalf("Unexpected review: %v", r)
+	}
+	if responses[2].Error == nil || responses[2].Error.Code != CodeRequestFailed {
+		t.Fatalf("Unexpected response for a missing review: %v", responses[2])
+	}
+}
+
+func TestCommentsAndVotes(t *testing.T) {
+	s := New(repository.NewMockRepoForTest(), nil)
+	responses := session(t, s,
+		`{"jsonrpc": "2.0", "id": 1, "method": "appraise/addComment", "params": {"review": "`+repository.TestCommitB+`", "description": "Typo", "path": "foo.go", "line": 3}}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "appraise/fileComments", "params": {"review": "`+repository.TestCommitB+`", "path": "foo.go"}}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "appraise/addComment", "params": {"review": "`+repository.TestCommitB+`", "line": 3, "description": "Typo"}}`,
+		`{"jsonrpc": "2.0", "id": 4, "method": "appraise/accept", "params": {"review": "`+repository.TestCommitB+`", "description": "LGTM"}}`,
+		`{"jsonrpc": "2.0", "id": 5, "method": "appraise/accept", "params": {"review": "`+repository.TestCommitB+`", "level": "needs-changes"}}`)
+	var added CommentResult
+	decodeResult(t, responses[0], &added)
+	if added.Hash == "" || added.Comment.Location == nil || added.Comment.Location.Range.StartLine != 3 {
+		t.Fatalf("Unexpected comment: %v", added)
+	}
+	var threads []review.CommentThread
+	decodeResult(t, responses[1], &threads)
+	if len(threads) != 1 || threads[0].Hash != added.Hash {
+		t.Fatalf("Unexpected threads on foo.go: %v", threads)
+	}
+	if responses[2].Error == nil || responses[2].Error.Code != CodeInvalidParams {
+		t.Fatalf("Unexpected response for a line comment without a path: %v", responses[2])
+	}
+	var accepted CommentResult
+	decodeResult(t, responses[3], &accepted)
+	if accepted.Comment.Resolved == nil || !*accepted.Comment.Resolved || accepted.Comment.Location.Path != "" {
+		t.Fatalf("Unexpected vote: %v", accepted)
+	}
+	var conditional CommentResult
+	decodeResult(t, responses[4], &conditional)
+	if conditional.Comment.Resolved == nil || *conditional.Comment.Resolved || conditional.Comment.Level != comment.LevelNeedsChanges {
+		t.Fatalf("Unexpected conditional vote: %v", conditional)
+	}
+}
@@ -0,0 +1,330 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package search implements full-text search over the descriptions and comments of reviews.
+//
+// A query is a list of space-separated terms, all of which must match. Plain
+// terms match words in the review description or in any of its comments.
+// Terms of the form "<field>:<value>" match specific fields instead:
+//
+//	author:<email>     the requester of the review
+//	reviewer:<email>   one of the requested reviewers, or anyone who commented
+//	status:<status>    one of draft, pending, accepted, rejected, submitted, or abandoned
+//	label:<label>      one of the labels of the review
+//	path:<path>        a file changed by the review; either a directory prefix or a glob
+package search
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/promet/git-appraise/review"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Query is a parsed search query.
+type Query struct {
+	Terms     []string
+	Authors   []string
+	Reviewers []string
+	Statuses  []string
+	Labels    []string
+	Paths     []string
+}
+
+// ParseQuery parses the given search query.
+func ParseQuery(query string) (Query, error) {
+	var q Query
+	for _, field := range strings.Fields(query) {
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) == 2 && parts[1] == "" {
+			return q, fmt.Errorf("Missing a value for the query term %q.", field)
+		}
+		if len(parts) == 2 {
+			switch strings.ToLower(parts[0]) {
+			case "author":
+				q.Authors = append(q.Authors, parts[1])
+				continue
+			case "reviewer":
+				q.Reviewers = append(q.Reviewers, parts[1])
+				continue
+			case "status":
+				q.Statuses = append(q.Statuses, strings.ToLower(parts[1]))
+				continue
+			case "label":
+				q.Labels = append(q.Labels, parts[1])
+				continue
+			case "path":
+				q.Paths = append(q.Paths, parts[1])
+				continue
+			}
+		}
+		q.Terms = append(q.Terms, tokenize(field)...)
+	}
+	return q, nil
+}
+
+// tokenize splits the given text into lower-cased words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Status returns the single status that best describes the given review.
+func Status(r *review.Summary) string {
+	switch {
+	case r.Submitted:
+		return "submitted"
+	case r.IsAbandoned():
+		return "abandoned"
+	case r.IsDraft():
+		return "draft"
+	case r.Resolved == nil:
+		return "pending"
+	case *r.Resolved:
+		return "accepted"
+	}
+	return "rejected"
+}
+
+// Document holds the searchable contents of a single review.
+type Document struct {
+	Revision  string
+	Requester string
+	Reviewers []string
+	Status    string
+	Labels    []string
+	// Paths holds the files changed by the review. It is only computed
+	// when first needed, as doing so requires computing the review's diff.
+	Paths         []string
+	PathsComputed bool
+}
+
+// Index is an inverted index from words to the reviews that contain them.
+type Index struct {
+	// StateHash identifies the state of the repo when the index was built.
+	StateHash string
+	Documents []Document
+	// Postings maps each word to the (sorted) indices of the documents containing it.
+	Postings map[string][]int
+}
+
+func addCommenters(reviewers map[string]bool, threads []review.CommentThread) {
+	for _, thread := range threads {
+		reviewers[thread.Comment.Author] = true
+		addCommenters(reviewers, thread.Children)
+	}
+}
+
+func addCommentWords(words map[string]bool, threads []review.CommentThread) {
+	for _, thread := range threads {
+		for _, word := range tokenize(thread.Latest().Description) {
+			words[word] = true
+		}
+		addCommentWords(words, thread.Children)
+	}
+}
+
+// Build builds an index of the given reviews.
+func Build(stateHash string, reviews []review.Summary) *Index {
+	index := &Index{
+		StateHash: stateHash,
+		Postings:  make(map[string][]int),
+	}
+	for i, r := range reviews {
+		reviewers := make(map[string]bool)
+		for _, reviewer := range r.Request.Reviewers {
+			reviewers[reviewer] = true
+		}
+		addCommenters(reviewers, r.Comments)
+		doc := Document{
+			Revision:  r.Revision,
+			Requester: r.Request.Requester,
+			Status:    Status(&reviews[i]),
+			Labels:    r.Request.Labels,
+		}
+		for reviewer := range reviewers {
+			doc.Reviewers = append(doc.Reviewers, reviewer)
+		}
+		sort.Strings(doc.Reviewers)
+		index.Documents = append(index.Documents, doc)
+
+		words := make(map[string]bool)
+		for _, word := range tokenize(r.Request.Description) {
+			words[word] = true
+		}
+		addCommentWords(words, r.Comments)
+		for word := range words {
+			index.Postings[word] = append(index.Postings[word], i)
+		}
+	}
+	return index
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPath reports whether any of the given paths matches the pattern from a "path:" term.
+func matchesPath(paths []string, pattern string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	for _, p := range paths {
+		if strings.ContainsAny(pattern, "*?[") {
+			if matched, _ := path.Match(pattern, p); matched {
+				return true
+			}
+			if matched, _ := path.Match(pattern, path.Base(p)); matched {
+				return true
+			}
+			continue
+		}
+		if p == pattern || strings.HasPrefix(p, strings.TrimSuffix(pattern, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesFields reports whether the document matches all of the field terms of the query, other than paths.
+func (doc *Document) matchesFields(q Query) bool {
+	for _, author := range q.Authors {
+		if !strings.EqualFold(doc.Requester, author) {
+			return false
+		}
+	}
+	for _, reviewer := range q.Reviewers {
+		if !containsFold(doc.Reviewers, reviewer) {
+			return false
+		}
+	}
+	for _, status := range q.Statuses {
+		if doc.Status != status {
+			return false
+		}
+	}
+	for _, label := range q.Labels {
+		if !containsFold(doc.Labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates returns the indices of the documents that contain all of the plain terms of the query.
+func (index *Index) candidates(q Query) []int {
+	if len(q.Terms) == 0 {
+		all := make([]int, len(index.Documents))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+	result := index.Postings[q.Terms[0]]
+	for _, term := range q.Terms[1:] {
+		postings := index.Postings[term]
+		var intersection []int
+		for i, j := 0, 0; i < len(result) && j < len(postings); {
+			switch {
+			case result[i] == postings[j]:
+				intersection = append(intersection, result[i])
+				i++
+				j++
+			case result[i] < postings[j]:
+				i++
+			default:
+				j++
+			}
+		}
+		result = intersection
+	}
+	return result
+}
+
+// Search returns the revisions of the reviews that match the given query.
+//
+// The "changedPaths" function is used to compute the files changed by a
+// review, and is only called for path queries, for reviews that match all of
+// the other terms and whose changed paths are not already in the index.
+func (index *Index) Search(q Query, changedPaths func(revision string) ([]string, error)) ([]string, error) {
+	var revisions []string
+	for _, i := range index.candidates(q) {
+		doc := &index.Documents[i]
+		if !doc.matchesFields(q) {
+			continue
+		}
+		if len(q.Paths) > 0 && !doc.PathsComputed {
+			paths, err := changedPaths(doc.Revision)
+			if err != nil {
+				return nil, err
+			}
+			doc.Paths = paths
+			doc.PathsComputed = true
+		}
+		matches := true
+		for _, pattern := range q.Paths {
+			matches = matches && matchesPath(doc.Paths, pattern)
+		}
+		if matches {
+			revisions = append(revisions, doc.Revision)
+		}
+	}
+	return revisions, nil
+}
+
+// Load reads a previously saved index from the given file.
+func Load(file string) (*Index, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var index Index
+	if err := gob.NewDecoder(f).Decode(&index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// Save writes the index to the given file.
+func (index *Index) Save(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(index)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
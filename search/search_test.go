@@ -0,0 +1,111 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package search
+
+import (
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/comment"
+	"github.com/promet/git-appraise/review/request"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testReviews() []review.Summary {
+	accepted := true
+	return []review.Summary{
+		{
+			Revision: "A",
+			Request:  request.Request{TargetRef: "refs/heads/master", Requester: "alice@example.com", Description: "Fix the flaky parser test", Labels: []string{"backend"}},
+			Comments: []review.CommentThread{{
+				Comment:  comment.New("bob@example.com", "Please add a regression test"),
+				Children: []review.CommentThread{{Comment: comment.New("carol@example.com", "Agreed, LGTM otherwise")}},
+			}},
+			Resolved: &accepted,
+		},
+		{
+			Revision: "B",
+			Request:  request.Request{TargetRef: "refs/heads/master", Requester: "bob@example.com", Description: "Speed up the parser"},
+		},
+	}
+}
+
+func search(t *testing.T, index *Index, query string) []string {
+	q, err := ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := index.Search(q, func(revision string) ([]string, error) {
+		if revision == "A" {
+			return []string{"parser/parser_test.go"}, nil
+		}
+		return []string{"parser/parser.go", "README.md"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return revisions
+}
+
+func TestSearch(t *testing.T) {
+	index := Build("state", testReviews())
+	expected := map[string][]string{
+		"parser":                     {"A", "B"},
+		"Parser regression":          {"A"},
+		"lgtm":                       {"A"},
+		"missing":                    nil,
+		"author:bob@example.com":     {"B"},
+		"reviewer:carol@example.com": {"A"},
+		"status:accepted":            {"A"},
+		"status:pending parser":      {"B"},
+		"label:backend":              {"A"},
+		"path:parser":                {"A", "B"},
+		"path:*.md":                  {"B"},
+		"path:parser/*_test.go test": {"A"},
+	}
+	for query, revisions := range expected {
+		if actual := search(t, index, query); !reflect.DeepEqual(actual, revisions) {
+			t.Errorf("Unexpected results for %q: %v", query, actual)
+		}
+	}
+	if _, err := ParseQuery("author:"); err == nil {
+		t.Fatal("Failed to reject a field term without a value")
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	index := Build("state", testReviews())
+	search(t, index, "path:parser")
+	file := filepath.Join(dir, "appraise", "search.gob")
+	if err := index.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.StateHash != "state" || !reflect.DeepEqual(loaded.Documents, index.Documents) {
+		t.Fatalf("Unexpected index loaded: %+v", loaded)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"html/template"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const attachmentsDir = "attachments"
+
+// staticPageName returns the name of the file holding the page for the given review.
+func staticPageName(revision string) string {
+	return revision + ".html"
+}
+
+// staticAttachmentPath returns the path, relative to the root of the site, of the given attachment.
+//
+// Static file servers pick the content type of a file from its name, so any
+// attachment that would not be shown inline by the dashboard gets a ".bin"
+// suffix, which makes it a download.
+func staticAttachmentPath(hash, name string) string {
+	name = path.Base(name)
+	if name == "." || name == "/" || name == ".." {
+		name = "attachment"
+	}
+	if !isInlineContentType(mime.TypeByExtension(path.Ext(name))) {
+		name += ".bin"
+	}
+	return path.Join(attachmentsDir, hash, name)
+}
+
+// staticLinks defines the template functions that link the pages of an exported site.
+//
+// Every page is written to the root of the site, so the links are all relative to it.
+var staticLinks = template.FuncMap{
+	"indexURL": func() string {
+		return "index.html"
+	},
+	"reviewURL":     staticPageName,
+	"attachmentURL": staticAttachmentPath,
+}
+
+// writePage renders the named template with the given data into the given file.
+func writePage(templates *template.Template, filename, name string, data interface{}) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := templates.ExecuteTemplate(f, name, data); err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to render %q: %v", filename, err)
+	}
+	return f.Close()
+}
+
+// exportAttachments writes the files attached to the given comment threads into the site in the given directory.
+func exportAttachments(repo repository.Repo, dir string, threads []review.CommentThread) error {
+	for _, thread := range threads {
+		for _, attachment := range thread.Comment.Attachments {
+			contents, err := repo.ReadBlob(attachment.Hash)
+			if err != nil {
+				return err
+			}
+			filename := filepath.Join(dir, filepath.FromSlash(staticAttachmentPath(attachment.Hash, attachment.Name)))
+			if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(filename, contents, 0644); err != nil {
+				return err
+			}
+		}
+		if err := exportAttachments(repo, dir, thread.Children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportBinaryImages writes the old and new versions of the images changed by a review into the site in the given directory.
+func exportBinaryImages(repo repository.Repo, dir string, binaries []review.BinaryDiff) error {
+	for _, binary := range binaries {
+		if !strings.HasPrefix(binary.ContentType, "image/") || !isInlineContentType(binary.ContentType) {
+			continue
+		}
+		for _, hash := range []string{binary.OldBlob, binary.NewBlob} {
+			if hash == "" {
+				continue
+			}
+			contents, err := repo.ReadBlob(hash)
+			if err != nil {
+				return err
+			}
+			filename := filepath.Join(dir, filepath.FromSlash(staticAttachmentPath(hash, binary.Path)))
+			if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(filename, contents, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Export renders every review in the repo into the given directory, as a static website.
+//
+// The site consists of an "index.html" page listing all of the reviews, a page
+// for each review named after its revision, and the files attached to comments
+// along with the images changed by each review.
+// Every link within the site is relative, so it can be published under any URL.
+// The diff of each review is shown side by side if sideBySide is true.
+func Export(repo repository.Repo, dir string, sideBySide bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	templates := newTemplates(staticLinks)
+	reviews := review.ListAll(repo)
+	index := listPage{
+		Repo:    filepath.Base(repo.GetPath()),
+		All:     true,
+		Static:  true,
+		Reviews: reviews,
+	}
+	if err := writePage(templates, filepath.Join(dir, "index.html"), "list", index); err != nil {
+		return err
+	}
+	details, err := review.DetailsAll(repo, reviews)
+	if err != nil {
+		return err
+	}
+	for _, r := range details {
+		page := newReviewPage(repo, r, sideBySide)
+		page.Static = true
+		if err := writePage(templates, filepath.Join(dir, staticPageName(r.Revision)), "review", page); err != nil {
+			return err
+		}
+		if err := exportAttachments(repo, dir, r.Comments); err != nil {
+			return err
+		}
+		if err := exportBinaryImages(repo, dir, page.BinaryFiles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+// templates defines the HTML templates for all of the dashboard pages.
+const templates = `
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}} - git-appraise</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table.reviews td { padding: 0.2em 1em 0.2em 0; vertical-align: top; }
+.status { font-weight: bold; }
+.comment { border-left: 3px solid #ccc; margin: 0.5em 0; padding-left: 1em; }
+.meta { color: #666; font-size: small; }
+pre { background: #f6f8fa; padding: 0.5em; overflow-x: auto; }
+.diff .file { font-weight: bold; }
+.diff .hunk { color: #6f42c1; }
+.diff .added { background: #e6ffed; }
+.diff .removed { background: #ffeef0; }
+table.side-by-side { border-collapse: collapse; width: 100%; table-layout: fixed; background: #f6f8fa; }
+.side-by-side td { font-family: monospace; white-space: pre-wrap; vertical-align: top; padding: 0 0.5em; }
+.side-by-side td.line { width: 3em; color: #666; text-align: right; }
+.side-by-side td.threads { font-family: sans-serif; white-space: normal; }
+table.images td { vertical-align: top; padding-right: 1em; }
+table.images img { max-width: 40em; border: 1px solid #ccc; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "list"}}{{template "header" "Reviews"}}
+<h1>{{if .All}}All{{else}}Open{{end}} reviews in {{.Repo}}</h1>
+{{if not .Static}}<p>{{if .All}}<a href="/">Show only open reviews</a>{{else}}<a href="/?all=1">Show all reviews</a>{{end}}</p>{{end}}
+{{if .Reviews}}<table class="reviews">
+{{range .Reviews}}<tr>
+<td class="status">{{status .}}</td>
+<td><a href="{{reviewURL .Revision}}">{{short .Revision}}</a></td>
+<td>{{index (lines .Request.Description) 0}}</td>
+<td class="meta">{{.Request.Requester}} &rarr; {{.Request.TargetRef}}</td>
+</tr>
+{{end}}</table>
+{{else}}<p>There are no reviews.</p>
+{{end}}{{template "footer"}}{{end}}
+
+{{define "thread"}}<div class="comment">
+<div class="meta">{{.Comment.Author}} at {{timestamp .Comment.Timestamp}}
+{{- with .Comment.Location}}{{if .Path}} on {{.Path}}{{with .Range}}:{{.StartLine}}{{end}}{{end}}{{end}}
+{{- if .IsOpen}} &mdash; <b>open</b>{{else if .Resolved}} &mdash; resolved{{end}}
+{{- if .IsEdited}} &mdash; edited{{end}}</div>
+{{if .Redacted}}<pre>[redacted by {{.Redacted.Author}}]</pre>{{else}}{{with .Latest}}{{if .Deleted}}<pre>[deleted]</pre>{{else if .Encrypted}}<pre>[encrypted]</pre>
+<div class="meta">private to:{{range .Encrypted.Recipients}} {{.}}{{end}}</div>{{else}}<pre>{{.Description}}</pre>
+{{if .Suggestion}}<div class="meta">suggested change:</div>
+<pre class="diff">{{range lines .Suggestion}}<span class="{{diffClass .}}">{{.}}</span>
+{{end}}</pre>{{end}}{{end}}{{end}}{{end}}
+{{range .Comment.Attachments}}<div class="meta">attachment: <a href="{{attachmentURL .Hash .Name}}">{{.Name}}</a> ({{.Size}} bytes)</div>
+{{end}}
+{{- range .Children}}{{template "thread" .}}{{end}}</div>
+{{end}}
+
+{{define "review"}}{{template "header" (short .Review.Revision)}}
+<p><a href="{{indexURL}}">&larr; All reviews</a></p>
+<h1>Review {{short .Review.Revision}} <span class="status">[{{status .Review.Summary}}]</span></h1>
+<table>
+<tr><td>From</td><td>{{.Review.Request.ReviewRef}}</td></tr>
+<tr><td>To</td><td>{{.Review.Request.TargetRef}}</td></tr>
+<tr><td>Requester</td><td>{{.Review.Request.Requester}}</td></tr>
+<tr><td>Reviewers</td><td>{{range $i, $r := .Review.Request.Reviewers}}{{if $i}}, {{end}}{{$r}}{{end}}</td></tr>
+{{if .Issues}}<tr><td>Issues</td><td>{{range $i, $issue := .Issues}}{{if $i}}, {{end}}{{if $issue.URL}}<a href="{{$issue.URL}}">{{$issue.ID}}</a>{{else}}{{$issue.ID}}{{end}}{{end}}</td></tr>{{end}}
+<tr><td>Build status</td><td><pre>{{.BuildStatus}}</pre></td></tr>
+</table>
+<pre>{{.Review.Request.Description}}</pre>
+<h2>Comments</h2>
+{{range .Review.Comments}}{{template "thread" .}}{{else}}<p>There are no comments.</p>{{end}}
+<h2>CI history</h2>
+{{range .CIHistory}}<h3>{{short .Commit}}</h3>
+<table class="reviews">
+{{range .Reports}}<tr>
+<td class="status">{{.Status}}</td>
+<td>{{.Agent}}</td>
+<td class="meta">{{timestamp .Timestamp}}</td>
+<td>{{if .URL}}<a href="{{.URL}}">details</a>{{end}}</td>
+</tr>
+{{end}}</table>
+{{else}}<p>There are no CI reports.</p>{{end}}
+<h2>Diff</h2>
+{{if not .Static}}<p>{{if .SideBySide}}<a href="{{reviewURL .Review.Revision}}">Show the unified diff</a>{{else}}<a href="{{reviewURL .Review.Revision}}?view=side-by-side">Show the diff side by side</a>{{end}}</p>{{end}}
+{{if .DiffError}}<p>Failed to compute the diff: {{.DiffError}}</p>
+{{else if .SideBySide}}<table class="diff side-by-side">
+{{range .DiffRows}}{{if .Header}}<tr><td colspan="4" class="{{diffClass .Header}}">{{.Header}}</td></tr>
+{{else}}<tr><td class="line">{{with .Old.Number}}{{.}}{{end}}</td><td{{if .Old.Changed}} class="removed"{{end}}>{{.Old.Text}}</td><td class="line">{{with .New.Number}}{{.}}{{end}}</td><td{{if .New.Changed}} class="added"{{end}}>{{.New.Text}}</td></tr>
+{{end}}{{if or .OldThreads .NewThreads}}<tr><td></td><td class="threads">{{range .OldThreads}}{{template "thread" .}}{{end}}</td><td></td><td class="threads">{{range .NewThreads}}{{template "thread" .}}{{end}}</td></tr>
+{{end}}{{end}}</table>
+{{else}}<pre class="diff">{{range lines .Diff}}<span class="{{diffClass .}}">{{.}}</span>
+{{end}}</pre>{{end}}
+{{with .BinaryFiles}}<h2>Binary files</h2>
+{{range .}}<h3>{{.Path}}</h3>
+<p class="meta">{{.ContentType}}: {{if .OldBlob}}{{.OldSize}} bytes{{if .OldWidth}}, {{.OldWidth}}x{{.OldHeight}}{{end}}{{else}}added{{end}} &rarr; {{if .NewBlob}}{{.NewSize}} bytes{{if .NewWidth}}, {{.NewWidth}}x{{.NewHeight}}{{end}}{{else}}removed{{end}}</p>
+{{if inlineImage .ContentType}}<table class="images"><tr><th>Before</th><th>After</th></tr>
+<tr><td>{{if .OldBlob}}<img src="{{attachmentURL .OldBlob .Path}}" alt="{{.Path}} before">{{end}}</td><td>{{if .NewBlob}}<img src="{{attachmentURL .NewBlob .Path}}" alt="{{.Path}} after">{{end}}</td></tr></table>
+{{end}}{{end}}{{end}}
+{{template "footer"}}{{end}}
+`
@@ -0,0 +1,345 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package web contains a read-only HTTP dashboard for browsing the code reviews in a repo,
+// and an exporter that renders the same pages as a static website.
+package web
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review"
+	"github.com/promet/git-appraise/review/ci"
+	"github.com/promet/git-appraise/review/issue"
+	"github.com/promet/git-appraise/review/schema"
+	"github.com/promet/git-appraise/review/sidebyside"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const reviewPathPrefix = "/review/"
+
+const attachmentPathPrefix = "/attachment/"
+
+// Server serves HTML pages describing the reviews stored in a single repo.
+type Server struct {
+	repo      repository.Repo
+	templates *template.Template
+	mux       *http.ServeMux
+}
+
+// listPage holds the data used to render the list of reviews.
+type listPage struct {
+	Repo    string
+	All     bool
+	Static  bool
+	Reviews []review.Summary
+}
+
+// commitReports holds the CI reports for a single commit of a review.
+type commitReports struct {
+	Commit  string
+	Reports []ci.Report
+}
+
+// diffRow is a row of a side-by-side diff, along with the comment threads anchored in each of its columns.
+type diffRow struct {
+	sidebyside.Row
+	OldThreads []review.CommentThread
+	NewThreads []review.CommentThread
+}
+
+// reviewPage holds the data used to render a single review.
+type reviewPage struct {
+	Review      *review.Review
+	BuildStatus string
+	Issues      []issue.Issue
+	CIHistory   []commitReports
+	Diff        string
+	DiffError   string
+	// SideBySide selects showing the diff in two columns, whose rows are in DiffRows.
+	SideBySide bool
+	DiffRows   []diffRow
+	// BinaryFiles are the changes to binary files, which are left out of the diff.
+	BinaryFiles []review.BinaryDiff
+	Static      bool
+}
+
+// serverLinks defines the template functions that link the pages served by the dashboard.
+var serverLinks = template.FuncMap{
+	"indexURL": func() string {
+		return "/"
+	},
+	"reviewURL": func(revision string) string {
+		return reviewPathPrefix + revision
+	},
+	"attachmentURL": func(hash, name string) string {
+		return attachmentPathPrefix + hash + "/" + name
+	},
+}
+
+// newTemplates parses the page templates, using the given functions to link the pages together.
+func newTemplates(links template.FuncMap) *template.Template {
+	return template.Must(template.New("web").Funcs(templateFuncs).Funcs(links).Parse(templates))
+}
+
+// New returns a new Server for the given repo.
+func New(repo repository.Repo) *Server {
+	s := &Server{
+		repo:      repo,
+		templates: newTemplates(serverLinks),
+		mux:       http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/", s.serveList)
+	s.mux.HandleFunc(reviewPathPrefix, s.serveReview)
+	s.mux.HandleFunc(attachmentPathPrefix, s.serveAttachment)
+	return s
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "The dashboard is read-only.", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mux.ServeHTTP(w, req)
+}
+
+func (s *Server) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Failed to render the %q page: %v", name, err)
+	}
+}
+
+// serveList renders the list of reviews, showing only the open, published ones unless "all" is requested.
+func (s *Server) serveList(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+	page := listPage{
+		Repo: s.repo.GetPath(),
+		All:  req.URL.Query().Get("all") != "",
+	}
+	if page.All {
+		page.Reviews = review.ListAll(s.repo)
+	} else {
+		page.Reviews = review.FilterDrafts(review.ListOpen(s.repo), "")
+	}
+	s.render(w, "list", page)
+}
+
+// serveReview renders the details, comments, and diff of a single review.
+func (s *Server) serveReview(w http.ResponseWriter, req *http.Request) {
+	revision := strings.TrimPrefix(req.URL.Path, reviewPathPrefix)
+	if revision == "" {
+		http.NotFound(w, req)
+		return
+	}
+	r, err := review.Get(s.repo, revision)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r == nil {
+		http.NotFound(w, req)
+		return
+	}
+	s.render(w, "review", newReviewPage(s.repo, r, req.URL.Query().Get("view") == "side-by-side"))
+}
+
+// newReviewPage collects the data used to render the given review, with its diff either unified or side by side.
+func newReviewPage(repo repository.Repo, r *review.Review, sideBySide bool) reviewPage {
+	page := reviewPage{
+		Review:      r,
+		BuildStatus: r.GetBuildStatusMessage(),
+		CIHistory:   getCIHistory(repo, r),
+		SideBySide:  sideBySide,
+	}
+	// A malformed tracker configuration only means that the issues are shown without links.
+	trackers, _ := issue.LoadTrackers(repo)
+	page.Issues = issue.Expand(trackers, r.Request.Issues)
+	if diff, err := r.GetDiff(); err != nil {
+		page.DiffError = err.Error()
+	} else {
+		page.Diff = diff
+	}
+	if sideBySide && page.DiffError == "" {
+		page.DiffRows = sideBySideRows(r, page.Diff)
+	}
+	// The binary files are only described in addition to the diff, so failing to list them is not an error.
+	page.BinaryFiles, _ = r.GetBinaryDiffs()
+	return page
+}
+
+// sideBySideRows lays out the given diff of the review in two columns, with
+// each comment thread anchored in the column of the line that it comments on.
+//
+// If the comments cannot be remapped to the head of the review, then the
+// rows are returned without them, as the threads are also listed on their own.
+func sideBySideRows(r *review.Review, diff string) []diffRow {
+	var anchored map[sidebyside.Anchor][]review.CommentThread
+	if threads, err := r.RemapComments(); err == nil {
+		base, _ := r.GetBaseCommit()
+		anchored, _ = sidebyside.AnchorThreads(threads, base)
+	}
+	var rows []diffRow
+	for _, row := range sidebyside.Split(diff) {
+		result := diffRow{Row: row}
+		for _, anchor := range row.Anchors() {
+			if anchor.Side == sidebyside.Old {
+				result.OldThreads = append(result.OldThreads, anchored[anchor]...)
+			} else {
+				result.NewThreads = append(result.NewThreads, anchored[anchor]...)
+			}
+			delete(anchored, anchor)
+		}
+		rows = append(rows, result)
+	}
+	return rows
+}
+
+// getCIHistory returns the CI reports for every patchset of the given review, and for its current head.
+//
+// The commits are listed newest first, and commits without any reports are left out.
+func getCIHistory(repo repository.Repo, r *review.Review) []commitReports {
+	var commits []string
+	for _, patchset := range r.Request.Patchsets {
+		commits = append(commits, patchset.Commit)
+	}
+	if head, err := r.GetHeadCommit(); err == nil {
+		commits = append(commits, head)
+	}
+	seen := make(map[string]bool)
+	var history []commitReports
+	for i := len(commits) - 1; i >= 0; i-- {
+		commit := commits[i]
+		if seen[commit] {
+			continue
+		}
+		seen[commit] = true
+		if reports := ci.ParseAllValid(repo.GetNotes(ci.Ref, commit)); len(reports) > 0 {
+			history = append(history, commitReports{Commit: commit, Reports: reports})
+		}
+	}
+	return history
+}
+
+// serveAttachment serves the contents of a file attached to a comment.
+//
+// Attachments are served from paths of the form "/attachment/<hash>/<name>", with
+// the content type derived from the name. To avoid serving active content from
+// the dashboard, anything other than an image or plain text is served as a download.
+func (s *Server) serveAttachment(w http.ResponseWriter, req *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, attachmentPathPrefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, req)
+		return
+	}
+	contents, err := s.repo.ReadBlob(parts[0])
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(parts[1]))
+	if contentType == "" {
+		contentType = http.DetectContentType(contents)
+	}
+	if !isInlineContentType(contentType) {
+		contentType = "application/octet-stream"
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(contents)
+}
+
+// isInlineContentType reports whether content of the given type is safe to show inline.
+//
+// Only images and plain text are, with the exception of SVG images since those may contain scripts.
+func isInlineContentType(contentType string) bool {
+	if strings.HasPrefix(contentType, "image/svg") {
+		return false
+	}
+	return strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "text/plain")
+}
+
+// getStatus returns a short description of the state of a review.
+func getStatus(r *review.Summary) string {
+	if r.Submitted {
+		return "submitted"
+	}
+	if r.IsAbandoned() {
+		return "abandoned"
+	}
+	if r.IsDraft() {
+		return "draft"
+	}
+	if r.Resolved == nil {
+		return "pending"
+	}
+	if *r.Resolved {
+		return "accepted"
+	}
+	return "rejected"
+}
+
+// formatTimestamp converts a timestamp from a git note into a human-readable string.
+func formatTimestamp(timestamp string) string {
+	t, err := schema.ParseTimestamp(timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.UTC().Format(time.RFC1123)
+}
+
+// diffLineClass returns the CSS class used to render the given line of a diff.
+func diffLineClass(line string) string {
+	switch {
+	case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"), strings.HasPrefix(line, "diff "):
+		return "file"
+	case strings.HasPrefix(line, "@@"):
+		return "hunk"
+	case strings.HasPrefix(line, "+"):
+		return "added"
+	case strings.HasPrefix(line, "-"):
+		return "removed"
+	}
+	return ""
+}
+
+var templateFuncs = template.FuncMap{
+	"status":    getStatus,
+	"timestamp": formatTimestamp,
+	"lines": func(text string) []string {
+		return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	},
+	"diffClass": diffLineClass,
+	"inlineImage": func(contentType string) bool {
+		return strings.HasPrefix(contentType, "image/") && isInlineContentType(contentType)
+	},
+	"short": func(hash string) string {
+		if len(hash) > 12 {
+			return hash[:12]
+		}
+		return hash
+	},
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"github.com/promet/git-appraise/repository"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func get(t *testing.T, s *Server, path string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestServeList(t *testing.T) {
+	s := New(repository.NewMockRepoForTest())
+	w := get(t, s, "/")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "/review/"+repository.TestCommitG) {
+		t.Fatalf("The open review was not listed: %s", w.Body.String())
+	}
+	if w := get(t, s, "/missing"); w.Code != http.StatusNotFound {
+		t.Fatalf("Unexpected status code for a missing page: %d", w.Code)
+	}
+}
+
+func TestServeReview(t *testing.T) {
+	s := New(repository.NewMockRepoForTest())
+	w := get(t, s, "/review/"+repository.TestCommitG)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "Final description of G") {
+		t.Fatalf("The review description was not rendered: %s", w.Body.String())
+	}
+}
+
+func TestServeReviewSideBySide(t *testing.T) {
+	s := New(repository.NewMockRepoForTest())
+	w := get(t, s, "/review/"+repository.TestCommitG+"?view=side-by-side")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "Show the unified diff") {
+		t.Fatalf("The side-by-side view was not rendered: %s", w.Body.String())
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s := New(repository.NewMockRepoForTest())
+	req, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Unexpected status code for a write: %d", w.Code)
+	}
+}
+
+func TestServeAttachment(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	s := New(repo)
+	image, err := repo.StoreBlob("refs/notes/test", []byte("\x89PNG\r\n\x1a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := get(t, s, "/attachment/"+image+"/screenshot.png")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Unexpected response for an image: %d, %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	page, err := repo.StoreBlob("refs/notes/test", []byte("<script>alert(1)</script>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = get(t, s, "/attachment/"+page+"/page.html")
+	if w.Header().Get("Content-Type") != "application/octet-stream" || w.Header().Get("Content-Disposition") != "attachment" {
+		t.Fatalf("Active content was served inline: %q", w.Header().Get("Content-Type"))
+	}
+	if w := get(t, s, "/attachment/missing/file.txt"); w.Code != http.StatusNotFound {
+		t.Fatalf("Unexpected status code for a missing attachment: %d", w.Code)
+	}
+}
+
+func TestStaticAttachmentPath(t *testing.T) {
+	if p := staticAttachmentPath("abc", "screenshot.png"); p != "attachments/abc/screenshot.png" {
+		t.Errorf("Unexpected path for an image: %q", p)
+	}
+	if p := staticAttachmentPath("abc", "../page.html"); p != "attachments/abc/page.html.bin" {
+		t.Errorf("Unexpected path for active content: %q", p)
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := Export(repository.NewMockRepoForTest(), dir, false); err != nil {
+		t.Fatal(err)
+	}
+	index, err := ioutil.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, revision := range []string{repository.TestCommitB, repository.TestCommitD, repository.TestCommitG} {
+		if !strings.Contains(string(index), `href="`+revision+`.html"`) {
+			t.Errorf("The review %q was not linked from the index: %s", revision, index)
+		}
+	}
+	page, err := ioutil.ReadFile(filepath.Join(dir, repository.TestCommitG+".html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), "Final description of G") || !strings.Contains(string(page), `href="index.html"`) {
+		t.Errorf("The review page was not rendered: %s", page)
+	}
+}
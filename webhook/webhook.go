@@ -0,0 +1,333 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook receives build notifications from CI systems, and records them as CI reports.
+//
+// Each supported CI system posts its notifications to its own endpoint:
+//
+//	POST /webhooks/jenkins     payloads from the Jenkins Notification plugin
+//	POST /webhooks/buildkite   payloads from Buildkite build webhooks
+//	POST /webhooks/github      workflow_run events from GitHub Actions
+//
+// Notifications about commits that are not in the repo, and events that do
+// not describe the state of a build, are acknowledged but otherwise ignored.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/ci"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// PathPrefix is the path under which all of the webhook endpoints are served.
+const PathPrefix = "/webhooks/"
+
+// maxPayloadSize is the largest request body that is accepted, in bytes.
+const maxPayloadSize = 10 * 1024 * 1024
+
+// errIgnored is returned by a converter for a payload that does not describe the state of a build.
+var errIgnored = errors.New("The event does not describe the state of a build.")
+
+// converter translates the payload of a webhook into the commit that was
+// built, and a report of the state of that build.
+type converter func(req *http.Request, payload []byte) (string, ci.Report, error)
+
+// Server receives CI webhooks for a single repo.
+type Server struct {
+	repo repository.Repo
+	// remote is the remote repo that new reports are pushed to, or empty to not push them.
+	remote string
+	// secret is the shared secret that requests must be authenticated with, or empty to accept any request.
+	secret string
+	// writeMutex serializes all of the requests that write to the repo's notes.
+	writeMutex sync.Mutex
+}
+
+// Response is the body of the response to a webhook.
+type Response struct {
+	Commit string     `json:"commit,omitempty"`
+	Report *ci.Report `json:"report,omitempty"`
+	// Ignored explains why the webhook did not result in a report.
+	Ignored string `json:"ignored,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// New returns a new Server for the given repo.
+//
+// If remote is not empty, then every report that is recorded is also pushed
+// to it. If secret is not empty, then requests must be authenticated with it,
+// in the way that is native to each CI system.
+func New(repo repository.Repo, remote, secret string) *Server {
+	return &Server{repo: repo, remote: remote, secret: secret}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body Response) {
+	bytes, err := json.Marshal(body)
+	if err != nil {
+		status = http.StatusInternalServerError
+		bytes, _ = json.Marshal(Response{Error: err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+	w.Write([]byte("\n"))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, Response{Error: err.Error()})
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var convert converter
+	var authenticate func(req *http.Request, payload []byte) bool
+	switch strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, PathPrefix), "/") {
+	case "jenkins":
+		convert, authenticate = convertJenkins, s.checkToken
+	case "buildkite":
+		convert, authenticate = convertBuildkite, s.checkBuildkiteToken
+	case "github":
+		convert, authenticate = convertGitHub, s.checkGitHubSignature
+	default:
+		writeError(w, http.StatusNotFound, errors.New("Unknown webhook endpoint."))
+		return
+	}
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Only POST is supported for webhooks."))
+		return
+	}
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxPayloadSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.secret != "" && !authenticate(req, payload) {
+		writeError(w, http.StatusUnauthorized, errors.New("The webhook could not be authenticated."))
+		return
+	}
+	commit, report, err := convert(req, payload)
+	if err == errIgnored {
+		writeJSON(w, http.StatusOK, Response{Ignored: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := report.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Only accept the full hash of a commit, so that a payload cannot attach its report to whatever a ref or an abbreviated hash happens to name.
+	if hash, err := s.repo.GetCommitHash(commit); err != nil || hash != commit {
+		writeJSON(w, http.StatusOK, Response{Commit: commit, Ignored: fmt.Sprintf("The commit %q is not the full hash of a commit in the repo.", commit)})
+		return
+	}
+	if err := s.record(commit, report); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{Commit: commit, Report: &report})
+}
+
+// record writes the report as a note on the given commit, and then pushes the CI notes to the remote.
+func (s *Server) record(commit string, report ci.Report) error {
+	note, err := report.Write()
+	if err != nil {
+		return err
+	}
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if err := s.repo.AppendNote(ci.Ref, commit, note); err != nil {
+		return err
+	}
+	if s.remote == "" {
+		return nil
+	}
+	return s.repo.PushNotes(s.remote, ci.Ref)
+}
+
+// checkToken authenticates a request that passes the secret in the "X-Appraise-Token" header, or the "token" query parameter.
+func (s *Server) checkToken(req *http.Request, payload []byte) bool {
+	token := req.Header.Get("X-Appraise-Token")
+	if token == "" {
+		token = req.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.secret)) == 1
+}
+
+// checkBuildkiteToken authenticates a request from Buildkite, which passes the secret in the "X-Buildkite-Token" header.
+func (s *Server) checkBuildkiteToken(req *http.Request, payload []byte) bool {
+	token := req.Header.Get("X-Buildkite-Token")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.secret)) == 1
+}
+
+// checkGitHubSignature authenticates a request from GitHub, which signs the payload with an HMAC of the secret.
+func (s *Server) checkGitHubSignature(req *http.Request, payload []byte) bool {
+	signature := strings.TrimPrefix(req.Header.Get("X-Hub-Signature-256"), "sha256=")
+	actual, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.secret))
+	mac.Write(payload)
+	return hmac.Equal(actual, mac.Sum(nil))
+}
+
+// jenkinsPayload is the subset of a Jenkins Notification plugin payload that we use.
+type jenkinsPayload struct {
+	Name  string `json:"name"`
+	Build struct {
+		FullURL string `json:"full_url"`
+		Phase   string `json:"phase"`
+		Status  string `json:"status"`
+		SCM     struct {
+			Commit string `json:"commit"`
+		} `json:"scm"`
+	} `json:"build"`
+}
+
+// convertJenkins converts a payload from the Jenkins Notification plugin.
+func convertJenkins(req *http.Request, payload []byte) (string, ci.Report, error) {
+	var p jenkinsPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", ci.Report{}, fmt.Errorf("Malformed Jenkins payload: %v", err)
+	}
+	var status string
+	switch p.Build.Phase {
+	case "QUEUED":
+		status = ci.StatusPending
+	case "STARTED":
+		status = ci.StatusRunning
+	case "COMPLETED":
+		if p.Build.Status == "SUCCESS" {
+			status = ci.StatusSuccess
+		} else {
+			status = ci.StatusFailure
+		}
+	default:
+		// The FINALIZED phase repeats the COMPLETED one.
+		return "", ci.Report{}, errIgnored
+	}
+	if p.Build.SCM.Commit == "" {
+		return "", ci.Report{}, errors.New("The Jenkins payload does not name the commit that was built.")
+	}
+	return p.Build.SCM.Commit, ci.New(agentName("jenkins", p.Name), status, p.Build.FullURL), nil
+}
+
+// buildkitePayload is the subset of a Buildkite webhook payload that we use.
+type buildkitePayload struct {
+	Event string `json:"event"`
+	Build struct {
+		WebURL string `json:"web_url"`
+		Commit string `json:"commit"`
+		State  string `json:"state"`
+	} `json:"build"`
+	Pipeline struct {
+		Slug string `json:"slug"`
+	} `json:"pipeline"`
+}
+
+// convertBuildkite converts a payload from a Buildkite build webhook.
+func convertBuildkite(req *http.Request, payload []byte) (string, ci.Report, error) {
+	var p buildkitePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", ci.Report{}, fmt.Errorf("Malformed Buildkite payload: %v", err)
+	}
+	if !strings.HasPrefix(p.Event, "build.") {
+		return "", ci.Report{}, errIgnored
+	}
+	var status string
+	switch p.Build.State {
+	case "scheduled", "blocked":
+		status = ci.StatusPending
+	case "running":
+		status = ci.StatusRunning
+	case "passed":
+		status = ci.StatusSuccess
+	case "failed", "canceled":
+		status = ci.StatusFailure
+	default:
+		return "", ci.Report{}, errIgnored
+	}
+	if p.Build.Commit == "" {
+		return "", ci.Report{}, errors.New("The Buildkite payload does not name the commit that was built.")
+	}
+	return p.Build.Commit, ci.New(agentName("buildkite", p.Pipeline.Slug), status, p.Build.WebURL), nil
+}
+
+// gitHubPayload is the subset of a GitHub workflow_run event payload that we use.
+type gitHubPayload struct {
+	WorkflowRun struct {
+		Name       string `json:"name"`
+		HeadSHA    string `json:"head_sha"`
+		HTMLURL    string `json:"html_url"`
+		Status     string `json:"status"`
+		Conclusion string `json:"conclusion"`
+	} `json:"workflow_run"`
+}
+
+// convertGitHub converts a workflow_run event from GitHub Actions.
+func convertGitHub(req *http.Request, payload []byte) (string, ci.Report, error) {
+	if req.Header.Get("X-GitHub-Event") != "workflow_run" {
+		return "", ci.Report{}, errIgnored
+	}
+	var p gitHubPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", ci.Report{}, fmt.Errorf("Malformed GitHub payload: %v", err)
+	}
+	run := p.WorkflowRun
+	var status string
+	switch run.Status {
+	case "queued", "requested", "waiting", "pending":
+		status = ci.StatusPending
+	case "in_progress":
+		status = ci.StatusRunning
+	case "completed":
+		switch run.Conclusion {
+		case "success":
+			status = ci.StatusSuccess
+		case "skipped", "neutral":
+			return "", ci.Report{}, errIgnored
+		default:
+			status = ci.StatusFailure
+		}
+	default:
+		return "", ci.Report{}, errIgnored
+	}
+	if run.HeadSHA == "" {
+		return "", ci.Report{}, errors.New("The GitHub payload does not name the commit that was built.")
+	}
+	return run.HeadSHA, ci.New(agentName("github-actions", run.Name), status, run.HTMLURL), nil
+}
+
+// agentName returns the name of the CI agent for a job (or pipeline, or workflow) in the given CI system.
+func agentName(system, job string) string {
+	if job == "" {
+		return system
+	}
+	return system + "/" + job
+}
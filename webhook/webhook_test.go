@@ -0,0 +1,126 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/promet/git-appraise/repository"
+	"github.com/promet/git-appraise/review/ci"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(t *testing.T, s *Server, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func latestReport(t *testing.T, repo repository.Repo, commit string) ci.Report {
+	reports := ci.ParseAllValid(repo.GetNotes(ci.Ref, commit))
+	latest, err := ci.GetLatestCIReport(reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest == nil {
+		t.Fatalf("No CI reports were recorded for %q", commit)
+	}
+	return *latest
+}
+
+func TestJenkins(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	s := New(repo, "", "")
+	body := `{"name": "unit", "build": {"full_url": "http://jenkins/job/unit/1/", "phase": "COMPLETED", "status": "FAILURE", "scm": {"commit": "` + repository.TestCommitB + `"}}}`
+	if w := serve(t, s, "/webhooks/jenkins", body, nil); w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body.String())
+	}
+	report := latestReport(t, repo, repository.TestCommitB)
+	if report.Agent != "jenkins/unit" || report.Status != ci.StatusFailure || report.URL != "http://jenkins/job/unit/1/" {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	finalized := strings.Replace(body, "COMPLETED", "FINALIZED", 1)
+	if w := serve(t, s, "/webhooks/jenkins", finalized, nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "ignored") {
+		t.Errorf("Unexpected response to an ignored event %d: %s", w.Code, w.Body.String())
+	}
+	unknown := strings.Replace(body, repository.TestCommitB, "0123456789abcdef", 1)
+	if w := serve(t, s, "/webhooks/jenkins", unknown, nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "ignored") {
+		t.Errorf("Unexpected response for an unknown commit %d: %s", w.Code, w.Body.String())
+	}
+	ref := strings.Replace(body, repository.TestCommitB, repository.TestTargetRef, 1)
+	if w := serve(t, s, "/webhooks/jenkins", ref, nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "ignored") {
+		t.Errorf("Unexpected response for a ref rather than a commit hash %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(t, s, "/webhooks/jenkins", "not json", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status code for a malformed payload: %d", w.Code)
+	}
+}
+
+func TestBuildkite(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	s := New(repo, "", "secret")
+	body := `{"event": "build.running", "build": {"web_url": "http://buildkite/1", "commit": "` + repository.TestCommitC + `", "state": "running"}, "pipeline": {"slug": "app"}}`
+	if w := serve(t, s, "/webhooks/buildkite", body, map[string]string{"X-Buildkite-Token": "wrong"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Unexpected status code for an unauthenticated request: %d", w.Code)
+	}
+	if w := serve(t, s, "/webhooks/buildkite", body, map[string]string{"X-Buildkite-Token": "secret"}); w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body.String())
+	}
+	report := latestReport(t, repo, repository.TestCommitC)
+	if report.Agent != "buildkite/app" || report.Status != ci.StatusRunning {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+}
+
+func TestGitHub(t *testing.T) {
+	repo := repository.NewMockRepoForTest()
+	s := New(repo, "", "secret")
+	body := `{"action": "completed", "workflow_run": {"name": "CI", "head_sha": "` + repository.TestCommitD + `", "html_url": "http://github/run/1", "status": "completed", "conclusion": "success"}}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	headers := map[string]string{
+		"X-GitHub-Event":      "workflow_run",
+		"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(mac.Sum(nil)),
+	}
+	if w := serve(t, s, "/webhooks/github", body, headers); w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body.String())
+	}
+	report := latestReport(t, repo, repository.TestCommitD)
+	if report.Agent != "github-actions/CI" || report.Status != ci.StatusSuccess {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	headers["X-Hub-Signature-256"] = "sha256=00"
+	if w := serve(t, s, "/webhooks/github", body, headers); w.Code != http.StatusUnauthorized {
+		t.Errorf("Unexpected status code for a bad signature: %d", w.Code)
+	}
+	if w := serve(t, s, "/webhooks/unknown", body, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status code for an unknown endpoint: %d", w.Code)
+	}
+}